@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/openendpoint/openendpoint/internal/config"
+	"github.com/openendpoint/openendpoint/internal/encryption"
 	"github.com/openendpoint/openendpoint/internal/engine"
 	"github.com/openendpoint/openendpoint/internal/metadata/pebble"
 	"github.com/openendpoint/openendpoint/internal/storage/flatfile"
@@ -490,7 +491,28 @@ func getEngine() (*engine.ObjectService, error) {
 		return nil, err
 	}
 
+	masterKey, err := encryption.LoadMasterKey(cfg.Encryption.MasterKey, cfg.Encryption.MasterKeyFile)
+	if err != nil {
+		metadata.Close()
+		storage.Close()
+		return nil, err
+	}
+
+	kms := cfg.Encryption.KMS
+	keyProvider, err := encryption.OpenKeyProvider(kms.Provider, kms.KeyringFile, kms.Endpoint, kms.Token, masterKey)
+	if err != nil {
+		metadata.Close()
+		storage.Close()
+		return nil, err
+	}
+
 	eng := engine.New(storage, metadata, nil)
+	if masterKey != nil {
+		eng.SetMasterKey(masterKey)
+	}
+	if keyProvider != nil {
+		eng.SetKeyProvider(keyProvider)
+	}
 	return eng, nil
 }
 
@@ -647,7 +669,8 @@ func runObjectDelete(bucket, key string) error {
 	}
 	defer eng.Close()
 
-	return eng.DeleteObject(context.Background(), bucket, key, engine.DeleteObjectOptions{})
+	_, err = eng.DeleteObject(context.Background(), bucket, key, engine.DeleteObjectOptions{})
+	return err
 }
 
 func runObjectCopy(srcBucket, srcKey, dstBucket, dstKey string) error {
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
@@ -14,12 +15,16 @@ import (
 	"github.com/openendpoint/openendpoint/internal/cluster"
 	"github.com/openendpoint/openendpoint/internal/config"
 	"github.com/openendpoint/openendpoint/internal/dashboard"
+	"github.com/openendpoint/openendpoint/internal/encryption"
 	"github.com/openendpoint/openendpoint/internal/engine"
+	"github.com/openendpoint/openendpoint/internal/iam"
 	"github.com/openendpoint/openendpoint/internal/lifecycle"
 	"github.com/openendpoint/openendpoint/internal/metadata/pebble"
 	"github.com/openendpoint/openendpoint/internal/mgmt"
 	"github.com/openendpoint/openendpoint/internal/middleware"
+	"github.com/openendpoint/openendpoint/internal/storage"
 	"github.com/openendpoint/openendpoint/internal/storage/flatfile"
+	"github.com/openendpoint/openendpoint/internal/storage/packed"
 	"github.com/openendpoint/openendpoint/internal/telemetry"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -61,6 +66,7 @@ func main() {
 
 	rootCmd.AddCommand(serverCmd())
 	rootCmd.AddCommand(versionCmd())
+	rootCmd.AddCommand(adminCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -94,6 +100,116 @@ func versionCmd() *cobra.Command {
 	}
 }
 
+func adminCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "admin",
+		Short: "Offline maintenance commands",
+	}
+	cmd.AddCommand(fsckCmd())
+	return cmd
+}
+
+func fsckCmd() *cobra.Command {
+	var cfgPath string
+	var repair bool
+
+	cmd := &cobra.Command{
+		Use:   "fsck",
+		Short: "Reconcile object data with metadata",
+		Long: `Checks that every object version in the metadata store has its data in
+the storage backend and that every object in the backend belongs to a
+version. The server must be stopped while it runs.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runFsck(cfgPath, repair, cmd.OutOrStdout())
+		},
+	}
+
+	cmd.Flags().StringVarP(&cfgPath, "config", "c", "", "Path to config file")
+	cmd.Flags().BoolVar(&repair, "repair", false, "Delete orphaned data and dangling metadata")
+
+	return cmd
+}
+
+// runFsck checks the configured backend and metadata store, printing every
+// problem found. It fails if any problem is left unrepaired.
+func runFsck(cfgPath string, repair bool, out io.Writer) error {
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	logger, err := telemetry.NewLogger("warn")
+	if err != nil {
+		return fmt.Errorf("failed to initialize logger: %w", err)
+	}
+	defer logger.Sync()
+
+	backend, err := newStorageBackend(cfg.Storage)
+	if err != nil {
+		return fmt.Errorf("failed to initialize storage: %w", err)
+	}
+	defer backend.Close()
+
+	metadata, err := pebble.New(cfg.Storage.DataDir)
+	if err != nil {
+		return fmt.Errorf("failed to initialize metadata: %w", err)
+	}
+	defer metadata.Close()
+
+	report, err := engine.New(backend, metadata, logger).Fsck(context.Background(), engine.FsckOptions{Repair: repair})
+	if err != nil {
+		return fmt.Errorf("fsck failed: %w", err)
+	}
+
+	fmt.Fprintf(out, "checked %d buckets, %d versions, %d objects\n", report.Buckets, report.Versions, report.Objects)
+	for _, p := range report.Problems {
+		line := fmt.Sprintf("%-13s %s/%s", p.Kind, p.Bucket, p.Key)
+		if p.VersionID != "" {
+			line += " version " + p.VersionID
+		}
+		if p.Detail != "" {
+			line += ": " + p.Detail
+		}
+		if p.Repaired {
+			line += " (repaired)"
+		}
+		fmt.Fprintln(out, line)
+	}
+
+	if n := report.Unrepaired(); n > 0 {
+		return fmt.Errorf("%d problems left unrepaired", n)
+	}
+	return nil
+}
+
+// startupFsck runs the configured consistency check before the server
+// starts serving. Problems are logged; they do not stop the server.
+func startupFsck(objEngine *engine.ObjectService, repair bool, logger *zap.SugaredLogger) {
+	report, err := objEngine.Fsck(context.Background(), engine.FsckOptions{Repair: repair})
+	if err != nil {
+		logger.Errorw("startup fsck failed", "error", err)
+		return
+	}
+	for _, p := range report.Problems {
+		logger.Warnw("fsck problem", "kind", p.Kind, "bucket", p.Bucket, "key", p.Key,
+			"versionID", p.VersionID, "detail", p.Detail, "repaired", p.Repaired)
+	}
+	logger.Infow("startup fsck finished", "buckets", report.Buckets, "versions", report.Versions,
+		"objects", report.Objects, "problems", len(report.Problems), "unrepaired", report.Unrepaired())
+}
+
+// newStorageBackend opens the storage backend selected in the config
+func newStorageBackend(cfg config.StorageConfig) (storage.StorageBackend, error) {
+	switch cfg.StorageBackend {
+	case "", "flatfile":
+		return flatfile.New(cfg.DataDir)
+	case "packed":
+		return packed.New(cfg.DataDir, cfg.PackedVolumeSize)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.StorageBackend)
+	}
+}
+
 func runServer(cfgPath string) error {
 	// Load configuration
 	cfg, err := config.Load(cfgPath)
@@ -118,12 +234,13 @@ func runServer(cfgPath string) error {
 	)
 
 	// Initialize storage backend
-	storage, err := flatfile.New(cfg.Storage.DataDir)
+	backend, err := newStorageBackend(cfg.Storage)
 	if err != nil {
 		logger.Error("failed to initialize storage backend", zap.Error(err))
 		return fmt.Errorf("failed to initialize storage: %w", err)
 	}
-	defer storage.Close()
+	defer backend.Close()
+	logger.Info("storage backend initialized", zap.String("backend", cfg.Storage.StorageBackend))
 
 	// Initialize metadata store
 	metadata, err := pebble.New(cfg.Storage.DataDir)
@@ -134,7 +251,36 @@ func runServer(cfgPath string) error {
 	defer metadata.Close()
 
 	// Initialize object engine
-	objEngine := engine.New(storage, metadata, logger)
+	objEngine := engine.New(backend, metadata, logger)
+	objEngine.SetMaxObjectSize(cfg.Storage.MaxObjectSize)
+
+	// Load the master key sealing SSE-S3 data keys
+	masterKey, err := encryption.LoadMasterKey(cfg.Encryption.MasterKey, cfg.Encryption.MasterKeyFile)
+	if err != nil {
+		logger.Error("failed to load encryption master key", zap.Error(err))
+		return fmt.Errorf("failed to load master key: %w", err)
+	}
+	if masterKey != nil {
+		objEngine.SetMasterKey(masterKey)
+		logger.Info("server-side encryption enabled")
+	}
+
+	// Open the key provider of SSE-KMS
+	kms := cfg.Encryption.KMS
+	keyProvider, err := encryption.OpenKeyProvider(kms.Provider, kms.KeyringFile, kms.Endpoint, kms.Token, masterKey)
+	if err != nil {
+		logger.Error("failed to open kms provider", zap.Error(err))
+		return fmt.Errorf("failed to open kms provider: %w", err)
+	}
+	if keyProvider != nil {
+		objEngine.SetKeyProvider(keyProvider)
+		logger.Info("kms encryption enabled", zap.String("provider", kms.Provider))
+	}
+
+	// Reconcile data and metadata left inconsistent by a crash
+	if cfg.Storage.FsckOnStartup {
+		startupFsck(objEngine, cfg.Storage.FsckRepair, logger)
+	}
 
 	// Initialize storage metrics from existing data
 	if bytes, objects, err := objEngine.ComputeStorageMetrics(); err == nil {
@@ -145,8 +291,11 @@ func runServer(cfgPath string) error {
 			zap.Int64("objects", objects))
 	}
 
-	// Initialize auth service
+	// Initialize auth service. IAM users sign requests with their access
+	// keys alongside the configured credentials.
+	iamManager := iam.NewManager(zapLogger)
 	authService := auth.New(cfg.Auth)
+	authService.SetCredentialStore(iamManager)
 
 	// Initialize cluster (if enabled)
 	var clusterService *cluster.Cluster
@@ -172,14 +321,36 @@ func runServer(cfgPath string) error {
 	// Initialize lifecycle processor (if enabled)
 	var lifecycleProcessor *lifecycle.Processor
 	lifecycleProcessor = lifecycle.NewProcessor(objEngine, 1*time.Hour)
+	lifecycleProcessor.SetStaleUploadAge(time.Duration(cfg.Storage.MultipartMaxAge) * time.Hour)
 	go lifecycleProcessor.Start()
 	defer lifecycleProcessor.Stop()
 
 	// Initialize S3 API router with all dependencies
 	s3Router := api.NewRouter(objEngine, authService, logger, cfg)
+	s3Router.SetIAM(iamManager)
 
 	// Initialize management API router with cluster info
 	mgmtRouter := mgmt.NewRouter(objEngine, logger, cfg, clusterService, cfg.Storage.DataDir)
+	mgmtRouter.SetIAMManager(iamManager)
+	mgmtRouter.SetAuth(authService)
+
+	// Compact packed volumes in the background
+	if packedBackend, ok := backend.(*packed.Backend); ok {
+		compactor := packed.NewCompactor(packedBackend,
+			time.Duration(cfg.Storage.PackedCompactionInterval)*time.Minute,
+			cfg.Storage.PackedCompactionThreshold)
+		compactor.Start()
+		defer compactor.Stop()
+		mgmtRouter.SetCompactor(compactor)
+	}
+
+	// Re-wrap data keys under the latest versions of their KMS keys
+	if keyProvider != nil {
+		rewrapper := engine.NewRewrapper(objEngine, time.Duration(kms.RewrapInterval)*time.Minute)
+		rewrapper.Start()
+		defer rewrapper.Stop()
+		mgmtRouter.SetKMS(keyProvider, rewrapper)
+	}
 
 	// Create dashboard wrapper that adapts cluster.Cluster to dashboard interface
 	var dashboardCluster interface {
@@ -191,25 +362,22 @@ func runServer(cfgPath string) error {
 	}
 
 	// Setup HTTP server
-	mux := http.NewServeMux()
-
-	// S3 API endpoints
-	mux.Handle("/s3/", s3Router)
+	mux, mgmtMux := serverMuxes(cfg, s3Router)
 
 	// Management API endpoints
-	mux.Handle("/_mgmt/", mgmtRouter)
+	mgmtMux.Handle("/_mgmt/", mgmtRouter)
 
 	// Web Dashboard
-	mux.Handle("/_dashboard/", dashboard.Handler(dashboardCluster))
+	mgmtMux.Handle("/_dashboard/", dashboard.Handler(dashboardCluster))
 
 	// Health check
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+	mgmtMux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
 
 	// Readiness check
-	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+	mgmtMux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("READY"))
 	})
@@ -236,20 +404,12 @@ func runServer(cfgPath string) error {
 	reg.MustRegister(telemetry.BucketObjects)
 	reg.MustRegister(telemetry.BucketBytes)
 
-	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	mgmtMux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
 
 	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
 
-	// Apply CORS middleware for WebUI access
-	corsHandler := middleware.CORS([]string{"*"})(mux)
-
-	server := &http.Server{
-		Addr:         addr,
-		Handler:      telemetry.LoggingMiddleware(logger)(corsHandler),
-		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout) * time.Second,
-		WriteTimeout: time.Duration(cfg.Server.WriteTimeout) * time.Second,
-		IdleTimeout:  time.Duration(cfg.Server.IdleTimeout) * time.Second,
-	}
+	// Virtual-hosted-style requests reach the S3 API whatever their path
+	server := newHTTPServer(cfg, addr, s3Router.VirtualHosts(mux), logger)
 
 	// Start server in goroutine
 	go func() {
@@ -259,6 +419,19 @@ func runServer(cfgPath string) error {
 		}
 	}()
 
+	// Management endpoints on their own port
+	var mgmtServer *http.Server
+	if mgmtMux != mux {
+		mgmtAddr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.MgmtPort)
+		mgmtServer = newHTTPServer(cfg, mgmtAddr, mgmtMux, logger)
+		go func() {
+			logger.Info("management server listening", zap.String("address", mgmtAddr))
+			if err := mgmtServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("management server error", zap.Error(err))
+			}
+		}()
+	}
+
 	logger.Info("OpenEndpoint server started successfully",
 		zap.String("address", addr),
 		zap.String("version", version),
@@ -283,6 +456,11 @@ func runServer(cfgPath string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	if mgmtServer != nil {
+		if err := mgmtServer.Shutdown(ctx); err != nil {
+			logger.Error("management server forced to shutdown", zap.Error(err))
+		}
+	}
 	if err := server.Shutdown(ctx); err != nil {
 		logger.Error("server forced to shutdown", zap.Error(err))
 		return err
@@ -291,3 +469,35 @@ func runServer(cfgPath string) error {
 	logger.Info("server exited")
 	return nil
 }
+
+// serverMuxes returns the mux of the S3 listener, with the S3 API mounted
+// at /s3/ or at the root, and the mux for the management endpoints. The two
+// are the same unless a management port is configured.
+func serverMuxes(cfg *config.Config, s3 http.Handler) (mux, mgmtMux *http.ServeMux) {
+	mux = http.NewServeMux()
+	if cfg.Server.S3Root {
+		mux.Handle("/", s3)
+	} else {
+		mux.Handle("/s3/", s3)
+	}
+
+	mgmtMux = mux
+	if cfg.Server.MgmtPort != 0 {
+		mgmtMux = http.NewServeMux()
+	}
+	return mux, mgmtMux
+}
+
+// newHTTPServer creates a server for handler with CORS for WebUI access,
+// request logging and the configured timeouts
+func newHTTPServer(cfg *config.Config, addr string, handler http.Handler, logger *zap.SugaredLogger) *http.Server {
+	corsHandler := middleware.CORS([]string{"*"})(handler)
+
+	return &http.Server{
+		Addr:         addr,
+		Handler:      telemetry.LoggingMiddleware(logger)(corsHandler),
+		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout) * time.Second,
+		WriteTimeout: time.Duration(cfg.Server.WriteTimeout) * time.Second,
+		IdleTimeout:  time.Duration(cfg.Server.IdleTimeout) * time.Second,
+	}
+}
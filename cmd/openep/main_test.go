@@ -1,11 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/openendpoint/openendpoint/internal/config"
+	"github.com/openendpoint/openendpoint/internal/storage"
 	"github.com/spf13/cobra"
 )
 
@@ -596,3 +604,81 @@ func TestCommandSuggestions(t *testing.T) {
 	}
 }
 
+
+func TestNewStorageBackend(t *testing.T) {
+	for _, name := range []string{"flatfile", "packed"} {
+		backend, err := newStorageBackend(config.StorageConfig{DataDir: t.TempDir(), StorageBackend: name})
+		if err != nil {
+			t.Fatalf("newStorageBackend(%q) error: %v", name, err)
+		}
+		backend.Close()
+	}
+
+	if _, err := newStorageBackend(config.StorageConfig{DataDir: t.TempDir(), StorageBackend: "tape"}); err == nil {
+		t.Error("expected error for unknown storage backend")
+	}
+}
+
+func TestRunFsck(t *testing.T) {
+	dataDir := t.TempDir()
+	cfgPath := filepath.Join(t.TempDir(), "config.yaml")
+	cfgData := fmt.Sprintf("storage:\n  data_dir: %q\n  storage_backend: packed\n", dataDir)
+	if err := os.WriteFile(cfgPath, []byte(cfgData), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Data written without its metadata, as after a crash
+	backend, err := newStorageBackend(config.StorageConfig{DataDir: dataDir, StorageBackend: "packed", PackedVolumeSize: 1024 * 1024})
+	if err != nil {
+		t.Fatalf("newStorageBackend error: %v", err)
+	}
+	backend.Put(context.Background(), "bucket", "orphan", strings.NewReader("x"), 1, storage.PutOptions{})
+	backend.Close()
+
+	var out bytes.Buffer
+	if err := runFsck(cfgPath, false, &out); err == nil {
+		t.Error("runFsck should fail with an orphan left in place")
+	}
+	if !strings.Contains(out.String(), "orphan        bucket/orphan") {
+		t.Errorf("runFsck output = %q", out.String())
+	}
+
+	out.Reset()
+	if err := runFsck(cfgPath, true, &out); err != nil {
+		t.Errorf("runFsck(repair) error: %v", err)
+	}
+	if !strings.Contains(out.String(), "(repaired)") {
+		t.Errorf("runFsck(repair) output = %q", out.String())
+	}
+
+	if err := runFsck(cfgPath, false, io.Discard); err != nil {
+		t.Errorf("runFsck after repair error: %v", err)
+	}
+}
+
+func TestServerMuxes(t *testing.T) {
+	s3 := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("s3"))
+	})
+	get := func(mux *http.ServeMux, path string) string {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Body.String()
+	}
+
+	mux, mgmtMux := serverMuxes(&config.Config{}, s3)
+	if mux != mgmtMux {
+		t.Error("management endpoints not shared without a management port")
+	}
+	if get(mux, "/s3/bucket") != "s3" || get(mux, "/bucket") == "s3" {
+		t.Error("S3 API not mounted at /s3/ only")
+	}
+
+	mux, mgmtMux = serverMuxes(&config.Config{Server: config.ServerConfig{S3Root: true, MgmtPort: 9001}}, s3)
+	if mux == mgmtMux {
+		t.Error("management endpoints shared despite a management port")
+	}
+	if get(mux, "/bucket/key") != "s3" || get(mux, "/") != "s3" {
+		t.Error("S3 API not mounted at /")
+	}
+}
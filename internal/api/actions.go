@@ -0,0 +1,163 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/openendpoint/openendpoint/internal/auth"
+)
+
+// bucketSubresourceActions maps bucket sub-resource query parameters to the
+// S3 action names used for GET, PUT and DELETE on that sub-resource
+var bucketSubresourceActions = []struct {
+	param         string
+	get, put, del string
+}{
+	{"versioning", "s3:GetBucketVersioning", "s3:PutBucketVersioning", ""},
+	{"lifecycle", "s3:GetLifecycleConfiguration", "s3:PutLifecycleConfiguration", "s3:PutLifecycleConfiguration"},
+	{"cors", "s3:GetBucketCORS", "s3:PutBucketCORS", "s3:PutBucketCORS"},
+	{"policy", "s3:GetBucketPolicy", "s3:PutBucketPolicy", "s3:DeleteBucketPolicy"},
+	{"encryption", "s3:GetEncryptionConfiguration", "s3:PutEncryptionConfiguration", "s3:PutEncryptionConfiguration"},
+	{"replication", "s3:GetReplicationConfiguration", "s3:PutReplicationConfiguration", "s3:PutReplicationConfiguration"},
+	{"tagging", "s3:GetBucketTagging", "s3:PutBucketTagging", "s3:PutBucketTagging"},
+	{"object-lock", "s3:GetBucketObjectLockConfiguration", "s3:PutBucketObjectLockConfiguration", "s3:PutBucketObjectLockConfiguration"},
+	{"public-access-block", "s3:GetBucketPublicAccessBlock", "s3:PutBucketPublicAccessBlock", "s3:PutBucketPublicAccessBlock"},
+	{"accelerate", "s3:GetAccelerateConfiguration", "s3:PutAccelerateConfiguration", "s3:PutAccelerateConfiguration"},
+	{"inventory", "s3:GetInventoryConfiguration", "s3:PutInventoryConfiguration", "s3:PutInventoryConfiguration"},
+	{"analytics", "s3:GetAnalyticsConfiguration", "s3:PutAnalyticsConfiguration", "s3:PutAnalyticsConfiguration"},
+	{"website", "s3:GetBucketWebsite", "s3:PutBucketWebsite", "s3:DeleteBucketWebsite"},
+	{"notification", "s3:GetBucketNotification", "s3:PutBucketNotification", "s3:PutBucketNotification"},
+	{"logging", "s3:GetBucketLogging", "s3:PutBucketLogging", "s3:PutBucketLogging"},
+	{"location", "s3:GetBucketLocation", "s3:CreateBucket", ""},
+	{"ownership-controls", "s3:GetBucketOwnershipControls", "s3:PutBucketOwnershipControls", "s3:PutBucketOwnershipControls"},
+	{"metrics", "s3:GetMetricsConfiguration", "s3:PutMetricsConfiguration", "s3:PutMetricsConfiguration"},
+	{"acl", "s3:GetBucketAcl", "s3:PutBucketAcl", "s3:PutBucketAcl"},
+	{"versions", "s3:ListBucketVersions", "", ""},
+	{"uploads", "s3:ListBucketMultipartUploads", "", ""},
+}
+
+// objectSubresourceActions maps object sub-resource query parameters to the
+// S3 action names used for GET, PUT and DELETE on that sub-resource
+var objectSubresourceActions = []struct {
+	param         string
+	get, put, del string
+}{
+	{"acl", "s3:GetObjectAcl", "s3:PutObjectAcl", ""},
+	{"tagging", "s3:GetObjectTagging", "s3:PutObjectTagging", "s3:DeleteObjectTagging"},
+	{"retention", "s3:GetObjectRetention", "s3:PutObjectRetention", ""},
+	{"legal-hold", "s3:GetObjectLegalHold", "s3:PutObjectLegalHold", ""},
+	{"attributes", "s3:GetObjectAttributes", "", ""},
+}
+
+// s3Action returns the S3 action name (as used in IAM and bucket policies)
+// for the operation a request addresses
+func s3Action(req *http.Request, bucket, key string) string {
+	query := req.URL.Query()
+	has := func(name string) bool {
+		_, ok := query[name]
+		return ok
+	}
+
+	if bucket == "" {
+		return "s3:ListAllMyBuckets"
+	}
+
+	if key == "" {
+		switch req.Method {
+		case http.MethodHead:
+			return "s3:ListBucket"
+		case http.MethodPost:
+			if has("delete") {
+				return "s3:DeleteObject"
+			}
+			return "s3:PutObject"
+		}
+
+		for _, sub := range bucketSubresourceActions {
+			if !has(sub.param) {
+				continue
+			}
+			if action := pickAction(req.Method, sub.get, sub.put, sub.del); action != "" {
+				return action
+			}
+		}
+
+		return pickAction(req.Method, "s3:ListBucket", "s3:CreateBucket", "s3:DeleteBucket")
+	}
+
+	// Multipart upload operations
+	if has("uploads") && req.Method == http.MethodPost {
+		return "s3:PutObject"
+	}
+	if has("uploadId") {
+		switch req.Method {
+		case http.MethodGet:
+			return "s3:ListMultipartUploadParts"
+		case http.MethodDelete:
+			return "s3:AbortMultipartUpload"
+		default:
+			return "s3:PutObject"
+		}
+	}
+
+	if req.Method == http.MethodPost {
+		if has("restore") {
+			return "s3:RestoreObject"
+		}
+		return "s3:GetObject"
+	}
+
+	for _, sub := range objectSubresourceActions {
+		if !has(sub.param) {
+			continue
+		}
+		if action := pickAction(req.Method, sub.get, sub.put, sub.del); action != "" {
+			return action
+		}
+	}
+
+	versioned := query.Get("versionId") != ""
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		if versioned {
+			return "s3:GetObjectVersion"
+		}
+		return "s3:GetObject"
+	case http.MethodDelete:
+		if versioned {
+			return "s3:DeleteObjectVersion"
+		}
+		return "s3:DeleteObject"
+	}
+
+	return "s3:PutObject"
+}
+
+// pickAction selects the action for the request method
+func pickAction(method, get, put, del string) string {
+	switch method {
+	case http.MethodGet:
+		return get
+	case http.MethodPut:
+		return put
+	case http.MethodDelete:
+		return del
+	}
+	return ""
+}
+
+// authErrorToS3 maps an authentication error to the S3 error returned to the client
+func authErrorToS3(err error) S3Error {
+	switch {
+	case errors.Is(err, auth.ErrInvalidAccessKeyID):
+		return ErrInvalidAccessKeyID
+	case errors.Is(err, auth.ErrSignatureDoesNotMatch):
+		return ErrSignatureDoesNotMatch
+	case errors.Is(err, auth.ErrRequestTimeTooSkewed):
+		return ErrRequestTimeTooSkewed
+	case errors.Is(err, auth.ErrInvalidPolicyDocument):
+		return ErrInvalidPolicyDocument
+	default:
+		return ErrAccessDenied
+	}
+}
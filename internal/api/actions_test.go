@@ -0,0 +1,136 @@
+package api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openendpoint/openendpoint/internal/auth"
+	"github.com/openendpoint/openendpoint/internal/config"
+	"github.com/openendpoint/openendpoint/internal/engine"
+	s3types "github.com/openendpoint/openendpoint/pkg/s3types"
+	"go.uber.org/zap"
+)
+
+func TestS3Action(t *testing.T) {
+	tests := []struct {
+		method string
+		target string
+		want   string
+	}{
+		{"GET", "/s3/", "s3:ListAllMyBuckets"},
+		{"GET", "/s3/bucket", "s3:ListBucket"},
+		{"GET", "/s3/bucket?list-type=2", "s3:ListBucket"},
+		{"HEAD", "/s3/bucket", "s3:ListBucket"},
+		{"PUT", "/s3/bucket", "s3:CreateBucket"},
+		{"DELETE", "/s3/bucket", "s3:DeleteBucket"},
+		{"GET", "/s3/bucket?versioning", "s3:GetBucketVersioning"},
+		{"PUT", "/s3/bucket?versioning", "s3:PutBucketVersioning"},
+		{"DELETE", "/s3/bucket?policy", "s3:DeleteBucketPolicy"},
+		{"GET", "/s3/bucket?versions", "s3:ListBucketVersions"},
+		{"GET", "/s3/bucket?uploads", "s3:ListBucketMultipartUploads"},
+		{"POST", "/s3/bucket?delete", "s3:DeleteObject"},
+		{"GET", "/s3/bucket/key", "s3:GetObject"},
+		{"HEAD", "/s3/bucket/key", "s3:GetObject"},
+		{"GET", "/s3/bucket/key?versionId=v1", "s3:GetObjectVersion"},
+		{"PUT", "/s3/bucket/key", "s3:PutObject"},
+		{"DELETE", "/s3/bucket/key", "s3:DeleteObject"},
+		{"DELETE", "/s3/bucket/key?versionId=v1", "s3:DeleteObjectVersion"},
+		{"GET", "/s3/bucket/key?tagging", "s3:GetObjectTagging"},
+		{"DELETE", "/s3/bucket/key?tagging", "s3:DeleteObjectTagging"},
+		{"PUT", "/s3/bucket/key?retention", "s3:PutObjectRetention"},
+		{"GET", "/s3/bucket/key?attributes", "s3:GetObjectAttributes"},
+		{"POST", "/s3/bucket/key?uploads", "s3:PutObject"},
+		{"PUT", "/s3/bucket/key?partNumber=1&uploadId=u", "s3:PutObject"},
+		{"GET", "/s3/bucket/key?uploadId=u", "s3:ListMultipartUploadParts"},
+		{"DELETE", "/s3/bucket/key?uploadId=u", "s3:AbortMultipartUpload"},
+		{"POST", "/s3/bucket/key?select&select-type=2", "s3:GetObject"},
+		{"POST", "/s3/bucket/key?restore", "s3:RestoreObject"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			bucket, key, _ := parseBucketKey(req, req.URL.Path)
+			if got := s3Action(req, bucket, key); got != tt.want {
+				t.Errorf("s3Action() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func createAuthenticatedTestRouter(t *testing.T) *Router {
+	logger := zap.NewNop().Sugar()
+	svc := engine.New(NewMockAPIStorage(), NewMockAPIMetadata(), logger)
+	authSvc := auth.New(config.AuthConfig{AccessKey: "test-key", SecretKey: "test-secret"})
+	return NewRouter(svc, authSvc, logger, &config.Config{})
+}
+
+func TestAPIRouter_ServeHTTP_Authentication(t *testing.T) {
+	router := createAuthenticatedTestRouter(t)
+	dateStamp := time.Now().UTC().Format("20060102")
+	amzDate := time.Now().UTC().Format("20060102T150405Z")
+
+	tests := []struct {
+		name     string
+		target   string
+		header   string
+		wantCode string
+	}{
+		{
+			name:     "Anonymous",
+			target:   "/s3/test-bucket/key",
+			wantCode: "AccessDenied",
+		},
+		{
+			name:     "UnknownAccessKey",
+			target:   "/s3/test-bucket/key",
+			header:   fmt.Sprintf("AWS4-HMAC-SHA256 Credential=other-key/%s/us-east-1/s3/aws4_request, SignedHeaders=host;x-amz-date, Signature=abc", dateStamp),
+			wantCode: "InvalidAccessKeyId",
+		},
+		{
+			name:     "BadSignature",
+			target:   "/s3/test-bucket/key",
+			header:   fmt.Sprintf("AWS4-HMAC-SHA256 Credential=test-key/%s/us-east-1/s3/aws4_request, SignedHeaders=host;x-amz-date, Signature=abc", dateStamp),
+			wantCode: "SignatureDoesNotMatch",
+		},
+		{
+			name:     "BadPresignedSignature",
+			target:   fmt.Sprintf("/s3/test-bucket?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=test-key%%2F%s%%2Fus-east-1%%2Fs3%%2Faws4_request&X-Amz-Date=%s&X-Amz-Expires=60&X-Amz-SignedHeaders=host&X-Amz-Signature=abc", dateStamp, amzDate),
+			wantCode: "SignatureDoesNotMatch",
+		},
+		{
+			name:     "MalformedHeader",
+			target:   "/s3/",
+			header:   "Bearer token",
+			wantCode: "AccessDenied",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			req.Header.Set("X-Amz-Date", amzDate)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusForbidden {
+				t.Errorf("Status = %d, want %d", w.Code, http.StatusForbidden)
+			}
+			var resp s3types.Error
+			if err := xml.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("XML unmarshal error: %v", err)
+			}
+			if resp.Code != tt.wantCode {
+				t.Errorf("Code = %s, want %s", resp.Code, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// normalizeDomains lowercases the configured virtual host domains and drops
+// any leading dot
+func normalizeDomains(domains []string) []string {
+	normalized := make([]string, 0, len(domains))
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "."))
+		if d != "" {
+			normalized = append(normalized, d)
+		}
+	}
+	return normalized
+}
+
+// virtualHostBucket returns the bucket named by a virtual-hosted-style
+// Host header such as bucket.s3.example.local:9000, or "" when host is not
+// a subdomain of a configured domain
+func (r *Router) virtualHostBucket(host string) string {
+	if len(r.domains) == 0 || host == "" {
+		return ""
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	for _, domain := range r.domains {
+		if bucket, ok := strings.CutSuffix(host, "."+domain); ok && bucket != "" {
+			return bucket
+		}
+	}
+	return ""
+}
+
+// bucketKey returns the bucket and key a request addresses. Virtual-hosted
+// requests name the bucket in the Host header and the key in the path;
+// path-style requests carry both in the path below the S3 root.
+func (r *Router) bucketKey(req *http.Request) (bucket, key string, err error) {
+	if bucket := r.virtualHostBucket(req.Host); bucket != "" {
+		return bucket, strings.TrimPrefix(req.URL.Path, "/"), nil
+	}
+	if r.rootPath {
+		return splitBucketKey(strings.TrimPrefix(req.URL.Path, "/"))
+	}
+	return parseBucketKey(req, req.URL.Path)
+}
+
+// VirtualHosts sends virtual-hosted-style requests to the S3 API whatever
+// their path and everything else to next
+func (r *Router) VirtualHosts(next http.Handler) http.Handler {
+	if len(r.domains) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if r.virtualHostBucket(req.Host) != "" {
+			r.ServeHTTP(w, req)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,234 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openendpoint/openendpoint/internal/config"
+)
+
+func TestVirtualHostBucket(t *testing.T) {
+	r := &Router{domains: normalizeDomains([]string{"s3.example.local", ".S3.Other.Test"})}
+
+	tests := map[string]string{
+		"photos.s3.example.local":      "photos",
+		"photos.s3.example.local:9000": "photos",
+		"Photos.S3.Example.Local":      "photos",
+		"my.dotted.s3.example.local":   "my.dotted",
+		"logs.s3.other.test":           "logs",
+		"s3.example.local":             "",
+		"s3.example.local:9000":        "",
+		".s3.example.local":            "",
+		"photos.example.local":         "",
+		"localhost:9000":               "",
+		"":                             "",
+	}
+	for host, want := range tests {
+		if got := r.virtualHostBucket(host); got != want {
+			t.Errorf("virtualHostBucket(%q) = %q, want %q", host, got, want)
+		}
+	}
+
+	if got := (&Router{}).virtualHostBucket("photos.s3.example.local"); got != "" {
+		t.Errorf("virtualHostBucket() without domains = %q", got)
+	}
+}
+
+func TestRouter_BucketKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		rootPath   bool
+		host       string
+		path       string
+		wantBucket string
+		wantKey    string
+	}{
+		{"PathStyle", false, "localhost", "/s3/bucket/dir/key", "bucket", "dir/key"},
+		{"PathStyleRoot", false, "localhost", "/s3/", "", ""},
+		{"RootPath", true, "localhost", "/bucket/dir/key", "bucket", "dir/key"},
+		{"RootPathBucketNamedS3", true, "localhost", "/s3/key", "s3", "key"},
+		{"RootPathListBuckets", true, "localhost", "/", "", ""},
+		{"VirtualHost", false, "bucket.s3.example.local", "/dir/key", "bucket", "dir/key"},
+		{"VirtualHostBucketRoot", true, "bucket.s3.example.local:9000", "/", "bucket", ""},
+		{"VirtualHostKeyLikePrefix", false, "bucket.s3.example.local", "/s3/key", "bucket", "s3/key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Router{domains: []string{"s3.example.local"}, rootPath: tt.rootPath}
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req.Host = tt.host
+			bucket, key, err := r.bucketKey(req)
+			if err != nil {
+				t.Fatalf("bucketKey() error = %v", err)
+			}
+			if bucket != tt.wantBucket || key != tt.wantKey {
+				t.Errorf("bucketKey() = %q, %q, want %q, %q", bucket, key, tt.wantBucket, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestAPIRouter_VirtualHostedStyle(t *testing.T) {
+	router := createStoreTestAPIRouter(t, &config.Config{
+		Server: config.ServerConfig{Domains: []string{"s3.example.local"}},
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/s3/", router)
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+	handler := router.VirtualHosts(mux)
+
+	do := func(method, host, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Host = host
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("PUT", "localhost:9000", "/s3/photos", ""); w.Code != http.StatusOK {
+		t.Fatalf("CreateBucket status = %d", w.Code)
+	}
+	// Paths the server itself uses are keys on a bucket's host
+	if w := do("PUT", "photos.s3.example.local:9000", "/health", "virtual"); w.Code != http.StatusOK {
+		t.Fatalf("virtual-hosted PutObject status = %d, body %s", w.Code, w.Body.String())
+	}
+	if w := do("GET", "localhost:9000", "/s3/photos/health", ""); w.Code != http.StatusOK || w.Body.String() != "virtual" {
+		t.Errorf("path-style GetObject = %d %q", w.Code, w.Body.String())
+	}
+	if w := do("GET", "localhost:9000", "/health", ""); w.Body.String() != "OK" {
+		t.Errorf("health check = %q", w.Body.String())
+	}
+	if w := do("GET", "photos.s3.example.local", "/?list-type=2", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<Key>health</Key>") {
+		t.Errorf("virtual-hosted ListObjectsV2 = %d %s", w.Code, w.Body.String())
+	}
+	if w := do("GET", "missing.s3.example.local", "/key", ""); w.Code != http.StatusNotFound {
+		t.Errorf("GetObject on missing bucket status = %d", w.Code)
+	}
+}
+
+func TestAPIRouter_RootPath(t *testing.T) {
+	router := createStoreTestAPIRouter(t, &config.Config{Server: config.ServerConfig{S3Root: true}})
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("PUT", "/photos", ""); w.Code != http.StatusOK {
+		t.Fatalf("CreateBucket status = %d", w.Code)
+	}
+	if w := do("PUT", "/photos/key", "data"); w.Code != http.StatusOK {
+		t.Fatalf("PutObject status = %d", w.Code)
+	}
+	if w := do("GET", "/", ""); !strings.Contains(w.Body.String(), "<Name>photos</Name>") {
+		t.Errorf("ListBuckets = %s", w.Body.String())
+	}
+	if w := do("GET", "/photos/key", ""); w.Body.String() != "data" {
+		t.Errorf("GetObject = %q", w.Body.String())
+	}
+}
+
+// signV4 signs req with an Authorization header over host and x-amz-date,
+// using any X-Amz-Content-Sha256 header as the payload hash. It returns the
+// signature and signing key that seed an aws-chunked body.
+func signV4(req *http.Request, accessKey, secretKey string) (seed string, signingKey []byte) {
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	dateStamp := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	payloadHash := req.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = "UNSIGNED-PAYLOAD"
+	}
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.Host + "\nx-amz-date:" + amzDate + "\n",
+		"host;x-amz-date",
+		payloadHash,
+	}, "\n")
+	scope := dateStamp + "/us-east-1/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	signingKey = []byte("AWS4" + secretKey)
+	for _, part := range []string{dateStamp, "us-east-1", "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	seed = hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=host;x-amz-date, Signature=%s", accessKey, scope, seed))
+	return seed, signingKey
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// signV2 signs req with a SigV2 Authorization header for resource
+func signV2(req *http.Request, accessKey, secretKey, resource string) {
+	date := time.Now().UTC().Format(http.TimeFormat)
+	req.Header.Set("Date", date)
+	mac := hmac.New(sha1.New, []byte(secretKey))
+	io.WriteString(mac, req.Method+"\n\n\n"+date+"\n"+resource)
+	req.Header.Set("Authorization", "AWS "+accessKey+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+func TestAPIRouter_VirtualHostedSignatures(t *testing.T) {
+	router := createStoreTestAPIRouter(t, &config.Config{
+		Server: config.ServerConfig{Domains: []string{"s3.example.local"}},
+		Auth:   config.AuthConfig{AccessKey: "test-key", SecretKey: "test-secret"},
+	})
+
+	do := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	req := httptest.NewRequest("PUT", "/s3/photos", nil)
+	signV4(req, "test-key", "test-secret")
+	if w := do(req); w.Code != http.StatusOK {
+		t.Fatalf("path-style SigV4 CreateBucket status = %d, body %s", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest("PUT", "/cat.jpg", strings.NewReader("meow"))
+	req.Host = "photos.s3.example.local:9000"
+	signV4(req, "test-key", "test-secret")
+	if w := do(req); w.Code != http.StatusOK {
+		t.Fatalf("virtual-hosted SigV4 PutObject status = %d, body %s", w.Code, w.Body.String())
+	}
+
+	// SigV2 signs the bucket as part of the resource
+	req = httptest.NewRequest("GET", "/cat.jpg", nil)
+	req.Host = "photos.s3.example.local"
+	signV2(req, "test-key", "test-secret", "/photos/cat.jpg")
+	if w := do(req); w.Code != http.StatusOK || w.Body.String() != "meow" {
+		t.Errorf("virtual-hosted SigV2 GetObject = %d %q", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/cat.jpg", nil)
+	req.Host = "photos.s3.example.local"
+	signV2(req, "test-key", "test-secret", "/cat.jpg")
+	if w := do(req); w.Code != http.StatusForbidden {
+		t.Errorf("SigV2 without the bucket status = %d, want 403", w.Code)
+	}
+}
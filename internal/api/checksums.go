@@ -0,0 +1,134 @@
+package api
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/openendpoint/openendpoint/pkg/s3types"
+)
+
+// checksumSizes are the digest sizes of the additional checksum
+// algorithms, in the order their headers are looked for
+var checksumSizes = []struct {
+	algorithm string
+	size      int
+}{
+	{"CRC32", 4},
+	{"CRC32C", 4},
+	{"SHA1", 20},
+	{"SHA256", 32},
+}
+
+// checksumHeader returns the header carrying a checksum of algorithm
+func checksumHeader(algorithm string) string {
+	return "x-amz-checksum-" + strings.ToLower(algorithm)
+}
+
+// checksumAlgorithm returns the S3 name of a checksum algorithm given in
+// any case, or "" when it is not supported
+func checksumAlgorithm(name string) string {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	for _, c := range checksumSizes {
+		if c.algorithm == name {
+			return name
+		}
+	}
+	return ""
+}
+
+// requestChecksum returns the additional checksum algorithm of an upload
+// and the base64 value its data must match. The value is "" when only
+// x-amz-sdk-checksum-algorithm is given, or when the checksum follows in
+// an aws-chunked trailer, which is verified as the body is decoded.
+func requestChecksum(req *http.Request) (algorithm, value string, s3err S3Error) {
+	if name := req.Header.Get("x-amz-sdk-checksum-algorithm"); name != "" {
+		if algorithm = checksumAlgorithm(name); algorithm == "" {
+			return "", "", ErrInvalidChecksum
+		}
+	}
+
+	var found string
+	for _, c := range checksumSizes {
+		v := req.Header.Get(checksumHeader(c.algorithm))
+		if v == "" {
+			continue
+		}
+		if found != "" {
+			return "", "", ErrInvalidChecksum
+		}
+		if sum, err := base64.StdEncoding.DecodeString(v); err != nil || len(sum) != c.size {
+			return "", "", ErrInvalidChecksum
+		}
+		found, value = c.algorithm, v
+	}
+
+	if trailer := req.Header.Get("X-Amz-Trailer"); trailer != "" {
+		name, ok := strings.CutPrefix(strings.ToLower(strings.TrimSpace(trailer)), "x-amz-checksum-")
+		if !ok || found != "" {
+			return "", "", ErrInvalidChecksum
+		}
+		found = checksumAlgorithm(name)
+		if found == "" {
+			return "", "", ErrInvalidChecksum
+		}
+	}
+
+	if found != "" {
+		if algorithm != "" && algorithm != found {
+			return "", "", ErrInvalidChecksum
+		}
+		algorithm = found
+	}
+	return algorithm, value, nil
+}
+
+// checksumModeEnabled reports whether a GET or HEAD asked for the object's
+// checksum with x-amz-checksum-mode: ENABLED
+func checksumModeEnabled(req *http.Request) bool {
+	return strings.EqualFold(req.Header.Get("x-amz-checksum-mode"), "ENABLED")
+}
+
+// checksumType returns COMPOSITE for the checksum of a multipart object,
+// which ends in -<part count>, and FULL_OBJECT otherwise
+func checksumType(value string) string {
+	if strings.Contains(value, "-") {
+		return "COMPOSITE"
+	}
+	return "FULL_OBJECT"
+}
+
+// setChecksumHeaders sets the header carrying a checksum and its type
+func setChecksumHeaders(w http.ResponseWriter, algorithm, value string) {
+	if algorithm == "" || value == "" {
+		return
+	}
+	w.Header().Set(checksumHeader(algorithm), value)
+	w.Header().Set("x-amz-checksum-type", checksumType(value))
+}
+
+// checksumXML returns a checksum as the XML elements of S3 responses
+func checksumXML(algorithm, value string) s3types.Checksum {
+	var c s3types.Checksum
+	switch algorithm {
+	case "CRC32":
+		c.ChecksumCRC32 = value
+	case "CRC32C":
+		c.ChecksumCRC32C = value
+	case "SHA1":
+		c.ChecksumSHA1 = value
+	case "SHA256":
+		c.ChecksumSHA256 = value
+	}
+	return c
+}
+
+// xmlChecksumValue returns the single checksum given in XML, or "" if none
+func xmlChecksumValue(c s3types.Checksum) string {
+	for _, v := range []string{c.ChecksumCRC32, c.ChecksumCRC32C, c.ChecksumSHA1, c.ChecksumSHA256} {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	s3types "github.com/openendpoint/openendpoint/pkg/s3types"
+)
+
+func TestRequestChecksum(t *testing.T) {
+	tests := []struct {
+		name          string
+		header        map[string]string
+		wantAlgorithm string
+		wantValue     string
+		wantErr       bool
+	}{
+		{"None", nil, "", "", false},
+		{"Header", map[string]string{"x-amz-checksum-crc32": "y/Q5Jg=="}, "CRC32", "y/Q5Jg==", false},
+		{"SDKAlgorithm", map[string]string{"x-amz-sdk-checksum-algorithm": "sha256"}, "SHA256", "", false},
+		{"SDKAlgorithmAndHeader", map[string]string{"x-amz-sdk-checksum-algorithm": "CRC32C", "x-amz-checksum-crc32c": "4waSgw=="}, "CRC32C", "4waSgw==", false},
+		{"Trailer", map[string]string{"X-Amz-Trailer": "x-amz-checksum-sha1"}, "SHA1", "", false},
+		{"UnknownAlgorithm", map[string]string{"x-amz-sdk-checksum-algorithm": "MD5"}, "", "", true},
+		{"ConflictingAlgorithm", map[string]string{"x-amz-sdk-checksum-algorithm": "SHA1", "x-amz-checksum-crc32": "y/Q5Jg=="}, "", "", true},
+		{"TwoHeaders", map[string]string{"x-amz-checksum-crc32": "y/Q5Jg==", "x-amz-checksum-crc32c": "4waSgw=="}, "", "", true},
+		{"WrongLength", map[string]string{"x-amz-checksum-sha256": "y/Q5Jg=="}, "", "", true},
+		{"NotBase64", map[string]string{"x-amz-checksum-crc32": "not base64"}, "", "", true},
+		{"HeaderAndTrailer", map[string]string{"x-amz-checksum-crc32": "y/Q5Jg==", "X-Amz-Trailer": "x-amz-checksum-crc32"}, "", "", true},
+		{"UnknownTrailer", map[string]string{"X-Amz-Trailer": "x-amz-meta-foo"}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PUT", "/s3/bucket/key", nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			algorithm, value, s3err := requestChecksum(req)
+			if (s3err != nil) != tt.wantErr {
+				t.Fatalf("requestChecksum() error = %v, wantErr %v", s3err, tt.wantErr)
+			}
+			if algorithm != tt.wantAlgorithm || value != tt.wantValue {
+				t.Errorf("requestChecksum() = %q %q, want %q %q", algorithm, value, tt.wantAlgorithm, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestAPIRouter_PutObjectChecksum(t *testing.T) {
+	router := createVersionedTestAPIRouter(t)
+
+	do := func(method, target, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	do("PUT", "/s3/test-bucket", "", nil)
+
+	w := do("PUT", "/s3/test-bucket/key", "123456789", map[string]string{"x-amz-checksum-crc32": "y/Q5Jg=="})
+	if w.Code != http.StatusOK {
+		t.Fatalf("PutObject status = %d, body %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("x-amz-checksum-crc32"); got != "y/Q5Jg==" {
+		t.Errorf("PutObject x-amz-checksum-crc32 = %q, want y/Q5Jg==", got)
+	}
+
+	w = do("PUT", "/s3/test-bucket/bad", "123456789", map[string]string{"x-amz-checksum-crc32": crc32Checksum("other")})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "BadDigest") {
+		t.Errorf("PutObject with wrong checksum = %d %s, want BadDigest", w.Code, w.Body.String())
+	}
+	if w := do("GET", "/s3/test-bucket/bad", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("GetObject after rejected upload status = %d, want 404", w.Code)
+	}
+
+	w = do("PUT", "/s3/test-bucket/bad", "123456789", map[string]string{"x-amz-checksum-crc32": "AAAA"})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "InvalidRequest") {
+		t.Errorf("PutObject with malformed checksum = %d %s, want InvalidRequest", w.Code, w.Body.String())
+	}
+
+	for _, method := range []string{"GET", "HEAD"} {
+		w := do(method, "/s3/test-bucket/key", "", map[string]string{"x-amz-checksum-mode": "ENABLED"})
+		if got := w.Header().Get("x-amz-checksum-crc32"); got != "y/Q5Jg==" {
+			t.Errorf("%s with checksum mode x-amz-checksum-crc32 = %q, want y/Q5Jg==", method, got)
+		}
+		if got := w.Header().Get("x-amz-checksum-type"); got != "FULL_OBJECT" {
+			t.Errorf("%s with checksum mode x-amz-checksum-type = %q, want FULL_OBJECT", method, got)
+		}
+
+		w = do(method, "/s3/test-bucket/key", "", nil)
+		if got := w.Header().Get("x-amz-checksum-crc32"); got != "" {
+			t.Errorf("%s without checksum mode x-amz-checksum-crc32 = %q, want none", method, got)
+		}
+	}
+
+	w = do("GET", "/s3/test-bucket/key", "", map[string]string{"x-amz-checksum-mode": "ENABLED", "Range": "bytes=0-3"})
+	if got := w.Header().Get("x-amz-checksum-crc32"); got != "" {
+		t.Errorf("range GET x-amz-checksum-crc32 = %q, want none", got)
+	}
+}
+
+func TestAPIRouter_MultipartChecksum(t *testing.T) {
+	router := createVersionedTestAPIRouter(t)
+
+	do := func(method, target, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	do("PUT", "/s3/test-bucket", "", nil)
+
+	w := do("POST", "/s3/test-bucket/key?uploads", "", map[string]string{"x-amz-checksum-algorithm": "SHA256"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("CreateMultipartUpload status = %d, body %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("x-amz-checksum-algorithm"); got != "SHA256" {
+		t.Errorf("CreateMultipartUpload x-amz-checksum-algorithm = %q, want SHA256", got)
+	}
+	var initiated s3types.InitiateMultipartUploadResult
+	if err := xml.Unmarshal(w.Body.Bytes(), &initiated); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	uploadID := initiated.UploadID
+
+	if w := do("POST", "/s3/test-bucket/other?uploads", "", map[string]string{"x-amz-checksum-algorithm": "MD5"}); w.Code != http.StatusBadRequest {
+		t.Errorf("CreateMultipartUpload with unknown algorithm status = %d, want 400", w.Code)
+	}
+
+	var complete strings.Builder
+	complete.WriteString("<CompleteMultipartUpload>")
+	composite := sha256.New()
+	var partSums []string
+	for i, data := range []string{strings.Repeat("a", 5<<20), "bb"} {
+		sum := sha256.Sum256([]byte(data))
+		composite.Write(sum[:])
+		partSum := base64.StdEncoding.EncodeToString(sum[:])
+		partSums = append(partSums, partSum)
+
+		w := do("PUT", "/s3/test-bucket/key?partNumber="+strconv.Itoa(i+1)+"&uploadId="+uploadID, data, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("UploadPart(%d) status = %d, body %s", i+1, w.Code, w.Body.String())
+		}
+		if got := w.Header().Get("x-amz-checksum-sha256"); got != partSum {
+			t.Errorf("UploadPart(%d) x-amz-checksum-sha256 = %q, want %q", i+1, got, partSum)
+		}
+		complete.WriteString("<Part><PartNumber>" + strconv.Itoa(i+1) + "</PartNumber><ETag>" + w.Header().Get("ETag") +
+			"</ETag><ChecksumSHA256>" + partSum + "</ChecksumSHA256></Part>")
+	}
+	complete.WriteString("</CompleteMultipartUpload>")
+	want := base64.StdEncoding.EncodeToString(composite.Sum(nil)) + "-2"
+
+	w = do("POST", "/s3/test-bucket/key?uploadId="+uploadID, complete.String(), nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("CompleteMultipartUpload status = %d, body %s", w.Code, w.Body.String())
+	}
+	var completed s3types.CompleteMultipartUploadResult
+	if err := xml.Unmarshal(w.Body.Bytes(), &completed); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if completed.ChecksumSHA256 != want || completed.ChecksumType != "COMPOSITE" {
+		t.Errorf("CompleteMultipartUpload checksum = %q %q, want %q COMPOSITE", completed.ChecksumSHA256, completed.ChecksumType, want)
+	}
+
+	attributes := func(names string, header map[string]string) (*httptest.ResponseRecorder, s3types.GetObjectAttributesOutput) {
+		if header == nil {
+			header = map[string]string{}
+		}
+		header["x-amz-object-attributes"] = names
+		w := do("GET", "/s3/test-bucket/key?attributes", "", header)
+		var out s3types.GetObjectAttributesOutput
+		if w.Code == http.StatusOK {
+			if err := xml.Unmarshal(w.Body.Bytes(), &out); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+		}
+		return w, out
+	}
+
+	w, out := attributes("ETag,ObjectSize,Checksum,ObjectParts", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GetObjectAttributes status = %d, body %s", w.Code, w.Body.String())
+	}
+	if out.ETag == "" || strings.Contains(out.ETag, `"`) || out.ObjectSize != "5242882" || out.StorageClass != "" {
+		t.Errorf("GetObjectAttributes = %+v", out)
+	}
+	if out.Checksum == nil || out.Checksum.ChecksumSHA256 != want || out.Checksum.ChecksumType != "COMPOSITE" {
+		t.Errorf("GetObjectAttributes Checksum = %+v, want %s", out.Checksum, want)
+	}
+	if out.ObjectParts == nil || out.ObjectParts.TotalPartsCount != 2 || len(out.ObjectParts.Parts) != 2 ||
+		out.ObjectParts.Parts[1].ChecksumSHA256 != partSums[1] || out.ObjectParts.Parts[1].Size != 2 {
+		t.Errorf("GetObjectAttributes ObjectParts = %+v", out.ObjectParts)
+	}
+	if w.Header().Get("Last-Modified") == "" {
+		t.Error("GetObjectAttributes did not set Last-Modified")
+	}
+
+	_, out = attributes("ObjectParts", map[string]string{"x-amz-max-parts": "1"})
+	if parts := out.ObjectParts; parts == nil || !parts.IsTruncated || parts.NextPartNumberMarker != 1 || len(parts.Parts) != 1 {
+		t.Errorf("first page of ObjectParts = %+v", parts)
+	}
+	_, out = attributes("ObjectParts", map[string]string{"x-amz-part-number-marker": "1"})
+	if parts := out.ObjectParts; parts == nil || parts.IsTruncated || len(parts.Parts) != 1 || parts.Parts[0].PartNumber != 2 {
+		t.Errorf("second page of ObjectParts = %+v", parts)
+	}
+
+	if w, _ := attributes("ETag,Owner", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("GetObjectAttributes with unknown attribute status = %d, want 400", w.Code)
+	}
+	if w := do("GET", "/s3/test-bucket/key?attributes", "", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("GetObjectAttributes without attributes status = %d, want 400", w.Code)
+	}
+	if w, _ := attributes("ETag", nil); w.Code != http.StatusOK {
+		t.Errorf("GetObjectAttributes status = %d", w.Code)
+	}
+	if w := do("GET", "/s3/test-bucket/missing?attributes", "", map[string]string{"x-amz-object-attributes": "ETag"}); w.Code != http.StatusNotFound {
+		t.Errorf("GetObjectAttributes of missing key status = %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/openendpoint/openendpoint/internal/engine"
+)
+
+// requestConditions reads the conditional request headers; prefix is "" for
+// GET/HEAD and "x-amz-copy-source-" for the copy source of CopyObject
+func requestConditions(req *http.Request, prefix string) engine.Conditions {
+	return engine.Conditions{
+		IfMatch:           req.Header.Get(prefix + "If-Match"),
+		IfNoneMatch:       req.Header.Get(prefix + "If-None-Match"),
+		IfModifiedSince:   req.Header.Get(prefix + "If-Modified-Since"),
+		IfUnmodifiedSince: req.Header.Get(prefix + "If-Unmodified-Since"),
+	}
+}
+
+// writePreconditionError answers a failed conditional request with 304 or
+// 412 and reports whether err was a precondition failure
+func (r *Router) writePreconditionError(w http.ResponseWriter, err error) bool {
+	var perr *engine.PreconditionError
+	if !errors.As(err, &perr) {
+		return false
+	}
+
+	if errors.Is(perr, engine.ErrNotModified) {
+		w.Header().Set("ETag", sanitizeHeaderValue(perr.ETag))
+		setLastModified(w, perr.LastModified)
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+
+	r.writeError(w, ErrPreconditionFailed)
+	return true
+}
+
+// setLastModified sets the Last-Modified header from a Unix timestamp
+func setLastModified(w http.ResponseWriter, lastModified int64) {
+	if lastModified > 0 {
+		w.Header().Set("Last-Modified", time.Unix(lastModified, 0).UTC().Format(http.TimeFormat))
+	}
+}
+
+// preconditionStatus returns the metrics status label for a precondition failure
+func preconditionStatus(err error) string {
+	if errors.Is(err, engine.ErrNotModified) {
+		return "304"
+	}
+	return "412"
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/openendpoint/openendpoint/internal/auth"
+	"github.com/openendpoint/openendpoint/internal/encryption"
+	"github.com/openendpoint/openendpoint/internal/engine"
+	"github.com/openendpoint/openendpoint/internal/iam"
+	"github.com/openendpoint/openendpoint/internal/metadata"
+	"github.com/openendpoint/openendpoint/pkg/s3types"
+)
+
+const (
+	// sseHeader asks for SSE-S3 or SSE-KMS on writes and reports it on
+	// responses
+	sseHeader = "x-amz-server-side-encryption"
+	// sseKMSKeyIDHeader names the KMS key of SSE-KMS
+	sseKMSKeyIDHeader = "x-amz-server-side-encryption-aws-kms-key-id"
+	// sseCustomerPrefix starts the SSE-C headers of the object written or
+	// read, and sseCopySourceCustomerPrefix those of a copy source
+	sseCustomerPrefix           = "x-amz-server-side-encryption-customer-"
+	sseCopySourceCustomerPrefix = "x-amz-copy-source-server-side-encryption-customer-"
+)
+
+// requestEncryption reads the encryption a request asks for: SSE-S3 or
+// SSE-KMS from x-amz-server-side-encryption or SSE-C from the customer key
+// headers. Its KMS keys are used with the requester's permissions.
+func (r *Router) requestEncryption(req *http.Request) (engine.Encryption, S3Error) {
+	key, s3err := customerKey(req.Header, sseCustomerPrefix)
+	if s3err != nil {
+		return engine.Encryption{}, s3err
+	}
+
+	algorithm := req.Header.Get(sseHeader)
+	keyID := req.Header.Get(sseKMSKeyIDHeader)
+	switch {
+	case algorithm != "" && key != nil:
+		// Server-managed keys and SSE-C are exclusive
+		return engine.Encryption{}, ErrInvalidArgument
+	case algorithm != "" && algorithm != engine.SSEAlgorithmAES256 && algorithm != engine.SSEAlgorithmKMS:
+		return engine.Encryption{}, ErrInvalidEncryptionAlgorithm
+	case keyID != "" && algorithm != engine.SSEAlgorithmKMS:
+		return engine.Encryption{}, ErrInvalidArgument
+	}
+	return engine.Encryption{
+		Algorithm:    algorithm,
+		CustomerKey:  key,
+		KMSKeyID:     keyID,
+		AuthorizeKey: r.keyAuthorizer(req, auth.RequestAccessKey(req)),
+	}, nil
+}
+
+// copySourceEncryption reads the customer key of an SSE-C copy source. The
+// KMS key of an SSE-KMS source is used with the requester's permissions.
+func (r *Router) copySourceEncryption(req *http.Request) (engine.Encryption, S3Error) {
+	key, s3err := customerKey(req.Header, sseCopySourceCustomerPrefix)
+	return engine.Encryption{CustomerKey: key, AuthorizeKey: r.keyAuthorizer(req, auth.RequestAccessKey(req))}, s3err
+}
+
+// keyAuthorizer returns the authorizer of KMS key use by req, signed with
+// accessKey. IAM users may use a key when their policies allow the action
+// on the key's ARN and none denies it, and configured credentials may use
+// every key.
+// Anonymous requests, which a bucket policy may admit, may use none while
+// authentication is on.
+func (r *Router) keyAuthorizer(req *http.Request, accessKey string) func(keyID, action string) error {
+	return func(keyID, action string) error {
+		switch {
+		case accessKey == "" && r.auth.Enabled():
+			return fmt.Errorf("%w: anonymous requests may not use kms keys", engine.ErrKeyAccessDenied)
+		case accessKey == "" || r.iam == nil:
+			return nil
+		}
+		user, ok := r.iam.GetUserByAccessKey(accessKey)
+		if !ok {
+			return nil
+		}
+		rc := &iam.RequestContext{
+			Action:     action,
+			Resource:   encryption.KeyARN(keyID),
+			Principals: []string{user.ID, user.Username, iam.UserARN(user.Username)},
+			Keys:       requestConditionKeys(req),
+		}
+		rc.Keys[iam.KeyUsername] = user.Username
+		if r.iam.EvaluateRequest(user.ID, rc) != iam.DecisionAllow {
+			return fmt.Errorf("%w: %s is not allowed %s", engine.ErrKeyAccessDenied, user.Username, action)
+		}
+		return nil
+	}
+}
+
+// customerKey returns the SSE-C key given by the algorithm, key and key-MD5
+// headers starting with prefix, or nil when none of them is set
+func customerKey(h http.Header, prefix string) ([]byte, S3Error) {
+	algorithm := h.Get(prefix + "algorithm")
+	encoded := h.Get(prefix + "key")
+	digest := h.Get(prefix + "key-MD5")
+	if algorithm == "" && encoded == "" && digest == "" {
+		return nil, nil
+	}
+	if algorithm != engine.SSEAlgorithmAES256 {
+		return nil, ErrInvalidEncryptionAlgorithm
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != encryption.KeySize || digest != encryption.KeyMD5(key) {
+		return nil, ErrInvalidCustomerKey
+	}
+	return key, nil
+}
+
+// setEncryptionHeaders reports the encryption of an object on a response
+func setEncryptionHeaders(w http.ResponseWriter, sse engine.ServerSideEncryption) {
+	switch {
+	case sse.Algorithm == "":
+	case sse.CustomerKeyMD5 != "":
+		w.Header().Set(sseCustomerPrefix+"algorithm", sse.Algorithm)
+		w.Header().Set(sseCustomerPrefix+"key-MD5", sse.CustomerKeyMD5)
+	default:
+		w.Header().Set(sseHeader, sse.Algorithm)
+		if sse.KMSKeyID != "" {
+			w.Header().Set(sseKMSKeyIDHeader, encryption.KeyARN(sse.KMSKeyID))
+		}
+	}
+}
+
+// encryptionErrorToS3 maps an engine encryption error to the S3 error
+// returned to the client, or nil if err is not about encryption
+func encryptionErrorToS3(err error) S3Error {
+	switch {
+	case errors.Is(err, engine.ErrCustomerKeyRequired):
+		return ErrCustomerKeyRequired
+	case errors.Is(err, engine.ErrCustomerKeyMismatch):
+		return ErrCustomerKeyMismatch
+	case errors.Is(err, engine.ErrEncryptionNotConfigured):
+		return ErrEncryptionNotConfigured
+	case errors.Is(err, engine.ErrInvalidEncryption):
+		return ErrEncryptionNotApplicable
+	case errors.Is(err, engine.ErrKeyAccessDenied):
+		return ErrKMSAccessDenied
+	case errors.Is(err, encryption.ErrKeyNotFound), errors.Is(err, encryption.ErrInvalidKeyID):
+		return ErrKMSNotFound
+	case errors.Is(err, encryption.ErrKeyDisabled):
+		return ErrKMSDisabled
+	default:
+		return nil
+	}
+}
+
+// parseBucketEncryption validates a PutBucketEncryption body, which must
+// hold exactly one default encryption rule
+func parseBucketEncryption(in *s3types.ServerSideEncryptionConfiguration) (*metadata.BucketEncryption, S3Error) {
+	if len(in.Rules) != 1 {
+		return nil, ErrMalformedXML
+	}
+	apply := in.Rules[0].ApplyServerSideEncryptionByDefault
+	if apply.SSEAlgorithm == "" {
+		return nil, ErrMalformedXML
+	}
+	return &metadata.BucketEncryption{Rule: metadata.EncryptionRule{Apply: metadata.ApplyEncryptionConfiguration{
+		SSEAlgorithm:   apply.SSEAlgorithm,
+		KMSMasterKeyID: apply.KMSMasterKeyID,
+	}}}, nil
+}
+
+// bucketEncryptionXML returns the GetBucketEncryption body of config,
+// without rules when the bucket has no default encryption
+func bucketEncryptionXML(config *metadata.BucketEncryption) s3types.ServerSideEncryptionConfiguration {
+	var out s3types.ServerSideEncryptionConfiguration
+	if config != nil && config.Rule.Apply.SSEAlgorithm != "" {
+		out.Rules = []s3types.ServerSideEncryptionRule{{
+			ApplyServerSideEncryptionByDefault: s3types.ServerSideEncryptionByDefault{
+				SSEAlgorithm:   config.Rule.Apply.SSEAlgorithm,
+				KMSMasterKeyID: config.Rule.Apply.KMSMasterKeyID,
+			},
+		}}
+	}
+	return out
+}
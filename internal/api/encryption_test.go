@@ -0,0 +1,326 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/openendpoint/openendpoint/internal/config"
+	"github.com/openendpoint/openendpoint/internal/encryption"
+	"github.com/openendpoint/openendpoint/internal/engine"
+	"github.com/openendpoint/openendpoint/internal/iam"
+	"go.uber.org/zap"
+)
+
+// testMasterKey is the SSE-S3 master key of routers in encryption tests
+var testMasterKey = bytes.Repeat([]byte{0x42}, 32)
+
+// customerKeyHeaders returns the SSE-C headers, starting with prefix, that
+// send key
+func customerKeyHeaders(prefix string, key []byte) http.Header {
+	sum := md5.Sum(key)
+	h := make(http.Header)
+	h.Set(prefix+"algorithm", "AES256")
+	h.Set(prefix+"key", base64.StdEncoding.EncodeToString(key))
+	h.Set(prefix+"key-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	return h
+}
+
+func TestAPIRouter_Encryption(t *testing.T) {
+	router := createStoreTestAPIRouter(t, &config.Config{})
+	router.engine.SetMasterKey(testMasterKey)
+	router.engine.CreateBucket(context.Background(), "test-bucket")
+
+	do := func(method, target, body string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	expect := func(t *testing.T, w *httptest.ResponseRecorder, code int, errCode string) {
+		t.Helper()
+		if w.Code != code || errCode != "" && !strings.Contains(w.Body.String(), "<Code>"+errCode+"</Code>") {
+			t.Errorf("status = %d %s, want %d %s", w.Code, w.Body.String(), code, errCode)
+		}
+	}
+
+	data := strings.Repeat("0123456789", 10000)
+	sse := http.Header{"X-Amz-Server-Side-Encryption": {"AES256"}}
+
+	t.Run("SSES3", func(t *testing.T) {
+		w := do("PUT", "/s3/test-bucket/sse-s3", data, sse)
+		expect(t, w, http.StatusOK, "")
+		if got := w.Header().Get("x-amz-server-side-encryption"); got != "AES256" {
+			t.Errorf("PutObject x-amz-server-side-encryption = %q, want AES256", got)
+		}
+
+		w = do("GET", "/s3/test-bucket/sse-s3", "", nil)
+		if w.Code != http.StatusOK || w.Body.String() != data || w.Header().Get("x-amz-server-side-encryption") != "AES256" {
+			t.Errorf("GetObject = %d, %d bytes, encryption %q", w.Code, w.Body.Len(), w.Header().Get("x-amz-server-side-encryption"))
+		}
+
+		w = do("GET", "/s3/test-bucket/sse-s3", "", http.Header{"Range": {"bytes=65530-65549"}})
+		if w.Code != http.StatusPartialContent || w.Body.String() != data[65530:65550] {
+			t.Errorf("ranged GetObject = %d %q, want 206 %q", w.Code, w.Body.String(), data[65530:65550])
+		}
+
+		expect(t, do("PUT", "/s3/test-bucket/kms", data, http.Header{"X-Amz-Server-Side-Encryption": {"aws:kms:dsse"}}),
+			http.StatusBadRequest, "InvalidEncryptionAlgorithmError")
+	})
+
+	key := bytes.Repeat([]byte{0x07}, 32)
+	ssec := customerKeyHeaders("x-amz-server-side-encryption-customer-", key)
+
+	t.Run("SSEC", func(t *testing.T) {
+		w := do("PUT", "/s3/test-bucket/sse-c", data, ssec)
+		expect(t, w, http.StatusOK, "")
+		if got := w.Header().Get("x-amz-server-side-encryption-customer-key-MD5"); got != ssec.Get("x-amz-server-side-encryption-customer-key-MD5") {
+			t.Errorf("PutObject customer key MD5 = %q, want %q", got, ssec.Get("x-amz-server-side-encryption-customer-key-MD5"))
+		}
+
+		w = do("GET", "/s3/test-bucket/sse-c", "", ssec)
+		if w.Code != http.StatusOK || w.Body.String() != data {
+			t.Errorf("GetObject with key = %d, %d bytes", w.Code, w.Body.Len())
+		}
+		expect(t, do("HEAD", "/s3/test-bucket/sse-c", "", ssec), http.StatusOK, "")
+
+		expect(t, do("GET", "/s3/test-bucket/sse-c", "", nil), http.StatusBadRequest, "InvalidRequest")
+		expect(t, do("HEAD", "/s3/test-bucket/sse-c", "", nil), http.StatusBadRequest, "")
+		wrong := customerKeyHeaders("x-amz-server-side-encryption-customer-", bytes.Repeat([]byte{0x08}, 32))
+		expect(t, do("GET", "/s3/test-bucket/sse-c", "", wrong), http.StatusForbidden, "AccessDenied")
+		expect(t, do("GET", "/s3/test-bucket/sse-s3", "", ssec), http.StatusBadRequest, "InvalidRequest")
+
+		badMD5 := customerKeyHeaders("x-amz-server-side-encryption-customer-", key)
+		badMD5.Set("x-amz-server-side-encryption-customer-key-MD5", base64.StdEncoding.EncodeToString(make([]byte, 16)))
+		expect(t, do("PUT", "/s3/test-bucket/bad", data, badMD5), http.StatusBadRequest, "InvalidArgument")
+
+		both := customerKeyHeaders("x-amz-server-side-encryption-customer-", key)
+		both.Set("x-amz-server-side-encryption", "AES256")
+		expect(t, do("PUT", "/s3/test-bucket/bad", data, both), http.StatusBadRequest, "InvalidArgument")
+	})
+
+	t.Run("Copy", func(t *testing.T) {
+		copyHeader := http.Header{"X-Amz-Copy-Source": {"test-bucket/sse-c"}}
+		expect(t, do("PUT", "/s3/test-bucket/copy", "", copyHeader), http.StatusBadRequest, "InvalidRequest")
+
+		for k, v := range customerKeyHeaders("x-amz-copy-source-server-side-encryption-customer-", key) {
+			copyHeader[k] = v
+		}
+		copyHeader.Set("x-amz-server-side-encryption", "AES256")
+		w := do("PUT", "/s3/test-bucket/copy", "", copyHeader)
+		expect(t, w, http.StatusOK, "")
+		if got := w.Header().Get("x-amz-server-side-encryption"); got != "AES256" {
+			t.Errorf("CopyObject x-amz-server-side-encryption = %q, want AES256", got)
+		}
+		if w := do("GET", "/s3/test-bucket/copy", "", nil); w.Body.String() != data {
+			t.Errorf("GetObject of copy = %d bytes, want %d", w.Body.Len(), len(data))
+		}
+	})
+
+	t.Run("BucketDefault", func(t *testing.T) {
+		config := `<ServerSideEncryptionConfiguration><Rule><ApplyServerSideEncryptionByDefault><SSEAlgorithm>AES256</SSEAlgorithm></ApplyServerSideEncryptionByDefault></Rule></ServerSideEncryptionConfiguration>`
+		expect(t, do("PUT", "/s3/test-bucket?encryption", config, nil), http.StatusOK, "")
+		if w := do("GET", "/s3/test-bucket?encryption", "", nil); !strings.Contains(w.Body.String(), "<Rule><ApplyServerSideEncryptionByDefault><SSEAlgorithm>AES256</SSEAlgorithm>") {
+			t.Errorf("GetBucketEncryption = %s, want the AES256 rule", w.Body.String())
+		}
+
+		w := do("PUT", "/s3/test-bucket/default", data, nil)
+		if got := w.Header().Get("x-amz-server-side-encryption"); got != "AES256" {
+			t.Errorf("PutObject with bucket default x-amz-server-side-encryption = %q, want AES256", got)
+		}
+
+		expect(t, do("PUT", "/s3/test-bucket?encryption", `<ServerSideEncryptionConfiguration></ServerSideEncryptionConfiguration>`, nil),
+			http.StatusBadRequest, "MalformedXML")
+		expect(t, do("PUT", "/s3/test-bucket?encryption", strings.Replace(config, "AES256", "DES", 1), nil),
+			http.StatusBadRequest, "MalformedXML")
+	})
+}
+
+func TestAPIRouter_EncryptionNotConfigured(t *testing.T) {
+	router := createStoreTestAPIRouter(t, &config.Config{})
+	router.engine.CreateBucket(context.Background(), "test-bucket")
+
+	req := httptest.NewRequest("PUT", "/s3/test-bucket/key", strings.NewReader("data"))
+	req.Header.Set("x-amz-server-side-encryption", "AES256")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "<Code>InvalidRequest</Code>") {
+		t.Errorf("PutObject with SSE-S3 and no master key = %d %s, want 400 InvalidRequest", w.Code, w.Body.String())
+	}
+}
+
+func TestAPIRouter_EncryptionKMS(t *testing.T) {
+	router := createStoreTestAPIRouter(t, &config.Config{})
+	keyring, err := encryption.OpenLocalKeyring(filepath.Join(t.TempDir(), "keyring"), testMasterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	keyring.CreateKey(ctx, "app", "")
+	keyring.CreateKey(ctx, "other", "")
+	router.engine.SetKeyProvider(keyring)
+	router.engine.CreateBucket(ctx, "test-bucket")
+
+	// An IAM user allowed to use only the "app" key
+	manager := iam.NewManager(zap.NewNop())
+	user, _ := manager.CreateUser("tenant", "alice", "")
+	accessKey, _ := manager.CreateAccessKey(user.ID)
+	policy, _ := manager.CreatePolicy("tenant", "app-key", iam.PolicyDoc{Statement: []iam.Statement{{
+		Effect:    "Allow",
+		Actions:   []string{engine.KMSActionGenerateDataKey, engine.KMSActionDecrypt},
+		Resources: []string{encryption.KeyARN("app")},
+	}}})
+	manager.AttachPolicy(policy.ID, user.ID, "user")
+
+	// An IAM user allowed every key but denied the "other" key
+	bob, _ := manager.CreateUser("tenant", "bob", "")
+	bobKey, _ := manager.CreateAccessKey(bob.ID)
+	policy, _ = manager.CreatePolicy("tenant", "all-but-other", iam.PolicyDoc{Statement: []iam.Statement{
+		{Effect: "Allow", Actions: []string{"kms:*"}, Resources: []string{"*"}},
+		{Effect: "Deny", Actions: []string{engine.KMSActionGenerateDataKey, engine.KMSActionDecrypt}, Resources: []string{encryption.KeyARN("other")}},
+	}})
+	manager.AttachPolicy(policy.ID, bob.ID, "user")
+	router.SetIAM(manager)
+	signedBy := "AWS4-HMAC-SHA256 Credential=" + accessKey.ID + "/20240101/us-east-1/s3/aws4_request, SignedHeaders=host, Signature=0"
+	signedByBob := "AWS4-HMAC-SHA256 Credential=" + bobKey.ID + "/20240101/us-east-1/s3/aws4_request, SignedHeaders=host, Signature=0"
+
+	do := func(method, target, body string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	expect := func(t *testing.T, w *httptest.ResponseRecorder, code int, errCode string) {
+		t.Helper()
+		if w.Code != code || errCode != "" && !strings.Contains(w.Body.String(), "<Code>"+errCode+"</Code>") {
+			t.Errorf("status = %d %s, want %d %s", w.Code, w.Body.String(), code, errCode)
+		}
+	}
+	kms := func(keyID string) http.Header {
+		h := http.Header{"X-Amz-Server-Side-Encryption": {"aws:kms"}}
+		if keyID != "" {
+			h.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", keyID)
+		}
+		return h
+	}
+
+	t.Run("PutGet", func(t *testing.T) {
+		w := do("PUT", "/s3/test-bucket/kms", "secret data", kms(encryption.KeyARN("app")))
+		expect(t, w, http.StatusOK, "")
+		if w.Header().Get("x-amz-server-side-encryption") != "aws:kms" ||
+			w.Header().Get("x-amz-server-side-encryption-aws-kms-key-id") != encryption.KeyARN("app") {
+			t.Errorf("PutObject encryption headers = %v", w.Header())
+		}
+
+		w = do("GET", "/s3/test-bucket/kms", "", nil)
+		if w.Code != http.StatusOK || w.Body.String() != "secret data" ||
+			w.Header().Get("x-amz-server-side-encryption-aws-kms-key-id") != encryption.KeyARN("app") {
+			t.Errorf("GetObject = %d %q, headers %v", w.Code, w.Body.String(), w.Header())
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		expect(t, do("PUT", "/s3/test-bucket/k", "x", kms("")), http.StatusBadRequest, "InvalidRequest")
+		expect(t, do("PUT", "/s3/test-bucket/k", "x", kms("missing")), http.StatusBadRequest, "KMS.NotFoundException")
+		expect(t, do("PUT", "/s3/test-bucket/k", "x", http.Header{
+			"X-Amz-Server-Side-Encryption":                {"AES256"},
+			"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": {"app"},
+		}), http.StatusBadRequest, "InvalidArgument")
+
+		keyring.DisableKey(ctx, "app")
+		expect(t, do("GET", "/s3/test-bucket/kms", "", nil), http.StatusBadRequest, "KMS.DisabledException")
+		keyring.EnableKey(ctx, "app")
+	})
+
+	t.Run("KeyPermissions", func(t *testing.T) {
+		h := kms("app")
+		h.Set("Authorization", signedBy)
+		expect(t, do("PUT", "/s3/test-bucket/alice", "x", h), http.StatusOK, "")
+		expect(t, do("GET", "/s3/test-bucket/kms", "", http.Header{"Authorization": {signedBy}}), http.StatusOK, "")
+
+		h = kms("other")
+		h.Set("Authorization", signedBy)
+		expect(t, do("PUT", "/s3/test-bucket/alice", "x", h), http.StatusForbidden, "AccessDenied")
+
+		do("PUT", "/s3/test-bucket/other", "x", kms("other"))
+		expect(t, do("GET", "/s3/test-bucket/other", "", http.Header{"Authorization": {signedBy}}), http.StatusForbidden, "AccessDenied")
+	})
+
+	t.Run("KeyDeny", func(t *testing.T) {
+		h := kms("app")
+		h.Set("Authorization", signedByBob)
+		expect(t, do("PUT", "/s3/test-bucket/bob", "x", h), http.StatusOK, "")
+
+		// A Deny on the key's ARN wins over the wildcard Allow
+		h = kms("other")
+		h.Set("Authorization", signedByBob)
+		expect(t, do("PUT", "/s3/test-bucket/bob", "x", h), http.StatusForbidden, "AccessDenied")
+		expect(t, do("GET", "/s3/test-bucket/other", "", http.Header{"Authorization": {signedByBob}}), http.StatusForbidden, "AccessDenied")
+	})
+
+	t.Run("BucketDefault", func(t *testing.T) {
+		body := `<ServerSideEncryptionConfiguration><Rule><ApplyServerSideEncryptionByDefault>` +
+			`<SSEAlgorithm>aws:kms</SSEAlgorithm><KMSMasterKeyID>%s</KMSMasterKeyID>` +
+			`</ApplyServerSideEncryptionByDefault></Rule></ServerSideEncryptionConfiguration>`
+		expect(t, do("PUT", "/s3/test-bucket?encryption", fmt.Sprintf(body, "missing"), nil), http.StatusBadRequest, "KMS.NotFoundException")
+		expect(t, do("PUT", "/s3/test-bucket?encryption", fmt.Sprintf(body, encryption.KeyARN("app")), nil), http.StatusOK, "")
+
+		w := do("PUT", "/s3/test-bucket/default", "x", nil)
+		expect(t, w, http.StatusOK, "")
+		if w.Header().Get("x-amz-server-side-encryption-aws-kms-key-id") != encryption.KeyARN("app") {
+			t.Errorf("PutObject under a KMS bucket default, headers %v", w.Header())
+		}
+	})
+}
+
+func TestAPIRouter_EncryptionKMSAnonymous(t *testing.T) {
+	router := createStoreTestAPIRouter(t, &config.Config{
+		Auth: config.AuthConfig{AccessKey: "owner-key", SecretKey: "owner-secret"},
+	})
+	keyring, err := encryption.OpenLocalKeyring(filepath.Join(t.TempDir(), "keyring"), testMasterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	keyring.CreateKey(ctx, "app", "")
+	router.engine.SetKeyProvider(keyring)
+	router.engine.CreateBucket(ctx, "test-bucket")
+	policy := `{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": ["s3:PutObject", "s3:GetObject"],
+		"Resource": "arn:aws:s3:::test-bucket/*"}]}`
+	router.engine.PutBucketPolicy(ctx, "test-bucket", &policy)
+
+	put := func(key string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/s3/test-bucket/"+key, strings.NewReader("data"))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The bucket policy admits anonymous writes, but not with a KMS key
+	if w := put("plain", nil); w.Code != http.StatusOK {
+		t.Fatalf("anonymous PutObject = %d %s, want 200", w.Code, w.Body.String())
+	}
+	w := put("kms", http.Header{
+		"X-Amz-Server-Side-Encryption":                {"aws:kms"},
+		"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": {"app"},
+	})
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "<Code>AccessDenied</Code>") {
+		t.Errorf("anonymous PutObject with a KMS key = %d %s, want 403 AccessDenied", w.Code, w.Body.String())
+	}
+}
@@ -133,6 +133,18 @@ var (
 		statusCode: 403,
 	}
 
+	ErrInvalidAccessKeyID = &s3Error{
+		code:       "InvalidAccessKeyId",
+		message:    "The AWS access key ID you provided does not exist in our records.",
+		statusCode: 403,
+	}
+
+	ErrRequestTimeTooSkewed = &s3Error{
+		code:       "RequestTimeTooSkewed",
+		message:    "The difference between the request time and the server's time is too large.",
+		statusCode: 403,
+	}
+
 	ErrMalformedXML = &s3Error{
 		code:       "MalformedXML",
 		message:    "The XML you provided was not well-formed or did not validate against our published schema.",
@@ -145,6 +157,66 @@ var (
 		statusCode: 411,
 	}
 
+	ErrIncompleteBody = &s3Error{
+		code:       "IncompleteBody",
+		message:    "You did not provide the number of bytes specified by the Content-Length HTTP header.",
+		statusCode: 400,
+	}
+
+	ErrBadDigest = &s3Error{
+		code:       "BadDigest",
+		message:    "The Content-MD5 or checksum value that you specified did not match what the server received.",
+		statusCode: 400,
+	}
+
+	ErrInvalidDigest = &s3Error{
+		code:       "InvalidDigest",
+		message:    "The Content-MD5 you specified is not valid.",
+		statusCode: 400,
+	}
+
+	ErrXAmzContentSHA256Mismatch = &s3Error{
+		code:       "XAmzContentSHA256Mismatch",
+		message:    "The provided 'x-amz-content-sha256' header does not match what was computed.",
+		statusCode: 400,
+	}
+
+	ErrMalformedTrailer = &s3Error{
+		code:       "MalformedTrailerError",
+		message:    "The request contained trailing data that was not well-formed or did not conform to our published schema.",
+		statusCode: 400,
+	}
+
+	ErrInvalidChecksum = &s3Error{
+		code:       "InvalidRequest",
+		message:    "The checksum algorithm or value specified is not valid for this request.",
+		statusCode: 400,
+	}
+
+	ErrInvalidPolicyDocument = &s3Error{
+		code:       "InvalidPolicyDocument",
+		message:    "The content of the form does not meet the conditions specified in the policy document.",
+		statusCode: 400,
+	}
+
+	ErrMalformedPOSTRequest = &s3Error{
+		code:       "MalformedPOSTRequest",
+		message:    "The body of your POST request is not well-formed multipart/form-data.",
+		statusCode: 400,
+	}
+
+	ErrMaxPostPreDataLengthExceeded = &s3Error{
+		code:       "MaxPostPreDataLengthExceededError",
+		message:    "Your POST request fields preceding the upload file were too large.",
+		statusCode: 400,
+	}
+
+	ErrIncorrectNumberOfFilesInPostRequest = &s3Error{
+		code:       "IncorrectNumberOfFilesInPostRequest",
+		message:    "POST requires exactly one file upload per request.",
+		statusCode: 400,
+	}
+
 	ErrInvalidContentLength = &s3Error{
 		code:       "InvalidContentLength",
 		message:    "The Content-Length HTTP header was not specified or is invalid.",
@@ -157,6 +229,18 @@ var (
 		statusCode: 412,
 	}
 
+	ErrInvalidContinuationToken = &s3Error{
+		code:       "InvalidArgument",
+		message:    "The continuation token provided is incorrect",
+		statusCode: 400,
+	}
+
+	ErrInvalidRange = &s3Error{
+		code:       "InvalidRange",
+		message:    "The requested range is not satisfiable",
+		statusCode: 416,
+	}
+
 	ErrNotImplemented = &s3Error{
 		code:       "NotImplemented",
 		message:    "A header you provided implies functionality that is not implemented.",
@@ -199,6 +283,24 @@ var (
 		statusCode: 400,
 	}
 
+	ErrInvalidPart = &s3Error{
+		code:       "InvalidPart",
+		message:    "One or more of the specified parts could not be found. The part might not have been uploaded, or the specified entity tag might not have matched the part's entity tag.",
+		statusCode: 400,
+	}
+
+	ErrInvalidPartOrder = &s3Error{
+		code:       "InvalidPartOrder",
+		message:    "The list of parts was not in ascending order. Parts must be ordered by part number.",
+		statusCode: 400,
+	}
+
+	ErrMetadataTooLarge = &s3Error{
+		code:       "MetadataTooLarge",
+		message:    "Your metadata headers exceed the maximum allowed metadata size.",
+		statusCode: 400,
+	}
+
 	ErrEntityTooLarge = &s3Error{
 		code:       "EntityTooLarge",
 		message:    "Your proposed upload exceeds the maximum allowed object size.",
@@ -252,4 +354,82 @@ var (
 		message:    "The specified bucket website configuration does not exist.",
 		statusCode: 404,
 	}
+
+	ErrObjectLocked = &s3Error{
+		code:       "AccessDenied",
+		message:    "Access Denied because object protected by object lock.",
+		statusCode: 403,
+	}
+
+	ErrInvalidBucketState = &s3Error{
+		code:       "InvalidBucketState",
+		message:    "Object Lock requires versioning to be enabled on the bucket.",
+		statusCode: 409,
+	}
+
+	ErrInvalidEncryptionAlgorithm = &s3Error{
+		code:       "InvalidEncryptionAlgorithmError",
+		message:    "The encryption request you specified is not valid. The valid value is AES256.",
+		statusCode: 400,
+	}
+
+	ErrInvalidCustomerKey = &s3Error{
+		code:       "InvalidArgument",
+		message:    "The customer encryption key or its MD5 is not valid.",
+		statusCode: 400,
+	}
+
+	ErrCustomerKeyRequired = &s3Error{
+		code:       "InvalidRequest",
+		message:    "The object was stored using a form of Server Side Encryption. The correct parameters must be provided to retrieve the object.",
+		statusCode: 400,
+	}
+
+	ErrCustomerKeyMismatch = &s3Error{
+		code:       "AccessDenied",
+		message:    "The customer encryption key does not match the key the object was encrypted with.",
+		statusCode: 403,
+	}
+
+	ErrEncryptionNotApplicable = &s3Error{
+		code:       "InvalidRequest",
+		message:    "The encryption parameters are not applicable to this object.",
+		statusCode: 400,
+	}
+
+	ErrEncryptionNotConfigured = &s3Error{
+		code:       "InvalidRequest",
+		message:    "Server-side encryption is not configured on this server.",
+		statusCode: 400,
+	}
+
+	ErrKMSAccessDenied = &s3Error{
+		code:       "AccessDenied",
+		message:    "You are not authorized to use the KMS key.",
+		statusCode: 403,
+	}
+
+	ErrKMSNotFound = &s3Error{
+		code:       "KMS.NotFoundException",
+		message:    "The specified KMS key does not exist.",
+		statusCode: 400,
+	}
+
+	ErrKMSDisabled = &s3Error{
+		code:       "KMS.DisabledException",
+		message:    "The specified KMS key is disabled.",
+		statusCode: 400,
+	}
+
+	ErrMalformedPolicy = &s3Error{
+		code:       "MalformedPolicy",
+		message:    "The policy is not valid.",
+		statusCode: 400,
+	}
+
+	ErrInvalidTag = &s3Error{
+		code:       "InvalidTag",
+		message:    "The tag set is not valid.",
+		statusCode: 400,
+	}
 )
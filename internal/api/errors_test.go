@@ -54,12 +54,26 @@ func TestAllS3ErrorsComplete(t *testing.T) {
 		{"InvalidBucketName", ErrInvalidBucketName, "InvalidBucketName", http.StatusBadRequest, "The specified bucket name is invalid."},
 		{"InvalidObjectName", ErrInvalidObjectName, "InvalidObjectName", http.StatusBadRequest, "The specified object name is invalid."},
 		{"InvalidArgument", ErrInvalidArgument, "InvalidArgument", http.StatusBadRequest, "An invalid argument was specified."},
+		{"InvalidContinuationToken", ErrInvalidContinuationToken, "InvalidArgument", http.StatusBadRequest, "The continuation token provided is incorrect"},
 		{"AccessDenied", ErrAccessDenied, "AccessDenied", http.StatusForbidden, "Access Denied."},
 		{"SignatureDoesNotMatch", ErrSignatureDoesNotMatch, "SignatureDoesNotMatch", http.StatusForbidden, "The request signature we calculated does not match the signature you provided."},
 		{"MalformedXML", ErrMalformedXML, "MalformedXML", http.StatusBadRequest, "The XML you provided was not well-formed or did not validate against our published schema."},
+		{"InvalidAccessKeyId", ErrInvalidAccessKeyID, "InvalidAccessKeyId", http.StatusForbidden, "The AWS access key ID you provided does not exist in our records."},
+		{"RequestTimeTooSkewed", ErrRequestTimeTooSkewed, "RequestTimeTooSkewed", http.StatusForbidden, "The difference between the request time and the server's time is too large."},
 		{"MissingContentLength", ErrMissingContentLength, "MissingContentLength", http.StatusLengthRequired, "You must provide the Content-Length HTTP header."},
+		{"IncompleteBody", ErrIncompleteBody, "IncompleteBody", http.StatusBadRequest, "You did not provide the number of bytes specified by the Content-Length HTTP header."},
+		{"BadDigest", ErrBadDigest, "BadDigest", http.StatusBadRequest, "The Content-MD5 or checksum value that you specified did not match what the server received."},
+		{"InvalidDigest", ErrInvalidDigest, "InvalidDigest", http.StatusBadRequest, "The Content-MD5 you specified is not valid."},
+		{"XAmzContentSHA256Mismatch", ErrXAmzContentSHA256Mismatch, "XAmzContentSHA256Mismatch", http.StatusBadRequest, "The provided 'x-amz-content-sha256' header does not match what was computed."},
+		{"MalformedTrailer", ErrMalformedTrailer, "MalformedTrailerError", http.StatusBadRequest, "The request contained trailing data that was not well-formed or did not conform to our published schema."},
+		{"InvalidChecksum", ErrInvalidChecksum, "InvalidRequest", http.StatusBadRequest, "The checksum algorithm or value specified is not valid for this request."},
+		{"InvalidPolicyDocument", ErrInvalidPolicyDocument, "InvalidPolicyDocument", http.StatusBadRequest, "The content of the form does not meet the conditions specified in the policy document."},
+		{"MalformedPOSTRequest", ErrMalformedPOSTRequest, "MalformedPOSTRequest", http.StatusBadRequest, "The body of your POST request is not well-formed multipart/form-data."},
+		{"MaxPostPreDataLengthExceeded", ErrMaxPostPreDataLengthExceeded, "MaxPostPreDataLengthExceededError", http.StatusBadRequest, "Your POST request fields preceding the upload file were too large."},
+		{"IncorrectNumberOfFilesInPostRequest", ErrIncorrectNumberOfFilesInPostRequest, "IncorrectNumberOfFilesInPostRequest", http.StatusBadRequest, "POST requires exactly one file upload per request."},
 		{"InvalidContentLength", ErrInvalidContentLength, "InvalidContentLength", http.StatusBadRequest, "The Content-Length HTTP header was not specified or is invalid."},
 		{"PreconditionFailed", ErrPreconditionFailed, "PreconditionFailed", http.StatusPreconditionFailed, "At least one of the preconditions you specified did not hold."},
+		{"InvalidRange", ErrInvalidRange, "InvalidRange", http.StatusRequestedRangeNotSatisfiable, "The requested range is not satisfiable"},
 		{"NotImplemented", ErrNotImplemented, "NotImplemented", http.StatusNotImplemented, "A header you provided implies functionality that is not implemented."},
 		{"TooManyBuckets", ErrTooManyBuckets, "TooManyBuckets", http.StatusBadRequest, "You have attempted to create more buckets than allowed."},
 		{"BucketAlreadyExists", ErrBucketAlreadyExists, "BucketAlreadyExists", http.StatusConflict, "The bucket you tried to create already exists."},
@@ -67,6 +81,9 @@ func TestAllS3ErrorsComplete(t *testing.T) {
 		{"MaxMessageLengthExceeded", ErrMaxMessageLengthExceeded, "MaxMessageLengthExceeded", http.StatusBadRequest, "Your request was too large."},
 		{"MaxUploadLengthExceeded", ErrMaxUploadLengthExceeded, "MaxUploadLengthExceeded", http.StatusBadRequest, "Your upload exceeds the maximum allowed object size."},
 		{"EntityTooSmall", ErrEntityTooSmall, "EntityTooSmall", http.StatusBadRequest, "Your proposed upload is smaller than the minimum allowed object size."},
+		{"InvalidPart", ErrInvalidPart, "InvalidPart", http.StatusBadRequest, "One or more of the specified parts could not be found. The part might not have been uploaded, or the specified entity tag might not have matched the part's entity tag."},
+		{"InvalidPartOrder", ErrInvalidPartOrder, "InvalidPartOrder", http.StatusBadRequest, "The list of parts was not in ascending order. Parts must be ordered by part number."},
+		{"MetadataTooLarge", ErrMetadataTooLarge, "MetadataTooLarge", http.StatusBadRequest, "Your metadata headers exceed the maximum allowed metadata size."},
 		{"EntityTooLarge", ErrEntityTooLarge, "EntityTooLarge", http.StatusBadRequest, "Your proposed upload exceeds the maximum allowed object size."},
 		{"InvalidRequest", ErrInvalidRequest, "InvalidRequest", http.StatusBadRequest, "The request is invalid."},
 		{"InvalidAccelerateConfiguration", ErrInvalidAccelerateConfiguration, "InvalidAccelerateConfiguration", http.StatusBadRequest, "The accelerate configuration is invalid."},
@@ -76,6 +93,19 @@ func TestAllS3ErrorsComplete(t *testing.T) {
 		{"PresignedURLNotFound", ErrPresignedURLNotFound, "PresignedURLNotFoundError", http.StatusNotFound, "The specified presigned URL does not exist."},
 		{"InvalidPresignedURL", ErrInvalidPresignedURL, "InvalidPresignedURL", http.StatusBadRequest, "The presigned URL is invalid."},
 		{"WebsiteNotFound", ErrWebsiteNotFound, "NoSuchWebsiteConfiguration", http.StatusNotFound, "The specified bucket website configuration does not exist."},
+		{"ObjectLocked", ErrObjectLocked, "AccessDenied", http.StatusForbidden, "Access Denied because object protected by object lock."},
+		{"InvalidBucketState", ErrInvalidBucketState, "InvalidBucketState", http.StatusConflict, "Object Lock requires versioning to be enabled on the bucket."},
+		{"InvalidEncryptionAlgorithm", ErrInvalidEncryptionAlgorithm, "InvalidEncryptionAlgorithmError", http.StatusBadRequest, "The encryption request you specified is not valid. The valid value is AES256."},
+		{"InvalidCustomerKey", ErrInvalidCustomerKey, "InvalidArgument", http.StatusBadRequest, "The customer encryption key or its MD5 is not valid."},
+		{"CustomerKeyRequired", ErrCustomerKeyRequired, "InvalidRequest", http.StatusBadRequest, "The object was stored using a form of Server Side Encryption. The correct parameters must be provided to retrieve the object."},
+		{"CustomerKeyMismatch", ErrCustomerKeyMismatch, "AccessDenied", http.StatusForbidden, "The customer encryption key does not match the key the object was encrypted with."},
+		{"EncryptionNotApplicable", ErrEncryptionNotApplicable, "InvalidRequest", http.StatusBadRequest, "The encryption parameters are not applicable to this object."},
+		{"EncryptionNotConfigured", ErrEncryptionNotConfigured, "InvalidRequest", http.StatusBadRequest, "Server-side encryption is not configured on this server."},
+		{"KMSAccessDenied", ErrKMSAccessDenied, "AccessDenied", http.StatusForbidden, "You are not authorized to use the KMS key."},
+		{"KMSNotFound", ErrKMSNotFound, "KMS.NotFoundException", http.StatusBadRequest, "The specified KMS key does not exist."},
+		{"KMSDisabled", ErrKMSDisabled, "KMS.DisabledException", http.StatusBadRequest, "The specified KMS key is disabled."},
+		{"MalformedPolicy", ErrMalformedPolicy, "MalformedPolicy", http.StatusBadRequest, "The policy is not valid."},
+		{"InvalidTag", ErrInvalidTag, "InvalidTag", http.StatusBadRequest, "The tag set is not valid."},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/openendpoint/openendpoint/internal/engine"
+)
+
+// userMetadataPrefix marks user-defined metadata headers
+const userMetadataPrefix = "x-amz-meta-"
+
+// maxUserMetadataSize is the S3 limit on the combined size of user metadata
+// keys and values
+const maxUserMetadataSize = 2048
+
+// responseOverrides maps the GET query parameters that override stored
+// headers to the header they replace
+var responseOverrides = map[string]string{
+	"response-content-type":        "Content-Type",
+	"response-content-language":    "Content-Language",
+	"response-expires":             "Expires",
+	"response-cache-control":       "Cache-Control",
+	"response-content-disposition": "Content-Disposition",
+	"response-content-encoding":    "Content-Encoding",
+}
+
+// objectHeaders are the standard headers and user metadata stored with an
+// object and returned on GET and HEAD
+type objectHeaders struct {
+	ContentType        string
+	ContentEncoding    string
+	CacheControl       string
+	ContentDisposition string
+	ContentLanguage    string
+	Expires            int64
+	Metadata           map[string]string
+}
+
+// parseObjectHeaders reads the standard headers and x-amz-meta-* user
+// metadata of a write request into put options. User metadata keys are
+// lowercased and stored without the prefix.
+func parseObjectHeaders(req *http.Request) (engine.PutObjectOptions, S3Error) {
+	opts := engine.PutObjectOptions{
+		ContentType:        req.Header.Get("Content-Type"),
+		ContentEncoding:    storedContentEncoding(req.Header.Get("Content-Encoding")),
+		CacheControl:       req.Header.Get("Cache-Control"),
+		ContentDisposition: req.Header.Get("Content-Disposition"),
+		ContentLanguage:    req.Header.Get("Content-Language"),
+	}
+
+	// An unparseable Expires is dropped, as HTTP caches treat it as expired
+	if expires := req.Header.Get("Expires"); expires != "" {
+		if t, err := http.ParseTime(expires); err == nil {
+			opts.Expires = t.Unix()
+		}
+	}
+
+	size := 0
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if !strings.HasPrefix(lower, userMetadataPrefix) {
+			continue
+		}
+		key := strings.TrimPrefix(lower, userMetadataPrefix)
+		value := strings.Join(values, ",")
+		size += len(key) + len(value)
+		if size > maxUserMetadataSize {
+			return engine.PutObjectOptions{}, ErrMetadataTooLarge
+		}
+		if opts.Metadata == nil {
+			opts.Metadata = make(map[string]string)
+		}
+		opts.Metadata[key] = value
+	}
+
+	return opts, nil
+}
+
+// write sets the stored headers of an object on a GET or HEAD response
+func (h objectHeaders) write(w http.ResponseWriter) {
+	contentType := h.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", sanitizeHeaderValue(contentType))
+
+	for name, value := range map[string]string{
+		"Content-Encoding":    h.ContentEncoding,
+		"Cache-Control":       h.CacheControl,
+		"Content-Disposition": h.ContentDisposition,
+		"Content-Language":    h.ContentLanguage,
+	} {
+		if value != "" {
+			w.Header().Set(name, sanitizeHeaderValue(value))
+		}
+	}
+	if h.Expires != 0 {
+		w.Header().Set("Expires", time.Unix(h.Expires, 0).UTC().Format(http.TimeFormat))
+	}
+
+	for key, value := range h.Metadata {
+		w.Header().Set(userMetadataPrefix+sanitizeHeaderValue(key), sanitizeHeaderValue(value))
+	}
+}
+
+// applyResponseOverrides replaces stored headers with the response-*
+// query parameters of a GET, as used by presigned downloads
+func applyResponseOverrides(w http.ResponseWriter, query url.Values) {
+	for param, header := range responseOverrides {
+		if value := query.Get(param); value != "" {
+			w.Header().Set(header, sanitizeHeaderValue(value))
+		}
+	}
+}
+
+// copyMetadataDirective reports whether a CopyObject request replaces the
+// source's metadata, and false for ok when the directive is not recognised
+func copyMetadataDirective(req *http.Request) (replace, ok bool) {
+	switch req.Header.Get("x-amz-metadata-directive") {
+	case "", "COPY":
+		return false, true
+	case "REPLACE":
+		return true, true
+	default:
+		return false, false
+	}
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+)
+
+// maxListKeys is the most keys a single list request returns
+const maxListKeys = 1000
+
+// errEmptyContinuationToken is returned for a token that encodes no key
+var errEmptyContinuationToken = errors.New("empty continuation token")
+
+// listParams are the query parameters of a ListObjects or ListObjectsV2
+// request
+type listParams struct {
+	v2                bool
+	prefix            string
+	delimiter         string
+	maxKeys           int
+	encodingType      string
+	marker            string // key to list after, from any of the markers below
+	startAfter        string
+	continuationToken string
+	fetchOwner        bool
+}
+
+// parseListParams reads and validates the query of a list request
+func parseListParams(query url.Values) (*listParams, *s3Error) {
+	p := &listParams{
+		v2:           query.Get("list-type") == "2",
+		prefix:       query.Get("prefix"),
+		delimiter:    query.Get("delimiter"),
+		maxKeys:      maxListKeys,
+		encodingType: query.Get("encoding-type"),
+	}
+
+	if p.encodingType != "" && p.encodingType != "url" {
+		return nil, ErrInvalidArgument
+	}
+
+	if s := query.Get("max-keys"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, ErrInvalidArgument
+		}
+		if n < maxListKeys {
+			p.maxKeys = n
+		}
+	}
+
+	if !p.v2 {
+		p.marker = query.Get("marker")
+		return p, nil
+	}
+
+	p.fetchOwner = query.Get("fetch-owner") == "true"
+	p.startAfter = query.Get("start-after")
+	p.marker = p.startAfter
+	// A continuation token resumes a listing and overrides start-after
+	if query.Has("continuation-token") {
+		p.continuationToken = query.Get("continuation-token")
+		marker, err := decodeContinuationToken(p.continuationToken)
+		if err != nil {
+			return nil, ErrInvalidContinuationToken
+		}
+		p.marker = marker
+	}
+	return p, nil
+}
+
+// encode applies the requested encoding-type to a key or prefix
+func (p *listParams) encode(s string) string {
+	if p.encodingType == "url" {
+		return url.QueryEscape(s)
+	}
+	return s
+}
+
+// encodeContinuationToken returns the opaque token that resumes a listing
+// after marker, the last key or common prefix of the previous page
+func encodeContinuationToken(marker string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(marker))
+}
+
+// decodeContinuationToken returns the marker encoded in a continuation token
+func decodeContinuationToken(token string) (string, error) {
+	marker, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", err
+	}
+	if len(marker) == 0 {
+		return "", errEmptyContinuationToken
+	}
+	return string(marker), nil
+}
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/openendpoint/openendpoint/pkg/s3types"
+)
+
+// listTestRouter returns a router over real stores holding a bucket with
+// keys a, b+c, dir/x, dir/y, dir2/z and e
+func listTestRouter(t *testing.T) func(target string) *httptest.ResponseRecorder {
+	t.Helper()
+	router := createVersionedTestAPIRouter(t)
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	do("PUT", "/s3/test-bucket", "")
+	for _, key := range []string{"a", "b%2Bc", "dir/x", "dir/y", "dir2/z", "e"} {
+		if w := do("PUT", "/s3/test-bucket/"+key, "data"); w.Code != http.StatusOK {
+			t.Fatalf("PutObject(%s) status = %d", key, w.Code)
+		}
+	}
+	return func(target string) *httptest.ResponseRecorder {
+		return do("GET", target, "")
+	}
+}
+
+func listEntries(contents []s3types.Object, prefixes []s3types.CommonPrefix) []string {
+	var entries []string
+	for _, o := range contents {
+		entries = append(entries, o.Key)
+	}
+	for _, cp := range prefixes {
+		entries = append(entries, cp.Prefix)
+	}
+	return entries
+}
+
+func TestAPIRouter_ListObjectsV2_ContinuationToken(t *testing.T) {
+	get := listTestRouter(t)
+
+	var pages [][]string
+	target := "/s3/test-bucket?list-type=2&delimiter=/&max-keys=2"
+	for page := 0; page < 5; page++ {
+		w := get(target)
+		if w.Code != http.StatusOK {
+			t.Fatalf("ListObjectsV2() status = %d, body %s", w.Code, w.Body.String())
+		}
+		var res s3types.ListBucketResultV2
+		if err := xml.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if res.KeyCount != len(res.Contents)+len(res.CommonPrefixes) {
+			t.Errorf("KeyCount = %d for %d entries", res.KeyCount, len(res.Contents)+len(res.CommonPrefixes))
+		}
+		if len(res.Contents) > 0 && res.Contents[0].Owner != nil {
+			t.Error("Owner returned without fetch-owner")
+		}
+		pages = append(pages, listEntries(res.Contents, res.CommonPrefixes))
+		if !res.IsTruncated {
+			break
+		}
+		if res.NextContinuationToken == "" || strings.Contains(res.NextContinuationToken, "/") {
+			t.Fatalf("NextContinuationToken = %q", res.NextContinuationToken)
+		}
+		target = "/s3/test-bucket?list-type=2&delimiter=/&max-keys=2&continuation-token=" + url.QueryEscape(res.NextContinuationToken)
+	}
+
+	want := [][]string{{"a", "b+c"}, {"dir/", "dir2/"}, {"e"}}
+	if !reflect.DeepEqual(pages, want) {
+		t.Errorf("pages = %v, want %v", pages, want)
+	}
+}
+
+func TestAPIRouter_ListObjectsV2_Options(t *testing.T) {
+	get := listTestRouter(t)
+
+	list := func(t *testing.T, query string) *s3types.ListBucketResultV2 {
+		t.Helper()
+		w := get("/s3/test-bucket?list-type=2&" + query)
+		if w.Code != http.StatusOK {
+			t.Fatalf("ListObjectsV2(%s) status = %d, body %s", query, w.Code, w.Body.String())
+		}
+		var res s3types.ListBucketResultV2
+		if err := xml.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		return &res
+	}
+
+	t.Run("StartAfter", func(t *testing.T) {
+		res := list(t, "start-after=dir/x")
+		if got := listEntries(res.Contents, nil); !reflect.DeepEqual(got, []string{"dir/y", "dir2/z", "e"}) {
+			t.Errorf("entries = %v", got)
+		}
+		if res.StartAfter != "dir/x" {
+			t.Errorf("StartAfter = %q", res.StartAfter)
+		}
+	})
+
+	t.Run("TokenOverridesStartAfter", func(t *testing.T) {
+		res := list(t, "start-after=a&continuation-token="+encodeContinuationToken("dir2/z"))
+		if got := listEntries(res.Contents, nil); !reflect.DeepEqual(got, []string{"e"}) {
+			t.Errorf("entries = %v", got)
+		}
+	})
+
+	t.Run("FetchOwner", func(t *testing.T) {
+		res := list(t, "fetch-owner=true&max-keys=1")
+		if len(res.Contents) != 1 || res.Contents[0].Owner == nil {
+			t.Errorf("Contents = %+v, want one object with an owner", res.Contents)
+		}
+	})
+
+	t.Run("EncodingType", func(t *testing.T) {
+		res := list(t, "encoding-type=url&prefix=b%2B")
+		if res.EncodingType != "url" || res.Prefix != "b%2B" {
+			t.Errorf("EncodingType = %q, Prefix = %q", res.EncodingType, res.Prefix)
+		}
+		if got := listEntries(res.Contents, nil); !reflect.DeepEqual(got, []string{"b%2Bc"}) {
+			t.Errorf("entries = %v", got)
+		}
+	})
+
+	t.Run("ZeroMaxKeys", func(t *testing.T) {
+		res := list(t, "max-keys=0")
+		if res.KeyCount != 0 || len(res.Contents) != 0 || res.MaxKeys != 0 || res.IsTruncated {
+			t.Errorf("KeyCount = %d, Contents = %d, MaxKeys = %d, IsTruncated = %v", res.KeyCount, len(res.Contents), res.MaxKeys, res.IsTruncated)
+		}
+	})
+
+	t.Run("DefaultMaxKeys", func(t *testing.T) {
+		res := list(t, "")
+		if res.KeyCount != 6 || res.MaxKeys != 1000 || res.IsTruncated {
+			t.Errorf("KeyCount = %d, MaxKeys = %d, IsTruncated = %v", res.KeyCount, res.MaxKeys, res.IsTruncated)
+		}
+	})
+}
+
+func TestAPIRouter_ListObjects_InvalidParams(t *testing.T) {
+	get := listTestRouter(t)
+
+	tests := []struct {
+		name  string
+		query string
+		code  int
+	}{
+		{"BadToken", "list-type=2&continuation-token=!!!", http.StatusBadRequest},
+		{"EmptyToken", "list-type=2&continuation-token=", http.StatusBadRequest},
+		{"BadEncoding", "encoding-type=base64", http.StatusBadRequest},
+		{"BadMaxKeys", "max-keys=ten", http.StatusBadRequest},
+		{"NegativeMaxKeys", "list-type=2&max-keys=-1", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := get("/s3/test-bucket?" + tt.query); w.Code != tt.code {
+				t.Errorf("status = %d, want %d", w.Code, tt.code)
+			}
+		})
+	}
+
+	if w := get("/s3/missing-bucket?list-type=2&max-keys=0"); w.Code != http.StatusNotFound {
+		t.Errorf("missing bucket status = %d, want 404", w.Code)
+	}
+}
+
+func TestAPIRouter_ListObjectsV1_Marker(t *testing.T) {
+	get := listTestRouter(t)
+
+	w := get("/s3/test-bucket?delimiter=/&max-keys=3&marker=a")
+	if w.Code != http.StatusOK {
+		t.Fatalf("ListObjects() status = %d", w.Code)
+	}
+	var res s3types.ListBucketResult
+	if err := xml.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if got := listEntries(res.Contents, res.CommonPrefixes); !reflect.DeepEqual(got, []string{"b+c", "dir/", "dir2/"}) {
+		t.Errorf("entries = %v", got)
+	}
+	if !res.IsTruncated || res.NextMarker != "dir2/" || res.Marker != "a" {
+		t.Errorf("IsTruncated = %v, NextMarker = %q, Marker = %q", res.IsTruncated, res.NextMarker, res.Marker)
+	}
+	if res.Contents[0].Owner == nil {
+		t.Error("ListObjects omitted Owner")
+	}
+
+	// Without a delimiter the client resumes from the last key
+	w = get("/s3/test-bucket?max-keys=1")
+	res = s3types.ListBucketResult{}
+	if err := xml.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !res.IsTruncated || res.NextMarker != "" {
+		t.Errorf("IsTruncated = %v, NextMarker = %q", res.IsTruncated, res.NextMarker)
+	}
+
+	w = get("/s3/test-bucket?max-keys=0")
+	res = s3types.ListBucketResult{}
+	if err := xml.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if len(res.Contents) != 0 || res.IsTruncated {
+		t.Errorf("max-keys=0 Contents = %d, IsTruncated = %v", len(res.Contents), res.IsTruncated)
+	}
+}
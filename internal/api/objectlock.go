@@ -0,0 +1,100 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/openendpoint/openendpoint/internal/engine"
+	"github.com/openendpoint/openendpoint/internal/metadata"
+	"github.com/openendpoint/openendpoint/pkg/s3types"
+)
+
+// bypassGovernanceAction is the permission a caller needs for its
+// x-amz-bypass-governance-retention header to take effect
+const bypassGovernanceAction = "s3:BypassGovernanceRetention"
+
+// bypassGovernance reports whether a request asks to override GOVERNANCE
+// mode retention and its caller is allowed s3:BypassGovernanceRetention
+// on the object
+func (r *Router) bypassGovernance(req *http.Request, bucket, key string) bool {
+	if !strings.EqualFold(req.Header.Get("x-amz-bypass-governance-retention"), "true") {
+		return false
+	}
+	if s3err := r.authorize(req, bucket, key, bypassGovernanceAction); s3err != nil {
+		r.logger.Warnw("governance retention bypass denied", "bucket", bucket, "key", key, "error", s3err.Message())
+		return false
+	}
+	return true
+}
+
+// objectLockErrorToS3 maps an engine object lock error to the S3 error
+// returned to the client
+func objectLockErrorToS3(err error) S3Error {
+	switch {
+	case errors.Is(err, engine.ErrObjectLocked):
+		return ErrObjectLocked
+	case errors.Is(err, engine.ErrNoSuchKey):
+		return ErrNoSuchKey
+	case errors.Is(err, engine.ErrVersioningRequired), errors.Is(err, engine.ErrObjectLockEnabled):
+		return ErrInvalidBucketState
+	case errors.Is(err, engine.ErrInvalidObjectLock):
+		return ErrInvalidRequest
+	default:
+		return ErrInternal
+	}
+}
+
+// parseObjectLockConfig validates a PutObjectLockConfiguration body
+func parseObjectLockConfig(in *s3types.ObjectLockConfiguration) (*metadata.ObjectLockConfig, S3Error) {
+	if in.ObjectLockEnabled != "" && in.ObjectLockEnabled != "Enabled" {
+		return nil, ErrMalformedXML
+	}
+	config := &metadata.ObjectLockConfig{Enabled: in.ObjectLockEnabled == "Enabled"}
+	if in.Rule == nil {
+		return config, nil
+	}
+
+	d := in.Rule.DefaultRetention
+	if d.Mode != engine.RetentionGovernance && d.Mode != engine.RetentionCompliance {
+		return nil, ErrMalformedXML
+	}
+	if d.Days < 0 || d.Years < 0 || (d.Days > 0) == (d.Years > 0) {
+		return nil, ErrInvalidArgument
+	}
+	if !config.Enabled {
+		return nil, ErrInvalidRequest
+	}
+	config.DefaultRetention = &metadata.DefaultRetention{Mode: d.Mode, Days: d.Days, Years: d.Years}
+	return config, nil
+}
+
+// objectLockConfigXML returns the GetObjectLockConfiguration body of config
+func objectLockConfigXML(config *metadata.ObjectLockConfig) *s3types.ObjectLockConfiguration {
+	out := &s3types.ObjectLockConfiguration{}
+	if config == nil || !config.Enabled {
+		return out
+	}
+	out.ObjectLockEnabled = "Enabled"
+	if d := config.DefaultRetention; d != nil {
+		out.Rule = &s3types.ObjectLockRule{DefaultRetention: s3types.DefaultRetention{Mode: d.Mode, Days: d.Days, Years: d.Years}}
+	}
+	return out
+}
+
+// parseObjectRetention validates a PutObjectRetention body. An empty
+// retention removes it; otherwise the date must lie in the future.
+func parseObjectRetention(in *s3types.ObjectRetention) (*metadata.ObjectRetention, S3Error) {
+	if in.Mode == "" && in.RetainUntilDate == "" {
+		return &metadata.ObjectRetention{}, nil
+	}
+	if in.Mode != engine.RetentionGovernance && in.Mode != engine.RetentionCompliance {
+		return nil, ErrMalformedXML
+	}
+	until, err := time.Parse(time.RFC3339, in.RetainUntilDate)
+	if err != nil || !until.After(time.Now()) {
+		return nil, ErrInvalidArgument
+	}
+	return &metadata.ObjectRetention{Mode: in.Mode, RetainUntilDate: until.Unix()}, nil
+}
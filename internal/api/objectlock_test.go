@@ -0,0 +1,149 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openendpoint/openendpoint/internal/config"
+	"github.com/openendpoint/openendpoint/internal/engine"
+	"github.com/openendpoint/openendpoint/internal/metadata"
+)
+
+func TestAPIRouter_ObjectLock(t *testing.T) {
+	router := createStoreTestAPIRouter(t, &config.Config{})
+	ctx := context.Background()
+	router.engine.CreateBucket(ctx, "test-bucket")
+
+	do := func(method, target, body string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	expect := func(t *testing.T, w *httptest.ResponseRecorder, code int, errCode string) {
+		t.Helper()
+		if w.Code != code || errCode != "" && !strings.Contains(w.Body.String(), "<Code>"+errCode+"</Code>") {
+			t.Errorf("status = %d %s, want %d %s", w.Code, w.Body.String(), code, errCode)
+		}
+	}
+
+	lockConfig := `<ObjectLockConfiguration><ObjectLockEnabled>Enabled</ObjectLockEnabled>
+		<Rule><DefaultRetention><Mode>GOVERNANCE</Mode><Days>1</Days></DefaultRetention></Rule></ObjectLockConfiguration>`
+	t.Run("RequiresVersioning", func(t *testing.T) {
+		expect(t, do("PUT", "/s3/test-bucket?object-lock", lockConfig, nil), http.StatusConflict, "InvalidBucketState")
+	})
+
+	t.Run("RetentionRequiresObjectLock", func(t *testing.T) {
+		expect(t, do("PUT", "/s3/test-bucket/unlocked", "data", nil), http.StatusOK, "")
+		until := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+		retention := `<Retention><Mode>GOVERNANCE</Mode><RetainUntilDate>` + until + `</RetainUntilDate></Retention>`
+		expect(t, do("PUT", "/s3/test-bucket/unlocked?retention", retention, nil), http.StatusBadRequest, "InvalidRequest")
+		expect(t, do("PUT", "/s3/test-bucket/unlocked?legal-hold", `<LegalHold><Status>ON</Status></LegalHold>`, nil),
+			http.StatusBadRequest, "InvalidRequest")
+		expect(t, do("DELETE", "/s3/test-bucket/unlocked", "", nil), http.StatusNoContent, "")
+	})
+
+	router.engine.PutBucketVersioning(ctx, "test-bucket", &metadata.BucketVersioning{Status: engine.VersioningEnabled})
+	t.Run("Configuration", func(t *testing.T) {
+		expect(t, do("PUT", "/s3/test-bucket?object-lock", lockConfig, nil), http.StatusOK, "")
+		w := do("GET", "/s3/test-bucket?object-lock", "", nil)
+		if !strings.Contains(w.Body.String(), "<ObjectLockEnabled>Enabled</ObjectLockEnabled><Rule><DefaultRetention><Mode>GOVERNANCE</Mode><Days>1</Days>") {
+			t.Errorf("GetObjectLockConfiguration = %s, want the default retention rule", w.Body.String())
+		}
+		expect(t, do("PUT", "/s3/test-bucket?versioning", `<VersioningConfiguration><Status>Suspended</Status></VersioningConfiguration>`, nil),
+			http.StatusConflict, "InvalidBucketState")
+	})
+
+	t.Run("CannotDisable", func(t *testing.T) {
+		expect(t, do("PUT", "/s3/test-bucket?object-lock", `<ObjectLockConfiguration/>`, nil), http.StatusConflict, "InvalidBucketState")
+		expect(t, do("DELETE", "/s3/test-bucket?object-lock", "", nil), http.StatusConflict, "InvalidBucketState")
+		w := do("GET", "/s3/test-bucket?object-lock", "", nil)
+		if !strings.Contains(w.Body.String(), "<ObjectLockEnabled>Enabled</ObjectLockEnabled>") {
+			t.Errorf("GetObjectLockConfiguration = %s, want object lock still enabled", w.Body.String())
+		}
+	})
+
+	result, _ := router.engine.PutObject(ctx, "test-bucket", "locked.txt", bytes.NewBufferString("data"), engine.PutObjectOptions{})
+	version := "/s3/test-bucket/locked.txt?versionId=" + result.VersionID
+	bypass := http.Header{"X-Amz-Bypass-Governance-Retention": {"true"}}
+
+	t.Run("GovernanceRetention", func(t *testing.T) {
+		w := do("GET", "/s3/test-bucket/locked.txt?retention", "", nil)
+		if !strings.Contains(w.Body.String(), "<Retention><Mode>GOVERNANCE</Mode><RetainUntilDate>") {
+			t.Errorf("GetObjectRetention = %s, want the default GOVERNANCE retention", w.Body.String())
+		}
+		expect(t, do("DELETE", version, "", nil), http.StatusForbidden, "AccessDenied")
+
+		w = do("POST", "/s3/test-bucket?delete", `<Delete><Object><Key>locked.txt</Key><VersionId>`+result.VersionID+`</VersionId></Object></Delete>`, nil)
+		if !strings.Contains(w.Body.String(), "<Code>AccessDenied</Code>") {
+			t.Errorf("DeleteObjects = %s, want an AccessDenied error for the locked version", w.Body.String())
+		}
+	})
+
+	t.Run("ComplianceRetention", func(t *testing.T) {
+		until := time.Now().Add(2 * 24 * time.Hour).UTC().Format(time.RFC3339)
+		retention := `<Retention><Mode>COMPLIANCE</Mode><RetainUntilDate>` + until + `</RetainUntilDate></Retention>`
+		expect(t, do("PUT", "/s3/test-bucket/locked.txt?retention", retention, nil), http.StatusOK, "")
+		w := do("GET", "/s3/test-bucket/locked.txt?retention", "", nil)
+		if !strings.Contains(w.Body.String(), "<RetainUntilDate>"+until+"</RetainUntilDate>") {
+			t.Errorf("GetObjectRetention = %s, want retention until %s", w.Body.String(), until)
+		}
+
+		expect(t, do("PUT", "/s3/test-bucket/locked.txt?retention", `<Retention/>`, bypass), http.StatusForbidden, "AccessDenied")
+		expect(t, do("DELETE", version, "", bypass), http.StatusForbidden, "AccessDenied")
+	})
+
+	t.Run("InvalidRetention", func(t *testing.T) {
+		past := `<Retention><Mode>GOVERNANCE</Mode><RetainUntilDate>2020-01-01T00:00:00Z</RetainUntilDate></Retention>`
+		expect(t, do("PUT", "/s3/test-bucket/locked.txt?retention", past, nil), http.StatusBadRequest, "InvalidArgument")
+		mode := `<Retention><Mode>FOREVER</Mode><RetainUntilDate>2099-01-01T00:00:00Z</RetainUntilDate></Retention>`
+		expect(t, do("PUT", "/s3/test-bucket/locked.txt?retention", mode, nil), http.StatusBadRequest, "MalformedXML")
+		expect(t, do("PUT", "/s3/test-bucket/locked.txt?legal-hold", `<LegalHold><Status>MAYBE</Status></LegalHold>`, nil), http.StatusBadRequest, "MalformedXML")
+	})
+
+	t.Run("BypassGovernance", func(t *testing.T) {
+		governed, _ := router.engine.PutObject(ctx, "test-bucket", "governed.txt", bytes.NewBufferString("data"), engine.PutObjectOptions{})
+		target := "/s3/test-bucket/governed.txt?versionId=" + governed.VersionID
+		expect(t, do("DELETE", target, "", nil), http.StatusForbidden, "AccessDenied")
+		expect(t, do("DELETE", target, "", bypass), http.StatusNoContent, "")
+	})
+
+	t.Run("LegalHold", func(t *testing.T) {
+		router.engine.PutObjectLock(ctx, "test-bucket", &metadata.ObjectLockConfig{Enabled: true})
+		held, _ := router.engine.PutObject(ctx, "test-bucket", "held.txt", bytes.NewBufferString("data"), engine.PutObjectOptions{})
+		target := "/s3/test-bucket/held.txt?versionId=" + held.VersionID
+
+		expect(t, do("PUT", "/s3/test-bucket/held.txt?legal-hold", `<LegalHold><Status>ON</Status></LegalHold>`, nil), http.StatusOK, "")
+		if w := do("GET", "/s3/test-bucket/held.txt?legal-hold", "", nil); !strings.Contains(w.Body.String(), "<LegalHold><Status>ON</Status></LegalHold>") {
+			t.Errorf("GetObjectLegalHold = %s, want ON", w.Body.String())
+		}
+		expect(t, do("DELETE", target, "", bypass), http.StatusForbidden, "AccessDenied")
+
+		expect(t, do("PUT", "/s3/test-bucket/held.txt?legal-hold", `<LegalHold><Status>OFF</Status></LegalHold>`, nil), http.StatusOK, "")
+		expect(t, do("DELETE", target, "", nil), http.StatusNoContent, "")
+	})
+
+	t.Run("PerVersion", func(t *testing.T) {
+		v1, _ := router.engine.PutObject(ctx, "test-bucket", "multi.txt", bytes.NewBufferString("one"), engine.PutObjectOptions{})
+		v2, _ := router.engine.PutObject(ctx, "test-bucket", "multi.txt", bytes.NewBufferString("two"), engine.PutObjectOptions{})
+		hold := "/s3/test-bucket/multi.txt?legal-hold&versionId="
+
+		expect(t, do("PUT", hold+v1.VersionID, `<LegalHold><Status>ON</Status></LegalHold>`, nil), http.StatusOK, "")
+		expect(t, do("GET", hold+v2.VersionID, "", nil), http.StatusNotFound, "ObjectLegalHoldNotFound")
+		expect(t, do("PUT", hold+"no-such-version", `<LegalHold><Status>ON</Status></LegalHold>`, nil), http.StatusNotFound, "NoSuchKey")
+		expect(t, do("PUT", "/s3/test-bucket/multi.txt?legal-hold", `<LegalHold><Status>OFF</Status></LegalHold>`, nil), http.StatusOK, "")
+		expect(t, do("DELETE", "/s3/test-bucket/multi.txt?versionId="+v1.VersionID, "", nil), http.StatusForbidden, "AccessDenied")
+		expect(t, do("DELETE", "/s3/test-bucket/multi.txt?versionId="+v2.VersionID, "", nil), http.StatusNoContent, "")
+
+		expect(t, do("PUT", hold+v1.VersionID, `<LegalHold><Status>OFF</Status></LegalHold>`, nil), http.StatusOK, "")
+		expect(t, do("DELETE", "/s3/test-bucket/multi.txt?versionId="+v1.VersionID, "", nil), http.StatusNoContent, "")
+	})
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/openendpoint/openendpoint/internal/auth"
+)
+
+// requestPayload returns the object data of a PutObject or UploadPart
+// request and its declared length, -1 when unknown. aws-chunked bodies are
+// decoded, their chunk signatures and trailing checksum verified as the
+// data streams through, and their length is x-amz-decoded-content-length.
+// The data is also verified against Content-MD5 and a signed
+// x-amz-content-sha256, failing the final read on a mismatch.
+func (r *Router) requestPayload(req *http.Request) (io.Reader, int64, S3Error) {
+	body, size := io.Reader(req.Body), req.ContentLength
+	if auth.IsChunkedUpload(req) {
+		var err error
+		if size, err = auth.DecodedContentLength(req); err != nil {
+			return nil, 0, ErrInvalidArgument
+		}
+		if body, err = r.auth.NewChunkedReader(req); err != nil {
+			r.logger.Warnw("rejected aws-chunked upload", "error", err)
+			return nil, 0, payloadErrorToS3(err)
+		}
+	}
+
+	body, err := auth.NewPayloadReader(req, body)
+	if err != nil {
+		return nil, 0, payloadErrorToS3(err)
+	}
+	return body, size, nil
+}
+
+// payloadErrorToS3 maps an error from decoding a request payload to the S3
+// error returned to the client, or nil if err did not come from the payload
+func payloadErrorToS3(err error) S3Error {
+	switch {
+	case errors.Is(err, auth.ErrChecksumMismatch), errors.Is(err, auth.ErrContentMD5Mismatch):
+		return ErrBadDigest
+	case errors.Is(err, auth.ErrContentSHA256Mismatch):
+		return ErrXAmzContentSHA256Mismatch
+	case errors.Is(err, auth.ErrInvalidDigest):
+		return ErrInvalidDigest
+	case errors.Is(err, auth.ErrInvalidContentSHA256):
+		return ErrInvalidArgument
+	case errors.Is(err, auth.ErrMalformedTrailer):
+		return ErrMalformedTrailer
+	case errors.Is(err, auth.ErrMalformedChunk):
+		return ErrIncompleteBody
+	case errors.Is(err, auth.ErrUnsupportedStreaming):
+		return ErrNotImplemented
+	case errors.Is(err, auth.ErrSignatureDoesNotMatch), errors.Is(err, auth.ErrAccessDenied), errors.Is(err, auth.ErrInvalidAccessKeyID):
+		return authErrorToS3(err)
+	default:
+		return nil
+	}
+}
+
+// storedContentEncoding drops the aws-chunked transfer coding from a
+// Content-Encoding header, leaving the encodings of the object itself
+func storedContentEncoding(header string) string {
+	var codings []string
+	for _, coding := range strings.Split(header, ",") {
+		coding = strings.TrimSpace(coding)
+		if coding != "" && !strings.EqualFold(coding, "aws-chunked") {
+			codings = append(codings, coding)
+		}
+	}
+	return strings.Join(codings, ",")
+}
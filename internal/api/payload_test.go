@@ -0,0 +1,276 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openendpoint/openendpoint/internal/config"
+	"github.com/openendpoint/openendpoint/internal/engine"
+)
+
+// chunkedPut builds a SigV4 signed aws-chunked PUT of chunks to target,
+// signing every chunk. A non-empty checksum is sent as a signed
+// x-amz-checksum-crc32 trailer.
+func chunkedPut(target string, chunks []string, checksum string) *http.Request {
+	mode := "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	if checksum != "" {
+		mode = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	}
+	req := httptest.NewRequest("PUT", target, nil)
+	req.Header.Set("X-Amz-Content-Sha256", mode)
+	req.Header.Set("Content-Encoding", "aws-chunked,gzip")
+	req.Header.Set("X-Amz-Decoded-Content-Length", fmt.Sprint(len(strings.Join(chunks, ""))))
+	if checksum != "" {
+		req.Header.Set("X-Amz-Trailer", "x-amz-checksum-crc32")
+	}
+	prev, key := signV4(req, "test-key", "test-secret")
+
+	amzDate := req.Header.Get("X-Amz-Date")
+	scope := amzDate[:8] + "/us-east-1/s3/aws4_request"
+	sign := func(algorithm string, hashes ...string) string {
+		prev = hex.EncodeToString(hmacSHA256(key, algorithm+"\n"+amzDate+"\n"+scope+"\n"+prev+"\n"+strings.Join(hashes, "\n")))
+		return prev
+	}
+	emptyHash := sha256.Sum256(nil)
+
+	var body bytes.Buffer
+	for _, chunk := range append(chunks, "") {
+		sum := sha256.Sum256([]byte(chunk))
+		fmt.Fprintf(&body, "%x;chunk-signature=%s\r\n", len(chunk), sign("AWS4-HMAC-SHA256-PAYLOAD", hex.EncodeToString(emptyHash[:]), hex.EncodeToString(sum[:])))
+		if chunk != "" {
+			body.WriteString(chunk + "\r\n")
+		}
+	}
+	if checksum != "" {
+		trailer := "x-amz-checksum-crc32:" + checksum
+		sum := sha256.Sum256([]byte(trailer + "\n"))
+		body.WriteString(trailer + "\r\n")
+		body.WriteString("x-amz-trailer-signature:" + sign("AWS4-HMAC-SHA256-TRAILER", hex.EncodeToString(sum[:])) + "\r\n")
+	}
+	body.WriteString("\r\n")
+
+	req.Body = io.NopCloser(&body)
+	req.ContentLength = int64(body.Len())
+	return req
+}
+
+func crc32Checksum(data string) string {
+	sum := binary.BigEndian.AppendUint32(nil, crc32.ChecksumIEEE([]byte(data)))
+	return base64.StdEncoding.EncodeToString(sum)
+}
+
+func TestAPIRouter_ChunkedPutObject(t *testing.T) {
+	router := createStoreTestAPIRouter(t, &config.Config{
+		Auth: config.AuthConfig{AccessKey: "test-key", SecretKey: "test-secret"},
+	})
+
+	do := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	get := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/s3/test-bucket/"+key, nil)
+		signV4(req, "test-key", "test-secret")
+		return do(req)
+	}
+
+	req := httptest.NewRequest("PUT", "/s3/test-bucket", nil)
+	signV4(req, "test-key", "test-secret")
+	if w := do(req); w.Code != http.StatusOK {
+		t.Fatalf("CreateBucket status = %d, body %s", w.Code, w.Body.String())
+	}
+
+	chunks := []string{strings.Repeat("x", 8192), "hello"}
+	want := strings.Join(chunks, "")
+
+	t.Run("Signed", func(t *testing.T) {
+		if w := do(chunkedPut("/s3/test-bucket/signed", chunks, "")); w.Code != http.StatusOK {
+			t.Fatalf("PutObject status = %d, body %s", w.Code, w.Body.String())
+		}
+		w := get("signed")
+		if w.Body.String() != want {
+			t.Errorf("GetObject returned %d bytes, want %d", w.Body.Len(), len(want))
+		}
+		if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+			t.Errorf("Content-Encoding = %q, want gzip", got)
+		}
+	})
+
+	t.Run("SignedTrailer", func(t *testing.T) {
+		if w := do(chunkedPut("/s3/test-bucket/trailer", chunks, crc32Checksum(want))); w.Code != http.StatusOK {
+			t.Fatalf("PutObject status = %d, body %s", w.Code, w.Body.String())
+		}
+		if w := get("trailer"); w.Body.String() != want {
+			t.Errorf("GetObject returned %d bytes, want %d", w.Body.Len(), len(want))
+		}
+	})
+
+	t.Run("BadChunkSignature", func(t *testing.T) {
+		req := chunkedPut("/s3/test-bucket/tampered", chunks, "")
+		body, _ := io.ReadAll(req.Body)
+		req.Body = io.NopCloser(strings.NewReader(strings.Replace(string(body), "hello", "jello", 1)))
+		if w := do(req); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "SignatureDoesNotMatch") {
+			t.Errorf("PutObject = %d %s, want SignatureDoesNotMatch", w.Code, w.Body.String())
+		}
+		if w := get("tampered"); w.Code != http.StatusNotFound {
+			t.Errorf("GetObject after rejected upload status = %d, want 404", w.Code)
+		}
+	})
+
+	t.Run("ChecksumMismatch", func(t *testing.T) {
+		w := do(chunkedPut("/s3/test-bucket/mismatch", chunks, crc32Checksum("other")))
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "BadDigest") {
+			t.Errorf("PutObject = %d %s, want BadDigest", w.Code, w.Body.String())
+		}
+		if w := get("mismatch"); w.Code != http.StatusNotFound {
+			t.Errorf("GetObject after rejected upload status = %d, want 404", w.Code)
+		}
+	})
+}
+
+func TestAPIRouter_ChunkedUploadPart(t *testing.T) {
+	router := createVersionedTestAPIRouter(t)
+
+	do := func(method, target, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	do("PUT", "/s3/test-bucket", "", nil)
+	upload, err := router.engine.CreateMultipartUpload(context.Background(), "test-bucket", "big", engine.PutObjectOptions{})
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload() error = %v", err)
+	}
+
+	// Unsigned trailers carry only a checksum, as SDKs send over TLS
+	body := "5\r\nhello\r\n0\r\nx-amz-checksum-crc32:" + crc32Checksum("hello") + "\r\n\r\n"
+	header := map[string]string{
+		"X-Amz-Content-Sha256":         "STREAMING-UNSIGNED-PAYLOAD-TRAILER",
+		"X-Amz-Trailer":                "x-amz-checksum-crc32",
+		"X-Amz-Decoded-Content-Length": "5",
+	}
+	target := "/s3/test-bucket/big?partNumber=1&uploadId=" + upload.UploadID
+	w := do("PUT", target, body, header)
+	if w.Code != http.StatusOK {
+		t.Fatalf("UploadPart status = %d, body %s", w.Code, w.Body.String())
+	}
+	if got, want := w.Header().Get("ETag"), fmt.Sprintf("%q", "5d41402abc4b2a76b9719d911017c592"); got != want {
+		t.Errorf("ETag = %s, want %s (MD5 of the decoded part)", got, want)
+	}
+
+	body = strings.Replace(body, crc32Checksum("hello"), crc32Checksum("jello"), 1)
+	if w := do("PUT", target, body, header); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "BadDigest") {
+		t.Errorf("UploadPart with bad checksum = %d %s, want BadDigest", w.Code, w.Body.String())
+	}
+}
+
+func TestAPIRouter_PayloadDigests(t *testing.T) {
+	router := createStoreTestAPIRouter(t, &config.Config{
+		Auth: config.AuthConfig{AccessKey: "test-key", SecretKey: "test-secret"},
+	})
+
+	// put signs a PutObject of body declaring header, then swaps in sent
+	put := func(key, body, sent string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/s3/test-bucket/"+key, strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		signV4(req, "test-key", "test-secret")
+		req.Body = io.NopCloser(strings.NewReader(sent))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	get := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/s3/test-bucket/"+key, nil)
+		signV4(req, "test-key", "test-secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	req := httptest.NewRequest("PUT", "/s3/test-bucket", nil)
+	signV4(req, "test-key", "test-secret")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	md5sum := md5.Sum([]byte("hello"))
+	contentMD5 := base64.StdEncoding.EncodeToString(md5sum[:])
+	shasum := sha256.Sum256([]byte("hello"))
+	contentSHA := hex.EncodeToString(shasum[:])
+
+	tests := []struct {
+		name     string
+		sent     string
+		header   map[string]string
+		wantCode int
+		wantErr  string
+	}{
+		{"ContentMD5", "hello", map[string]string{"Content-MD5": contentMD5}, http.StatusOK, ""},
+		{"ContentMD5Mismatch", "jello", map[string]string{"Content-MD5": contentMD5}, http.StatusBadRequest, "BadDigest"},
+		{"InvalidContentMD5", "hello", map[string]string{"Content-MD5": "bm90IG1kNQ=="}, http.StatusBadRequest, "InvalidDigest"},
+		{"ContentSHA256", "hello", map[string]string{"X-Amz-Content-Sha256": contentSHA}, http.StatusOK, ""},
+		{"ContentSHA256Mismatch", "jello", map[string]string{"X-Amz-Content-Sha256": contentSHA}, http.StatusBadRequest, "XAmzContentSHA256Mismatch"},
+		{"UnsignedPayload", "jello", nil, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := put(tt.name, "hello", tt.sent, tt.header)
+			if w.Code != tt.wantCode || !strings.Contains(w.Body.String(), tt.wantErr) {
+				t.Fatalf("PutObject = %d %s, want %d %s", w.Code, w.Body.String(), tt.wantCode, tt.wantErr)
+			}
+			if w := get(tt.name); tt.wantCode != http.StatusOK && w.Code != http.StatusNotFound {
+				t.Errorf("GetObject after rejected upload status = %d, want 404", w.Code)
+			}
+		})
+	}
+
+	upload, err := router.engine.CreateMultipartUpload(context.Background(), "test-bucket", "big", engine.PutObjectOptions{})
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload() error = %v", err)
+	}
+	req = httptest.NewRequest("PUT", "/s3/test-bucket/big?partNumber=1&uploadId="+upload.UploadID, strings.NewReader("jello"))
+	req.Header.Set("Content-MD5", contentMD5)
+	signV4(req, "test-key", "test-secret")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "BadDigest") {
+		t.Errorf("UploadPart with wrong Content-MD5 = %d %s, want BadDigest", w.Code, w.Body.String())
+	}
+	if parts, err := router.engine.ListParts(context.Background(), "test-bucket", "big", upload.UploadID); err != nil || len(parts) != 0 {
+		t.Errorf("ListParts() after rejected part = %v, %v, want none", parts, err)
+	}
+}
+
+func TestStoredContentEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                  "",
+		"aws-chunked":       "",
+		"aws-chunked,gzip":  "gzip",
+		"gzip, aws-chunked": "gzip",
+		"AWS-Chunked":       "",
+		"gzip":              "gzip",
+	}
+	for header, want := range tests {
+		if got := storedContentEncoding(header); got != want {
+			t.Errorf("storedContentEncoding(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/openendpoint/openendpoint/internal/auth"
+	"github.com/openendpoint/openendpoint/internal/iam"
+)
+
+// bucketPolicyActions are always allowed to the bucket owner, whatever the
+// bucket policy says, so that a bad policy cannot lock the owner out
+var bucketPolicyActions = map[string]bool{
+	"s3:GetBucketPolicy":    true,
+	"s3:PutBucketPolicy":    true,
+	"s3:DeleteBucketPolicy": true,
+}
+
+// authenticate verifies the signature of a request and returns the access
+// key that signed it, or "" for an anonymous request, which only a bucket
+// policy can allow
+func (r *Router) authenticate(req *http.Request, bucket, action string) (string, S3Error) {
+	err := r.auth.Authorize(req, bucket, action)
+	switch {
+	case err == nil:
+	case errors.Is(err, auth.ErrAnonymousRequest):
+		return "", nil
+	default:
+		r.logger.Warnw("request authentication failed", "bucket", bucket, "action", action, "error", err)
+		return "", authErrorToS3(err)
+	}
+	if !r.auth.Enabled() {
+		return "", nil
+	}
+	return auth.RequestAccessKey(req), nil
+}
+
+// authorize authenticates a request and checks that the policies allow
+// it action on the bucket, or the object when key is set
+func (r *Router) authorize(req *http.Request, bucket, key, action string) S3Error {
+	accessKey, s3err := r.authenticate(req, bucket, action)
+	if s3err != nil {
+		return s3err
+	}
+	if !r.policyAllows(req, accessKey, bucket, key, action) {
+		return ErrAccessDenied
+	}
+	return nil
+}
+
+// policyAllows decides a request signed with accessKey, "" if anonymous,
+// against the requester's identity policies and the bucket policy. An
+// explicit Deny in either wins; otherwise one of them must allow it.
+// Configured credentials, and everyone while authentication is off, are
+// allowed by identity; IAM users by their policies; anonymous requesters
+// only by the bucket policy.
+func (r *Router) policyAllows(req *http.Request, accessKey, bucket, key, action string) bool {
+	rc := &iam.RequestContext{
+		Action:   action,
+		Resource: "arn:aws:s3:::*",
+		Keys:     requestConditionKeys(req),
+	}
+	switch {
+	case key != "":
+		rc.Resource = iam.ObjectARN(bucket, key)
+		rc.ObjectTags = func() map[string]string {
+			objectTags, _, err := r.engine.GetObjectTagging(req.Context(), bucket, key, req.URL.Query().Get("versionId"))
+			if err != nil {
+				return nil
+			}
+			return objectTags
+		}
+	case bucket != "":
+		rc.Resource = iam.BucketARN(bucket)
+	}
+
+	identity := iam.DecisionNone
+	owner := false
+	switch {
+	case !r.auth.Enabled():
+		identity, owner = iam.DecisionAllow, true
+	case accessKey == "":
+	default:
+		var user *iam.User
+		if r.iam != nil {
+			user, _ = r.iam.GetUserByAccessKey(accessKey)
+		}
+		if user == nil {
+			rc.Principals = []string{accessKey}
+			identity, owner = iam.DecisionAllow, true
+			break
+		}
+		rc.Principals = []string{user.ID, user.Username, iam.UserARN(user.Username)}
+		rc.Keys[iam.KeyUsername] = user.Username
+		identity = r.iam.EvaluateRequest(user.ID, rc)
+	}
+
+	decision := identity
+	if bucket != "" && !(owner && bucketPolicyActions[action]) {
+		decision = decision.Combine(r.bucketPolicyDecision(req, bucket, rc))
+	}
+	if decision != iam.DecisionAllow {
+		r.logger.Warnw("request denied by policy", "bucket", bucket, "key", key, "action", action,
+			"accessKey", accessKey, "deny", decision == iam.DecisionDeny)
+		return false
+	}
+	return true
+}
+
+// bucketPolicyDecision evaluates a request against the policy of bucket.
+// A stored policy that no longer parses is logged and ignored.
+func (r *Router) bucketPolicyDecision(req *http.Request, bucket string, rc *iam.RequestContext) iam.Decision {
+	doc, err := r.engine.GetBucketPolicy(req.Context(), bucket)
+	if err != nil || doc == nil || *doc == "" {
+		return iam.DecisionNone
+	}
+	policy, err := iam.ParsePolicy([]byte(*doc))
+	if err != nil {
+		r.logger.Warnw("ignoring unreadable bucket policy", "bucket", bucket, "error", err)
+		return iam.DecisionNone
+	}
+	return policy.Evaluate(rc)
+}
+
+// requestConditionKeys returns the condition key values of a request but
+// aws:username, which only IAM users have
+func requestConditionKeys(req *http.Request) map[string]string {
+	keys := map[string]string{
+		iam.KeySecureTransport: strconv.FormatBool(req.TLS != nil),
+		iam.KeyCurrentTime:     time.Now().UTC().Format(time.RFC3339),
+	}
+	// The connection's address, not a client-supplied forwarding header
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		keys[iam.KeySourceIP] = host
+	}
+	query := req.URL.Query()
+	if query.Has("prefix") {
+		keys[iam.KeyPrefix] = query.Get("prefix")
+	}
+	if query.Has("delimiter") {
+		keys[iam.KeyDelimiter] = query.Get("delimiter")
+	}
+	if acl := req.Header.Get("x-amz-acl"); acl != "" {
+		keys[iam.KeyACL] = acl
+	}
+	return keys
+}
+
+// authorizeCopySource checks that the requester of a copy may read its
+// source object, which the policy check of the copy's own action, a write
+// to the destination, does not cover
+func (r *Router) authorizeCopySource(req *http.Request, bucket, key, versionID string) S3Error {
+	action := "s3:GetObject"
+	if versionID != "" {
+		action = "s3:GetObjectVersion"
+	}
+	return r.authorize(req, bucket, key, action)
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openendpoint/openendpoint/internal/config"
+	"github.com/openendpoint/openendpoint/internal/engine"
+	"github.com/openendpoint/openendpoint/internal/iam"
+	"go.uber.org/zap"
+)
+
+func TestAPIRouter_BucketPolicy(t *testing.T) {
+	router := createStoreTestAPIRouter(t, &config.Config{
+		Auth: config.AuthConfig{AccessKey: "owner-key", SecretKey: "owner-secret"},
+	})
+	ctx := context.Background()
+
+	// An IAM user allowed everything on the bucket's objects
+	manager := iam.NewManager(zap.NewNop())
+	user, _ := manager.CreateUser("tenant", "alice", "")
+	aliceKey, _ := manager.CreateAccessKey(user.ID)
+	policy, _ := manager.CreatePolicy("tenant", "objects", iam.PolicyDoc{Statement: []iam.Statement{{
+		Effect:    "Allow",
+		Actions:   []string{"s3:*"},
+		Resources: []string{"arn:aws:s3:::test-bucket/*"},
+	}}})
+	manager.AttachPolicy(policy.ID, user.ID, "user")
+	router.auth.SetCredentialStore(manager)
+	router.SetIAM(manager)
+
+	router.engine.CreateBucket(ctx, "test-bucket")
+	for _, key := range []string{"public/a.txt", "private/b.txt", "home/alice/c.txt", "home/alice/d.txt"} {
+		router.engine.PutObject(ctx, "test-bucket", key, strings.NewReader("data"), engine.PutObjectOptions{})
+	}
+
+	// sign signs a request as owner, alice, or no one
+	sign := func(req *http.Request, as string) {
+		switch as {
+		case "owner":
+			signV4(req, "owner-key", "owner-secret")
+		case "alice":
+			signV4(req, aliceKey.ID, aliceKey.Secret)
+		}
+	}
+	do := func(method, target, body, as string, edit func(*http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if edit != nil {
+			edit(req)
+		}
+		sign(req, as)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	expect := func(t *testing.T, w *httptest.ResponseRecorder, code int, errCode string) {
+		t.Helper()
+		if w.Code != code || errCode != "" && !strings.Contains(w.Body.String(), "<Code>"+errCode+"</Code>") {
+			t.Errorf("status = %d %s, want %d %s", w.Code, w.Body.String(), code, errCode)
+		}
+	}
+
+	// Before any bucket policy only the owner and alice's own policy count
+	expect(t, do("GET", "/s3/test-bucket/public/a.txt", "", "", nil), http.StatusForbidden, "AccessDenied")
+	expect(t, do("GET", "/s3/test-bucket/private/b.txt", "", "alice", nil), http.StatusOK, "")
+	expect(t, do("GET", "/s3/test-bucket", "", "alice", nil), http.StatusForbidden, "AccessDenied")
+
+	w := do("PUT", "/s3/test-bucket?policy=", `{
+		"Version": "2012-10-17",
+		"Statement": [
+			{"Sid": "PublicRead", "Effect": "Allow", "Principal": "*", "Action": "s3:GetObject",
+			 "Resource": "arn:aws:s3:::test-bucket/public/*",
+			 "Condition": {"IpAddress": {"aws:SourceIp": "192.0.2.0/24"}}},
+			{"Sid": "PublicList", "Effect": "Allow", "Principal": "*", "Action": "s3:ListBucket",
+			 "Resource": "arn:aws:s3:::test-bucket",
+			 "Condition": {"StringLike": {"s3:prefix": "public/*"}}},
+			{"Sid": "NoPrivate", "Effect": "Deny", "Principal": {"AWS": "arn:aws:iam:::user/alice"}, "Action": "s3:*",
+			 "Resource": "arn:aws:s3:::test-bucket/private/*"},
+			{"Sid": "TLSWrites", "Effect": "Deny", "Principal": "*", "Action": "s3:PutObject",
+			 "Resource": "arn:aws:s3:::test-bucket/*",
+			 "Condition": {"Bool": {"aws:SecureTransport": "false"}}}
+		]
+	}`, "owner", nil)
+	expect(t, w, http.StatusOK, "")
+
+	t.Run("Anonymous", func(t *testing.T) {
+		expect(t, do("GET", "/s3/test-bucket/public/a.txt", "", "", nil), http.StatusOK, "")
+		expect(t, do("GET", "/s3/test-bucket/public/a.txt", "", "", func(req *http.Request) {
+			req.RemoteAddr = "203.0.113.5:4000"
+		}), http.StatusForbidden, "AccessDenied")
+		expect(t, do("GET", "/s3/test-bucket/home/alice/c.txt", "", "", nil), http.StatusForbidden, "AccessDenied")
+		expect(t, do("PUT", "/s3/test-bucket/public/new.txt", "x", "", nil), http.StatusForbidden, "AccessDenied")
+		expect(t, do("GET", "/s3/test-bucket?prefix=public/", "", "", nil), http.StatusOK, "")
+		expect(t, do("GET", "/s3/test-bucket?prefix=home/", "", "", nil), http.StatusForbidden, "AccessDenied")
+		expect(t, do("GET", "/s3/test-bucket", "", "", nil), http.StatusForbidden, "AccessDenied")
+	})
+
+	t.Run("ExplicitDenyWins", func(t *testing.T) {
+		expect(t, do("GET", "/s3/test-bucket/private/b.txt", "", "alice", nil), http.StatusForbidden, "AccessDenied")
+		expect(t, do("GET", "/s3/test-bucket/home/alice/c.txt", "", "alice", nil), http.StatusOK, "")
+		expect(t, do("GET", "/s3/test-bucket/private/b.txt", "", "owner", nil), http.StatusOK, "")
+	})
+
+	t.Run("SecureTransport", func(t *testing.T) {
+		expect(t, do("PUT", "/s3/test-bucket/home/alice/new.txt", "x", "alice", nil), http.StatusForbidden, "AccessDenied")
+		expect(t, do("PUT", "https://localhost/s3/test-bucket/home/alice/new.txt", "x", "alice", nil), http.StatusOK, "")
+		expect(t, do("PUT", "/s3/test-bucket/home/alice/new.txt", "x", "owner", nil), http.StatusForbidden, "AccessDenied")
+	})
+
+	t.Run("CopySource", func(t *testing.T) {
+		copyFrom := func(source string) func(*http.Request) {
+			return func(req *http.Request) { req.Header.Set("x-amz-copy-source", source) }
+		}
+		expect(t, do("PUT", "https://localhost/s3/test-bucket/home/alice/copy.txt", "", "alice",
+			copyFrom("/test-bucket/private/b.txt")), http.StatusForbidden, "AccessDenied")
+		expect(t, do("PUT", "https://localhost/s3/test-bucket/home/alice/copy.txt", "", "alice",
+			copyFrom("/test-bucket/public/a.txt")), http.StatusOK, "")
+	})
+
+	t.Run("DeleteObjects", func(t *testing.T) {
+		w := do("POST", "/s3/test-bucket?delete=", `<Delete>
+			<Object><Key>private/b.txt</Key></Object>
+			<Object><Key>home/alice/d.txt</Key></Object>
+		</Delete>`, "alice", nil)
+		expect(t, w, http.StatusOK, "")
+		body := w.Body.String()
+		if !strings.Contains(body, "<Deleted><Key>home/alice/d.txt</Key>") ||
+			!strings.Contains(body, "<Key>private/b.txt</Key><Code>AccessDenied</Code>") {
+			t.Errorf("DeleteObjects body = %s", body)
+		}
+		expect(t, do("GET", "/s3/test-bucket/private/b.txt", "", "owner", nil), http.StatusOK, "")
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		w := do("PUT", "/s3/test-bucket?policy=", `{"Statement": [{"Effect": "Allow", "Principal": "*",
+			"Action": "s3:GetObject", "Resource": "arn:aws:s3:::other-bucket/*"}]}`, "owner", nil)
+		expect(t, w, http.StatusBadRequest, "MalformedPolicy")
+		if !strings.Contains(w.Body.String(), "other-bucket") {
+			t.Errorf("MalformedPolicy body = %s, want the offending resource", w.Body.String())
+		}
+		expect(t, do("PUT", "/s3/test-bucket?policy=", `{"Statement": [{"Effect": "Allow", "Principal": "*",
+			"Action": "s3:GetObject", "Resource": "arn:aws:s3:::test-bucket/*",
+			"Condition": {"NumericLessThan": {"s3:max-keys": "10"}}}]}`, "owner", nil), http.StatusBadRequest, "MalformedPolicy")
+	})
+
+	t.Run("OwnerKeepsPolicyAccess", func(t *testing.T) {
+		lockout := `{"Statement": [{"Effect": "Deny", "Principal": "*", "Action": "s3:*",
+			"Resource": ["arn:aws:s3:::test-bucket", "arn:aws:s3:::test-bucket/*"]}]}`
+		expect(t, do("PUT", "/s3/test-bucket?policy=", lockout, "owner", nil), http.StatusOK, "")
+		expect(t, do("GET", "/s3/test-bucket/private/b.txt", "", "owner", nil), http.StatusForbidden, "AccessDenied")
+		expect(t, do("GET", "/s3/test-bucket?policy=", "", "owner", nil), http.StatusOK, "")
+		expect(t, do("DELETE", "/s3/test-bucket?policy=", "", "owner", nil), http.StatusNoContent, "")
+		expect(t, do("GET", "/s3/test-bucket/private/b.txt", "", "owner", nil), http.StatusOK, "")
+	})
+
+	t.Run("ExistingObjectTag", func(t *testing.T) {
+		expect(t, do("PUT", "/s3/test-bucket?policy=", `{"Statement": [{"Effect": "Allow", "Principal": "*",
+			"Action": "s3:GetObject", "Resource": "arn:aws:s3:::test-bucket/*",
+			"Condition": {"StringEquals": {"s3:ExistingObjectTag/classification": "public"}}}]}`, "owner", nil), http.StatusOK, "")
+
+		// User metadata set by the uploader is not a tag
+		expect(t, do("PUT", "/s3/test-bucket/tagged.txt", "data", "owner", func(req *http.Request) {
+			req.Header.Set("x-amz-meta-classification", "public")
+		}), http.StatusOK, "")
+		expect(t, do("GET", "/s3/test-bucket/tagged.txt", "", "", nil), http.StatusForbidden, "AccessDenied")
+
+		tagging := `<Tagging><TagSet><Tag><Key>classification</Key><Value>public</Value></Tag></TagSet></Tagging>`
+		expect(t, do("PUT", "/s3/test-bucket/tagged.txt?tagging=", tagging, "owner", nil), http.StatusNoContent, "")
+		expect(t, do("GET", "/s3/test-bucket/tagged.txt", "", "", nil), http.StatusOK, "")
+
+		expect(t, do("DELETE", "/s3/test-bucket/tagged.txt?tagging=", "", "owner", nil), http.StatusNoContent, "")
+		expect(t, do("GET", "/s3/test-bucket/tagged.txt", "", "", nil), http.StatusForbidden, "AccessDenied")
+	})
+}
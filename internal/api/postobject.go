@@ -0,0 +1,213 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/openendpoint/openendpoint/internal/auth"
+	s3types "github.com/openendpoint/openendpoint/pkg/s3types"
+)
+
+// maxPostFormSize bounds the form fields read before the file of a POST
+const maxPostFormSize = 64 << 10
+
+// Errors of the uploaded file of a POST, checked against the policy
+// content-length-range as it streams to storage
+var (
+	errPostTooSmall = errors.New("file is smaller than the policy content-length-range")
+	errPostTooLarge = errors.New("file is larger than the policy content-length-range")
+)
+
+// isPostObject reports whether req is a browser POST upload to a bucket,
+// authenticated by the signed policy in its form rather than its headers
+func isPostObject(req *http.Request, bucket, key string) bool {
+	if req.Method != http.MethodPost || bucket == "" || key != "" || len(req.URL.Query()) > 0 {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return mediaType == "multipart/form-data"
+}
+
+// handlePostObject handles POST Object, an upload from an HTML form. The
+// fields preceding the file are read, the policy they carry verified, and
+// the file streamed to storage; fields after the file are ignored.
+func (r *Router) handlePostObject(w http.ResponseWriter, req *http.Request, bucket string) {
+	ctx := req.Context()
+
+	form, err := req.MultipartReader()
+	if err != nil {
+		r.writeError(w, ErrMalformedPOSTRequest)
+		return
+	}
+
+	fields := make(map[string]string)
+	var file *multipart.Part
+	size := 0
+	for {
+		part, err := form.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			r.writeError(w, ErrMalformedPOSTRequest)
+			return
+		}
+		name := strings.ToLower(part.FormName())
+		if name == "file" {
+			file = part
+			break
+		}
+		value, err := io.ReadAll(io.LimitReader(part, int64(maxPostFormSize-size+1)))
+		if err != nil {
+			r.writeError(w, ErrMalformedPOSTRequest)
+			return
+		}
+		if size += len(value); size > maxPostFormSize {
+			r.writeError(w, ErrMaxPostPreDataLengthExceeded)
+			return
+		}
+		if name != "" {
+			fields[name] = string(value)
+		}
+	}
+	if file == nil {
+		r.writeError(w, ErrIncorrectNumberOfFilesInPostRequest)
+		return
+	}
+
+	key := strings.ReplaceAll(fields["key"], "${filename}", path.Base(file.FileName()))
+	if key == "" {
+		r.writeError(w, ErrInvalidArgument)
+		return
+	}
+	fields["key"] = key
+
+	// The form, not a header, carries the uploader's credential
+	accessKey, _, _ := strings.Cut(fields["x-amz-credential"], "/")
+	policy, err := r.auth.VerifyPostPolicy(bucket, fields)
+	if errors.Is(err, auth.ErrAnonymousRequest) {
+		// An anonymous upload is up to the bucket policy
+		policy, accessKey, err = &auth.PostPolicy{MaxLength: -1}, "", nil
+	}
+	if err != nil {
+		r.logger.Warnw("POST upload policy rejected", "bucket", bucket, "key", key, "error", err)
+		s3Err := authErrorToS3(err)
+		r.writeError(w, s3Err)
+		s3RequestsTotal.WithLabelValues("PostObject", strconv.Itoa(s3Err.StatusCode())).Inc()
+		return
+	}
+
+	// Form fields stand in for the headers of a PutObject
+	header := make(http.Header)
+	for name, value := range fields {
+		header.Set(name, value)
+	}
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", file.Header.Get("Content-Type"))
+	}
+	fieldsReq := req.Clone(ctx)
+	fieldsReq.Header = header
+	if !r.auth.Enabled() {
+		accessKey = ""
+	}
+	if !r.policyAllows(fieldsReq, accessKey, bucket, key, "s3:PutObject") {
+		r.writeError(w, ErrAccessDenied)
+		s3RequestsTotal.WithLabelValues("PostObject", strconv.Itoa(ErrAccessDenied.StatusCode())).Inc()
+		return
+	}
+	opts, s3err := parseObjectHeaders(fieldsReq)
+	if s3err != nil {
+		r.writeError(w, s3err)
+		return
+	}
+	if opts.Encryption, s3err = r.requestEncryption(fieldsReq); s3err != nil {
+		r.writeError(w, s3err)
+		return
+	}
+	opts.Encryption.AuthorizeKey = r.keyAuthorizer(fieldsReq, accessKey)
+
+	body := &lengthRangeReader{r: file, min: policy.MinLength, max: policy.MaxLength}
+	result, err := r.engine.PutObject(ctx, bucket, key, body, opts)
+	if err != nil {
+		r.logger.Warnw("failed to put POST upload", "bucket", bucket, "key", key, "error", err)
+		switch {
+		case errors.Is(err, errPostTooSmall):
+			r.writeError(w, ErrEntityTooSmall)
+		case errors.Is(err, errPostTooLarge):
+			r.writeError(w, ErrEntityTooLarge)
+		default:
+			r.writeError(w, putErrorToS3(err))
+		}
+		return
+	}
+
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	objectPath := (&url.URL{Path: strings.TrimSuffix(req.URL.Path, "/") + "/" + key}).EscapedPath()
+	location := scheme + "://" + req.Host + objectPath
+
+	w.Header().Set("ETag", sanitizeHeaderValue(result.ETag))
+	w.Header().Set("Location", location)
+	setVersionID(w, result.VersionID)
+	setEncryptionHeaders(w, result.Encryption)
+
+	if redirect, err := url.Parse(fields["success_action_redirect"]); err == nil && redirect.IsAbs() {
+		query := redirect.Query()
+		query.Set("bucket", bucket)
+		query.Set("key", key)
+		query.Set("etag", result.ETag)
+		redirect.RawQuery = query.Encode()
+		http.Redirect(w, req, redirect.String(), http.StatusSeeOther)
+		s3RequestsTotal.WithLabelValues("PostObject", "303").Inc()
+		return
+	}
+
+	status := http.StatusNoContent
+	switch fields["success_action_status"] {
+	case "200":
+		status = http.StatusOK
+		w.WriteHeader(status)
+	case "201":
+		status = http.StatusCreated
+		r.writeXML(w, status, s3types.PostResponse{
+			Location: location,
+			Bucket:   bucket,
+			Key:      key,
+			ETag:     result.ETag,
+		})
+	default:
+		w.WriteHeader(status)
+	}
+	s3RequestsTotal.WithLabelValues("PostObject", strconv.Itoa(status)).Inc()
+}
+
+// lengthRangeReader fails the read past max bytes, or the final read when
+// fewer than min bytes came, so a file outside a policy content-length-range
+// is never stored; max is -1 when unbounded
+type lengthRangeReader struct {
+	r        io.Reader
+	n        int64
+	min, max int64
+}
+
+func (l *lengthRangeReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.max >= 0 && l.n > l.max {
+		return n, fmt.Errorf("%w: more than %d bytes", errPostTooLarge, l.max)
+	}
+	if err == io.EOF && l.n < l.min {
+		return n, fmt.Errorf("%w: %d of at least %d bytes", errPostTooSmall, l.n, l.min)
+	}
+	return n, err
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openendpoint/openendpoint/internal/config"
+	s3types "github.com/openendpoint/openendpoint/pkg/s3types"
+)
+
+// postObject builds a browser POST upload of file to target. The policy
+// conditions are signed with secret unless it is empty; fields are sent
+// in order before the file.
+func postObject(target, secret string, conditions []string, fields [][2]string, file string) *http.Request {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+
+	if secret != "" {
+		now := time.Now().UTC()
+		dateStamp := now.Format("20060102")
+		conditions = append(conditions,
+			`{"x-amz-algorithm": "AWS4-HMAC-SHA256"}`,
+			`["starts-with", "$x-amz-credential", ""]`,
+			`["starts-with", "$x-amz-date", ""]`)
+		policy := base64.StdEncoding.EncodeToString([]byte(`{"expiration": "` +
+			now.Add(time.Hour).Format(time.RFC3339) + `", "conditions": [` + strings.Join(conditions, ", ") + `]}`))
+		key := hmacSHA256([]byte("AWS4"+secret), dateStamp)
+		for _, s := range []string{"us-east-1", "s3", "aws4_request"} {
+			key = hmacSHA256(key, s)
+		}
+		fields = append(fields,
+			[2]string{"x-amz-algorithm", "AWS4-HMAC-SHA256"},
+			[2]string{"x-amz-credential", "test-key/" + dateStamp + "/us-east-1/s3/aws4_request"},
+			[2]string{"x-amz-date", now.Format("20060102T150405Z")},
+			[2]string{"Policy", policy},
+			[2]string{"X-Amz-Signature", hex.EncodeToString(hmacSHA256(key, policy))})
+	}
+	for _, f := range fields {
+		form.WriteField(f[0], f[1])
+	}
+	part, _ := form.CreateFormFile("file", "photo.jpg")
+	part.Write([]byte(file))
+	form.Close()
+
+	req := httptest.NewRequest("POST", target, &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func TestAPIRouter_PostObject(t *testing.T) {
+	router := createStoreTestAPIRouter(t, &config.Config{
+		Auth: config.AuthConfig{AccessKey: "test-key", SecretKey: "test-secret"},
+	})
+
+	do := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	get := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/s3/test-bucket/"+key, nil)
+		signV4(req, "test-key", "test-secret")
+		return do(req)
+	}
+
+	req := httptest.NewRequest("PUT", "/s3/test-bucket", nil)
+	signV4(req, "test-key", "test-secret")
+	do(req)
+
+	conditions := []string{
+		`{"bucket": "test-bucket"}`,
+		`["starts-with", "$key", "uploads/"]`,
+		`["starts-with", "$Content-Type", "image/"]`,
+		`["content-length-range", 1, 16]`,
+	}
+	fields := [][2]string{{"key", "uploads/${filename}"}, {"Content-Type", "image/jpeg"}}
+
+	t.Run("NoContent", func(t *testing.T) {
+		w := do(postObject("/s3/test-bucket", "test-secret", conditions, fields, "jpeg data"))
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("PostObject status = %d, body %s", w.Code, w.Body.String())
+		}
+		if w.Header().Get("ETag") == "" || !strings.HasSuffix(w.Header().Get("Location"), "/s3/test-bucket/uploads/photo.jpg") {
+			t.Errorf("PostObject headers = %v", w.Header())
+		}
+		g := get("uploads/photo.jpg")
+		if g.Body.String() != "jpeg data" || g.Header().Get("Content-Type") != "image/jpeg" {
+			t.Errorf("GetObject = %q %s, want the posted file", g.Body.String(), g.Header().Get("Content-Type"))
+		}
+	})
+
+	t.Run("Created", func(t *testing.T) {
+		w := do(postObject("/s3/test-bucket", "test-secret",
+			append(conditions, `{"success_action_status": "201"}`),
+			append(fields, [2]string{"success_action_status", "201"}), "jpeg data"))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("PostObject status = %d, body %s", w.Code, w.Body.String())
+		}
+		var resp s3types.PostResponse
+		if err := xml.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		if resp.Bucket != "test-bucket" || resp.Key != "uploads/photo.jpg" || resp.ETag == "" {
+			t.Errorf("PostResponse = %+v", resp)
+		}
+	})
+
+	t.Run("Redirect", func(t *testing.T) {
+		w := do(postObject("/s3/test-bucket", "test-secret",
+			append(conditions, `["starts-with", "$success_action_redirect", "https://app.example.com/"]`),
+			append(fields, [2]string{"success_action_redirect", "https://app.example.com/done?x=1"}), "jpeg data"))
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("PostObject status = %d, body %s", w.Code, w.Body.String())
+		}
+		location, err := url.Parse(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("Location %q: %v", w.Header().Get("Location"), err)
+		}
+		query := location.Query()
+		if location.Host != "app.example.com" || query.Get("x") != "1" || query.Get("key") != "uploads/photo.jpg" || query.Get("etag") == "" {
+			t.Errorf("redirect Location = %s", location)
+		}
+	})
+
+	rejected := []struct {
+		name       string
+		secret     string
+		conditions []string
+		fields     [][2]string
+		file       string
+		wantCode   int
+		wantErr    string
+	}{
+		{"TooLarge", "test-secret", conditions, fields, strings.Repeat("x", 17), http.StatusBadRequest, "EntityTooLarge"},
+		{"TooSmall", "test-secret", conditions, fields, "", http.StatusBadRequest, "EntityTooSmall"},
+		{"WrongSecret", "other-secret", conditions, fields, "jpeg data", http.StatusForbidden, "SignatureDoesNotMatch"},
+		{"Anonymous", "", nil, fields, "jpeg data", http.StatusForbidden, "AccessDenied"},
+		{"ConditionFailed", "test-secret", conditions, [][2]string{{"key", "elsewhere/${filename}"}, {"Content-Type", "image/jpeg"}}, "jpeg data", http.StatusForbidden, "AccessDenied"},
+		{"ExtraField", "test-secret", conditions, append(fields, [2]string{"acl", "public-read"}), "jpeg data", http.StatusForbidden, "AccessDenied"},
+		{"MissingKey", "test-secret", conditions, fields[1:], "jpeg data", http.StatusBadRequest, "InvalidArgument"},
+	}
+	for _, tt := range rejected {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(postObject("/s3/test-bucket", tt.secret, tt.conditions, tt.fields, tt.file))
+			if w.Code != tt.wantCode || !strings.Contains(w.Body.String(), tt.wantErr) {
+				t.Errorf("PostObject = %d %s, want %d %s", w.Code, w.Body.String(), tt.wantCode, tt.wantErr)
+			}
+		})
+	}
+
+	// Nothing from the rejected uploads was stored under their keys
+	if w := get("elsewhere/photo.jpg"); w.Code != http.StatusNotFound {
+		t.Errorf("GetObject of rejected upload status = %d, want 404", w.Code)
+	}
+	if w := get("uploads/photo.jpg"); w.Body.String() != "jpeg data" {
+		t.Errorf("GetObject after rejected uploads = %q, want the accepted file", w.Body.String())
+	}
+
+	t.Run("NoFile", func(t *testing.T) {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		form.WriteField("key", "k")
+		form.Close()
+		req := httptest.NewRequest("POST", "/s3/test-bucket", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		if w := do(req); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "IncorrectNumberOfFilesInPostRequest") {
+			t.Errorf("PostObject without file = %d %s", w.Code, w.Body.String())
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/openendpoint/openendpoint/internal/storage"
+)
+
+// errUnsatisfiableRange is returned when none of the requested ranges
+// overlaps the object
+var errUnsatisfiableRange = errors.New("range not satisfiable")
+
+// byteRange is an inclusive byte range within an object
+type byteRange struct {
+	start, end int64
+}
+
+func (r byteRange) length() int64 {
+	return r.end - r.start + 1
+}
+
+// parseRangeHeader parses an HTTP Range header against an object of the
+// given size. Like S3 it returns nil (serve the whole object) for an absent
+// or syntactically invalid header, and errUnsatisfiableRange when the
+// header is valid but no range overlaps the object.
+func parseRangeHeader(header string, size int64) ([]byteRange, error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !ok {
+		return nil, nil
+	}
+
+	var ranges []byteRange
+	seen := false
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		seen = true
+		first, last, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, nil
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		if first == "" {
+			// Suffix range: the last n bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, nil
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			ranges = append(ranges, byteRange{start: size - n, end: size - 1})
+			continue
+		}
+
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return nil, nil
+		}
+		end := size - 1
+		if last != "" {
+			end, err = strconv.ParseInt(last, 10, 64)
+			if err != nil || end < start {
+				return nil, nil
+			}
+		}
+		if start >= size {
+			continue
+		}
+		if end >= size {
+			end = size - 1
+		}
+		ranges = append(ranges, byteRange{start: start, end: end})
+	}
+
+	if !seen {
+		return nil, nil
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	return ranges, nil
+}
+
+// formatRangeHeader renders ranges back into canonical Range header form
+func formatRangeHeader(ranges []byteRange) string {
+	parts := make([]string, len(ranges))
+	for i, r := range ranges {
+		parts[i] = fmt.Sprintf("%d-%d", r.start, r.end)
+	}
+	return "bytes=" + strings.Join(parts, ",")
+}
+
+// parseCopySourceRange parses an x-amz-copy-source-range header, which
+// unlike Range must name a single range with both bounds: bytes=first-last.
+// Whether the range fits the source object is checked by the engine.
+func parseCopySourceRange(header string) (*storage.Range, bool) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !ok {
+		return nil, false
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return nil, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return nil, false
+	}
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return nil, false
+	}
+	return &storage.Range{Start: start, End: end + 1}, true
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openendpoint/openendpoint/internal/storage"
+)
+
+func TestParseRangeHeader(t *testing.T) {
+	tests := []struct {
+		header  string
+		size    int64
+		want    []byteRange
+		wantErr bool
+	}{
+		{"", 10, nil, false},
+		{"bytes=0-4", 10, []byteRange{{0, 4}}, false},
+		{"bytes=5-", 10, []byteRange{{5, 9}}, false},
+		{"bytes=-3", 10, []byteRange{{7, 9}}, false},
+		{"bytes=-30", 10, []byteRange{{0, 9}}, false},
+		{"bytes=8-30", 10, []byteRange{{8, 9}}, false},
+		{"bytes=0-0, 2-3", 10, []byteRange{{0, 0}, {2, 3}}, false},
+		{"bytes=20-, 1-2", 10, []byteRange{{1, 2}}, false},
+		{"bytes=10-", 10, nil, true},
+		{"bytes=-0", 10, nil, true},
+		{"bytes=0-0", 0, nil, true},
+		{"bytes=", 10, nil, false},
+		{"bytes=4-2", 10, nil, false},
+		{"bytes=a-b", 10, nil, false},
+		{"bytes=5", 10, nil, false},
+		{"lines=0-1", 10, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			got, err := parseRangeHeader(tt.header, tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRangeHeader() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRangeHeader() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatRangeHeader(t *testing.T) {
+	got := formatRangeHeader([]byteRange{{0, 4}, {8, 9}})
+	if got != "bytes=0-4,8-9" {
+		t.Errorf("formatRangeHeader() = %s, want bytes=0-4,8-9", got)
+	}
+}
+
+func TestParseCopySourceRange(t *testing.T) {
+	tests := []struct {
+		header string
+		want   *storage.Range
+		ok     bool
+	}{
+		{"bytes=0-4", &storage.Range{Start: 0, End: 5}, true},
+		{"bytes=5-5", &storage.Range{Start: 5, End: 6}, true},
+		{"bytes=5-", nil, false},
+		{"bytes=-3", nil, false},
+		{"bytes=4-2", nil, false},
+		{"bytes=0-1,3-4", nil, false},
+		{"0-4", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			got, ok := parseCopySourceRange(tt.header)
+			if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCopySourceRange() = %v, %v, want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
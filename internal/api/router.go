@@ -1,12 +1,14 @@
 package api
 
 import (
-	"bytes"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -14,8 +16,8 @@ import (
 	"github.com/openendpoint/openendpoint/internal/auth"
 	"github.com/openendpoint/openendpoint/internal/config"
 	"github.com/openendpoint/openendpoint/internal/engine"
+	"github.com/openendpoint/openendpoint/internal/iam"
 	"github.com/openendpoint/openendpoint/internal/metadata"
-	s3select "github.com/openendpoint/openendpoint/internal/s3select"
 	"github.com/openendpoint/openendpoint/internal/tags"
 	s3types "github.com/openendpoint/openendpoint/pkg/s3types"
 	"github.com/prometheus/client_golang/prometheus"
@@ -28,11 +30,13 @@ const maxRequestBodySize = 10 * 1024 * 1024
 
 // Router handles S3 API requests
 type Router struct {
-	engine        *engine.ObjectService
-	auth          *auth.Auth
-	logger        *zap.SugaredLogger
-	config        *config.Config
-	selectService *s3select.SelectService
+	engine   *engine.ObjectService
+	auth     *auth.Auth
+	iam      *iam.Manager // gates KMS key use by IAM users, nil allows all
+	logger   *zap.SugaredLogger
+	config   *config.Config
+	domains  []string // base domains of virtual-hosted-style requests
+	rootPath bool     // the S3 API is served at / rather than /s3/
 }
 
 // s3RequestsTotal is a metric for tracking S3 API requests
@@ -43,16 +47,22 @@ var s3RequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
 
 // NewRouter creates a new S3 API router
 func NewRouter(engine *engine.ObjectService, auth *auth.Auth, logger *zap.SugaredLogger, cfg *config.Config) *Router {
-	selectLogger, _ := zap.NewProduction()
 	return &Router{
-		engine:        engine,
-		auth:          auth,
-		logger:        logger,
-		config:        cfg,
-		selectService: s3select.NewSelectService(selectLogger),
+		engine:   engine,
+		auth:     auth,
+		logger:   logger,
+		config:   cfg,
+		domains:  normalizeDomains(cfg.Server.Domains),
+		rootPath: cfg.Server.S3Root,
 	}
 }
 
+// SetIAM sets the IAM manager whose policies decide which KMS keys IAM
+// users may use
+func (r *Router) SetIAM(m *iam.Manager) {
+	r.iam = m
+}
+
 // readLimitedBody reads request body with size limit to prevent memory exhaustion
 func readLimitedBody(body io.Reader) ([]byte, error) {
 	return io.ReadAll(io.LimitReader(body, maxRequestBodySize+1))
@@ -82,22 +92,35 @@ func sanitizeHeaderValue(value string) string {
 
 // ServeHTTP handles S3 API requests
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	// Check for presigned URL query parameters
-	if req.URL.Query().Get("X-Amz-Signature") != "" {
-		// Verify presigned URL
-		bucket, key, err := r.auth.VerifyPresignedURL(req)
-		if err != nil {
-			r.logger.Warnw("invalid presigned URL", "error", err)
-			r.writeError(w, ErrSignatureDoesNotMatch)
-			return
-		}
+	bucket, key, err := r.bucketKey(req)
+	if err != nil {
+		r.writeError(w, ErrInvalidURI)
+		return
+	}
+	if r.virtualHostBucket(req.Host) != "" {
+		req = auth.WithVirtualHostBucket(req, bucket)
+	}
 
-		// For presigned URLs, we need to set the bucket and key properly
-		// The URL path should be adjusted to include the bucket for path-style
-		if bucket != "" && key != "" {
-			// Update the path to include bucket/key for path-style URLs
-			req.URL.Path = "/s3/" + bucket + "/" + key
-		}
+	// Browser POST uploads are signed by the policy in their form, which
+	// the handler verifies once it has read the fields
+	if isPostObject(req, bucket, key) {
+		r.handlePostObject(w, req, bucket)
+		return
+	}
+
+	// Authenticate header-signed, presigned and anonymous requests alike,
+	// then decide them by policy. DeleteObjects decides each of its keys.
+	action := s3Action(req, bucket, key)
+	var s3Err S3Error
+	if _, batchDelete := req.URL.Query()["delete"]; batchDelete && key == "" && req.Method == http.MethodPost {
+		_, s3Err = r.authenticate(req, bucket, action)
+	} else {
+		s3Err = r.authorize(req, bucket, key, action)
+	}
+	if s3Err != nil {
+		r.writeError(w, s3Err)
+		s3RequestsTotal.WithLabelValues(action, strconv.Itoa(s3Err.StatusCode())).Inc()
+		return
 	}
 
 	// Route request
@@ -106,26 +129,34 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 // route routes the request to the appropriate handler
 func (r *Router) route(w http.ResponseWriter, req *http.Request) {
-	// Get bucket and key from path
-	bucket, key, err := parseBucketKey(req, req.URL.Path)
+	// Get bucket and key from the path or Host header
+	bucket, key, err := r.bucketKey(req)
 	if err != nil {
 		r.writeError(w, ErrInvalidURI)
 		return
 	}
 
+	// Sub-resources are usually sent without a value (?versioning), so
+	// their presence is what selects the operation
+	query := req.URL.Query()
+
 	// Check for multipart upload operations
 	if bucket != "" && key != "" {
 		// Check if uploads parameter exists (S3 uses ?uploads or ?uploads=)
-		_, hasUploads := req.URL.Query()["uploads"]
+		hasUploads := query.Has("uploads")
 		if hasUploads && req.Method == http.MethodPost {
 			r.handleCreateMultipartUpload(w, req, bucket, key)
 			return
 		}
-		if req.URL.Query().Get("uploadId") != "" {
+		if query.Get("uploadId") != "" {
 			switch req.Method {
 			case http.MethodPut:
-				if req.URL.Query().Get("partNumber") != "" {
-					r.handleUploadPart(w, req, bucket, key)
+				if query.Get("partNumber") != "" {
+					if req.Header.Get("x-amz-copy-source") != "" {
+						r.handleUploadPartCopy(w, req, bucket, key)
+					} else {
+						r.handleUploadPart(w, req, bucket, key)
+					}
 					return
 				}
 			case http.MethodPost:
@@ -148,61 +179,63 @@ func (r *Router) route(w http.ResponseWriter, req *http.Request) {
 			r.handleListBuckets(w, req)
 		} else if key == "" {
 			// Check for query string operations on bucket
-			if req.URL.Query().Get("versioning") != "" {
+			if query.Has("versioning") {
 				r.handleGetBucketVersioning(w, req, bucket)
-			} else if req.URL.Query().Get("lifecycle") != "" {
+			} else if query.Has("lifecycle") {
 				r.handleGetBucketLifecycle(w, req, bucket)
-			} else if req.URL.Query().Get("cors") != "" {
+			} else if query.Has("cors") {
 				r.handleGetBucketCors(w, req, bucket)
-			} else if req.URL.Query().Get("policy") != "" {
+			} else if query.Has("policy") {
 				r.handleGetBucketPolicy(w, req, bucket)
-			} else if req.URL.Query().Get("encryption") != "" {
+			} else if query.Has("encryption") {
 				r.handleGetBucketEncryption(w, req, bucket)
-			} else if req.URL.Query().Get("replication") != "" {
+			} else if query.Has("replication") {
 				r.handleGetBucketReplication(w, req, bucket)
-			} else if req.URL.Query().Get("tagging") != "" {
+			} else if query.Has("tagging") {
 				r.handleGetBucketTags(w, req, bucket)
-			} else if req.URL.Query().Get("object-lock") != "" {
+			} else if query.Has("object-lock") {
 				r.handleGetObjectLock(w, req, bucket)
-			} else if req.URL.Query().Get("public-access-block") != "" {
+			} else if query.Has("public-access-block") {
 				r.handleGetPublicAccessBlock(w, req, bucket)
-			} else if req.URL.Query().Get("accelerate") != "" {
+			} else if query.Has("accelerate") {
 				r.handleGetBucketAccelerate(w, req, bucket)
-			} else if req.URL.Query().Get("inventory") != "" {
+			} else if query.Has("inventory") {
 				r.handleGetBucketInventory(w, req, bucket)
-			} else if req.URL.Query().Get("analytics") != "" {
+			} else if query.Has("analytics") {
 				r.handleGetBucketAnalytics(w, req, bucket)
-			} else if req.URL.Query().Get("website") != "" {
+			} else if query.Has("website") {
 				r.handleGetBucketWebsite(w, req, bucket)
-			} else if req.URL.Query().Get("notification") != "" {
+			} else if query.Has("notification") {
 				r.handleGetBucketNotification(w, req, bucket)
-			} else if req.URL.Query().Get("logging") != "" {
+			} else if query.Has("logging") {
 				r.handleGetBucketLogging(w, req, bucket)
-			} else if req.URL.Query().Get("location") != "" {
+			} else if query.Has("location") {
 				r.handleGetBucketLocation(w, req, bucket)
-			} else if req.URL.Query().Get("ownership-controls") != "" {
+			} else if query.Has("ownership-controls") {
 				r.handleGetBucketOwnershipControls(w, req, bucket)
-			} else if req.URL.Query().Get("metrics") != "" {
+			} else if query.Has("metrics") {
 				r.handleGetBucketMetrics(w, req, bucket)
-			} else if req.URL.Query().Get("acl") != "" {
+			} else if query.Has("acl") {
 				r.handleGetBucketAcl(w, req, bucket)
-			} else if req.URL.Query().Get("versions") != "" {
+			} else if query.Has("versions") {
 				r.handleListObjectVersions(w, req, bucket)
 			} else {
 				r.handleListObjects(w, req, bucket)
 			}
 		} else {
 			// Check for query string operations on object
-			if req.URL.Query().Get("presignedurl") != "" {
+			if query.Has("presignedurl") {
 				r.handleGetPresignedURL(w, req, bucket, key)
-			} else if req.URL.Query().Get("acl") != "" {
+			} else if query.Has("acl") {
 				r.handleGetObjectAcl(w, req, bucket, key)
-			} else if req.URL.Query().Get("tagging") != "" {
+			} else if query.Has("tagging") {
 				r.handleGetObjectTags(w, req, bucket, key)
-			} else if req.URL.Query().Get("retention") != "" {
+			} else if query.Has("retention") {
 				r.handleGetObjectRetention(w, req, bucket, key)
-			} else if req.URL.Query().Get("legal-hold") != "" {
+			} else if query.Has("legal-hold") {
 				r.handleGetObjectLegalHold(w, req, bucket, key)
+			} else if query.Has("attributes") {
+				r.handleGetObjectAttributes(w, req, bucket, key)
 			} else {
 				r.handleGetObject(w, req, bucket, key)
 			}
@@ -212,58 +245,58 @@ func (r *Router) route(w http.ResponseWriter, req *http.Request) {
 			r.writeError(w, ErrInvalidBucketName)
 		} else if key == "" {
 			// Check for query string operations on bucket
-			if req.URL.Query().Get("versioning") != "" {
+			if query.Has("versioning") {
 				r.handlePutBucketVersioning(w, req, bucket)
-			} else if req.URL.Query().Get("lifecycle") != "" {
+			} else if query.Has("lifecycle") {
 				r.handlePutBucketLifecycle(w, req, bucket)
-			} else if req.URL.Query().Get("cors") != "" {
+			} else if query.Has("cors") {
 				r.handlePutBucketCors(w, req, bucket)
-			} else if req.URL.Query().Get("policy") != "" {
+			} else if query.Has("policy") {
 				r.handlePutBucketPolicy(w, req, bucket)
-			} else if req.URL.Query().Get("encryption") != "" {
+			} else if query.Has("encryption") {
 				r.handlePutBucketEncryption(w, req, bucket)
-			} else if req.URL.Query().Get("replication") != "" {
+			} else if query.Has("replication") {
 				r.handlePutBucketReplication(w, req, bucket)
-			} else if req.URL.Query().Get("tagging") != "" {
+			} else if query.Has("tagging") {
 				r.handlePutBucketTags(w, req, bucket)
-			} else if req.URL.Query().Get("object-lock") != "" {
+			} else if query.Has("object-lock") {
 				r.handlePutObjectLock(w, req, bucket)
-			} else if req.URL.Query().Get("public-access-block") != "" {
+			} else if query.Has("public-access-block") {
 				r.handlePutPublicAccessBlock(w, req, bucket)
-			} else if req.URL.Query().Get("accelerate") != "" {
+			} else if query.Has("accelerate") {
 				r.handlePutBucketAccelerate(w, req, bucket)
-			} else if req.URL.Query().Get("inventory") != "" {
+			} else if query.Has("inventory") {
 				r.handlePutBucketInventory(w, req, bucket)
-			} else if req.URL.Query().Get("analytics") != "" {
+			} else if query.Has("analytics") {
 				r.handlePutBucketAnalytics(w, req, bucket)
-			} else if req.URL.Query().Get("website") != "" {
+			} else if query.Has("website") {
 				r.handlePutBucketWebsite(w, req, bucket)
-			} else if req.URL.Query().Get("notification") != "" {
+			} else if query.Has("notification") {
 				r.handlePutBucketNotification(w, req, bucket)
-			} else if req.URL.Query().Get("logging") != "" {
+			} else if query.Has("logging") {
 				r.handlePutBucketLogging(w, req, bucket)
-			} else if req.URL.Query().Get("location") != "" {
+			} else if query.Has("location") {
 				r.handlePutBucketLocation(w, req, bucket)
-			} else if req.URL.Query().Get("ownership-controls") != "" {
+			} else if query.Has("ownership-controls") {
 				r.handlePutBucketOwnershipControls(w, req, bucket)
-			} else if req.URL.Query().Get("metrics") != "" {
+			} else if query.Has("metrics") {
 				r.handlePutBucketMetrics(w, req, bucket)
-			} else if req.URL.Query().Get("acl") != "" {
+			} else if query.Has("acl") {
 				r.handlePutBucketAcl(w, req, bucket)
 			} else {
 				r.handleCreateBucket(w, req, bucket)
 			}
 		} else {
 			// Check for query string operations on object
-			if req.URL.Query().Get("presignedurl") != "" {
+			if query.Has("presignedurl") {
 				r.handlePutPresignedURL(w, req, bucket, key)
-			} else if req.URL.Query().Get("acl") != "" {
+			} else if query.Has("acl") {
 				r.handlePutObjectAcl(w, req, bucket, key)
-			} else if req.URL.Query().Get("tagging") != "" {
+			} else if query.Has("tagging") {
 				r.handlePutObjectTags(w, req, bucket, key)
-			} else if req.URL.Query().Get("retention") != "" {
+			} else if query.Has("retention") {
 				r.handlePutObjectRetention(w, req, bucket, key)
-			} else if req.URL.Query().Get("legal-hold") != "" {
+			} else if query.Has("legal-hold") {
 				r.handlePutObjectLegalHold(w, req, bucket, key)
 			} else if req.Header.Get("x-amz-copy-source") != "" {
 				r.handleCopyObject(w, req, bucket, key)
@@ -273,46 +306,46 @@ func (r *Router) route(w http.ResponseWriter, req *http.Request) {
 		}
 	case http.MethodDelete:
 		if key == "" {
-			if req.URL.Query().Get("inventory") != "" {
+			if query.Has("inventory") {
 				r.handleDeleteBucketInventory(w, req, bucket)
-			} else if req.URL.Query().Get("analytics") != "" {
+			} else if query.Has("analytics") {
 				r.handleDeleteBucketAnalytics(w, req, bucket)
-			} else if req.URL.Query().Get("website") != "" {
+			} else if query.Has("website") {
 				r.handleDeleteBucketWebsite(w, req, bucket)
-			} else if req.URL.Query().Get("policy") != "" {
+			} else if query.Has("policy") {
 				r.handleDeleteBucketPolicy(w, req, bucket)
-			} else if req.URL.Query().Get("lifecycle") != "" {
+			} else if query.Has("lifecycle") {
 				r.handleDeleteBucketLifecycle(w, req, bucket)
-			} else if req.URL.Query().Get("cors") != "" {
+			} else if query.Has("cors") {
 				r.handleDeleteBucketCors(w, req, bucket)
-			} else if req.URL.Query().Get("encryption") != "" {
+			} else if query.Has("encryption") {
 				r.handleDeleteBucketEncryption(w, req, bucket)
-			} else if req.URL.Query().Get("replication") != "" {
+			} else if query.Has("replication") {
 				r.handleDeleteBucketReplication(w, req, bucket)
-			} else if req.URL.Query().Get("tagging") != "" {
+			} else if query.Has("tagging") {
 				r.handleDeleteBucketTags(w, req, bucket)
-			} else if req.URL.Query().Get("object-lock") != "" {
+			} else if query.Has("object-lock") {
 				r.handleDeleteObjectLock(w, req, bucket)
-			} else if req.URL.Query().Get("public-access-block") != "" {
+			} else if query.Has("public-access-block") {
 				r.handleDeletePublicAccessBlock(w, req, bucket)
-			} else if req.URL.Query().Get("accelerate") != "" {
+			} else if query.Has("accelerate") {
 				r.handleDeleteBucketAccelerate(w, req, bucket)
-			} else if req.URL.Query().Get("notification") != "" {
+			} else if query.Has("notification") {
 				r.handleDeleteBucketNotification(w, req, bucket)
-			} else if req.URL.Query().Get("logging") != "" {
+			} else if query.Has("logging") {
 				r.handleDeleteBucketLogging(w, req, bucket)
-			} else if req.URL.Query().Get("ownership-controls") != "" {
+			} else if query.Has("ownership-controls") {
 				r.handleDeleteBucketOwnershipControls(w, req, bucket)
-			} else if req.URL.Query().Get("metrics") != "" {
+			} else if query.Has("metrics") {
 				r.handleDeleteBucketMetrics(w, req, bucket)
-			} else if req.URL.Query().Get("acl") != "" {
+			} else if query.Has("acl") {
 				r.handleDeleteBucketAcl(w, req, bucket)
 			} else {
 				r.handleDeleteBucket(w, req, bucket)
 			}
 		} else {
 			// Check for query string operations on object
-			if req.URL.Query().Get("tagging") != "" {
+			if query.Has("tagging") {
 				r.handleDeleteObjectTags(w, req, bucket, key)
 			} else {
 				r.handleDeleteObject(w, req, bucket, key)
@@ -328,19 +361,19 @@ func (r *Router) route(w http.ResponseWriter, req *http.Request) {
 		}
 	case http.MethodPost:
 		// Handle post to bucket/key (S3 Select)
-		if bucket != "" && key != "" && req.URL.Query().Get("select") != "" {
+		if bucket != "" && key != "" && query.Has("select") {
 			r.handleSelectObjectContent(w, req, bucket, key)
 			return
 		}
 		// Handle post to bucket/key (Restore Object)
-		if bucket != "" && key != "" && req.URL.Query().Get("restore") != "" {
+		if bucket != "" && key != "" && query.Has("restore") {
 			r.handleRestoreObject(w, req, bucket, key)
 			return
 		}
 		// Handle post to bucket
 		if bucket != "" && key == "" {
 			// Check for query string operations
-			if req.URL.Query().Get("delete") != "" {
+			if query.Has("delete") {
 				r.handleDeleteObjects(w, req, bucket)
 				return
 			}
@@ -354,7 +387,7 @@ func (r *Router) route(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-// parseBucketKey parses the bucket and key from the request path
+// parseBucketKey parses the bucket and key from a path-style request path
 func parseBucketKey(req *http.Request, path string) (bucket, key string, err error) {
 	// Handle S3 API path format
 	// /s3/ -> root (list buckets)
@@ -370,6 +403,11 @@ func parseBucketKey(req *http.Request, path string) (bucket, key string, err err
 		}
 	}
 
+	return splitBucketKey(path)
+}
+
+// splitBucketKey splits a path relative to the S3 root into bucket and key
+func splitBucketKey(path string) (bucket, key string, err error) {
 	// If path is empty now, it's root (list buckets)
 	if path == "" {
 		return "", "", nil
@@ -451,18 +489,22 @@ func (r *Router) handleListBuckets(w http.ResponseWriter, req *http.Request) {
 	s3RequestsTotal.WithLabelValues("ListBuckets", "200").Inc()
 }
 
-// handleListObjects handles ListObjects
+// handleListObjects handles ListObjects (GET /bucket) and, with
+// list-type=2, ListObjectsV2
 func (r *Router) handleListObjects(w http.ResponseWriter, req *http.Request, bucket string) {
 	ctx := req.Context()
 
-	prefix := req.URL.Query().Get("prefix")
-	delimiter := req.URL.Query().Get("delimiter")
-	maxKeys := parseInt(req.URL.Query().Get("max-keys"), 1000)
+	params, s3err := parseListParams(req.URL.Query())
+	if s3err != nil {
+		r.writeError(w, s3err)
+		return
+	}
 
 	result, err := r.engine.ListObjects(ctx, bucket, engine.ListObjectsOptions{
-		Prefix:    prefix,
-		Delimiter: delimiter,
-		MaxKeys:   maxKeys,
+		Prefix:    params.prefix,
+		Delimiter: params.delimiter,
+		MaxKeys:   params.maxKeys,
+		Marker:    params.marker,
 	})
 	if err != nil {
 		r.logger.Warnw("failed to list objects", "bucket", bucket, "error", err)
@@ -471,33 +513,68 @@ func (r *Router) handleListObjects(w http.ResponseWriter, req *http.Request, buc
 	}
 
 	// Convert engine objects to S3 objects
+	enc := params.encode
 	contents := make([]s3types.Object, len(result.Objects))
 	for i, obj := range result.Objects {
+		storageClass := obj.StorageClass
+		if storageClass == "" {
+			storageClass = "STANDARD"
+		}
 		contents[i] = s3types.Object{
-			Key:          obj.Key,
-			LastModified: time.Unix(obj.LastModified, 0).Format(time.RFC3339),
+			Key:          enc(obj.Key),
+			LastModified: time.Unix(obj.LastModified, 0).UTC().Format(time.RFC3339),
 			ETag:         obj.ETag,
 			Size:         fmt.Sprintf("%d", obj.Size),
-			StorageClass: obj.StorageClass,
-			Owner: &s3types.Owner{
-				ID:          "root",
-				DisplayName: "root",
-			},
+			StorageClass: storageClass,
+		}
+		// V2 only reports owners when asked to
+		if !params.v2 || params.fetchOwner {
+			contents[i].Owner = &s3types.Owner{ID: "root", DisplayName: "root"}
 		}
 	}
 
-	xmlResult := s3types.ListObjectsV2Output{
-		Name:                  bucket,
-		Prefix:                prefix,
-		Delimiter:             delimiter,
-		MaxKeys:               fmt.Sprintf("%d", maxKeys),
-		KeyCount:              fmt.Sprintf("%d", len(result.Objects)),
-		IsTruncated:           result.IsTruncated,
-		Contents:              contents,
-		CommonPrefixes:        result.CommonPrefixes,
-		NextContinuationToken: result.NextMarker,
+	commonPrefixes := make([]s3types.CommonPrefix, len(result.CommonPrefixes))
+	for i, cp := range result.CommonPrefixes {
+		commonPrefixes[i] = s3types.CommonPrefix{Prefix: enc(cp)}
+	}
+
+	if params.v2 {
+		xmlResult := s3types.ListBucketResultV2{
+			Name:              bucket,
+			Prefix:            enc(params.prefix),
+			Delimiter:         enc(params.delimiter),
+			MaxKeys:           params.maxKeys,
+			KeyCount:          len(contents) + len(commonPrefixes),
+			EncodingType:      params.encodingType,
+			IsTruncated:       result.IsTruncated,
+			ContinuationToken: params.continuationToken,
+			StartAfter:        enc(params.startAfter),
+			Contents:          contents,
+			CommonPrefixes:    commonPrefixes,
+		}
+		if result.IsTruncated {
+			xmlResult.NextContinuationToken = encodeContinuationToken(result.NextMarker)
+		}
+		r.writeXML(w, http.StatusOK, xmlResult)
+		s3RequestsTotal.WithLabelValues("ListObjectsV2", "200").Inc()
+		return
 	}
 
+	xmlResult := s3types.ListBucketResult{
+		Name:           bucket,
+		Prefix:         enc(params.prefix),
+		Marker:         enc(params.marker),
+		Delimiter:      enc(params.delimiter),
+		MaxKeys:        params.maxKeys,
+		EncodingType:   params.encodingType,
+		IsTruncated:    result.IsTruncated,
+		Contents:       contents,
+		CommonPrefixes: commonPrefixes,
+	}
+	// Without a delimiter clients resume from the last key themselves
+	if result.IsTruncated && params.delimiter != "" {
+		xmlResult.NextMarker = enc(result.NextMarker)
+	}
 	r.writeXML(w, http.StatusOK, xmlResult)
 	s3RequestsTotal.WithLabelValues("ListObjects", "200").Inc()
 }
@@ -506,68 +583,78 @@ func (r *Router) handleListObjects(w http.ResponseWriter, req *http.Request, buc
 func (r *Router) handleListObjectVersions(w http.ResponseWriter, req *http.Request, bucket string) {
 	ctx := req.Context()
 
-	prefix := req.URL.Query().Get("prefix")
-	delimiter := req.URL.Query().Get("delimiter")
-	maxKeys := parseInt(req.URL.Query().Get("max-keys"), 1000)
+	query := req.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	keyMarker := query.Get("key-marker")
+	versionIDMarker := query.Get("version-id-marker")
+	maxKeys := parseInt(query.Get("max-keys"), 1000)
+	if maxKeys < 0 || maxKeys > 1000 {
+		maxKeys = 1000
+	}
+
+	// A version marker only makes sense within a key
+	if versionIDMarker != "" && keyMarker == "" {
+		r.writeError(w, ErrInvalidArgument)
+		return
+	}
 
-	objects, err := r.engine.ListObjects(ctx, bucket, engine.ListObjectsOptions{
-		Prefix:    prefix,
-		Delimiter: delimiter,
-		MaxKeys:   maxKeys,
+	result, err := r.engine.ListObjectVersions(ctx, bucket, engine.ListObjectVersionsOptions{
+		Prefix:          prefix,
+		Delimiter:       delimiter,
+		MaxKeys:         maxKeys,
+		KeyMarker:       keyMarker,
+		VersionIDMarker: versionIDMarker,
 	})
 	if err != nil {
 		r.logger.Warnw("failed to list object versions", "bucket", bucket, "error", err)
-		r.writeError(w, ErrInternal)
+		r.writeError(w, ErrNoSuchBucket)
 		return
 	}
 
-	// Build XML response - same as ListObjects for now
-	// In a full implementation, this would include version IDs
-	w.Header().Set("Content-Type", "application/xml")
-	w.WriteHeader(http.StatusOK)
-
-	var xmlResult string
-	if len(objects.Objects) == 0 {
-		xmlResult = fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<ListVersionsResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
-  <Name>%s</Name>
-  <Prefix>%s</Prefix>
-  <KeyMarker></KeyMarker>
-  <VersionIdMarker></VersionIdMarker>
-  <MaxKeys>%d</MaxKeys>
-  <IsTruncated>false</IsTruncated>
-</ListVersionsResult>`, bucket, prefix, maxKeys)
-	} else {
-		var contents string
-		for _, obj := range objects.Objects {
-			contents += fmt.Sprintf(`<Version>
-    <Key>%s</Key>
-    <VersionId>%s</VersionId>
-    <IsLatest>%v</IsLatest>
-    <LastModified>%s</LastModified>
-    <ETag>%s</ETag>
-    <Size>%d</Size>
-  </Version>`,
-				tags.EscapeXML(obj.Key),
-				obj.VersionID,
-				obj.IsLatest,
-				time.Unix(obj.LastModified, 0).Format(time.RFC3339),
-				obj.ETag,
-				obj.Size)
-		}
-		xmlResult = fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<ListVersionsResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
-  <Name>%s</Name>
-  <Prefix>%s</Prefix>
-  <KeyMarker></KeyMarker>
-  <VersionIdMarker></VersionIdMarker>
-  <MaxKeys>%d</MaxKeys>
-  <IsTruncated>false</IsTruncated>
-  %s
-</ListVersionsResult>`, bucket, prefix, maxKeys, contents)
-	}
-
-	w.Write([]byte(xmlResult))
+	owner := &s3types.Owner{ID: "root", DisplayName: "root"}
+	versions := make([]s3types.ObjectVersion, len(result.Versions))
+	for i, v := range result.Versions {
+		entry := s3types.ObjectVersion{
+			XMLName:      xml.Name{Local: "Version"},
+			Key:          v.Key,
+			VersionID:    v.VersionID,
+			IsLatest:     v.IsLatest,
+			LastModified: time.Unix(v.LastModified, 0).UTC().Format(time.RFC3339),
+			Owner:        owner,
+		}
+		if v.IsDeleteMarker {
+			entry.XMLName.Local = "DeleteMarker"
+		} else {
+			size := v.Size
+			entry.Size = &size
+			entry.ETag = v.ETag
+			entry.StorageClass = v.StorageClass
+			if entry.StorageClass == "" {
+				entry.StorageClass = "STANDARD"
+			}
+		}
+		versions[i] = entry
+	}
+
+	commonPrefixes := make([]s3types.CommonPrefix, len(result.CommonPrefixes))
+	for i, cp := range result.CommonPrefixes {
+		commonPrefixes[i] = s3types.CommonPrefix{Prefix: cp}
+	}
+
+	r.writeXML(w, http.StatusOK, s3types.ListVersionsResult{
+		Name:                bucket,
+		Prefix:              prefix,
+		KeyMarker:           keyMarker,
+		VersionIDMarker:     versionIDMarker,
+		NextKeyMarker:       result.NextKeyMarker,
+		NextVersionIDMarker: result.NextVersionIDMarker,
+		Delimiter:           delimiter,
+		MaxKeys:             maxKeys,
+		IsTruncated:         result.IsTruncated,
+		Versions:            versions,
+		CommonPrefixes:      commonPrefixes,
+	})
 	s3RequestsTotal.WithLabelValues("ListObjectVersions", "200").Inc()
 }
 
@@ -575,30 +662,97 @@ func (r *Router) handleListObjectVersions(w http.ResponseWriter, req *http.Reque
 func (r *Router) handleGetObject(w http.ResponseWriter, req *http.Request, bucket, key string) {
 	ctx := req.Context()
 
-	obj, err := r.engine.GetObject(ctx, bucket, key, engine.GetObjectOptions{})
+	sse, s3err := r.requestEncryption(req)
+	if s3err != nil {
+		r.writeError(w, s3err)
+		return
+	}
+
+	obj, err := r.engine.GetObject(ctx, bucket, key, engine.GetObjectOptions{
+		VersionID:  req.URL.Query().Get("versionId"),
+		Conditions: requestConditions(req, ""),
+		Encryption: sse,
+	})
 	if err != nil {
+		if r.writePreconditionError(w, err) {
+			s3RequestsTotal.WithLabelValues("GetObject", preconditionStatus(err)).Inc()
+			return
+		}
+		if r.writeDeleteMarkerError(w, err) {
+			s3RequestsTotal.WithLabelValues("GetObject", deleteMarkerStatus(err)).Inc()
+			return
+		}
 		r.logger.Warnw("failed to get object", "bucket", bucket, "key", key, "error", err)
+		if s3err := encryptionErrorToS3(err); s3err != nil {
+			r.writeError(w, s3err)
+			return
+		}
 		r.writeError(w, ErrNoSuchKey)
 		return
 	}
 	defer obj.Body.Close()
 
+	// Validate the Range header up front so unsatisfiable ranges get an S3
+	// error body; malformed headers are ignored and the whole object served
+	ranges, err := parseRangeHeader(req.Header.Get("Range"), obj.Size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", obj.Size))
+		r.writeError(w, ErrInvalidRange)
+		s3RequestsTotal.WithLabelValues("GetObject", "416").Inc()
+		return
+	}
+	if ranges != nil {
+		req.Header.Set("Range", formatRangeHeader(ranges))
+	} else {
+		req.Header.Del("Range")
+	}
+
 	// Set headers (sanitize user-controlled values to prevent header injection)
-	w.Header().Set("Content-Type", sanitizeHeaderValue(obj.ContentType))
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", obj.Size))
+	objectHeaders{
+		ContentType:        obj.ContentType,
+		ContentEncoding:    obj.ContentEncoding,
+		CacheControl:       obj.CacheControl,
+		ContentDisposition: obj.ContentDisposition,
+		ContentLanguage:    obj.ContentLanguage,
+		Expires:            obj.Expires,
+		Metadata:           obj.Metadata,
+	}.write(w)
+	applyResponseOverrides(w, req.URL.Query())
 	w.Header().Set("ETag", sanitizeHeaderValue(obj.ETag))
-
-	// Use a buffer to ensure data is properly sent
-	data, err := io.ReadAll(obj.Body)
-	if err != nil {
-		r.logger.Warnw("failed to read object data", "bucket", bucket, "key", key, "error", err)
-		r.writeError(w, ErrInternal)
+	w.Header().Set("Accept-Ranges", "bytes")
+	setLastModified(w, obj.LastModified)
+	setVersionID(w, obj.VersionID)
+	setEncryptionHeaders(w, obj.Encryption)
+	// A checksum covers the whole object, so ranged reads never carry one
+	if ranges == nil && checksumModeEnabled(req) {
+		setChecksumHeaders(w, obj.ChecksumAlgorithm, obj.Checksum)
+	}
+
+	// Preconditions were evaluated by the engine; only If-Range is left to
+	// ServeContent
+	for _, h := range []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"} {
+		req.Header.Del(h)
+	}
+
+	body, ok := obj.Body.(io.ReadSeeker)
+	if !ok {
+		// Backend reader cannot seek: stream the whole object
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", obj.Size))
+		if _, err := io.Copy(w, obj.Body); err != nil {
+			r.logger.Warnw("failed to stream object data", "bucket", bucket, "key", key, "error", err)
+		}
+		s3RequestsTotal.WithLabelValues("GetObject", "200").Inc()
 		return
 	}
 
-	// Write data directly
-	w.Write(data)
+	// ServeContent streams the body and answers single ranges with 206 and
+	// Content-Range, and multiple ranges with multipart/byteranges
+	http.ServeContent(w, req, "", time.Unix(obj.LastModified, 0), body)
 
+	if ranges != nil {
+		s3RequestsTotal.WithLabelValues("GetObject", "206").Inc()
+		return
+	}
 	s3RequestsTotal.WithLabelValues("GetObject", "200").Inc()
 }
 
@@ -606,21 +760,164 @@ func (r *Router) handleGetObject(w http.ResponseWriter, req *http.Request, bucke
 func (r *Router) handleHeadObject(w http.ResponseWriter, req *http.Request, bucket, key string) {
 	ctx := req.Context()
 
-	meta, err := r.engine.HeadObject(ctx, bucket, key)
+	sse, s3err := r.requestEncryption(req)
+	if s3err != nil {
+		r.writeError(w, s3err)
+		return
+	}
+
+	meta, err := r.engine.HeadObject(ctx, bucket, key, engine.HeadObjectOptions{
+		VersionID:  req.URL.Query().Get("versionId"),
+		Conditions: requestConditions(req, ""),
+		Encryption: sse,
+	})
 	if err != nil {
+		if r.writePreconditionError(w, err) {
+			s3RequestsTotal.WithLabelValues("HeadObject", preconditionStatus(err)).Inc()
+			return
+		}
+		if r.writeDeleteMarkerError(w, err) {
+			s3RequestsTotal.WithLabelValues("HeadObject", deleteMarkerStatus(err)).Inc()
+			return
+		}
 		r.logger.Warnw("failed to head object", "bucket", bucket, "key", key, "error", err)
+		if s3err := encryptionErrorToS3(err); s3err != nil {
+			r.writeError(w, s3err)
+			return
+		}
 		r.writeError(w, ErrNoSuchKey)
 		return
 	}
 
-	w.Header().Set("Content-Type", sanitizeHeaderValue(meta.ContentType))
+	objectHeaders{
+		ContentType:        meta.ContentType,
+		ContentEncoding:    meta.ContentEncoding,
+		CacheControl:       meta.CacheControl,
+		ContentDisposition: meta.ContentDisposition,
+		ContentLanguage:    meta.ContentLanguage,
+		Expires:            meta.Expires,
+		Metadata:           meta.Metadata,
+	}.write(w)
 	w.Header().Set("Content-Length", fmt.Sprintf("%d", meta.Size))
 	w.Header().Set("ETag", sanitizeHeaderValue(meta.ETag))
+	w.Header().Set("Accept-Ranges", "bytes")
+	setLastModified(w, meta.LastModified)
+	setVersionID(w, meta.VersionID)
+	setEncryptionHeaders(w, meta.Encryption)
+	if checksumModeEnabled(req) {
+		setChecksumHeaders(w, meta.ChecksumAlgorithm, meta.Checksum)
+	}
 	w.WriteHeader(http.StatusOK)
 
 	s3RequestsTotal.WithLabelValues("HeadObject", "200").Inc()
 }
 
+// objectAttributes are the names x-amz-object-attributes may list
+var objectAttributes = map[string]bool{
+	"ETag":         true,
+	"Checksum":     true,
+	"ObjectParts":  true,
+	"StorageClass": true,
+	"ObjectSize":   true,
+}
+
+// maxListParts is the most parts returned in one page of ObjectParts
+const maxListParts = 1000
+
+// handleGetObjectAttributes handles GetObjectAttributes (GET ?attributes),
+// returning only the attributes named in x-amz-object-attributes
+func (r *Router) handleGetObjectAttributes(w http.ResponseWriter, req *http.Request, bucket, key string) {
+	ctx := req.Context()
+
+	requested := make(map[string]bool)
+	for _, header := range req.Header.Values("x-amz-object-attributes") {
+		for _, name := range strings.Split(header, ",") {
+			name = strings.TrimSpace(name)
+			if !objectAttributes[name] {
+				r.writeError(w, ErrInvalidArgument)
+				return
+			}
+			requested[name] = true
+		}
+	}
+	if len(requested) == 0 {
+		r.writeError(w, ErrInvalidArgument)
+		return
+	}
+
+	maxParts := parseInt(req.Header.Get("x-amz-max-parts"), maxListParts)
+	marker := parseInt(req.Header.Get("x-amz-part-number-marker"), 0)
+	if maxParts < 0 || marker < 0 {
+		r.writeError(w, ErrInvalidArgument)
+		return
+	}
+
+	attrs, err := r.engine.GetObjectAttributes(ctx, bucket, key, req.URL.Query().Get("versionId"))
+	if err != nil {
+		if r.writeDeleteMarkerError(w, err) {
+			s3RequestsTotal.WithLabelValues("GetObjectAttributes", deleteMarkerStatus(err)).Inc()
+			return
+		}
+		r.logger.Warnw("failed to get object attributes", "bucket", bucket, "key", key, "error", err)
+		r.writeError(w, ErrNoSuchKey)
+		return
+	}
+
+	var resp s3types.GetObjectAttributesOutput
+	if requested["ETag"] {
+		resp.ETag = strings.Trim(attrs.ETag, `"`)
+	}
+	if requested["StorageClass"] {
+		resp.StorageClass = attrs.StorageClass
+		if resp.StorageClass == "" {
+			resp.StorageClass = "STANDARD"
+		}
+	}
+	if requested["ObjectSize"] {
+		resp.ObjectSize = strconv.FormatInt(attrs.Size, 10)
+	}
+	if requested["Checksum"] && attrs.Checksum != "" {
+		checksum := checksumXML(attrs.ChecksumAlgorithm, attrs.Checksum)
+		checksum.ChecksumType = checksumType(attrs.Checksum)
+		resp.Checksum = &checksum
+	}
+	if requested["ObjectParts"] && len(attrs.Parts) > 0 {
+		resp.ObjectParts = objectPartsPage(attrs.Parts, attrs.ChecksumAlgorithm, marker, maxParts)
+	}
+
+	setLastModified(w, attrs.LastModified)
+	setVersionID(w, attrs.VersionID)
+	r.writeXML(w, http.StatusOK, resp)
+	s3RequestsTotal.WithLabelValues("GetObjectAttributes", "200").Inc()
+}
+
+// objectPartsPage returns up to maxParts of the parts of a multipart
+// object numbered above marker
+func objectPartsPage(parts []metadata.PartInfo, algorithm string, marker, maxParts int) *s3types.ObjectParts {
+	page := &s3types.ObjectParts{
+		TotalPartsCount:  len(parts),
+		PartNumberMarker: marker,
+		MaxParts:         maxParts,
+		Parts:            []s3types.Part{},
+	}
+	for _, p := range parts {
+		if p.PartNumber <= marker {
+			continue
+		}
+		if len(page.Parts) == maxParts {
+			page.IsTruncated = true
+			break
+		}
+		page.Parts = append(page.Parts, s3types.Part{
+			PartNumber: p.PartNumber,
+			Size:       p.Size,
+			Checksum:   checksumXML(algorithm, p.Checksum),
+		})
+		page.NextPartNumberMarker = p.PartNumber
+	}
+	return page
+}
+
 // handleHeadBucket handles HeadBucket - checks if bucket exists
 func (r *Router) handleHeadBucket(w http.ResponseWriter, req *http.Request, bucket string) {
 	ctx := req.Context()
@@ -645,29 +942,96 @@ func (r *Router) handleHeadBucket(w http.ResponseWriter, req *http.Request, buck
 func (r *Router) handlePutObject(w http.ResponseWriter, req *http.Request, bucket, key string) {
 	ctx := req.Context()
 
-	// Read content
-	data := req.Body
-	contentLength := req.ContentLength
-	contentType := req.Header.Get("Content-Type")
-
-	result, err := r.engine.PutObject(ctx, bucket, key, data, engine.PutObjectOptions{
-		ContentType: contentType,
-	})
-	_ = contentLength // Reserved for future use
+	// S3 requires a declared length; the body is streamed, never buffered
+	body, contentLength, s3err := r.requestPayload(req)
+	if s3err != nil {
+		r.writeError(w, s3err)
+		return
+	}
+	if contentLength < 0 {
+		r.writeError(w, ErrMissingContentLength)
+		return
+	}
+	if max := r.maxObjectSize(); contentLength > max {
+		r.writeError(w, ErrEntityTooLarge)
+		return
+	}
+	opts, s3err := parseObjectHeaders(req)
+	if s3err != nil {
+		r.writeError(w, s3err)
+		return
+	}
+	opts.Size = contentLength
+	opts.ChecksumAlgorithm, opts.Checksum, s3err = requestChecksum(req)
+	if s3err != nil {
+		r.writeError(w, s3err)
+		return
+	}
+	if opts.Encryption, s3err = r.requestEncryption(req); s3err != nil {
+		r.writeError(w, s3err)
+		return
+	}
 
+	result, err := r.engine.PutObject(ctx, bucket, key, body, opts)
 	if err != nil {
 		r.logger.Warnw("failed to put object", "bucket", bucket, "key", key, "error", err)
-		r.writeError(w, ErrInternal)
+		r.writeError(w, putErrorToS3(err))
 		return
 	}
 
 	// Set response headers
 	w.Header().Set("ETag", sanitizeHeaderValue(result.ETag))
+	setVersionID(w, result.VersionID)
+	setChecksumHeaders(w, result.ChecksumAlgorithm, result.Checksum)
+	setEncryptionHeaders(w, result.Encryption)
 	w.WriteHeader(http.StatusOK)
 
 	s3RequestsTotal.WithLabelValues("PutObject", "200").Inc()
 }
 
+// maxObjectSize returns the configured object size limit
+func (r *Router) maxObjectSize() int64 {
+	if r.config != nil && r.config.Storage.MaxObjectSize > 0 {
+		return r.config.Storage.MaxObjectSize
+	}
+	return engine.MaxUploadSize
+}
+
+// maxPartNumber is the highest part number S3 accepts in a multipart upload
+const maxPartNumber = 10000
+
+// putErrorToS3 maps an engine write error to the S3 error returned to the client
+func putErrorToS3(err error) S3Error {
+	switch {
+	case errors.Is(err, engine.ErrEntityTooLarge):
+		return ErrEntityTooLarge
+	case errors.Is(err, engine.ErrIncompleteBody):
+		return ErrIncompleteBody
+	case errors.Is(err, engine.ErrNoSuchUpload):
+		return ErrNoSuchUpload
+	case errors.Is(err, engine.ErrInvalidPart):
+		return ErrInvalidPart
+	case errors.Is(err, engine.ErrInvalidPartOrder):
+		return ErrInvalidPartOrder
+	case errors.Is(err, engine.ErrEntityTooSmall):
+		return ErrEntityTooSmall
+	case errors.Is(err, engine.ErrBadDigest):
+		return ErrBadDigest
+	case errors.Is(err, engine.ErrInvalidChecksum):
+		return ErrInvalidChecksum
+	case errors.Is(err, engine.ErrObjectLocked):
+		return ErrObjectLocked
+	default:
+		if s3err := payloadErrorToS3(err); s3err != nil {
+			return s3err
+		}
+		if s3err := encryptionErrorToS3(err); s3err != nil {
+			return s3err
+		}
+		return ErrInternal
+	}
+}
+
 // handleCreateBucket handles CreateBucket
 func (r *Router) handleCreateBucket(w http.ResponseWriter, req *http.Request, bucket string) {
 	ctx := req.Context()
@@ -698,49 +1062,116 @@ func (r *Router) handleDeleteBucket(w http.ResponseWriter, req *http.Request, bu
 	s3RequestsTotal.WithLabelValues("DeleteBucket", "200").Inc()
 }
 
+// parseCopySource splits an x-amz-copy-source header of the form
+// /bucket/key[?versionId=id] into its bucket, key and version ID
+func parseCopySource(copySource string) (bucket, key, versionID string, ok bool) {
+	// Remove leading slash if present
+	copySource = strings.TrimPrefix(copySource, "/")
+
+	// A specific source version is selected with ?versionId=
+	if src, rawQuery, found := strings.Cut(copySource, "?"); found {
+		copySource = src
+		if values, err := url.ParseQuery(rawQuery); err == nil {
+			versionID = values.Get("versionId")
+		}
+	}
+	if unescaped, err := url.PathUnescape(copySource); err == nil {
+		copySource = unescaped
+	}
+
+	bucket, key, found := strings.Cut(copySource, "/")
+	if !found || bucket == "" || key == "" {
+		return "", "", "", false
+	}
+	return bucket, key, versionID, true
+}
+
 // handleCopyObject handles CopyObject (PUT with x-amz-copy-source)
 func (r *Router) handleCopyObject(w http.ResponseWriter, req *http.Request, bucket, key string) {
 	ctx := req.Context()
 
-	// Parse the copy source header: /bucket/key
-	copySource := req.Header.Get("x-amz-copy-source")
-	if copySource == "" {
+	srcBucket, srcKey, srcVersionID, ok := parseCopySource(req.Header.Get("x-amz-copy-source"))
+	if !ok {
 		r.writeError(w, ErrInvalidArgument)
 		return
 	}
+	if s3err := r.authorizeCopySource(req, srcBucket, srcKey, srcVersionID); s3err != nil {
+		r.writeError(w, s3err)
+		return
+	}
 
-	// Remove leading slash if present
-	copySource = strings.TrimPrefix(copySource, "/")
-
-	// Parse source bucket and key
-	parts := strings.SplitN(copySource, "/", 2)
-	if len(parts) != 2 {
+	replace, ok := copyMetadataDirective(req)
+	if !ok {
 		r.writeError(w, ErrInvalidArgument)
 		return
 	}
-
-	srcBucket := parts[0]
-	srcKey := parts[1]
+	opts := engine.CopyObjectOptions{
+		SourceVersionID: srcVersionID,
+		Conditions:      requestConditions(req, "x-amz-copy-source-"),
+		ReplaceMetadata: replace,
+	}
+	if name := req.Header.Get("x-amz-checksum-algorithm"); name != "" {
+		if opts.ChecksumAlgorithm = checksumAlgorithm(name); opts.ChecksumAlgorithm == "" {
+			r.writeError(w, ErrInvalidChecksum)
+			return
+		}
+	}
+	if replace {
+		headers, s3err := parseObjectHeaders(req)
+		if s3err != nil {
+			r.writeError(w, s3err)
+			return
+		}
+		opts.Replacement = headers
+	}
+	var s3err S3Error
+	if opts.SourceEncryption, s3err = r.copySourceEncryption(req); s3err != nil {
+		r.writeError(w, s3err)
+		return
+	}
+	if opts.Encryption, s3err = r.requestEncryption(req); s3err != nil {
+		r.writeError(w, s3err)
+		return
+	}
 
 	// Perform the copy
-	result, err := r.engine.CopyObject(ctx, srcBucket, srcKey, bucket, key)
+	result, err := r.engine.CopyObject(ctx, srcBucket, srcKey, bucket, key, opts)
 	if err != nil {
+		if r.writePreconditionError(w, err) {
+			s3RequestsTotal.WithLabelValues("CopyObject", preconditionStatus(err)).Inc()
+			return
+		}
 		r.logger.Warnw("failed to copy object", "srcBucket", srcBucket, "srcKey", srcKey, "dstBucket", bucket, "dstKey", key, "error", err)
-		r.writeError(w, ErrInternal)
+		if s3err := encryptionErrorToS3(err); s3err != nil {
+			r.writeError(w, s3err)
+			return
+		}
+		r.writeError(w, objectLockErrorToS3(err))
 		return
 	}
 
 	// Return S3 CopyObject result
+	setVersionID(w, result.VersionID)
+	if result.SourceVersionID != "" {
+		w.Header().Set("x-amz-copy-source-version-id", sanitizeHeaderValue(result.SourceVersionID))
+	}
+	setEncryptionHeaders(w, result.Encryption)
 	w.Header().Set("Content-Type", "application/xml")
 	w.WriteHeader(http.StatusOK)
 
+	var checksum string
+	if result.Checksum != "" {
+		element := "Checksum" + result.ChecksumAlgorithm
+		checksum = fmt.Sprintf("\n  <%s>%s</%s>", element, result.Checksum, element)
+	}
+
 	response := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
 <CopyObjectResult>
   <LastModified>%s</LastModified>
-  <ETag>%s</ETag>
+  <ETag>%s</ETag>%s
 </CopyObjectResult>`,
 		time.Unix(result.LastModified, 0).Format(time.RFC3339),
-		result.ETag)
+		result.ETag, checksum)
 
 	w.Write([]byte(response))
 	s3RequestsTotal.WithLabelValues("CopyObject", "200").Inc()
@@ -751,7 +1182,7 @@ func (r *Router) handleGetObjectAcl(w http.ResponseWriter, req *http.Request, bu
 	ctx := req.Context()
 
 	// Check if object exists
-	_, err := r.engine.GetObject(ctx, bucket, key, engine.GetObjectOptions{})
+	_, err := r.engine.GetObjectAttributes(ctx, bucket, key, "")
 	if err != nil {
 		r.logger.Warnw("object not found for ACL", "bucket", bucket, "key", key, "error", err)
 		r.writeError(w, ErrNoSuchKey)
@@ -788,7 +1219,7 @@ func (r *Router) handlePutObjectAcl(w http.ResponseWriter, req *http.Request, bu
 	ctx := req.Context()
 
 	// Check if object exists
-	_, err := r.engine.GetObject(ctx, bucket, key, engine.GetObjectOptions{})
+	_, err := r.engine.GetObjectAttributes(ctx, bucket, key, "")
 	if err != nil {
 		r.logger.Warnw("object not found for ACL", "bucket", bucket, "key", key, "error", err)
 		r.writeError(w, ErrNoSuchKey)
@@ -805,13 +1236,22 @@ func (r *Router) handlePutObjectAcl(w http.ResponseWriter, req *http.Request, bu
 func (r *Router) handleDeleteObject(w http.ResponseWriter, req *http.Request, bucket, key string) {
 	ctx := req.Context()
 
-	err := r.engine.DeleteObject(ctx, bucket, key, engine.DeleteObjectOptions{})
+	result, err := r.engine.DeleteObject(ctx, bucket, key, engine.DeleteObjectOptions{
+		VersionID:                 req.URL.Query().Get("versionId"),
+		BypassGovernanceRetention: r.bypassGovernance(req, bucket, key),
+	})
 	if err != nil {
 		r.logger.Warnw("failed to delete object", "bucket", bucket, "key", key, "error", err)
-		r.writeError(w, ErrInternal)
+		s3err := objectLockErrorToS3(err)
+		r.writeError(w, s3err)
+		s3RequestsTotal.WithLabelValues("DeleteObject", strconv.Itoa(s3err.StatusCode())).Inc()
 		return
 	}
 
+	setVersionID(w, result.VersionID)
+	if result.DeleteMarker {
+		w.Header().Set("x-amz-delete-marker", "true")
+	}
 	w.WriteHeader(http.StatusNoContent)
 	s3RequestsTotal.WithLabelValues("DeleteObject", "200").Inc()
 }
@@ -830,15 +1270,37 @@ func parseInt(s string, defaultVal int) int {
 func (r *Router) handleCreateMultipartUpload(w http.ResponseWriter, req *http.Request, bucket, key string) {
 	ctx := req.Context()
 
-	result, err := r.engine.CreateMultipartUpload(ctx, bucket, key, engine.PutObjectOptions{
-		ContentType: req.Header.Get("Content-Type"),
-	})
+	opts, s3err := parseObjectHeaders(req)
+	if s3err != nil {
+		r.writeError(w, s3err)
+		return
+	}
+	if name := req.Header.Get("x-amz-checksum-algorithm"); name != "" {
+		if opts.ChecksumAlgorithm = checksumAlgorithm(name); opts.ChecksumAlgorithm == "" {
+			r.writeError(w, ErrInvalidChecksum)
+			return
+		}
+	}
+	if opts.Encryption, s3err = r.requestEncryption(req); s3err != nil {
+		r.writeError(w, s3err)
+		return
+	}
+
+	result, err := r.engine.CreateMultipartUpload(ctx, bucket, key, opts)
 	if err != nil {
 		r.logger.Warnw("failed to create multipart upload", "bucket", bucket, "key", key, "error", err)
+		if s3err := encryptionErrorToS3(err); s3err != nil {
+			r.writeError(w, s3err)
+			return
+		}
 		r.writeError(w, ErrInternal)
 		return
 	}
 
+	if opts.ChecksumAlgorithm != "" {
+		w.Header().Set("x-amz-checksum-algorithm", opts.ChecksumAlgorithm)
+	}
+	setEncryptionHeaders(w, result.Encryption)
 	w.Header().Set("Content-Type", "application/xml")
 	w.WriteHeader(http.StatusOK)
 
@@ -859,29 +1321,120 @@ func (r *Router) handleUploadPart(w http.ResponseWriter, req *http.Request, buck
 
 	uploadID := req.URL.Query().Get("uploadId")
 	partNumber := parseInt(req.URL.Query().Get("partNumber"), 0)
+	if partNumber < 1 || partNumber > maxPartNumber {
+		r.writeError(w, ErrInvalidArgument)
+		return
+	}
 
-	// Read part data
-	data, err := io.ReadAll(req.Body)
-	if err != nil {
-		r.logger.Warnw("failed to read part data", "bucket", bucket, "key", key, "error", err)
-		r.writeError(w, ErrInternal)
+	body, _, s3err := r.requestPayload(req)
+	if s3err != nil {
+		r.writeError(w, s3err)
+		return
+	}
+	var opts engine.UploadPartOptions
+	opts.ChecksumAlgorithm, opts.Checksum, s3err = requestChecksum(req)
+	if s3err != nil {
+		r.writeError(w, s3err)
+		return
+	}
+	if opts.Encryption, s3err = r.requestEncryption(req); s3err != nil {
+		r.writeError(w, s3err)
 		return
 	}
 
-	// Create a reader from the data
-	result, err := r.engine.UploadPart(ctx, bucket, key, uploadID, partNumber, bytes.NewReader(data))
+	// Stream the part body straight to the engine
+	result, err := r.engine.UploadPart(ctx, bucket, key, uploadID, partNumber, body, opts)
 	if err != nil {
 		r.logger.Warnw("failed to upload part", "bucket", bucket, "key", key, "part", partNumber, "error", err)
-		r.writeError(w, ErrInternal)
+		r.writeError(w, putErrorToS3(err))
 		return
 	}
 
 	w.Header().Set("ETag", sanitizeHeaderValue(result.ETag))
+	if result.Checksum != "" {
+		w.Header().Set(checksumHeader(result.ChecksumAlgorithm), result.Checksum)
+	}
+	setEncryptionHeaders(w, result.Encryption)
 	w.WriteHeader(http.StatusOK)
 
 	s3RequestsTotal.WithLabelValues("UploadPart", "200").Inc()
 }
 
+// handleUploadPartCopy handles UploadPartCopy (PUT ?partNumber&uploadId
+// with x-amz-copy-source)
+func (r *Router) handleUploadPartCopy(w http.ResponseWriter, req *http.Request, bucket, key string) {
+	ctx := req.Context()
+
+	uploadID := req.URL.Query().Get("uploadId")
+	partNumber := parseInt(req.URL.Query().Get("partNumber"), 0)
+	if partNumber < 1 || partNumber > maxPartNumber {
+		r.writeError(w, ErrInvalidArgument)
+		return
+	}
+
+	srcBucket, srcKey, srcVersionID, ok := parseCopySource(req.Header.Get("x-amz-copy-source"))
+	if !ok {
+		r.writeError(w, ErrInvalidArgument)
+		return
+	}
+	if s3err := r.authorizeCopySource(req, srcBucket, srcKey, srcVersionID); s3err != nil {
+		r.writeError(w, s3err)
+		return
+	}
+
+	opts := engine.UploadPartCopyOptions{
+		SourceVersionID: srcVersionID,
+		Conditions:      requestConditions(req, "x-amz-copy-source-"),
+	}
+	if header := req.Header.Get("x-amz-copy-source-range"); header != "" {
+		rng, ok := parseCopySourceRange(header)
+		if !ok {
+			r.writeError(w, ErrInvalidArgument)
+			return
+		}
+		opts.Range = rng
+	}
+	var s3err S3Error
+	if opts.SourceEncryption, s3err = r.copySourceEncryption(req); s3err != nil {
+		r.writeError(w, s3err)
+		return
+	}
+	if opts.Encryption, s3err = r.requestEncryption(req); s3err != nil {
+		r.writeError(w, s3err)
+		return
+	}
+
+	result, err := r.engine.UploadPartCopy(ctx, srcBucket, srcKey, bucket, key, uploadID, partNumber, opts)
+	if err != nil {
+		if r.writePreconditionError(w, err) {
+			s3RequestsTotal.WithLabelValues("UploadPartCopy", preconditionStatus(err)).Inc()
+			return
+		}
+		if r.writeDeleteMarkerError(w, err) {
+			s3RequestsTotal.WithLabelValues("UploadPartCopy", deleteMarkerStatus(err)).Inc()
+			return
+		}
+		r.logger.Warnw("failed to copy part", "srcBucket", srcBucket, "srcKey", srcKey, "bucket", bucket, "key", key, "part", partNumber, "error", err)
+		switch {
+		case errors.Is(err, engine.ErrInvalidRange):
+			r.writeError(w, ErrInvalidArgument)
+		default:
+			r.writeError(w, putErrorToS3(err))
+		}
+		return
+	}
+
+	if result.SourceVersionID != "" {
+		w.Header().Set("x-amz-copy-source-version-id", sanitizeHeaderValue(result.SourceVersionID))
+	}
+	setEncryptionHeaders(w, result.Encryption)
+	r.writeXML(w, http.StatusOK, s3types.CopyPartResult{
+		LastModified: time.Unix(result.LastModified, 0).UTC().Format(time.RFC3339),
+		ETag:         result.ETag,
+	})
+	s3RequestsTotal.WithLabelValues("UploadPartCopy", "200").Inc()
+}
+
 // handleCompleteMultipartUpload handles CompleteMultipartUpload
 func (r *Router) handleCompleteMultipartUpload(w http.ResponseWriter, req *http.Request, bucket, key string) {
 	ctx := req.Context()
@@ -891,15 +1444,16 @@ func (r *Router) handleCompleteMultipartUpload(w http.ResponseWriter, req *http.
 	// Parse the completion XML
 	var completeBody struct {
 		Parts []struct {
-			ETag         string `xml:"ETag"`
-			PartNumber   int    `xml:"PartNumber"`
+			ETag       string `xml:"ETag"`
+			PartNumber int    `xml:"PartNumber"`
+			s3types.Checksum
 		} `xml:"Part"`
 	}
 
 	decoder := xml.NewDecoder(req.Body)
-	if err := decoder.Decode(&completeBody); err != nil {
+	if err := decoder.Decode(&completeBody); err != nil || len(completeBody.Parts) == 0 {
 		r.logger.Warnw("failed to parse complete body", "bucket", bucket, "key", key, "error", err)
-		r.writeError(w, ErrInternal)
+		r.writeError(w, ErrMalformedXML)
 		return
 	}
 
@@ -909,25 +1463,32 @@ func (r *Router) handleCompleteMultipartUpload(w http.ResponseWriter, req *http.
 		parts[i] = engine.PartInfo{
 			ETag:       p.ETag,
 			PartNumber: p.PartNumber,
+			Checksum:   xmlChecksumValue(p.Checksum),
 		}
 	}
 
 	result, err := r.engine.CompleteMultipartUpload(ctx, bucket, key, uploadID, parts)
 	if err != nil {
 		r.logger.Warnw("failed to complete multipart upload", "bucket", bucket, "key", key, "error", err)
-		r.writeError(w, ErrInternal)
+		r.writeError(w, putErrorToS3(err))
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/xml")
 	w.Header().Set("ETag", sanitizeHeaderValue(result.ETag))
+	setVersionID(w, result.VersionID)
+	setEncryptionHeaders(w, result.Encryption)
 	w.WriteHeader(http.StatusOK)
 
 	resp := s3types.CompleteMultipartUploadResult{
-		Bucket:       bucket,
-		Key:          key,
-		ETag:         result.ETag,
-		Location:     "",
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     result.ETag,
+		Location: "",
+		Checksum: checksumXML(result.ChecksumAlgorithm, result.Checksum),
+	}
+	if result.Checksum != "" {
+		resp.ChecksumType = checksumType(result.Checksum)
 	}
 	xmlBytes, _ := xml.Marshal(resp)
 	w.Write(xmlBytes)
@@ -943,6 +1504,10 @@ func (r *Router) handleAbortMultipartUpload(w http.ResponseWriter, req *http.Req
 
 	err := r.engine.AbortMultipartUpload(ctx, bucket, key, uploadID)
 	if err != nil {
+		if errors.Is(err, engine.ErrNoSuchUpload) {
+			r.writeError(w, ErrNoSuchUpload)
+			return
+		}
 		r.logger.Warnw("failed to abort multipart upload", "bucket", bucket, "key", key, "error", err)
 		r.writeError(w, ErrInternal)
 		return
@@ -974,12 +1539,14 @@ func (r *Router) handleListParts(w http.ResponseWriter, req *http.Request, bucke
 		s3parts[i] = s3types.Part{
 			PartNumber: p.PartNumber,
 			ETag:       p.ETag,
+			Size:       p.Size,
+			Checksum:   checksumXML(p.ChecksumAlgorithm, p.Checksum),
 		}
 	}
 
 	resp := s3types.ListPartsOutput{
-		Bucket:    bucket,
-		Key:       key,
+		Bucket:   bucket,
+		Key:      key,
 		UploadID: uploadID,
 		Parts:    s3parts,
 	}
@@ -1014,8 +1581,8 @@ func (r *Router) handleListMultipartUploads(w http.ResponseWriter, req *http.Req
 	}
 
 	resp := s3types.ListMultipartUploadsOutput{
-		Bucket:    bucket,
-		Upload:    uploads,
+		Bucket: bucket,
+		Upload: uploads,
 	}
 	xmlBytes, _ := xml.Marshal(resp)
 	w.Write(xmlBytes)
@@ -1038,8 +1605,8 @@ func (r *Router) handleGetBucketVersioning(w http.ResponseWriter, req *http.Requ
 	w.WriteHeader(http.StatusOK)
 
 	status := ""
-	if versioning != nil && versioning.Status == "Enabled" {
-		status = "Enabled"
+	if versioning != nil && (versioning.Status == engine.VersioningEnabled || versioning.Status == engine.VersioningSuspended) {
+		status = versioning.Status
 	}
 
 	resp := s3types.GetBucketVersioningOutput{
@@ -1070,6 +1637,12 @@ func (r *Router) handlePutBucketVersioning(w http.ResponseWriter, req *http.Requ
 		return
 	}
 
+	// Versioning can only be enabled or suspended, never turned off again
+	if input.Status != engine.VersioningEnabled && input.Status != engine.VersioningSuspended {
+		r.writeError(w, ErrMalformedXML)
+		return
+	}
+
 	// Set versioning
 	versioning := &metadata.BucketVersioning{
 		Status: input.Status,
@@ -1077,7 +1650,7 @@ func (r *Router) handlePutBucketVersioning(w http.ResponseWriter, req *http.Requ
 
 	if err := r.engine.PutBucketVersioning(ctx, bucket, versioning); err != nil {
 		r.logger.Warnw("failed to set bucket versioning", "bucket", bucket, "error", err)
-		r.writeError(w, ErrInternal)
+		r.writeError(w, objectLockErrorToS3(err))
 		return
 	}
 
@@ -1264,11 +1837,11 @@ func (r *Router) handlePutBucketPolicy(w http.ResponseWriter, req *http.Request,
 		return
 	}
 
-	// Validate JSON policy
-	var policyJSON map[string]interface{}
-	if err := json.Unmarshal(body, &policyJSON); err != nil {
-		r.logger.Warnw("failed to parse bucket policy", "error", err)
-		r.writeError(w, ErrInvalidRequest)
+	// Only store a policy that can be enforced, telling the client why not
+	if _, err := iam.ParseBucketPolicy(body, bucket); err != nil {
+		r.logger.Warnw("rejected bucket policy", "bucket", bucket, "error", err)
+		r.writeError(w, &s3Error{code: ErrMalformedPolicy.Code(), message: err.Error(), statusCode: http.StatusBadRequest})
+		s3RequestsTotal.WithLabelValues("PutBucketPolicy", "400").Inc()
 		return
 	}
 
@@ -1288,19 +1861,14 @@ func (r *Router) handlePutBucketPolicy(w http.ResponseWriter, req *http.Request,
 func (r *Router) handleGetBucketEncryption(w http.ResponseWriter, req *http.Request, bucket string) {
 	ctx := req.Context()
 
-	encryption, err := r.engine.GetBucketEncryption(ctx, bucket)
+	config, err := r.engine.GetBucketEncryption(ctx, bucket)
 	if err != nil {
 		r.logger.Warnw("failed to get bucket encryption", "bucket", bucket, "error", err)
 		r.writeError(w, ErrInternal)
 		return
 	}
 
-	// Return encryption configuration or empty
-	if encryption == nil {
-		encryption = &metadata.BucketEncryption{}
-	}
-
-	r.writeXML(w, http.StatusOK, encryption)
+	r.writeXML(w, http.StatusOK, bucketEncryptionXML(config))
 	s3RequestsTotal.WithLabelValues("GetBucketEncryption", "200").Inc()
 }
 
@@ -1317,17 +1885,29 @@ func (r *Router) handlePutBucketEncryption(w http.ResponseWriter, req *http.Requ
 	}
 
 	// Parse encryption configuration
-	var encryption metadata.BucketEncryption
-	if err := xml.Unmarshal(body, &encryption); err != nil {
+	var in s3types.ServerSideEncryptionConfiguration
+	if err := xml.Unmarshal(body, &in); err != nil {
 		r.logger.Warnw("failed to parse encryption configuration", "error", err)
 		r.writeError(w, ErrMalformedXML)
 		return
 	}
+	config, s3err := parseBucketEncryption(&in)
+	if s3err != nil {
+		r.writeError(w, s3err)
+		return
+	}
 
 	// Store encryption configuration
-	if err := r.engine.PutBucketEncryption(ctx, bucket, &encryption); err != nil {
+	if err := r.engine.PutBucketEncryption(ctx, bucket, config); err != nil {
 		r.logger.Warnw("failed to set bucket encryption", "bucket", bucket, "error", err)
-		r.writeError(w, ErrInternal)
+		switch {
+		case errors.Is(err, engine.ErrInvalidEncryption):
+			r.writeError(w, ErrMalformedXML)
+		case encryptionErrorToS3(err) != nil:
+			r.writeError(w, encryptionErrorToS3(err))
+		default:
+			r.writeError(w, ErrInternal)
+		}
 		return
 	}
 
@@ -1361,7 +1941,7 @@ func (r *Router) handleGetBucketTags(w http.ResponseWriter, req *http.Request, b
 	}
 	type TaggingResponse struct {
 		XMLName xml.Name `xml:"Tagging"`
-		TagSet  TagSet  `xml:"TagSet"`
+		TagSet  TagSet   `xml:"TagSet"`
 	}
 
 	response := TaggingResponse{
@@ -1436,12 +2016,7 @@ func (r *Router) handleGetObjectLock(w http.ResponseWriter, req *http.Request, b
 		return
 	}
 
-	// Return configuration or empty
-	if config == nil {
-		config = &metadata.ObjectLockConfig{}
-	}
-
-	r.writeXML(w, http.StatusOK, config)
+	r.writeXML(w, http.StatusOK, objectLockConfigXML(config))
 	s3RequestsTotal.WithLabelValues("GetObjectLock", "200").Inc()
 }
 
@@ -1458,17 +2033,22 @@ func (r *Router) handlePutObjectLock(w http.ResponseWriter, req *http.Request, b
 	}
 
 	// Parse object lock configuration
-	var config metadata.ObjectLockConfig
-	if err := xml.Unmarshal(body, &config); err != nil {
+	var input s3types.ObjectLockConfiguration
+	if err := xml.Unmarshal(body, &input); err != nil {
 		r.logger.Warnw("failed to parse object lock configuration", "error", err)
 		r.writeError(w, ErrMalformedXML)
 		return
 	}
+	config, s3err := parseObjectLockConfig(&input)
+	if s3err != nil {
+		r.writeError(w, s3err)
+		return
+	}
 
 	// Store configuration
-	if err := r.engine.PutObjectLock(ctx, bucket, &config); err != nil {
+	if err := r.engine.PutObjectLock(ctx, bucket, config); err != nil {
 		r.logger.Warnw("failed to set object lock", "bucket", bucket, "error", err)
-		r.writeError(w, ErrInternal)
+		r.writeError(w, objectLockErrorToS3(err))
 		return
 	}
 
@@ -1494,11 +2074,11 @@ func (r *Router) handleGetPublicAccessBlock(w http.ResponseWriter, req *http.Req
 
 	// Wrap in XML response
 	type PublicAccessBlockConfiguration struct {
-		XMLName                      xml.Name `xml:"PublicAccessBlockConfiguration"`
-		BlockPublicAcls       bool   `xml:"BlockPublicAcls"`
-		BlockPublicPolicy     bool   `xml:"BlockPublicPolicy"`
-		IgnorePublicAcls      bool   `xml:"IgnorePublicAcls"`
-		RestrictPublicBuckets bool   `xml:"RestrictPublicBuckets"`
+		XMLName               xml.Name `xml:"PublicAccessBlockConfiguration"`
+		BlockPublicAcls       bool     `xml:"BlockPublicAcls"`
+		BlockPublicPolicy     bool     `xml:"BlockPublicPolicy"`
+		IgnorePublicAcls      bool     `xml:"IgnorePublicAcls"`
+		RestrictPublicBuckets bool     `xml:"RestrictPublicBuckets"`
 	}
 
 	response := PublicAccessBlockConfiguration{
@@ -1636,7 +2216,7 @@ func (r *Router) handleGetBucketInventory(w http.ResponseWriter, req *http.Reque
 
 	// Return as list
 	type InventoryList struct {
-		XMLName     xml.Name                   `xml:"ListInventoryConfigurationsResult"`
+		XMLName        xml.Name                          `xml:"ListInventoryConfigurationsResult"`
 		Configurations []metadata.InventoryConfiguration `xml:"InventoryConfiguration"`
 	}
 
@@ -1744,7 +2324,7 @@ func (r *Router) handleGetBucketAnalytics(w http.ResponseWriter, req *http.Reque
 
 	// Return as list
 	type AnalyticsList struct {
-		XMLName     xml.Name                     `xml:"ListAnalyticsConfigurationsResult"`
+		XMLName        xml.Name                          `xml:"ListAnalyticsConfigurationsResult"`
 		Configurations []metadata.AnalyticsConfiguration `xml:"AnalyticsConfiguration"`
 	}
 
@@ -1960,7 +2540,7 @@ func (r *Router) handleDeleteObjectLock(w http.ResponseWriter, req *http.Request
 
 	if err := r.engine.DeleteObjectLock(ctx, bucket); err != nil {
 		r.logger.Warnw("failed to delete object lock", "bucket", bucket, "error", err)
-		r.writeError(w, ErrInternal)
+		r.writeError(w, objectLockErrorToS3(err))
 		return
 	}
 
@@ -1972,19 +2552,26 @@ func (r *Router) handleDeleteObjectLock(w http.ResponseWriter, req *http.Request
 func (r *Router) handleGetObjectRetention(w http.ResponseWriter, req *http.Request, bucket, key string) {
 	ctx := req.Context()
 
-	retention, err := r.engine.GetObjectRetention(ctx, bucket, key)
+	retention, err := r.engine.GetObjectRetention(ctx, bucket, key, req.URL.Query().Get("versionId"))
 	if err != nil {
+		if r.writeDeleteMarkerError(w, err) {
+			s3RequestsTotal.WithLabelValues("GetObjectRetention", deleteMarkerStatus(err)).Inc()
+			return
+		}
 		r.logger.Warnw("failed to get object retention", "bucket", bucket, "key", key, "error", err)
-		r.writeError(w, ErrInternal)
+		r.writeError(w, objectLockErrorToS3(err))
 		return
 	}
 
-	if retention == nil {
+	if retention == nil || retention.Mode == "" {
 		r.writeError(w, ErrObjectRetentionNotFound)
 		return
 	}
 
-	data, err := xml.Marshal(retention)
+	data, err := xml.Marshal(s3types.ObjectRetention{
+		Mode:            retention.Mode,
+		RetainUntilDate: time.Unix(retention.RetainUntilDate, 0).UTC().Format(time.RFC3339),
+	})
 	if err != nil {
 		r.writeError(w, ErrInternal)
 		return
@@ -2011,16 +2598,26 @@ func (r *Router) handlePutObjectRetention(w http.ResponseWriter, req *http.Reque
 	}
 	defer req.Body.Close()
 
-	var retention metadata.ObjectRetention
-	if err := xml.Unmarshal(body, &retention); err != nil {
+	var input s3types.ObjectRetention
+	if err := xml.Unmarshal(body, &input); err != nil {
 		r.logger.Warnw("failed to parse retention", "error", err)
 		r.writeError(w, ErrMalformedXML)
 		return
 	}
+	retention, s3err := parseObjectRetention(&input)
+	if s3err != nil {
+		r.writeError(w, s3err)
+		return
+	}
 
-	if err := r.engine.PutObjectRetention(ctx, bucket, key, &retention); err != nil {
+	opts := engine.PutObjectRetentionOptions{BypassGovernanceRetention: r.bypassGovernance(req, bucket, key)}
+	if err := r.engine.PutObjectRetention(ctx, bucket, key, req.URL.Query().Get("versionId"), retention, opts); err != nil {
+		if r.writeDeleteMarkerError(w, err) {
+			s3RequestsTotal.WithLabelValues("PutObjectRetention", deleteMarkerStatus(err)).Inc()
+			return
+		}
 		r.logger.Warnw("failed to put object retention", "bucket", bucket, "key", key, "error", err)
-		r.writeError(w, ErrInternal)
+		r.writeError(w, objectLockErrorToS3(err))
 		return
 	}
 
@@ -2032,10 +2629,14 @@ func (r *Router) handlePutObjectRetention(w http.ResponseWriter, req *http.Reque
 func (r *Router) handleGetObjectLegalHold(w http.ResponseWriter, req *http.Request, bucket, key string) {
 	ctx := req.Context()
 
-	legalHold, err := r.engine.GetObjectLegalHold(ctx, bucket, key)
+	legalHold, err := r.engine.GetObjectLegalHold(ctx, bucket, key, req.URL.Query().Get("versionId"))
 	if err != nil {
+		if r.writeDeleteMarkerError(w, err) {
+			s3RequestsTotal.WithLabelValues("GetObjectLegalHold", deleteMarkerStatus(err)).Inc()
+			return
+		}
 		r.logger.Warnw("failed to get object legal hold", "bucket", bucket, "key", key, "error", err)
-		r.writeError(w, ErrInternal)
+		r.writeError(w, objectLockErrorToS3(err))
 		return
 	}
 
@@ -2044,7 +2645,7 @@ func (r *Router) handleGetObjectLegalHold(w http.ResponseWriter, req *http.Reque
 		return
 	}
 
-	data, err := xml.Marshal(legalHold)
+	data, err := xml.Marshal(s3types.ObjectLegalHold{Status: legalHold.Status})
 	if err != nil {
 		r.writeError(w, ErrInternal)
 		return
@@ -2071,16 +2672,25 @@ func (r *Router) handlePutObjectLegalHold(w http.ResponseWriter, req *http.Reque
 	}
 	defer req.Body.Close()
 
-	var legalHold metadata.ObjectLegalHold
-	if err := xml.Unmarshal(body, &legalHold); err != nil {
+	var input s3types.ObjectLegalHold
+	if err := xml.Unmarshal(body, &input); err != nil {
 		r.logger.Warnw("failed to parse legal hold", "error", err)
 		r.writeError(w, ErrMalformedXML)
 		return
 	}
+	if input.Status != engine.LegalHoldOn && input.Status != engine.LegalHoldOff {
+		r.writeError(w, ErrMalformedXML)
+		return
+	}
 
-	if err := r.engine.PutObjectLegalHold(ctx, bucket, key, &legalHold); err != nil {
+	hold := &metadata.ObjectLegalHold{Status: input.Status}
+	if err := r.engine.PutObjectLegalHold(ctx, bucket, key, req.URL.Query().Get("versionId"), hold); err != nil {
+		if r.writeDeleteMarkerError(w, err) {
+			s3RequestsTotal.WithLabelValues("PutObjectLegalHold", deleteMarkerStatus(err)).Inc()
+			return
+		}
 		r.logger.Warnw("failed to put object legal hold", "bucket", bucket, "key", key, "error", err)
-		r.writeError(w, ErrInternal)
+		r.writeError(w, objectLockErrorToS3(err))
 		return
 	}
 
@@ -2352,7 +2962,7 @@ func (r *Router) handleGetBucketMetrics(w http.ResponseWriter, req *http.Request
 
 	// Return as list
 	type MetricsList struct {
-		XMLName     xml.Name                       `xml:"ListMetricsConfigurationsResult"`
+		XMLName        xml.Name                        `xml:"ListMetricsConfigurationsResult"`
 		Configurations []metadata.MetricsConfiguration `xml:"MetricsConfiguration"`
 	}
 
@@ -2569,37 +3179,24 @@ func (r *Router) handleDeleteBucketAcl(w http.ResponseWriter, req *http.Request,
 func (r *Router) handleGetObjectTags(w http.ResponseWriter, req *http.Request, bucket, key string) {
 	ctx := req.Context()
 
-	// Check if object exists
-	obj, err := r.engine.GetObject(ctx, bucket, key, engine.GetObjectOptions{})
+	objectTags, versionID, err := r.engine.GetObjectTagging(ctx, bucket, key, req.URL.Query().Get("versionId"))
 	if err != nil {
-		r.logger.Warnw("object not found for tags", "bucket", bucket, "key", key, "error", err)
-		r.writeError(w, ErrNoSuchKey)
+		if r.writeDeleteMarkerError(w, err) {
+			s3RequestsTotal.WithLabelValues("GetObjectTags", deleteMarkerStatus(err)).Inc()
+			return
+		}
+		r.logger.Warnw("failed to get object tags", "bucket", bucket, "key", key, "error", err)
+		r.writeError(w, taggingErrorToS3(err))
 		return
 	}
 
-	// Return tags if any, otherwise empty tag set
+	tagging := tags.Tagging{TagSet: tags.FromMap(objectTags)}
+	sort.Slice(tagging.TagSet, func(i, j int) bool { return tagging.TagSet[i].Key < tagging.TagSet[j].Key })
+
+	setVersionID(w, versionID)
 	w.Header().Set("Content-Type", "application/xml")
 	w.WriteHeader(http.StatusOK)
-
-	tagsXML := `<?xml version="1.0" encoding="UTF-8"?>
-<Tagging xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
-  <TagSet>`
-
-	if obj.Metadata != nil {
-		for k, v := range obj.Metadata {
-			tagsXML += fmt.Sprintf(`
-    <Tag>
-      <Key>%s</Key>
-      <Value>%s</Value>
-    </Tag>`, tags.EscapeXML(k), tags.EscapeXML(v))
-		}
-	}
-
-	tagsXML += `
-  </TagSet>
-</Tagging>`
-
-	w.Write([]byte(tagsXML))
+	w.Write([]byte(tagging.ToXML()))
 	s3RequestsTotal.WithLabelValues("GetObjectTags", "200").Inc()
 }
 
@@ -2607,17 +3204,31 @@ func (r *Router) handleGetObjectTags(w http.ResponseWriter, req *http.Request, b
 func (r *Router) handlePutObjectTags(w http.ResponseWriter, req *http.Request, bucket, key string) {
 	ctx := req.Context()
 
-	// Check if object exists
-	_, err := r.engine.GetObject(ctx, bucket, key, engine.GetObjectOptions{})
+	body, err := readLimitedBody(req.Body)
 	if err != nil {
-		r.logger.Warnw("object not found for tags", "bucket", bucket, "key", key, "error", err)
-		r.writeError(w, ErrNoSuchKey)
+		r.logger.Warnw("failed to read request body", "error", err)
+		r.writeError(w, ErrInternal)
+		return
+	}
+	tagging, err := tags.FromXML(body)
+	if err != nil {
+		r.logger.Warnw("failed to parse tagging input", "error", err)
+		r.writeError(w, ErrMalformedXML)
 		return
 	}
 
-	// Parse tags from body (simplified - just acknowledge for now)
-	// In production, we'd parse the XML and update object metadata
+	versionID, err := r.engine.PutObjectTagging(ctx, bucket, key, req.URL.Query().Get("versionId"), tagging.TagSet)
+	if err != nil {
+		if r.writeDeleteMarkerError(w, err) {
+			s3RequestsTotal.WithLabelValues("PutObjectTags", deleteMarkerStatus(err)).Inc()
+			return
+		}
+		r.logger.Warnw("failed to put object tags", "bucket", bucket, "key", key, "error", err)
+		r.writeError(w, taggingErrorToS3(err))
+		return
+	}
 
+	setVersionID(w, versionID)
 	w.WriteHeader(http.StatusNoContent)
 	s3RequestsTotal.WithLabelValues("PutObjectTags", "204").Inc()
 }
@@ -2626,18 +3237,35 @@ func (r *Router) handlePutObjectTags(w http.ResponseWriter, req *http.Request, b
 func (r *Router) handleDeleteObjectTags(w http.ResponseWriter, req *http.Request, bucket, key string) {
 	ctx := req.Context()
 
-	// Check if object exists
-	_, err := r.engine.GetObject(ctx, bucket, key, engine.GetObjectOptions{})
+	versionID, err := r.engine.DeleteObjectTagging(ctx, bucket, key, req.URL.Query().Get("versionId"))
 	if err != nil {
-		r.logger.Warnw("object not found for tags", "bucket", bucket, "key", key, "error", err)
-		r.writeError(w, ErrNoSuchKey)
+		if r.writeDeleteMarkerError(w, err) {
+			s3RequestsTotal.WithLabelValues("DeleteObjectTags", deleteMarkerStatus(err)).Inc()
+			return
+		}
+		r.logger.Warnw("failed to delete object tags", "bucket", bucket, "key", key, "error", err)
+		r.writeError(w, taggingErrorToS3(err))
 		return
 	}
 
+	setVersionID(w, versionID)
 	w.WriteHeader(http.StatusNoContent)
 	s3RequestsTotal.WithLabelValues("DeleteObjectTags", "204").Inc()
 }
 
+// taggingErrorToS3 maps an engine object tagging error to the S3 error
+// returned to the client
+func taggingErrorToS3(err error) S3Error {
+	switch {
+	case errors.Is(err, engine.ErrInvalidTag):
+		return ErrInvalidTag
+	case errors.Is(err, engine.ErrNoSuchKey):
+		return ErrNoSuchKey
+	default:
+		return ErrInternal
+	}
+}
+
 // handleGetBucketNotification handles GET /bucket?notification
 func (r *Router) handleGetBucketNotification(w http.ResponseWriter, req *http.Request, bucket string) {
 	ctx := req.Context()
@@ -2791,8 +3419,8 @@ func (r *Router) handlePutPresignedURL(w http.ResponseWriter, req *http.Request,
 	}
 
 	var input struct {
-		Method string `json:"method"`
-		Expires int64 `json:"expires"`
+		Method  string `json:"method"`
+		Expires int64  `json:"expires"`
 	}
 
 	if err := json.Unmarshal(body, &input); err != nil {
@@ -2845,22 +3473,46 @@ func (r *Router) handleDeleteObjects(w http.ResponseWriter, req *http.Request, b
 	var deleted []s3types.DeletedObject
 	var errors []s3types.DeleteError
 
+	// Each key is decided by policy as a DeleteObject of its own would be
+	accessKey, _ := r.authenticate(req, bucket, "s3:DeleteObject")
+	bypassRequested := strings.EqualFold(req.Header.Get("x-amz-bypass-governance-retention"), "true")
 	for _, obj := range input.Objects {
-		err := r.engine.DeleteObject(ctx, bucket, obj.Key, engine.DeleteObjectOptions{
-			VersionID: obj.VersionID,
-		})
-		if err != nil {
+		action := "s3:DeleteObject"
+		if obj.VersionID != "" {
+			action = "s3:DeleteObjectVersion"
+		}
+		if !r.policyAllows(req, accessKey, bucket, obj.Key, action) {
 			errors = append(errors, s3types.DeleteError{
 				Key:       obj.Key,
 				VersionID: obj.VersionID,
-				Code:      "AccessDenied",
-				Message:   err.Error(),
+				Code:      ErrAccessDenied.Code(),
+				Message:   ErrAccessDenied.Message(),
 			})
-		} else {
-			deleted = append(deleted, s3types.DeletedObject{
+			continue
+		}
+
+		result, err := r.engine.DeleteObject(ctx, bucket, obj.Key, engine.DeleteObjectOptions{
+			VersionID:                 obj.VersionID,
+			BypassGovernanceRetention: bypassRequested && r.policyAllows(req, accessKey, bucket, obj.Key, bypassGovernanceAction),
+		})
+		if err != nil {
+			s3err := objectLockErrorToS3(err)
+			errors = append(errors, s3types.DeleteError{
 				Key:       obj.Key,
 				VersionID: obj.VersionID,
+				Code:      s3err.Code(),
+				Message:   s3err.Message(),
 			})
+		} else {
+			entry := s3types.DeletedObject{
+				Key:          obj.Key,
+				VersionID:    obj.VersionID,
+				DeleteMarker: result.DeleteMarker,
+			}
+			if result.DeleteMarker {
+				entry.DeleteMarkerVersionID = result.VersionID
+			}
+			deleted = append(deleted, entry)
 		}
 	}
 
@@ -2877,73 +3529,12 @@ func (r *Router) handleDeleteObjects(w http.ResponseWriter, req *http.Request, b
 	s3RequestsTotal.WithLabelValues("DeleteObjects", "200").Inc()
 }
 
-// handleSelectObjectContent handles S3 Select (POST /bucket/key?select)
-func (r *Router) handleSelectObjectContent(w http.ResponseWriter, req *http.Request, bucket, key string) {
-	ctx := req.Context()
-
-	// Get the object first
-	obj, err := r.engine.GetObject(ctx, bucket, key, engine.GetObjectOptions{})
-	if err != nil {
-		r.logger.Warnw("failed to get object for select", "bucket", bucket, "key", key, "error", err)
-		r.writeError(w, ErrNoSuchKey)
-		return
-	}
-	defer obj.Body.Close()
-
-	// Read the body
-	data, err := io.ReadAll(obj.Body)
-	if err != nil {
-		r.logger.Warnw("failed to read object data", "error", err)
-		r.writeError(w, ErrInternal)
-		return
-	}
-
-	// Parse the S3 Select request body
-	var selectInput s3types.SelectObjectContentRequest
-	if err := xml.Unmarshal(data, &selectInput); err != nil {
-		r.logger.Warnw("failed to parse select input", "error", err)
-		r.writeError(w, ErrMalformedXML)
-		return
-	}
-
-	// Determine input format (CSV or JSON)
-	inputFormat := s3select.FormatJSON
-	if selectInput.InputSerialization.CSV != nil {
-		inputFormat = s3select.FormatCSV
-	}
-
-	// Create select request
-	selectReq := &s3select.SelectRequest{
-		Bucket:     bucket,
-		Key:        key,
-		Expression: selectInput.Expression,
-		InputSerialization: s3select.InputSerialization{
-			Format: inputFormat,
-		},
-	}
-
-	// Execute select - pass the original object data, not the request body
-	result, err := r.selectService.Execute(ctx, selectReq, bytes.NewReader(data))
-	if err != nil {
-		r.logger.Warnw("failed to execute select", "error", err)
-		r.writeError(w, ErrInternal)
-		return
-	}
-
-	// Write response
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(result.Payload)
-
-	s3RequestsTotal.WithLabelValues("SelectObjectContent", "200").Inc()
-}
-
 // handleRestoreObject handles POST /bucket/key?restore (Glacier restore)
 func (r *Router) handleRestoreObject(w http.ResponseWriter, req *http.Request, bucket, key string) {
 	ctx := req.Context()
 
 	// Get the object
-	obj, err := r.engine.GetObject(ctx, bucket, key, engine.GetObjectOptions{})
+	obj, err := r.engine.GetObjectAttributes(ctx, bucket, key, "")
 	if err != nil {
 		r.logger.Warnw("object not found for restore", "bucket", bucket, "key", key, "error", err)
 		r.writeError(w, ErrNoSuchKey)
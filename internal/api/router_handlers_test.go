@@ -6,17 +6,23 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/openendpoint/openendpoint/internal/auth"
 	"github.com/openendpoint/openendpoint/internal/config"
 	"github.com/openendpoint/openendpoint/internal/engine"
 	"github.com/openendpoint/openendpoint/internal/metadata"
+	"github.com/openendpoint/openendpoint/internal/metadata/pebble"
 	"github.com/openendpoint/openendpoint/internal/storage"
+	"github.com/openendpoint/openendpoint/internal/storage/flatfile"
 	"github.com/openendpoint/openendpoint/pkg/s3types"
 	"go.uber.org/zap"
 )
@@ -24,12 +30,14 @@ import (
 type MockAPIStorage struct {
 	objects map[string][]byte
 	buckets map[string]bool
+	parts   map[string][]byte
 }
 
 func NewMockAPIStorage() *MockAPIStorage {
 	return &MockAPIStorage{
 		objects: make(map[string][]byte),
 		buckets: make(map[string]bool),
+		parts:   make(map[string][]byte),
 	}
 }
 
@@ -48,6 +56,9 @@ func (m *MockAPIStorage) Get(ctx context.Context, bucket, key string, opts stora
 	if !ok {
 		return nil, os.ErrNotExist
 	}
+	if opts.Range != nil {
+		data = data[opts.Range.Start:min(opts.Range.End, int64(len(data)))]
+	}
 	return io.NopCloser(bytes.NewReader(data)), nil
 }
 
@@ -96,6 +107,32 @@ func (m *MockAPIStorage) ListBuckets(ctx context.Context) ([]storage.BucketInfo,
 	return buckets, nil
 }
 
+func (m *MockAPIStorage) PutPart(ctx context.Context, uploadID string, partNumber int, data io.Reader, size int64) error {
+	b, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	m.parts[fmt.Sprintf("%s/%d", uploadID, partNumber)] = b
+	return nil
+}
+
+func (m *MockAPIStorage) GetPart(ctx context.Context, uploadID string, partNumber int) (io.ReadCloser, error) {
+	data, ok := m.parts[fmt.Sprintf("%s/%d", uploadID, partNumber)]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *MockAPIStorage) DeleteUpload(ctx context.Context, uploadID string) error {
+	for k := range m.parts {
+		if strings.HasPrefix(k, uploadID+"/") {
+			delete(m.parts, k)
+		}
+	}
+	return nil
+}
+
 func (m *MockAPIStorage) ComputeStorageMetrics() (int64, int64, error) {
 	var totalSize int64
 	for _, v := range m.objects {
@@ -122,6 +159,7 @@ type MockAPIMetadata struct {
 	legalHold         map[string]*metadata.ObjectLegalHold
 	ownershipControls map[string]*metadata.OwnershipControls
 	metrics           map[string]map[string]*metadata.MetricsConfiguration
+	locks             map[string]*metadata.ObjectLockConfig
 	shouldError       bool
 }
 
@@ -142,6 +180,7 @@ func NewMockAPIMetadata() *MockAPIMetadata {
 		legalHold:         make(map[string]*metadata.ObjectLegalHold),
 		ownershipControls: make(map[string]*metadata.OwnershipControls),
 		metrics:           make(map[string]map[string]*metadata.MetricsConfiguration),
+		locks:             make(map[string]*metadata.ObjectLockConfig),
 	}
 }
 
@@ -180,16 +219,31 @@ func (m *MockAPIMetadata) DeleteObject(ctx context.Context, bucket, key string,
 	delete(m.objects, bucket+"/"+key)
 	return nil
 }
-func (m *MockAPIMetadata) ListObjects(ctx context.Context, bucket, prefix string, opts metadata.ListOptions) ([]metadata.ObjectMetadata, error) {
-	var objects []metadata.ObjectMetadata
-	for k, v := range m.objects {
-		if len(k) > len(bucket)+1 && k[:len(bucket)+1] == bucket+"/" {
-			objects = append(objects, *v)
+func (m *MockAPIMetadata) ListObjects(ctx context.Context, bucket string, opts metadata.ListOptions) (*metadata.ObjectListing, error) {
+	var keys []string
+	for k := range m.objects {
+		if strings.HasPrefix(k, bucket+"/") {
+			keys = append(keys, k[len(bucket)+1:])
+		}
+	}
+	sort.Strings(keys)
+	pager := metadata.NewObjectPager(opts)
+	for _, key := range keys {
+		if !pager.Add(key, m.objects[bucket+"/"+key]) {
+			break
 		}
 	}
-	return objects, nil
+	return pager.Listing(), nil
+}
+func (m *MockAPIMetadata) ListObjectVersions(ctx context.Context, bucket string, opts metadata.ListOptions) (*metadata.VersionListing, error) {
+	return &metadata.VersionListing{}, nil
+}
+func (m *MockAPIMetadata) UpdateObjectVersion(ctx context.Context, bucket, key string, meta *metadata.ObjectMetadata) error {
+	m.objects[bucket+"/"+key] = meta
+	return nil
 }
 func (m *MockAPIMetadata) CreateMultipartUpload(ctx context.Context, bucket, key, uploadID string, meta *metadata.ObjectMetadata) error {
+	m.uploads[bucket] = append(m.uploads[bucket], metadata.MultipartUploadMetadata{UploadID: uploadID, Key: key, Bucket: bucket})
 	return nil
 }
 func (m *MockAPIMetadata) PutPart(ctx context.Context, bucket, key, uploadID string, partNumber int, meta *metadata.PartMetadata) error {
@@ -205,7 +259,7 @@ func (m *MockAPIMetadata) ListParts(ctx context.Context, bucket, key, uploadID s
 	return nil, nil
 }
 func (m *MockAPIMetadata) ListMultipartUploads(ctx context.Context, bucket, prefix string) ([]metadata.MultipartUploadMetadata, error) {
-	return nil, nil
+	return m.uploads[bucket], nil
 }
 func (m *MockAPIMetadata) PutLifecycleRule(ctx context.Context, bucket string, rule *metadata.LifecycleRule) error {
 	return nil
@@ -282,12 +336,14 @@ func (m *MockAPIMetadata) DeleteBucketTags(ctx context.Context, bucket string) e
 	return nil
 }
 func (m *MockAPIMetadata) PutObjectLock(ctx context.Context, bucket string, config *metadata.ObjectLockConfig) error {
+	m.locks[bucket] = config
 	return nil
 }
 func (m *MockAPIMetadata) GetObjectLock(ctx context.Context, bucket string) (*metadata.ObjectLockConfig, error) {
-	return nil, nil
+	return m.locks[bucket], nil
 }
 func (m *MockAPIMetadata) DeleteObjectLock(ctx context.Context, bucket string) error {
+	delete(m.locks, bucket)
 	return nil
 }
 func (m *MockAPIMetadata) PutObjectRetention(ctx context.Context, bucket, key string, retention *metadata.ObjectRetention) error {
@@ -450,6 +506,17 @@ func createTestAPIRouter(t *testing.T) (*Router, func()) {
 	return router, func() {}
 }
 
+// createObjectLockBucket creates a versioned bucket with object lock enabled
+func createObjectLockBucket(t *testing.T, router *Router, bucket string) {
+	t.Helper()
+	ctx := context.Background()
+	_ = router.engine.CreateBucket(ctx, bucket)
+	_ = router.engine.PutBucketVersioning(ctx, bucket, &metadata.BucketVersioning{Status: engine.VersioningEnabled})
+	if err := router.engine.PutObjectLock(ctx, bucket, &metadata.ObjectLockConfig{Enabled: true}); err != nil {
+		t.Fatalf("PutObjectLock() error: %v", err)
+	}
+}
+
 func TestAPIRouter_NewRouter(t *testing.T) {
 	router, cleanup := createTestAPIRouter(t)
 	defer cleanup()
@@ -555,6 +622,42 @@ func TestAPIRouter_HandlePutObject(t *testing.T) {
 	}
 }
 
+func TestAPIRouter_HandlePutObject_ContentLength(t *testing.T) {
+	router, cleanup := createTestAPIRouter(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	router.engine.CreateBucket(ctx, "test-bucket")
+	router.config.Storage.MaxObjectSize = 8
+
+	tests := []struct {
+		name          string
+		contentLength int64
+		wantStatus    int
+		wantCode      string
+	}{
+		{"Missing", -1, http.StatusLengthRequired, "MissingContentLength"},
+		{"TooLarge", 9, http.StatusBadRequest, "EntityTooLarge"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PUT", "/s3/test-bucket/test-key.txt", strings.NewReader("123456789"))
+			req.ContentLength = tt.contentLength
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantCode) {
+				t.Errorf("Body = %s, want error code %s", w.Body.String(), tt.wantCode)
+			}
+		})
+	}
+}
+
 func TestAPIRouter_HandleGetObject(t *testing.T) {
 	router, cleanup := createTestAPIRouter(t)
 	defer cleanup()
@@ -578,6 +681,102 @@ func TestAPIRouter_HandleGetObject(t *testing.T) {
 	}
 }
 
+func TestAPIRouter_HandleGetObject_Range(t *testing.T) {
+	router, cleanup := createTestAPIRouter(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	router.engine.CreateBucket(ctx, "test-bucket")
+	router.engine.PutObject(ctx, "test-bucket", "digits", bytes.NewBufferString("0123456789"), engine.PutObjectOptions{})
+
+	tests := []struct {
+		name             string
+		rangeHeader      string
+		wantStatus       int
+		wantBody         string
+		wantContentRange string
+	}{
+		{"NoRange", "", http.StatusOK, "0123456789", ""},
+		{"Closed", "bytes=2-5", http.StatusPartialContent, "2345", "bytes 2-5/10"},
+		{"OpenEnded", "bytes=7-", http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{"Suffix", "bytes=-3", http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{"EndPastSize", "bytes=8-100", http.StatusPartialContent, "89", "bytes 8-9/10"},
+		{"Malformed", "bytes=5-2", http.StatusOK, "0123456789", ""},
+		{"WrongUnit", "items=0-1", http.StatusOK, "0123456789", ""},
+		{"Unsatisfiable", "bytes=20-30", http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "bytes */10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/s3/test-bucket/digits", nil)
+			if tt.rangeHeader != "" {
+				req.Header.Set("Range", tt.rangeHeader)
+			}
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("Body = %q, want it to contain %q", w.Body.String(), tt.wantBody)
+			}
+			if got := w.Header().Get("Content-Range"); got != tt.wantContentRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.wantContentRange)
+			}
+			if w.Code != http.StatusRequestedRangeNotSatisfiable && w.Header().Get("Accept-Ranges") != "bytes" {
+				t.Errorf("Accept-Ranges = %q, want bytes", w.Header().Get("Accept-Ranges"))
+			}
+		})
+	}
+}
+
+func TestAPIRouter_HandleGetObject_MultiRange(t *testing.T) {
+	router, cleanup := createTestAPIRouter(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	router.engine.CreateBucket(ctx, "test-bucket")
+	router.engine.PutObject(ctx, "test-bucket", "digits", bytes.NewBufferString("0123456789"), engine.PutObjectOptions{})
+
+	req := httptest.NewRequest("GET", "/s3/test-bucket/digits", nil)
+	req.Header.Set("Range", "bytes=0-1,-2")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("Status = %d, want %d", w.Code, http.StatusPartialContent)
+	}
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("Content-Type = %q, want multipart/byteranges", w.Header().Get("Content-Type"))
+	}
+
+	mr := multipart.NewReader(w.Body, params["boundary"])
+	want := []struct{ contentRange, body string }{
+		{"bytes 0-1/10", "01"},
+		{"bytes 8-9/10", "89"},
+	}
+	for i, wp := range want {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		if got := part.Header.Get("Content-Range"); got != wp.contentRange {
+			t.Errorf("part %d Content-Range = %q, want %q", i, got, wp.contentRange)
+		}
+		data, _ := io.ReadAll(part)
+		if string(data) != wp.body {
+			t.Errorf("part %d body = %q, want %q", i, data, wp.body)
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("expected exactly %d parts, got err %v", len(want), err)
+	}
+}
+
 func TestAPIRouter_HandleGetObject_NotFound(t *testing.T) {
 	router, cleanup := createTestAPIRouter(t)
 	defer cleanup()
@@ -1034,16 +1233,29 @@ func TestAPIRouter_HandleGetObjectTags(t *testing.T) {
 
 	ctx := context.Background()
 	router.engine.CreateBucket(ctx, "test-bucket")
-	router.engine.PutObject(ctx, "test-bucket", "test.txt", bytes.NewBufferString("test"), engine.PutObjectOptions{})
+	opts := engine.PutObjectOptions{Metadata: map[string]string{"team": "web"}}
+	router.engine.PutObject(ctx, "test-bucket", "test.txt", bytes.NewBufferString("test"), opts)
 
 	req := httptest.NewRequest("GET", "/s3/test-bucket/test.txt?tagging=true", nil)
 	w := httptest.NewRecorder()
 
 	router.ServeHTTP(w, req)
 
-	// Handler may return various success codes - some features may not be supported
-	if w.Code != http.StatusOK && w.Code != http.StatusNoContent {
-		t.Errorf("Status = %d, want %d", w.Code, http.StatusOK)
+	// User metadata is not part of the tag set
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "<Tag>") {
+		t.Errorf("Status = %d, body %s, want %d and an empty tag set", w.Code, w.Body.String(), http.StatusOK)
+	}
+
+	body := bytes.NewBufferString(`<Tagging><TagSet><Tag><Key>env</Key><Value>prod</Value></Tag></TagSet></Tagging>`)
+	req = httptest.NewRequest("PUT", "/s3/test-bucket/test.txt?tagging=true", body)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest("GET", "/s3/test-bucket/test.txt?tagging=true", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if got := w.Body.String(); !strings.Contains(got, "<Tag><Key>env</Key><Value>prod</Value></Tag>") || strings.Contains(got, "team") {
+		t.Errorf("GetObjectTagging body = %s, want only the stored tag", got)
 	}
 }
 
@@ -1090,17 +1302,21 @@ func TestAPIRouter_HandleCompleteMultipartUpload(t *testing.T) {
 
 	ctx := context.Background()
 	router.engine.CreateBucket(ctx, "test-bucket")
+	upload, err := router.engine.CreateMultipartUpload(ctx, "test-bucket", "multipart.txt", engine.PutObjectOptions{})
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload() error: %v", err)
+	}
 
 	completeXML := `<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>etag1</ETag></Part></CompleteMultipartUpload>`
 	body := bytes.NewBufferString(completeXML)
-	req := httptest.NewRequest("POST", "/s3/test-bucket/multipart.txt?uploadId=test-upload", body)
+	req := httptest.NewRequest("POST", "/s3/test-bucket/multipart.txt?uploadId="+upload.UploadID, body)
 	w := httptest.NewRecorder()
 
 	router.ServeHTTP(w, req)
 
-	// Handler may return various success codes - some features may not be supported
-	if w.Code != http.StatusOK && w.Code != http.StatusNoContent {
-		t.Errorf("Status = %d, want %d", w.Code, http.StatusOK)
+	// Part 1 was never uploaded
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "InvalidPart") {
+		t.Errorf("Status = %d, body %s, want InvalidPart", w.Code, w.Body.String())
 	}
 }
 
@@ -1116,6 +1332,19 @@ func TestAPIRouter_HandleAbortMultipartUpload(t *testing.T) {
 
 	router.ServeHTTP(w, req)
 
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "<Code>NoSuchUpload</Code>") {
+		t.Errorf("Status = %d, body %s, want 404 NoSuchUpload", w.Code, w.Body.String())
+	}
+
+	upload, err := router.engine.CreateMultipartUpload(ctx, "test-bucket", "multipart.txt", engine.PutObjectOptions{})
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload() error = %v", err)
+	}
+	req = httptest.NewRequest("DELETE", "/s3/test-bucket/multipart.txt?uploadId="+upload.UploadID, nil)
+	w = httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
 	if w.Code != http.StatusNoContent {
 		t.Errorf("Status = %d, want %d", w.Code, http.StatusNoContent)
 	}
@@ -1259,7 +1488,7 @@ func TestAPIRouter_HandlePutBucketPolicy(t *testing.T) {
 	ctx := context.Background()
 	router.engine.CreateBucket(ctx, "test-bucket")
 
-	body := bytes.NewBufferString(`{"Version":"2012-10-17"}`)
+	body := bytes.NewBufferString(`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:GetObject","Resource":"arn:aws:s3:::test-bucket/*"}]}`)
 	req := httptest.NewRequest("PUT", "/s3/test-bucket?policy=true", body)
 	w := httptest.NewRecorder()
 
@@ -1274,6 +1503,7 @@ func TestAPIRouter_HandlePutBucketPolicy(t *testing.T) {
 func TestAPIRouter_HandlePutBucketEncryption(t *testing.T) {
 	router, cleanup := createTestAPIRouter(t)
 	defer cleanup()
+	router.engine.SetMasterKey(testMasterKey)
 
 	ctx := context.Background()
 	router.engine.CreateBucket(ctx, "test-bucket")
@@ -1335,6 +1565,7 @@ func TestAPIRouter_HandlePutObjectLock(t *testing.T) {
 
 	ctx := context.Background()
 	router.engine.CreateBucket(ctx, "test-bucket")
+	router.engine.PutBucketVersioning(ctx, "test-bucket", &metadata.BucketVersioning{Status: engine.VersioningEnabled})
 
 	body := bytes.NewBufferString(`<ObjectLockConfiguration><ObjectLockEnabled>Enabled</ObjectLockEnabled></ObjectLockConfiguration>`)
 	req := httptest.NewRequest("PUT", "/s3/test-bucket?object-lock=true", body)
@@ -1659,7 +1890,7 @@ func TestAPIRouter_HandlePutObjectLegalHold(t *testing.T) {
 	defer cleanup()
 
 	ctx := context.Background()
-	router.engine.CreateBucket(ctx, "test-bucket")
+	createObjectLockBucket(t, router, "test-bucket")
 	router.engine.PutObject(ctx, "test-bucket", "test.txt", bytes.NewBufferString("test"), engine.PutObjectOptions{})
 
 	body := bytes.NewBufferString(`<LegalHold><Status>ON</Status></LegalHold>`)
@@ -2773,6 +3004,103 @@ func TestAPIRouter_HandleGetObjectIfMatch(t *testing.T) {
 	}
 }
 
+func TestAPIRouter_HandleGetObject_Conditional(t *testing.T) {
+	router, cleanup := createTestAPIRouter(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	router.engine.CreateBucket(ctx, "test-bucket")
+	put, err := router.engine.PutObject(ctx, "test-bucket", "cond.txt", bytes.NewBufferString("test content"), engine.PutObjectOptions{})
+	if err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		name       string
+		method     string
+		header     string
+		value      string
+		wantStatus int
+	}{
+		{"IfMatchHit", "GET", "If-Match", put.ETag, http.StatusOK},
+		{"IfMatchMiss", "GET", "If-Match", `"d41d8cd98f00b204e9800998ecf8427e"`, http.StatusPreconditionFailed},
+		{"IfNoneMatchHit", "GET", "If-None-Match", put.ETag, http.StatusNotModified},
+		{"IfModifiedSincePast", "GET", "If-Modified-Since", past, http.StatusOK},
+		{"IfModifiedSinceFuture", "GET", "If-Modified-Since", future, http.StatusNotModified},
+		{"IfUnmodifiedSincePast", "GET", "If-Unmodified-Since", past, http.StatusPreconditionFailed},
+		{"HeadIfNoneMatchHit", "HEAD", "If-None-Match", put.ETag, http.StatusNotModified},
+		{"HeadIfMatchMiss", "HEAD", "If-Match", `"other"`, http.StatusPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/s3/test-bucket/cond.txt", nil)
+			req.Header.Set(tt.header, tt.value)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			switch tt.wantStatus {
+			case http.StatusNotModified:
+				if w.Header().Get("ETag") != put.ETag || w.Body.Len() != 0 {
+					t.Errorf("304 should carry the ETag and no body, got ETag %q body %q", w.Header().Get("ETag"), w.Body.String())
+				}
+			case http.StatusPreconditionFailed:
+				if tt.method == "GET" && !strings.Contains(w.Body.String(), "PreconditionFailed") {
+					t.Errorf("Body = %s, want PreconditionFailed error", w.Body.String())
+				}
+			}
+			if tt.wantStatus != http.StatusPreconditionFailed && w.Header().Get("Last-Modified") == "" {
+				t.Error("Last-Modified header should be set")
+			}
+		})
+	}
+}
+
+func TestAPIRouter_HandleCopyObject_Conditional(t *testing.T) {
+	router, cleanup := createTestAPIRouter(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	router.engine.CreateBucket(ctx, "test-bucket")
+	put, err := router.engine.PutObject(ctx, "test-bucket", "source.txt", bytes.NewBufferString("source content"), engine.PutObjectOptions{})
+	if err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		header     string
+		value      string
+		wantStatus int
+	}{
+		{"IfMatchHit", "X-Amz-Copy-Source-If-Match", put.ETag, http.StatusOK},
+		{"IfMatchMiss", "X-Amz-Copy-Source-If-Match", `"other"`, http.StatusPreconditionFailed},
+		{"IfNoneMatchHit", "X-Amz-Copy-Source-If-None-Match", put.ETag, http.StatusPreconditionFailed},
+		{"IfModifiedSinceFuture", "X-Amz-Copy-Source-If-Modified-Since", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), http.StatusPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PUT", "/s3/test-bucket/dest.txt", nil)
+			req.Header.Set("X-Amz-Copy-Source", "/test-bucket/source.txt")
+			req.Header.Set(tt.header, tt.value)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
+
 func TestAPIRouter_HandleCopyObjectSameBucket(t *testing.T) {
 	router, cleanup := createTestAPIRouter(t)
 	defer cleanup()
@@ -2935,6 +3263,7 @@ func TestAPIRouter_HandleGetObjectTorrent(t *testing.T) {
 func TestAPIRouter_HandlePutObjectWithSSE(t *testing.T) {
 	router, cleanup := createTestAPIRouter(t)
 	defer cleanup()
+	router.engine.SetMasterKey(testMasterKey)
 
 	ctx := context.Background()
 	router.engine.CreateBucket(ctx, "test-bucket")
@@ -3471,7 +3800,7 @@ func TestAPIRouter_HandlePutObjectLegalHold_WithBody(t *testing.T) {
 	defer cleanup()
 
 	ctx := context.Background()
-	router.engine.CreateBucket(ctx, "test-bucket")
+	createObjectLockBucket(t, router, "test-bucket")
 	router.engine.PutObject(ctx, "test-bucket", "test.txt", bytes.NewBufferString("content"), engine.PutObjectOptions{})
 
 	body := bytes.NewBufferString(`<LegalHold xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Status>ON</Status></LegalHold>`)
@@ -3490,14 +3819,14 @@ func TestAPIRouter_HandleGetObjectRetention_WithRetention(t *testing.T) {
 	defer cleanup()
 
 	ctx := context.Background()
-	router.engine.CreateBucket(ctx, "test-bucket")
+	createObjectLockBucket(t, router, "test-bucket")
 	router.engine.PutObject(ctx, "test-bucket", "test.txt", bytes.NewBufferString("content"), engine.PutObjectOptions{})
 
 	retention := &metadata.ObjectRetention{
 		Mode:            "COMPLIANCE",
 		RetainUntilDate: 1735689600,
 	}
-	if err := router.engine.PutObjectRetention(ctx, "test-bucket", "test.txt", retention); err != nil {
+	if err := router.engine.PutObjectRetention(ctx, "test-bucket", "test.txt", "", retention, engine.PutObjectRetentionOptions{}); err != nil {
 		t.Fatalf("Failed to set retention: %v", err)
 	}
 
@@ -3515,13 +3844,13 @@ func TestAPIRouter_HandleGetObjectLegalHold_WithHold(t *testing.T) {
 	defer cleanup()
 
 	ctx := context.Background()
-	router.engine.CreateBucket(ctx, "test-bucket")
+	createObjectLockBucket(t, router, "test-bucket")
 	router.engine.PutObject(ctx, "test-bucket", "test.txt", bytes.NewBufferString("content"), engine.PutObjectOptions{})
 
 	legalHold := &metadata.ObjectLegalHold{
 		Status: "ON",
 	}
-	if err := router.engine.PutObjectLegalHold(ctx, "test-bucket", "test.txt", legalHold); err != nil {
+	if err := router.engine.PutObjectLegalHold(ctx, "test-bucket", "test.txt", "", legalHold); err != nil {
 		t.Fatalf("Failed to set legal hold: %v", err)
 	}
 
@@ -6286,3 +6615,378 @@ func TestRouter_HandleGetBucketReplication(t *testing.T) {
 		t.Logf("GetBucketReplication returned status %d", w.Code)
 	}
 }
+
+// createVersionedTestAPIRouter builds a router over a real flat file backend
+// and pebble store, since the mocks ignore version IDs
+func createVersionedTestAPIRouter(t *testing.T) *Router {
+	t.Helper()
+	return createStoreTestAPIRouter(t, &config.Config{})
+}
+
+// createStoreTestAPIRouter creates a router over real stores configured by cfg
+func createStoreTestAPIRouter(t *testing.T, cfg *config.Config) *Router {
+	t.Helper()
+	logger := zap.NewNop().Sugar()
+
+	backend, err := flatfile.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := pebble.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+		backend.Close()
+	})
+
+	svc := engine.New(backend, store, logger)
+	return NewRouter(svc, auth.New(cfg.Auth), logger, cfg)
+}
+
+func TestAPIRouter_Versioning(t *testing.T) {
+	router := createVersionedTestAPIRouter(t)
+
+	do := func(method, target, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	do("PUT", "/s3/test-bucket", "", nil)
+	if w := do("PUT", "/s3/test-bucket?versioning", `<VersioningConfiguration><Status>Off</Status></VersioningConfiguration>`, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("PutBucketVersioning(Off) status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := do("PUT", "/s3/test-bucket?versioning", `<VersioningConfiguration><Status>Enabled</Status></VersioningConfiguration>`, nil); w.Code != http.StatusOK {
+		t.Fatalf("PutBucketVersioning() status = %d", w.Code)
+	}
+
+	w := do("PUT", "/s3/test-bucket/key.txt", "one", nil)
+	v1 := w.Header().Get("x-amz-version-id")
+	if w.Code != http.StatusOK || v1 == "" {
+		t.Fatalf("PutObject() status = %d, version %q", w.Code, v1)
+	}
+	do("PUT", "/s3/test-bucket/key.txt", "two", nil)
+
+	w = do("DELETE", "/s3/test-bucket/key.txt", "", nil)
+	marker := w.Header().Get("x-amz-version-id")
+	if w.Code != http.StatusNoContent || w.Header().Get("x-amz-delete-marker") != "true" || marker == "" {
+		t.Fatalf("DeleteObject() status = %d, headers %v", w.Code, w.Header())
+	}
+
+	w = do("GET", "/s3/test-bucket/key.txt", "", nil)
+	if w.Code != http.StatusNotFound || w.Header().Get("x-amz-delete-marker") != "true" {
+		t.Errorf("GET after delete status = %d, delete marker %q", w.Code, w.Header().Get("x-amz-delete-marker"))
+	}
+	w = do("HEAD", "/s3/test-bucket/key.txt?versionId="+marker, "", nil)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("x-amz-version-id") != marker {
+		t.Errorf("HEAD of delete marker status = %d, version %q", w.Code, w.Header().Get("x-amz-version-id"))
+	}
+
+	w = do("GET", "/s3/test-bucket/key.txt?versionId="+v1, "", nil)
+	if w.Code != http.StatusOK || w.Body.String() != "one" || w.Header().Get("x-amz-version-id") != v1 {
+		t.Errorf("GET v1 status = %d, body %q, version %q", w.Code, w.Body.String(), w.Header().Get("x-amz-version-id"))
+	}
+
+	w = do("PUT", "/s3/test-bucket/copy.txt", "", map[string]string{"x-amz-copy-source": "/test-bucket/key.txt?versionId=" + v1})
+	if w.Code != http.StatusOK || w.Header().Get("x-amz-copy-source-version-id") != v1 || w.Header().Get("x-amz-version-id") == "" {
+		t.Errorf("CopyObject() status = %d, headers %v", w.Code, w.Header())
+	}
+
+	w = do("GET", "/s3/test-bucket?versioning", "", nil)
+	if !strings.Contains(w.Body.String(), "<Status>Enabled</Status>") {
+		t.Errorf("GetBucketVersioning() body = %s", w.Body.String())
+	}
+}
+
+func TestAPIRouter_ListObjectVersions_Pagination(t *testing.T) {
+	router := createVersionedTestAPIRouter(t)
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	do("PUT", "/s3/test-bucket", "")
+	do("PUT", "/s3/test-bucket?versioning", `<VersioningConfiguration><Status>Enabled</Status></VersioningConfiguration>`)
+	do("PUT", "/s3/test-bucket/a.txt", "one")
+	do("PUT", "/s3/test-bucket/a.txt", "two")
+	do("DELETE", "/s3/test-bucket/a.txt", "")
+	do("PUT", "/s3/test-bucket/dir/b.txt", "three")
+
+	type entry struct {
+		XMLName   xml.Name
+		Key       string `xml:"Key"`
+		VersionID string `xml:"VersionId"`
+		IsLatest  bool   `xml:"IsLatest"`
+	}
+	type result struct {
+		IsTruncated         bool    `xml:"IsTruncated"`
+		NextKeyMarker       string  `xml:"NextKeyMarker"`
+		NextVersionIDMarker string  `xml:"NextVersionIdMarker"`
+		Entries             []entry `xml:",any"`
+	}
+
+	var got []string
+	target := "/s3/test-bucket?versions&max-keys=2"
+	for page := 0; page < 5; page++ {
+		w := do("GET", target, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("ListObjectVersions() status = %d, body %s", w.Code, w.Body.String())
+		}
+		var res result
+		if err := xml.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		for _, e := range res.Entries {
+			if e.XMLName.Local == "Version" || e.XMLName.Local == "DeleteMarker" {
+				got = append(got, fmt.Sprintf("%s:%s:%v", e.XMLName.Local, e.Key, e.IsLatest))
+			}
+		}
+		if !res.IsTruncated {
+			break
+		}
+		target = "/s3/test-bucket?versions&max-keys=2&key-marker=" + res.NextKeyMarker + "&version-id-marker=" + res.NextVersionIDMarker
+	}
+
+	want := []string{"DeleteMarker:a.txt:true", "Version:a.txt:false", "Version:a.txt:false", "Version:dir/b.txt:true"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("entries = %v, want %v", got, want)
+	}
+
+	w := do("GET", "/s3/test-bucket?versions&delimiter=/", "")
+	if !strings.Contains(w.Body.String(), "<CommonPrefixes><Prefix>dir/</Prefix></CommonPrefixes>") {
+		t.Errorf("delimiter listing = %s", w.Body.String())
+	}
+
+	if w := do("GET", "/s3/test-bucket?versions&version-id-marker=x", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("version-id-marker without key-marker status = %d, want 400", w.Code)
+	}
+}
+
+func TestAPIRouter_CompleteMultipartUpload_Parts(t *testing.T) {
+	router := createVersionedTestAPIRouter(t)
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	do("PUT", "/s3/test-bucket", "")
+	w := do("POST", "/s3/test-bucket/mp.txt?uploads", "")
+	var initiate s3types.InitiateMultipartUploadResult
+	if err := xml.Unmarshal(w.Body.Bytes(), &initiate); err != nil || initiate.UploadID == "" {
+		t.Fatalf("InitiateMultipartUpload() status = %d, body %s", w.Code, w.Body.String())
+	}
+	base := "/s3/test-bucket/mp.txt?uploadId=" + initiate.UploadID
+
+	if w := do("PUT", base+"&partNumber=0", "x"); w.Code != http.StatusBadRequest {
+		t.Errorf("UploadPart(0) status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := do("PUT", "/s3/test-bucket/mp.txt?uploadId=missing&partNumber=1", "x"); w.Code != http.StatusNotFound {
+		t.Errorf("UploadPart(unknown upload) status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	w = do("PUT", base+"&partNumber=1", "hello world")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("UploadPart() status = %d, etag %q", w.Code, etag)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		code string
+	}{
+		{"empty", `<CompleteMultipartUpload></CompleteMultipartUpload>`, "MalformedXML"},
+		{"wrong etag", `<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>"abc"</ETag></Part></CompleteMultipartUpload>`, "InvalidPart"},
+		{"order", `<CompleteMultipartUpload><Part><PartNumber>2</PartNumber><ETag>x</ETag></Part><Part><PartNumber>1</PartNumber><ETag>x</ETag></Part></CompleteMultipartUpload>`, "InvalidPartOrder"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do("POST", base, tt.body)
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "<Code>"+tt.code+"</Code>") {
+				t.Errorf("status = %d, body %s, want %s", w.Code, w.Body.String(), tt.code)
+			}
+		})
+	}
+
+	w = do("POST", base, `<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>`+etag+`</ETag></Part></CompleteMultipartUpload>`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "-1") {
+		t.Fatalf("CompleteMultipartUpload() status = %d, body %s", w.Code, w.Body.String())
+	}
+	if w := do("GET", "/s3/test-bucket/mp.txt", ""); w.Body.String() != "hello world" {
+		t.Errorf("GetObject() = %q, want %q", w.Body.String(), "hello world")
+	}
+}
+
+func TestAPIRouter_UploadPartCopy(t *testing.T) {
+	router := createVersionedTestAPIRouter(t)
+
+	do := func(method, target, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Every part but the last must reach the 5 MiB minimum
+	const minPart = 5 * 1024 * 1024
+	source := strings.Repeat("a", minPart) + "hello"
+	do("PUT", "/s3/test-bucket", "", nil)
+	do("PUT", "/s3/test-bucket/src.txt", source, nil)
+
+	w := do("POST", "/s3/test-bucket/dst.txt?uploads", "", nil)
+	var initiate s3types.InitiateMultipartUploadResult
+	if err := xml.Unmarshal(w.Body.Bytes(), &initiate); err != nil || initiate.UploadID == "" {
+		t.Fatalf("InitiateMultipartUpload() status = %d, body %s", w.Code, w.Body.String())
+	}
+	base := "/s3/test-bucket/dst.txt?uploadId=" + initiate.UploadID
+
+	copyPart := func(n int, rng string) *httptest.ResponseRecorder {
+		header := map[string]string{"x-amz-copy-source": "/test-bucket/src.txt"}
+		if rng != "" {
+			header["x-amz-copy-source-range"] = rng
+		}
+		return do("PUT", fmt.Sprintf("%s&partNumber=%d", base, n), "", header)
+	}
+
+	if w := copyPart(1, fmt.Sprintf("bytes=0-%d", len(source))); w.Code != http.StatusBadRequest {
+		t.Errorf("UploadPartCopy(out of range) status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := copyPart(1, "bytes=5-"); w.Code != http.StatusBadRequest {
+		t.Errorf("UploadPartCopy(open range) status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	var etags []string
+	ranges := []string{fmt.Sprintf("bytes=0-%d", minPart-1), fmt.Sprintf("bytes=%d-%d", minPart, len(source)-1)}
+	for i, rng := range ranges {
+		w := copyPart(i+1, rng)
+		var result s3types.CopyPartResult
+		if err := xml.Unmarshal(w.Body.Bytes(), &result); w.Code != http.StatusOK || err != nil || result.ETag == "" {
+			t.Fatalf("UploadPartCopy(%s) status = %d, body %s", rng, w.Code, w.Body.String())
+		}
+		etags = append(etags, result.ETag)
+	}
+
+	complete := "<CompleteMultipartUpload>"
+	for i, etag := range etags {
+		complete += fmt.Sprintf("<Part><PartNumber>%d</PartNumber><ETag>%s</ETag></Part>", i+1, etag)
+	}
+	complete += "</CompleteMultipartUpload>"
+	if w := do("POST", base, complete, nil); w.Code != http.StatusOK {
+		t.Fatalf("CompleteMultipartUpload() status = %d, body %s", w.Code, w.Body.String())
+	}
+	if w := do("GET", "/s3/test-bucket/dst.txt", "", nil); w.Body.String() != source {
+		t.Errorf("GetObject() returned %d bytes, want the %d byte source", w.Body.Len(), len(source))
+	}
+}
+
+func TestAPIRouter_ObjectHeaders(t *testing.T) {
+	router := createVersionedTestAPIRouter(t)
+
+	do := func(method, target, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	checkHeaders := func(t *testing.T, name string, w *httptest.ResponseRecorder, want map[string]string) {
+		t.Helper()
+		for k, v := range want {
+			if got := w.Header().Get(k); got != v {
+				t.Errorf("%s header %s = %q, want %q", name, k, got, v)
+			}
+		}
+	}
+
+	stored := map[string]string{
+		"Content-Type":        "text/plain",
+		"Content-Encoding":    "gzip",
+		"Cache-Control":       "max-age=60",
+		"Content-Disposition": `attachment; filename="a.txt"`,
+		"Content-Language":    "en",
+		"Expires":             "Wed, 21 Oct 2026 07:28:00 GMT",
+		"x-amz-meta-color":    "blue",
+	}
+	do("PUT", "/s3/test-bucket", "", nil)
+	put := map[string]string{"X-Amz-Meta-Color": "blue"}
+	for k, v := range stored {
+		if k != "x-amz-meta-color" {
+			put[k] = v
+		}
+	}
+	if w := do("PUT", "/s3/test-bucket/a.txt", "hello", put); w.Code != http.StatusOK {
+		t.Fatalf("PutObject() status = %d, body %s", w.Code, w.Body.String())
+	}
+
+	checkHeaders(t, "GetObject", do("GET", "/s3/test-bucket/a.txt", "", nil), stored)
+	checkHeaders(t, "HeadObject", do("HEAD", "/s3/test-bucket/a.txt", "", nil), stored)
+
+	w := do("GET", "/s3/test-bucket/a.txt?response-content-type=application/json&response-content-disposition=inline", "", nil)
+	checkHeaders(t, "GetObject(overrides)", w, map[string]string{
+		"Content-Type":        "application/json",
+		"Content-Disposition": "inline",
+		"Cache-Control":       "max-age=60",
+	})
+
+	// COPY keeps the source headers, REPLACE takes the request's
+	do("PUT", "/s3/test-bucket/copy.txt", "", map[string]string{"x-amz-copy-source": "/test-bucket/a.txt", "Content-Type": "image/png"})
+	checkHeaders(t, "HeadObject(copy)", do("HEAD", "/s3/test-bucket/copy.txt", "", nil), stored)
+
+	do("PUT", "/s3/test-bucket/replaced.txt", "", map[string]string{
+		"x-amz-copy-source":        "/test-bucket/a.txt",
+		"x-amz-metadata-directive": "REPLACE",
+		"Content-Type":             "image/png",
+		"x-amz-meta-shape":         "round",
+	})
+	w = do("HEAD", "/s3/test-bucket/replaced.txt", "", nil)
+	checkHeaders(t, "HeadObject(replaced)", w, map[string]string{
+		"Content-Type":     "image/png",
+		"Cache-Control":    "",
+		"x-amz-meta-color": "",
+		"x-amz-meta-shape": "round",
+	})
+
+	w = do("PUT", "/s3/test-bucket/bad.txt", "", map[string]string{"x-amz-copy-source": "/test-bucket/a.txt", "x-amz-metadata-directive": "MERGE"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("CopyObject(MERGE) status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	// Headers given at initiation apply to the completed upload
+	w = do("POST", "/s3/test-bucket/mp.txt?uploads", "", map[string]string{"Content-Type": "text/csv", "x-amz-meta-rows": "3"})
+	var initiate s3types.InitiateMultipartUploadResult
+	if err := xml.Unmarshal(w.Body.Bytes(), &initiate); err != nil || initiate.UploadID == "" {
+		t.Fatalf("InitiateMultipartUpload() status = %d, body %s", w.Code, w.Body.String())
+	}
+	base := "/s3/test-bucket/mp.txt?uploadId=" + initiate.UploadID
+	etag := do("PUT", base+"&partNumber=1", "a,b,c", nil).Header().Get("ETag")
+	complete := fmt.Sprintf(`<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>%s</ETag></Part></CompleteMultipartUpload>`, etag)
+	if w := do("POST", base, complete, nil); w.Code != http.StatusOK {
+		t.Fatalf("CompleteMultipartUpload() status = %d, body %s", w.Code, w.Body.String())
+	}
+	checkHeaders(t, "HeadObject(multipart)", do("HEAD", "/s3/test-bucket/mp.txt", "", nil), map[string]string{
+		"Content-Type":    "text/csv",
+		"x-amz-meta-rows": "3",
+	})
+
+	w = do("PUT", "/s3/test-bucket/big.txt", "x", map[string]string{"x-amz-meta-big": strings.Repeat("v", maxUserMetadataSize)})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "MetadataTooLarge") {
+		t.Errorf("PutObject(large metadata) status = %d, body %s, want MetadataTooLarge", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/openendpoint/openendpoint/internal/s3select"
+	s3types "github.com/openendpoint/openendpoint/pkg/s3types"
+)
+
+// handleSelectObjectContent handles S3 Select (POST /bucket/key?select).
+// Output records are streamed as event stream messages while the object
+// is scanned; once a message is sent the status can no longer change, so
+// later failures end the stream with an error event.
+func (r *Router) handleSelectObjectContent(w http.ResponseWriter, req *http.Request, bucket, key string) {
+	ctx := req.Context()
+
+	body, err := readLimitedBody(req.Body)
+	if err != nil {
+		r.logger.Warnw("failed to read request body", "error", err)
+		r.writeError(w, ErrInternal)
+		return
+	}
+	var input s3types.SelectObjectContentRequest
+	if err := xml.Unmarshal(body, &input); err != nil {
+		r.logger.Warnw("failed to parse select input", "error", err)
+		r.writeError(w, ErrMalformedXML)
+		return
+	}
+
+	if sr := input.ScanRange; sr != nil && (sr.Start != nil && *sr.Start < 0 || sr.End != nil && *sr.End < 0) {
+		r.writeError(w, ErrInvalidArgument)
+		s3RequestsTotal.WithLabelValues("SelectObjectContent", "400").Inc()
+		return
+	}
+
+	sse, s3err := r.requestEncryption(req)
+	if s3err != nil {
+		r.writeError(w, s3err)
+		s3RequestsTotal.WithLabelValues("SelectObjectContent", strconv.Itoa(s3err.StatusCode())).Inc()
+		return
+	}
+
+	w.Header().Set("Content-Type", s3select.EventStreamContentType)
+	out := s3select.NewEventStreamWriter(w)
+	stats, err := r.engine.SelectObjectContent(ctx, bucket, key, sse, selectRequest(bucket, key, &input), out)
+	if err != nil {
+		r.logger.Warnw("failed to execute select", "bucket", bucket, "key", key, "error", err)
+		var selErr *s3select.Error
+		isSelErr := errors.As(err, &selErr)
+		switch {
+		case out.Started():
+			if !isSelErr {
+				selErr = &s3select.Error{Code: ErrInternal.Code(), Message: ErrInternal.Message()}
+			}
+			out.Error(selErr.Code, selErr.Message)
+			s3RequestsTotal.WithLabelValues("SelectObjectContent", "200").Inc()
+		case r.writeDeleteMarkerError(w, err):
+			s3RequestsTotal.WithLabelValues("SelectObjectContent", deleteMarkerStatus(err)).Inc()
+		case isSelErr:
+			r.writeError(w, &s3Error{code: selErr.Code, message: selErr.Message, statusCode: http.StatusBadRequest})
+			s3RequestsTotal.WithLabelValues("SelectObjectContent", "400").Inc()
+		case encryptionErrorToS3(err) != nil:
+			r.writeError(w, encryptionErrorToS3(err))
+		default:
+			r.writeError(w, ErrNoSuchKey)
+		}
+		return
+	}
+
+	out.Stats(*stats)
+	out.End()
+	s3RequestsTotal.WithLabelValues("SelectObjectContent", "200").Inc()
+}
+
+// selectRequest converts a SelectObjectContent request document
+func selectRequest(bucket, key string, input *s3types.SelectObjectContentRequest) *s3select.SelectRequest {
+	req := &s3select.SelectRequest{
+		Bucket:          bucket,
+		Key:             key,
+		Expression:      input.Expression,
+		ExpressionType:  s3select.ExpressionType(input.ExpressionType),
+		RequestProgress: input.RequestProgress.Enabled,
+	}
+
+	if sr := input.ScanRange; sr != nil {
+		req.ScanRange = &s3select.ScanRange{Start: -1, End: -1}
+		if sr.Start != nil {
+			req.ScanRange.Start = *sr.Start
+		}
+		if sr.End != nil {
+			req.ScanRange.End = *sr.End
+		}
+	}
+
+	req.InputSerialization.CompressionType = input.InputSerialization.CompressionType
+	if in := input.InputSerialization.CSV; in != nil {
+		req.InputSerialization.Format = s3select.FormatCSV
+		req.InputSerialization.CSV = &s3select.CSVInput{
+			FileHeaderInfo:             in.FileHeaderInfo,
+			RecordDelimiter:            in.RecordDelimiter,
+			FieldDelimiter:             in.FieldDelimiter,
+			QuoteCharacter:             in.QuoteCharacter,
+			QuoteEscapeCharacter:       in.QuoteEscapeCharacter,
+			CommentCharacter:           in.Comments,
+			AllowQuotedRecordDelimiter: in.AllowQuotedRecordDelimiter,
+		}
+	} else if in := input.InputSerialization.JSON; in != nil {
+		req.InputSerialization.Format = s3select.FormatJSON
+		req.InputSerialization.JSON = &s3select.JSONInput{Type: in.Type}
+	}
+
+	if out := input.OutputSerialization.JSON; out != nil {
+		req.OutputSerialization = s3select.OutputSerialization{
+			Format: s3select.OutputJSON,
+			JSON:   &s3select.JSONOutput{RecordDelimiter: out.RecordDelimiter},
+		}
+	} else {
+		req.OutputSerialization.Format = s3select.OutputCSV
+		if out := input.OutputSerialization.CSV; out != nil {
+			req.OutputSerialization.CSV = &s3select.CSVOutput{
+				RecordDelimiter:      out.RecordDelimiter,
+				FieldDelimiter:       out.FieldDelimiter,
+				QuoteCharacter:       out.QuoteCharacter,
+				QuoteEscapeCharacter: out.QuoteEscapeCharacter,
+				QuoteFields:          out.QuoteFields,
+			}
+		}
+	}
+	return req
+}
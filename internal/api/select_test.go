@@ -0,0 +1,201 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openendpoint/openendpoint/internal/engine"
+	"github.com/openendpoint/openendpoint/internal/s3select"
+)
+
+// selectEvents decodes the event stream of a SelectObjectContent response
+func selectEvents(t *testing.T, body []byte) []*s3select.Message {
+	t.Helper()
+	var msgs []*s3select.Message
+	r := bytes.NewReader(body)
+	for {
+		msg, err := s3select.ReadMessage(r)
+		if err == io.EOF {
+			return msgs
+		}
+		if err != nil {
+			t.Fatalf("ReadMessage() error = %v", err)
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+// eventTypes lists the event type of each message, or the error code of
+// an error message
+func eventTypes(msgs []*s3select.Message) []string {
+	var types []string
+	for _, m := range msgs {
+		if m.Headers[":message-type"] == "error" {
+			types = append(types, "error:"+m.Headers[":error-code"])
+		} else {
+			types = append(types, m.Headers[":event-type"])
+		}
+	}
+	return types
+}
+
+func TestAPIRouter_SelectObjectContent(t *testing.T) {
+	router, cleanup := createTestAPIRouter(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	router.engine.CreateBucket(ctx, "test-bucket")
+	router.engine.PutObject(ctx, "test-bucket", "people.csv", strings.NewReader("name,age\nAlice,30\nBob,25\nCarol,35\n"), engine.PutObjectOptions{})
+	router.engine.PutObject(ctx, "test-bucket", "people.json", strings.NewReader(`{"name":"Alice","address":{"city":"Paris"}}`+"\n"+`{"name":"Bob"}`), engine.PutObjectOptions{})
+
+	selectObject := func(key, request string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/s3/test-bucket/"+key+"?select&select-type=2", strings.NewReader(
+			`<SelectObjectContentRequest>`+request+`</SelectObjectContentRequest>`))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("CSV", func(t *testing.T) {
+		w := selectObject("people.csv", `<Expression>SELECT s.name FROM S3Object s WHERE s.age &gt; 26</Expression>
+			<ExpressionType>SQL</ExpressionType>
+			<InputSerialization><CSV><FileHeaderInfo>USE</FileHeaderInfo></CSV></InputSerialization>
+			<OutputSerialization><CSV/></OutputSerialization>
+			<RequestProgress><Enabled>true</Enabled></RequestProgress>`)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/vnd.amazon.eventstream" {
+			t.Fatalf("SelectObjectContent = %d %s, body %q", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+		}
+		msgs := selectEvents(t, w.Body.Bytes())
+		if got := strings.Join(eventTypes(msgs), ","); got != "Records,Progress,Stats,End" {
+			t.Fatalf("events = %s, want Records,Progress,Stats,End", got)
+		}
+		if string(msgs[0].Payload) != "Alice\nCarol\n" {
+			t.Errorf("Records = %q, want Alice and Carol", msgs[0].Payload)
+		}
+		wantStats := "<Stats><BytesScanned>34</BytesScanned><BytesProcessed>34</BytesProcessed><BytesReturned>12</BytesReturned></Stats>"
+		if string(msgs[2].Payload) != wantStats {
+			t.Errorf("Stats = %s, want %s", msgs[2].Payload, wantStats)
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		w := selectObject("people.json", `<Expression>SELECT s.name, s.address.city FROM S3Object s</Expression>
+			<ExpressionType>SQL</ExpressionType>
+			<InputSerialization><JSON><Type>LINES</Type></JSON></InputSerialization>
+			<OutputSerialization><JSON/></OutputSerialization>`)
+		msgs := selectEvents(t, w.Body.Bytes())
+		if got := strings.Join(eventTypes(msgs), ","); got != "Records,Stats,End" {
+			t.Fatalf("events = %s, want Records,Stats,End", got)
+		}
+		if want := `{"name":"Alice","city":"Paris"}` + "\n" + `{"name":"Bob"}` + "\n"; string(msgs[0].Payload) != want {
+			t.Errorf("Records = %q, want %q", msgs[0].Payload, want)
+		}
+	})
+
+	t.Run("NoMatches", func(t *testing.T) {
+		w := selectObject("people.csv", `<Expression>SELECT * FROM S3Object WHERE age = 99</Expression>
+			<ExpressionType>SQL</ExpressionType>
+			<InputSerialization><CSV><FileHeaderInfo>USE</FileHeaderInfo></CSV></InputSerialization>
+			<OutputSerialization><CSV/></OutputSerialization>`)
+		if got := strings.Join(eventTypes(selectEvents(t, w.Body.Bytes())), ","); got != "Stats,End" {
+			t.Errorf("events = %s, want Stats,End", got)
+		}
+	})
+
+	t.Run("ScanRange", func(t *testing.T) {
+		// Bob's record starts at byte 18; the header comes from the start
+		w := selectObject("people.csv", `<Expression>SELECT s.name FROM S3Object s</Expression>
+			<ExpressionType>SQL</ExpressionType>
+			<InputSerialization><CSV><FileHeaderInfo>USE</FileHeaderInfo></CSV></InputSerialization>
+			<OutputSerialization><CSV/></OutputSerialization>
+			<ScanRange><Start>10</Start><End>20</End></ScanRange>`)
+		msgs := selectEvents(t, w.Body.Bytes())
+		if len(msgs) == 0 || string(msgs[0].Payload) != "Bob\n" {
+			t.Errorf("events = %v, want a Bob record", eventTypes(msgs))
+		}
+	})
+
+	t.Run("Gzip", func(t *testing.T) {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte("a;b|1;2|"))
+		zw.Close()
+		router.engine.PutObject(ctx, "test-bucket", "data.csv.gz", &buf, engine.PutObjectOptions{})
+
+		w := selectObject("data.csv.gz", `<Expression>SELECT b FROM S3Object</Expression>
+			<ExpressionType>SQL</ExpressionType>
+			<InputSerialization><CompressionType>GZIP</CompressionType>
+				<CSV><FileHeaderInfo>USE</FileHeaderInfo><FieldDelimiter>;</FieldDelimiter><RecordDelimiter>|</RecordDelimiter></CSV>
+			</InputSerialization>
+			<OutputSerialization><CSV/></OutputSerialization>`)
+		msgs := selectEvents(t, w.Body.Bytes())
+		if len(msgs) == 0 || string(msgs[0].Payload) != "2\n" {
+			t.Errorf("events = %v, want a record of 2", eventTypes(msgs))
+		}
+	})
+
+	rejected := []struct {
+		name     string
+		key      string
+		request  string
+		wantCode int
+		wantErr  string
+	}{
+		{"ParseError", "people.csv", `<Expression>SELECT FROM S3Object</Expression><ExpressionType>SQL</ExpressionType>
+			<InputSerialization><CSV/></InputSerialization><OutputSerialization><CSV/></OutputSerialization>`, http.StatusBadRequest, "ParseUnexpectedToken"},
+		{"ExpressionType", "people.csv", `<Expression>SELECT * FROM S3Object</Expression><ExpressionType>XPATH</ExpressionType>
+			<InputSerialization><CSV/></InputSerialization><OutputSerialization><CSV/></OutputSerialization>`, http.StatusBadRequest, "InvalidExpressionType"},
+		{"NoInputFormat", "people.csv", `<Expression>SELECT * FROM S3Object</Expression><ExpressionType>SQL</ExpressionType>
+			<InputSerialization/><OutputSerialization><CSV/></OutputSerialization>`, http.StatusBadRequest, "InvalidRequestParameter"},
+		{"NoSuchKey", "missing.csv", `<Expression>SELECT * FROM S3Object</Expression><ExpressionType>SQL</ExpressionType>
+			<InputSerialization><CSV/></InputSerialization><OutputSerialization><CSV/></OutputSerialization>`, http.StatusNotFound, "NoSuchKey"},
+		{"MalformedXML", "people.csv", `<Expression>`, http.StatusBadRequest, "MalformedXML"},
+		{"NegativeScanRange", "people.csv", `<Expression>SELECT * FROM S3Object</Expression><ExpressionType>SQL</ExpressionType>
+			<InputSerialization><CSV/></InputSerialization><OutputSerialization><CSV/></OutputSerialization>
+			<ScanRange><Start>-5</Start></ScanRange>`, http.StatusBadRequest, "InvalidArgument"},
+		{"ScanRangeCompressed", "people.csv", `<Expression>SELECT * FROM S3Object</Expression><ExpressionType>SQL</ExpressionType>
+			<InputSerialization><CompressionType>GZIP</CompressionType><CSV/></InputSerialization><OutputSerialization><CSV/></OutputSerialization>
+			<ScanRange><End>5</End></ScanRange>`, http.StatusBadRequest, "UnsupportedScanRangeInput"},
+	}
+	for _, tt := range rejected {
+		t.Run(tt.name, func(t *testing.T) {
+			w := selectObject(tt.key, tt.request)
+			if w.Code != tt.wantCode || !strings.Contains(w.Body.String(), "<Code>"+tt.wantErr+"</Code>") {
+				t.Errorf("SelectObjectContent = %d %s, want %d %s", w.Code, w.Body.String(), tt.wantCode, tt.wantErr)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/xml" {
+				t.Errorf("Content-Type = %s, want application/xml", ct)
+			}
+		})
+	}
+
+	t.Run("ErrorAfterRecords", func(t *testing.T) {
+		// Enough output to send a Records event before the bad row is reached
+		var data strings.Builder
+		data.WriteString("n,pad\n")
+		for i := 0; i < 2000; i++ {
+			fmt.Fprintf(&data, "%d,%s\n", i, strings.Repeat("x", 60))
+		}
+		data.WriteString("oops,x\n")
+		router.engine.PutObject(ctx, "test-bucket", "bad.csv", strings.NewReader(data.String()), engine.PutObjectOptions{})
+
+		w := selectObject("bad.csv", `<Expression>SELECT CAST(n AS INT), pad FROM S3Object</Expression>
+			<ExpressionType>SQL</ExpressionType>
+			<InputSerialization><CSV><FileHeaderInfo>USE</FileHeaderInfo></CSV></InputSerialization>
+			<OutputSerialization><CSV/></OutputSerialization>`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("SelectObjectContent status = %d, want 200", w.Code)
+		}
+		msgs := selectEvents(t, w.Body.Bytes())
+		if got := strings.Join(eventTypes(msgs), ","); got != "Records,error:CastFailed" {
+			t.Errorf("events = %s, want Records then a CastFailed error", got)
+		}
+	})
+}
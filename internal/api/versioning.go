@@ -0,0 +1,44 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/openendpoint/openendpoint/internal/engine"
+)
+
+// setVersionID sets the x-amz-version-id header when the object has a
+// reportable version ID
+func setVersionID(w http.ResponseWriter, versionID string) {
+	if versionID != "" {
+		w.Header().Set("x-amz-version-id", sanitizeHeaderValue(versionID))
+	}
+}
+
+// writeDeleteMarkerError answers a GET or HEAD that resolved to a delete
+// marker and reports whether err was such a failure. Reading the latest
+// version yields 404, addressing the marker by version ID yields 405.
+func (r *Router) writeDeleteMarkerError(w http.ResponseWriter, err error) bool {
+	var derr *engine.DeleteMarkerError
+	if !errors.As(err, &derr) {
+		return false
+	}
+
+	w.Header().Set("x-amz-delete-marker", "true")
+	setVersionID(w, derr.VersionID)
+	if derr.Explicit {
+		r.writeError(w, ErrMethodNotAllowed)
+	} else {
+		r.writeError(w, ErrNoSuchKey)
+	}
+	return true
+}
+
+// deleteMarkerStatus returns the metrics status label for a delete marker failure
+func deleteMarkerStatus(err error) string {
+	var derr *engine.DeleteMarkerError
+	if errors.As(err, &derr) && derr.Explicit {
+		return "405"
+	}
+	return "404"
+}
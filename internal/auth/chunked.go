@@ -0,0 +1,407 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/openendpoint/openendpoint/pkg/checksum"
+)
+
+// x-amz-content-sha256 values of aws-chunked uploads
+const (
+	// streamingPayload signs every chunk
+	streamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	// streamingPayloadTrailer signs every chunk and the trailing headers
+	streamingPayloadTrailer = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	// streamingUnsignedTrailer sends unsigned chunks and trailing headers
+	streamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+
+	// maxChunkHeaderLength bounds a chunk header or trailer line
+	maxChunkHeaderLength = 4096
+)
+
+// emptySHA256 is the hex SHA256 of no data, part of every chunk string to sign
+var emptySHA256 = hex.EncodeToString(sha256.New().Sum(nil))
+
+// Errors of aws-chunked bodies. Like the authentication errors they are
+// returned wrapped.
+var (
+	// ErrMalformedChunk is returned when the chunk framing is invalid or the
+	// decoded body differs from x-amz-decoded-content-length
+	ErrMalformedChunk = errors.New("malformed aws-chunked body")
+	// ErrMalformedTrailer is returned when the trailing headers are invalid
+	// or differ from those announced in x-amz-trailer
+	ErrMalformedTrailer = errors.New("malformed aws-chunked trailer")
+	// ErrChecksumMismatch is returned when a trailing checksum does not
+	// match the decoded body
+	ErrChecksumMismatch = errors.New("trailing checksum does not match")
+	// ErrUnsupportedStreaming is returned for streaming payload types this
+	// server cannot verify, such as SigV4a
+	ErrUnsupportedStreaming = errors.New("unsupported streaming payload")
+)
+
+// trailerChecksums are the checksum trailers an aws-chunked body may carry
+var trailerChecksums = map[string]func() hash.Hash{
+	"x-amz-checksum-crc32":  checksum.CRC32,
+	"x-amz-checksum-crc32c": checksum.CRC32C,
+	"x-amz-checksum-sha1":   checksum.SHA1,
+	"x-amz-checksum-sha256": checksum.SHA256,
+}
+
+// IsChunkedUpload reports whether req carries an aws-chunked body
+func IsChunkedUpload(req *http.Request) bool {
+	return strings.HasPrefix(req.Header.Get("X-Amz-Content-Sha256"), "STREAMING-")
+}
+
+// DecodedContentLength returns the payload length of an aws-chunked
+// request from x-amz-decoded-content-length, or -1 when it is not declared
+func DecodedContentLength(req *http.Request) (int64, error) {
+	value := req.Header.Get("X-Amz-Decoded-Content-Length")
+	if value == "" {
+		return -1, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return -1, fmt.Errorf("%w: invalid x-amz-decoded-content-length %q", ErrMalformedChunk, value)
+	}
+	return n, nil
+}
+
+// chunkSigner verifies the signature chain of an aws-chunked body. Each
+// chunk is signed over the signature before it, starting from the seed
+// signature of the Authorization header.
+type chunkSigner struct {
+	key     []byte
+	amzDate string
+	scope   string
+	prev    string
+}
+
+// verify checks sig against the string to sign of algorithm over the hex
+// SHA256 lines and moves the chain on to sig
+func (s *chunkSigner) verify(algorithm, sig string, hashes ...string) error {
+	stringToSign := algorithm + "\n" + s.amzDate + "\n" + s.scope + "\n" + s.prev + "\n" + strings.Join(hashes, "\n")
+	want := hex.EncodeToString(hmacSHA256(s.key, []byte(stringToSign)))
+	if !hmac.Equal([]byte(want), []byte(sig)) {
+		return ErrSignatureDoesNotMatch
+	}
+	s.prev = sig
+	return nil
+}
+
+// newChunkSigner builds the signer of a request signed with a SigV4
+// Authorization header. It returns nil when no credentials are configured,
+// as such requests are not authenticated.
+func (a *Auth) newChunkSigner(req *http.Request) (*chunkSigner, error) {
+	if len(a.credentials) == 0 {
+		return nil, nil
+	}
+
+	authHeader := req.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, sigV4Algorithm) {
+		return nil, fmt.Errorf("%w: signed streaming payload without a SigV4 Authorization header", ErrAccessDenied)
+	}
+	fields, err := parseSigV4Header(authHeader)
+	if err != nil {
+		return nil, err
+	}
+	accessKey, dateStamp, region, service, err := parseCredentialScope(fields["Credential"])
+	if err != nil {
+		return nil, err
+	}
+	cred, ok := a.credential(accessKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidAccessKeyID, accessKey)
+	}
+
+	return &chunkSigner{
+		key:     signingKey(cred.SecretKey, dateStamp, region, service),
+		amzDate: requestDate(req),
+		scope:   fmt.Sprintf("%s/%s/%s/aws4_request", dateStamp, region, service),
+		prev:    fields["Signature"],
+	}, nil
+}
+
+// NewChunkedReader returns a reader over the decoded payload of an
+// aws-chunked request. The request must already have passed Authorize:
+// signed chunks are verified against its seed signature, and any trailing
+// checksum against the decoded data. Reads fail once the body proves
+// invalid, so an upload is never completed with unverified data.
+func (a *Auth) NewChunkedReader(req *http.Request) (io.Reader, error) {
+	cr := &chunkedReader{
+		r:        bufio.NewReaderSize(req.Body, maxChunkHeaderLength),
+		expected: -1,
+	}
+
+	switch mode := req.Header.Get("X-Amz-Content-Sha256"); mode {
+	case streamingPayload, streamingPayloadTrailer:
+		cr.signed = true
+		cr.trailer = mode == streamingPayloadTrailer
+		signer, err := a.newChunkSigner(req)
+		if err != nil {
+			return nil, err
+		}
+		cr.signer = signer
+	case streamingUnsignedTrailer:
+		cr.trailer = true
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedStreaming, mode)
+	}
+
+	expected, err := DecodedContentLength(req)
+	if err != nil {
+		return nil, err
+	}
+	cr.expected = expected
+
+	if cr.trailer {
+		if name := strings.ToLower(strings.TrimSpace(req.Header.Get("X-Amz-Trailer"))); name != "" {
+			newHash, ok := trailerChecksums[name]
+			if !ok {
+				return nil, fmt.Errorf("%w: unsupported trailer %s", ErrMalformedTrailer, name)
+			}
+			cr.checksumName = name
+			cr.checksum = newHash()
+		}
+	}
+
+	return cr, nil
+}
+
+// chunkedReader decodes an aws-chunked body: chunks framed as
+// hex-size[;chunk-signature=sig]\r\n data \r\n, ending with an empty chunk
+// and, for trailer variants, trailing headers and an empty line.
+type chunkedReader struct {
+	r        *bufio.Reader
+	signed   bool         // chunk headers carry signatures
+	signer   *chunkSigner // nil when signatures are not verified
+	trailer  bool         // trailing headers follow the last chunk
+	expected int64        // x-amz-decoded-content-length, -1 if not declared
+
+	checksumName string // announced checksum trailer
+	checksum     hash.Hash
+
+	remaining int64     // data bytes left in the current chunk
+	chunkSig  string    // signature of the current chunk
+	chunkHash hash.Hash // SHA256 of the current chunk's data
+	inChunk   bool
+	decoded   int64
+	err       error
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	for c.remaining == 0 {
+		if c.inChunk {
+			if err := c.endChunk(); err != nil {
+				return 0, c.fail(err)
+			}
+		}
+		if err := c.startChunk(); err != nil {
+			return 0, c.fail(err)
+		}
+		if c.err != nil {
+			return 0, c.err
+		}
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.remaining -= int64(n)
+		c.decoded += int64(n)
+		if c.chunkHash != nil {
+			c.chunkHash.Write(p[:n])
+		}
+		if c.checksum != nil {
+			c.checksum.Write(p[:n])
+		}
+	}
+	if err == io.EOF {
+		return n, c.fail(fmt.Errorf("%w: body ends inside a chunk", ErrMalformedChunk))
+	}
+	if err != nil {
+		return n, c.fail(err)
+	}
+	return n, nil
+}
+
+// fail records err so every later Read returns it too
+func (c *chunkedReader) fail(err error) error {
+	c.err = err
+	return err
+}
+
+// startChunk reads a chunk header. The last, empty chunk is verified on the
+// spot and ends the body.
+func (c *chunkedReader) startChunk() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+
+	sizeField, sig := line, ""
+	if c.signed {
+		var ok bool
+		sizeField, sig, ok = strings.Cut(line, ";chunk-signature=")
+		if !ok {
+			return fmt.Errorf("%w: chunk header %q has no signature", ErrMalformedChunk, line)
+		}
+	}
+	size, err := strconv.ParseInt(sizeField, 16, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("%w: invalid chunk size %q", ErrMalformedChunk, sizeField)
+	}
+
+	c.remaining = size
+	c.chunkSig = sig
+	c.inChunk = true
+	if c.signer != nil {
+		c.chunkHash = sha256.New()
+	}
+	if size > 0 {
+		return nil
+	}
+
+	// The empty chunk closes the data
+	c.inChunk = false
+	if err := c.verifyChunk(); err != nil {
+		return err
+	}
+	if c.trailer {
+		if err := c.readTrailers(); err != nil {
+			return err
+		}
+	} else if err := c.expectCRLF(); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if c.expected >= 0 && c.decoded != c.expected {
+		return fmt.Errorf("%w: decoded %d bytes, x-amz-decoded-content-length is %d", ErrMalformedChunk, c.decoded, c.expected)
+	}
+	c.err = io.EOF
+	return nil
+}
+
+// endChunk consumes the CRLF after a chunk's data and verifies the chunk
+func (c *chunkedReader) endChunk() error {
+	c.inChunk = false
+	if err := c.expectCRLF(); err != nil {
+		return err
+	}
+	return c.verifyChunk()
+}
+
+// verifyChunk checks the signature of the chunk just read
+func (c *chunkedReader) verifyChunk() error {
+	if c.signer == nil {
+		return nil
+	}
+	if err := c.signer.verify("AWS4-HMAC-SHA256-PAYLOAD", c.chunkSig, emptySHA256, hex.EncodeToString(c.chunkHash.Sum(nil))); err != nil {
+		return fmt.Errorf("%w: chunk ending at byte %d", err, c.decoded)
+	}
+	return nil
+}
+
+// readTrailers reads the trailing headers up to the closing empty line,
+// checking the trailer signature and the announced checksum
+func (c *chunkedReader) readTrailers() error {
+	var canonical bytes.Buffer
+	var sig, value string
+	for {
+		line, err := c.readLine()
+		if errors.Is(err, io.EOF) {
+			break // some clients omit the closing empty line
+		}
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			break
+		}
+
+		name, v, ok := strings.Cut(line, ":")
+		if !ok {
+			return fmt.Errorf("%w: trailer line %q", ErrMalformedTrailer, line)
+		}
+		name, v = strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(v)
+		if name == "x-amz-trailer-signature" {
+			sig = v
+			continue
+		}
+		if name != c.checksumName {
+			return fmt.Errorf("%w: unexpected trailer %s", ErrMalformedTrailer, name)
+		}
+		value = v
+		canonical.WriteString(name + ":" + v + "\n")
+	}
+
+	if c.signer != nil {
+		if sig == "" {
+			return fmt.Errorf("%w: missing x-amz-trailer-signature", ErrMalformedTrailer)
+		}
+		sum := sha256.Sum256(canonical.Bytes())
+		if err := c.signer.verify("AWS4-HMAC-SHA256-TRAILER", sig, hex.EncodeToString(sum[:])); err != nil {
+			return fmt.Errorf("%w: trailer", err)
+		}
+	}
+
+	if c.checksum == nil {
+		return nil
+	}
+	if value == "" {
+		return fmt.Errorf("%w: missing %s", ErrMalformedTrailer, c.checksumName)
+	}
+	if got := base64.StdEncoding.EncodeToString(c.checksum.Sum(nil)); got != value {
+		return fmt.Errorf("%w: %s is %s, data has %s", ErrChecksumMismatch, c.checksumName, value, got)
+	}
+	return nil
+}
+
+// readLine reads a CRLF terminated line without its terminator
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", fmt.Errorf("%w: line longer than %d bytes", ErrMalformedChunk, maxChunkHeaderLength)
+	}
+	if err == io.EOF && len(line) == 0 {
+		return "", io.EOF
+	}
+	if err == io.EOF {
+		return "", fmt.Errorf("%w: unterminated line", ErrMalformedChunk)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
+
+// expectCRLF consumes the CRLF that ends a chunk
+func (c *chunkedReader) expectCRLF() error {
+	line, err := c.readLine()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("%w: missing chunk terminator: %w", ErrMalformedChunk, io.EOF)
+		}
+		return err
+	}
+	if line != "" {
+		return fmt.Errorf("%w: chunk longer than its declared size", ErrMalformedChunk)
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openendpoint/openendpoint/internal/config"
+)
+
+// chunkedRequest builds a SigV4 signed aws-chunked PUT of chunks in mode,
+// signing each chunk the way the AWS SDKs do. A non-empty trailer names
+// the checksum trailer to send, with value overriding the true checksum.
+func chunkedRequest(t *testing.T, a *Auth, mode string, chunks []string, trailer, value string) *http.Request {
+	t.Helper()
+	now := time.Now().UTC()
+	amzDate := now.Format(iso8601Format)
+	dateStamp := now.Format("20060102")
+	scope := dateStamp + "/us-east-1/s3/aws4_request"
+
+	req, _ := http.NewRequest("PUT", "/bucket/key", nil)
+	req.Host = "localhost:9000"
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", mode)
+	req.Header.Set("Content-Encoding", "aws-chunked")
+	req.Header.Set("X-Amz-Decoded-Content-Length", fmt.Sprint(len(strings.Join(chunks, ""))))
+	if trailer != "" {
+		req.Header.Set("X-Amz-Trailer", trailer)
+	}
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonical := a.buildCanonicalRequest(req, signedHeaders)
+	seed := a.calculateSignature("test-secret", dateStamp, "us-east-1", "s3", a.buildStringToSign(req, canonical, dateStamp, "us-east-1", "s3"))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=test-key/%s, SignedHeaders=%s, Signature=%s", sigV4Algorithm, scope, signedHeaders, seed))
+	if err := a.Authorize(req, "bucket", "s3:PutObject"); err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+
+	signer := &chunkSigner{key: signingKey("test-secret", dateStamp, "us-east-1", "s3"), amzDate: amzDate, scope: scope, prev: seed}
+	sign := func(algorithm string, hashes ...string) string {
+		stringToSign := algorithm + "\n" + amzDate + "\n" + scope + "\n" + signer.prev + "\n" + strings.Join(hashes, "\n")
+		signer.prev = hex.EncodeToString(hmacSHA256(signer.key, []byte(stringToSign)))
+		return signer.prev
+	}
+	signed := mode != streamingUnsignedTrailer
+
+	var body bytes.Buffer
+	for _, chunk := range append(chunks, "") {
+		fmt.Fprintf(&body, "%x", len(chunk))
+		if signed {
+			sum := sha256.Sum256([]byte(chunk))
+			body.WriteString(";chunk-signature=" + sign("AWS4-HMAC-SHA256-PAYLOAD", emptySHA256, hex.EncodeToString(sum[:])))
+		}
+		body.WriteString("\r\n")
+		if chunk != "" {
+			body.WriteString(chunk + "\r\n")
+		}
+	}
+	if mode != streamingPayload {
+		var canonical string
+		if trailer != "" {
+			if value == "" {
+				value = trailerChecksum(trailer, strings.Join(chunks, ""))
+			}
+			canonical = trailer + ":" + value + "\n"
+			body.WriteString(trailer + ":" + value + "\r\n")
+		}
+		if signed {
+			sum := sha256.Sum256([]byte(canonical))
+			body.WriteString("x-amz-trailer-signature:" + sign("AWS4-HMAC-SHA256-TRAILER", hex.EncodeToString(sum[:])) + "\r\n")
+		}
+	}
+	body.WriteString("\r\n")
+
+	req.Body = io.NopCloser(&body)
+	return req
+}
+
+// trailerChecksum returns the base64 checksum of data for a trailer
+func trailerChecksum(trailer, data string) string {
+	h := trailerChecksums[trailer]()
+	h.Write([]byte(data))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func decodeChunked(a *Auth, req *http.Request) (string, error) {
+	r, err := a.NewChunkedReader(req)
+	if err != nil {
+		return "", err
+	}
+	data, err := io.ReadAll(r)
+	return string(data), err
+}
+
+func TestChunkedReader(t *testing.T) {
+	a := New(config.AuthConfig{AccessKey: "test-key", SecretKey: "test-secret"})
+	chunks := []string{strings.Repeat("a", 8192), strings.Repeat("b", 8192), "tail"}
+	want := strings.Join(chunks, "")
+
+	tests := []struct {
+		name    string
+		mode    string
+		trailer string
+	}{
+		{"Signed", streamingPayload, ""},
+		{"SignedTrailer", streamingPayloadTrailer, "x-amz-checksum-crc32c"},
+		{"SignedTrailerNoChecksum", streamingPayloadTrailer, ""},
+		{"UnsignedTrailer", streamingUnsignedTrailer, "x-amz-checksum-crc32"},
+		{"UnsignedTrailerSHA256", streamingUnsignedTrailer, "x-amz-checksum-sha256"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeChunked(a, chunkedRequest(t, a, tt.mode, chunks, tt.trailer, ""))
+			if err != nil {
+				t.Fatalf("decode error = %v", err)
+			}
+			if got != want {
+				t.Errorf("decoded %d bytes, want %d", len(got), len(want))
+			}
+		})
+	}
+
+	t.Run("Empty", func(t *testing.T) {
+		got, err := decodeChunked(a, chunkedRequest(t, a, streamingPayload, nil, "", ""))
+		if err != nil || got != "" {
+			t.Errorf("decode = %q, %v", got, err)
+		}
+	})
+}
+
+func TestChunkedReader_Errors(t *testing.T) {
+	a := New(config.AuthConfig{AccessKey: "test-key", SecretKey: "test-secret"})
+	chunks := []string{"hello ", "world"}
+
+	tamper := func(req *http.Request, old, new string) *http.Request {
+		body, _ := io.ReadAll(req.Body)
+		req.Body = io.NopCloser(strings.NewReader(strings.Replace(string(body), old, new, 1)))
+		return req
+	}
+
+	tests := []struct {
+		name string
+		req  func(t *testing.T) *http.Request
+		want error
+	}{
+		{"TamperedData", func(t *testing.T) *http.Request {
+			return tamper(chunkedRequest(t, a, streamingPayload, chunks, "", ""), "world", "w0rld")
+		}, ErrSignatureDoesNotMatch},
+		{"ChecksumMismatch", func(t *testing.T) *http.Request {
+			return chunkedRequest(t, a, streamingUnsignedTrailer, chunks, "x-amz-checksum-crc32", "AAAAAA==")
+		}, ErrChecksumMismatch},
+		{"SignedChecksumMismatch", func(t *testing.T) *http.Request {
+			return chunkedRequest(t, a, streamingPayloadTrailer, chunks, "x-amz-checksum-sha1", "AAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+		}, ErrChecksumMismatch},
+		{"TamperedTrailer", func(t *testing.T) *http.Request {
+			// The data matches the new checksum, but the trailer is signed
+			req := chunkedRequest(t, a, streamingPayloadTrailer, chunks, "x-amz-checksum-crc32", "")
+			req = tamper(req, "world", "w0rld")
+			return tamper(req, trailerChecksum("x-amz-checksum-crc32", "hello world"), trailerChecksum("x-amz-checksum-crc32", "hello w0rld"))
+		}, ErrSignatureDoesNotMatch},
+		{"MissingTrailer", func(t *testing.T) *http.Request {
+			req := chunkedRequest(t, a, streamingUnsignedTrailer, chunks, "", "")
+			req.Header.Set("X-Amz-Trailer", "x-amz-checksum-crc32")
+			return req
+		}, ErrMalformedTrailer},
+		{"UnsupportedTrailer", func(t *testing.T) *http.Request {
+			req := chunkedRequest(t, a, streamingUnsignedTrailer, chunks, "", "")
+			req.Header.Set("X-Amz-Trailer", "x-amz-checksum-md5")
+			return req
+		}, ErrMalformedTrailer},
+		{"DecodedLengthMismatch", func(t *testing.T) *http.Request {
+			req := chunkedRequest(t, a, streamingPayload, chunks, "", "")
+			req.Header.Set("X-Amz-Decoded-Content-Length", "12")
+			return req
+		}, ErrMalformedChunk},
+		{"BadChunkSize", func(t *testing.T) *http.Request {
+			return tamper(chunkedRequest(t, a, streamingUnsignedTrailer, chunks, "", ""), "6\r\n", "zz\r\n")
+		}, ErrMalformedChunk},
+		{"Truncated", func(t *testing.T) *http.Request {
+			req := chunkedRequest(t, a, streamingPayload, chunks, "", "")
+			body, _ := io.ReadAll(req.Body)
+			req.Body = io.NopCloser(bytes.NewReader(body[:len(body)/2]))
+			return req
+		}, ErrMalformedChunk},
+		{"SigV4a", func(t *testing.T) *http.Request {
+			req := chunkedRequest(t, a, streamingPayload, chunks, "", "")
+			req.Header.Set("X-Amz-Content-Sha256", "STREAMING-AWS4-ECDSA-P256-SHA256-PAYLOAD")
+			return req
+		}, ErrUnsupportedStreaming},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeChunked(a, tt.req(t))
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("decode error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("decode error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunkedReader_NoCredentials(t *testing.T) {
+	signer := New(config.AuthConfig{AccessKey: "test-key", SecretKey: "test-secret"})
+	req := chunkedRequest(t, signer, streamingPayload, []string{"data"}, "", "")
+
+	// Without credentials the framing is decoded but nothing is verified
+	got, err := decodeChunked(New(config.AuthConfig{}), req)
+	if err != nil || got != "data" {
+		t.Errorf("decode = %q, %v", got, err)
+	}
+}
+
+func TestIsChunkedUpload(t *testing.T) {
+	req, _ := http.NewRequest("PUT", "/bucket/key", nil)
+	if IsChunkedUpload(req) {
+		t.Error("plain request reported as chunked")
+	}
+	req.Header.Set("X-Amz-Content-Sha256", streamingUnsignedTrailer)
+	if !IsChunkedUpload(req) {
+		t.Error("streaming request not reported as chunked")
+	}
+
+	if n, err := DecodedContentLength(req); n != -1 || err != nil {
+		t.Errorf("DecodedContentLength() = %d, %v", n, err)
+	}
+	req.Header.Set("X-Amz-Decoded-Content-Length", "-5")
+	if _, err := DecodedContentLength(req); !errors.Is(err, ErrMalformedChunk) {
+		t.Errorf("DecodedContentLength() error = %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+)
+
+// Errors of payload verification. Like the aws-chunked errors they are
+// returned wrapped, the mismatches from the final Read of the body.
+var (
+	// ErrInvalidDigest is returned when Content-MD5 is not a base64 MD5
+	ErrInvalidDigest = errors.New("malformed Content-MD5")
+	// ErrInvalidContentSHA256 is returned when x-amz-content-sha256 is
+	// neither a hex SHA256 nor one of the unsigned or streaming markers
+	ErrInvalidContentSHA256 = errors.New("malformed x-amz-content-sha256")
+	// ErrContentMD5Mismatch is returned when the body does not match
+	// Content-MD5
+	ErrContentMD5Mismatch = errors.New("Content-MD5 does not match the body")
+	// ErrContentSHA256Mismatch is returned when the body does not match
+	// the x-amz-content-sha256 covered by the signature
+	ErrContentSHA256Mismatch = errors.New("x-amz-content-sha256 does not match the body")
+)
+
+// payloadDigest is a digest a body is verified against
+type payloadDigest struct {
+	hash hash.Hash
+	want []byte
+	err  error // returned when the body does not match
+}
+
+// payloadReader hashes everything read through it and fails the read
+// that reaches EOF when a digest does not match, so a backend streaming
+// the body never commits data that differs from what the client declared
+type payloadReader struct {
+	r       io.Reader
+	digests []payloadDigest
+}
+
+// NewPayloadReader wraps body, the decoded payload of req, to verify it
+// against the Content-MD5 and x-amz-content-sha256 headers of req. The
+// payload hash is only checked when it is a hex SHA256: UNSIGNED-PAYLOAD
+// and aws-chunked bodies, whose chunks are verified as they are decoded,
+// pass through. body is returned unwrapped when there is nothing to verify.
+func NewPayloadReader(req *http.Request, body io.Reader) (io.Reader, error) {
+	var digests []payloadDigest
+
+	if value := req.Header.Get("Content-MD5"); value != "" {
+		want, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(want) != md5.Size {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidDigest, value)
+		}
+		digests = append(digests, payloadDigest{hash: md5.New(), want: want, err: ErrContentMD5Mismatch})
+	}
+
+	if value := req.Header.Get("X-Amz-Content-Sha256"); value != "" && value != unsignedPayload && !IsChunkedUpload(req) {
+		want, err := hex.DecodeString(value)
+		if err != nil || len(want) != sha256.Size {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidContentSHA256, value)
+		}
+		digests = append(digests, payloadDigest{hash: sha256.New(), want: want, err: ErrContentSHA256Mismatch})
+	}
+
+	if len(digests) == 0 {
+		return body, nil
+	}
+	return &payloadReader{r: body, digests: digests}, nil
+}
+
+func (p *payloadReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	for _, d := range p.digests {
+		d.hash.Write(b[:n])
+	}
+	if err == io.EOF {
+		for _, d := range p.digests {
+			if got := d.hash.Sum(nil); !bytes.Equal(got, d.want) {
+				return n, fmt.Errorf("%w: body has %x, declared %x", d.err, got, d.want)
+			}
+		}
+	}
+	return n, err
+}
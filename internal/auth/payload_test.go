@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewPayloadReader(t *testing.T) {
+	body := "hello world"
+	md5sum := md5.Sum([]byte(body))
+	shasum := sha256.Sum256([]byte(body))
+	goodMD5 := base64.StdEncoding.EncodeToString(md5sum[:])
+	goodSHA := hex.EncodeToString(shasum[:])
+	otherMD5 := md5.Sum([]byte("other"))
+	otherSHA := sha256.Sum256([]byte("other"))
+
+	tests := []struct {
+		name      string
+		md5       string
+		sha256    string
+		wantNew   error
+		wantRead  error
+		unwrapped bool
+	}{
+		{name: "Nothing", unwrapped: true},
+		{name: "Unsigned", sha256: "UNSIGNED-PAYLOAD", unwrapped: true},
+		{name: "Streaming", sha256: "STREAMING-UNSIGNED-PAYLOAD-TRAILER", unwrapped: true},
+		{name: "MD5", md5: goodMD5},
+		{name: "SHA256", sha256: goodSHA},
+		{name: "Both", md5: goodMD5, sha256: goodSHA},
+		{name: "MD5Mismatch", md5: base64.StdEncoding.EncodeToString(otherMD5[:]), wantRead: ErrContentMD5Mismatch},
+		{name: "SHA256Mismatch", sha256: hex.EncodeToString(otherSHA[:]), wantRead: ErrContentSHA256Mismatch},
+		{name: "MalformedMD5", md5: "not-md5", wantNew: ErrInvalidDigest},
+		{name: "ShortMD5", md5: base64.StdEncoding.EncodeToString(md5sum[:8]), wantNew: ErrInvalidDigest},
+		{name: "MalformedSHA256", sha256: "abc", wantNew: ErrInvalidContentSHA256},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PUT", "/bucket/key", nil)
+			if tt.md5 != "" {
+				req.Header.Set("Content-MD5", tt.md5)
+			}
+			if tt.sha256 != "" {
+				req.Header.Set("X-Amz-Content-Sha256", tt.sha256)
+			}
+
+			src := strings.NewReader(body)
+			r, err := NewPayloadReader(req, src)
+			if !errors.Is(err, tt.wantNew) {
+				t.Fatalf("NewPayloadReader() error = %v, want %v", err, tt.wantNew)
+			}
+			if err != nil {
+				return
+			}
+			if tt.unwrapped && r != io.Reader(src) {
+				t.Error("NewPayloadReader() wrapped a body with nothing to verify")
+			}
+
+			got, err := io.ReadAll(r)
+			if !errors.Is(err, tt.wantRead) {
+				t.Fatalf("Read() error = %v, want %v", err, tt.wantRead)
+			}
+			if string(got) != body {
+				t.Errorf("Read() = %q, want %q", got, body)
+			}
+		})
+	}
+}
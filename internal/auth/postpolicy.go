@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors of browser POST uploads. Like the authentication errors they are
+// returned wrapped.
+var (
+	// ErrInvalidPolicyDocument is returned when the policy form field is not
+	// a base64 JSON document with an expiration and valid conditions
+	ErrInvalidPolicyDocument = errors.New("invalid POST policy document")
+	// ErrPolicyExpired is returned when the policy expiration has passed
+	ErrPolicyExpired = errors.New("POST policy expired")
+	// ErrPolicyConditionFailed is returned when the form fields do not meet
+	// the policy conditions, or a field is not covered by any condition
+	ErrPolicyConditionFailed = errors.New("POST policy condition failed")
+)
+
+// postPolicyExempt are the form fields a policy need not have conditions
+// for, along with those prefixed x-ignore-
+var postPolicyExempt = map[string]bool{
+	"policy":          true,
+	"x-amz-signature": true,
+	"file":            true,
+}
+
+// PostPolicy is the verified policy document of a browser POST upload
+type PostPolicy struct {
+	Expiration time.Time
+
+	// MinLength and MaxLength bound the size of the uploaded file as given
+	// by a content-length-range condition; MaxLength is -1 when unbounded
+	MinLength int64
+	MaxLength int64
+}
+
+// postCondition is an eq or starts-with condition on one form field
+type postCondition struct {
+	op    string
+	field string // lower-case form field name, without the $
+	value string
+}
+
+// VerifyPostPolicy verifies the fields of a browser POST upload to bucket:
+// the SigV4 signature of the policy field, its expiration and that every
+// condition holds. fields maps lower-case form field names to their values,
+// with ${filename} already substituted in the key. Unless authentication is
+// disabled, a POST without a signed policy is rejected as anonymous, with
+// an error wrapping ErrAnonymousRequest.
+func (a *Auth) VerifyPostPolicy(bucket string, fields map[string]string) (*PostPolicy, error) {
+	encoded := fields["policy"]
+	if encoded == "" {
+		if len(a.credentials) == 0 {
+			return &PostPolicy{MaxLength: -1}, nil
+		}
+		return nil, fmt.Errorf("%w: %w: POST upload to %q", ErrAccessDenied, ErrAnonymousRequest, bucket)
+	}
+
+	if len(a.credentials) > 0 {
+		if err := a.verifyPostSignature(encoded, fields); err != nil {
+			return nil, err
+		}
+	}
+
+	policy, conditions, err := parsePostPolicy(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(policy.Expiration) {
+		return nil, fmt.Errorf("%w at %s", ErrPolicyExpired, policy.Expiration.Format(time.RFC3339))
+	}
+
+	covered := map[string]bool{}
+	for _, c := range conditions {
+		value := fields[c.field]
+		if c.field == "bucket" {
+			value = bucket
+		}
+		if c.op == "eq" && value != c.value || c.op == "starts-with" && !strings.HasPrefix(value, c.value) {
+			return nil, fmt.Errorf("%w: [%q, \"$%s\", %q]", ErrPolicyConditionFailed, c.op, c.field, c.value)
+		}
+		covered[c.field] = true
+	}
+	for name := range fields {
+		if !covered[name] && !postPolicyExempt[name] && !strings.HasPrefix(name, "x-ignore-") {
+			return nil, fmt.Errorf("%w: extra input field %q", ErrPolicyConditionFailed, name)
+		}
+	}
+	return policy, nil
+}
+
+// verifyPostSignature checks the SigV4 signature of a POST policy, which
+// signs the base64 policy itself with the key of the credential scope
+func (a *Auth) verifyPostSignature(encoded string, fields map[string]string) error {
+	if algorithm := fields["x-amz-algorithm"]; algorithm != sigV4Algorithm {
+		return fmt.Errorf("%w: unsupported POST algorithm %q", ErrAccessDenied, algorithm)
+	}
+	signature := fields["x-amz-signature"]
+	if signature == "" {
+		return fmt.Errorf("%w: missing x-amz-signature", ErrAccessDenied)
+	}
+
+	accessKey, dateStamp, region, service, err := parseCredentialScope(fields["x-amz-credential"])
+	if err != nil {
+		return err
+	}
+	cred, ok := a.credential(accessKey)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrInvalidAccessKeyID, accessKey)
+	}
+	if !strings.HasPrefix(fields["x-amz-date"], dateStamp) {
+		return fmt.Errorf("%w: credential date does not match x-amz-date", ErrSignatureDoesNotMatch)
+	}
+
+	expected := hex.EncodeToString(hmacSHA256(signingKey(cred.SecretKey, dateStamp, region, service), []byte(encoded)))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return fmt.Errorf("%w: POST policy signature mismatch", ErrSignatureDoesNotMatch)
+	}
+	return nil
+}
+
+// parsePostPolicy decodes a base64 policy document into its expiration,
+// content-length-range and field conditions
+func parsePostPolicy(encoded string) (*PostPolicy, []postCondition, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: policy is not base64", ErrInvalidPolicyDocument)
+	}
+	var doc struct {
+		Expiration string            `json:"expiration"`
+		Conditions []json.RawMessage `json:"conditions"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidPolicyDocument, err)
+	}
+	expiration, err := time.Parse(time.RFC3339, doc.Expiration)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: invalid expiration %q", ErrInvalidPolicyDocument, doc.Expiration)
+	}
+
+	policy := &PostPolicy{Expiration: expiration, MaxLength: -1}
+	var conditions []postCondition
+	for _, raw := range doc.Conditions {
+		// {"field": "value"} is shorthand for an exact match
+		var exact map[string]string
+		if err := json.Unmarshal(raw, &exact); err == nil {
+			for field, value := range exact {
+				conditions = append(conditions, postCondition{op: "eq", field: strings.ToLower(field), value: value})
+			}
+			continue
+		}
+
+		var args []json.RawMessage
+		if err := json.Unmarshal(raw, &args); err != nil || len(args) != 3 {
+			return nil, nil, fmt.Errorf("%w: invalid condition %s", ErrInvalidPolicyDocument, raw)
+		}
+		var op string
+		if err := json.Unmarshal(args[0], &op); err != nil {
+			return nil, nil, fmt.Errorf("%w: invalid condition %s", ErrInvalidPolicyDocument, raw)
+		}
+		op = strings.ToLower(op)
+
+		if op == "content-length-range" {
+			min, errMin := policyInt(args[1])
+			max, errMax := policyInt(args[2])
+			if errMin != nil || errMax != nil || min < 0 || max < min {
+				return nil, nil, fmt.Errorf("%w: invalid content-length-range %s", ErrInvalidPolicyDocument, raw)
+			}
+			policy.MinLength, policy.MaxLength = min, max
+			continue
+		}
+
+		var field, value string
+		errField := json.Unmarshal(args[1], &field)
+		errValue := json.Unmarshal(args[2], &value)
+		field, ok := strings.CutPrefix(field, "$")
+		if errField != nil || errValue != nil || !ok || op != "eq" && op != "starts-with" {
+			return nil, nil, fmt.Errorf("%w: invalid condition %s", ErrInvalidPolicyDocument, raw)
+		}
+		conditions = append(conditions, postCondition{op: op, field: strings.ToLower(field), value: value})
+	}
+	return policy, conditions, nil
+}
+
+// policyInt decodes a content-length-range bound, a JSON number or string
+func policyInt(raw json.RawMessage) (int64, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return strconv.ParseInt(s, 10, 64)
+	}
+	var n int64
+	err := json.Unmarshal(raw, &n)
+	return n, err
+}
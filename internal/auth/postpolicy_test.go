@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openendpoint/openendpoint/internal/config"
+)
+
+// signedPostFields returns the form fields of a POST upload signed with
+// secret, carrying policy and any extra fields
+func signedPostFields(secret, policy string, extra map[string]string) map[string]string {
+	now := time.Now().UTC()
+	dateStamp := now.Format("20060102")
+	encoded := base64.StdEncoding.EncodeToString([]byte(policy))
+	key := signingKey(secret, dateStamp, "us-east-1", "s3")
+
+	fields := map[string]string{
+		"policy":           encoded,
+		"x-amz-algorithm":  sigV4Algorithm,
+		"x-amz-credential": "test-key/" + dateStamp + "/us-east-1/s3/aws4_request",
+		"x-amz-date":       now.Format(iso8601Format),
+		"x-amz-signature":  hex.EncodeToString(hmacSHA256(key, []byte(encoded))),
+	}
+	for k, v := range extra {
+		fields[k] = v
+	}
+	return fields
+}
+
+func TestAuth_VerifyPostPolicy(t *testing.T) {
+	a := New(config.AuthConfig{AccessKey: "test-key", SecretKey: "test-secret"})
+	expiration := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	policy := func(conditions ...string) string {
+		return `{"expiration": "` + expiration + `", "conditions": [` + strings.Join(append(conditions,
+			`{"x-amz-algorithm": "AWS4-HMAC-SHA256"}`,
+			`["starts-with", "$x-amz-credential", "test-key/"]`,
+			`["starts-with", "$x-amz-date", ""]`), ", ") + `]}`
+	}
+	basic := policy(`{"bucket": "photos"}`, `["starts-with", "$key", "uploads/"]`)
+
+	tests := []struct {
+		name    string
+		fields  map[string]string
+		wantErr error
+	}{
+		{"Valid", signedPostFields("test-secret", basic, map[string]string{"key": "uploads/a.jpg"}), nil},
+		{"WrongSecret", signedPostFields("other-secret", basic, map[string]string{"key": "uploads/a.jpg"}), ErrSignatureDoesNotMatch},
+		{"Anonymous", map[string]string{"key": "uploads/a.jpg"}, ErrAccessDenied},
+		{"StartsWithFailed", signedPostFields("test-secret", basic, map[string]string{"key": "other/a.jpg"}), ErrPolicyConditionFailed},
+		{"ExtraField", signedPostFields("test-secret", basic, map[string]string{"key": "uploads/a.jpg", "acl": "public-read"}), ErrPolicyConditionFailed},
+		{"IgnoredField", signedPostFields("test-secret", basic, map[string]string{"key": "uploads/a.jpg", "x-ignore-me": "1"}), nil},
+		{"EqFailed", signedPostFields("test-secret", policy(`["eq", "$key", "exact"]`), map[string]string{"key": "uploads/a.jpg"}), ErrPolicyConditionFailed},
+		{"WrongBucket", signedPostFields("test-secret", policy(`{"bucket": "other"}`, `["eq", "$key", "k"]`), map[string]string{"key": "k"}), ErrPolicyConditionFailed},
+		{"Expired", signedPostFields("test-secret", `{"expiration": "2001-01-01T00:00:00Z", "conditions": []}`, nil), ErrPolicyExpired},
+		{"NotJSON", signedPostFields("test-secret", "not json", nil), ErrInvalidPolicyDocument},
+		{"UnknownOperator", signedPostFields("test-secret", policy(`["ends-with", "$key", "x"]`), nil), ErrInvalidPolicyDocument},
+		{"BadRange", signedPostFields("test-secret", policy(`["content-length-range", 10, 1]`), nil), ErrInvalidPolicyDocument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.VerifyPostPolicy("photos", tt.fields)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("VerifyPostPolicy() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuth_VerifyPostPolicyContentLengthRange(t *testing.T) {
+	a := New(config.AuthConfig{AccessKey: "test-key", SecretKey: "test-secret"})
+	expiration := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	tests := []struct {
+		condition string
+		min, max  int64
+	}{
+		{`["content-length-range", 1, 1048576]`, 1, 1048576},
+		{`["content-length-range", "0", "10"]`, 0, 10},
+		{`["eq", "$key", "k"]`, 0, -1},
+	}
+	for _, tt := range tests {
+		policy := `{"expiration": "` + expiration + `", "conditions": [` + tt.condition + `, ["eq", "$key", "k"],
+			["eq", "$x-amz-algorithm", "AWS4-HMAC-SHA256"], ["starts-with", "$x-amz-credential", ""], ["starts-with", "$x-amz-date", ""]]}`
+		got, err := a.VerifyPostPolicy("bucket", signedPostFields("test-secret", policy, map[string]string{"key": "k"}))
+		if err != nil {
+			t.Fatalf("VerifyPostPolicy(%s) error = %v", tt.condition, err)
+		}
+		if got.MinLength != tt.min || got.MaxLength != tt.max {
+			t.Errorf("VerifyPostPolicy(%s) range = %d-%d, want %d-%d", tt.condition, got.MinLength, got.MaxLength, tt.min, tt.max)
+		}
+	}
+}
+
+func TestAuth_VerifyPostPolicyNoCredentials(t *testing.T) {
+	a := New(config.AuthConfig{})
+	got, err := a.VerifyPostPolicy("bucket", map[string]string{"key": "k"})
+	if err != nil {
+		t.Fatalf("VerifyPostPolicy() without credentials error = %v", err)
+	}
+	if got.MaxLength != -1 {
+		t.Errorf("VerifyPostPolicy() MaxLength = %d, want -1", got.MaxLength)
+	}
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/spf13/viper"
 )
@@ -22,23 +23,33 @@ type Config struct {
 	Metrics   MetricsConfig   `mapstructure:"metrics"`
 	TLS       TLSConfig       `mapstructure:"tls"`
 	RateLimit RateLimitConfig `mapstructure:"rate_limit"`
+	Encryption EncryptionConfig `mapstructure:"encryption"`
 	LogLevel  string          `mapstructure:"log_level"`
 }
 
 type ServerConfig struct {
-	Host         string `mapstructure:"host"`
-	Port         int    `mapstructure:"port"`
-	ReadTimeout  int    `mapstructure:"read_timeout"`
-	WriteTimeout int    `mapstructure:"write_timeout"`
-	IdleTimeout  int    `mapstructure:"idle_timeout"`
+	Host         string   `mapstructure:"host"`
+	Port         int      `mapstructure:"port"`
+	ReadTimeout  int      `mapstructure:"read_timeout"`
+	WriteTimeout int      `mapstructure:"write_timeout"`
+	IdleTimeout  int      `mapstructure:"idle_timeout"`
+	S3Root       bool     `mapstructure:"s3_root"`   // serve the S3 API at / instead of /s3/
+	Domains      []string `mapstructure:"domains"`   // base domains of virtual-hosted-style requests, bucket.domain/key
+	MgmtPort     int      `mapstructure:"mgmt_port"` // serve management, dashboard, health and metrics on their own port, 0 shares the S3 port
 }
 
 type StorageConfig struct {
-	DataDir            string `mapstructure:"data_dir"`
-	MaxObjectSize      int64  `mapstructure:"max_object_size"`
-	MaxBuckets         int    `mapstructure:"max_buckets"`
-	EnableCompression  bool   `mapstructure:"enable_compression"`
-	StorageBackend     string `mapstructure:"storage_backend"` // flatfile, packed
+	DataDir                   string  `mapstructure:"data_dir"`
+	MaxObjectSize             int64   `mapstructure:"max_object_size"`
+	MaxBuckets                int     `mapstructure:"max_buckets"`
+	EnableCompression         bool    `mapstructure:"enable_compression"`
+	StorageBackend            string  `mapstructure:"storage_backend"`             // flatfile, packed
+	PackedVolumeSize          int64   `mapstructure:"packed_volume_size"`          // bytes per volume file of the packed backend
+	PackedCompactionThreshold float64 `mapstructure:"packed_compaction_threshold"` // garbage ratio at which a full volume is compacted
+	PackedCompactionInterval  int     `mapstructure:"packed_compaction_interval"`  // minutes between compaction passes, 0 disables
+	MultipartMaxAge           int     `mapstructure:"multipart_max_age"`           // hours before an incomplete multipart upload is aborted, 0 disables
+	FsckOnStartup             bool    `mapstructure:"fsck_on_startup"`             // reconcile data and metadata before serving
+	FsckRepair                bool    `mapstructure:"fsck_repair"`                 // let the startup fsck delete orphans and dangling entries
 }
 
 type AuthConfig struct {
@@ -47,6 +58,21 @@ type AuthConfig struct {
 	SessionExpiry int    `mapstructure:"session_expiry"` // in hours
 }
 
+type EncryptionConfig struct {
+	MasterKey     string    `mapstructure:"master_key"`      // base64 256-bit key sealing SSE-S3 data keys
+	MasterKeyFile string    `mapstructure:"master_key_file"` // file holding the base64 master key, read when master_key is empty
+	KMS           KMSConfig `mapstructure:"kms"`
+}
+
+// KMSConfig configures the key provider of SSE-KMS
+type KMSConfig struct {
+	Provider       string `mapstructure:"provider"`        // local, http, or empty to disable SSE-KMS
+	KeyringFile    string `mapstructure:"keyring_file"`    // keyring of the local provider, sealed by the master key; defaults to <data_dir>/kms/keyring
+	Endpoint       string `mapstructure:"endpoint"`        // base URL of the http provider
+	Token          string `mapstructure:"token"`           // bearer token sent to the http provider
+	RewrapInterval int    `mapstructure:"rewrap_interval"` // minutes between passes re-wrapping data keys under the latest key versions, 0 disables
+}
+
 type ClusterConfig struct {
 	Enabled         bool   `mapstructure:"enabled"`
 	NodeID          string `mapstructure:"node_id"`
@@ -163,17 +189,34 @@ func Load(path string) (*Config, error) {
 	v.SetDefault("server.read_timeout", 30)
 	v.SetDefault("server.write_timeout", 30)
 	v.SetDefault("server.idle_timeout", 60)
+	v.SetDefault("server.s3_root", false)
+	v.SetDefault("server.domains", []string{})
+	v.SetDefault("server.mgmt_port", 0)
 
 	v.SetDefault("storage.data_dir", "/var/lib/openendpoint")
 	v.SetDefault("storage.max_object_size", 5*1024*1024*1024) // 5GB
 	v.SetDefault("storage.max_buckets", 100)
 	v.SetDefault("storage.enable_compression", false)
 	v.SetDefault("storage.storage_backend", "flatfile")
+	v.SetDefault("storage.packed_volume_size", 1024*1024*1024) // 1GB
+	v.SetDefault("storage.packed_compaction_threshold", 0.5)
+	v.SetDefault("storage.packed_compaction_interval", 60)
+	v.SetDefault("storage.multipart_max_age", 24)
+	v.SetDefault("storage.fsck_on_startup", false)
+	v.SetDefault("storage.fsck_repair", false)
 
 	v.SetDefault("auth.secret_key", "")
 	v.SetDefault("auth.access_key", "")
 	v.SetDefault("auth.session_expiry", 24)
 
+	v.SetDefault("encryption.master_key", "")
+	v.SetDefault("encryption.master_key_file", "")
+	v.SetDefault("encryption.kms.provider", "")
+	v.SetDefault("encryption.kms.keyring_file", "")
+	v.SetDefault("encryption.kms.endpoint", "")
+	v.SetDefault("encryption.kms.token", "")
+	v.SetDefault("encryption.kms.rewrap_interval", 1440)
+
 	v.SetDefault("cluster.enabled", false)
 	v.SetDefault("cluster.node_id", "")
 	v.SetDefault("cluster.bind_addr", "0.0.0.0")
@@ -232,6 +275,12 @@ func Load(path string) (*Config, error) {
 	if cfg.Auth.AccessKey == "" {
 		cfg.Auth.AccessKey = os.Getenv("OPENEP_ACCESS_KEY")
 	}
+	if cfg.Encryption.MasterKey == "" {
+		cfg.Encryption.MasterKey = os.Getenv("OPENEP_MASTER_KEY")
+	}
+	if cfg.Encryption.KMS.KeyringFile == "" {
+		cfg.Encryption.KMS.KeyringFile = filepath.Join(cfg.Storage.DataDir, "kms", "keyring")
+	}
 
 	return &cfg, nil
 }
@@ -15,6 +15,17 @@ func (c *Config) Validate() error {
 	if c.Server.Port < 1 || c.Server.Port > 65535 {
 		return fmt.Errorf("port must be between 1 and 65535, got %d", c.Server.Port)
 	}
+	if c.Server.MgmtPort < 0 || c.Server.MgmtPort > 65535 {
+		return fmt.Errorf("management port must be between 0 and 65535, got %d", c.Server.MgmtPort)
+	}
+	if c.Server.MgmtPort != 0 && c.Server.MgmtPort == c.Server.Port {
+		return fmt.Errorf("management port must differ from the S3 port %d", c.Server.Port)
+	}
+	for _, domain := range c.Server.Domains {
+		if domain == "" || strings.ContainsAny(domain, ":/") {
+			return fmt.Errorf("invalid virtual host domain %q", domain)
+		}
+	}
 
 	// Validate storage config
 	if c.Storage.DataDir == "" {
@@ -171,6 +171,40 @@ func TestConfigValidate(t *testing.T) {
 			wantErr: true,
 			errMsg:  "cluster replication factor must be between 1 and 7",
 		},
+		{
+			name: "management port same as S3 port",
+			config: &Config{
+				Server: ServerConfig{
+					Port:     9000,
+					MgmtPort: 9000,
+				},
+				Storage: StorageConfig{
+					DataDir: t.TempDir(),
+				},
+				Auth: AuthConfig{
+					SecretKey: "test-secret-key-123",
+				},
+			},
+			wantErr: true,
+			errMsg:  "management port must differ from the S3 port 9000",
+		},
+		{
+			name: "virtual host domain with port",
+			config: &Config{
+				Server: ServerConfig{
+					Port:    9000,
+					Domains: []string{"s3.example.local:9000"},
+				},
+				Storage: StorageConfig{
+					DataDir: t.TempDir(),
+				},
+				Auth: AuthConfig{
+					SecretKey: "test-secret-key-123",
+				},
+			},
+			wantErr: true,
+			errMsg:  "invalid virtual host domain",
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,71 @@
+package encryption
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// KeySize is the size of AES-256 master, customer and data keys
+const KeySize = 32
+
+// NewDataKey returns a random key for sealing the data of one object
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(randReader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// SealKey encrypts a data key under a key encryption key, returning it
+// base64 encoded for storage in object metadata
+func SealKey(kek, dataKey []byte) (string, error) {
+	sealed, err := Encrypt(kek, dataKey)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenKey decrypts a data key sealed by SealKey
+func OpenKey(kek []byte, sealed string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	return Decrypt(kek, data)
+}
+
+// KeyMD5 returns the base64 MD5 digest S3 uses to identify a customer key
+func KeyMD5(key []byte) string {
+	sum := md5.Sum(key)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// LoadMasterKey returns the base64 master key given in key or, when key
+// is empty, stored in file. It returns nil when neither is set.
+func LoadMasterKey(key, file string) ([]byte, error) {
+	if key == "" && file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read master key file: %w", err)
+		}
+		key = strings.TrimSpace(string(data))
+	}
+	if key == "" {
+		return nil, nil
+	}
+
+	master, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("master key is not valid base64: %w", err)
+	}
+	if len(master) != KeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", KeySize, len(master))
+	}
+	return master, nil
+}
@@ -0,0 +1,51 @@
+package encryption
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestKeys_SealOpen(t *testing.T) {
+	kek, _ := NewDataKey()
+	dataKey, _ := NewDataKey()
+	sealed, err := SealKey(kek, dataKey)
+	if err != nil {
+		t.Fatalf("SealKey() error: %v", err)
+	}
+	if got, err := OpenKey(kek, sealed); err != nil || !bytes.Equal(got, dataKey) {
+		t.Errorf("OpenKey() = %x, %v, expected %x", got, err, dataKey)
+	}
+	other, _ := NewDataKey()
+	if _, err := OpenKey(other, sealed); err == nil {
+		t.Error("OpenKey() with another key succeeded")
+	}
+}
+
+func TestLoadMasterKey(t *testing.T) {
+	if key, err := LoadMasterKey("", ""); key != nil || err != nil {
+		t.Errorf("LoadMasterKey() unset = %x, %v, expected nil", key, err)
+	}
+
+	encoded := "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	if key, err := LoadMasterKey(encoded, ""); err != nil || string(key) != "0123456789abcdef0123456789abcdef" {
+		t.Errorf("LoadMasterKey() = %q, %v", key, err)
+	}
+
+	file := t.TempDir() + "/master.key"
+	if err := os.WriteFile(file, []byte(encoded+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if key, err := LoadMasterKey("", file); err != nil || len(key) != KeySize {
+		t.Errorf("LoadMasterKey() from file = %x, %v", key, err)
+	}
+
+	for _, bad := range []string{"not base64!", "c2hvcnQ="} {
+		if _, err := LoadMasterKey(bad, ""); err == nil {
+			t.Errorf("LoadMasterKey(%q) succeeded", bad)
+		}
+	}
+	if _, err := LoadMasterKey("", t.TempDir()+"/missing"); err == nil {
+		t.Error("LoadMasterKey() with a missing file succeeded")
+	}
+}
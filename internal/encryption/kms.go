@@ -0,0 +1,388 @@
+package encryption
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors returned by key providers
+var (
+	// ErrKeyNotFound is returned for a key ID the provider does not hold
+	ErrKeyNotFound = errors.New("kms key not found")
+	// ErrKeyExists is returned when creating a key whose ID is taken
+	ErrKeyExists = errors.New("kms key already exists")
+	// ErrKeyDisabled is returned when wrapping or unwrapping under a
+	// disabled key
+	ErrKeyDisabled = errors.New("kms key is disabled")
+	// ErrInvalidKeyID is returned for a key ID that is not 1 to 64
+	// letters, digits, '-' or '_'
+	ErrInvalidKeyID = errors.New("kms key id is not valid")
+)
+
+// keyARNPrefix starts the ARN of a key, as accepted in requests and
+// matched by IAM policies
+const keyARNPrefix = "arn:aws:kms:::key/"
+
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// KeyARN returns the ARN IAM policies use to name key id
+func KeyARN(id string) string {
+	return keyARNPrefix + id
+}
+
+// ParseKeyID returns the key ID named by a bare ID or a key ARN
+func ParseKeyID(s string) (string, error) {
+	id := s
+	if strings.HasPrefix(s, "arn:") {
+		i := strings.LastIndex(s, ":key/")
+		if i < 0 {
+			return "", fmt.Errorf("%w: %s", ErrInvalidKeyID, s)
+		}
+		id = s[i+len(":key/"):]
+	}
+	if !keyIDPattern.MatchString(id) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidKeyID, s)
+	}
+	return id, nil
+}
+
+// KeyInfo describes a named key. Key material never leaves the provider.
+type KeyInfo struct {
+	ID          string `json:"id"`
+	ARN         string `json:"arn"`
+	Description string `json:"description,omitempty"`
+	Enabled     bool   `json:"enabled"`
+	// Version counts rotations; data keys are wrapped under the latest
+	// version and stay unwrappable under earlier ones
+	Version   int   `json:"version"`
+	CreatedAt int64 `json:"createdAt"`
+	RotatedAt int64 `json:"rotatedAt,omitempty"`
+}
+
+// KeyProvider holds named key encryption keys and wraps data keys under
+// them, SSE-KMS style. Wrapped keys are opaque strings for storage in
+// object metadata.
+type KeyProvider interface {
+	// CreateKey creates an enabled key
+	CreateKey(ctx context.Context, id, description string) (*KeyInfo, error)
+	// DescribeKey returns a key's state
+	DescribeKey(ctx context.Context, id string) (*KeyInfo, error)
+	// ListKeys returns every key, ordered by ID
+	ListKeys(ctx context.Context) ([]KeyInfo, error)
+	// EnableKey and DisableKey switch whether a key can be used
+	EnableKey(ctx context.Context, id string) error
+	DisableKey(ctx context.Context, id string) error
+	// RotateKey adds a key version that wraps data keys from now on
+	RotateKey(ctx context.Context, id string) (*KeyInfo, error)
+	// WrapKey encrypts a data key under the latest version of a key
+	WrapKey(ctx context.Context, id string, dataKey []byte) (string, error)
+	// UnwrapKey decrypts a data key wrapped under any version of a key
+	UnwrapKey(ctx context.Context, id, wrapped string) ([]byte, error)
+	// RewrapKey re-encrypts a wrapped data key under the latest version
+	// of its key. It reports false, returning wrapped unchanged, when the
+	// data key already is.
+	RewrapKey(ctx context.Context, id, wrapped string) (string, bool, error)
+}
+
+// OpenKeyProvider opens the key provider named by provider: "local" for a
+// LocalKeyring at keyringFile sealed by masterKey, or "http" for an
+// HTTPKeyProvider at endpoint. It returns nil when provider is empty.
+func OpenKeyProvider(provider, keyringFile, endpoint, token string, masterKey []byte) (KeyProvider, error) {
+	switch provider {
+	case "":
+		return nil, nil
+	case "local":
+		if masterKey == nil {
+			return nil, errors.New("the local kms provider requires a master key")
+		}
+		return OpenLocalKeyring(keyringFile, masterKey)
+	case "http":
+		if endpoint == "" {
+			return nil, errors.New("the http kms provider requires an endpoint")
+		}
+		return NewHTTPKeyProvider(endpoint, token), nil
+	default:
+		return nil, fmt.Errorf("unknown kms provider %q", provider)
+	}
+}
+
+// localKey is a key of a local keyring, holding every version's material
+type localKey struct {
+	Description string   `json:"description,omitempty"`
+	Enabled     bool     `json:"enabled"`
+	Versions    [][]byte `json:"versions"`
+	CreatedAt   int64    `json:"createdAt"`
+	RotatedAt   int64    `json:"rotatedAt,omitempty"`
+}
+
+func (k *localKey) info(id string) *KeyInfo {
+	return &KeyInfo{
+		ID:          id,
+		ARN:         KeyARN(id),
+		Description: k.Description,
+		Enabled:     k.Enabled,
+		Version:     len(k.Versions),
+		CreatedAt:   k.CreatedAt,
+		RotatedAt:   k.RotatedAt,
+	}
+}
+
+// LocalKeyring is a KeyProvider keeping its keys in a file encrypted
+// under the master key. Every change is written to the file before it
+// takes effect.
+type LocalKeyring struct {
+	path   string
+	master []byte
+
+	mu   sync.RWMutex
+	keys map[string]*localKey
+}
+
+// OpenLocalKeyring opens the keyring stored at path, creating an empty one
+// if the file does not exist
+func OpenLocalKeyring(path string, masterKey []byte) (*LocalKeyring, error) {
+	if len(masterKey) != KeySize {
+		return nil, fmt.Errorf("keyring requires a %d-byte master key", KeySize)
+	}
+	k := &LocalKeyring{path: path, master: masterKey, keys: make(map[string]*localKey)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return k, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring: %w", err)
+	}
+	plain, err := Decrypt(masterKey, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt keyring, is the master key right? %w", err)
+	}
+	if err := json.Unmarshal(plain, &k.keys); err != nil {
+		return nil, fmt.Errorf("failed to parse keyring: %w", err)
+	}
+	return k, nil
+}
+
+// save writes the keyring to its file, replacing it atomically. It is
+// called with mu held for writing.
+func (k *LocalKeyring) save() error {
+	plain, err := json.Marshal(k.keys)
+	if err != nil {
+		return err
+	}
+	sealed, err := Encrypt(k.master, plain)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(k.path), 0700); err != nil {
+		return fmt.Errorf("failed to create keyring directory: %w", err)
+	}
+	tmp := k.path + ".tmp"
+	if err := os.WriteFile(tmp, sealed, 0600); err != nil {
+		return fmt.Errorf("failed to write keyring: %w", err)
+	}
+	if err := os.Rename(tmp, k.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write keyring: %w", err)
+	}
+	return nil
+}
+
+// update applies fn to key id and saves the keyring, undoing the change
+// if the save fails
+func (k *LocalKeyring) update(id string, fn func(key *localKey) error) (*KeyInfo, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, id)
+	}
+	saved := *key
+	if err := fn(key); err != nil {
+		return nil, err
+	}
+	if err := k.save(); err != nil {
+		*key = saved
+		return nil, err
+	}
+	return key.info(id), nil
+}
+
+// CreateKey creates an enabled key with random material
+func (k *LocalKeyring) CreateKey(ctx context.Context, id, description string) (*KeyInfo, error) {
+	if !keyIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidKeyID, id)
+	}
+	material, err := NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[id]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyExists, id)
+	}
+	key := &localKey{
+		Description: description,
+		Enabled:     true,
+		Versions:    [][]byte{material},
+		CreatedAt:   time.Now().Unix(),
+	}
+	k.keys[id] = key
+	if err := k.save(); err != nil {
+		delete(k.keys, id)
+		return nil, err
+	}
+	return key.info(id), nil
+}
+
+// DescribeKey returns a key's state
+func (k *LocalKeyring) DescribeKey(ctx context.Context, id string) (*KeyInfo, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, id)
+	}
+	return key.info(id), nil
+}
+
+// ListKeys returns every key, ordered by ID
+func (k *LocalKeyring) ListKeys(ctx context.Context) ([]KeyInfo, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	keys := make([]KeyInfo, 0, len(k.keys))
+	for id, key := range k.keys {
+		keys = append(keys, *key.info(id))
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+// EnableKey allows a key to be used again
+func (k *LocalKeyring) EnableKey(ctx context.Context, id string) error {
+	_, err := k.update(id, func(key *localKey) error {
+		key.Enabled = true
+		return nil
+	})
+	return err
+}
+
+// DisableKey stops a key wrapping and unwrapping data keys, which makes
+// the objects sealed under it unreadable until it is enabled again
+func (k *LocalKeyring) DisableKey(ctx context.Context, id string) error {
+	_, err := k.update(id, func(key *localKey) error {
+		key.Enabled = false
+		return nil
+	})
+	return err
+}
+
+// RotateKey adds a key version with new random material
+func (k *LocalKeyring) RotateKey(ctx context.Context, id string) (*KeyInfo, error) {
+	material, err := NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+	return k.update(id, func(key *localKey) error {
+		key.Versions = append(key.Versions, material)
+		key.RotatedAt = time.Now().Unix()
+		return nil
+	})
+}
+
+// usableKey returns enabled key id. It is called with mu held.
+func (k *LocalKeyring) usableKey(id string) (*localKey, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, id)
+	}
+	if !key.Enabled {
+		return nil, fmt.Errorf("%w: %s", ErrKeyDisabled, id)
+	}
+	return key, nil
+}
+
+// WrapKey encrypts a data key under the latest version of key id
+func (k *LocalKeyring) WrapKey(ctx context.Context, id string, dataKey []byte) (string, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, err := k.usableKey(id)
+	if err != nil {
+		return "", err
+	}
+	return wrapLocal(key, dataKey)
+}
+
+// UnwrapKey decrypts a data key wrapped under any version of key id
+func (k *LocalKeyring) UnwrapKey(ctx context.Context, id, wrapped string) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, err := k.usableKey(id)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, _, err := unwrapLocal(key, wrapped)
+	return dataKey, err
+}
+
+// RewrapKey re-encrypts a data key under the latest version of key id
+func (k *LocalKeyring) RewrapKey(ctx context.Context, id, wrapped string) (string, bool, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, err := k.usableKey(id)
+	if err != nil {
+		return "", false, err
+	}
+	dataKey, version, err := unwrapLocal(key, wrapped)
+	if err != nil {
+		return "", false, err
+	}
+	if version == len(key.Versions) {
+		return wrapped, false, nil
+	}
+	rewrapped, err := wrapLocal(key, dataKey)
+	if err != nil {
+		return "", false, err
+	}
+	return rewrapped, true, nil
+}
+
+// wrapLocal seals a data key under the latest version of key, prefixed
+// with the version number: "<version>:<base64 sealed key>"
+func wrapLocal(key *localKey, dataKey []byte) (string, error) {
+	version := len(key.Versions)
+	sealed, err := SealKey(key.Versions[version-1], dataKey)
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(version) + ":" + sealed, nil
+}
+
+// unwrapLocal opens a data key sealed by wrapLocal, returning the key
+// version that sealed it
+func unwrapLocal(key *localKey, wrapped string) ([]byte, int, error) {
+	v, sealed, ok := strings.Cut(wrapped, ":")
+	version, err := strconv.Atoi(v)
+	if !ok || err != nil || version < 1 || version > len(key.Versions) {
+		return nil, 0, errors.New("wrapped key is malformed")
+	}
+	dataKey, err := OpenKey(key.Versions[version-1], sealed)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return dataKey, version, nil
+}
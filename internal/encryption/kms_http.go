@@ -0,0 +1,336 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The HTTP KMS protocol is JSON over HTTP, authenticated by an optional
+// bearer token:
+//
+//	GET  /keys                 list keys: {"keys": [KeyInfo...]}
+//	POST /keys                 create a key: {"id", "description"} -> KeyInfo
+//	GET  /keys/{id}            describe a key -> KeyInfo
+//	POST /keys/{id}/enable     enable a key
+//	POST /keys/{id}/disable    disable a key
+//	POST /keys/{id}/rotate     rotate a key -> KeyInfo
+//	POST /keys/{id}/wrap       {"plaintext"} -> {"wrapped"}
+//	POST /keys/{id}/unwrap     {"wrapped"} -> {"plaintext"}
+//	POST /keys/{id}/rewrap     {"wrapped"} -> {"wrapped", "rewrapped"}
+//
+// Plaintext data keys are base64 in JSON. Failures answer with a non-2xx
+// status and {"error": code, "message"}, where code is one of the codes
+// below.
+
+// Error codes of the HTTP KMS protocol
+const (
+	kmsErrNotFound     = "NotFound"
+	kmsErrExists       = "AlreadyExists"
+	kmsErrDisabled     = "Disabled"
+	kmsErrInvalidKeyID = "InvalidKeyId"
+	kmsErrBadRequest   = "BadRequest"
+	kmsErrUnauthorized = "Unauthorized"
+	kmsErrInternal     = "InternalError"
+)
+
+// kmsErrors maps protocol error codes to provider errors and statuses
+var kmsErrors = []struct {
+	code   string
+	err    error
+	status int
+}{
+	{kmsErrNotFound, ErrKeyNotFound, http.StatusNotFound},
+	{kmsErrExists, ErrKeyExists, http.StatusConflict},
+	{kmsErrDisabled, ErrKeyDisabled, http.StatusConflict},
+	{kmsErrInvalidKeyID, ErrInvalidKeyID, http.StatusBadRequest},
+}
+
+type kmsErrorBody struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+type kmsCreateRequest struct {
+	ID          string `json:"id"`
+	Description string `json:"description,omitempty"`
+}
+
+type kmsKeyList struct {
+	Keys []KeyInfo `json:"keys"`
+}
+
+type kmsWrapMessage struct {
+	Plaintext []byte `json:"plaintext,omitempty"`
+	Wrapped   string `json:"wrapped,omitempty"`
+	Rewrapped bool   `json:"rewrapped,omitempty"`
+}
+
+// HTTPKeyProvider is a KeyProvider backed by an external KMS speaking the
+// HTTP KMS protocol
+type HTTPKeyProvider struct {
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+// NewHTTPKeyProvider creates a provider for the KMS at endpoint. A non-empty
+// token is sent as a bearer token.
+func NewHTTPKeyProvider(endpoint, token string) *HTTPKeyProvider {
+	return &HTTPKeyProvider{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		token:    token,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// call sends a request to the KMS and decodes its response into out,
+// unless out is nil
+func (p *HTTPKeyProvider) call(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.endpoint+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("kms request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		var e kmsErrorBody
+		json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&e)
+		for _, m := range kmsErrors {
+			if m.code == e.Error {
+				return fmt.Errorf("%w: %s", m.err, e.Message)
+			}
+		}
+		return fmt.Errorf("kms returned %s: %s %s", resp.Status, e.Error, e.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode kms response: %w", err)
+	}
+	return nil
+}
+
+func keyPath(id string, action string) string {
+	path := "/keys/" + url.PathEscape(id)
+	if action != "" {
+		path += "/" + action
+	}
+	return path
+}
+
+// CreateKey creates an enabled key
+func (p *HTTPKeyProvider) CreateKey(ctx context.Context, id, description string) (*KeyInfo, error) {
+	var info KeyInfo
+	if err := p.call(ctx, http.MethodPost, "/keys", kmsCreateRequest{ID: id, Description: description}, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// DescribeKey returns a key's state
+func (p *HTTPKeyProvider) DescribeKey(ctx context.Context, id string) (*KeyInfo, error) {
+	var info KeyInfo
+	if err := p.call(ctx, http.MethodGet, keyPath(id, ""), nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// ListKeys returns every key
+func (p *HTTPKeyProvider) ListKeys(ctx context.Context) ([]KeyInfo, error) {
+	var list kmsKeyList
+	if err := p.call(ctx, http.MethodGet, "/keys", nil, &list); err != nil {
+		return nil, err
+	}
+	return list.Keys, nil
+}
+
+// EnableKey allows a key to be used again
+func (p *HTTPKeyProvider) EnableKey(ctx context.Context, id string) error {
+	return p.call(ctx, http.MethodPost, keyPath(id, "enable"), nil, nil)
+}
+
+// DisableKey stops a key being used
+func (p *HTTPKeyProvider) DisableKey(ctx context.Context, id string) error {
+	return p.call(ctx, http.MethodPost, keyPath(id, "disable"), nil, nil)
+}
+
+// RotateKey adds a key version
+func (p *HTTPKeyProvider) RotateKey(ctx context.Context, id string) (*KeyInfo, error) {
+	var info KeyInfo
+	if err := p.call(ctx, http.MethodPost, keyPath(id, "rotate"), nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// WrapKey encrypts a data key under the latest version of a key
+func (p *HTTPKeyProvider) WrapKey(ctx context.Context, id string, dataKey []byte) (string, error) {
+	var out kmsWrapMessage
+	if err := p.call(ctx, http.MethodPost, keyPath(id, "wrap"), kmsWrapMessage{Plaintext: dataKey}, &out); err != nil {
+		return "", err
+	}
+	return out.Wrapped, nil
+}
+
+// UnwrapKey decrypts a wrapped data key
+func (p *HTTPKeyProvider) UnwrapKey(ctx context.Context, id, wrapped string) ([]byte, error) {
+	var out kmsWrapMessage
+	if err := p.call(ctx, http.MethodPost, keyPath(id, "unwrap"), kmsWrapMessage{Wrapped: wrapped}, &out); err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
+
+// RewrapKey re-encrypts a wrapped data key under the latest key version
+func (p *HTTPKeyProvider) RewrapKey(ctx context.Context, id, wrapped string) (string, bool, error) {
+	var out kmsWrapMessage
+	if err := p.call(ctx, http.MethodPost, keyPath(id, "rewrap"), kmsWrapMessage{Wrapped: wrapped}, &out); err != nil {
+		return "", false, err
+	}
+	return out.Wrapped, out.Rewrapped, nil
+}
+
+// kmsHandler serves the HTTP KMS protocol from a KeyProvider
+type kmsHandler struct {
+	provider KeyProvider
+	token    string
+}
+
+// NewKMSHandler returns a handler serving the HTTP KMS protocol from
+// provider, typically a LocalKeyring standing in for an external KMS in
+// development and tests. A non-empty token is required as a bearer token.
+func NewKMSHandler(provider KeyProvider, token string) http.Handler {
+	return &kmsHandler{provider: provider, token: token}
+}
+
+// ServeHTTP routes a KMS request
+func (h *kmsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if h.token != "" {
+		got := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(h.token)) != 1 {
+			writeKMSError(w, http.StatusUnauthorized, kmsErrUnauthorized, "missing or invalid token")
+			return
+		}
+	}
+
+	path := strings.Trim(req.URL.Path, "/")
+	parts := strings.Split(path, "/")
+	if parts[0] != "keys" || len(parts) > 3 {
+		writeKMSError(w, http.StatusNotFound, kmsErrBadRequest, "unknown path")
+		return
+	}
+	ctx := req.Context()
+
+	switch {
+	case len(parts) == 1 && req.Method == http.MethodGet:
+		keys, err := h.provider.ListKeys(ctx)
+		h.respond(w, kmsKeyList{Keys: keys}, err)
+	case len(parts) == 1 && req.Method == http.MethodPost:
+		var in kmsCreateRequest
+		if !decodeKMSRequest(w, req, &in) {
+			return
+		}
+		info, err := h.provider.CreateKey(ctx, in.ID, in.Description)
+		h.respond(w, info, err)
+	case len(parts) == 2 && req.Method == http.MethodGet:
+		info, err := h.provider.DescribeKey(ctx, parts[1])
+		h.respond(w, info, err)
+	case len(parts) == 3 && req.Method == http.MethodPost:
+		h.serveKeyAction(w, req, parts[1], parts[2])
+	default:
+		writeKMSError(w, http.StatusMethodNotAllowed, kmsErrBadRequest, "method not allowed")
+	}
+}
+
+// serveKeyAction serves a POST /keys/{id}/{action} request
+func (h *kmsHandler) serveKeyAction(w http.ResponseWriter, req *http.Request, id, action string) {
+	ctx := req.Context()
+	switch action {
+	case "enable":
+		h.respond(w, struct{}{}, h.provider.EnableKey(ctx, id))
+	case "disable":
+		h.respond(w, struct{}{}, h.provider.DisableKey(ctx, id))
+	case "rotate":
+		info, err := h.provider.RotateKey(ctx, id)
+		h.respond(w, info, err)
+	case "wrap", "unwrap", "rewrap":
+		var in kmsWrapMessage
+		if !decodeKMSRequest(w, req, &in) {
+			return
+		}
+		var out kmsWrapMessage
+		var err error
+		switch action {
+		case "wrap":
+			out.Wrapped, err = h.provider.WrapKey(ctx, id, in.Plaintext)
+		case "unwrap":
+			out.Plaintext, err = h.provider.UnwrapKey(ctx, id, in.Wrapped)
+		default:
+			out.Wrapped, out.Rewrapped, err = h.provider.RewrapKey(ctx, id, in.Wrapped)
+		}
+		h.respond(w, out, err)
+	default:
+		writeKMSError(w, http.StatusNotFound, kmsErrBadRequest, "unknown action "+action)
+	}
+}
+
+// respond writes out as JSON, or the protocol error for err
+func (h *kmsHandler) respond(w http.ResponseWriter, out interface{}, err error) {
+	if err != nil {
+		for _, m := range kmsErrors {
+			if errors.Is(err, m.err) {
+				writeKMSError(w, m.status, m.code, err.Error())
+				return
+			}
+		}
+		writeKMSError(w, http.StatusInternalServerError, kmsErrInternal, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+func decodeKMSRequest(w http.ResponseWriter, req *http.Request, in interface{}) bool {
+	if err := json.NewDecoder(io.LimitReader(req.Body, 64*1024)).Decode(in); err != nil {
+		writeKMSError(w, http.StatusBadRequest, kmsErrBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func writeKMSError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(kmsErrorBody{Error: code, Message: message})
+}
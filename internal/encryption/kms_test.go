@@ -0,0 +1,156 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseKeyID(t *testing.T) {
+	tests := []struct {
+		in, want string
+		valid    bool
+	}{
+		{"app-key", "app-key", true},
+		{"arn:aws:kms:::key/app-key", "app-key", true},
+		{"arn:aws:kms:us-east-1:123456789012:key/app_key", "app_key", true},
+		{"", "", false},
+		{"bad/key", "", false},
+		{"arn:aws:kms:::alias/app-key", "", false},
+	}
+	for _, tt := range tests {
+		got, err := ParseKeyID(tt.in)
+		if (err == nil) != tt.valid || got != tt.want {
+			t.Errorf("ParseKeyID(%q) = %q, %v, expected %q valid=%v", tt.in, got, err, tt.want, tt.valid)
+		}
+	}
+}
+
+// testKeyProvider exercises a KeyProvider holding no keys
+func testKeyProvider(t *testing.T, p KeyProvider) {
+	ctx := context.Background()
+
+	info, err := p.CreateKey(ctx, "app", "application data")
+	if err != nil {
+		t.Fatalf("CreateKey() error: %v", err)
+	}
+	if info.ID != "app" || info.ARN != KeyARN("app") || !info.Enabled || info.Version != 1 || info.Description != "application data" {
+		t.Errorf("CreateKey() = %+v", info)
+	}
+	if _, err := p.CreateKey(ctx, "app", ""); !errors.Is(err, ErrKeyExists) {
+		t.Errorf("CreateKey() duplicate error = %v, expected ErrKeyExists", err)
+	}
+	if _, err := p.CreateKey(ctx, "bad/id", ""); !errors.Is(err, ErrInvalidKeyID) {
+		t.Errorf("CreateKey() bad id error = %v, expected ErrInvalidKeyID", err)
+	}
+
+	dataKey, _ := NewDataKey()
+	wrapped, err := p.WrapKey(ctx, "app", dataKey)
+	if err != nil {
+		t.Fatalf("WrapKey() error: %v", err)
+	}
+	if got, err := p.UnwrapKey(ctx, "app", wrapped); err != nil || !bytes.Equal(got, dataKey) {
+		t.Errorf("UnwrapKey() = %x, %v, expected %x", got, err, dataKey)
+	}
+	if _, err := p.WrapKey(ctx, "missing", dataKey); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("WrapKey() unknown key error = %v, expected ErrKeyNotFound", err)
+	}
+	if same, rewrapped, err := p.RewrapKey(ctx, "app", wrapped); err != nil || rewrapped || same != wrapped {
+		t.Errorf("RewrapKey() before rotation = %v, %v, expected unchanged", rewrapped, err)
+	}
+
+	info, err = p.RotateKey(ctx, "app")
+	if err != nil || info.Version != 2 || info.RotatedAt == 0 {
+		t.Fatalf("RotateKey() = %+v, %v", info, err)
+	}
+	if got, err := p.UnwrapKey(ctx, "app", wrapped); err != nil || !bytes.Equal(got, dataKey) {
+		t.Errorf("UnwrapKey() of a key wrapped before rotation = %x, %v", got, err)
+	}
+	newer, rewrapped, err := p.RewrapKey(ctx, "app", wrapped)
+	if err != nil || !rewrapped || newer == wrapped {
+		t.Fatalf("RewrapKey() after rotation = %v, %v, expected a new wrapping", rewrapped, err)
+	}
+	if got, err := p.UnwrapKey(ctx, "app", newer); err != nil || !bytes.Equal(got, dataKey) {
+		t.Errorf("UnwrapKey() of rewrapped key = %x, %v", got, err)
+	}
+
+	if err := p.DisableKey(ctx, "app"); err != nil {
+		t.Fatalf("DisableKey() error: %v", err)
+	}
+	if _, err := p.UnwrapKey(ctx, "app", newer); !errors.Is(err, ErrKeyDisabled) {
+		t.Errorf("UnwrapKey() with disabled key error = %v, expected ErrKeyDisabled", err)
+	}
+	if _, err := p.WrapKey(ctx, "app", dataKey); !errors.Is(err, ErrKeyDisabled) {
+		t.Errorf("WrapKey() with disabled key error = %v, expected ErrKeyDisabled", err)
+	}
+	if info, err := p.DescribeKey(ctx, "app"); err != nil || info.Enabled {
+		t.Errorf("DescribeKey() after disable = %+v, %v", info, err)
+	}
+	if err := p.EnableKey(ctx, "app"); err != nil {
+		t.Fatalf("EnableKey() error: %v", err)
+	}
+	if _, err := p.UnwrapKey(ctx, "app", newer); err != nil {
+		t.Errorf("UnwrapKey() after enable error: %v", err)
+	}
+
+	p.CreateKey(ctx, "another", "")
+	keys, err := p.ListKeys(ctx)
+	if err != nil || len(keys) != 2 || keys[0].ID != "another" || keys[1].ID != "app" {
+		t.Errorf("ListKeys() = %+v, %v", keys, err)
+	}
+	if _, err := p.DescribeKey(ctx, "missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("DescribeKey() unknown key error = %v, expected ErrKeyNotFound", err)
+	}
+}
+
+func TestLocalKeyring(t *testing.T) {
+	master, _ := NewDataKey()
+	path := filepath.Join(t.TempDir(), "kms", "keyring")
+	k, err := OpenLocalKeyring(path, master)
+	if err != nil {
+		t.Fatalf("OpenLocalKeyring() error: %v", err)
+	}
+	testKeyProvider(t, k)
+
+	// Keys and their versions survive reopening
+	ctx := context.Background()
+	dataKey, _ := NewDataKey()
+	wrapped, _ := k.WrapKey(ctx, "app", dataKey)
+	reopened, err := OpenLocalKeyring(path, master)
+	if err != nil {
+		t.Fatalf("OpenLocalKeyring() reopen error: %v", err)
+	}
+	if info, err := reopened.DescribeKey(ctx, "app"); err != nil || info.Version != 2 {
+		t.Errorf("DescribeKey() after reopen = %+v, %v", info, err)
+	}
+	if got, err := reopened.UnwrapKey(ctx, "app", wrapped); err != nil || !bytes.Equal(got, dataKey) {
+		t.Errorf("UnwrapKey() after reopen = %x, %v", got, err)
+	}
+
+	other, _ := NewDataKey()
+	if _, err := OpenLocalKeyring(path, other); err == nil {
+		t.Error("OpenLocalKeyring() with another master key succeeded")
+	}
+	if _, err := reopened.UnwrapKey(ctx, "app", "9:"+wrapped); err == nil {
+		t.Error("UnwrapKey() of a malformed key succeeded")
+	}
+}
+
+func TestHTTPKeyProvider(t *testing.T) {
+	master, _ := NewDataKey()
+	k, err := OpenLocalKeyring(filepath.Join(t.TempDir(), "keyring"), master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(NewKMSHandler(k, "secret"))
+	defer server.Close()
+
+	testKeyProvider(t, NewHTTPKeyProvider(server.URL+"/", "secret"))
+
+	if _, err := NewHTTPKeyProvider(server.URL, "wrong").ListKeys(context.Background()); err == nil {
+		t.Error("ListKeys() with a wrong token succeeded")
+	}
+}
@@ -0,0 +1,213 @@
+package encryption
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Object data is sealed as a sequence of chunks of ChunkSize plaintext
+// bytes, each encrypted and authenticated on its own with AES-256-GCM, so a
+// range read only opens the chunks it overlaps. Chunk i uses i as its
+// nonce, which is safe because a data key only ever seals one stream. The
+// last chunk is sealed with different additional data, so a stream cut
+// short at a chunk boundary fails to open. An empty stream is a single
+// empty last chunk.
+
+// ChunkSize is the plaintext size of every chunk but the last
+const ChunkSize = 64 * 1024
+
+// chunkTagSize is the GCM tag appended to every sealed chunk
+const chunkTagSize = 16
+
+// sealedChunkSize is the size of a sealed full chunk
+const sealedChunkSize = ChunkSize + chunkTagSize
+
+// ErrCorruptData is returned when sealed data fails authentication
+var ErrCorruptData = errors.New("encrypted data failed authentication")
+
+// lastChunk is the additional data of the last chunk of a stream
+var lastChunk = []byte{1}
+
+// SealedSize returns the size of size bytes of plaintext once sealed
+func SealedSize(size int64) int64 {
+	return size + chunkCount(size)*chunkTagSize
+}
+
+// SealedRange returns the range [start, end) of the sealed form of a
+// size-byte stream holding the chunks that cover plaintext bytes
+// [off, end)
+func SealedRange(size, off, end int64) (int64, int64) {
+	first := off / ChunkSize
+	last := first
+	if end > off {
+		last = (end - 1) / ChunkSize
+	}
+	return first * sealedChunkSize, min((last+1)*sealedChunkSize, SealedSize(size))
+}
+
+func chunkCount(size int64) int64 {
+	if size == 0 {
+		return 1
+	}
+	return (size + ChunkSize - 1) / ChunkSize
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := newCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return newGCM(block)
+}
+
+func chunkNonce(aead cipher.AEAD, index int64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], uint64(index))
+	return nonce
+}
+
+func chunkData(last bool) []byte {
+	if last {
+		return lastChunk
+	}
+	return nil
+}
+
+// encryptReader seals the data of an underlying reader chunk by chunk
+type encryptReader struct {
+	src   *bufio.Reader
+	aead  cipher.AEAD
+	index int64
+	plain []byte
+	buf   []byte
+	out   []byte // sealed bytes not yet returned
+	done  bool
+}
+
+// NewEncryptReader returns a reader of the sealed form of r's data under
+// key. Errors from r are returned as they occur, so nothing is sealed past
+// a failed read.
+func NewEncryptReader(r io.Reader, key []byte) (io.Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptReader{
+		src:   bufio.NewReaderSize(r, ChunkSize),
+		aead:  aead,
+		plain: make([]byte, ChunkSize),
+		buf:   make([]byte, 0, sealedChunkSize),
+	}, nil
+}
+
+func (e *encryptReader) Read(p []byte) (int, error) {
+	if len(e.out) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+		if err := e.sealNext(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, e.out)
+	e.out = e.out[n:]
+	return n, nil
+}
+
+func (e *encryptReader) sealNext() error {
+	n, err := io.ReadFull(e.src, e.plain)
+	switch err {
+	case nil:
+		// A full chunk is the last one only when nothing follows it
+		if _, err := e.src.Peek(1); err == io.EOF {
+			e.done = true
+		} else if err != nil {
+			return err
+		}
+	case io.EOF, io.ErrUnexpectedEOF:
+		e.done = true
+	default:
+		return err
+	}
+	e.out = e.aead.Seal(e.buf[:0], chunkNonce(e.aead, e.index), e.plain[:n], chunkData(e.done))
+	e.index++
+	return nil
+}
+
+// decryptReader opens sealed chunks and returns a range of their plaintext
+type decryptReader struct {
+	src       io.Reader
+	aead      cipher.AEAD
+	index     int64 // next chunk to open
+	last      int64 // last chunk of the stream
+	skip      int64 // plaintext to drop from the next chunk
+	remaining int64 // plaintext bytes left to return
+	buf       []byte
+	out       []byte // opened bytes not yet returned
+}
+
+// NewDecryptReader returns a reader of plaintext bytes [off, end) of a
+// size-byte stream sealed under key. r must read the sealed stream from the
+// start of the range SealedRange returns for the same bytes.
+func NewDecryptReader(r io.Reader, key []byte, size, off, end int64) (io.Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		src:       r,
+		aead:      aead,
+		index:     off / ChunkSize,
+		last:      chunkCount(size) - 1,
+		skip:      off % ChunkSize,
+		remaining: end - off,
+		buf:       make([]byte, sealedChunkSize),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	if d.remaining <= 0 {
+		return 0, io.EOF
+	}
+	for len(d.out) == 0 {
+		if err := d.openNext(); err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > d.remaining {
+		p = p[:d.remaining]
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	d.remaining -= int64(n)
+	return n, nil
+}
+
+func (d *decryptReader) openNext() error {
+	if d.index > d.last {
+		return io.ErrUnexpectedEOF
+	}
+	n, err := io.ReadFull(d.src, d.buf)
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF && d.index != d.last:
+		return io.ErrUnexpectedEOF
+	case err != nil && err != io.ErrUnexpectedEOF:
+		return err
+	}
+
+	plain, err := d.aead.Open(d.buf[:0], chunkNonce(d.aead, d.index), d.buf[:n], chunkData(d.index == d.last))
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d", ErrCorruptData, d.index)
+	}
+	d.index++
+	if d.skip > int64(len(plain)) {
+		return io.ErrUnexpectedEOF
+	}
+	d.out = plain[d.skip:]
+	d.skip = 0
+	return nil
+}
@@ -0,0 +1,109 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func sealBytes(t *testing.T, key, plain []byte) []byte {
+	t.Helper()
+	r, err := NewEncryptReader(bytes.NewReader(plain), key)
+	if err != nil {
+		t.Fatalf("NewEncryptReader() error: %v", err)
+	}
+	sealed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("sealing error: %v", err)
+	}
+	return sealed
+}
+
+func openRange(key, sealed []byte, size, off, end int64) ([]byte, error) {
+	start, stop := SealedRange(size, off, end)
+	r, err := NewDecryptReader(bytes.NewReader(sealed[start:stop]), key, size, off, end)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestStream_RoundTrip(t *testing.T) {
+	key, _ := NewDataKey()
+	for _, size := range []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3*ChunkSize + 17} {
+		plain := bytes.Repeat([]byte("0123456789abcdef"), size/16+1)[:size]
+		sealed := sealBytes(t, key, plain)
+		if int64(len(sealed)) != SealedSize(int64(size)) {
+			t.Errorf("size %d: sealed %d bytes, SealedSize() = %d", size, len(sealed), SealedSize(int64(size)))
+		}
+
+		got, err := openRange(key, sealed, int64(size), 0, int64(size))
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("size %d: round trip = %d bytes, %v", size, len(got), err)
+		}
+	}
+}
+
+func TestStream_Ranges(t *testing.T) {
+	key, _ := NewDataKey()
+	size := int64(3*ChunkSize + 100)
+	plain := make([]byte, size)
+	for i := range plain {
+		plain[i] = byte(i * 7)
+	}
+	sealed := sealBytes(t, key, plain)
+
+	for _, r := range [][2]int64{
+		{0, 1},
+		{5, 10},
+		{ChunkSize - 3, ChunkSize + 3},
+		{ChunkSize, 2 * ChunkSize},
+		{ChunkSize + 1, size},
+		{size - 1, size},
+	} {
+		got, err := openRange(key, sealed, size, r[0], r[1])
+		if err != nil || !bytes.Equal(got, plain[r[0]:r[1]]) {
+			t.Errorf("range %v = %d bytes, %v", r, len(got), err)
+		}
+	}
+}
+
+func TestStream_Tampering(t *testing.T) {
+	key, _ := NewDataKey()
+	plain := bytes.Repeat([]byte("x"), 2*ChunkSize+10)
+	sealed := sealBytes(t, key, plain)
+	size := int64(len(plain))
+
+	flipped := append([]byte(nil), sealed...)
+	flipped[ChunkSize+20] ^= 1
+	if _, err := openRange(key, flipped, size, 0, size); !errors.Is(err, ErrCorruptData) {
+		t.Errorf("flipped bit error = %v, expected %v", err, ErrCorruptData)
+	}
+
+	// Dropping the last chunk leaves a stream whose end is not marked
+	truncated := sealed[:2*sealedChunkSize]
+	r, _ := NewDecryptReader(bytes.NewReader(truncated), key, 2*ChunkSize, 0, 2*ChunkSize)
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrCorruptData) {
+		t.Errorf("truncated stream error = %v, expected %v", err, ErrCorruptData)
+	}
+
+	r, _ = NewDecryptReader(bytes.NewReader(truncated), key, size, 0, size)
+	if _, err := io.ReadAll(r); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("short stream error = %v, expected %v", err, io.ErrUnexpectedEOF)
+	}
+
+	other, _ := NewDataKey()
+	if _, err := openRange(other, sealed, size, 0, size); !errors.Is(err, ErrCorruptData) {
+		t.Errorf("wrong key error = %v, expected %v", err, ErrCorruptData)
+	}
+}
+
+func TestEncryptReader_SourceError(t *testing.T) {
+	key, _ := NewDataKey()
+	want := errors.New("read error")
+	r, _ := NewEncryptReader(io.MultiReader(bytes.NewReader(make([]byte, ChunkSize+5)), &errorReader{err: want}), key)
+	if _, err := io.ReadAll(r); !errors.Is(err, want) {
+		t.Errorf("ReadAll() error = %v, expected %v", err, want)
+	}
+}
@@ -0,0 +1,59 @@
+package engine
+
+import (
+	"encoding/base64"
+	"fmt"
+	"hash"
+
+	"github.com/openendpoint/openendpoint/internal/metadata"
+	"github.com/openendpoint/openendpoint/pkg/checksum"
+)
+
+// checksumAlgorithms are the additional checksums S3 clients may request,
+// keyed by the algorithm name S3 uses
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"CRC32":  checksum.CRC32,
+	"CRC32C": checksum.CRC32C,
+	"SHA1":   checksum.SHA1,
+	"SHA256": checksum.SHA256,
+}
+
+// ValidChecksumAlgorithm reports whether name is a supported additional
+// checksum algorithm
+func ValidChecksumAlgorithm(name string) bool {
+	_, ok := checksumAlgorithms[name]
+	return ok
+}
+
+// newChecksum returns a hash for algorithm, or nil for none
+func newChecksum(algorithm string) (hash.Hash, error) {
+	if algorithm == "" {
+		return nil, nil
+	}
+	newHash, ok := checksumAlgorithms[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidChecksum, algorithm)
+	}
+	return newHash(), nil
+}
+
+// compositeChecksum returns the checksum of a multipart object: the
+// checksum of the concatenated binary part checksums, followed by the
+// number of parts. It returns "" if any part lacks a checksum of algorithm.
+func compositeChecksum(algorithm string, parts []metadata.PartMetadata) (string, error) {
+	h, err := newChecksum(algorithm)
+	if err != nil || h == nil {
+		return "", err
+	}
+	for _, p := range parts {
+		if p.ChecksumAlgorithm != algorithm || p.Checksum == "" {
+			return "", nil
+		}
+		sum, err := base64.StdEncoding.DecodeString(p.Checksum)
+		if err != nil {
+			return "", fmt.Errorf("%w: part %d has a malformed checksum", ErrInvalidPart, p.PartNumber)
+		}
+		h.Write(sum)
+	}
+	return fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(h.Sum(nil)), len(parts)), nil
+}
@@ -0,0 +1,147 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestObjectService_PutObjectChecksum(t *testing.T) {
+	svc := newVersioningTestService(t)
+	ctx := context.Background()
+	_ = svc.CreateBucket(ctx, "bucket")
+
+	put := func(key, algorithm, want string) (*ObjectResult, error) {
+		return svc.PutObject(ctx, "bucket", key, strings.NewReader("123456789"), PutObjectOptions{
+			Size:              9,
+			ChecksumAlgorithm: algorithm,
+			Checksum:          want,
+		})
+	}
+
+	result, err := put("computed", "CRC32", "")
+	if err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	if result.ChecksumAlgorithm != "CRC32" || result.Checksum != "y/Q5Jg==" {
+		t.Errorf("PutObject() checksum = %s %s, want CRC32 y/Q5Jg==", result.ChecksumAlgorithm, result.Checksum)
+	}
+	info, err := svc.HeadObject(ctx, "bucket", "computed", HeadObjectOptions{})
+	if err != nil {
+		t.Fatalf("HeadObject() error = %v", err)
+	}
+	if info.ChecksumAlgorithm != "CRC32" || info.Checksum != "y/Q5Jg==" {
+		t.Errorf("HeadObject() checksum = %s %s", info.ChecksumAlgorithm, info.Checksum)
+	}
+
+	if _, err := put("verified", "CRC32C", "4waSgw=="); err != nil {
+		t.Errorf("PutObject() with matching checksum error = %v", err)
+	}
+
+	if _, err := put("mismatch", "CRC32C", "AAAAAA=="); !errors.Is(err, ErrBadDigest) {
+		t.Errorf("PutObject() with wrong checksum error = %v, want ErrBadDigest", err)
+	}
+	if _, _, err := getString(t, svc, "bucket", "mismatch", ""); err == nil {
+		t.Error("object with a wrong checksum was stored")
+	}
+
+	if _, err := put("unknown", "MD5", ""); !errors.Is(err, ErrInvalidChecksum) {
+		t.Errorf("PutObject() with unknown algorithm error = %v, want ErrInvalidChecksum", err)
+	}
+}
+
+func TestObjectService_CopyObjectChecksum(t *testing.T) {
+	svc := newVersioningTestService(t)
+	ctx := context.Background()
+	_ = svc.CreateBucket(ctx, "bucket")
+
+	if _, err := svc.PutObject(ctx, "bucket", "src", strings.NewReader("123456789"), PutObjectOptions{Size: 9, ChecksumAlgorithm: "CRC32"}); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		algorithm string
+		want      string
+	}{
+		{"KeepsAlgorithm", "", "CRC32"},
+		{"NewAlgorithm", "CRC32C", "CRC32C"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := svc.CopyObject(ctx, "bucket", "src", "bucket", tt.name, CopyObjectOptions{ChecksumAlgorithm: tt.algorithm}); err != nil {
+				t.Fatalf("CopyObject() error = %v", err)
+			}
+			info, err := svc.HeadObject(ctx, "bucket", tt.name, HeadObjectOptions{})
+			if err != nil {
+				t.Fatalf("HeadObject() error = %v", err)
+			}
+			if info.ChecksumAlgorithm != tt.want || info.Checksum == "" {
+				t.Errorf("copy checksum = %s %q, want %s", info.ChecksumAlgorithm, info.Checksum, tt.want)
+			}
+		})
+	}
+}
+
+func TestObjectService_MultipartCompositeChecksum(t *testing.T) {
+	svc := newVersioningTestService(t)
+	svc.minPartSize = 4
+	ctx := context.Background()
+	_ = svc.CreateBucket(ctx, "bucket")
+
+	upload, err := svc.CreateMultipartUpload(ctx, "bucket", "key", PutObjectOptions{ChecksumAlgorithm: "SHA256"})
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload() error = %v", err)
+	}
+
+	var parts []PartInfo
+	composite := sha256.New()
+	for i, data := range []string{"aaaa", "bb"} {
+		sum := sha256.Sum256([]byte(data))
+		composite.Write(sum[:])
+		want := base64.StdEncoding.EncodeToString(sum[:])
+
+		// Parts uploaded without a checksum still get the upload's
+		part, err := svc.UploadPart(ctx, "bucket", "key", upload.UploadID, i+1, strings.NewReader(data), UploadPartOptions{})
+		if err != nil {
+			t.Fatalf("UploadPart(%d) error = %v", i+1, err)
+		}
+		if part.ChecksumAlgorithm != "SHA256" || part.Checksum != want {
+			t.Errorf("UploadPart(%d) checksum = %s %s, want SHA256 %s", i+1, part.ChecksumAlgorithm, part.Checksum, want)
+		}
+		parts = append(parts, PartInfo{PartNumber: i + 1, ETag: part.ETag, Checksum: part.Checksum})
+	}
+
+	if _, err := svc.UploadPart(ctx, "bucket", "key", upload.UploadID, 3, strings.NewReader("c"), UploadPartOptions{ChecksumAlgorithm: "CRC32"}); !errors.Is(err, ErrInvalidChecksum) {
+		t.Errorf("UploadPart() with another algorithm error = %v, want ErrInvalidChecksum", err)
+	}
+	if _, err := svc.UploadPart(ctx, "bucket", "key", upload.UploadID, 3, strings.NewReader("c"), UploadPartOptions{ChecksumAlgorithm: "SHA256", Checksum: parts[0].Checksum}); !errors.Is(err, ErrBadDigest) {
+		t.Errorf("UploadPart() with wrong checksum error = %v, want ErrBadDigest", err)
+	}
+
+	wrong := append([]PartInfo(nil), parts...)
+	wrong[1].Checksum = parts[0].Checksum
+	if _, err := svc.CompleteMultipartUpload(ctx, "bucket", "key", upload.UploadID, wrong); !errors.Is(err, ErrInvalidPart) {
+		t.Errorf("CompleteMultipartUpload() with wrong part checksum error = %v, want ErrInvalidPart", err)
+	}
+
+	result, err := svc.CompleteMultipartUpload(ctx, "bucket", "key", upload.UploadID, parts)
+	if err != nil {
+		t.Fatalf("CompleteMultipartUpload() error = %v", err)
+	}
+	want := base64.StdEncoding.EncodeToString(composite.Sum(nil)) + "-2"
+	if result.ChecksumAlgorithm != "SHA256" || result.Checksum != want {
+		t.Errorf("CompleteMultipartUpload() checksum = %s %s, want SHA256 %s", result.ChecksumAlgorithm, result.Checksum, want)
+	}
+
+	attrs, err := svc.GetObjectAttributes(ctx, "bucket", "key", "")
+	if err != nil {
+		t.Fatalf("GetObjectAttributes() error = %v", err)
+	}
+	if attrs.Checksum != want || len(attrs.Parts) != 2 || attrs.Parts[1].Checksum != parts[1].Checksum || attrs.Parts[1].Size != 2 {
+		t.Errorf("GetObjectAttributes() = %+v", attrs)
+	}
+}
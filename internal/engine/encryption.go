@@ -0,0 +1,371 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/openendpoint/openendpoint/internal/encryption"
+	"github.com/openendpoint/openendpoint/internal/metadata"
+	"github.com/openendpoint/openendpoint/internal/storage"
+)
+
+// Server-side encryption algorithms. Data is always sealed with AES-256-GCM;
+// they differ in what seals the data keys.
+const (
+	// SSEAlgorithmAES256 seals data keys with the master key (SSE-S3) or
+	// a customer key (SSE-C)
+	SSEAlgorithmAES256 = "AES256"
+	// SSEAlgorithmKMS seals data keys with a named key of the key
+	// provider (SSE-KMS)
+	SSEAlgorithmKMS = "aws:kms"
+)
+
+// Actions passed to Encryption.AuthorizeKey, named after the IAM actions
+// that grant them
+const (
+	// KMSActionGenerateDataKey seals new data under a key
+	KMSActionGenerateDataKey = "kms:GenerateDataKey"
+	// KMSActionDecrypt reads data sealed under a key
+	KMSActionDecrypt = "kms:Decrypt"
+)
+
+// Encryption asks for server-side encryption of written data, or supplies
+// the customer key of data written with SSE-C
+type Encryption struct {
+	// Algorithm is SSEAlgorithmAES256 to seal the data with the master
+	// key or SSEAlgorithmKMS to seal it with a KMS key; empty applies the
+	// bucket's default encryption, if any
+	Algorithm string
+	// CustomerKey is the 256-bit SSE-C key, which takes the place of the
+	// master key; nil for other requests
+	CustomerKey []byte
+	// KMSKeyID names the KMS key of SSEAlgorithmKMS, by ID or ARN; empty
+	// uses the bucket default's key
+	KMSKeyID string
+	// AuthorizeKey, when set, is asked before a KMS key seals or opens
+	// data for the request, and returns an error wrapping
+	// ErrKeyAccessDenied to refuse. Internal callers leave it nil.
+	AuthorizeKey func(keyID, action string) error
+}
+
+// ServerSideEncryption reports the encryption of stored data
+type ServerSideEncryption struct {
+	Algorithm      string // SSEAlgorithmAES256 or SSEAlgorithmKMS, "" for plaintext
+	CustomerKeyMD5 string // base64 MD5 of the SSE-C key, "" otherwise
+	KMSKeyID       string // KMS key of SSEAlgorithmKMS
+}
+
+// SetMasterKey sets the 256-bit key that seals the data keys of SSE-S3
+// objects. Without one, SSE-S3 requests fail.
+func (s *ObjectService) SetMasterKey(key []byte) {
+	s.masterKey = key
+}
+
+// SetKeyProvider sets the KMS that holds the keys of SSE-KMS objects.
+// Without one, SSE-KMS requests fail.
+func (s *ObjectService) SetKeyProvider(p encryption.KeyProvider) {
+	s.keyProvider = p
+}
+
+// reportedEncryption returns the encryption reported for data sealed as rec
+func reportedEncryption(rec *metadata.ObjectEncryption) ServerSideEncryption {
+	if rec == nil {
+		return ServerSideEncryption{}
+	}
+	return ServerSideEncryption{Algorithm: rec.Algorithm, CustomerKeyMD5: rec.CustomerKeyMD5, KMSKeyID: rec.KMSKeyID}
+}
+
+// resolveEncryption returns the encryption of a write to bucket: SSE-C when
+// the request carries a customer key, otherwise SSE-S3 or SSE-KMS when the
+// request or the bucket's default encryption asks for it. Nil means
+// plaintext.
+func (s *ObjectService) resolveEncryption(ctx context.Context, bucket string, enc Encryption) (*metadata.ObjectEncryption, error) {
+	if enc.CustomerKey != nil {
+		if len(enc.CustomerKey) != encryption.KeySize {
+			return nil, fmt.Errorf("%w: customer key must be %d bytes", ErrInvalidEncryption, encryption.KeySize)
+		}
+		return &metadata.ObjectEncryption{Algorithm: SSEAlgorithmAES256, CustomerKeyMD5: encryption.KeyMD5(enc.CustomerKey)}, nil
+	}
+
+	// A request for SSE-KMS without a key uses the key of an SSE-KMS default
+	algorithm, keyID := enc.Algorithm, enc.KMSKeyID
+	if algorithm == "" || algorithm == SSEAlgorithmKMS && keyID == "" {
+		if config, err := s.metadata.GetBucketEncryption(ctx, bucket); err == nil && config != nil {
+			if algorithm == "" {
+				algorithm = config.Rule.Apply.SSEAlgorithm
+			}
+			if algorithm == config.Rule.Apply.SSEAlgorithm && keyID == "" {
+				keyID = config.Rule.Apply.KMSMasterKeyID
+			}
+		}
+	}
+	if keyID != "" && algorithm != SSEAlgorithmKMS {
+		return nil, fmt.Errorf("%w: a kms key id requires %s", ErrInvalidEncryption, SSEAlgorithmKMS)
+	}
+
+	switch algorithm {
+	case "":
+		return nil, nil
+	case SSEAlgorithmAES256:
+		if s.masterKey == nil {
+			return nil, ErrEncryptionNotConfigured
+		}
+		return &metadata.ObjectEncryption{Algorithm: algorithm}, nil
+	case SSEAlgorithmKMS:
+		if s.keyProvider == nil {
+			return nil, ErrEncryptionNotConfigured
+		}
+		if keyID == "" {
+			return nil, fmt.Errorf("%w: %s requires a kms key id", ErrInvalidEncryption, algorithm)
+		}
+		id, err := encryption.ParseKeyID(keyID)
+		if err != nil {
+			return nil, err
+		}
+		return &metadata.ObjectEncryption{Algorithm: algorithm, KMSKeyID: id}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidEncryption, algorithm)
+	}
+}
+
+// checkDefaultEncryption verifies that a bucket's default encryption can be
+// applied to writes, and replaces a KMS key ARN with the key's ID
+func (s *ObjectService) checkDefaultEncryption(ctx context.Context, apply *metadata.ApplyEncryptionConfiguration) error {
+	switch apply.SSEAlgorithm {
+	case SSEAlgorithmAES256:
+		if s.masterKey == nil {
+			return ErrEncryptionNotConfigured
+		}
+		if apply.KMSMasterKeyID != "" {
+			return fmt.Errorf("%w: a kms key id requires %s", ErrInvalidEncryption, SSEAlgorithmKMS)
+		}
+	case SSEAlgorithmKMS:
+		if s.keyProvider == nil {
+			return ErrEncryptionNotConfigured
+		}
+		if apply.KMSMasterKeyID == "" {
+			return fmt.Errorf("%w: %s requires a kms key id", ErrInvalidEncryption, SSEAlgorithmKMS)
+		}
+		id, err := encryption.ParseKeyID(apply.KMSMasterKeyID)
+		if err != nil {
+			return err
+		}
+		if _, err := s.keyProvider.DescribeKey(ctx, id); err != nil {
+			return err
+		}
+		apply.KMSMasterKeyID = id
+	default:
+		return fmt.Errorf("%w: %s", ErrInvalidEncryption, apply.SSEAlgorithm)
+	}
+	return nil
+}
+
+// checkKMSKey verifies, before any data is sealed as rec, that its KMS key
+// exists, is enabled and may seal data for the request
+func (s *ObjectService) checkKMSKey(ctx context.Context, rec *metadata.ObjectEncryption, enc Encryption) error {
+	if rec == nil || rec.Algorithm != SSEAlgorithmKMS {
+		return nil
+	}
+	if err := authorizeKey(rec, enc, KMSActionGenerateDataKey); err != nil {
+		return err
+	}
+	info, err := s.keyProvider.DescribeKey(ctx, rec.KMSKeyID)
+	if err != nil {
+		return err
+	}
+	if !info.Enabled {
+		return fmt.Errorf("%w: %s", encryption.ErrKeyDisabled, rec.KMSKeyID)
+	}
+	return nil
+}
+
+// authorizeKey asks the request's authorizer whether it may use the KMS
+// key of data sealed as rec for action
+func authorizeKey(rec *metadata.ObjectEncryption, enc Encryption, action string) error {
+	if enc.AuthorizeKey == nil {
+		return nil
+	}
+	return enc.AuthorizeKey(rec.KMSKeyID, action)
+}
+
+// checkCustomerKey verifies that a request carries a customer key exactly
+// when the data sealed as rec was written with one, and that it is the same
+func checkCustomerKey(rec *metadata.ObjectEncryption, enc Encryption) error {
+	switch {
+	case rec == nil || rec.CustomerKeyMD5 == "":
+		if enc.CustomerKey != nil {
+			return fmt.Errorf("%w: the data is not encrypted with a customer key", ErrInvalidEncryption)
+		}
+	case enc.CustomerKey == nil:
+		return ErrCustomerKeyRequired
+	case encryption.KeyMD5(enc.CustomerKey) != rec.CustomerKeyMD5:
+		return ErrCustomerKeyMismatch
+	}
+	return nil
+}
+
+// keySealer seals and opens the data keys of data sealed as one record
+type keySealer struct {
+	seal func(dataKey []byte) (string, error)
+	open func(sealed string) ([]byte, error)
+}
+
+// keySealer returns the sealer of the data keys of data sealed as rec, for
+// a request that performs action on it. Data keys are sealed by the
+// request's customer key for SSE-C, the KMS key for SSE-KMS, and the
+// master key otherwise.
+func (s *ObjectService) keySealer(ctx context.Context, rec *metadata.ObjectEncryption, enc Encryption, action string) (*keySealer, error) {
+	if err := checkCustomerKey(rec, enc); err != nil {
+		return nil, err
+	}
+
+	var kek []byte
+	switch {
+	case rec.CustomerKeyMD5 != "":
+		kek = enc.CustomerKey
+	case rec.Algorithm == SSEAlgorithmKMS:
+		if s.keyProvider == nil {
+			return nil, ErrEncryptionNotConfigured
+		}
+		if err := authorizeKey(rec, enc, action); err != nil {
+			return nil, err
+		}
+		return &keySealer{
+			seal: func(dataKey []byte) (string, error) {
+				return s.keyProvider.WrapKey(ctx, rec.KMSKeyID, dataKey)
+			},
+			open: func(sealed string) ([]byte, error) {
+				return s.keyProvider.UnwrapKey(ctx, rec.KMSKeyID, sealed)
+			},
+		}, nil
+	case s.masterKey == nil:
+		return nil, ErrEncryptionNotConfigured
+	default:
+		kek = s.masterKey
+	}
+	return &keySealer{
+		seal: func(dataKey []byte) (string, error) { return encryption.SealKey(kek, dataKey) },
+		open: func(sealed string) ([]byte, error) { return encryption.OpenKey(kek, sealed) },
+	}, nil
+}
+
+// sealData returns a reader that seals data under a new data key, and the
+// data key sealed for storage in metadata. Data is returned unchanged when
+// rec is nil.
+func (s *ObjectService) sealData(ctx context.Context, data io.Reader, rec *metadata.ObjectEncryption, enc Encryption) (io.Reader, string, error) {
+	if rec == nil {
+		return data, "", checkCustomerKey(nil, enc)
+	}
+
+	sealer, err := s.keySealer(ctx, rec, enc, KMSActionGenerateDataKey)
+	if err != nil {
+		return nil, "", err
+	}
+	dataKey, err := encryption.NewDataKey()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+	sealedKey, err := sealer.seal(dataKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to seal data key: %w", err)
+	}
+	sealed, err := encryption.NewEncryptReader(data, dataKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return sealed, sealedKey, nil
+}
+
+// sealedSize returns the stored size of size bytes of data sealed as rec
+func sealedSize(size int64, rec *metadata.ObjectEncryption) int64 {
+	if rec == nil {
+		return size
+	}
+	return encryption.SealedSize(size)
+}
+
+// storedSize returns the size of an object's data in the backend
+func storedSize(meta *metadata.ObjectMetadata) int64 {
+	if meta.Encryption == nil || meta.Encryption.SealedKey != "" {
+		return sealedSize(meta.Size, meta.Encryption)
+	}
+	var size int64
+	for _, p := range meta.Parts {
+		size += encryption.SealedSize(p.Size)
+	}
+	return size
+}
+
+// dataOpener opens plaintext bytes [off, end) of an object's data
+type dataOpener func(off, end int64) (io.ReadCloser, error)
+
+// objectData returns an opener of the data of the object meta describes.
+// Data keys are unsealed up front, so a missing or wrong customer key
+// fails before any data is read.
+func (s *ObjectService) objectData(ctx context.Context, bucket, key string, meta *metadata.ObjectMetadata, enc Encryption) (dataOpener, error) {
+	dataBucket, dataKey := dataLocation(bucket, key, meta)
+	if meta.Encryption == nil {
+		if err := checkCustomerKey(nil, enc); err != nil {
+			return nil, err
+		}
+		return func(off, end int64) (io.ReadCloser, error) {
+			var opts storage.GetOptions
+			if off != 0 || end != meta.Size {
+				opts.Range = &storage.Range{Start: off, End: end}
+			}
+			return s.storage.Get(ctx, dataBucket, dataKey, opts)
+		}, nil
+	}
+
+	segments, err := s.sealedSegments(ctx, meta, enc)
+	if err != nil {
+		return nil, err
+	}
+	return func(off, end int64) (io.ReadCloser, error) {
+		r := newSealedReader(ctx, s.storage, dataBucket, dataKey, segments, off, end)
+		if off < end {
+			if err := r.open(); err != nil {
+				return nil, err
+			}
+		}
+		return r, nil
+	}, nil
+}
+
+// sealedSegment is a run of an object's data sealed as one stream: the
+// whole object, or one part of a multipart object
+type sealedSegment struct {
+	off, size int64 // plaintext offset and size
+	sealedOff int64 // offset of the sealed stream in the stored data
+	key       []byte
+}
+
+// sealedSegments unseals the data keys of an encrypted object
+func (s *ObjectService) sealedSegments(ctx context.Context, meta *metadata.ObjectMetadata, enc Encryption) ([]sealedSegment, error) {
+	sealer, err := s.keySealer(ctx, meta.Encryption, enc, KMSActionDecrypt)
+	if err != nil {
+		return nil, err
+	}
+
+	if meta.Encryption.SealedKey != "" {
+		dataKey, err := sealer.open(meta.Encryption.SealedKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open data key: %w", err)
+		}
+		return []sealedSegment{{size: meta.Size, key: dataKey}}, nil
+	}
+
+	segments := make([]sealedSegment, 0, len(meta.Parts))
+	var off, sealedOff int64
+	for _, p := range meta.Parts {
+		dataKey, err := sealer.open(p.SealedKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open data key of part %d: %w", p.PartNumber, err)
+		}
+		segments = append(segments, sealedSegment{off: off, size: p.Size, sealedOff: sealedOff, key: dataKey})
+		off += p.Size
+		sealedOff += encryption.SealedSize(p.Size)
+	}
+	return segments, nil
+}
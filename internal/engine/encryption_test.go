@@ -0,0 +1,453 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/openendpoint/openendpoint/internal/encryption"
+	"github.com/openendpoint/openendpoint/internal/metadata"
+	"github.com/openendpoint/openendpoint/internal/storage"
+)
+
+func newEncryptionTestService(t *testing.T) *ObjectService {
+	t.Helper()
+	svc := newVersioningTestService(t)
+	svc.SetMasterKey(bytes.Repeat([]byte{0x42}, encryption.KeySize))
+	if err := svc.CreateBucket(context.Background(), "bucket"); err != nil {
+		t.Fatal(err)
+	}
+	return svc
+}
+
+// testData returns n bytes spanning several encryption chunks when n is large
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i*31 + i/7)
+	}
+	return data
+}
+
+// storedData reads an object's data as the backend holds it
+func storedData(t *testing.T, svc *ObjectService, bucket, key string) []byte {
+	t.Helper()
+	ctx := context.Background()
+	meta, err := svc.metadata.GetObject(ctx, bucket, key, "")
+	if err != nil {
+		t.Fatalf("GetObject() metadata error: %v", err)
+	}
+	dataBucket, dataKey := dataLocation(bucket, key, meta)
+	rc, err := svc.storage.Get(ctx, dataBucket, dataKey, storage.GetOptions{})
+	if err != nil {
+		t.Fatalf("storage Get() error: %v", err)
+	}
+	defer rc.Close()
+	data, _ := io.ReadAll(rc)
+	return data
+}
+
+func readObject(t *testing.T, svc *ObjectService, bucket, key string, opts GetObjectOptions) ([]byte, error) {
+	t.Helper()
+	result, err := svc.GetObject(context.Background(), bucket, key, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Body.Close()
+	return io.ReadAll(result.Body)
+}
+
+func TestEncryption_SSES3(t *testing.T) {
+	svc := newEncryptionTestService(t)
+	ctx := context.Background()
+	data := testData(2*encryption.ChunkSize + 1000)
+
+	result, err := svc.PutObject(ctx, "bucket", "key", bytes.NewReader(data), PutObjectOptions{
+		Size:       int64(len(data)),
+		Encryption: Encryption{Algorithm: SSEAlgorithmAES256},
+	})
+	if err != nil {
+		t.Fatalf("PutObject() error: %v", err)
+	}
+	if result.Encryption.Algorithm != SSEAlgorithmAES256 || result.Encryption.CustomerKeyMD5 != "" {
+		t.Errorf("PutObject() Encryption = %+v, expected SSE-S3", result.Encryption)
+	}
+
+	stored := storedData(t, svc, "bucket", "key")
+	if int64(len(stored)) != encryption.SealedSize(int64(len(data))) || bytes.Contains(stored, data[:64]) {
+		t.Errorf("backend holds %d bytes of plaintext-looking data, expected %d sealed bytes", len(stored), encryption.SealedSize(int64(len(data))))
+	}
+
+	got, err := readObject(t, svc, "bucket", "key", GetObjectOptions{})
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("GetObject() = %d bytes, %v", len(got), err)
+	}
+
+	off, end := int64(encryption.ChunkSize-10), int64(encryption.ChunkSize+10)
+	got, err = readObject(t, svc, "bucket", "key", GetObjectOptions{Range: &storage.Range{Start: off, End: end}})
+	if err != nil || !bytes.Equal(got, data[off:end]) {
+		t.Errorf("ranged GetObject() = %d bytes, %v", len(got), err)
+	}
+
+	// Whole-object reads seek for the API layer's range handling
+	obj, _ := svc.GetObject(ctx, "bucket", "key", GetObjectOptions{})
+	defer obj.Body.Close()
+	if _, err := obj.Body.(io.Seeker).Seek(int64(len(data)-5), io.SeekStart); err != nil {
+		t.Fatalf("Seek() error: %v", err)
+	}
+	if tail, _ := io.ReadAll(obj.Body); !bytes.Equal(tail, data[len(data)-5:]) {
+		t.Errorf("read after Seek() = %q, expected %q", tail, data[len(data)-5:])
+	}
+
+	head, err := svc.HeadObject(ctx, "bucket", "key", HeadObjectOptions{})
+	if err != nil || head.Size != int64(len(data)) || head.Encryption.Algorithm != SSEAlgorithmAES256 {
+		t.Errorf("HeadObject() = %+v, %v", head, err)
+	}
+}
+
+func TestEncryption_NotConfigured(t *testing.T) {
+	svc := newVersioningTestService(t)
+	ctx := context.Background()
+	_ = svc.CreateBucket(ctx, "bucket")
+
+	_, err := svc.PutObject(ctx, "bucket", "key", bytes.NewReader([]byte("data")), PutObjectOptions{
+		Size:       4,
+		Encryption: Encryption{Algorithm: SSEAlgorithmAES256},
+	})
+	if !errors.Is(err, ErrEncryptionNotConfigured) {
+		t.Errorf("PutObject() error = %v, expected %v", err, ErrEncryptionNotConfigured)
+	}
+
+	_, err = svc.PutObject(ctx, "bucket", "key", bytes.NewReader([]byte("data")), PutObjectOptions{
+		Size:       4,
+		Encryption: Encryption{Algorithm: "aws:kms:dsse"},
+	})
+	if !errors.Is(err, ErrInvalidEncryption) {
+		t.Errorf("PutObject() with unknown algorithm error = %v, expected %v", err, ErrInvalidEncryption)
+	}
+}
+
+func TestEncryption_SSEC(t *testing.T) {
+	svc := newEncryptionTestService(t)
+	ctx := context.Background()
+	key := bytes.Repeat([]byte{0x07}, encryption.KeySize)
+	other := bytes.Repeat([]byte{0x08}, encryption.KeySize)
+	data := testData(encryption.ChunkSize + 5)
+
+	result, err := svc.PutObject(ctx, "bucket", "secret", bytes.NewReader(data), PutObjectOptions{
+		Size:       int64(len(data)),
+		Encryption: Encryption{CustomerKey: key},
+	})
+	if err != nil {
+		t.Fatalf("PutObject() error: %v", err)
+	}
+	if result.Encryption.CustomerKeyMD5 != encryption.KeyMD5(key) {
+		t.Errorf("PutObject() CustomerKeyMD5 = %q, expected %q", result.Encryption.CustomerKeyMD5, encryption.KeyMD5(key))
+	}
+
+	got, err := readObject(t, svc, "bucket", "secret", GetObjectOptions{Encryption: Encryption{CustomerKey: key}})
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("GetObject() with key = %d bytes, %v", len(got), err)
+	}
+
+	tests := []struct {
+		name string
+		enc  Encryption
+		want error
+	}{
+		{"no key", Encryption{}, ErrCustomerKeyRequired},
+		{"wrong key", Encryption{CustomerKey: other}, ErrCustomerKeyMismatch},
+	}
+	for _, tt := range tests {
+		if _, err := readObject(t, svc, "bucket", "secret", GetObjectOptions{Encryption: tt.enc}); !errors.Is(err, tt.want) {
+			t.Errorf("GetObject() %s error = %v, expected %v", tt.name, err, tt.want)
+		}
+		if _, err := svc.HeadObject(ctx, "bucket", "secret", HeadObjectOptions{Encryption: tt.enc}); !errors.Is(err, tt.want) {
+			t.Errorf("HeadObject() %s error = %v, expected %v", tt.name, err, tt.want)
+		}
+	}
+
+	// A customer key is not applicable to an object written without one
+	putString(t, svc, "bucket", "plain", "plain data")
+	if _, err := readObject(t, svc, "bucket", "plain", GetObjectOptions{Encryption: Encryption{CustomerKey: key}}); !errors.Is(err, ErrInvalidEncryption) {
+		t.Errorf("GetObject() of plaintext with key error = %v, expected %v", err, ErrInvalidEncryption)
+	}
+
+	_, err = svc.PutObject(ctx, "bucket", "short", bytes.NewReader(data), PutObjectOptions{
+		Size:       int64(len(data)),
+		Encryption: Encryption{CustomerKey: key[:16]},
+	})
+	if !errors.Is(err, ErrInvalidEncryption) {
+		t.Errorf("PutObject() with short key error = %v, expected %v", err, ErrInvalidEncryption)
+	}
+}
+
+func TestEncryption_BucketDefault(t *testing.T) {
+	svc := newEncryptionTestService(t)
+	ctx := context.Background()
+	err := svc.PutBucketEncryption(ctx, "bucket", &metadata.BucketEncryption{
+		Rule: metadata.EncryptionRule{Apply: metadata.ApplyEncryptionConfiguration{SSEAlgorithm: SSEAlgorithmAES256}},
+	})
+	if err != nil {
+		t.Fatalf("PutBucketEncryption() error: %v", err)
+	}
+
+	if result := putString(t, svc, "bucket", "key", "default encrypted"); result.Encryption.Algorithm != SSEAlgorithmAES256 {
+		t.Errorf("PutObject() Encryption = %+v, expected the bucket default", result.Encryption)
+	}
+	if stored := storedData(t, svc, "bucket", "key"); bytes.Contains(stored, []byte("default encrypted")) {
+		t.Error("backend holds plaintext of an object in a bucket with default encryption")
+	}
+	if body, _, err := getString(t, svc, "bucket", "key", ""); err != nil || body != "default encrypted" {
+		t.Errorf("GetObject() = %q, %v", body, err)
+	}
+}
+
+func TestEncryption_Copy(t *testing.T) {
+	svc := newEncryptionTestService(t)
+	ctx := context.Background()
+	key := bytes.Repeat([]byte{0x07}, encryption.KeySize)
+	data := testData(encryption.ChunkSize + 77)
+
+	_, err := svc.PutObject(ctx, "bucket", "src", bytes.NewReader(data), PutObjectOptions{
+		Size:       int64(len(data)),
+		Encryption: Encryption{CustomerKey: key},
+	})
+	if err != nil {
+		t.Fatalf("PutObject() error: %v", err)
+	}
+
+	if _, err := svc.CopyObject(ctx, "bucket", "src", "bucket", "dst", CopyObjectOptions{}); !errors.Is(err, ErrCustomerKeyRequired) {
+		t.Errorf("CopyObject() without source key error = %v, expected %v", err, ErrCustomerKeyRequired)
+	}
+
+	// SSE-C to SSE-S3
+	result, err := svc.CopyObject(ctx, "bucket", "src", "bucket", "dst", CopyObjectOptions{
+		SourceEncryption: Encryption{CustomerKey: key},
+		Encryption:       Encryption{Algorithm: SSEAlgorithmAES256},
+	})
+	if err != nil {
+		t.Fatalf("CopyObject() error: %v", err)
+	}
+	if result.Encryption.Algorithm != SSEAlgorithmAES256 || result.Encryption.CustomerKeyMD5 != "" {
+		t.Errorf("CopyObject() Encryption = %+v, expected SSE-S3", result.Encryption)
+	}
+	if got, err := readObject(t, svc, "bucket", "dst", GetObjectOptions{}); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("GetObject() of SSE-S3 copy = %d bytes, %v", len(got), err)
+	}
+
+	// SSE-S3 to plaintext
+	if _, err := svc.CopyObject(ctx, "bucket", "dst", "bucket", "plain", CopyObjectOptions{}); err != nil {
+		t.Fatalf("CopyObject() to plaintext error: %v", err)
+	}
+	if stored := storedData(t, svc, "bucket", "plain"); !bytes.Equal(stored, data) {
+		t.Errorf("plaintext copy stored %d bytes, expected the %d plaintext bytes", len(stored), len(data))
+	}
+}
+
+func TestEncryption_Multipart(t *testing.T) {
+	svc := newEncryptionTestService(t)
+	svc.minPartSize = 1
+	ctx := context.Background()
+	key := bytes.Repeat([]byte{0x07}, encryption.KeySize)
+	sse := Encryption{CustomerKey: key}
+	data := testData(3*encryption.ChunkSize + 123)
+
+	_, err := svc.PutObject(ctx, "bucket", "src", bytes.NewReader(data), PutObjectOptions{
+		Size:       int64(len(data)),
+		Encryption: Encryption{Algorithm: SSEAlgorithmAES256},
+	})
+	if err != nil {
+		t.Fatalf("PutObject() error: %v", err)
+	}
+
+	upload, err := svc.CreateMultipartUpload(ctx, "bucket", "mpu", PutObjectOptions{Encryption: sse})
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload() error: %v", err)
+	}
+	if upload.Encryption.CustomerKeyMD5 != encryption.KeyMD5(key) {
+		t.Errorf("CreateMultipartUpload() Encryption = %+v, expected SSE-C", upload.Encryption)
+	}
+
+	if _, err := svc.UploadPart(ctx, "bucket", "mpu", upload.UploadID, 1, bytes.NewReader(data[:100]), UploadPartOptions{}); !errors.Is(err, ErrCustomerKeyRequired) {
+		t.Errorf("UploadPart() without key error = %v, expected %v", err, ErrCustomerKeyRequired)
+	}
+
+	split := int64(encryption.ChunkSize + 10)
+	p1, err := svc.UploadPart(ctx, "bucket", "mpu", upload.UploadID, 1, bytes.NewReader(data[:split]), UploadPartOptions{Encryption: sse})
+	if err != nil {
+		t.Fatalf("UploadPart() error: %v", err)
+	}
+	// The second part is copied from an SSE-S3 object, so it is unsealed
+	// with the master key and sealed again under the upload's customer key
+	p2, err := svc.UploadPartCopy(ctx, "bucket", "src", "bucket", "mpu", upload.UploadID, 2, UploadPartCopyOptions{
+		Range:      &storage.Range{Start: split, End: int64(len(data))},
+		Encryption: sse,
+	})
+	if err != nil {
+		t.Fatalf("UploadPartCopy() error: %v", err)
+	}
+
+	complete, err := svc.CompleteMultipartUpload(ctx, "bucket", "mpu", upload.UploadID, []PartInfo{
+		{PartNumber: 1, ETag: p1.ETag},
+		{PartNumber: 2, ETag: p2.ETag},
+	})
+	if err != nil {
+		t.Fatalf("CompleteMultipartUpload() error: %v", err)
+	}
+	if complete.Encryption.CustomerKeyMD5 != encryption.KeyMD5(key) {
+		t.Errorf("CompleteMultipartUpload() Encryption = %+v, expected SSE-C", complete.Encryption)
+	}
+
+	got, err := readObject(t, svc, "bucket", "mpu", GetObjectOptions{Encryption: sse})
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("GetObject() = %d bytes, %v", len(got), err)
+	}
+
+	// Ranges may cross from one part's sealed stream into the next
+	for _, r := range [][2]int64{{0, 10}, {split - 5, split + 5}, {split, split + 1}, {int64(len(data)) - 3, int64(len(data))}} {
+		got, err := readObject(t, svc, "bucket", "mpu", GetObjectOptions{Range: &storage.Range{Start: r[0], End: r[1]}, Encryption: sse})
+		if err != nil || !bytes.Equal(got, data[r[0]:r[1]]) {
+			t.Errorf("GetObject() range %v = %d bytes, %v", r, len(got), err)
+		}
+	}
+
+	if _, err := readObject(t, svc, "bucket", "mpu", GetObjectOptions{}); !errors.Is(err, ErrCustomerKeyRequired) {
+		t.Errorf("GetObject() without key error = %v, expected %v", err, ErrCustomerKeyRequired)
+	}
+
+	report, err := svc.Fsck(ctx, FsckOptions{})
+	if err != nil {
+		t.Fatalf("Fsck() error: %v", err)
+	}
+	if len(report.Problems) != 0 {
+		t.Errorf("Fsck() found problems in encrypted objects: %+v", report.Problems)
+	}
+}
+
+// newKMSTestService returns an encryption test service whose key provider
+// is a local keyring holding the enabled key "app"
+func newKMSTestService(t *testing.T) (*ObjectService, *encryption.LocalKeyring) {
+	t.Helper()
+	svc := newEncryptionTestService(t)
+	keyring, err := encryption.OpenLocalKeyring(t.TempDir()+"/keyring", bytes.Repeat([]byte{0x42}, encryption.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keyring.CreateKey(context.Background(), "app", ""); err != nil {
+		t.Fatal(err)
+	}
+	svc.SetKeyProvider(keyring)
+	return svc, keyring
+}
+
+func TestEncryption_KMS(t *testing.T) {
+	svc, keyring := newKMSTestService(t)
+	ctx := context.Background()
+	data := testData(encryption.ChunkSize + 500)
+	sse := Encryption{Algorithm: SSEAlgorithmKMS, KMSKeyID: encryption.KeyARN("app")}
+
+	result, err := svc.PutObject(ctx, "bucket", "key", bytes.NewReader(data), PutObjectOptions{Size: int64(len(data)), Encryption: sse})
+	if err != nil {
+		t.Fatalf("PutObject() error: %v", err)
+	}
+	if result.Encryption.Algorithm != SSEAlgorithmKMS || result.Encryption.KMSKeyID != "app" {
+		t.Errorf("PutObject() Encryption = %+v, expected SSE-KMS under app", result.Encryption)
+	}
+	if stored := storedData(t, svc, "bucket", "key"); bytes.Contains(stored, data[:64]) {
+		t.Error("backend holds plaintext of an SSE-KMS object")
+	}
+
+	got, err := readObject(t, svc, "bucket", "key", GetObjectOptions{})
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("GetObject() = %d bytes, %v", len(got), err)
+	}
+
+	// The request's authorizer gates every use of the key
+	var asked []string
+	deny := Encryption{AuthorizeKey: func(keyID, action string) error {
+		asked = append(asked, keyID+" "+action)
+		return ErrKeyAccessDenied
+	}}
+	if _, err := readObject(t, svc, "bucket", "key", GetObjectOptions{Encryption: deny}); !errors.Is(err, ErrKeyAccessDenied) {
+		t.Errorf("GetObject() denied error = %v, expected %v", err, ErrKeyAccessDenied)
+	}
+	deny.Algorithm, deny.KMSKeyID = SSEAlgorithmKMS, "app"
+	if _, err := svc.PutObject(ctx, "bucket", "denied", bytes.NewReader(data), PutObjectOptions{Encryption: deny}); !errors.Is(err, ErrKeyAccessDenied) {
+		t.Errorf("PutObject() denied error = %v, expected %v", err, ErrKeyAccessDenied)
+	}
+	if _, err := svc.CreateMultipartUpload(ctx, "bucket", "denied", PutObjectOptions{Encryption: deny}); !errors.Is(err, ErrKeyAccessDenied) {
+		t.Errorf("CreateMultipartUpload() denied error = %v, expected %v", err, ErrKeyAccessDenied)
+	}
+	want := []string{"app " + KMSActionDecrypt, "app " + KMSActionGenerateDataKey, "app " + KMSActionGenerateDataKey}
+	if len(asked) != len(want) || asked[0] != want[0] || asked[1] != want[1] || asked[2] != want[2] {
+		t.Errorf("AuthorizeKey() calls = %v, expected %v", asked, want)
+	}
+
+	// A disabled key makes its objects unreadable until enabled again
+	keyring.DisableKey(ctx, "app")
+	if _, err := readObject(t, svc, "bucket", "key", GetObjectOptions{}); !errors.Is(err, encryption.ErrKeyDisabled) {
+		t.Errorf("GetObject() with disabled key error = %v, expected %v", err, encryption.ErrKeyDisabled)
+	}
+	if _, err := svc.CreateMultipartUpload(ctx, "bucket", "mpu", PutObjectOptions{Encryption: sse}); !errors.Is(err, encryption.ErrKeyDisabled) {
+		t.Errorf("CreateMultipartUpload() with disabled key error = %v, expected %v", err, encryption.ErrKeyDisabled)
+	}
+	keyring.EnableKey(ctx, "app")
+	if _, err := readObject(t, svc, "bucket", "key", GetObjectOptions{}); err != nil {
+		t.Errorf("GetObject() after enabling error: %v", err)
+	}
+
+	for name, enc := range map[string]Encryption{
+		"unknown key":     {Algorithm: SSEAlgorithmKMS, KMSKeyID: "missing"},
+		"invalid key":     {Algorithm: SSEAlgorithmKMS, KMSKeyID: "not/valid"},
+		"no key":          {Algorithm: SSEAlgorithmKMS},
+		"key with AES256": {Algorithm: SSEAlgorithmAES256, KMSKeyID: "app"},
+	} {
+		if _, err := svc.PutObject(ctx, "bucket", "bad", bytes.NewReader(data), PutObjectOptions{Encryption: enc}); err == nil {
+			t.Errorf("PutObject() with %s succeeded", name)
+		}
+	}
+
+	plain := newEncryptionTestService(t)
+	if _, err := plain.PutObject(ctx, "bucket", "key", bytes.NewReader(data), PutObjectOptions{Encryption: sse}); !errors.Is(err, ErrEncryptionNotConfigured) {
+		t.Errorf("PutObject() without key provider error = %v, expected %v", err, ErrEncryptionNotConfigured)
+	}
+}
+
+func TestEncryption_KMSBucketDefault(t *testing.T) {
+	svc, _ := newKMSTestService(t)
+	ctx := context.Background()
+
+	config := func(algorithm, keyID string) *metadata.BucketEncryption {
+		return &metadata.BucketEncryption{Rule: metadata.EncryptionRule{Apply: metadata.ApplyEncryptionConfiguration{
+			SSEAlgorithm: algorithm, KMSMasterKeyID: keyID,
+		}}}
+	}
+	if err := svc.PutBucketEncryption(ctx, "bucket", config(SSEAlgorithmKMS, "")); !errors.Is(err, ErrInvalidEncryption) {
+		t.Errorf("PutBucketEncryption() without key error = %v, expected %v", err, ErrInvalidEncryption)
+	}
+	if err := svc.PutBucketEncryption(ctx, "bucket", config(SSEAlgorithmKMS, "missing")); !errors.Is(err, encryption.ErrKeyNotFound) {
+		t.Errorf("PutBucketEncryption() with unknown key error = %v, expected %v", err, encryption.ErrKeyNotFound)
+	}
+	if err := svc.PutBucketEncryption(ctx, "bucket", config(SSEAlgorithmAES256, "app")); !errors.Is(err, ErrInvalidEncryption) {
+		t.Errorf("PutBucketEncryption() AES256 with key error = %v, expected %v", err, ErrInvalidEncryption)
+	}
+	if err := svc.PutBucketEncryption(ctx, "bucket", config(SSEAlgorithmKMS, encryption.KeyARN("app"))); err != nil {
+		t.Fatalf("PutBucketEncryption() error: %v", err)
+	}
+	if got, _ := svc.GetBucketEncryption(ctx, "bucket"); got.Rule.Apply.KMSMasterKeyID != "app" {
+		t.Errorf("stored default key = %q, expected the key ID", got.Rule.Apply.KMSMasterKeyID)
+	}
+
+	for _, enc := range []Encryption{{}, {Algorithm: SSEAlgorithmKMS}} {
+		result, err := svc.PutObject(ctx, "bucket", "key", bytes.NewReader([]byte("data")), PutObjectOptions{Size: 4, Encryption: enc})
+		if err != nil {
+			t.Fatalf("PutObject(%+v) error: %v", enc, err)
+		}
+		if result.Encryption.Algorithm != SSEAlgorithmKMS || result.Encryption.KMSKeyID != "app" {
+			t.Errorf("PutObject(%+v) Encryption = %+v, expected the bucket's KMS key", enc, result.Encryption)
+		}
+	}
+}
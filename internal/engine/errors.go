@@ -0,0 +1,104 @@
+package engine
+
+import "errors"
+
+// Errors returned by ObjectService operations. They are wrapped with
+// request details, so callers should match them with errors.Is.
+var (
+	// ErrEntityTooLarge is returned when an upload exceeds the maximum object size
+	ErrEntityTooLarge = errors.New("object size exceeds maximum allowed size")
+	// ErrIncompleteBody is returned when fewer bytes than declared were received
+	ErrIncompleteBody = errors.New("request body is shorter than the declared content length")
+	// ErrInvalidPart is returned when a completion names a part that was
+	// not uploaded or whose ETag does not match
+	ErrInvalidPart = errors.New("one or more of the specified parts could not be found")
+	// ErrInvalidPartOrder is returned when completion parts are not in
+	// ascending part number order
+	ErrInvalidPartOrder = errors.New("the list of parts was not in ascending order")
+	// ErrEntityTooSmall is returned when a part other than the last is
+	// smaller than the minimum part size
+	ErrEntityTooSmall = errors.New("part is smaller than the minimum allowed size")
+	// ErrNoSuchUpload is returned when a multipart upload ID is unknown
+	ErrNoSuchUpload = errors.New("the specified multipart upload does not exist")
+	// ErrInvalidRange is returned when a copy source range does not lie
+	// within the source object
+	ErrInvalidRange = errors.New("range is not valid for the source object")
+	// ErrBadDigest is returned when the received data does not match the
+	// checksum the client declared for it
+	ErrBadDigest = errors.New("checksum does not match the received data")
+	// ErrInvalidChecksum is returned for an unsupported checksum algorithm
+	// or one that conflicts with the multipart upload's
+	ErrInvalidChecksum = errors.New("checksum algorithm is not valid for this request")
+	// ErrNoSuchKey is returned when the object or version a request
+	// addresses does not exist
+	ErrNoSuchKey = errors.New("the specified key does not exist")
+	// ErrObjectLocked is returned when retention or a legal hold forbids
+	// deleting or overwriting an object, or weakening its retention
+	ErrObjectLocked = errors.New("object is protected by object lock")
+	// ErrInvalidObjectLock is returned for an unknown retention mode or
+	// legal hold status, a default retention without a valid period, or
+	// retention and legal holds in a bucket without object lock
+	ErrInvalidObjectLock = errors.New("object lock setting is not valid")
+	// ErrVersioningRequired is returned when object lock is enabled on a
+	// bucket without versioning enabled, or versioning is suspended on a
+	// bucket with object lock
+	ErrVersioningRequired = errors.New("object lock requires versioning to be enabled")
+	// ErrObjectLockEnabled is returned when object lock is disabled or its
+	// configuration deleted on a bucket that has it enabled
+	ErrObjectLockEnabled = errors.New("object lock cannot be disabled once enabled")
+	// ErrInvalidTag is returned for an object tag set with too many tags,
+	// an empty, duplicate or oversized key, or an oversized value
+	ErrInvalidTag = errors.New("object tags are not valid")
+	// ErrInvalidEncryption is returned for an unsupported server-side
+	// encryption algorithm or customer key, or a customer key sent for
+	// data that was not written with one
+	ErrInvalidEncryption = errors.New("server-side encryption parameters are not valid")
+	// ErrEncryptionNotConfigured is returned for SSE-S3 when the server
+	// has no master key, and for SSE-KMS when it has no key provider
+	ErrEncryptionNotConfigured = errors.New("server-side encryption is not configured")
+	// ErrCustomerKeyRequired is returned when data written with SSE-C is
+	// accessed without its customer key
+	ErrCustomerKeyRequired = errors.New("object is encrypted with a customer key")
+	// ErrCustomerKeyMismatch is returned when the customer key of a
+	// request is not the one the data was written with
+	ErrCustomerKeyMismatch = errors.New("customer key does not match the object's")
+	// ErrKeyAccessDenied is returned when the requester may not use the
+	// KMS key of an SSE-KMS request
+	ErrKeyAccessDenied = errors.New("access to the kms key is denied")
+)
+
+// Precondition errors are returned wrapped in a *PreconditionError
+var (
+	// ErrNotModified is returned when If-None-Match or If-Modified-Since
+	// indicate the client's copy is current
+	ErrNotModified = errors.New("object not modified")
+	// ErrPreconditionFailed is returned when If-Match or
+	// If-Unmodified-Since do not hold
+	ErrPreconditionFailed = errors.New("precondition failed")
+)
+
+// PreconditionError reports a failed conditional request together with the
+// validators of the current object, which a 304 response must carry
+type PreconditionError struct {
+	Err          error
+	ETag         string
+	LastModified int64
+}
+
+func (e *PreconditionError) Error() string { return e.Err.Error() }
+func (e *PreconditionError) Unwrap() error { return e.Err }
+
+// ErrDeleteMarker is returned, wrapped in a *DeleteMarkerError, when the
+// requested object version is a delete marker
+var ErrDeleteMarker = errors.New("object version is a delete marker")
+
+// DeleteMarkerError reports a read that resolved to a delete marker. S3
+// answers 404 when the marker is the current version and 405 when it was
+// addressed explicitly by version ID.
+type DeleteMarkerError struct {
+	VersionID string
+	Explicit  bool
+}
+
+func (e *DeleteMarkerError) Error() string { return ErrDeleteMarker.Error() }
+func (e *DeleteMarkerError) Unwrap() error { return ErrDeleteMarker }
@@ -0,0 +1,240 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/openendpoint/openendpoint/internal/metadata"
+	"github.com/openendpoint/openendpoint/internal/storage"
+)
+
+// Kinds of inconsistency reported by Fsck
+const (
+	FsckOrphan       = "orphan"        // data no metadata refers to
+	FsckDangling     = "dangling"      // metadata whose data is missing
+	FsckSizeMismatch = "size_mismatch" // data whose size differs from its metadata
+)
+
+// fsckPageSize is the number of versions read from metadata at a time
+const fsckPageSize = 1000
+
+// FsckOptions controls a consistency check between data and metadata
+type FsckOptions struct {
+	// Repair deletes orphaned data and dangling metadata instead of only
+	// reporting them. Size mismatches are never repaired.
+	Repair bool
+}
+
+// FsckProblem is one inconsistency found by Fsck. Orphans are reported by
+// their backend bucket and key, which for non-null versions is the
+// internal versions bucket.
+type FsckProblem struct {
+	Kind      string `json:"kind"`
+	Bucket    string `json:"bucket"`
+	Key       string `json:"key"`
+	VersionID string `json:"versionId,omitempty"`
+	Detail    string `json:"detail,omitempty"`
+	Repaired  bool   `json:"repaired"`
+}
+
+// FsckReport is the result of Fsck
+type FsckReport struct {
+	Buckets  int           `json:"buckets"`
+	Versions int           `json:"versions"` // metadata versions checked
+	Objects  int           `json:"objects"`  // backend objects checked
+	Problems []FsckProblem `json:"problems"`
+}
+
+// Unrepaired returns the number of problems left in place
+func (r *FsckReport) Unrepaired() int {
+	n := 0
+	for _, p := range r.Problems {
+		if !p.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+// Fsck reconciles the storage backend against the metadata store. Every
+// version in metadata must have its data in the backend, and every object
+// in the backend must belong to a version. It must not run while writes
+// are in flight: data written just before its metadata looks orphaned.
+func (s *ObjectService) Fsck(ctx context.Context, opts FsckOptions) (*FsckReport, error) {
+	report := &FsckReport{}
+
+	buckets, err := s.metadata.ListBuckets(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list buckets: %w", err)
+	}
+	sort.Strings(buckets)
+	report.Buckets = len(buckets)
+
+	known := make(map[string]bool, len(buckets))
+	for _, bucket := range buckets {
+		known[bucket] = true
+
+		referenced, err := s.fsckVersions(ctx, bucket, opts, report)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.fsckObjects(ctx, bucket, opts, report, func(key string) bool {
+			return referenced[key]
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	// Non-null versions keep their data in the versions bucket
+	if err := s.fsckObjects(ctx, versionsBucket, opts, report, func(key string) bool {
+		return s.referencesVersionData(ctx, key, known)
+	}); err != nil {
+		return nil, err
+	}
+
+	// Buckets left in the backend by an interrupted DeleteBucket
+	backendBuckets, err := s.storage.ListBuckets(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backend buckets: %w", err)
+	}
+	for _, b := range backendBuckets {
+		if known[b.Name] || b.Name == versionsBucket {
+			continue
+		}
+		if err := s.fsckObjects(ctx, b.Name, opts, report, func(string) bool { return false }); err != nil {
+			return nil, err
+		}
+		if opts.Repair {
+			if err := s.storage.DeleteBucket(ctx, b.Name); err != nil {
+				s.logger.Warnw("failed to delete orphaned bucket", "bucket", b.Name, "error", err)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// fsckVersions checks that every version of a bucket has its data and
+// returns the keys whose data is stored at the key itself
+func (s *ObjectService) fsckVersions(ctx context.Context, bucket string, opts FsckOptions, report *FsckReport) (map[string]bool, error) {
+	referenced := make(map[string]bool)
+
+	listOpts := metadata.ListOptions{MaxKeys: fsckPageSize}
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		listing, err := s.metadata.ListObjectVersions(ctx, bucket, listOpts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list versions of %s: %w", bucket, err)
+		}
+
+		for i := range listing.Versions {
+			v := &listing.Versions[i]
+			if v.IsDeleteMarker {
+				continue
+			}
+			report.Versions++
+
+			dataBucket, dataKey := dataLocation(bucket, v.Key, v)
+			if !v.VersionedData {
+				referenced[v.Key] = true
+			}
+
+			info, err := s.storage.Head(ctx, dataBucket, dataKey)
+			switch {
+			case errors.Is(err, storage.ErrObjectNotFound) || errors.Is(err, storage.ErrBucketNotFound):
+				problem := FsckProblem{Kind: FsckDangling, Bucket: bucket, Key: v.Key, VersionID: v.VersionID}
+				if opts.Repair {
+					problem.Repaired = s.removeDanglingVersion(ctx, bucket, v)
+				}
+				report.Problems = append(report.Problems, problem)
+			case err != nil:
+				return nil, fmt.Errorf("failed to check %s/%s: %w", dataBucket, dataKey, err)
+			case info.Size != storedSize(v):
+				report.Problems = append(report.Problems, FsckProblem{
+					Kind:      FsckSizeMismatch,
+					Bucket:    bucket,
+					Key:       v.Key,
+					VersionID: v.VersionID,
+					Detail:    fmt.Sprintf("metadata has %d bytes, data has %d", storedSize(v), info.Size),
+				})
+			}
+		}
+
+		if !listing.IsTruncated {
+			return referenced, nil
+		}
+		listOpts.Marker = listing.NextKeyMarker
+		listOpts.VersionIDMarker = listing.NextVersionIDMarker
+	}
+}
+
+// removeDanglingVersion deletes the metadata of a version whose data is
+// missing and reports whether it did
+func (s *ObjectService) removeDanglingVersion(ctx context.Context, bucket string, v *metadata.ObjectMetadata) bool {
+	// An empty version ID would delete every version of the key
+	if v.VersionID == "" {
+		return false
+	}
+
+	unlock := s.locker.Lock(bucket, v.Key)
+	defer unlock()
+
+	if err := s.metadata.DeleteObject(ctx, bucket, v.Key, v.VersionID); err != nil {
+		s.logger.Warnw("failed to delete dangling version", "bucket", bucket, "key", v.Key, "versionID", v.VersionID, "error", err)
+		return false
+	}
+	return true
+}
+
+// fsckObjects reports the objects of a backend bucket that referenced does
+// not claim, deleting them on repair
+func (s *ObjectService) fsckObjects(ctx context.Context, bucket string, opts FsckOptions, report *FsckReport, referenced func(key string) bool) error {
+	// Listed in one call: backends do not all page reliably
+	result, err := s.storage.List(ctx, bucket, "", storage.ListOptions{})
+	if errors.Is(err, storage.ErrBucketNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list backend bucket %s: %w", bucket, err)
+	}
+
+	for _, obj := range result.Objects {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		report.Objects++
+		if referenced(obj.Key) {
+			continue
+		}
+
+		problem := FsckProblem{Kind: FsckOrphan, Bucket: bucket, Key: obj.Key}
+		if opts.Repair {
+			if err := s.storage.Delete(ctx, bucket, obj.Key); err != nil {
+				s.logger.Warnw("failed to delete orphaned data", "bucket", bucket, "key", obj.Key, "error", err)
+			} else {
+				problem.Repaired = true
+			}
+		}
+		report.Problems = append(report.Problems, problem)
+	}
+	return nil
+}
+
+// referencesVersionData reports whether a key of the versions bucket holds
+// the data of an existing version
+func (s *ObjectService) referencesVersionData(ctx context.Context, dataKey string, buckets map[string]bool) bool {
+	parts := strings.SplitN(dataKey, "/", 3)
+	if len(parts) != 3 || !buckets[parts[0]] {
+		return false
+	}
+	bucket, versionID, key := parts[0], parts[1], parts[2]
+
+	meta, err := s.metadata.GetObject(ctx, bucket, key, versionID)
+	return err == nil && !meta.IsDeleteMarker && meta.VersionedData
+}
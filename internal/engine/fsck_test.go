@@ -0,0 +1,166 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/openendpoint/openendpoint/internal/metadata"
+	"github.com/openendpoint/openendpoint/internal/storage"
+)
+
+func problemKeys(report *FsckReport, kind string) []string {
+	var keys []string
+	for _, p := range report.Problems {
+		if p.Kind == kind {
+			keys = append(keys, p.Bucket+"/"+p.Key)
+		}
+	}
+	return keys
+}
+
+func TestObjectService_FsckClean(t *testing.T) {
+	svc := newVersioningTestService(t)
+	ctx := context.Background()
+	_ = svc.CreateBucket(ctx, "plain")
+	_ = svc.CreateBucket(ctx, "versioned")
+	_ = svc.PutBucketVersioning(ctx, "versioned", &metadata.BucketVersioning{Status: VersioningEnabled})
+
+	putString(t, svc, "plain", "a", "one")
+	putString(t, svc, "plain", "dir/b", "two")
+	putString(t, svc, "versioned", "key", "one")
+	putString(t, svc, "versioned", "key", "two")
+	if _, err := svc.DeleteObject(ctx, "versioned", "key", DeleteObjectOptions{}); err != nil {
+		t.Fatalf("DeleteObject() error: %v", err)
+	}
+
+	report, err := svc.Fsck(ctx, FsckOptions{})
+	if err != nil {
+		t.Fatalf("Fsck() error: %v", err)
+	}
+	if len(report.Problems) != 0 {
+		t.Errorf("Fsck() problems = %+v, expected none", report.Problems)
+	}
+	if report.Buckets != 2 || report.Versions != 4 || report.Objects != 4 {
+		t.Errorf("Fsck() checked %d buckets, %d versions, %d objects; expected 2, 4, 4", report.Buckets, report.Versions, report.Objects)
+	}
+}
+
+func TestObjectService_FsckRepair(t *testing.T) {
+	svc := newVersioningTestService(t)
+	ctx := context.Background()
+	_ = svc.CreateBucket(ctx, "plain")
+	_ = svc.CreateBucket(ctx, "versioned")
+	_ = svc.PutBucketVersioning(ctx, "versioned", &metadata.BucketVersioning{Status: VersioningEnabled})
+
+	putString(t, svc, "plain", "kept", "data")
+	putString(t, svc, "plain", "resized", "data")
+	v1 := putString(t, svc, "versioned", "key", "one").VersionID
+	putString(t, svc, "versioned", "key", "two")
+
+	put := func(bucket, key, body string) {
+		if err := svc.storage.Put(ctx, bucket, key, strings.NewReader(body), int64(len(body)), storage.PutOptions{}); err != nil {
+			t.Fatalf("storage Put(%s/%s) error: %v", bucket, key, err)
+		}
+	}
+
+	// Data written without its metadata
+	put("plain", "orphan", "x")
+	put(versionsBucket, versionDataKey("versioned", "key", "missing-version"), "x")
+	put("deleted-bucket", "leftover", "x")
+	// Metadata whose data is gone
+	if err := svc.storage.Delete(ctx, versionsBucket, versionDataKey("versioned", "key", v1)); err != nil {
+		t.Fatal(err)
+	}
+	// Data that no longer matches its metadata
+	put("plain", "resized", "longer data")
+
+	report, err := svc.Fsck(ctx, FsckOptions{})
+	if err != nil {
+		t.Fatalf("Fsck() error: %v", err)
+	}
+	orphans := problemKeys(report, FsckOrphan)
+	if len(orphans) != 3 || orphans[0] != "plain/orphan" || orphans[2] != "deleted-bucket/leftover" {
+		t.Errorf("Fsck() orphans = %v", orphans)
+	}
+	if dangling := problemKeys(report, FsckDangling); len(dangling) != 1 || dangling[0] != "versioned/key" {
+		t.Errorf("Fsck() dangling = %v", dangling)
+	}
+	if mismatched := problemKeys(report, FsckSizeMismatch); len(mismatched) != 1 || mismatched[0] != "plain/resized" {
+		t.Errorf("Fsck() size mismatches = %v", mismatched)
+	}
+	if report.Unrepaired() != 5 {
+		t.Errorf("Unrepaired() = %d, expected 5", report.Unrepaired())
+	}
+
+	report, err = svc.Fsck(ctx, FsckOptions{Repair: true})
+	if err != nil {
+		t.Fatalf("Fsck(repair) error: %v", err)
+	}
+	if report.Unrepaired() != 1 {
+		t.Errorf("Fsck(repair) left %d problems, expected only the size mismatch", report.Unrepaired())
+	}
+
+	report, err = svc.Fsck(ctx, FsckOptions{})
+	if err != nil {
+		t.Fatalf("Fsck() after repair error: %v", err)
+	}
+	if len(report.Problems) != 1 || report.Problems[0].Kind != FsckSizeMismatch {
+		t.Errorf("Fsck() after repair problems = %+v", report.Problems)
+	}
+	if _, err := svc.metadata.GetObject(ctx, "versioned", "key", v1); err == nil {
+		t.Error("dangling version still in metadata after repair")
+	}
+	if body, _, err := getString(t, svc, "plain", "kept", ""); err != nil || body != "data" {
+		t.Errorf("GetObject(kept) = %q, %v after repair", body, err)
+	}
+}
+
+// failingStore fails the metadata writes the write-ordering tests need
+type failingStore struct {
+	metadata.Store
+}
+
+var errMetadataDown = errors.New("metadata unavailable")
+
+func (f *failingStore) PutObject(ctx context.Context, bucket, key string, meta *metadata.ObjectMetadata) error {
+	return errMetadataDown
+}
+
+func (f *failingStore) DeleteObject(ctx context.Context, bucket, key, versionID string) error {
+	return errMetadataDown
+}
+
+func TestObjectService_WriteOrdering(t *testing.T) {
+	svc := newVersioningTestService(t)
+	ctx := context.Background()
+	_ = svc.CreateBucket(ctx, "plain")
+	_ = svc.CreateBucket(ctx, "versioned")
+	_ = svc.PutBucketVersioning(ctx, "versioned", &metadata.BucketVersioning{Status: VersioningEnabled})
+	putString(t, svc, "plain", "key", "data")
+
+	store := svc.metadata
+	svc.metadata = &failingStore{Store: store}
+
+	// A failed metadata delete keeps the data the metadata points at
+	if _, err := svc.DeleteObject(ctx, "plain", "key", DeleteObjectOptions{}); !errors.Is(err, errMetadataDown) {
+		t.Errorf("DeleteObject() error = %v, expected metadata failure", err)
+	}
+	// A failed metadata write removes the new version's data
+	if _, err := svc.PutObject(ctx, "versioned", "key", strings.NewReader("data"), PutObjectOptions{Size: 4}); !errors.Is(err, errMetadataDown) {
+		t.Errorf("PutObject() error = %v, expected metadata failure", err)
+	}
+	if _, err := svc.CopyObject(ctx, "plain", "key", "versioned", "copy", CopyObjectOptions{}); !errors.Is(err, errMetadataDown) {
+		t.Errorf("CopyObject() error = %v, expected metadata failure", err)
+	}
+
+	svc.metadata = store
+	report, err := svc.Fsck(ctx, FsckOptions{})
+	if err != nil {
+		t.Fatalf("Fsck() error: %v", err)
+	}
+	if len(report.Problems) != 0 {
+		t.Errorf("Fsck() problems = %+v, expected none", report.Problems)
+	}
+}
@@ -0,0 +1,58 @@
+package engine
+
+import (
+	"github.com/openendpoint/openendpoint/internal/metadata"
+	"github.com/openendpoint/openendpoint/internal/storage"
+)
+
+// applyHeaders records the standard headers and user metadata of opts on meta
+func applyHeaders(meta *metadata.ObjectMetadata, opts PutObjectOptions) {
+	meta.ContentType = opts.ContentType
+	meta.ContentEncoding = opts.ContentEncoding
+	meta.CacheControl = opts.CacheControl
+	meta.ContentDisposition = opts.ContentDisposition
+	meta.ContentLanguage = opts.ContentLanguage
+	meta.Expires = opts.Expires
+	meta.Metadata = opts.Metadata
+	meta.StorageClass = opts.StorageClass
+}
+
+// storedHeaders returns put options carrying the headers and user metadata
+// stored with an object, as used when copying it
+func storedHeaders(meta *metadata.ObjectMetadata) PutObjectOptions {
+	return PutObjectOptions{
+		ContentType:        meta.ContentType,
+		ContentEncoding:    meta.ContentEncoding,
+		CacheControl:       meta.CacheControl,
+		ContentDisposition: meta.ContentDisposition,
+		ContentLanguage:    meta.ContentLanguage,
+		Expires:            meta.Expires,
+		Metadata:           meta.Metadata,
+		StorageClass:       meta.StorageClass,
+	}
+}
+
+// uploadHeaders returns put options carrying the headers given when a
+// multipart upload was initiated
+func uploadHeaders(upload *metadata.MultipartUploadMetadata) PutObjectOptions {
+	return PutObjectOptions{
+		ContentType:        upload.ContentType,
+		ContentEncoding:    upload.ContentEncoding,
+		CacheControl:       upload.CacheControl,
+		ContentDisposition: upload.ContentDisposition,
+		ContentLanguage:    upload.ContentLanguage,
+		Expires:            upload.Expires,
+		Metadata:           upload.Metadata,
+	}
+}
+
+// storageOptions converts put options to the subset the backend keeps
+func storageOptions(opts PutObjectOptions) storage.PutOptions {
+	return storage.PutOptions{
+		ContentType:     opts.ContentType,
+		ContentEncoding: opts.ContentEncoding,
+		CacheControl:    opts.CacheControl,
+		Metadata:        opts.Metadata,
+		StorageClass:    opts.StorageClass,
+	}
+}
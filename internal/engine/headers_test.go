@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestObjectService_ObjectHeaders(t *testing.T) {
+	svc := newVersioningTestService(t)
+	ctx := context.Background()
+	_ = svc.CreateBucket(ctx, "test-bucket")
+
+	opts := PutObjectOptions{
+		Size:               5,
+		ContentType:        "text/plain",
+		ContentDisposition: "attachment",
+		ContentLanguage:    "en",
+		Expires:            1700000000,
+		Metadata:           map[string]string{"color": "blue"},
+	}
+	if _, err := svc.PutObject(ctx, "test-bucket", "src", strings.NewReader("hello"), opts); err != nil {
+		t.Fatalf("PutObject() error: %v", err)
+	}
+
+	info, err := svc.HeadObject(ctx, "test-bucket", "src", HeadObjectOptions{})
+	if err != nil {
+		t.Fatalf("HeadObject() error: %v", err)
+	}
+	if info.ContentDisposition != "attachment" || info.ContentLanguage != "en" || info.Expires != 1700000000 || info.Metadata["color"] != "blue" {
+		t.Errorf("HeadObject() = %+v, expected the stored headers", info)
+	}
+
+	// A plain copy keeps the source's headers
+	if _, err := svc.CopyObject(ctx, "test-bucket", "src", "test-bucket", "copy", CopyObjectOptions{}); err != nil {
+		t.Fatalf("CopyObject() error: %v", err)
+	}
+	_, copied, err := getString(t, svc, "test-bucket", "copy", "")
+	if err != nil {
+		t.Fatalf("GetObject(copy) error: %v", err)
+	}
+	if copied.ContentType != "text/plain" || copied.ContentDisposition != "attachment" || copied.Metadata["color"] != "blue" {
+		t.Errorf("GetObject(copy) = %+v, expected the source headers", copied)
+	}
+
+	// Replacing drops every header the request does not repeat
+	_, err = svc.CopyObject(ctx, "test-bucket", "src", "test-bucket", "src", CopyObjectOptions{
+		ReplaceMetadata: true,
+		Replacement:     PutObjectOptions{ContentType: "image/png", Metadata: map[string]string{"shape": "round"}},
+	})
+	if err != nil {
+		t.Fatalf("CopyObject(REPLACE) error: %v", err)
+	}
+	body, replaced, err := getString(t, svc, "test-bucket", "src", "")
+	if err != nil || body != "hello" {
+		t.Fatalf("GetObject(src) = %q, %v after replacing metadata", body, err)
+	}
+	if replaced.ContentType != "image/png" || replaced.ContentDisposition != "" || replaced.Metadata["color"] != "" || replaced.Metadata["shape"] != "round" {
+		t.Errorf("GetObject(src) = %+v, expected only the replacement headers", replaced)
+	}
+
+	// Headers given at initiation apply to the completed object
+	upload, err := svc.CreateMultipartUpload(ctx, "test-bucket", "mp", PutObjectOptions{ContentType: "text/csv", CacheControl: "no-cache"})
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload() error: %v", err)
+	}
+	part, err := svc.UploadPart(ctx, "test-bucket", "mp", upload.UploadID, 1, strings.NewReader("a,b"), UploadPartOptions{})
+	if err != nil {
+		t.Fatalf("UploadPart() error: %v", err)
+	}
+	if _, err := svc.CompleteMultipartUpload(ctx, "test-bucket", "mp", upload.UploadID, []PartInfo{{PartNumber: 1, ETag: part.ETag}}); err != nil {
+		t.Fatalf("CompleteMultipartUpload() error: %v", err)
+	}
+	info, err = svc.HeadObject(ctx, "test-bucket", "mp", HeadObjectOptions{})
+	if err != nil {
+		t.Fatalf("HeadObject(mp) error: %v", err)
+	}
+	if info.ContentType != "text/csv" || info.CacheControl != "no-cache" {
+		t.Errorf("HeadObject(mp) = %+v, expected the initiation headers", info)
+	}
+}
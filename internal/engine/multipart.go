@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/openendpoint/openendpoint/internal/metadata"
+)
+
+// findUpload returns the metadata of an in-progress multipart upload
+func (s *ObjectService) findUpload(ctx context.Context, bucket, key, uploadID string) (*metadata.MultipartUploadMetadata, error) {
+	uploads, err := s.metadata.ListMultipartUploads(ctx, bucket, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list multipart uploads: %w", err)
+	}
+	for i := range uploads {
+		if uploads[i].UploadID == uploadID && uploads[i].Key == key {
+			return &uploads[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNoSuchUpload, uploadID)
+}
+
+// UploadPartCopy fills a part of a multipart upload with all or a range of
+// an existing object, without the data passing through the client
+func (s *ObjectService) UploadPartCopy(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey, uploadID string, partNumber int, opts UploadPartCopyOptions) (*UploadPartCopyResult, error) {
+	upload, err := s.findUpload(ctx, dstBucket, dstKey, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := s.locker.RLock(srcBucket, srcKey)
+	defer unlock()
+
+	srcMeta, err := s.metadata.GetObject(ctx, srcBucket, srcKey, opts.SourceVersionID)
+	if err != nil {
+		return nil, fmt.Errorf("source object not found: %s/%s", srcBucket, srcKey)
+	}
+	if srcMeta.IsDeleteMarker {
+		return nil, &DeleteMarkerError{VersionID: srcMeta.VersionID, Explicit: opts.SourceVersionID != ""}
+	}
+
+	// Copy preconditions never produce 304: every failure is a 412
+	if err := checkConditions(opts.Conditions, srcMeta.ETag, srcMeta.LastModified); err != nil {
+		return nil, &PreconditionError{Err: ErrPreconditionFailed, ETag: srcMeta.ETag, LastModified: srcMeta.LastModified}
+	}
+
+	if r := opts.Range; r != nil && (r.Start < 0 || r.End <= r.Start || r.End > srcMeta.Size) {
+		return nil, fmt.Errorf("%w: bytes %d-%d of %d", ErrInvalidRange, r.Start, r.End-1, srcMeta.Size)
+	}
+
+	open, err := s.objectData(ctx, srcBucket, srcKey, srcMeta, opts.SourceEncryption)
+	if err != nil {
+		return nil, err
+	}
+	off, end := int64(0), srcMeta.Size
+	if opts.Range != nil {
+		off, end = opts.Range.Start, opts.Range.End
+	}
+	data, err := open(off, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source object: %w", err)
+	}
+	defer data.Close()
+
+	part, err := s.stagePart(ctx, upload, partNumber, data, UploadPartOptions{Encryption: opts.Encryption})
+	if err != nil {
+		return nil, err
+	}
+
+	return &UploadPartCopyResult{
+		ETag:            part.ETag,
+		LastModified:    time.Now().Unix(),
+		SourceVersionID: s.reportedVersionID(ctx, srcBucket, srcMeta.VersionID),
+		Encryption:      reportedEncryption(upload.Encryption),
+	}, nil
+}
+
+// AbortStaleUploads aborts every multipart upload initiated more than
+// maxAge ago, removing its staged parts, and returns how many were aborted
+func (s *ObjectService) AbortStaleUploads(ctx context.Context, maxAge time.Duration) (int, error) {
+	buckets, err := s.metadata.ListBuckets(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list buckets: %w", err)
+	}
+
+	cutoff := time.Now().Add(-maxAge).Unix()
+	aborted := 0
+	for _, bucket := range buckets {
+		uploads, err := s.metadata.ListMultipartUploads(ctx, bucket, "")
+		if err != nil {
+			s.logger.Warnw("failed to list multipart uploads", "bucket", bucket, "error", err)
+			continue
+		}
+		for _, u := range uploads {
+			if u.Initiated >= cutoff {
+				continue
+			}
+			if err := s.AbortMultipartUpload(ctx, bucket, u.Key, u.UploadID); err != nil {
+				s.logger.Warnw("failed to abort stale multipart upload", "bucket", bucket, "key", u.Key, "uploadID", u.UploadID, "error", err)
+				continue
+			}
+			aborted++
+		}
+	}
+	return aborted, nil
+}
@@ -0,0 +1,165 @@
+package engine
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openendpoint/openendpoint/internal/metadata"
+	"github.com/openendpoint/openendpoint/internal/storage"
+	"go.uber.org/zap"
+)
+
+func TestObjectService_UploadPart_NoSuchUpload(t *testing.T) {
+	backend := NewMockStorageBackend()
+	svc := New(backend, NewMockMetadataStore(), zap.NewNop().Sugar())
+
+	_, err := svc.UploadPart(context.Background(), "bucket", "key", "missing", 1, strings.NewReader("data"), UploadPartOptions{})
+	if !errors.Is(err, ErrNoSuchUpload) {
+		t.Errorf("UploadPart() error = %v, want ErrNoSuchUpload", err)
+	}
+	if len(backend.parts) != 0 {
+		t.Errorf("UploadPart() staged %d parts for an unknown upload", len(backend.parts))
+	}
+}
+
+func TestObjectService_MultipartStaging(t *testing.T) {
+	backend := NewMockStorageBackend()
+	svc := New(backend, NewMockMetadataStore(), zap.NewNop().Sugar())
+	ctx := context.Background()
+	_ = svc.CreateBucket(ctx, "bucket")
+
+	upload, err := svc.CreateMultipartUpload(ctx, "bucket", "key", PutObjectOptions{})
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload() error = %v", err)
+	}
+	if _, err := svc.UploadPart(ctx, "bucket", "key", upload.UploadID, 1, strings.NewReader("part"), UploadPartOptions{}); err != nil {
+		t.Fatalf("UploadPart() error = %v", err)
+	}
+
+	// Staged parts never reach the bucket namespace
+	list, err := svc.ListObjects(ctx, "bucket", ListObjectsOptions{MaxKeys: 1000})
+	if err != nil {
+		t.Fatalf("ListObjects() error = %v", err)
+	}
+	if len(list.Objects) != 0 {
+		t.Errorf("ListObjects() returned %d objects, want 0", len(list.Objects))
+	}
+	if size, count, _ := svc.ComputeStorageMetrics(); size != 0 || count != 0 {
+		t.Errorf("ComputeStorageMetrics() = %d, %d, want 0, 0", size, count)
+	}
+
+	// An upload ID from another key or bucket aborts nothing
+	_ = svc.CreateBucket(ctx, "other")
+	for _, target := range [][2]string{{"bucket", "other-key"}, {"other", "key"}} {
+		if err := svc.AbortMultipartUpload(ctx, target[0], target[1], upload.UploadID); !errors.Is(err, ErrNoSuchUpload) {
+			t.Errorf("AbortMultipartUpload(%s/%s) error = %v, want %v", target[0], target[1], err, ErrNoSuchUpload)
+		}
+	}
+	if len(backend.parts) != 1 {
+		t.Fatalf("AbortMultipartUpload() of another key left %d staged parts, want 1", len(backend.parts))
+	}
+
+	if err := svc.AbortMultipartUpload(ctx, "bucket", "key", upload.UploadID); err != nil {
+		t.Fatalf("AbortMultipartUpload() error = %v", err)
+	}
+	if len(backend.parts) != 0 {
+		t.Errorf("AbortMultipartUpload() left %d staged parts", len(backend.parts))
+	}
+}
+
+func TestObjectService_AbortStaleUploads(t *testing.T) {
+	backend := NewMockStorageBackend()
+	meta := NewMockMetadataStore()
+	svc := New(backend, meta, zap.NewNop().Sugar())
+	ctx := context.Background()
+	_ = svc.CreateBucket(ctx, "bucket")
+
+	now := time.Now()
+	meta.uploads["bucket"] = []metadata.MultipartUploadMetadata{
+		{Bucket: "bucket", Key: "old", UploadID: "old-upload", Initiated: now.Add(-48 * time.Hour).Unix()},
+		{Bucket: "bucket", Key: "new", UploadID: "new-upload", Initiated: now.Add(-time.Hour).Unix()},
+	}
+	for _, id := range []string{"old-upload", "new-upload"} {
+		_ = backend.PutPart(ctx, id, 1, strings.NewReader("part"), 4)
+	}
+
+	aborted, err := svc.AbortStaleUploads(ctx, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("AbortStaleUploads() error = %v", err)
+	}
+	if aborted != 1 {
+		t.Errorf("AbortStaleUploads() = %d, want 1", aborted)
+	}
+
+	uploads, _ := meta.ListMultipartUploads(ctx, "bucket", "")
+	if len(uploads) != 1 || uploads[0].UploadID != "new-upload" {
+		t.Errorf("remaining uploads = %+v, want only new-upload", uploads)
+	}
+	if _, err := backend.GetPart(ctx, "old-upload", 1); err == nil {
+		t.Error("staged part of the stale upload should be removed")
+	}
+	if _, err := backend.GetPart(ctx, "new-upload", 1); err != nil {
+		t.Errorf("staged part of the recent upload was removed: %v", err)
+	}
+}
+
+func TestObjectService_UploadPartCopy(t *testing.T) {
+	backend := NewMockStorageBackend()
+	svc := New(backend, NewMockMetadataStore(), zap.NewNop().Sugar())
+	ctx := context.Background()
+	_ = svc.CreateBucket(ctx, "bucket")
+
+	if _, err := svc.PutObject(ctx, "bucket", "src", strings.NewReader("hello world"), PutObjectOptions{Size: 11}); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	upload, err := svc.CreateMultipartUpload(ctx, "bucket", "dst", PutObjectOptions{})
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		uploadID string
+		rng      *storage.Range
+		want     string
+		wantErr  error
+	}{
+		{"whole object", upload.UploadID, nil, "hello world", nil},
+		{"range", upload.UploadID, &storage.Range{Start: 6, End: 11}, "world", nil},
+		{"range past end", upload.UploadID, &storage.Range{Start: 6, End: 12}, "", ErrInvalidRange},
+		{"empty range", upload.UploadID, &storage.Range{Start: 3, End: 3}, "", ErrInvalidRange},
+		{"unknown upload", "missing", nil, "", ErrNoSuchUpload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := svc.UploadPartCopy(ctx, "bucket", "src", "bucket", "dst", tt.uploadID, 1, UploadPartCopyOptions{Range: tt.rng})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("UploadPartCopy() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UploadPartCopy() error = %v", err)
+			}
+			sum := md5.Sum([]byte(tt.want))
+			if want := `"` + hex.EncodeToString(sum[:]) + `"`; result.ETag != want {
+				t.Errorf("UploadPartCopy() ETag = %s, want %s", result.ETag, want)
+			}
+			rc, err := backend.GetPart(ctx, tt.uploadID, 1)
+			if err != nil {
+				t.Fatalf("GetPart() error = %v", err)
+			}
+			data, _ := io.ReadAll(rc)
+			if string(data) != tt.want {
+				t.Errorf("staged part = %q, want %q", data, tt.want)
+			}
+		})
+	}
+}
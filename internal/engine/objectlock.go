@@ -0,0 +1,249 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/openendpoint/openendpoint/internal/metadata"
+)
+
+// Object Lock retention modes and legal hold states
+const (
+	RetentionGovernance = "GOVERNANCE"
+	RetentionCompliance = "COMPLIANCE"
+	LegalHoldOn         = "ON"
+	LegalHoldOff        = "OFF"
+)
+
+// PutObjectRetentionOptions contains options for PutObjectRetention
+type PutObjectRetentionOptions struct {
+	// BypassGovernanceRetention lets a permitted caller shorten or remove
+	// GOVERNANCE mode retention
+	BypassGovernanceRetention bool
+}
+
+// validRetentionMode reports whether mode is an Object Lock retention mode
+func validRetentionMode(mode string) bool {
+	return mode == RetentionGovernance || mode == RetentionCompliance
+}
+
+// retentionActive reports whether r still protects its object at now
+func retentionActive(r *metadata.ObjectRetention, now int64) bool {
+	return r != nil && validRetentionMode(r.Mode) && r.RetainUntilDate > now
+}
+
+// PutObjectLock sets object lock configuration for a bucket. Object lock
+// can only be enabled on buckets with versioning enabled, so that
+// overwrites and deletes without a version ID never destroy data, and
+// can never be disabled afterwards.
+func (s *ObjectService) PutObjectLock(ctx context.Context, bucket string, config *metadata.ObjectLockConfig) error {
+	if config == nil {
+		return fmt.Errorf("object lock configuration is required")
+	}
+	if !config.Enabled {
+		if err := s.checkObjectLockDisabled(ctx, bucket); err != nil {
+			return err
+		}
+	}
+	if d := config.DefaultRetention; d != nil {
+		if !config.Enabled {
+			return fmt.Errorf("%w: default retention requires object lock to be enabled", ErrInvalidObjectLock)
+		}
+		if !validRetentionMode(d.Mode) || d.Days < 0 || d.Years < 0 || (d.Days > 0) == (d.Years > 0) {
+			return fmt.Errorf("%w: default retention needs a mode and a positive number of either days or years", ErrInvalidObjectLock)
+		}
+	}
+	if config.Enabled && s.versioningStatus(ctx, bucket) != VersioningEnabled {
+		return fmt.Errorf("%w: bucket %s", ErrVersioningRequired, bucket)
+	}
+	return s.metadata.PutObjectLock(ctx, bucket, config)
+}
+
+// DeleteObjectLock deletes the object lock configuration of a bucket that
+// does not have object lock enabled
+func (s *ObjectService) DeleteObjectLock(ctx context.Context, bucket string) error {
+	if err := s.checkObjectLockDisabled(ctx, bucket); err != nil {
+		return err
+	}
+	return s.metadata.DeleteObjectLock(ctx, bucket)
+}
+
+// checkObjectLockDisabled returns ErrObjectLockEnabled when bucket has
+// object lock enabled
+func (s *ObjectService) checkObjectLockDisabled(ctx context.Context, bucket string) error {
+	existing, err := s.metadata.GetObjectLock(ctx, bucket)
+	if err != nil {
+		return fmt.Errorf("failed to get object lock configuration: %w", err)
+	}
+	if existing != nil && existing.Enabled {
+		return fmt.Errorf("%w: bucket %s", ErrObjectLockEnabled, bucket)
+	}
+	return nil
+}
+
+// checkObjectLockEnabled returns ErrInvalidObjectLock unless bucket has
+// object lock enabled, which retention and legal holds require
+func (s *ObjectService) checkObjectLockEnabled(ctx context.Context, bucket string) error {
+	config, err := s.metadata.GetObjectLock(ctx, bucket)
+	if err != nil {
+		return fmt.Errorf("failed to get object lock configuration: %w", err)
+	}
+	if config == nil || !config.Enabled {
+		return fmt.Errorf("%w: bucket %s does not have object lock enabled", ErrInvalidObjectLock, bucket)
+	}
+	return nil
+}
+
+// versionTarget returns the version of bucket/key whose retention, legal
+// hold or tags a request addresses: versionID, or the latest version when
+// empty
+func (s *ObjectService) versionTarget(ctx context.Context, bucket, key, versionID string) (*metadata.ObjectMetadata, error) {
+	meta, err := s.metadata.GetObject(ctx, bucket, key, versionID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s/%s", ErrNoSuchKey, bucket, key)
+	}
+	if meta.IsDeleteMarker {
+		return nil, &DeleteMarkerError{VersionID: meta.VersionID, Explicit: versionID != ""}
+	}
+	return meta, nil
+}
+
+// GetObjectRetention gets the retention of a version of an object, the
+// latest when versionID is empty
+func (s *ObjectService) GetObjectRetention(ctx context.Context, bucket, key, versionID string) (*metadata.ObjectRetention, error) {
+	meta, err := s.versionTarget(ctx, bucket, key, versionID)
+	if err != nil {
+		return nil, err
+	}
+	return meta.Retention, nil
+}
+
+// PutObjectRetention sets the retention of a version of an object, the
+// latest when versionID is empty. Retention can always be extended, but
+// COMPLIANCE mode retention can never be shortened or removed before it
+// expires, and GOVERNANCE mode retention only with
+// opts.BypassGovernanceRetention. A retention without a mode removes it.
+func (s *ObjectService) PutObjectRetention(ctx context.Context, bucket, key, versionID string, retention *metadata.ObjectRetention, opts PutObjectRetentionOptions) error {
+	if retention == nil {
+		return fmt.Errorf("object retention is required")
+	}
+	if retention.Mode == "" && retention.RetainUntilDate != 0 || retention.Mode != "" && !validRetentionMode(retention.Mode) {
+		return fmt.Errorf("%w: retention mode must be GOVERNANCE or COMPLIANCE", ErrInvalidObjectLock)
+	}
+	if err := s.checkObjectLockEnabled(ctx, bucket); err != nil {
+		return err
+	}
+
+	unlock := s.locker.Lock(bucket, key)
+	defer unlock()
+
+	meta, err := s.versionTarget(ctx, bucket, key, versionID)
+	if err != nil {
+		return err
+	}
+	if existing := meta.Retention; retentionActive(existing, time.Now().Unix()) {
+		weaker := retention.Mode == "" || retention.RetainUntilDate < existing.RetainUntilDate
+		switch {
+		case existing.Mode == RetentionCompliance && (weaker || retention.Mode != RetentionCompliance):
+			return fmt.Errorf("%w: COMPLIANCE retention of %s/%s cannot be shortened or removed", ErrObjectLocked, bucket, key)
+		case existing.Mode == RetentionGovernance && weaker && !opts.BypassGovernanceRetention:
+			return fmt.Errorf("%w: GOVERNANCE retention of %s/%s cannot be shortened or removed without a bypass", ErrObjectLocked, bucket, key)
+		}
+	}
+
+	updated := *meta
+	updated.Retention = nil
+	if retention.Mode != "" {
+		updated.Retention = retention
+	}
+	if err := s.metadata.UpdateObjectVersion(ctx, bucket, key, &updated); err != nil {
+		return fmt.Errorf("failed to save object retention: %w", err)
+	}
+	return nil
+}
+
+// GetObjectLegalHold gets the legal hold of a version of an object, the
+// latest when versionID is empty
+func (s *ObjectService) GetObjectLegalHold(ctx context.Context, bucket, key, versionID string) (*metadata.ObjectLegalHold, error) {
+	meta, err := s.versionTarget(ctx, bucket, key, versionID)
+	if err != nil {
+		return nil, err
+	}
+	return meta.LegalHold, nil
+}
+
+// PutObjectLegalHold sets the legal hold of a version of an object, the
+// latest when versionID is empty
+func (s *ObjectService) PutObjectLegalHold(ctx context.Context, bucket, key, versionID string, legalHold *metadata.ObjectLegalHold) error {
+	if legalHold == nil {
+		return fmt.Errorf("object legal hold is required")
+	}
+	if legalHold.Status != LegalHoldOn && legalHold.Status != LegalHoldOff {
+		return fmt.Errorf("%w: legal hold status must be ON or OFF", ErrInvalidObjectLock)
+	}
+	if err := s.checkObjectLockEnabled(ctx, bucket); err != nil {
+		return err
+	}
+
+	unlock := s.locker.Lock(bucket, key)
+	defer unlock()
+
+	meta, err := s.versionTarget(ctx, bucket, key, versionID)
+	if err != nil {
+		return err
+	}
+	updated := *meta
+	updated.LegalHold = legalHold
+	if err := s.metadata.UpdateObjectVersion(ctx, bucket, key, &updated); err != nil {
+		return fmt.Errorf("failed to save object legal hold: %w", err)
+	}
+	return nil
+}
+
+// checkObjectLock returns ErrObjectLocked while the data of version meta
+// may not be removed: a legal hold is on, or its retention has not expired
+// and is not GOVERNANCE mode retention the caller may bypass
+func checkObjectLock(meta *metadata.ObjectMetadata, bypassGovernance bool) error {
+	if hold := meta.LegalHold; hold != nil && hold.Status == LegalHoldOn {
+		return fmt.Errorf("%w: %s/%s version %s is under legal hold", ErrObjectLocked, meta.Bucket, meta.Key, meta.VersionID)
+	}
+	retention := meta.Retention
+	if !retentionActive(retention, time.Now().Unix()) || retention.Mode == RetentionGovernance && bypassGovernance {
+		return nil
+	}
+	return fmt.Errorf("%w: %s/%s version %s is under %s retention until %s", ErrObjectLocked, meta.Bucket, meta.Key, meta.VersionID,
+		retention.Mode, time.Unix(retention.RetainUntilDate, 0).UTC().Format(time.RFC3339))
+}
+
+// checkOverwrite refuses a write that would replace the data of a locked
+// null version. Writes to versioning-enabled buckets add a version instead.
+func (s *ObjectService) checkOverwrite(ctx context.Context, t versionTarget) error {
+	if t.status == VersioningEnabled {
+		return nil
+	}
+	existing, err := s.metadata.GetObject(ctx, t.meta.Bucket, t.meta.Key, metadata.NullVersionID)
+	if err != nil || existing.IsDeleteMarker {
+		return nil
+	}
+	return checkObjectLock(existing, false)
+}
+
+// applyDefaultRetention gives the version meta, about to be saved, the
+// default retention of its bucket counted from its LastModified time
+func (s *ObjectService) applyDefaultRetention(ctx context.Context, meta *metadata.ObjectMetadata) error {
+	config, err := s.metadata.GetObjectLock(ctx, meta.Bucket)
+	if err != nil {
+		return fmt.Errorf("failed to get object lock configuration: %w", err)
+	}
+	if config == nil || !config.Enabled || config.DefaultRetention == nil {
+		return nil
+	}
+
+	d := config.DefaultRetention
+	meta.Retention = &metadata.ObjectRetention{
+		Mode:            d.Mode,
+		RetainUntilDate: time.Unix(meta.LastModified, 0).AddDate(d.Years, 0, d.Days).Unix(),
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openendpoint/openendpoint/internal/metadata"
+)
+
+func TestObjectService_ObjectLockRequiresVersioning(t *testing.T) {
+	svc := newVersioningTestService(t)
+	ctx := context.Background()
+	_ = svc.CreateBucket(ctx, "test-bucket")
+
+	config := &metadata.ObjectLockConfig{Enabled: true}
+	if err := svc.PutObjectLock(ctx, "test-bucket", config); !errors.Is(err, ErrVersioningRequired) {
+		t.Fatalf("PutObjectLock() on unversioned bucket error = %v, expected %v", err, ErrVersioningRequired)
+	}
+
+	_ = svc.PutBucketVersioning(ctx, "test-bucket", &metadata.BucketVersioning{Status: VersioningEnabled})
+	if err := svc.PutObjectLock(ctx, "test-bucket", config); err != nil {
+		t.Fatalf("PutObjectLock() error: %v", err)
+	}
+	err := svc.PutBucketVersioning(ctx, "test-bucket", &metadata.BucketVersioning{Status: VersioningSuspended})
+	if !errors.Is(err, ErrVersioningRequired) {
+		t.Errorf("PutBucketVersioning(Suspended) error = %v, expected %v", err, ErrVersioningRequired)
+	}
+
+	// Once enabled, object lock stays enabled
+	if err := svc.PutObjectLock(ctx, "test-bucket", &metadata.ObjectLockConfig{}); !errors.Is(err, ErrObjectLockEnabled) {
+		t.Errorf("PutObjectLock(disabled) error = %v, expected %v", err, ErrObjectLockEnabled)
+	}
+	if err := svc.DeleteObjectLock(ctx, "test-bucket"); !errors.Is(err, ErrObjectLockEnabled) {
+		t.Errorf("DeleteObjectLock() error = %v, expected %v", err, ErrObjectLockEnabled)
+	}
+	if lock, err := svc.GetObjectLock(ctx, "test-bucket"); err != nil || lock == nil || !lock.Enabled {
+		t.Errorf("GetObjectLock() = %+v, %v, expected object lock still enabled", lock, err)
+	}
+
+	for _, d := range []metadata.DefaultRetention{
+		{Mode: RetentionGovernance},
+		{Mode: RetentionGovernance, Days: 1, Years: 1},
+		{Mode: "FOREVER", Days: 1},
+	} {
+		config := &metadata.ObjectLockConfig{Enabled: true, DefaultRetention: &d}
+		if err := svc.PutObjectLock(ctx, "test-bucket", config); !errors.Is(err, ErrInvalidObjectLock) {
+			t.Errorf("PutObjectLock(%+v) error = %v, expected %v", d, err, ErrInvalidObjectLock)
+		}
+	}
+}
+
+// createObjectLockBucket creates a versioned bucket with object lock enabled
+func createObjectLockBucket(t *testing.T, svc *ObjectService, bucket string) {
+	t.Helper()
+	ctx := context.Background()
+	_ = svc.CreateBucket(ctx, bucket)
+	_ = svc.PutBucketVersioning(ctx, bucket, &metadata.BucketVersioning{Status: VersioningEnabled})
+	if err := svc.PutObjectLock(ctx, bucket, &metadata.ObjectLockConfig{Enabled: true}); err != nil {
+		t.Fatalf("PutObjectLock() error: %v", err)
+	}
+}
+
+func TestObjectService_RetentionRequiresObjectLock(t *testing.T) {
+	svc := newVersioningTestService(t)
+	ctx := context.Background()
+	_ = svc.CreateBucket(ctx, "test-bucket")
+	putString(t, svc, "test-bucket", "key", "data")
+
+	retention := &metadata.ObjectRetention{Mode: RetentionGovernance, RetainUntilDate: time.Now().Add(time.Hour).Unix()}
+	if err := svc.PutObjectRetention(ctx, "test-bucket", "key", "", retention, PutObjectRetentionOptions{}); !errors.Is(err, ErrInvalidObjectLock) {
+		t.Errorf("PutObjectRetention() without object lock error = %v, expected %v", err, ErrInvalidObjectLock)
+	}
+	if err := svc.PutObjectLegalHold(ctx, "test-bucket", "key", "", &metadata.ObjectLegalHold{Status: LegalHoldOn}); !errors.Is(err, ErrInvalidObjectLock) {
+		t.Errorf("PutObjectLegalHold() without object lock error = %v, expected %v", err, ErrInvalidObjectLock)
+	}
+
+	// Versioning alone is not enough
+	_ = svc.PutBucketVersioning(ctx, "test-bucket", &metadata.BucketVersioning{Status: VersioningEnabled})
+	if err := svc.PutObjectRetention(ctx, "test-bucket", "key", "", retention, PutObjectRetentionOptions{}); !errors.Is(err, ErrInvalidObjectLock) {
+		t.Errorf("PutObjectRetention() on versioned bucket error = %v, expected %v", err, ErrInvalidObjectLock)
+	}
+	if _, err := svc.DeleteObject(ctx, "test-bucket", "key", DeleteObjectOptions{}); err != nil {
+		t.Errorf("DeleteObject() error: %v", err)
+	}
+}
+
+func TestObjectService_ComplianceRetention(t *testing.T) {
+	svc := newVersioningTestService(t)
+	ctx := context.Background()
+	createObjectLockBucket(t, svc, "test-bucket")
+	v1 := putString(t, svc, "test-bucket", "key", "data").VersionID
+
+	until := time.Now().Add(time.Hour).Unix()
+	retention := &metadata.ObjectRetention{Mode: RetentionCompliance, RetainUntilDate: until}
+	if err := svc.PutObjectRetention(ctx, "test-bucket", "key", "", retention, PutObjectRetentionOptions{}); err != nil {
+		t.Fatalf("PutObjectRetention() error: %v", err)
+	}
+
+	bypass := DeleteObjectOptions{VersionID: v1, BypassGovernanceRetention: true}
+	if _, err := svc.DeleteObject(ctx, "test-bucket", "key", bypass); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("DeleteObject() error = %v, expected %v", err, ErrObjectLocked)
+	}
+	// Overwrites add a new version and leave the locked one in place
+	if _, err := svc.PutObject(ctx, "test-bucket", "key", strings.NewReader("new"), PutObjectOptions{Size: 3}); err != nil {
+		t.Errorf("PutObject() over locked object error: %v", err)
+	}
+	if body, _, err := getString(t, svc, "test-bucket", "key", v1); err != nil || body != "data" {
+		t.Errorf("GetObject(v1) = %q, %v, expected the locked data", body, err)
+	}
+
+	for _, weaker := range []*metadata.ObjectRetention{
+		{Mode: RetentionCompliance, RetainUntilDate: until - 60},
+		{Mode: RetentionGovernance, RetainUntilDate: until + 60},
+		{},
+	} {
+		err := svc.PutObjectRetention(ctx, "test-bucket", "key", v1, weaker, PutObjectRetentionOptions{BypassGovernanceRetention: true})
+		if !errors.Is(err, ErrObjectLocked) {
+			t.Errorf("PutObjectRetention(%+v) error = %v, expected %v", weaker, err, ErrObjectLocked)
+		}
+	}
+	retention.RetainUntilDate = until + 60
+	if err := svc.PutObjectRetention(ctx, "test-bucket", "key", v1, retention, PutObjectRetentionOptions{}); err != nil {
+		t.Errorf("PutObjectRetention() extending retention error: %v", err)
+	}
+
+	// Expired retention no longer protects the object
+	old := putString(t, svc, "test-bucket", "old", "data").VersionID
+	expired := &metadata.ObjectRetention{Mode: RetentionCompliance, RetainUntilDate: time.Now().Add(-time.Hour).Unix()}
+	_ = svc.PutObjectRetention(ctx, "test-bucket", "old", old, expired, PutObjectRetentionOptions{})
+	if _, err := svc.DeleteObject(ctx, "test-bucket", "old", DeleteObjectOptions{VersionID: old}); err != nil {
+		t.Errorf("DeleteObject() after retention expired error: %v", err)
+	}
+}
+
+func TestObjectService_GovernanceRetention(t *testing.T) {
+	svc := newVersioningTestService(t)
+	ctx := context.Background()
+	createObjectLockBucket(t, svc, "test-bucket")
+	v1 := putString(t, svc, "test-bucket", "key", "data").VersionID
+
+	until := time.Now().Add(time.Hour).Unix()
+	retention := &metadata.ObjectRetention{Mode: RetentionGovernance, RetainUntilDate: until}
+	if err := svc.PutObjectRetention(ctx, "test-bucket", "key", "", retention, PutObjectRetentionOptions{}); err != nil {
+		t.Fatalf("PutObjectRetention() error: %v", err)
+	}
+
+	shorter := &metadata.ObjectRetention{Mode: RetentionGovernance, RetainUntilDate: until - 60}
+	if err := svc.PutObjectRetention(ctx, "test-bucket", "key", "", shorter, PutObjectRetentionOptions{}); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("PutObjectRetention() shortening without bypass error = %v, expected %v", err, ErrObjectLocked)
+	}
+	if err := svc.PutObjectRetention(ctx, "test-bucket", "key", "", shorter, PutObjectRetentionOptions{BypassGovernanceRetention: true}); err != nil {
+		t.Errorf("PutObjectRetention() shortening with bypass error: %v", err)
+	}
+
+	if _, err := svc.DeleteObject(ctx, "test-bucket", "key", DeleteObjectOptions{VersionID: v1}); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("DeleteObject() without bypass error = %v, expected %v", err, ErrObjectLocked)
+	}
+	if _, err := svc.DeleteObject(ctx, "test-bucket", "key", DeleteObjectOptions{VersionID: v1, BypassGovernanceRetention: true}); err != nil {
+		t.Errorf("DeleteObject() with bypass error: %v", err)
+	}
+}
+
+func TestObjectService_LegalHold(t *testing.T) {
+	svc := newVersioningTestService(t)
+	ctx := context.Background()
+	createObjectLockBucket(t, svc, "test-bucket")
+	v1 := putString(t, svc, "test-bucket", "key", "data").VersionID
+
+	if err := svc.PutObjectLegalHold(ctx, "test-bucket", "key", "", &metadata.ObjectLegalHold{Status: "on"}); !errors.Is(err, ErrInvalidObjectLock) {
+		t.Errorf("PutObjectLegalHold(on) error = %v, expected %v", err, ErrInvalidObjectLock)
+	}
+	if err := svc.PutObjectLegalHold(ctx, "test-bucket", "key", "", &metadata.ObjectLegalHold{Status: LegalHoldOn}); err != nil {
+		t.Fatalf("PutObjectLegalHold() error: %v", err)
+	}
+	if _, err := svc.DeleteObject(ctx, "test-bucket", "key", DeleteObjectOptions{VersionID: v1, BypassGovernanceRetention: true}); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("DeleteObject() under legal hold error = %v, expected %v", err, ErrObjectLocked)
+	}
+
+	_ = svc.PutObjectLegalHold(ctx, "test-bucket", "key", "", &metadata.ObjectLegalHold{Status: LegalHoldOff})
+	if _, err := svc.DeleteObject(ctx, "test-bucket", "key", DeleteObjectOptions{VersionID: v1}); err != nil {
+		t.Errorf("DeleteObject() after legal hold released error: %v", err)
+	}
+}
+
+func TestObjectService_DefaultRetention(t *testing.T) {
+	svc := newVersioningTestService(t)
+	ctx := context.Background()
+	_ = svc.CreateBucket(ctx, "test-bucket")
+	_ = svc.PutBucketVersioning(ctx, "test-bucket", &metadata.BucketVersioning{Status: VersioningEnabled})
+	config := &metadata.ObjectLockConfig{
+		Enabled:          true,
+		DefaultRetention: &metadata.DefaultRetention{Mode: RetentionCompliance, Days: 1},
+	}
+	if err := svc.PutObjectLock(ctx, "test-bucket", config); err != nil {
+		t.Fatalf("PutObjectLock() error: %v", err)
+	}
+
+	v1 := putString(t, svc, "test-bucket", "key", "one").VersionID
+	retention, err := svc.GetObjectRetention(ctx, "test-bucket", "key", "")
+	if err != nil || retention == nil {
+		t.Fatalf("GetObjectRetention() = %v, %v, expected the default retention", retention, err)
+	}
+	want := time.Now().AddDate(0, 0, 1).Unix()
+	if retention.Mode != RetentionCompliance || retention.RetainUntilDate < want-60 || retention.RetainUntilDate > want {
+		t.Errorf("GetObjectRetention() = %+v, expected COMPLIANCE until about %d", retention, want)
+	}
+
+	// A new version gets the default retention only, the older keeps its own
+	longer := &metadata.ObjectRetention{Mode: RetentionCompliance, RetainUntilDate: want + 3600}
+	_ = svc.PutObjectRetention(ctx, "test-bucket", "key", v1, longer, PutObjectRetentionOptions{})
+	v2 := putString(t, svc, "test-bucket", "key", "two").VersionID
+	if retention, _ := svc.GetObjectRetention(ctx, "test-bucket", "key", v1); retention == nil || retention.RetainUntilDate != longer.RetainUntilDate {
+		t.Errorf("GetObjectRetention(v1) after overwrite = %+v, expected %+v", retention, longer)
+	}
+	if retention, _ := svc.GetObjectRetention(ctx, "test-bucket", "key", v2); retention == nil || retention.RetainUntilDate > want {
+		t.Errorf("GetObjectRetention(v2) = %+v, expected the default retention", retention)
+	}
+
+	// Deletes only hide locked versions behind a marker
+	marker, err := svc.DeleteObject(ctx, "test-bucket", "key", DeleteObjectOptions{})
+	if err != nil || !marker.DeleteMarker {
+		t.Fatalf("DeleteObject() = %+v, %v, expected a delete marker", marker, err)
+	}
+	if _, err := svc.DeleteObject(ctx, "test-bucket", "key", DeleteObjectOptions{VersionID: v1}); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("DeleteObject(v1) error = %v, expected %v", err, ErrObjectLocked)
+	}
+	if _, err := svc.DeleteObject(ctx, "test-bucket", "key", DeleteObjectOptions{VersionID: marker.VersionID}); err != nil {
+		t.Errorf("DeleteObject(marker) error: %v", err)
+	}
+}
+
+func TestObjectService_ObjectLockPerVersion(t *testing.T) {
+	svc := newVersioningTestService(t)
+	ctx := context.Background()
+	_ = svc.CreateBucket(ctx, "test-bucket")
+	_ = svc.PutBucketVersioning(ctx, "test-bucket", &metadata.BucketVersioning{Status: VersioningEnabled})
+	if err := svc.PutObjectLock(ctx, "test-bucket", &metadata.ObjectLockConfig{Enabled: true}); err != nil {
+		t.Fatalf("PutObjectLock() error: %v", err)
+	}
+
+	v1 := putString(t, svc, "test-bucket", "key", "one").VersionID
+	v2 := putString(t, svc, "test-bucket", "key", "two").VersionID
+	on := &metadata.ObjectLegalHold{Status: LegalHoldOn}
+	if err := svc.PutObjectLegalHold(ctx, "test-bucket", "key", v1, on); err != nil {
+		t.Fatalf("PutObjectLegalHold(v1) error: %v", err)
+	}
+	if err := svc.PutObjectLegalHold(ctx, "test-bucket", "key", "missing", on); !errors.Is(err, ErrNoSuchKey) {
+		t.Errorf("PutObjectLegalHold(missing) error = %v, expected %v", err, ErrNoSuchKey)
+	}
+
+	// Only the addressed version is held, and releasing another keeps it held
+	if hold, err := svc.GetObjectLegalHold(ctx, "test-bucket", "key", ""); err != nil || hold != nil {
+		t.Errorf("GetObjectLegalHold(latest) = %+v, %v, expected none", hold, err)
+	}
+	_ = svc.PutObjectLegalHold(ctx, "test-bucket", "key", "", &metadata.ObjectLegalHold{Status: LegalHoldOff})
+	if _, err := svc.DeleteObject(ctx, "test-bucket", "key", DeleteObjectOptions{VersionID: v1}); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("DeleteObject(v1) error = %v, expected %v", err, ErrObjectLocked)
+	}
+	if _, err := svc.DeleteObject(ctx, "test-bucket", "key", DeleteObjectOptions{VersionID: v2}); err != nil {
+		t.Errorf("DeleteObject(v2) error: %v", err)
+	}
+
+	// Retention of one version neither protects nor carries over to others
+	until := time.Now().Add(time.Hour).Unix()
+	retention := &metadata.ObjectRetention{Mode: RetentionCompliance, RetainUntilDate: until}
+	if err := svc.PutObjectRetention(ctx, "test-bucket", "key", v1, retention, PutObjectRetentionOptions{}); err != nil {
+		t.Fatalf("PutObjectRetention(v1) error: %v", err)
+	}
+	v3 := putString(t, svc, "test-bucket", "key", "three").VersionID
+	if got, err := svc.GetObjectRetention(ctx, "test-bucket", "key", v3); err != nil || got != nil {
+		t.Errorf("GetObjectRetention(v3) = %+v, %v, expected none", got, err)
+	}
+	if _, err := svc.DeleteObject(ctx, "test-bucket", "key", DeleteObjectOptions{VersionID: v3}); err != nil {
+		t.Errorf("DeleteObject(v3) error: %v", err)
+	}
+
+	// Delete markers have no lock state
+	marker, _ := svc.DeleteObject(ctx, "test-bucket", "key", DeleteObjectOptions{})
+	var derr *DeleteMarkerError
+	if _, err := svc.GetObjectLegalHold(ctx, "test-bucket", "key", marker.VersionID); !errors.As(err, &derr) || !derr.Explicit {
+		t.Errorf("GetObjectLegalHold(marker) error = %v, expected an explicit delete marker error", err)
+	}
+}
@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Conditions holds the HTTP conditional request headers of a read
+type Conditions struct {
+	IfMatch           string
+	IfNoneMatch       string
+	IfModifiedSince   string
+	IfUnmodifiedSince string
+}
+
+// checkConditions evaluates conditional headers against an object's ETag
+// and last modification time (Unix seconds) following S3: If-Match takes
+// precedence over If-Unmodified-Since and If-None-Match over
+// If-Modified-Since. Unparseable dates are ignored.
+func checkConditions(c Conditions, etag string, lastModified int64) error {
+	fail := func(err error) error {
+		return &PreconditionError{Err: err, ETag: etag, LastModified: lastModified}
+	}
+
+	if c.IfMatch != "" {
+		if !etagListMatches(c.IfMatch, etag) {
+			return fail(ErrPreconditionFailed)
+		}
+	} else if t, err := http.ParseTime(c.IfUnmodifiedSince); err == nil {
+		if lastModified > t.Unix() {
+			return fail(ErrPreconditionFailed)
+		}
+	}
+
+	if c.IfNoneMatch != "" {
+		if etagListMatches(c.IfNoneMatch, etag) {
+			return fail(ErrNotModified)
+		}
+	} else if t, err := http.ParseTime(c.IfModifiedSince); err == nil {
+		if lastModified <= t.Unix() {
+			return fail(ErrNotModified)
+		}
+	}
+
+	return nil
+}
+
+// etagListMatches reports whether a comma-separated If-Match/If-None-Match
+// value matches etag; "*" matches any object and weak validators compare
+// by their opaque tag
+func etagListMatches(list, etag string) bool {
+	etag = normalizeETag(etag)
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || normalizeETag(candidate) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeETag(etag string) string {
+	return strings.Trim(strings.TrimPrefix(strings.TrimSpace(etag), "W/"), "\"")
+}
@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckConditions(t *testing.T) {
+	const (
+		etag = `"abc123"`
+		// Last modified: Sun, 01 Jan 2023 12:00:00 GMT
+		lastModified = 1672574400
+		before       = "Sun, 01 Jan 2023 11:00:00 GMT"
+		same         = "Sun, 01 Jan 2023 12:00:00 GMT"
+		after        = "Sun, 01 Jan 2023 13:00:00 GMT"
+	)
+
+	tests := []struct {
+		name string
+		c    Conditions
+		want error
+	}{
+		{"None", Conditions{}, nil},
+		{"IfMatchHit", Conditions{IfMatch: `"abc123"`}, nil},
+		{"IfMatchUnquoted", Conditions{IfMatch: "abc123"}, nil},
+		{"IfMatchList", Conditions{IfMatch: `"x", "abc123"`}, nil},
+		{"IfMatchStar", Conditions{IfMatch: "*"}, nil},
+		{"IfMatchMiss", Conditions{IfMatch: `"other"`}, ErrPreconditionFailed},
+		{"IfNoneMatchHit", Conditions{IfNoneMatch: `"abc123"`}, ErrNotModified},
+		{"IfNoneMatchWeak", Conditions{IfNoneMatch: `W/"abc123"`}, ErrNotModified},
+		{"IfNoneMatchMiss", Conditions{IfNoneMatch: `"other"`}, nil},
+		{"IfModifiedSinceBefore", Conditions{IfModifiedSince: before}, nil},
+		{"IfModifiedSinceSame", Conditions{IfModifiedSince: same}, ErrNotModified},
+		{"IfModifiedSinceAfter", Conditions{IfModifiedSince: after}, ErrNotModified},
+		{"IfModifiedSinceInvalid", Conditions{IfModifiedSince: "yesterday"}, nil},
+		{"IfUnmodifiedSinceBefore", Conditions{IfUnmodifiedSince: before}, ErrPreconditionFailed},
+		{"IfUnmodifiedSinceSame", Conditions{IfUnmodifiedSince: same}, nil},
+		// If-Match true overrides a failing If-Unmodified-Since
+		{"IfMatchOverridesUnmodified", Conditions{IfMatch: etag, IfUnmodifiedSince: before}, nil},
+		// If-None-Match false (no match) overrides If-Modified-Since
+		{"IfNoneMatchOverridesModified", Conditions{IfNoneMatch: `"other"`, IfModifiedSince: after}, nil},
+		// 412 takes precedence over 304
+		{"FailedAndNotModified", Conditions{IfMatch: `"other"`, IfNoneMatch: etag}, ErrPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkConditions(tt.c, etag, lastModified)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("checkConditions() error = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("checkConditions() error = %v, want %v", err, tt.want)
+			}
+			var perr *PreconditionError
+			if !errors.As(err, &perr) || perr.ETag != etag || perr.LastModified != lastModified {
+				t.Errorf("checkConditions() error = %#v, want validators of the object", err)
+			}
+		})
+	}
+}
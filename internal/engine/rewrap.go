@@ -0,0 +1,276 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/openendpoint/openendpoint/internal/encryption"
+	"github.com/openendpoint/openendpoint/internal/metadata"
+)
+
+// RewrapKeys re-wraps the data keys of the SSE-KMS object versions sealed
+// under keyID, or under any KMS key when keyID is empty, with the latest
+// version of their key. Object data is not rewritten. Parts of multipart
+// uploads in progress keep their data keys until the upload completes and
+// a later pass re-wraps the object. Versions under a disabled key are
+// skipped. It returns the number of versions re-wrapped.
+func (s *ObjectService) RewrapKeys(ctx context.Context, keyID string) (int, error) {
+	if s.keyProvider == nil {
+		return 0, ErrEncryptionNotConfigured
+	}
+
+	buckets, err := s.metadata.ListBuckets(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list buckets: %w", err)
+	}
+
+	var rewrapped int
+	var lastErr error
+	for _, bucket := range buckets {
+		listOpts := metadata.ListOptions{MaxKeys: fsckPageSize}
+		for {
+			if err := ctx.Err(); err != nil {
+				return rewrapped, err
+			}
+
+			listing, err := s.metadata.ListObjectVersions(ctx, bucket, listOpts)
+			if err != nil {
+				return rewrapped, fmt.Errorf("failed to list versions of %s: %w", bucket, err)
+			}
+			for i := range listing.Versions {
+				v := &listing.Versions[i]
+				if !sealedUnderKMSKey(v.Encryption, keyID) {
+					continue
+				}
+				ok, err := s.rewrapVersion(ctx, bucket, v.Key, v.VersionID, keyID)
+				if err != nil {
+					s.logger.Warnw("failed to re-wrap data keys", "bucket", bucket, "key", v.Key, "versionID", v.VersionID, "error", err)
+					lastErr = err
+				}
+				if ok {
+					rewrapped++
+				}
+			}
+
+			if !listing.IsTruncated {
+				break
+			}
+			listOpts.Marker = listing.NextKeyMarker
+			listOpts.VersionIDMarker = listing.NextVersionIDMarker
+		}
+	}
+	return rewrapped, lastErr
+}
+
+// sealedUnderKMSKey reports whether data sealed as rec has its data keys
+// wrapped by KMS key keyID, or any KMS key when keyID is empty
+func sealedUnderKMSKey(rec *metadata.ObjectEncryption, keyID string) bool {
+	return rec != nil && rec.Algorithm == SSEAlgorithmKMS && (keyID == "" || rec.KMSKeyID == keyID)
+}
+
+// rewrapVersion re-wraps the data keys of one version and reports whether
+// any changed. The version is read again under the key's lock, so a
+// concurrent write is never overwritten with stale metadata.
+func (s *ObjectService) rewrapVersion(ctx context.Context, bucket, key, versionID, keyID string) (bool, error) {
+	unlock := s.locker.Lock(bucket, key)
+	defer unlock()
+
+	meta, err := s.metadata.GetObject(ctx, bucket, key, versionID)
+	if err != nil || !sealedUnderKMSKey(meta.Encryption, keyID) {
+		// Deleted or overwritten since it was listed
+		return false, nil
+	}
+
+	changed := false
+	rewrap := func(wrapped string) (string, error) {
+		out, ok, err := s.keyProvider.RewrapKey(ctx, meta.Encryption.KMSKeyID, wrapped)
+		changed = changed || ok
+		return out, err
+	}
+
+	sse := *meta.Encryption
+	if sse.SealedKey != "" {
+		if sse.SealedKey, err = rewrap(sse.SealedKey); err != nil {
+			return false, skipDisabledKey(err)
+		}
+	}
+	parts := make([]metadata.PartInfo, len(meta.Parts))
+	copy(parts, meta.Parts)
+	for i := range parts {
+		if parts[i].SealedKey == "" {
+			continue
+		}
+		if parts[i].SealedKey, err = rewrap(parts[i].SealedKey); err != nil {
+			return false, skipDisabledKey(err)
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+
+	meta.Encryption = &sse
+	meta.Parts = parts
+	if err := s.metadata.UpdateObjectVersion(ctx, bucket, key, meta); err != nil {
+		return false, fmt.Errorf("failed to update metadata: %w", err)
+	}
+	return true, nil
+}
+
+// skipDisabledKey drops the error of a key that is disabled: its data
+// keys are re-wrapped by a pass after it is enabled again
+func skipDisabledKey(err error) error {
+	if errors.Is(err, encryption.ErrKeyDisabled) {
+		return nil
+	}
+	return err
+}
+
+// RewrapStatus is the state of a Rewrapper
+type RewrapStatus struct {
+	Running      bool   `json:"running"`
+	LastStarted  int64  `json:"lastStarted,omitempty"`
+	LastFinished int64  `json:"lastFinished,omitempty"`
+	Rewrapped    int    `json:"rewrapped"` // versions re-wrapped by the last pass
+	LastError    string `json:"lastError,omitempty"`
+}
+
+// Rewrapper re-wraps data keys in the background: for a key when it is
+// triggered after a rotation, and for every key on an interval
+type Rewrapper struct {
+	service  *ObjectService
+	interval time.Duration
+
+	pending map[string]bool // keys queued for a pass, "" for every key
+	wakeCh  chan struct{}
+	stopCh  chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+
+	mu     sync.Mutex
+	status RewrapStatus
+}
+
+// NewRewrapper creates a rewrapper for an object service. An interval of 0
+// disables periodic passes, leaving only triggered ones.
+func NewRewrapper(s *ObjectService, interval time.Duration) *Rewrapper {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Rewrapper{
+		service:  s,
+		interval: interval,
+		pending:  make(map[string]bool),
+		wakeCh:   make(chan struct{}, 1),
+		stopCh:   make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Start starts the rewrapper
+func (r *Rewrapper) Start() {
+	r.wg.Add(1)
+	go r.run()
+}
+
+// Stop stops the rewrapper, abandoning a pass in progress. Versions
+// re-wrapped so far stay re-wrapped.
+func (r *Rewrapper) Stop() {
+	close(r.stopCh)
+	r.cancel()
+	r.wg.Wait()
+}
+
+// Trigger queues a pass over the data keys of keyID, or of every key when
+// keyID is empty
+func (r *Rewrapper) Trigger(keyID string) {
+	r.mu.Lock()
+	r.pending[keyID] = true
+	r.mu.Unlock()
+
+	select {
+	case r.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// Status returns the rewrapper state
+func (r *Rewrapper) Status() RewrapStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+// run runs the rewrapper loop
+func (r *Rewrapper) run() {
+	defer r.wg.Done()
+
+	var tick <-chan time.Time
+	if r.interval > 0 {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-tick:
+			r.rewrap([]string{""})
+		case <-r.wakeCh:
+			r.rewrap(r.takePending())
+		case <-r.stopCh:
+			return
+		}
+	}
+}
+
+// takePending returns the queued keys, collapsed to a single pass over
+// every key when one was queued
+func (r *Rewrapper) takePending() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var keys []string
+	if r.pending[""] {
+		keys = []string{""}
+	} else {
+		for id := range r.pending {
+			keys = append(keys, id)
+		}
+	}
+	r.pending = make(map[string]bool)
+	return keys
+}
+
+// rewrap runs one pass over the data keys of keys
+func (r *Rewrapper) rewrap(keys []string) {
+	r.mu.Lock()
+	r.status.Running = true
+	r.status.LastStarted = time.Now().Unix()
+	r.status.LastError = ""
+	r.mu.Unlock()
+
+	var total int
+	var lastErr error
+	for _, id := range keys {
+		n, err := r.service.RewrapKeys(r.ctx, id)
+		total += n
+		if err != nil {
+			lastErr = err
+		}
+		if r.ctx.Err() != nil {
+			break
+		}
+	}
+
+	r.mu.Lock()
+	r.status.Running = false
+	r.status.LastFinished = time.Now().Unix()
+	r.status.Rewrapped = total
+	if lastErr != nil {
+		r.status.LastError = lastErr.Error()
+	}
+	r.mu.Unlock()
+}
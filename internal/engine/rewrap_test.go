@@ -0,0 +1,151 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openendpoint/openendpoint/internal/metadata"
+)
+
+// wrappedKeys returns the wrapped data keys of every version of bucket/key
+func wrappedKeys(t *testing.T, svc *ObjectService, bucket, key string) []string {
+	t.Helper()
+	listing, err := svc.metadata.ListObjectVersions(context.Background(), bucket, metadata.ListOptions{Prefix: key, MaxKeys: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, v := range listing.Versions {
+		if v.Encryption == nil {
+			continue
+		}
+		if v.Encryption.SealedKey != "" {
+			keys = append(keys, v.Encryption.SealedKey)
+		}
+		for _, p := range v.Parts {
+			keys = append(keys, p.SealedKey)
+		}
+	}
+	return keys
+}
+
+func TestRewrapKeys(t *testing.T) {
+	svc, keyring := newKMSTestService(t)
+	svc.minPartSize = 1
+	ctx := context.Background()
+	keyring.CreateKey(ctx, "other", "")
+	if err := svc.PutBucketVersioning(ctx, "bucket", &metadata.BucketVersioning{Status: VersioningEnabled}); err != nil {
+		t.Fatal(err)
+	}
+
+	sse := Encryption{Algorithm: SSEAlgorithmKMS, KMSKeyID: "app"}
+	put := func(key, body string, enc Encryption) string {
+		result, err := svc.PutObject(ctx, "bucket", key, strings.NewReader(body), PutObjectOptions{Size: int64(len(body)), Encryption: enc})
+		if err != nil {
+			t.Fatalf("PutObject(%s) error: %v", key, err)
+		}
+		return result.VersionID
+	}
+	v1 := put("versioned", "first", sse)
+	put("versioned", "second", sse)
+	put("other", "other key", Encryption{Algorithm: SSEAlgorithmKMS, KMSKeyID: "other"})
+	put("sse-s3", "master key", Encryption{Algorithm: SSEAlgorithmAES256})
+
+	upload, err := svc.CreateMultipartUpload(ctx, "bucket", "mpu", PutObjectOptions{Encryption: sse})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parts []PartInfo
+	for i, body := range []string{"part one ", "part two"} {
+		p, err := svc.UploadPart(ctx, "bucket", "mpu", upload.UploadID, i+1, strings.NewReader(body), UploadPartOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, PartInfo{PartNumber: i + 1, ETag: p.ETag})
+	}
+	if _, err := svc.CompleteMultipartUpload(ctx, "bucket", "mpu", upload.UploadID, parts); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := svc.RewrapKeys(ctx, "app"); err != nil || n != 0 {
+		t.Errorf("RewrapKeys() before rotation = %d, %v, expected 0", n, err)
+	}
+
+	before := wrappedKeys(t, svc, "bucket", "")
+	dataBefore := storedData(t, svc, "bucket", "mpu")
+	if _, err := keyring.RotateKey(ctx, "app"); err != nil {
+		t.Fatal(err)
+	}
+	n, err := svc.RewrapKeys(ctx, "app")
+	if err != nil || n != 3 {
+		t.Fatalf("RewrapKeys() = %d, %v, expected the 3 versions under app", n, err)
+	}
+
+	after := wrappedKeys(t, svc, "bucket", "")
+	changed := 0
+	for i := range before {
+		if before[i] != after[i] {
+			changed++
+		}
+	}
+	if len(after) != len(before) || changed != 4 {
+		t.Errorf("re-wrapped %d of %d data keys, expected the 4 under app", changed, len(before))
+	}
+	if !bytes.Equal(storedData(t, svc, "bucket", "mpu"), dataBefore) {
+		t.Error("RewrapKeys() rewrote object data")
+	}
+
+	// Every version stays readable, and the latest stays latest
+	if got, _, err := getString(t, svc, "bucket", "versioned", v1); err != nil || got != "first" {
+		t.Errorf("GetObject(v1) = %q, %v", got, err)
+	}
+	if got, _, err := getString(t, svc, "bucket", "versioned", ""); err != nil || got != "second" {
+		t.Errorf("GetObject(latest) = %q, %v", got, err)
+	}
+	if got, _, err := getString(t, svc, "bucket", "mpu", ""); err != nil || got != "part one part two" {
+		t.Errorf("GetObject(mpu) = %q, %v", got, err)
+	}
+
+	// Data keys under a disabled key are left for a later pass
+	keyring.RotateKey(ctx, "other")
+	keyring.DisableKey(ctx, "other")
+	if n, err := svc.RewrapKeys(ctx, ""); err != nil || n != 0 {
+		t.Errorf("RewrapKeys() with disabled key = %d, %v, expected 0", n, err)
+	}
+}
+
+func TestRewrapper(t *testing.T) {
+	svc, keyring := newKMSTestService(t)
+	ctx := context.Background()
+	if _, err := svc.PutObject(ctx, "bucket", "key", strings.NewReader("data"), PutObjectOptions{
+		Size:       4,
+		Encryption: Encryption{Algorithm: SSEAlgorithmKMS, KMSKeyID: "app"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	before := wrappedKeys(t, svc, "bucket", "key")
+
+	r := NewRewrapper(svc, 0)
+	r.Start()
+	defer r.Stop()
+
+	keyring.RotateKey(ctx, "app")
+	r.Trigger("app")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for r.Status().LastFinished == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("rewrapper did not finish a pass")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status := r.Status(); status.Rewrapped != 1 || status.LastError != "" {
+		t.Errorf("Status() = %+v, expected 1 version re-wrapped", status)
+	}
+	if after := wrappedKeys(t, svc, "bucket", "key"); after[0] == before[0] {
+		t.Error("data key was not re-wrapped")
+	}
+}
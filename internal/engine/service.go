@@ -3,8 +3,6 @@ package engine
 import (
 	"bytes"
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
 	"io"
 	"strconv"
@@ -13,7 +11,9 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/openendpoint/openendpoint/internal/encryption"
 	"github.com/openendpoint/openendpoint/internal/metadata"
+	"github.com/openendpoint/openendpoint/internal/s3select"
 	"github.com/openendpoint/openendpoint/internal/storage"
 	"github.com/openendpoint/openendpoint/internal/telemetry"
 	"go.uber.org/zap"
@@ -22,21 +22,37 @@ import (
 // MaxUploadSize is the maximum size for object uploads (5GB by default, matching S3)
 const MaxUploadSize = 5 * 1024 * 1024 * 1024
 
+// MinPartSize is the smallest size of every multipart part but the last (5MB, matching S3)
+const MinPartSize = 5 * 1024 * 1024
+
 // ObjectService provides the core object storage operations
 type ObjectService struct {
-	storage   storage.StorageBackend
-	metadata  metadata.Store
-	logger    *zap.SugaredLogger
-	locker    *Locker
+	storage       storage.StorageBackend
+	metadata      metadata.Store
+	logger        *zap.SugaredLogger
+	locker        *Locker
+	maxObjectSize int64
+	minPartSize   int64
+	masterKey     []byte                 // seals SSE-S3 data keys, nil when not configured
+	keyProvider   encryption.KeyProvider // holds SSE-KMS keys, nil when not configured
 }
 
 // New creates a new ObjectService
 func New(storage storage.StorageBackend, metadata metadata.Store, logger *zap.SugaredLogger) *ObjectService {
 	return &ObjectService{
-		storage:  storage,
-		metadata: metadata,
-		logger:   logger,
-		locker:   NewLocker(),
+		storage:       storage,
+		metadata:      metadata,
+		logger:        logger,
+		locker:        NewLocker(),
+		maxObjectSize: MaxUploadSize,
+		minPartSize:   MinPartSize,
+	}
+}
+
+// SetMaxObjectSize sets the largest object PutObject accepts
+func (s *ObjectService) SetMaxObjectSize(size int64) {
+	if size > 0 {
+		s.maxObjectSize = size
 	}
 }
 
@@ -73,58 +89,68 @@ func (s *ObjectService) PutObject(ctx context.Context, bucket, key string, data
 		return nil, fmt.Errorf("bucket not found: %s", bucket)
 	}
 
-	// Read all data into memory first (required for hash calculation and storage)
-	// Use LimitReader to prevent memory exhaustion from malicious large uploads
-	dataBytes, err := io.ReadAll(io.LimitReader(data, MaxUploadSize+1))
-	if err != nil {
-		return nil, fmt.Errorf("failed to read data: %w", err)
+	// Stream the body to the backend, hashing and enforcing sizes on the way through
+	if opts.Size > s.maxObjectSize {
+		return nil, fmt.Errorf("%w (%d bytes)", ErrEntityTooLarge, s.maxObjectSize)
 	}
-	if int64(len(dataBytes)) > MaxUploadSize {
-		return nil, fmt.Errorf("object size exceeds maximum allowed size (%d bytes)", MaxUploadSize)
+	body := newHashingReader(data, opts.Size, s.maxObjectSize)
+	if err := body.addChecksum(opts.ChecksumAlgorithm, opts.Checksum); err != nil {
+		return nil, err
 	}
 
-	// Calculate size and hash
-	hasher := sha256.New()
-	hasher.Write(dataBytes)
-	size := int64(len(dataBytes))
-
-	// Generate ETag
-	etag := fmt.Sprintf("\"%s\"", hex.EncodeToString(hasher.Sum(nil)))
+	// Seal the data when the request or the bucket's default asks for it
+	sse, err := s.resolveEncryption(ctx, bucket, opts.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	stored, sealedKey, err := s.sealData(ctx, body, sse, opts.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	storedLength := opts.Size
+	if storedLength > 0 {
+		storedLength = sealedSize(storedLength, sse)
+	}
 
 	// Create storage options
-	storeOpts := storage.PutOptions{
-		ContentType:     opts.ContentType,
-		ContentEncoding: opts.ContentEncoding,
-		CacheControl:    opts.CacheControl,
-		Metadata:        opts.Metadata,
-		StorageClass:    opts.StorageClass,
-	}
+	storeOpts := storageOptions(opts)
 
-	// Store the object
-	if err := s.storage.Put(ctx, bucket, key, bytes.NewReader(dataBytes), size, storeOpts); err != nil {
+	// Store the object; the backend only makes it visible once fully written
+	target := s.newVersionTarget(ctx, bucket, key)
+	if err := s.checkOverwrite(ctx, target); err != nil {
+		return nil, err
+	}
+	dataBucket, dataKey := target.location()
+	if err := s.storage.Put(ctx, dataBucket, dataKey, stored, storedLength, storeOpts); err != nil {
 		return nil, fmt.Errorf("failed to store object: %w", err)
 	}
+	size := body.Size()
+	etag := body.ETag()
 
 	// Create metadata
 	now := time.Now().Unix()
-	objMeta := &metadata.ObjectMetadata{
-		Key:             key,
-		Bucket:          bucket,
-		Size:            size,
-		ETag:            etag,
-		ContentType:     opts.ContentType,
-		ContentEncoding: opts.ContentEncoding,
-		CacheControl:    opts.CacheControl,
-		Metadata:        opts.Metadata,
-		StorageClass:    opts.StorageClass,
-		VersionID:       uuid.New().String(),
-		IsLatest:        true,
-		LastModified:    now,
-	}
-
-	// Save metadata
+	objMeta := &target.meta
+	objMeta.Size = size
+	objMeta.ETag = etag
+	objMeta.ChecksumAlgorithm = opts.ChecksumAlgorithm
+	objMeta.Checksum = body.Checksum()
+	if sse != nil {
+		sse.SealedKey = sealedKey
+		objMeta.Encryption = sse
+	}
+	applyHeaders(objMeta, opts)
+	objMeta.IsLatest = true
+	objMeta.LastModified = now
+	if err := s.applyDefaultRetention(ctx, objMeta); err != nil {
+		s.discardVersionData(ctx, target)
+		return nil, err
+	}
+
+	// Save metadata; the data is already durable, so a crash before this
+	// point leaves at most an orphan for fsck
 	if err := s.metadata.PutObject(ctx, bucket, key, objMeta); err != nil {
 		s.logger.Error("failed to save metadata", zap.Error(err))
+		s.discardVersionData(ctx, target)
 		return nil, fmt.Errorf("failed to save object metadata: %w", err)
 	}
 
@@ -140,22 +166,29 @@ func (s *ObjectService) PutObject(ctx context.Context, bucket, key string, data
 	telemetry.UpdateLatency("PutObject", time.Since(start).Seconds())
 
 	return &ObjectResult{
-		ETag:         etag,
-		Size:         size,
-		VersionID:    objMeta.VersionID,
-		LastModified: now,
+		ETag:              etag,
+		Size:              size,
+		VersionID:         target.responseVersionID(),
+		LastModified:      now,
+		ChecksumAlgorithm: objMeta.ChecksumAlgorithm,
+		Checksum:          objMeta.Checksum,
+		Encryption:        reportedEncryption(sse),
 	}, nil
 }
 
 // CopyObjectResult contains the result of a copy operation
 type CopyObjectResult struct {
-	ETag         string
-	LastModified int64
-	VersionID    string
+	ETag              string
+	LastModified      int64
+	VersionID         string
+	SourceVersionID   string
+	ChecksumAlgorithm string
+	Checksum          string
+	Encryption        ServerSideEncryption
 }
 
 // CopyObject copies an object to another location
-func (s *ObjectService) CopyObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) (*CopyObjectResult, error) {
+func (s *ObjectService) CopyObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string, opts CopyObjectOptions) (*CopyObjectResult, error) {
 	// Lock for write
 	unlock := s.locker.Lock(dstBucket, dstKey)
 	defer unlock()
@@ -171,55 +204,107 @@ func (s *ObjectService) CopyObject(ctx context.Context, srcBucket, srcKey, dstBu
 	}
 
 	// Get source object metadata
-	srcMeta, err := s.metadata.GetObject(ctx, srcBucket, srcKey, "")
+	srcMeta, err := s.metadata.GetObject(ctx, srcBucket, srcKey, opts.SourceVersionID)
 	if err != nil {
 		return nil, fmt.Errorf("source object not found: %s/%s", srcBucket, srcKey)
 	}
+	if srcMeta.IsDeleteMarker {
+		return nil, &DeleteMarkerError{VersionID: srcMeta.VersionID, Explicit: opts.SourceVersionID != ""}
+	}
+
+	// Copy preconditions never produce 304: every failure is a 412
+	if err := checkConditions(opts.Conditions, srcMeta.ETag, srcMeta.LastModified); err != nil {
+		return nil, &PreconditionError{Err: ErrPreconditionFailed, ETag: srcMeta.ETag, LastModified: srcMeta.LastModified}
+	}
 
 	// Get source object data
-	data, err := s.storage.Get(ctx, srcBucket, srcKey, storage.GetOptions{})
+	open, err := s.objectData(ctx, srcBucket, srcKey, srcMeta, opts.SourceEncryption)
+	if err != nil {
+		return nil, err
+	}
+	data, err := open(0, srcMeta.Size)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read source object: %w", err)
 	}
 	defer data.Close()
 
+	// The destination keeps the source's headers unless they are replaced
+	headers := storedHeaders(srcMeta)
+	if opts.ReplaceMetadata {
+		headers = opts.Replacement
+		headers.StorageClass = srcMeta.StorageClass
+	}
+
+	// The copy is a single object, so its checksum covers the whole data
+	// even when the source's was a composite of parts. A whole-object source
+	// checksum of the same algorithm is verified on the way through.
+	algorithm := opts.ChecksumAlgorithm
+	if algorithm == "" {
+		algorithm = srcMeta.ChecksumAlgorithm
+	}
+	var wantChecksum string
+	if algorithm == srcMeta.ChecksumAlgorithm && len(srcMeta.Parts) == 0 {
+		wantChecksum = srcMeta.Checksum
+	}
+	body := newHashingReader(data, srcMeta.Size, 0)
+	if err := body.addChecksum(algorithm, wantChecksum); err != nil {
+		return nil, err
+	}
+
+	// The destination is sealed as the request or its bucket asks, whatever
+	// the source's encryption
+	sse, err := s.resolveEncryption(ctx, dstBucket, opts.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	stored, sealedKey, err := s.sealData(ctx, body, sse, opts.Encryption)
+	if err != nil {
+		return nil, err
+	}
+
 	// Copy to destination
-	dstMeta := &metadata.ObjectMetadata{
-		Key:             dstKey,
-		Bucket:          dstBucket,
-		Size:            srcMeta.Size,
-		ETag:            srcMeta.ETag,
-		ContentType:     srcMeta.ContentType,
-		ContentEncoding: srcMeta.ContentEncoding,
-		CacheControl:    srcMeta.CacheControl,
-		Metadata:        srcMeta.Metadata,
-		StorageClass:    srcMeta.StorageClass,
-		VersionID:       uuid.New().String(),
-		IsLatest:        true,
-		LastModified:    time.Now().Unix(),
+	target := s.newVersionTarget(ctx, dstBucket, dstKey)
+	if err := s.checkOverwrite(ctx, target); err != nil {
+		return nil, err
+	}
+	dstMeta := &target.meta
+	dstMeta.Size = srcMeta.Size
+	dstMeta.ETag = srcMeta.ETag
+	if sse != nil {
+		sse.SealedKey = sealedKey
+		dstMeta.Encryption = sse
 	}
+	applyHeaders(dstMeta, headers)
+	dstMeta.IsLatest = true
+	dstMeta.LastModified = time.Now().Unix()
 
 	// Write data to destination
-	putOpts := storage.PutOptions{
-		ContentType:     srcMeta.ContentType,
-		ContentEncoding: srcMeta.ContentEncoding,
-		CacheControl:    srcMeta.CacheControl,
-		Metadata:        srcMeta.Metadata,
-		StorageClass:    srcMeta.StorageClass,
-	}
-	if err := s.storage.Put(ctx, dstBucket, dstKey, data, srcMeta.Size, putOpts); err != nil {
+	dstDataBucket, dstDataKey := target.location()
+	if err := s.storage.Put(ctx, dstDataBucket, dstDataKey, stored, sealedSize(srcMeta.Size, sse), storageOptions(headers)); err != nil {
 		return nil, fmt.Errorf("failed to write destination object: %w", err)
 	}
+	dstMeta.ChecksumAlgorithm = algorithm
+	dstMeta.Checksum = body.Checksum()
+	if err := s.applyDefaultRetention(ctx, dstMeta); err != nil {
+		s.discardVersionData(ctx, target)
+		return nil, err
+	}
 
 	// Save metadata
 	if err := s.metadata.PutObject(ctx, dstBucket, dstKey, dstMeta); err != nil {
 		s.logger.Error("failed to save copy metadata", zap.Error(err))
+		s.discardVersionData(ctx, target)
+		return nil, fmt.Errorf("failed to save object metadata: %w", err)
 	}
 
 	return &CopyObjectResult{
-		ETag:         dstMeta.ETag,
-		LastModified: dstMeta.LastModified,
-		VersionID:    dstMeta.VersionID,
+		ETag:              dstMeta.ETag,
+		LastModified:      dstMeta.LastModified,
+		VersionID:         target.responseVersionID(),
+		SourceVersionID:   s.reportedVersionID(ctx, srcBucket, srcMeta.VersionID),
+		ChecksumAlgorithm: dstMeta.ChecksumAlgorithm,
+		Checksum:          dstMeta.Checksum,
+		Encryption:        reportedEncryption(sse),
 	}, nil
 }
 
@@ -239,18 +324,24 @@ func (s *ObjectService) GetObject(ctx context.Context, bucket, key string, opts
 	if err != nil {
 		return nil, fmt.Errorf("object not found: %s/%s", bucket, key)
 	}
+	if meta.IsDeleteMarker {
+		return nil, &DeleteMarkerError{VersionID: meta.VersionID, Explicit: opts.VersionID != ""}
+	}
 
-	// Convert storage options
-	storeOpts := storage.GetOptions{
-		Range: opts.Range,
-		IfMatch:           opts.IfMatch,
-		IfNoneMatch:       opts.IfNoneMatch,
-		IfModifiedSince:   opts.IfModifiedSince,
-		IfUnmodifiedSince: opts.IfUnmodifiedSince,
+	if err := checkConditions(opts.Conditions, meta.ETag, meta.LastModified); err != nil {
+		return nil, err
 	}
 
 	// Get the object - caller is responsible for closing
-	reader, err := s.storage.Get(ctx, bucket, key, storeOpts)
+	open, err := s.objectData(ctx, bucket, key, meta, opts.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	off, end := int64(0), meta.Size
+	if opts.Range != nil {
+		off, end = opts.Range.Start, opts.Range.End
+	}
+	reader, err := open(off, end)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get object: %w", err)
 	}
@@ -263,81 +354,201 @@ func (s *ObjectService) GetObject(ctx context.Context, bucket, key string, opts
 	telemetry.UpdateLatency("GetObject", time.Since(start).Seconds())
 	// Note: actual bytes downloaded would be tracked when the reader is read
 
+	// Whole-object reads are seekable so the API layer can serve byte ranges
+	if opts.Range == nil {
+		reader = newObjectReader(open, meta.Size, reader)
+	}
+
 	return &GetObjectResult{
-		Body:         reader,
-		Size:         meta.Size,
-		ETag:         meta.ETag,
-		ContentType:  meta.ContentType,
-		Metadata:     meta.Metadata,
-		LastModified: meta.LastModified,
-		VersionID:    meta.VersionID,
+		Body:               reader,
+		Size:               meta.Size,
+		ETag:               meta.ETag,
+		ContentType:        meta.ContentType,
+		ContentEncoding:    meta.ContentEncoding,
+		CacheControl:       meta.CacheControl,
+		ContentDisposition: meta.ContentDisposition,
+		ContentLanguage:    meta.ContentLanguage,
+		Expires:            meta.Expires,
+		Metadata:           meta.Metadata,
+		LastModified:       meta.LastModified,
+		VersionID:          s.reportedVersionID(ctx, bucket, meta.VersionID),
+		StorageClass:       meta.StorageClass,
+		ChecksumAlgorithm:  meta.ChecksumAlgorithm,
+		Checksum:           meta.Checksum,
+		Encryption:         reportedEncryption(meta.Encryption),
 	}, nil
 }
 
-// DeleteObject deletes an object
-func (s *ObjectService) DeleteObject(ctx context.Context, bucket, key string, opts DeleteObjectOptions) error {
+// DeleteObject deletes an object. Without a version ID, versioned buckets
+// get a delete marker instead of losing data; with one, that version is
+// removed permanently.
+func (s *ObjectService) DeleteObject(ctx context.Context, bucket, key string, opts DeleteObjectOptions) (*DeleteObjectResult, error) {
 	// Lock the object
 	unlock := s.locker.Lock(bucket, key)
 	defer unlock()
 
 	// Check bucket exists
 	if _, err := s.metadata.GetBucket(ctx, bucket); err != nil {
-		return fmt.Errorf("bucket not found: %s", bucket)
+		return nil, fmt.Errorf("bucket not found: %s", bucket)
 	}
 
-	// Delete from storage
-	if err := s.storage.Delete(ctx, bucket, key); err != nil {
-		return fmt.Errorf("failed to delete object: %w", err)
+	if opts.VersionID != "" {
+		return s.deleteVersion(ctx, bucket, key, opts)
 	}
 
-	// Delete metadata
-	if err := s.metadata.DeleteObject(ctx, bucket, key, opts.VersionID); err != nil {
-		s.logger.Warn("failed to delete metadata", zap.Error(err))
+	target := s.newVersionTarget(ctx, bucket, key)
+	if target.status == "" {
+		if existing, err := s.metadata.GetObject(ctx, bucket, key, ""); err == nil {
+			if err := checkObjectLock(existing, opts.BypassGovernanceRetention); err != nil {
+				return nil, err
+			}
+		}
+
+		// Delete metadata first so a failure never leaves an object whose
+		// data is gone
+		if err := s.metadata.DeleteObject(ctx, bucket, key, ""); err != nil {
+			return nil, fmt.Errorf("failed to delete object metadata: %w", err)
+		}
+
+		// Data left behind is an orphan for fsck
+		if err := s.storage.Delete(ctx, bucket, key); err != nil {
+			s.logger.Warnw("failed to delete object data", "bucket", bucket, "key", key, "error", err)
+		}
+
+		// Update telemetry metrics
+		telemetry.DecBucketObjects(bucket)
+		telemetry.DecTotalObjects()
+		telemetry.IncOperation("DeleteObject")
+		telemetry.OperationsTotal.WithLabelValues("DeleteObject", "success").Inc()
+		telemetry.OperationDuration.WithLabelValues("DeleteObject", "success").Observe(0) // Quick operation
+
+		return &DeleteObjectResult{}, nil
+	}
+
+	// In suspended buckets the marker replaces the null version and its data
+	var replaced *metadata.ObjectMetadata
+	if target.status == VersioningSuspended {
+		if existing, err := s.metadata.GetObject(ctx, bucket, key, metadata.NullVersionID); err == nil && !existing.IsDeleteMarker {
+			replaced = existing
+		}
+	}
+	if replaced != nil {
+		if err := checkObjectLock(replaced, opts.BypassGovernanceRetention); err != nil {
+			return nil, err
+		}
+	}
+
+	marker := &target.meta
+	marker.IsDeleteMarker = true
+	marker.VersionedData = false
+	marker.IsLatest = true
+	marker.LastModified = time.Now().Unix()
+	if err := s.metadata.PutObject(ctx, bucket, key, marker); err != nil {
+		return nil, fmt.Errorf("failed to create delete marker: %w", err)
+	}
+
+	if replaced != nil {
+		dataBucket, dataKey := dataLocation(bucket, key, replaced)
+		if err := s.storage.Delete(ctx, dataBucket, dataKey); err != nil {
+			s.logger.Warnw("failed to delete null version data", "bucket", dataBucket, "key", dataKey, "error", err)
+		}
 	}
 
-	// Update telemetry metrics
-	telemetry.DecBucketObjects(bucket)
-	telemetry.DecTotalObjects()
 	telemetry.IncOperation("DeleteObject")
 	telemetry.OperationsTotal.WithLabelValues("DeleteObject", "success").Inc()
-	telemetry.OperationDuration.WithLabelValues("DeleteObject", "success").Observe(0) // Quick operation
 
-	return nil
+	return &DeleteObjectResult{VersionID: marker.VersionID, DeleteMarker: true}, nil
+}
+
+// deleteVersion permanently removes one version of an object. Deleting a
+// version that does not exist succeeds, as in S3; delete markers are never
+// protected by object lock.
+func (s *ObjectService) deleteVersion(ctx context.Context, bucket, key string, opts DeleteObjectOptions) (*DeleteObjectResult, error) {
+	versionID := opts.VersionID
+	meta, err := s.metadata.GetObject(ctx, bucket, key, versionID)
+	if err != nil {
+		return &DeleteObjectResult{VersionID: versionID}, nil
+	}
+	if !meta.IsDeleteMarker {
+		if err := checkObjectLock(meta, opts.BypassGovernanceRetention); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.metadata.DeleteObject(ctx, bucket, key, versionID); err != nil {
+		return nil, fmt.Errorf("failed to delete version metadata: %w", err)
+	}
+
+	// Data left behind is an orphan for fsck
+	if !meta.IsDeleteMarker {
+		dataBucket, dataKey := dataLocation(bucket, key, meta)
+		if err := s.storage.Delete(ctx, dataBucket, dataKey); err != nil {
+			s.logger.Warnw("failed to delete object version data", "bucket", dataBucket, "key", dataKey, "error", err)
+		}
+	}
+
+	telemetry.IncOperation("DeleteObject")
+	telemetry.OperationsTotal.WithLabelValues("DeleteObject", "success").Inc()
+
+	return &DeleteObjectResult{VersionID: versionID, DeleteMarker: meta.IsDeleteMarker}, nil
 }
 
 // HeadObject returns object metadata without reading the body
-func (s *ObjectService) HeadObject(ctx context.Context, bucket, key string) (*ObjectInfo, error) {
+func (s *ObjectService) HeadObject(ctx context.Context, bucket, key string, opts HeadObjectOptions) (*ObjectInfo, error) {
 	// Check bucket exists
 	if _, err := s.metadata.GetBucket(ctx, bucket); err != nil {
 		return nil, fmt.Errorf("bucket not found: %s", bucket)
 	}
 
 	// Get metadata
-	meta, err := s.metadata.GetObject(ctx, bucket, key, "")
+	meta, err := s.metadata.GetObject(ctx, bucket, key, opts.VersionID)
 	if err != nil {
 		return nil, fmt.Errorf("object not found: %s/%s", bucket, key)
 	}
 
+	if meta.IsDeleteMarker {
+		return nil, &DeleteMarkerError{VersionID: meta.VersionID, Explicit: opts.VersionID != ""}
+	}
+	if err := checkCustomerKey(meta.Encryption, opts.Encryption); err != nil {
+		return nil, err
+	}
+
 	// Also get from storage to ensure it exists
-	storageMeta, err := s.storage.Head(ctx, bucket, key)
+	dataBucket, dataKey := dataLocation(bucket, key, meta)
+	storageMeta, err := s.storage.Head(ctx, dataBucket, dataKey)
 	if err != nil {
 		return nil, fmt.Errorf("object not found: %s/%s", bucket, key)
 	}
 
+	lastModified := meta.LastModified
+	if lastModified == 0 {
+		lastModified = storageMeta.LastModified
+	}
+
+	if err := checkConditions(opts.Conditions, meta.ETag, lastModified); err != nil {
+		return nil, err
+	}
+
 	// Update telemetry metrics
 	telemetry.OperationsTotal.WithLabelValues("HeadObject", "success").Inc()
 
 	return &ObjectInfo{
-		Key:             key,
-		Size:            meta.Size,
-		ETag:            meta.ETag,
-		ContentType:     meta.ContentType,
-		ContentEncoding: meta.ContentEncoding,
-		CacheControl:    meta.CacheControl,
-		Metadata:        meta.Metadata,
-		StorageClass:    meta.StorageClass,
-		LastModified:    storageMeta.LastModified,
-		VersionID:       meta.VersionID,
+		Key:                key,
+		Size:               meta.Size,
+		ETag:               meta.ETag,
+		ContentType:        meta.ContentType,
+		ContentEncoding:    meta.ContentEncoding,
+		CacheControl:       meta.CacheControl,
+		ContentDisposition: meta.ContentDisposition,
+		ContentLanguage:    meta.ContentLanguage,
+		Expires:            meta.Expires,
+		Metadata:           meta.Metadata,
+		StorageClass:       meta.StorageClass,
+		LastModified:       lastModified,
+		VersionID:          s.reportedVersionID(ctx, bucket, meta.VersionID),
+		ChecksumAlgorithm:  meta.ChecksumAlgorithm,
+		Checksum:           meta.Checksum,
+		Encryption:         reportedEncryption(meta.Encryption),
 	}, nil
 }
 
@@ -353,152 +564,158 @@ func (s *ObjectService) GetObjectAttributes(ctx context.Context, bucket, key, ve
 	if err != nil {
 		return nil, fmt.Errorf("object not found: %s/%s", bucket, key)
 	}
+	if meta.IsDeleteMarker {
+		return nil, &DeleteMarkerError{VersionID: meta.VersionID, Explicit: versionID != ""}
+	}
 
 	// Get storage info
-	storageMeta, err := s.storage.Head(ctx, bucket, key)
+	dataBucket, dataKey := dataLocation(bucket, key, meta)
+	storageMeta, err := s.storage.Head(ctx, dataBucket, dataKey)
 	if err != nil {
 		return nil, fmt.Errorf("object not found: %s/%s", bucket, key)
 	}
 
-	// Get parts if this is a multipart upload
-	var parts []metadata.PartMetadata
-	if len(meta.Parts) > 0 {
-		parts, err = s.metadata.ListParts(ctx, bucket, key, "")
-		if err != nil {
-			parts = nil // Ignore error, parts are optional
-		}
+	lastModified := meta.LastModified
+	if lastModified == 0 {
+		lastModified = storageMeta.LastModified
 	}
 
 	return &ObjectAttributes{
-		ETag:                meta.ETag,
-		Size:                meta.Size,
-		LastModified:        storageMeta.LastModified,
-		VersionID:           meta.VersionID,
-		StorageClass:        meta.StorageClass,
-		ContentType:         meta.ContentType,
-		ContentEncoding:     meta.ContentEncoding,
-		Metadata:            meta.Metadata,
-		Parts:               parts,
+		ETag:              meta.ETag,
+		Size:              meta.Size,
+		LastModified:      lastModified,
+		VersionID:         s.reportedVersionID(ctx, bucket, meta.VersionID),
+		StorageClass:      meta.StorageClass,
+		ContentType:       meta.ContentType,
+		ContentEncoding:   meta.ContentEncoding,
+		Metadata:          meta.Metadata,
+		Parts:             meta.Parts,
+		ChecksumAlgorithm: meta.ChecksumAlgorithm,
+		Checksum:          meta.Checksum,
 	}, nil
 }
 
 // ObjectAttributes represents object attributes
 type ObjectAttributes struct {
-	ETag                string
-	Size                int64
-	LastModified        int64
-	VersionID           string
-	StorageClass        string
-	ContentType         string
-	ContentEncoding     string
-	Metadata            map[string]string
-	Parts               []metadata.PartMetadata
-}
-
-// SelectObjectContentResult contains the result of a select query
-type SelectObjectContentResult struct {
-	Body        string
-	BytesScanned int64
-	BytesReturned int64
-}
-
-// SelectObjectContent performs a select query on object data
-func (s *ObjectService) SelectObjectContent(ctx context.Context, bucket, key, expression string) (*SelectObjectContentResult, error) {
-	// Check bucket exists
-	if _, err := s.metadata.GetBucket(ctx, bucket); err != nil {
-		return nil, fmt.Errorf("bucket not found: %s", bucket)
-	}
-
-	// Get object
-	_, err := s.metadata.GetObject(ctx, bucket, key, "")
+	ETag              string
+	Size              int64
+	LastModified      int64
+	VersionID         string
+	StorageClass      string
+	ContentType       string
+	ContentEncoding   string
+	Metadata          map[string]string
+	Parts             []metadata.PartInfo // parts of a multipart object, nil otherwise
+	ChecksumAlgorithm string
+	Checksum          string
+}
+
+// SelectObjectContent runs an S3 Select query over the latest version of
+// an object, streaming output records to out as they are produced, and
+// returns the statistics of the query. Enc supplies the customer key of
+// SSE-C objects and the authorizer of SSE-KMS ones.
+func (s *ObjectService) SelectObjectContent(ctx context.Context, bucket, key string, enc Encryption, req *s3select.SelectRequest, out s3select.ResultWriter) (*s3select.SelectStats, error) {
+	obj, err := s.GetObject(ctx, bucket, key, GetObjectOptions{Encryption: enc})
 	if err != nil {
-		return nil, fmt.Errorf("object not found: %s/%s", bucket, key)
+		return nil, err
 	}
+	defer obj.Body.Close()
 
-	// Get the object data from storage
-	data, err := s.storage.Get(ctx, bucket, key, storage.GetOptions{})
-	if err != nil {
-		return nil, fmt.Errorf("failed to read object: %w", err)
-	}
-	defer data.Close()
-
-	// Read all data
-	content, err := io.ReadAll(data)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read object data: %w", err)
-	}
-
-	// For now, implement a simple CSV/JSON filter
-	// In production, this would be a full SQL engine
-	result := s.processSelectExpression(string(content), expression)
-
-	return &SelectObjectContentResult{
-		Body:        result,
-		BytesScanned: int64(len(content)),
-		BytesReturned: int64(len(result)),
-	}, nil
+	return s3select.NewSelectService(s.logger.Desugar()).Stream(ctx, req, obj.Body, out)
 }
 
-// processSelectExpression processes a simple select expression
-// This is a simplified implementation - production would use a proper SQL engine
-func (s *ObjectService) processSelectExpression(content, expression string) string {
-	// Simple implementation: return content as-is for now
-	// A full implementation would parse SQL-like expressions
-	return content
-}
-
-// ListObjects lists objects in a bucket
+// ListObjects lists the latest version of the objects in a bucket in key
+// order, straight from the metadata index. Keys whose latest version is a
+// delete marker are left out. A page resumes after Marker, which may be a
+// key or a common prefix.
 func (s *ObjectService) ListObjects(ctx context.Context, bucket string, opts ListObjectsOptions) (*ListObjectsResult, error) {
 	// Check bucket exists
 	if _, err := s.metadata.GetBucket(ctx, bucket); err != nil {
 		return nil, fmt.Errorf("bucket not found: %s", bucket)
 	}
 
-	// Convert options
-	storeOpts := storage.ListOptions{
+	start := time.Now()
+	listing, err := s.metadata.ListObjects(ctx, bucket, metadata.ListOptions{
 		Prefix:    opts.Prefix,
 		Delimiter: opts.Delimiter,
 		MaxKeys:   opts.MaxKeys,
 		Marker:    opts.Marker,
-	}
-
-	// List from storage
-	result, err := s.storage.List(ctx, bucket, opts.Prefix, storeOpts)
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to list objects: %w", err)
 	}
 
 	// Update telemetry metrics
-	start := time.Now()
 	telemetry.IncOperation("ListObjects")
 	telemetry.OperationsTotal.WithLabelValues("ListObjects", "success").Inc()
 	telemetry.OperationDuration.WithLabelValues("ListObjects", "success").Observe(time.Since(start).Seconds())
 
-	// Convert to results
-	var objectInfos []ObjectInfo
-	for _, obj := range result.Objects {
-		objectInfos = append(objectInfos, ObjectInfo{
+	objects := make([]ObjectInfo, 0, len(listing.Objects))
+	for _, obj := range listing.Objects {
+		objects = append(objects, ObjectInfo{
 			Key:          obj.Key,
 			Size:         obj.Size,
 			ETag:         obj.ETag,
+			ContentType:  obj.ContentType,
+			StorageClass: obj.StorageClass,
 			LastModified: obj.LastModified,
+			VersionID:    obj.VersionID,
+			IsLatest:     true,
 		})
 	}
 
-	// Get next marker
-	var nextMarker string
-	if len(objectInfos) > 0 {
-		nextMarker = objectInfos[len(objectInfos)-1].Key
-	}
-
 	return &ListObjectsResult{
-		Objects:        objectInfos,
-		CommonPrefixes: result.CommonPrefixes,
+		Objects:        objects,
+		CommonPrefixes: listing.CommonPrefixes,
 		Prefix:         opts.Prefix,
 		Delimiter:      opts.Delimiter,
 		MaxKeys:        opts.MaxKeys,
-		NextMarker:     nextMarker,
-		IsTruncated:    len(objectInfos) == opts.MaxKeys,
+		NextMarker:     listing.NextMarker,
+		IsTruncated:    listing.IsTruncated,
+	}, nil
+}
+
+// ListObjectVersions lists every version and delete marker of the objects
+// in a bucket, by key and then newest version first
+func (s *ObjectService) ListObjectVersions(ctx context.Context, bucket string, opts ListObjectVersionsOptions) (*ListObjectVersionsResult, error) {
+	// Check bucket exists
+	if _, err := s.metadata.GetBucket(ctx, bucket); err != nil {
+		return nil, fmt.Errorf("bucket not found: %s", bucket)
+	}
+
+	listing, err := s.metadata.ListObjectVersions(ctx, bucket, metadata.ListOptions{
+		Prefix:          opts.Prefix,
+		Delimiter:       opts.Delimiter,
+		MaxKeys:         opts.MaxKeys,
+		Marker:          opts.KeyMarker,
+		VersionIDMarker: opts.VersionIDMarker,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list object versions: %w", err)
+	}
+
+	telemetry.IncOperation("ListObjectVersions")
+
+	versions := make([]ObjectInfo, 0, len(listing.Versions))
+	for _, v := range listing.Versions {
+		versions = append(versions, ObjectInfo{
+			Key:            v.Key,
+			Size:           v.Size,
+			ETag:           v.ETag,
+			StorageClass:   v.StorageClass,
+			LastModified:   v.LastModified,
+			VersionID:      v.VersionID,
+			IsLatest:       v.IsLatest,
+			IsDeleteMarker: v.IsDeleteMarker,
+		})
+	}
+
+	return &ListObjectVersionsResult{
+		Versions:            versions,
+		CommonPrefixes:      listing.CommonPrefixes,
+		IsTruncated:         listing.IsTruncated,
+		NextKeyMarker:       listing.NextKeyMarker,
+		NextVersionIDMarker: listing.NextVersionIDMarker,
 	}, nil
 }
 
@@ -537,6 +754,11 @@ func (s *ObjectService) DeleteBucket(ctx context.Context, bucket string) error {
 		return fmt.Errorf("bucket not empty: %s", bucket)
 	}
 
+	// Noncurrent versions keep a versioned bucket non-empty as well
+	if versions, err := s.storage.List(ctx, versionsBucket, bucket+"/", storage.ListOptions{MaxKeys: 1}); err == nil && len(versions.Objects) > 0 {
+		return fmt.Errorf("bucket not empty: %s", bucket)
+	}
+
 	// Delete from storage
 	if err := s.storage.DeleteBucket(ctx, bucket); err != nil {
 		return fmt.Errorf("failed to delete bucket: %w", err)
@@ -599,16 +821,31 @@ func (s *ObjectService) BucketExists(ctx context.Context, bucket string) (bool,
 
 // CreateMultipartUpload initiates a multipart upload
 func (s *ObjectService) CreateMultipartUpload(ctx context.Context, bucket, key string, opts PutObjectOptions) (*CreateMultipartUploadResult, error) {
+	if opts.ChecksumAlgorithm != "" && !ValidChecksumAlgorithm(opts.ChecksumAlgorithm) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidChecksum, opts.ChecksumAlgorithm)
+	}
+
+	// Every part and the completed object get the upload's encryption
+	sse, err := s.resolveEncryption(ctx, bucket, opts.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	// Parts are sealed later, so refuse an unusable KMS key now
+	if err := s.checkKMSKey(ctx, sse, opts.Encryption); err != nil {
+		return nil, err
+	}
+
 	// Generate upload ID
 	uploadID := uuid.New().String()
 
 	// Create metadata
 	meta := &metadata.ObjectMetadata{
-		Key:         key,
-		Bucket:      bucket,
-		ContentType: opts.ContentType,
-		Metadata:    opts.Metadata,
+		Key:               key,
+		Bucket:            bucket,
+		ChecksumAlgorithm: opts.ChecksumAlgorithm,
+		Encryption:        sse,
 	}
+	applyHeaders(meta, opts)
 
 	// Save to metadata
 	if err := s.metadata.CreateMultipartUpload(ctx, bucket, key, uploadID, meta); err != nil {
@@ -616,9 +853,10 @@ func (s *ObjectService) CreateMultipartUpload(ctx context.Context, bucket, key s
 	}
 
 	return &CreateMultipartUploadResult{
-		UploadID: uploadID,
-		Key:      key,
-		Bucket:   bucket,
+		UploadID:   uploadID,
+		Key:        key,
+		Bucket:     bucket,
+		Encryption: reportedEncryption(sse),
 	}, nil
 }
 
@@ -631,154 +869,231 @@ func (s *ObjectService) InitiateMultipartUpload(ctx context.Context, bucket, key
 }
 
 // UploadPart uploads a part
-func (s *ObjectService) UploadPart(ctx context.Context, bucket, key, uploadID string, partNumber int, data io.Reader) (*UploadPartResult, error) {
-	// Calculate size and hash
-	hasher := sha256.New()
-	size, err := io.Copy(hasher, data)
+func (s *ObjectService) UploadPart(ctx context.Context, bucket, key, uploadID string, partNumber int, data io.Reader, opts UploadPartOptions) (*UploadPartResult, error) {
+	upload, err := s.findUpload(ctx, bucket, key, uploadID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read data: %w", err)
+		return nil, err
 	}
+	return s.stagePart(ctx, upload, partNumber, data, opts)
+}
 
-	// Reset reader if it implements Seeker
-	if seeker, ok := data.(io.Seeker); ok {
-		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
-			s.logger.Warn("failed to seek reader", zap.Error(err))
+// stagePart writes the data of a part of upload to the staging area and
+// records its metadata
+func (s *ObjectService) stagePart(ctx context.Context, upload *metadata.MultipartUploadMetadata, partNumber int, data io.Reader, opts UploadPartOptions) (*UploadPartResult, error) {
+	// Every part carries the upload's checksum so completion can combine them
+	algorithm := upload.ChecksumAlgorithm
+	if opts.ChecksumAlgorithm != "" {
+		if algorithm != "" && opts.ChecksumAlgorithm != algorithm {
+			return nil, fmt.Errorf("%w: part checksum %s, upload uses %s", ErrInvalidChecksum, opts.ChecksumAlgorithm, algorithm)
 		}
+		algorithm = opts.ChecksumAlgorithm
 	}
 
-	// Generate ETag
-	etag := fmt.Sprintf("\"%s\"", hex.EncodeToString(hasher.Sum(nil)))
-
-	// Store part data
-	partKey := fmt.Sprintf("%s/%s/%s/%d", bucket, key, uploadID, partNumber)
-	storeOpts := storage.PutOptions{}
-	if err := s.storage.Put(ctx, bucket, partKey, data, size, storeOpts); err != nil {
+	// Stage the part outside the bucket, computing its MD5 ETag on the way
+	body := newHashingReader(data, 0, s.maxObjectSize)
+	if err := body.addChecksum(algorithm, opts.Checksum); err != nil {
+		return nil, err
+	}
+	// Parts of an encrypted upload are each sealed under their own data key
+	stored, sealedKey, err := s.sealData(ctx, body, upload.Encryption, opts.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.storage.PutPart(ctx, upload.UploadID, partNumber, stored, 0); err != nil {
 		return nil, fmt.Errorf("failed to store part: %w", err)
 	}
+	size := body.Size()
+	etag := body.ETag()
 
 	// Save part metadata
 	partMeta := &metadata.PartMetadata{
-		UploadID:   uploadID,
-		Key:        key,
-		Bucket:     bucket,
-		PartNumber: partNumber,
-		ETag:       etag,
-		Size:       size,
-	}
-
-	if err := s.metadata.PutPart(ctx, bucket, key, uploadID, partNumber, partMeta); err != nil {
+		UploadID:          upload.UploadID,
+		Key:               upload.Key,
+		Bucket:            upload.Bucket,
+		PartNumber:        partNumber,
+		ETag:              etag,
+		Size:              size,
+		LastModified:      time.Now().Unix(),
+		ChecksumAlgorithm: algorithm,
+		Checksum:          body.Checksum(),
+		SealedKey:         sealedKey,
+	}
+
+	if err := s.metadata.PutPart(ctx, upload.Bucket, upload.Key, upload.UploadID, partNumber, partMeta); err != nil {
 		s.logger.Error("failed to save part metadata", zap.Error(err))
 	}
 
 	return &UploadPartResult{
-		ETag:       etag,
-		PartNumber: partNumber,
-		Size:       size,
+		ETag:              etag,
+		PartNumber:        partNumber,
+		Size:              size,
+		ChecksumAlgorithm: partMeta.ChecksumAlgorithm,
+		Checksum:          partMeta.Checksum,
+		Encryption:        reportedEncryption(upload.Encryption),
 	}, nil
 }
 
 // PutPart is an alias for UploadPart
 func (s *ObjectService) PutPart(ctx context.Context, bucket, key, uploadID string, partNumber int, data []byte) error {
 	reader := bytes.NewReader(data)
-	_, err := s.UploadPart(ctx, bucket, key, uploadID, partNumber, reader)
+	_, err := s.UploadPart(ctx, bucket, key, uploadID, partNumber, reader, UploadPartOptions{})
 	return err
 }
 
-// CompleteMultipartUpload completes a multipart upload
+// CompleteMultipartUpload completes a multipart upload by concatenating the
+// requested parts, which must have been uploaded with matching ETags and be
+// listed in ascending order
 func (s *ObjectService) CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, parts []PartInfo) (*ObjectResult, error) {
 	// Lock the object
 	unlock := s.locker.Lock(bucket, key)
 	defer unlock()
 
+	upload, err := s.findUpload(ctx, bucket, key, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	headers := uploadHeaders(upload)
+
 	// Get parts from metadata
 	partMetas, err := s.metadata.ListParts(ctx, bucket, key, uploadID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list parts: %w", err)
 	}
 
-	// Sort parts by part number
-	for i := 0; i < len(partMetas)-1; i++ {
-		for j := i + 1; j < len(partMetas); j++ {
-			if partMetas[i].PartNumber > partMetas[j].PartNumber {
-				partMetas[i], partMetas[j] = partMetas[j], partMetas[i]
-			}
-		}
+	selected, err := s.selectParts(parts, partMetas)
+	if err != nil {
+		return nil, err
 	}
 
-	// Read all parts and concatenate into final object
-	var totalSize int64
-	var allData []byte
-	for _, p := range partMetas {
-		partKey := fmt.Sprintf("%s/%s/%s/%d", bucket, key, uploadID, p.PartNumber)
-		reader, err := s.storage.Get(ctx, bucket, partKey, storage.GetOptions{})
-		if err != nil {
-			return nil, fmt.Errorf("failed to read part %d: %w", p.PartNumber, err)
-		}
-		data, err := io.ReadAll(io.LimitReader(reader, MaxUploadSize+1))
-		reader.Close()
-		if err != nil {
-			return nil, fmt.Errorf("failed to read part data: %w", err)
-		}
-		allData = append(allData, data...)
-		totalSize += int64(len(data))
+	etag, err := multipartETag(selected)
+	if err != nil {
+		return nil, err
+	}
+	checksum, err := compositeChecksum(upload.ChecksumAlgorithm, selected)
+	if err != nil {
+		return nil, err
 	}
 
-	// Write final object to storage
-	storeOpts := storage.PutOptions{}
-	if err := s.storage.Put(ctx, bucket, key, bytes.NewReader(allData), totalSize, storeOpts); err != nil {
+	// Stream the parts into the final object; encrypted parts are copied
+	// still sealed, each under its own data key
+	var totalSize, storedLength int64
+	for _, p := range selected {
+		totalSize += p.Size
+		storedLength += sealedSize(p.Size, upload.Encryption)
+	}
+	body := newPartsReader(ctx, s.storage, uploadID, selected)
+	defer body.Close()
+
+	target := s.newVersionTarget(ctx, bucket, key)
+	if err := s.checkOverwrite(ctx, target); err != nil {
+		return nil, err
+	}
+	dataBucket, dataKey := target.location()
+	if err := s.storage.Put(ctx, dataBucket, dataKey, body, storedLength, storageOptions(headers)); err != nil {
 		return nil, fmt.Errorf("failed to write final object: %w", err)
 	}
 
 	// Create final object metadata
 	now := time.Now().Unix()
-	etag := fmt.Sprintf("\"%s\"", uuid.New().String())
-
-	objMeta := &metadata.ObjectMetadata{
-		Key:          key,
-		Bucket:       bucket,
-		Size:         totalSize,
-		ETag:         etag,
-		VersionID:    uuid.New().String(),
-		IsLatest:    true,
-		LastModified: now,
-		Parts:        convertToMetadataParts(parts),
+	objMeta := &target.meta
+	objMeta.Size = totalSize
+	objMeta.ETag = etag
+	objMeta.Encryption = upload.Encryption
+	if checksum != "" {
+		objMeta.ChecksumAlgorithm = upload.ChecksumAlgorithm
+		objMeta.Checksum = checksum
+	}
+	applyHeaders(objMeta, headers)
+	objMeta.IsLatest = true
+	objMeta.LastModified = now
+	objMeta.Parts = make([]metadata.PartInfo, len(selected))
+	for i, p := range selected {
+		objMeta.Parts[i] = metadata.PartInfo{PartNumber: p.PartNumber, ETag: p.ETag, Size: p.Size, Checksum: p.Checksum, SealedKey: p.SealedKey}
+	}
+	if err := s.applyDefaultRetention(ctx, objMeta); err != nil {
+		s.discardVersionData(ctx, target)
+		return nil, err
 	}
 
 	// Save final object metadata
 	if err := s.metadata.PutObject(ctx, bucket, key, objMeta); err != nil {
+		s.discardVersionData(ctx, target)
 		return nil, fmt.Errorf("failed to save metadata: %w", err)
 	}
 
 	// Complete multipart upload (cleanup)
-	if err := s.metadata.CompleteMultipartUpload(ctx, bucket, key, uploadID, convertToMetadataParts(parts)); err != nil {
+	if err := s.metadata.CompleteMultipartUpload(ctx, bucket, key, uploadID, objMeta.Parts); err != nil {
 		s.logger.Warn("failed to cleanup multipart upload", zap.Error(err))
 	}
 
-	// Clean up part files from storage
-	for _, p := range partMetas {
-		partKey := fmt.Sprintf("%s/%s/%s/%d", bucket, key, uploadID, p.PartNumber)
-		if err := s.storage.Delete(ctx, bucket, partKey); err != nil {
-			s.logger.Warnw("failed to delete part file", "partKey", partKey, "error", err)
-		}
+	// Clean up every staged part, including ones left out of the object
+	if err := s.storage.DeleteUpload(ctx, uploadID); err != nil {
+		s.logger.Warnw("failed to delete staged parts", "uploadID", uploadID, "error", err)
 	}
 
 	return &ObjectResult{
-		ETag:         etag,
-		Size:         totalSize,
-		VersionID:    objMeta.VersionID,
-		LastModified: now,
+		ETag:              etag,
+		Size:              objMeta.Size,
+		VersionID:         target.responseVersionID(),
+		LastModified:      now,
+		ChecksumAlgorithm: objMeta.ChecksumAlgorithm,
+		Checksum:          objMeta.Checksum,
+		Encryption:        reportedEncryption(upload.Encryption),
 	}, nil
 }
 
+// selectParts validates the parts requested for completion against the
+// uploaded ones and returns the uploaded part metadata in object order
+func (s *ObjectService) selectParts(requested []PartInfo, uploaded []metadata.PartMetadata) ([]metadata.PartMetadata, error) {
+	if len(requested) == 0 {
+		return nil, fmt.Errorf("%w: no parts specified", ErrInvalidPart)
+	}
+
+	byNumber := make(map[int]metadata.PartMetadata, len(uploaded))
+	for _, p := range uploaded {
+		byNumber[p.PartNumber] = p
+	}
+
+	for i := 1; i < len(requested); i++ {
+		if requested[i].PartNumber <= requested[i-1].PartNumber {
+			return nil, fmt.Errorf("%w: part %d follows part %d", ErrInvalidPartOrder, requested[i].PartNumber, requested[i-1].PartNumber)
+		}
+	}
+
+	selected := make([]metadata.PartMetadata, 0, len(requested))
+	for _, r := range requested {
+		p, ok := byNumber[r.PartNumber]
+		if !ok || normalizeETag(p.ETag) != normalizeETag(r.ETag) {
+			return nil, fmt.Errorf("%w: part %d", ErrInvalidPart, r.PartNumber)
+		}
+		if r.Checksum != "" && r.Checksum != p.Checksum {
+			return nil, fmt.Errorf("%w: part %d checksum does not match", ErrInvalidPart, r.PartNumber)
+		}
+		selected = append(selected, p)
+	}
+
+	// Every part but the last must reach the minimum size
+	for _, p := range selected[:len(selected)-1] {
+		if p.Size < s.minPartSize {
+			return nil, fmt.Errorf("%w: part %d is %d bytes", ErrEntityTooSmall, p.PartNumber, p.Size)
+		}
+	}
+
+	return selected, nil
+}
+
 // AbortMultipartUpload aborts a multipart upload
 func (s *ObjectService) AbortMultipartUpload(ctx context.Context, bucket, key, uploadID string) error {
-	// Delete all parts from storage
-	partMetas, err := s.metadata.ListParts(ctx, bucket, key, uploadID)
-	if err == nil {
-		for _, p := range partMetas {
-			partKey := fmt.Sprintf("%s/%s/%s/%d", bucket, key, uploadID, p.PartNumber)
-			s.storage.Delete(ctx, bucket, partKey)
-		}
+	// The upload ID alone names the staged parts, so make sure it belongs
+	// to this bucket and key before deleting anything
+	if _, err := s.findUpload(ctx, bucket, key, uploadID); err != nil {
+		return err
+	}
+
+	// Delete staged parts before the metadata, so a failure leaves the
+	// upload listed and collectable
+	if err := s.storage.DeleteUpload(ctx, uploadID); err != nil {
+		return fmt.Errorf("failed to delete staged parts: %w", err)
 	}
 
 	// Delete metadata
@@ -821,6 +1136,10 @@ func (s *ObjectService) ListParts(ctx context.Context, bucket, key, uploadID str
 		parts = append(parts, PartInfo{
 			PartNumber: pm.PartNumber,
 			ETag:       pm.ETag,
+			Size:       pm.Size,
+			Checksum:   pm.Checksum,
+
+			ChecksumAlgorithm: pm.ChecksumAlgorithm,
 		})
 	}
 
@@ -842,8 +1161,18 @@ func (s *ObjectService) DeleteLifecycleRule(ctx context.Context, bucket, ruleID
 	return s.metadata.DeleteLifecycleRule(ctx, bucket, ruleID)
 }
 
-// PutBucketVersioning sets bucket versioning
+// PutBucketVersioning sets bucket versioning. Buckets with object lock
+// enabled must keep versioning enabled.
 func (s *ObjectService) PutBucketVersioning(ctx context.Context, bucket string, versioning *metadata.BucketVersioning) error {
+	if versioning != nil && versioning.Status != VersioningEnabled {
+		config, err := s.metadata.GetObjectLock(ctx, bucket)
+		if err != nil {
+			return fmt.Errorf("failed to get object lock configuration: %w", err)
+		}
+		if config != nil && config.Enabled {
+			return fmt.Errorf("%w: object lock is enabled on bucket %s", ErrVersioningRequired, bucket)
+		}
+	}
 	return s.metadata.PutBucketVersioning(ctx, bucket, versioning)
 }
 
@@ -924,6 +1253,10 @@ func (s *ObjectService) PutBucketEncryption(ctx context.Context, bucket string,
 	if encryption == nil {
 		return fmt.Errorf("encryption is required")
 	}
+	// The default must be one every later write can apply
+	if err := s.checkDefaultEncryption(ctx, &encryption.Rule.Apply); err != nil {
+		return err
+	}
 	return s.metadata.PutBucketEncryption(ctx, bucket, encryption)
 }
 
@@ -971,44 +1304,11 @@ func (s *ObjectService) DeleteBucketTags(ctx context.Context, bucket string) err
 	return s.metadata.DeleteBucketTags(ctx, bucket)
 }
 
-// PutObjectLock sets object lock configuration for a bucket
-func (s *ObjectService) PutObjectLock(ctx context.Context, bucket string, config *metadata.ObjectLockConfig) error {
-	if config == nil {
-		return fmt.Errorf("object lock configuration is required")
-	}
-	return s.metadata.PutObjectLock(ctx, bucket, config)
-}
-
 // GetObjectLock gets object lock configuration for a bucket
 func (s *ObjectService) GetObjectLock(ctx context.Context, bucket string) (*metadata.ObjectLockConfig, error) {
 	return s.metadata.GetObjectLock(ctx, bucket)
 }
 
-// DeleteObjectLock deletes object lock configuration
-func (s *ObjectService) DeleteObjectLock(ctx context.Context, bucket string) error {
-	return s.metadata.DeleteObjectLock(ctx, bucket)
-}
-
-// PutObjectRetention sets object retention
-func (s *ObjectService) PutObjectRetention(ctx context.Context, bucket, key string, retention *metadata.ObjectRetention) error {
-	return s.metadata.PutObjectRetention(ctx, bucket, key, retention)
-}
-
-// GetObjectRetention gets object retention
-func (s *ObjectService) GetObjectRetention(ctx context.Context, bucket, key string) (*metadata.ObjectRetention, error) {
-	return s.metadata.GetObjectRetention(ctx, bucket, key)
-}
-
-// PutObjectLegalHold sets object legal hold
-func (s *ObjectService) PutObjectLegalHold(ctx context.Context, bucket, key string, legalHold *metadata.ObjectLegalHold) error {
-	return s.metadata.PutObjectLegalHold(ctx, bucket, key, legalHold)
-}
-
-// GetObjectLegalHold gets object legal hold
-func (s *ObjectService) GetObjectLegalHold(ctx context.Context, bucket, key string) (*metadata.ObjectLegalHold, error) {
-	return s.metadata.GetObjectLegalHold(ctx, bucket, key)
-}
-
 // PutPublicAccessBlock sets public access block configuration for a bucket
 func (s *ObjectService) PutPublicAccessBlock(ctx context.Context, bucket string, config *metadata.PublicAccessBlockConfiguration) error {
 	if config == nil {
@@ -1248,61 +1548,127 @@ func (s *ObjectService) ListBucketMetrics(ctx context.Context, bucket string) ([
 
 // Options for PutObject
 type PutObjectOptions struct {
-	ContentType     string
-	ContentEncoding string
-	CacheControl   string
-	Metadata       map[string]string
-	StorageClass   string
+	Size               int64 // declared body size in bytes, 0 when unknown
+	ContentType        string
+	ContentEncoding    string
+	CacheControl       string
+	ContentDisposition string
+	ContentLanguage    string
+	Expires            int64             // Unix time of the Expires header, 0 when unset
+	Metadata           map[string]string // user metadata without the x-amz-meta- prefix
+	StorageClass       string
+
+	// ChecksumAlgorithm selects an additional checksum to compute and
+	// store; a non-empty Checksum is the base64 value the data must match
+	ChecksumAlgorithm string
+	Checksum          string
+
+	// Encryption seals the data at rest; by default the bucket's default
+	// encryption applies
+	Encryption Encryption
 }
 
 // Result from PutObject
 type ObjectResult struct {
-	ETag         string
-	Size         int64
-	VersionID    string
-	LastModified int64
+	ETag              string
+	Size              int64
+	VersionID         string
+	LastModified      int64
+	ChecksumAlgorithm string
+	Checksum          string
+	Encryption        ServerSideEncryption
 }
 
-// Options for GetObject
+// Options for GetObject; Encryption carries the customer key of SSE-C data
 type GetObjectOptions struct {
-	VersionID string
-	Range     *storage.Range
-	IfMatch           string
-	IfNoneMatch       string
-	IfModifiedSince   string
-	IfUnmodifiedSince string
+	VersionID  string
+	Range      *storage.Range
+	Encryption Encryption
+	Conditions
+}
+
+// Options for HeadObject; Encryption carries the customer key of SSE-C data
+type HeadObjectOptions struct {
+	VersionID  string
+	Encryption Encryption
+	Conditions
+}
+
+// Options for CopyObject; Conditions are the x-amz-copy-source-if-*
+// headers evaluated against the source object
+type CopyObjectOptions struct {
+	SourceVersionID string
+	Conditions
+
+	// ReplaceMetadata gives the destination the headers and user metadata
+	// of Replacement instead of the source's (x-amz-metadata-directive: REPLACE)
+	ReplaceMetadata bool
+	Replacement     PutObjectOptions
+
+	// ChecksumAlgorithm recomputes the destination's checksum with another
+	// algorithm; by default the source's algorithm is kept
+	ChecksumAlgorithm string
+
+	// SourceEncryption carries the customer key of an SSE-C source, and
+	// Encryption seals the destination like a PutObject
+	SourceEncryption Encryption
+	Encryption       Encryption
 }
 
 // Result from GetObject
 type GetObjectResult struct {
-	Body         io.ReadCloser
-	Size         int64
-	ETag         string
-	ContentType  string
-	Metadata     map[string]string
-	LastModified int64
-	VersionID    string
-	StorageClass string
+	Body               io.ReadCloser
+	Size               int64
+	ETag               string
+	ContentType        string
+	ContentEncoding    string
+	CacheControl       string
+	ContentDisposition string
+	ContentLanguage    string
+	Expires            int64
+	Metadata           map[string]string
+	LastModified       int64
+	VersionID          string
+	StorageClass       string
+	ChecksumAlgorithm  string
+	Checksum           string
+	Encryption         ServerSideEncryption
 }
 
 // Options for DeleteObject
 type DeleteObjectOptions struct {
 	VersionID string
+	// BypassGovernanceRetention lets a permitted caller delete data that
+	// GOVERNANCE mode retention protects
+	BypassGovernanceRetention bool
+}
+
+// Result from DeleteObject
+type DeleteObjectResult struct {
+	VersionID    string
+	DeleteMarker bool
 }
 
 // Object info
 type ObjectInfo struct {
-	Key             string
-	Size            int64
-	ETag            string
-	ContentType     string
-	ContentEncoding string
-	CacheControl    string
-	Metadata        map[string]string
-	StorageClass    string
-	LastModified    int64
-	VersionID       string
-	IsLatest        bool
+	Key                string
+	Size               int64
+	ETag               string
+	ContentType        string
+	ContentEncoding    string
+	CacheControl       string
+	ContentDisposition string
+	ContentLanguage    string
+	Expires            int64
+	Metadata           map[string]string
+	StorageClass       string
+	LastModified       int64
+	VersionID          string
+	IsLatest           bool
+	IsDeleteMarker     bool
+	ChecksumAlgorithm  string
+	Checksum           string
+	Encryption         ServerSideEncryption
 }
 
 // Options for ListObjects
@@ -1315,13 +1681,31 @@ type ListObjectsOptions struct {
 
 // Result from ListObjects
 type ListObjectsResult struct {
-	Objects       []ObjectInfo
+	Objects        []ObjectInfo
 	CommonPrefixes []string
-	Prefix        string
-	Delimiter     string
-	MaxKeys       int
-	NextMarker    string
-	IsTruncated   bool
+	Prefix         string
+	Delimiter      string
+	MaxKeys        int
+	NextMarker     string // last key or common prefix when truncated
+	IsTruncated    bool
+}
+
+// Options for ListObjectVersions
+type ListObjectVersionsOptions struct {
+	Prefix          string
+	Delimiter       string
+	MaxKeys         int
+	KeyMarker       string
+	VersionIDMarker string
+}
+
+// Result from ListObjectVersions
+type ListObjectVersionsResult struct {
+	Versions            []ObjectInfo // versions and delete markers
+	CommonPrefixes      []string
+	IsTruncated         bool
+	NextKeyMarker       string
+	NextVersionIDMarker string
 }
 
 // Bucket info
@@ -1332,16 +1716,54 @@ type BucketInfo struct {
 
 // Result from CreateMultipartUpload
 type CreateMultipartUploadResult struct {
-	UploadID string
-	Key      string
-	Bucket   string
+	UploadID   string
+	Key        string
+	Bucket     string
+	Encryption ServerSideEncryption
 }
 
 // Result from UploadPart
 type UploadPartResult struct {
-	ETag       string
-	PartNumber int
-	Size       int64
+	ETag              string
+	PartNumber        int
+	Size              int64
+	ChecksumAlgorithm string
+	Checksum          string
+	Encryption        ServerSideEncryption
+}
+
+// Options for UploadPart
+type UploadPartOptions struct {
+	// ChecksumAlgorithm is the part's additional checksum, which must match
+	// the upload's when the upload names one; a non-empty Checksum is the
+	// base64 value the data must match
+	ChecksumAlgorithm string
+	Checksum          string
+
+	// Encryption carries the customer key of an upload initiated with SSE-C
+	Encryption Encryption
+}
+
+// Options for UploadPartCopy; Conditions are the x-amz-copy-source-if-*
+// headers evaluated against the source object
+type UploadPartCopyOptions struct {
+	SourceVersionID string
+	// Range selects part of the source object; nil copies all of it
+	Range *storage.Range
+	Conditions
+
+	// SourceEncryption carries the customer key of an SSE-C source, and
+	// Encryption that of an upload initiated with SSE-C
+	SourceEncryption Encryption
+	Encryption       Encryption
+}
+
+// Result from UploadPartCopy
+type UploadPartCopyResult struct {
+	ETag            string
+	LastModified    int64
+	SourceVersionID string
+	Encryption      ServerSideEncryption
 }
 
 // Part info for CompleteMultipartUpload
@@ -1349,6 +1771,9 @@ type PartInfo struct {
 	PartNumber int    `json:"PartNumber"`
 	ETag       string `json:"ETag"`
 	Size       int64  `json:"Size"`
+	Checksum   string `json:"Checksum,omitempty"` // base64 part checksum, checked when given
+
+	ChecksumAlgorithm string `json:"ChecksumAlgorithm,omitempty"`
 }
 
 // Result from ListMultipartUploads
@@ -1481,16 +1906,3 @@ func parseInt(s string, defaultVal int) int {
 	}
 	return i
 }
-
-// convertToMetadataParts converts engine PartInfo to metadata.PartInfo
-func convertToMetadataParts(parts []PartInfo) []metadata.PartInfo {
-	result := make([]metadata.PartInfo, len(parts))
-	for i, p := range parts {
-		result[i] = metadata.PartInfo{
-			PartNumber: p.PartNumber,
-			ETag:       p.ETag,
-			Size:       p.Size,
-		}
-	}
-	return result
-}
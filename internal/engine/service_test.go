@@ -3,12 +3,18 @@ package engine
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"sort"
+	"strings"
 	"sync"
 	"testing"
 
 	"github.com/openendpoint/openendpoint/internal/metadata"
+	"github.com/openendpoint/openendpoint/internal/s3select"
 	"github.com/openendpoint/openendpoint/internal/storage"
 	"go.uber.org/zap"
 )
@@ -18,12 +24,14 @@ type MockStorageBackend struct {
 	mu      sync.RWMutex
 	objects map[string][]byte
 	buckets map[string]bool
+	parts   map[string][]byte
 }
 
 func NewMockStorageBackend() *MockStorageBackend {
 	return &MockStorageBackend{
 		objects: make(map[string][]byte),
 		buckets: make(map[string]bool),
+		parts:   make(map[string][]byte),
 	}
 }
 
@@ -32,13 +40,14 @@ func (m *MockStorageBackend) objectKey(bucket, key string) string {
 }
 
 func (m *MockStorageBackend) Put(ctx context.Context, bucket, key string, data io.Reader, size int64, opts storage.PutOptions) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
+	// Read before locking; the body may itself read from this backend
 	b, err := io.ReadAll(data)
 	if err != nil {
 		return err
 	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.objects[m.objectKey(bucket, key)] = b
 	return nil
 }
@@ -51,6 +60,9 @@ func (m *MockStorageBackend) Get(ctx context.Context, bucket, key string, opts s
 	if !ok {
 		return nil, io.EOF
 	}
+	if opts.Range != nil {
+		data = data[opts.Range.Start:min(opts.Range.End, int64(len(data)))]
+	}
 	return io.NopCloser(bytes.NewReader(data)), nil
 }
 
@@ -117,6 +129,38 @@ func (m *MockStorageBackend) ListBuckets(ctx context.Context) ([]storage.BucketI
 	return buckets, nil
 }
 
+func (m *MockStorageBackend) PutPart(ctx context.Context, uploadID string, partNumber int, data io.Reader, size int64) error {
+	b, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.parts[fmt.Sprintf("%s/%d", uploadID, partNumber)] = b
+	return nil
+}
+
+func (m *MockStorageBackend) GetPart(ctx context.Context, uploadID string, partNumber int) (io.ReadCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	data, ok := m.parts[fmt.Sprintf("%s/%d", uploadID, partNumber)]
+	if !ok {
+		return nil, io.EOF
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *MockStorageBackend) DeleteUpload(ctx context.Context, uploadID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k := range m.parts {
+		if strings.HasPrefix(k, uploadID+"/") {
+			delete(m.parts, k)
+		}
+	}
+	return nil
+}
+
 func (m *MockStorageBackend) ComputeStorageMetrics() (int64, int64, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -146,6 +190,7 @@ type MockMetadataStore struct {
 	lifecycle   map[string][]metadata.LifecycleRule
 	uploads     map[string][]metadata.MultipartUploadMetadata
 	parts       map[string][]metadata.PartMetadata
+	locks       map[string]*metadata.ObjectLockConfig
 }
 
 func NewMockMetadataStore() *MockMetadataStore {
@@ -161,6 +206,7 @@ func NewMockMetadataStore() *MockMetadataStore {
 		lifecycle:   make(map[string][]metadata.LifecycleRule),
 		uploads:     make(map[string][]metadata.MultipartUploadMetadata),
 		parts:       make(map[string][]metadata.PartMetadata),
+		locks:       make(map[string]*metadata.ObjectLockConfig),
 	}
 }
 
@@ -224,17 +270,34 @@ func (m *MockMetadataStore) DeleteObject(ctx context.Context, bucket, key string
 	return nil
 }
 
-func (m *MockMetadataStore) ListObjects(ctx context.Context, bucket, prefix string, opts metadata.ListOptions) ([]metadata.ObjectMetadata, error) {
+func (m *MockMetadataStore) ListObjects(ctx context.Context, bucket string, opts metadata.ListOptions) (*metadata.ObjectListing, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	var objects []metadata.ObjectMetadata
-	prefixKey := bucket + "/" + prefix
-	for k, v := range m.objects {
-		if len(k) >= len(prefixKey) && k[:len(prefixKey)] == prefixKey {
-			objects = append(objects, *v)
+	var keys []string
+	for k := range m.objects {
+		if strings.HasPrefix(k, bucket+"/") {
+			keys = append(keys, k[len(bucket)+1:])
+		}
+	}
+	sort.Strings(keys)
+	pager := metadata.NewObjectPager(opts)
+	for _, key := range keys {
+		if !pager.Add(key, m.objects[bucket+"/"+key]) {
+			break
 		}
 	}
-	return objects, nil
+	return pager.Listing(), nil
+}
+
+func (m *MockMetadataStore) ListObjectVersions(ctx context.Context, bucket string, opts metadata.ListOptions) (*metadata.VersionListing, error) {
+	return &metadata.VersionListing{}, nil
+}
+
+func (m *MockMetadataStore) UpdateObjectVersion(ctx context.Context, bucket, key string, meta *metadata.ObjectMetadata) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[m.objectKey(bucket, key)] = meta
+	return nil
 }
 
 func (m *MockMetadataStore) Close() error {
@@ -242,6 +305,14 @@ func (m *MockMetadataStore) Close() error {
 }
 
 // Stub methods to satisfy interface
+// newUploadMetadataStore returns a mock store holding the multipart upload
+// "upload-id" of bucket/key
+func newUploadMetadataStore() *MockMetadataStore {
+	m := NewMockMetadataStore()
+	m.CreateMultipartUpload(context.Background(), "bucket", "key", "upload-id", &metadata.ObjectMetadata{})
+	return m
+}
+
 func (m *MockMetadataStore) CreateMultipartUpload(ctx context.Context, bucket, key, uploadID string, meta *metadata.ObjectMetadata) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -268,7 +339,13 @@ func (m *MockMetadataStore) CompleteMultipartUpload(ctx context.Context, bucket,
 func (m *MockMetadataStore) AbortMultipartUpload(ctx context.Context, bucket, key, uploadID string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	delete(m.uploads, bucket)
+	var remaining []metadata.MultipartUploadMetadata
+	for _, u := range m.uploads[bucket] {
+		if u.UploadID != uploadID {
+			remaining = append(remaining, u)
+		}
+	}
+	m.uploads[bucket] = remaining
 	delete(m.parts, bucket+":"+uploadID)
 	return nil
 }
@@ -405,12 +482,20 @@ func (m *MockMetadataStore) DeleteBucketTags(ctx context.Context, bucket string)
 	return nil
 }
 func (m *MockMetadataStore) PutObjectLock(ctx context.Context, bucket string, config *metadata.ObjectLockConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.locks[bucket] = config
 	return nil
 }
 func (m *MockMetadataStore) GetObjectLock(ctx context.Context, bucket string) (*metadata.ObjectLockConfig, error) {
-	return nil, nil
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.locks[bucket], nil
 }
 func (m *MockMetadataStore) DeleteObjectLock(ctx context.Context, bucket string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.locks, bucket)
 	return nil
 }
 func (m *MockMetadataStore) PutObjectRetention(ctx context.Context, bucket, key string, retention *metadata.ObjectRetention) error {
@@ -724,7 +809,7 @@ func TestObjectService_DeleteObject(t *testing.T) {
 		t.Fatalf("PutObject() error = %v", err)
 	}
 
-	err = svc.DeleteObject(ctx, "test-bucket", "test-key", DeleteObjectOptions{})
+	_, err = svc.DeleteObject(ctx, "test-bucket", "test-key", DeleteObjectOptions{})
 	if err != nil {
 		t.Fatalf("DeleteObject() error = %v", err)
 	}
@@ -737,7 +822,7 @@ func TestObjectService_DeleteObject_BucketNotFound(t *testing.T) {
 
 	svc := New(storage, meta, logger)
 
-	err := svc.DeleteObject(context.Background(), "nonexistent", "key", DeleteObjectOptions{})
+	_, err := svc.DeleteObject(context.Background(), "nonexistent", "key", DeleteObjectOptions{})
 	if err == nil {
 		t.Error("DeleteObject() should fail for nonexistent bucket")
 	}
@@ -760,7 +845,7 @@ func TestObjectService_HeadObject(t *testing.T) {
 		t.Fatalf("PutObject() error = %v", err)
 	}
 
-	info, err := svc.HeadObject(ctx, "test-bucket", "test-key")
+	info, err := svc.HeadObject(ctx, "test-bucket", "test-key", HeadObjectOptions{})
 	if err != nil {
 		t.Fatalf("HeadObject() error = %v", err)
 	}
@@ -779,7 +864,7 @@ func TestObjectService_HeadObject_BucketNotFound(t *testing.T) {
 
 	svc := New(storage, meta, logger)
 
-	_, err := svc.HeadObject(context.Background(), "nonexistent", "key")
+	_, err := svc.HeadObject(context.Background(), "nonexistent", "key", HeadObjectOptions{})
 	if err == nil {
 		t.Error("HeadObject() should fail for nonexistent bucket")
 	}
@@ -804,7 +889,7 @@ func TestObjectService_ListObjects(t *testing.T) {
 		}
 	}
 
-	result, err := svc.ListObjects(ctx, "test-bucket", ListObjectsOptions{})
+	result, err := svc.ListObjects(ctx, "test-bucket", ListObjectsOptions{MaxKeys: 1000})
 	if err != nil {
 		t.Fatalf("ListObjects() error = %v", err)
 	}
@@ -820,7 +905,7 @@ func TestObjectService_ListObjects_BucketNotFound(t *testing.T) {
 
 	svc := New(storage, meta, logger)
 
-	_, err := svc.ListObjects(context.Background(), "nonexistent", ListObjectsOptions{})
+	_, err := svc.ListObjects(context.Background(), "nonexistent", ListObjectsOptions{MaxKeys: 1000})
 	if err == nil {
 		t.Error("ListObjects() should fail for nonexistent bucket")
 	}
@@ -1061,7 +1146,14 @@ func TestObjectService_BucketEncryption(t *testing.T) {
 		},
 	}
 
+	// SSE-S3 needs a master key to seal data keys with
 	err := svc.PutBucketEncryption(ctx, "test-bucket", encryption)
+	if !errors.Is(err, ErrEncryptionNotConfigured) {
+		t.Fatalf("PutBucketEncryption() without master key error = %v, want %v", err, ErrEncryptionNotConfigured)
+	}
+
+	svc.SetMasterKey(make([]byte, 32))
+	err = svc.PutBucketEncryption(ctx, "test-bucket", encryption)
 	if err != nil {
 		t.Fatalf("PutBucketEncryption() error = %v", err)
 	}
@@ -1225,12 +1317,17 @@ func TestObjectService_AbortMultipartUpload(t *testing.T) {
 
 	svc := New(storage, meta, logger)
 
-	_, err := svc.CreateMultipartUpload(ctx, "test-bucket", "test-key", PutObjectOptions{})
+	upload, err := svc.CreateMultipartUpload(ctx, "test-bucket", "test-key", PutObjectOptions{})
 	if err != nil {
 		t.Fatalf("CreateMultipartUpload() error = %v", err)
 	}
 
 	err = svc.AbortMultipartUpload(ctx, "test-bucket", "test-key", "upload-123")
+	if !errors.Is(err, ErrNoSuchUpload) {
+		t.Errorf("AbortMultipartUpload() unknown upload error = %v, want %v", err, ErrNoSuchUpload)
+	}
+
+	err = svc.AbortMultipartUpload(ctx, "test-bucket", "test-key", upload.UploadID)
 	if err != nil {
 		t.Fatalf("AbortMultipartUpload() error = %v", err)
 	}
@@ -1285,6 +1382,11 @@ func TestObjectService_ObjectLock(t *testing.T) {
 	svc := New(storage, meta, logger)
 
 	config := &metadata.ObjectLockConfig{Enabled: true}
+	if err := svc.PutObjectLock(ctx, "test-bucket", config); !errors.Is(err, ErrVersioningRequired) {
+		t.Fatalf("PutObjectLock() on unversioned bucket error = %v, want %v", err, ErrVersioningRequired)
+	}
+
+	svc.PutBucketVersioning(ctx, "test-bucket", &metadata.BucketVersioning{Status: VersioningEnabled})
 	err := svc.PutObjectLock(ctx, "test-bucket", config)
 	if err != nil {
 		t.Fatalf("PutObjectLock() error = %v", err)
@@ -1294,11 +1396,13 @@ func TestObjectService_ObjectLock(t *testing.T) {
 	if err != nil {
 		t.Fatalf("GetObjectLock() error = %v", err)
 	}
-	_ = result
+	if result == nil || !result.Enabled {
+		t.Errorf("GetObjectLock() = %+v, expected object lock enabled", result)
+	}
 
 	err = svc.DeleteObjectLock(ctx, "test-bucket")
-	if err != nil {
-		t.Fatalf("DeleteObjectLock() error = %v", err)
+	if !errors.Is(err, ErrObjectLockEnabled) {
+		t.Fatalf("DeleteObjectLock() error = %v, want %v", err, ErrObjectLockEnabled)
 	}
 }
 
@@ -1310,14 +1414,16 @@ func TestObjectService_ObjectRetention(t *testing.T) {
 	ctx := context.Background()
 
 	svc := New(storage, meta, logger)
+	_ = meta.PutObjectLock(ctx, "test-bucket", &metadata.ObjectLockConfig{Enabled: true})
+	_ = meta.PutObject(ctx, "test-bucket", "test-key", &metadata.ObjectMetadata{Bucket: "test-bucket", Key: "test-key"})
 
 	retention := &metadata.ObjectRetention{Mode: "GOVERNANCE"}
-	err := svc.PutObjectRetention(ctx, "test-bucket", "test-key", retention)
+	err := svc.PutObjectRetention(ctx, "test-bucket", "test-key", "", retention, PutObjectRetentionOptions{})
 	if err != nil {
 		t.Fatalf("PutObjectRetention() error = %v", err)
 	}
 
-	result, err := svc.GetObjectRetention(ctx, "test-bucket", "test-key")
+	result, err := svc.GetObjectRetention(ctx, "test-bucket", "test-key", "")
 	if err != nil {
 		t.Fatalf("GetObjectRetention() error = %v", err)
 	}
@@ -1332,14 +1438,16 @@ func TestObjectService_ObjectLegalHold(t *testing.T) {
 	ctx := context.Background()
 
 	svc := New(storage, meta, logger)
+	_ = meta.PutObjectLock(ctx, "test-bucket", &metadata.ObjectLockConfig{Enabled: true})
+	_ = meta.PutObject(ctx, "test-bucket", "test-key", &metadata.ObjectMetadata{Bucket: "test-bucket", Key: "test-key"})
 
 	legalHold := &metadata.ObjectLegalHold{Status: "ON"}
-	err := svc.PutObjectLegalHold(ctx, "test-bucket", "test-key", legalHold)
+	err := svc.PutObjectLegalHold(ctx, "test-bucket", "test-key", "", legalHold)
 	if err != nil {
 		t.Fatalf("PutObjectLegalHold() error = %v", err)
 	}
 
-	result, err := svc.GetObjectLegalHold(ctx, "test-bucket", "test-key")
+	result, err := svc.GetObjectLegalHold(ctx, "test-bucket", "test-key", "")
 	if err != nil {
 		t.Fatalf("GetObjectLegalHold() error = %v", err)
 	}
@@ -1678,7 +1786,7 @@ func TestObjectService_CopyObject(t *testing.T) {
 		t.Fatalf("PutObject() error = %v", err)
 	}
 
-	result, err := svc.CopyObject(ctx, "src-bucket", "src-key", "dst-bucket", "dst-key")
+	result, err := svc.CopyObject(ctx, "src-bucket", "src-key", "dst-bucket", "dst-key", CopyObjectOptions{})
 	if err != nil {
 		t.Fatalf("CopyObject() error = %v", err)
 	}
@@ -1694,7 +1802,7 @@ func TestObjectService_CopyObject_SourceBucketNotFound(t *testing.T) {
 
 	svc := New(storage, meta, logger)
 
-	_, err := svc.CopyObject(context.Background(), "nonexistent", "src-key", "dst-bucket", "dst-key")
+	_, err := svc.CopyObject(context.Background(), "nonexistent", "src-key", "dst-bucket", "dst-key", CopyObjectOptions{})
 	if err == nil {
 		t.Error("CopyObject() should fail for nonexistent source bucket")
 	}
@@ -1710,7 +1818,7 @@ func TestObjectService_CopyObject_DestBucketNotFound(t *testing.T) {
 
 	svc := New(storage, meta, logger)
 
-	_, err := svc.CopyObject(ctx, "src-bucket", "src-key", "nonexistent", "dst-key")
+	_, err := svc.CopyObject(ctx, "src-bucket", "src-key", "nonexistent", "dst-key", CopyObjectOptions{})
 	if err == nil {
 		t.Error("CopyObject() should fail for nonexistent destination bucket")
 	}
@@ -1772,12 +1880,12 @@ func TestObjectService_CompleteMultipartUpload(t *testing.T) {
 		t.Fatalf("CreateMultipartUpload() error = %v", err)
 	}
 
+	// Part 1 was never uploaded
 	parts := []PartInfo{{PartNumber: 1, ETag: "etag1"}}
-	result, err := svc.CompleteMultipartUpload(ctx, "test-bucket", "test-key", uploadResult.UploadID, parts)
-	if err != nil {
-		t.Fatalf("CompleteMultipartUpload() error = %v", err)
+	_, err = svc.CompleteMultipartUpload(ctx, "test-bucket", "test-key", uploadResult.UploadID, parts)
+	if !errors.Is(err, ErrInvalidPart) {
+		t.Fatalf("CompleteMultipartUpload() error = %v, want ErrInvalidPart", err)
 	}
-	_ = result
 }
 
 func TestObjectService_SelectObjectContent(t *testing.T) {
@@ -1797,15 +1905,45 @@ func TestObjectService_SelectObjectContent(t *testing.T) {
 		t.Fatalf("PutObject() error = %v", err)
 	}
 
-	result, err := svc.SelectObjectContent(ctx, "test-bucket", "test.csv", "SELECT * FROM s3object")
+	out := &selectRecords{}
+	stats, err := svc.SelectObjectContent(ctx, "test-bucket", "test.csv", Encryption{}, csvSelect("SELECT name FROM s3object WHERE age > 26"), out)
 	if err != nil {
 		t.Fatalf("SelectObjectContent() error = %v", err)
 	}
-	if result == nil {
-		t.Fatal("SelectObjectContent() returned nil")
+	if out.String() != "john\n" || stats.RecordsReturned != 1 || stats.BytesScanned != 24 {
+		t.Errorf("SelectObjectContent() = %q, stats %+v", out.String(), stats)
 	}
+
+	// A delete marker hides the object from queries as from GetObject
+	svc.PutBucketVersioning(ctx, "test-bucket", &metadata.BucketVersioning{Status: VersioningEnabled})
+	svc.DeleteObject(ctx, "test-bucket", "test.csv", DeleteObjectOptions{})
+	_, err = svc.SelectObjectContent(ctx, "test-bucket", "test.csv", Encryption{}, csvSelect("SELECT * FROM s3object"), &selectRecords{})
+	var markerErr *DeleteMarkerError
+	if !errors.As(err, &markerErr) {
+		t.Errorf("SelectObjectContent() of deleted object error = %v, want DeleteMarkerError", err)
+	}
+}
+
+// csvSelect is an S3 Select request of expression over CSV with a header
+func csvSelect(expression string) *s3select.SelectRequest {
+	return &s3select.SelectRequest{
+		Expression:         expression,
+		InputSerialization: s3select.InputSerialization{Format: s3select.FormatCSV, CSV: &s3select.CSVInput{FileHeaderInfo: "USE"}},
+	}
+}
+
+// selectRecords collects the output records of a query
+type selectRecords struct {
+	bytes.Buffer
+}
+
+func (r *selectRecords) Records(payload []byte) error {
+	r.Write(payload)
+	return nil
 }
 
+func (r *selectRecords) Progress(s3select.SelectStats) error { return nil }
+
 func TestObjectService_SelectObjectContent_BucketNotFound(t *testing.T) {
 	storage := NewMockStorageBackend()
 	meta := NewMockMetadataStore()
@@ -1813,7 +1951,7 @@ func TestObjectService_SelectObjectContent_BucketNotFound(t *testing.T) {
 
 	svc := New(storage, meta, logger)
 
-	_, err := svc.SelectObjectContent(context.Background(), "nonexistent", "key", "SELECT *")
+	_, err := svc.SelectObjectContent(context.Background(), "nonexistent", "key", Encryption{}, csvSelect("SELECT * FROM s3object"), &selectRecords{})
 	if err == nil {
 		t.Error("SelectObjectContent() should fail for nonexistent bucket")
 	}
@@ -1829,7 +1967,7 @@ func TestObjectService_SelectObjectContent_ObjectNotFound(t *testing.T) {
 
 	svc := New(storage, meta, logger)
 
-	_, err := svc.SelectObjectContent(ctx, "test-bucket", "nonexistent.csv", "SELECT *")
+	_, err := svc.SelectObjectContent(ctx, "test-bucket", "nonexistent.csv", Encryption{}, csvSelect("SELECT * FROM s3object"), &selectRecords{})
 	if err == nil {
 		t.Error("SelectObjectContent() should fail for nonexistent object")
 	}
@@ -1850,7 +1988,7 @@ func TestObjectService_UploadPart(t *testing.T) {
 	}
 
 	data := bytes.NewReader([]byte("part data"))
-	result, err := svc.UploadPart(ctx, "test-bucket", "test-key", uploadResult.UploadID, 1, data)
+	result, err := svc.UploadPart(ctx, "test-bucket", "test-key", uploadResult.UploadID, 1, data, UploadPartOptions{})
 	if err != nil {
 		t.Fatalf("UploadPart() error = %v", err)
 	}
@@ -1890,6 +2028,7 @@ func TestObjectService_CompleteMultipartUploadWithParts(t *testing.T) {
 	ctx := context.Background()
 
 	svc := New(storage, meta, logger)
+	svc.minPartSize = 1
 
 	uploadResult, err := svc.CreateMultipartUpload(ctx, "test-bucket", "test-key", PutObjectOptions{})
 	if err != nil {
@@ -1897,20 +2036,20 @@ func TestObjectService_CompleteMultipartUploadWithParts(t *testing.T) {
 	}
 
 	data1 := bytes.NewReader([]byte("part one "))
-	_, err = svc.UploadPart(ctx, "test-bucket", "test-key", uploadResult.UploadID, 1, data1)
+	part1, err := svc.UploadPart(ctx, "test-bucket", "test-key", uploadResult.UploadID, 1, data1, UploadPartOptions{})
 	if err != nil {
 		t.Fatalf("UploadPart(1) error = %v", err)
 	}
 
 	data2 := bytes.NewReader([]byte("part two"))
-	_, err = svc.UploadPart(ctx, "test-bucket", "test-key", uploadResult.UploadID, 2, data2)
+	part2, err := svc.UploadPart(ctx, "test-bucket", "test-key", uploadResult.UploadID, 2, data2, UploadPartOptions{})
 	if err != nil {
 		t.Fatalf("UploadPart(2) error = %v", err)
 	}
 
 	parts := []PartInfo{
-		{PartNumber: 1, ETag: "etag1"},
-		{PartNumber: 2, ETag: "etag2"},
+		{PartNumber: 1, ETag: part1.ETag},
+		{PartNumber: 2, ETag: part2.ETag},
 	}
 	result, err := svc.CompleteMultipartUpload(ctx, "test-bucket", "test-key", uploadResult.UploadID, parts)
 	if err != nil {
@@ -1945,6 +2084,105 @@ func TestObjectService_CompleteMultipartUpload_NoParts(t *testing.T) {
 	}
 }
 
+func TestObjectService_CompleteMultipartUpload_Validation(t *testing.T) {
+	ctx := context.Background()
+	svc := New(NewMockStorageBackend(), NewMockMetadataStore(), zap.NewNop().Sugar())
+	svc.minPartSize = 4
+
+	upload, err := svc.CreateMultipartUpload(ctx, "test-bucket", "test-key", PutObjectOptions{})
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload() error = %v", err)
+	}
+	etags := map[int]string{}
+	for n, data := range map[int]string{1: "abcd", 2: "ab", 3: "abcd"} {
+		result, err := svc.UploadPart(ctx, "test-bucket", "test-key", upload.UploadID, n, strings.NewReader(data), UploadPartOptions{})
+		if err != nil {
+			t.Fatalf("UploadPart(%d) error = %v", n, err)
+		}
+		etags[n] = result.ETag
+	}
+
+	tests := []struct {
+		name    string
+		parts   []PartInfo
+		wantErr error
+	}{
+		{"NoParts", nil, ErrInvalidPart},
+		{"UnknownPart", []PartInfo{{PartNumber: 4, ETag: etags[1]}}, ErrInvalidPart},
+		{"WrongETag", []PartInfo{{PartNumber: 1, ETag: etags[3] + "x"}}, ErrInvalidPart},
+		{"Descending", []PartInfo{{PartNumber: 3, ETag: etags[3]}, {PartNumber: 1, ETag: etags[1]}}, ErrInvalidPartOrder},
+		{"Duplicate", []PartInfo{{PartNumber: 1, ETag: etags[1]}, {PartNumber: 1, ETag: etags[1]}}, ErrInvalidPartOrder},
+		{"SmallMiddlePart", []PartInfo{{PartNumber: 1, ETag: etags[1]}, {PartNumber: 2, ETag: etags[2]}, {PartNumber: 3, ETag: etags[3]}}, ErrEntityTooSmall},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.CompleteMultipartUpload(ctx, "test-bucket", "test-key", upload.UploadID, tt.parts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("CompleteMultipartUpload() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestObjectService_CompleteMultipartUpload_ETag(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMockStorageBackend()
+	meta := NewMockMetadataStore()
+	meta.CreateBucket(ctx, "test-bucket")
+	svc := New(backend, meta, zap.NewNop().Sugar())
+	svc.minPartSize = 4
+
+	upload, err := svc.CreateMultipartUpload(ctx, "test-bucket", "test-key", PutObjectOptions{})
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload() error = %v", err)
+	}
+
+	// Parts 1, 3 and 4 are uploaded, only 1 and 3 are used
+	var parts []PartInfo
+	combined := md5.New()
+	for _, p := range []struct {
+		n    int
+		data string
+	}{{1, "hello "}, {3, "world"}, {4, "unused"}} {
+		result, err := svc.UploadPart(ctx, "test-bucket", "test-key", upload.UploadID, p.n, strings.NewReader(p.data), UploadPartOptions{})
+		if err != nil {
+			t.Fatalf("UploadPart(%d) error = %v", p.n, err)
+		}
+		sum := md5.Sum([]byte(p.data))
+		if want := `"` + hex.EncodeToString(sum[:]) + `"`; result.ETag != want {
+			t.Errorf("UploadPart(%d) ETag = %s, want %s", p.n, result.ETag, want)
+		}
+		if p.n != 4 {
+			parts = append(parts, PartInfo{PartNumber: p.n, ETag: result.ETag})
+			combined.Write(sum[:])
+		}
+	}
+
+	result, err := svc.CompleteMultipartUpload(ctx, "test-bucket", "test-key", upload.UploadID, parts)
+	if err != nil {
+		t.Fatalf("CompleteMultipartUpload() error = %v", err)
+	}
+	if want := `"` + hex.EncodeToString(combined.Sum(nil)) + `-2"`; result.ETag != want {
+		t.Errorf("ETag = %s, want %s", result.ETag, want)
+	}
+	if result.Size != 11 {
+		t.Errorf("Size = %d, want 11", result.Size)
+	}
+
+	obj, err := svc.GetObject(ctx, "test-bucket", "test-key", GetObjectOptions{})
+	if err != nil {
+		t.Fatalf("GetObject() error = %v", err)
+	}
+	defer obj.Body.Close()
+	data, _ := io.ReadAll(obj.Body)
+	if string(data) != "hello world" {
+		t.Errorf("object = %q, want %q", data, "hello world")
+	}
+	if _, err := backend.GetPart(ctx, upload.UploadID, 4); err == nil {
+		t.Error("unused part 4 should be removed after completion")
+	}
+}
+
 func TestObjectService_DeleteBucketWithObjects(t *testing.T) {
 	storage := NewMockStorageBackend()
 	meta := NewMockMetadataStore()
@@ -2072,7 +2310,7 @@ func TestObjectService_DeleteObjectWithVersion(t *testing.T) {
 		t.Fatalf("PutObject() error = %v", err)
 	}
 
-	err = svc.DeleteObject(ctx, "test-bucket", "test-key", DeleteObjectOptions{VersionID: "version-123"})
+	_, err = svc.DeleteObject(ctx, "test-bucket", "test-key", DeleteObjectOptions{VersionID: "version-123"})
 	if err != nil {
 		t.Fatalf("DeleteObject() error = %v", err)
 	}
@@ -2097,7 +2335,7 @@ func TestObjectService_ListObjectsWithDelimiter(t *testing.T) {
 		}
 	}
 
-	result, err := svc.ListObjects(ctx, "test-bucket", ListObjectsOptions{Delimiter: "/"})
+	result, err := svc.ListObjects(ctx, "test-bucket", ListObjectsOptions{Delimiter: "/", MaxKeys: 1000})
 	if err != nil {
 		t.Fatalf("ListObjects() error = %v", err)
 	}
@@ -2226,11 +2464,95 @@ func TestObjectService_PutObject_ExceedsMaxSize(t *testing.T) {
 
 	meta.CreateBucket(context.Background(), "test-bucket")
 	svc := New(storage, meta, logger)
+	svc.SetMaxObjectSize(1024)
 
-	largeData := make([]byte, MaxUploadSize+2)
+	largeData := make([]byte, 1024+2)
 	_, err := svc.PutObject(context.Background(), "test-bucket", "key", bytes.NewReader(largeData), PutObjectOptions{})
-	if err == nil {
-		t.Error("PutObject() should fail for data exceeding max size")
+	if !errors.Is(err, ErrEntityTooLarge) {
+		t.Errorf("PutObject() error = %v, want ErrEntityTooLarge", err)
+	}
+
+	// A declared size over the limit is rejected before reading the body
+	_, err = svc.PutObject(context.Background(), "test-bucket", "key", &errorReader{}, PutObjectOptions{Size: 2048})
+	if !errors.Is(err, ErrEntityTooLarge) {
+		t.Errorf("PutObject() error = %v, want ErrEntityTooLarge", err)
+	}
+	if _, err := meta.GetObject(context.Background(), "test-bucket", "key", ""); err == nil {
+		t.Error("metadata should not be committed for a rejected upload")
+	}
+}
+
+func TestObjectService_GetObject_Seek(t *testing.T) {
+	storage := NewMockStorageBackend()
+	meta := NewMockMetadataStore()
+	logger := zap.NewNop().Sugar()
+
+	meta.CreateBucket(context.Background(), "test-bucket")
+	svc := New(storage, meta, logger)
+
+	data := "0123456789"
+	if _, err := svc.PutObject(context.Background(), "test-bucket", "key", strings.NewReader(data), PutObjectOptions{}); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+
+	result, err := svc.GetObject(context.Background(), "test-bucket", "key", GetObjectOptions{})
+	if err != nil {
+		t.Fatalf("GetObject() error = %v", err)
+	}
+	defer result.Body.Close()
+
+	body, ok := result.Body.(io.ReadSeeker)
+	if !ok {
+		t.Fatal("GetObject() body should be seekable")
+	}
+
+	if size, err := body.Seek(0, io.SeekEnd); err != nil || size != int64(len(data)) {
+		t.Fatalf("Seek(0, SeekEnd) = %d, %v, want %d", size, err, len(data))
+	}
+	if _, err := body.Seek(6, io.SeekStart); err != nil {
+		t.Fatalf("Seek() error = %v", err)
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(body, buf); err != nil || string(buf) != "67" {
+		t.Errorf("read after seek = %q, %v, want 67", buf, err)
+	}
+	if _, err := body.Seek(-3, io.SeekCurrent); err != nil {
+		t.Fatalf("Seek() error = %v", err)
+	}
+	rest, err := io.ReadAll(body)
+	if err != nil || string(rest) != "56789" {
+		t.Errorf("ReadAll after seek = %q, %v, want 56789", rest, err)
+	}
+}
+
+func TestObjectService_PutObject_Streaming(t *testing.T) {
+	storage := NewMockStorageBackend()
+	meta := NewMockMetadataStore()
+	logger := zap.NewNop().Sugar()
+
+	meta.CreateBucket(context.Background(), "test-bucket")
+	svc := New(storage, meta, logger)
+
+	data := "hello streaming world"
+	result, err := svc.PutObject(context.Background(), "test-bucket", "key", strings.NewReader(data), PutObjectOptions{Size: int64(len(data))})
+	if err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	sum := md5.Sum([]byte(data))
+	if want := "\"" + hex.EncodeToString(sum[:]) + "\""; result.ETag != want {
+		t.Errorf("ETag = %s, want %s", result.ETag, want)
+	}
+	if result.Size != int64(len(data)) {
+		t.Errorf("Size = %d, want %d", result.Size, len(data))
+	}
+
+	// A body shorter than the declared length must not be committed
+	_, err = svc.PutObject(context.Background(), "test-bucket", "short", strings.NewReader("abc"), PutObjectOptions{Size: 10})
+	if !errors.Is(err, ErrIncompleteBody) {
+		t.Errorf("PutObject() error = %v, want ErrIncompleteBody", err)
+	}
+	if _, err := meta.GetObject(context.Background(), "test-bucket", "short", ""); err == nil {
+		t.Error("metadata should not be committed for an incomplete upload")
 	}
 }
 
@@ -2260,6 +2582,20 @@ func (e *errorStorage) Get(ctx context.Context, bucket, key string, opts storage
 	return e.MockStorageBackend.Get(ctx, bucket, key, opts)
 }
 
+func (e *errorStorage) PutPart(ctx context.Context, uploadID string, partNumber int, data io.Reader, size int64) error {
+	if e.putErr != nil {
+		return e.putErr
+	}
+	return e.MockStorageBackend.PutPart(ctx, uploadID, partNumber, data, size)
+}
+
+func (e *errorStorage) GetPart(ctx context.Context, uploadID string, partNumber int) (io.ReadCloser, error) {
+	if e.getErr != nil {
+		return nil, e.getErr
+	}
+	return e.MockStorageBackend.GetPart(ctx, uploadID, partNumber)
+}
+
 func (e *errorStorage) Delete(ctx context.Context, bucket, key string) error {
 	if e.deleteErr != nil {
 		return e.deleteErr
@@ -2321,7 +2657,7 @@ func TestObjectService_CopyObject_ObjectNotFound(t *testing.T) {
 	meta.CreateBucket(context.Background(), "dst-bucket")
 	svc := New(storage, meta, zap.NewNop().Sugar())
 
-	_, err := svc.CopyObject(context.Background(), "src-bucket", "nonexistent", "dst-bucket", "dst-key")
+	_, err := svc.CopyObject(context.Background(), "src-bucket", "nonexistent", "dst-bucket", "dst-key", CopyObjectOptions{})
 	if err == nil {
 		t.Error("CopyObject() should fail for nonexistent object")
 	}
@@ -2335,7 +2671,7 @@ func TestObjectService_CopyObject_StorageGetError(t *testing.T) {
 	svc := New(storage, meta, zap.NewNop().Sugar())
 
 	meta.PutObject(context.Background(), "src-bucket", "src-key", &metadata.ObjectMetadata{Key: "src-key"})
-	_, err := svc.CopyObject(context.Background(), "src-bucket", "src-key", "dst-bucket", "dst-key")
+	_, err := svc.CopyObject(context.Background(), "src-bucket", "src-key", "dst-bucket", "dst-key", CopyObjectOptions{})
 	if err == nil {
 		t.Error("CopyObject() should fail with storage get error")
 	}
@@ -2355,7 +2691,7 @@ func TestObjectService_CopyObject_StoragePutError(t *testing.T) {
 	storage := &errorStorage{MockStorageBackend: mockStorage, putErr: fmt.Errorf("put error")}
 	svc := New(storage, meta, zap.NewNop().Sugar())
 
-	_, err := svc.CopyObject(context.Background(), "src-bucket", "src-key", "dst-bucket", "dst-key")
+	_, err := svc.CopyObject(context.Background(), "src-bucket", "src-key", "dst-bucket", "dst-key", CopyObjectOptions{})
 	if err == nil {
 		t.Error("CopyObject() should fail with storage put error")
 	}
@@ -2392,9 +2728,10 @@ func TestObjectService_DeleteObject_StorageError(t *testing.T) {
 	storage := &errorStorage{MockStorageBackend: NewMockStorageBackend(), deleteErr: fmt.Errorf("delete error")}
 	svc := New(storage, meta, zap.NewNop().Sugar())
 
-	err := svc.DeleteObject(context.Background(), "test-bucket", "key", DeleteObjectOptions{})
-	if err == nil {
-		t.Error("DeleteObject() should fail with storage error")
+	// Metadata is deleted first, so leftover data is only an orphan
+	_, err := svc.DeleteObject(context.Background(), "test-bucket", "key", DeleteObjectOptions{})
+	if err != nil {
+		t.Errorf("DeleteObject() should not fail with storage delete error: %v", err)
 	}
 }
 
@@ -2404,7 +2741,7 @@ func TestObjectService_HeadObject_ObjectNotFound(t *testing.T) {
 	meta.CreateBucket(context.Background(), "test-bucket")
 	svc := New(storage, meta, zap.NewNop().Sugar())
 
-	_, err := svc.HeadObject(context.Background(), "test-bucket", "nonexistent")
+	_, err := svc.HeadObject(context.Background(), "test-bucket", "nonexistent", HeadObjectOptions{})
 	if err == nil {
 		t.Error("HeadObject() should fail for nonexistent object")
 	}
@@ -2417,7 +2754,7 @@ func TestObjectService_HeadObject_StorageError(t *testing.T) {
 	storage := &errorStorage{MockStorageBackend: NewMockStorageBackend(), headErr: fmt.Errorf("head error")}
 	svc := New(storage, meta, zap.NewNop().Sugar())
 
-	_, err := svc.HeadObject(context.Background(), "test-bucket", "key")
+	_, err := svc.HeadObject(context.Background(), "test-bucket", "key", HeadObjectOptions{})
 	if err == nil {
 		t.Error("HeadObject() should fail with storage error")
 	}
@@ -2565,21 +2902,47 @@ func TestObjectService_SelectObjectContent_StorageError(t *testing.T) {
 	storage := &errorStorage{MockStorageBackend: NewMockStorageBackend(), getErr: fmt.Errorf("get error")}
 	svc := New(storage, meta, zap.NewNop().Sugar())
 
-	_, err := svc.SelectObjectContent(context.Background(), "test-bucket", "key", "SELECT *")
+	_, err := svc.SelectObjectContent(context.Background(), "test-bucket", "key", Encryption{}, csvSelect("SELECT * FROM s3object"), &selectRecords{})
 	if err == nil {
 		t.Error("SelectObjectContent() should fail with storage error")
 	}
 }
 
-func TestObjectService_ListObjects_StorageError(t *testing.T) {
+type errorListObjectsMetadata struct {
+	*MockMetadataStore
+	listErr error
+}
+
+func (e *errorListObjectsMetadata) ListObjects(ctx context.Context, bucket string, opts metadata.ListOptions) (*metadata.ObjectListing, error) {
+	return nil, e.listErr
+}
+
+func TestObjectService_ListObjects_MetadataError(t *testing.T) {
+	meta := NewMockMetadataStore()
+	meta.CreateBucket(context.Background(), "test-bucket")
+	errMeta := &errorListObjectsMetadata{MockMetadataStore: meta, listErr: fmt.Errorf("list error")}
+	svc := New(NewMockStorageBackend(), errMeta, zap.NewNop().Sugar())
+
+	_, err := svc.ListObjects(context.Background(), "test-bucket", ListObjectsOptions{MaxKeys: 1000})
+	if err == nil {
+		t.Error("ListObjects() should fail with metadata error")
+	}
+}
+
+func TestObjectService_ListObjects_IgnoresStorage(t *testing.T) {
 	meta := NewMockMetadataStore()
 	meta.CreateBucket(context.Background(), "test-bucket")
+	meta.PutObject(context.Background(), "test-bucket", "key", &metadata.ObjectMetadata{Key: "key", Size: 4})
 	storage := &errorStorage{MockStorageBackend: NewMockStorageBackend(), listErr: fmt.Errorf("list error")}
 	svc := New(storage, meta, zap.NewNop().Sugar())
 
-	_, err := svc.ListObjects(context.Background(), "test-bucket", ListObjectsOptions{})
-	if err == nil {
-		t.Error("ListObjects() should fail with storage error")
+	// Listings come from the metadata index, never from the backend
+	result, err := svc.ListObjects(context.Background(), "test-bucket", ListObjectsOptions{MaxKeys: 1000})
+	if err != nil {
+		t.Fatalf("ListObjects() error: %v", err)
+	}
+	if len(result.Objects) != 1 || result.Objects[0].Key != "key" || result.Objects[0].Size != 4 {
+		t.Errorf("ListObjects() objects = %+v", result.Objects)
 	}
 }
 
@@ -2657,10 +3020,10 @@ func TestObjectService_CreateMultipartUpload_MetadataError(t *testing.T) {
 }
 
 func TestObjectService_UploadPart_ReadError(t *testing.T) {
-	meta := NewMockMetadataStore()
+	meta := newUploadMetadataStore()
 	svc := New(NewMockStorageBackend(), meta, zap.NewNop().Sugar())
 
-	_, err := svc.UploadPart(context.Background(), "bucket", "key", "upload-id", 1, &errorReader{})
+	_, err := svc.UploadPart(context.Background(), "bucket", "key", "upload-id", 1, &errorReader{}, UploadPartOptions{})
 	if err == nil {
 		t.Error("UploadPart() should fail with read error")
 	}
@@ -2679,10 +3042,10 @@ func (s *seekerReader) Seek(offset int64, whence int) (int64, error) {
 }
 
 func TestObjectService_UploadPart_SeekError(t *testing.T) {
-	svc := New(NewMockStorageBackend(), NewMockMetadataStore(), zap.NewNop().Sugar())
+	svc := New(NewMockStorageBackend(), newUploadMetadataStore(), zap.NewNop().Sugar())
 
 	reader := &seekerReader{Reader: bytes.NewReader([]byte("data")), seekErr: true}
-	_, err := svc.UploadPart(context.Background(), "bucket", "key", "upload-id", 1, reader)
+	_, err := svc.UploadPart(context.Background(), "bucket", "key", "upload-id", 1, reader, UploadPartOptions{})
 	if err != nil {
 		t.Errorf("UploadPart() should not fail with seek error, got: %v", err)
 	}
@@ -2715,7 +3078,7 @@ func TestObjectService_CompleteMultipartUpload_WriteError(t *testing.T) {
 	mockMeta.PutPart(context.Background(), "bucket", "key", "upload-id", 1, &metadata.PartMetadata{PartNumber: 1})
 
 	mockStorage := NewMockStorageBackend()
-	mockStorage.Put(context.Background(), "bucket", "bucket/key/upload-id/1", bytes.NewReader([]byte("part")), 4, storage.PutOptions{})
+	mockStorage.PutPart(context.Background(), "upload-id", 1, bytes.NewReader([]byte("part")), 4)
 
 	storage := &errorStorage{MockStorageBackend: mockStorage, putErr: fmt.Errorf("put error")}
 	svc := New(storage, mockMeta, zap.NewNop().Sugar())
@@ -2731,7 +3094,7 @@ func TestObjectService_CompleteMultipartUpload_MetadataError(t *testing.T) {
 	mockMeta.PutPart(context.Background(), "bucket", "key", "upload-id", 1, &metadata.PartMetadata{PartNumber: 1})
 
 	mockStorage := NewMockStorageBackend()
-	mockStorage.Put(context.Background(), "bucket", "bucket/key/upload-id/1", bytes.NewReader([]byte("part")), 4, storage.PutOptions{})
+	mockStorage.PutPart(context.Background(), "upload-id", 1, bytes.NewReader([]byte("part")), 4)
 
 	meta := &errorMetadataStore{MockMetadataStore: mockMeta, putObjErr: fmt.Errorf("put error")}
 	svc := New(mockStorage, meta, zap.NewNop().Sugar())
@@ -2743,15 +3106,16 @@ func TestObjectService_CompleteMultipartUpload_MetadataError(t *testing.T) {
 }
 
 func TestObjectService_CompleteMultipartUpload_SortParts(t *testing.T) {
-	mockMeta := NewMockMetadataStore()
-	mockMeta.PutPart(context.Background(), "bucket", "key", "upload-id", 2, &metadata.PartMetadata{PartNumber: 2})
-	mockMeta.PutPart(context.Background(), "bucket", "key", "upload-id", 1, &metadata.PartMetadata{PartNumber: 1})
+	mockMeta := newUploadMetadataStore()
+	mockMeta.PutPart(context.Background(), "bucket", "key", "upload-id", 2, &metadata.PartMetadata{PartNumber: 2, Size: 5})
+	mockMeta.PutPart(context.Background(), "bucket", "key", "upload-id", 1, &metadata.PartMetadata{PartNumber: 1, Size: 5})
 
 	mockStorage := NewMockStorageBackend()
-	mockStorage.Put(context.Background(), "bucket", "bucket/key/upload-id/1", bytes.NewReader([]byte("part1")), 5, storage.PutOptions{})
-	mockStorage.Put(context.Background(), "bucket", "bucket/key/upload-id/2", bytes.NewReader([]byte("part2")), 5, storage.PutOptions{})
+	mockStorage.PutPart(context.Background(), "upload-id", 1, bytes.NewReader([]byte("part1")), 5)
+	mockStorage.PutPart(context.Background(), "upload-id", 2, bytes.NewReader([]byte("part2")), 5)
 
 	svc := New(mockStorage, mockMeta, zap.NewNop().Sugar())
+	svc.minPartSize = 5
 
 	result, err := svc.CompleteMultipartUpload(context.Background(), "bucket", "key", "upload-id", []PartInfo{{PartNumber: 1}, {PartNumber: 2}})
 	if err != nil {
@@ -2984,9 +3348,12 @@ func TestObjectService_DeleteObject_MetadataError(t *testing.T) {
 	errMeta := &errorDeleteObjectMetadata{MockMetadataStore: meta, delObjErr: fmt.Errorf("delete error")}
 	svc := New(mockStorage, errMeta, zap.NewNop().Sugar())
 
-	err := svc.DeleteObject(context.Background(), "test-bucket", "key", DeleteObjectOptions{})
-	if err != nil {
-		t.Errorf("DeleteObject() should not fail with metadata delete error: %v", err)
+	_, err := svc.DeleteObject(context.Background(), "test-bucket", "key", DeleteObjectOptions{})
+	if err == nil {
+		t.Error("DeleteObject() should fail with metadata delete error")
+	}
+	if _, err := mockStorage.Head(context.Background(), "test-bucket", "key"); err != nil {
+		t.Errorf("data deleted although its metadata was kept: %v", err)
 	}
 }
 
@@ -3013,8 +3380,8 @@ func TestObjectService_PutObject_MetadataError(t *testing.T) {
 	svc := New(mockStorage, errMeta, zap.NewNop().Sugar())
 
 	_, err := svc.PutObject(context.Background(), "test-bucket", "key", bytes.NewReader([]byte("data")), PutObjectOptions{})
-	if err != nil {
-		t.Errorf("PutObject() should not fail with metadata put error: %v", err)
+	if err == nil {
+		t.Error("PutObject() should fail when the metadata commit fails")
 	}
 }
 
@@ -3032,9 +3399,9 @@ func TestObjectService_CopyObject_MetadataError(t *testing.T) {
 	errMeta := &errorPutObjectMetadata{MockMetadataStore: meta, putObjErr: fmt.Errorf("put error")}
 	svc := New(mockStorage, errMeta, zap.NewNop().Sugar())
 
-	_, err := svc.CopyObject(context.Background(), "src-bucket", "src-key", "dst-bucket", "dst-key")
-	if err != nil {
-		t.Errorf("CopyObject() should not fail with metadata put error: %v", err)
+	_, err := svc.CopyObject(context.Background(), "src-bucket", "src-key", "dst-bucket", "dst-key", CopyObjectOptions{})
+	if err == nil {
+		t.Error("CopyObject() should fail when the metadata commit fails")
 	}
 }
 
@@ -3111,11 +3478,11 @@ func TestObjectService_SelectObjectContent_ReadError(t *testing.T) {
 
 	meta := NewMockMetadataStore()
 	meta.CreateBucket(context.Background(), "test-bucket")
-	meta.PutObject(context.Background(), "test-bucket", "key", &metadata.ObjectMetadata{Key: "key"})
+	meta.PutObject(context.Background(), "test-bucket", "key", &metadata.ObjectMetadata{Key: "key", Size: 4})
 
 	svc := New(mockStorage, meta, zap.NewNop().Sugar())
 
-	_, err := svc.SelectObjectContent(context.Background(), "test-bucket", "key", "SELECT *")
+	_, err := svc.SelectObjectContent(context.Background(), "test-bucket", "key", Encryption{}, csvSelect("SELECT * FROM s3object"), &selectRecords{})
 	if err == nil {
 		t.Error("SelectObjectContent() should fail with read error")
 	}
@@ -3135,10 +3502,10 @@ func (e *errorPartMetadataStore) PutPart(ctx context.Context, bucket, key, uploa
 
 func TestObjectService_UploadPart_MetadataError(t *testing.T) {
 	mockStorage := NewMockStorageBackend()
-	meta := &errorPartMetadataStore{MockMetadataStore: NewMockMetadataStore(), putPartErr: fmt.Errorf("put part error")}
+	meta := &errorPartMetadataStore{MockMetadataStore: newUploadMetadataStore(), putPartErr: fmt.Errorf("put part error")}
 	svc := New(mockStorage, meta, zap.NewNop().Sugar())
 
-	_, err := svc.UploadPart(context.Background(), "bucket", "key", "upload-id", 1, bytes.NewReader([]byte("data")))
+	_, err := svc.UploadPart(context.Background(), "bucket", "key", "upload-id", 1, bytes.NewReader([]byte("data")), UploadPartOptions{})
 	if err != nil {
 		t.Errorf("UploadPart() should not fail with metadata put error: %v", err)
 	}
@@ -3155,9 +3522,9 @@ func (e *errorDeleteStorage) Delete(ctx context.Context, bucket, key string) err
 func TestObjectService_CompleteMultipartUpload_DeletePartError(t *testing.T) {
 	mockStorage := &errorDeleteStorage{MockStorageBackend: NewMockStorageBackend()}
 	mockStorage.CreateBucket(context.Background(), "bucket")
-	mockStorage.Put(context.Background(), "bucket", "bucket/key/upload-id/1", bytes.NewReader([]byte("part")), 4, storage.PutOptions{})
+	mockStorage.PutPart(context.Background(), "upload-id", 1, bytes.NewReader([]byte("part")), 4)
 
-	meta := NewMockMetadataStore()
+	meta := newUploadMetadataStore()
 	meta.PutPart(context.Background(), "bucket", "key", "upload-id", 1, &metadata.PartMetadata{PartNumber: 1})
 
 	svc := New(mockStorage, meta, zap.NewNop().Sugar())
@@ -3171,9 +3538,10 @@ func TestObjectService_CompleteMultipartUpload_DeletePartError(t *testing.T) {
 func TestObjectService_AbortMultipartUpload_WithParts(t *testing.T) {
 	mockStorage := NewMockStorageBackend()
 	mockStorage.CreateBucket(context.Background(), "bucket")
-	mockStorage.Put(context.Background(), "bucket", "bucket/key/upload-id/1", bytes.NewReader([]byte("part")), 4, storage.PutOptions{})
+	mockStorage.PutPart(context.Background(), "upload-id", 1, bytes.NewReader([]byte("part")), 4)
 
 	meta := NewMockMetadataStore()
+	meta.CreateMultipartUpload(context.Background(), "bucket", "key", "upload-id", &metadata.ObjectMetadata{})
 	meta.PutPart(context.Background(), "bucket", "key", "upload-id", 1, &metadata.PartMetadata{PartNumber: 1})
 
 	svc := New(mockStorage, meta, zap.NewNop().Sugar())
@@ -3247,9 +3615,9 @@ func (e *errorCompleteMultipartMetadata) CompleteMultipartUpload(ctx context.Con
 func TestObjectService_CompleteMultipartUpload_CompleteError(t *testing.T) {
 	mockStorage := NewMockStorageBackend()
 	mockStorage.CreateBucket(context.Background(), "bucket")
-	mockStorage.Put(context.Background(), "bucket", "bucket/key/upload-id/1", bytes.NewReader([]byte("part")), 4, storage.PutOptions{})
+	mockStorage.PutPart(context.Background(), "upload-id", 1, bytes.NewReader([]byte("part")), 4)
 
-	meta := NewMockMetadataStore()
+	meta := newUploadMetadataStore()
 	meta.PutPart(context.Background(), "bucket", "key", "upload-id", 1, &metadata.PartMetadata{PartNumber: 1})
 
 	errMeta := &errorCompleteMultipartMetadata{MockMetadataStore: meta, completeMpuErr: fmt.Errorf("complete error")}
@@ -3284,9 +3652,9 @@ func (c *copyErrorReader) Read(p []byte) (n int, err error) {
 }
 
 func TestObjectService_UploadPart_CopyError(t *testing.T) {
-	svc := New(NewMockStorageBackend(), NewMockMetadataStore(), zap.NewNop().Sugar())
+	svc := New(NewMockStorageBackend(), newUploadMetadataStore(), zap.NewNop().Sugar())
 
-	_, err := svc.UploadPart(context.Background(), "bucket", "key", "upload-id", 1, &copyErrorReader{})
+	_, err := svc.UploadPart(context.Background(), "bucket", "key", "upload-id", 1, &copyErrorReader{}, UploadPartOptions{})
 	if err == nil {
 		t.Error("UploadPart() should fail with copy error")
 	}
@@ -3296,14 +3664,14 @@ type errorReadAllStorage struct {
 	*MockStorageBackend
 }
 
-func (e *errorReadAllStorage) Get(ctx context.Context, bucket, key string, opts storage.GetOptions) (io.ReadCloser, error) {
+func (e *errorReadAllStorage) GetPart(ctx context.Context, uploadID string, partNumber int) (io.ReadCloser, error) {
 	return io.NopCloser(&errorReader{}), nil
 }
 
 func TestObjectService_CompleteMultipartUpload_ReadAllError(t *testing.T) {
 	mockStorage := &errorReadAllStorage{MockStorageBackend: NewMockStorageBackend()}
 	mockStorage.CreateBucket(context.Background(), "bucket")
-	mockStorage.Put(context.Background(), "bucket", "bucket/key/upload-id/1", bytes.NewReader([]byte("part")), 4, storage.PutOptions{})
+	mockStorage.PutPart(context.Background(), "upload-id", 1, bytes.NewReader([]byte("part")), 4)
 
 	meta := NewMockMetadataStore()
 	meta.PutPart(context.Background(), "bucket", "key", "upload-id", 1, &metadata.PartMetadata{PartNumber: 1})
@@ -3318,9 +3686,9 @@ func TestObjectService_CompleteMultipartUpload_ReadAllError(t *testing.T) {
 
 func TestObjectService_UploadPart_StoragePutError(t *testing.T) {
 	storage := &errorStorage{MockStorageBackend: NewMockStorageBackend(), putErr: fmt.Errorf("put error")}
-	svc := New(storage, NewMockMetadataStore(), zap.NewNop().Sugar())
+	svc := New(storage, newUploadMetadataStore(), zap.NewNop().Sugar())
 
-	_, err := svc.UploadPart(context.Background(), "bucket", "key", "upload-id", 1, bytes.NewReader([]byte("data")))
+	_, err := svc.UploadPart(context.Background(), "bucket", "key", "upload-id", 1, bytes.NewReader([]byte("data")), UploadPartOptions{})
 	if err == nil {
 		t.Error("UploadPart() should fail with storage put error")
 	}
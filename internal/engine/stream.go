@@ -0,0 +1,347 @@
+package engine
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/openendpoint/openendpoint/internal/encryption"
+	"github.com/openendpoint/openendpoint/internal/metadata"
+	"github.com/openendpoint/openendpoint/internal/storage"
+)
+
+// hashingReader computes the MD5 and any additional checksum of
+// everything read through it while enforcing the declared and maximum
+// object sizes, so uploads can be streamed straight to the storage backend
+// without buffering. A declared checksum is verified before the final EOF,
+// so a backend never commits data that does not match it.
+type hashingReader struct {
+	r        io.Reader
+	md5      hash.Hash
+	n        int64
+	expected int64 // declared size, 0 when unknown
+	max      int64
+
+	checksum     hash.Hash // additional checksum, nil when none
+	wantChecksum string    // declared base64 checksum, "" to only compute it
+}
+
+// newHashingReader wraps r; expected is the declared size (0 if unknown)
+// and max the largest size accepted (0 for no limit)
+func newHashingReader(r io.Reader, expected, max int64) *hashingReader {
+	return &hashingReader{
+		r:        r,
+		md5:      md5.New(),
+		expected: expected,
+		max:      max,
+	}
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	if n > 0 {
+		h.md5.Write(p[:n])
+		if h.checksum != nil {
+			h.checksum.Write(p[:n])
+		}
+		h.n += int64(n)
+	}
+
+	if h.max > 0 && h.n > h.max {
+		return n, fmt.Errorf("%w (%d bytes)", ErrEntityTooLarge, h.max)
+	}
+	if h.expected > 0 && h.n > h.expected {
+		return n, fmt.Errorf("received more than the declared %d bytes", h.expected)
+	}
+	if err == io.EOF && h.expected > 0 && h.n < h.expected {
+		return n, fmt.Errorf("%w: got %d of %d bytes", ErrIncompleteBody, h.n, h.expected)
+	}
+	if err == io.EOF && h.wantChecksum != "" && h.Checksum() != h.wantChecksum {
+		return n, fmt.Errorf("%w: declared %s, data has %s", ErrBadDigest, h.wantChecksum, h.Checksum())
+	}
+
+	return n, err
+}
+
+// addChecksum computes the additional checksum algorithm over the data and,
+// when want is not empty, verifies it matches
+func (h *hashingReader) addChecksum(algorithm, want string) error {
+	sum, err := newChecksum(algorithm)
+	if err != nil {
+		return err
+	}
+	if sum == nil {
+		return nil
+	}
+	h.checksum = sum
+	h.wantChecksum = want
+	return nil
+}
+
+// Size returns the number of bytes read so far
+func (h *hashingReader) Size() int64 {
+	return h.n
+}
+
+// MD5 returns the hex MD5 of the bytes read so far
+func (h *hashingReader) MD5() string {
+	return hex.EncodeToString(h.md5.Sum(nil))
+}
+
+// ETag returns the quoted S3 ETag for the bytes read so far
+func (h *hashingReader) ETag() string {
+	return fmt.Sprintf("\"%s\"", h.MD5())
+}
+
+// Checksum returns the base64 additional checksum of the bytes read so
+// far, or "" when none was requested
+func (h *hashingReader) Checksum() string {
+	if h.checksum == nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(h.checksum.Sum(nil))
+}
+
+// objectReader is the body returned by GetObject for whole-object reads. It
+// also implements io.Seeker so callers such as http.ServeContent can serve
+// byte ranges; seeking is lazy and the data is re-opened at the new offset
+// on the next Read.
+type objectReader struct {
+	open dataOpener
+	size int64
+
+	rc    io.ReadCloser // current data reader, positioned at rcOff
+	rcOff int64
+	off   int64 // logical offset of the next Read
+}
+
+func newObjectReader(open dataOpener, size int64, rc io.ReadCloser) *objectReader {
+	return &objectReader{
+		open: open,
+		size: size,
+		rc:   rc,
+	}
+}
+
+func (o *objectReader) Read(p []byte) (int, error) {
+	if o.off >= o.size {
+		return 0, io.EOF
+	}
+
+	if o.rc != nil && o.rcOff != o.off {
+		o.rc.Close()
+		o.rc = nil
+	}
+	if o.rc == nil {
+		rc, err := o.open(o.off, o.size)
+		if err != nil {
+			return 0, fmt.Errorf("failed to reopen object at offset %d: %w", o.off, err)
+		}
+		o.rc = rc
+		o.rcOff = o.off
+	}
+
+	if remaining := o.size - o.off; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := o.rc.Read(p)
+	o.off += int64(n)
+	o.rcOff += int64(n)
+	if err == io.EOF && o.off < o.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (o *objectReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = o.off + offset
+	case io.SeekEnd:
+		abs = o.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if abs < 0 {
+		return 0, fmt.Errorf("negative position %d", abs)
+	}
+	o.off = abs
+	return abs, nil
+}
+
+func (o *objectReader) Close() error {
+	if o.rc == nil {
+		return nil
+	}
+	err := o.rc.Close()
+	o.rc = nil
+	return err
+}
+
+// sealedReader decrypts plaintext bytes [off, end) of an object stored as
+// sealed segments, fetching only the chunks of each segment the range
+// overlaps and opening a segment only when the previous one is exhausted
+type sealedReader struct {
+	ctx      context.Context
+	storage  storage.StorageBackend
+	bucket   string
+	key      string
+	segments []sealedSegment // segments not yet opened
+	off, end int64
+
+	cur io.Reader // decrypting reader of the open segment
+	rc  io.ReadCloser
+}
+
+func newSealedReader(ctx context.Context, backend storage.StorageBackend, bucket, key string, segments []sealedSegment, off, end int64) *sealedReader {
+	return &sealedReader{
+		ctx:      ctx,
+		storage:  backend,
+		bucket:   bucket,
+		key:      key,
+		segments: segments,
+		off:      off,
+		end:      end,
+	}
+}
+
+func (r *sealedReader) Read(p []byte) (int, error) {
+	for {
+		if r.off >= r.end {
+			return 0, io.EOF
+		}
+		if r.cur == nil {
+			if err := r.open(); err != nil {
+				return 0, err
+			}
+		}
+
+		n, err := r.cur.Read(p)
+		r.off += int64(n)
+		if err == io.EOF {
+			r.Close()
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// open starts reading the segment holding the current offset
+func (r *sealedReader) open() error {
+	for len(r.segments) > 0 && r.segments[0].off+r.segments[0].size <= r.off {
+		r.segments = r.segments[1:]
+	}
+	if len(r.segments) == 0 {
+		return io.ErrUnexpectedEOF
+	}
+	seg := r.segments[0]
+	r.segments = r.segments[1:]
+
+	off, end := r.off-seg.off, min(r.end, seg.off+seg.size)-seg.off
+	start, stop := encryption.SealedRange(seg.size, off, end)
+	rc, err := r.storage.Get(r.ctx, r.bucket, r.key, storage.GetOptions{
+		Range: &storage.Range{Start: seg.sealedOff + start, End: seg.sealedOff + stop},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read encrypted data at offset %d: %w", r.off, err)
+	}
+	cur, err := encryption.NewDecryptReader(rc, seg.key, seg.size, off, end)
+	if err != nil {
+		rc.Close()
+		return err
+	}
+	r.rc, r.cur = rc, cur
+	return nil
+}
+
+func (r *sealedReader) Close() error {
+	r.cur = nil
+	if r.rc == nil {
+		return nil
+	}
+	err := r.rc.Close()
+	r.rc = nil
+	return err
+}
+
+// partsReader concatenates the data of multipart upload parts, opening
+// each part only when the previous one is exhausted so completion never
+// holds more than one part open or in memory
+type partsReader struct {
+	ctx      context.Context
+	storage  storage.StorageBackend
+	uploadID string
+	parts    []metadata.PartMetadata
+
+	cur io.ReadCloser
+}
+
+func newPartsReader(ctx context.Context, backend storage.StorageBackend, uploadID string, parts []metadata.PartMetadata) *partsReader {
+	return &partsReader{
+		ctx:      ctx,
+		storage:  backend,
+		uploadID: uploadID,
+		parts:    parts,
+	}
+}
+
+func (p *partsReader) Read(buf []byte) (int, error) {
+	for {
+		if p.cur == nil {
+			if len(p.parts) == 0 {
+				return 0, io.EOF
+			}
+			part := p.parts[0]
+			rc, err := p.storage.GetPart(p.ctx, p.uploadID, part.PartNumber)
+			if err != nil {
+				return 0, fmt.Errorf("failed to read part %d: %w", part.PartNumber, err)
+			}
+			p.cur = rc
+			p.parts = p.parts[1:]
+		}
+
+		n, err := p.cur.Read(buf)
+		if err == io.EOF {
+			p.cur.Close()
+			p.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (p *partsReader) Close() error {
+	if p.cur == nil {
+		return nil
+	}
+	err := p.cur.Close()
+	p.cur = nil
+	return err
+}
+
+// multipartETag returns the S3 ETag of a multipart object: the MD5 of the
+// concatenated binary part MD5s, followed by the number of parts
+func multipartETag(parts []metadata.PartMetadata) (string, error) {
+	h := md5.New()
+	for _, p := range parts {
+		sum, err := hex.DecodeString(normalizeETag(p.ETag))
+		if err != nil {
+			return "", fmt.Errorf("%w: part %d has a malformed ETag", ErrInvalidPart, p.PartNumber)
+		}
+		h.Write(sum)
+	}
+	return fmt.Sprintf("\"%s-%d\"", hex.EncodeToString(h.Sum(nil)), len(parts)), nil
+}
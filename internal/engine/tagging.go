@@ -0,0 +1,50 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/openendpoint/openendpoint/internal/tags"
+)
+
+// GetObjectTagging gets the tags of a version of an object, the latest when
+// versionID is empty, and the ID of that version
+func (s *ObjectService) GetObjectTagging(ctx context.Context, bucket, key, versionID string) (map[string]string, string, error) {
+	meta, err := s.versionTarget(ctx, bucket, key, versionID)
+	if err != nil {
+		return nil, "", err
+	}
+	return meta.Tags, meta.VersionID, nil
+}
+
+// PutObjectTagging replaces the tags of a version of an object, the latest
+// when versionID is empty, and returns the ID of that version. An empty
+// tag set removes them.
+func (s *ObjectService) PutObjectTagging(ctx context.Context, bucket, key, versionID string, tagSet tags.TagSet) (string, error) {
+	if err := tags.NewTagValidator().Validate(tagSet); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidTag, err)
+	}
+
+	unlock := s.locker.Lock(bucket, key)
+	defer unlock()
+
+	meta, err := s.versionTarget(ctx, bucket, key, versionID)
+	if err != nil {
+		return "", err
+	}
+	updated := *meta
+	updated.Tags = nil
+	if len(tagSet) > 0 {
+		updated.Tags = tagSet.ToMap()
+	}
+	if err := s.metadata.UpdateObjectVersion(ctx, bucket, key, &updated); err != nil {
+		return "", fmt.Errorf("failed to save object tags: %w", err)
+	}
+	return meta.VersionID, nil
+}
+
+// DeleteObjectTagging removes the tags of a version of an object, the
+// latest when versionID is empty, and returns the ID of that version
+func (s *ObjectService) DeleteObjectTagging(ctx context.Context, bucket, key, versionID string) (string, error) {
+	return s.PutObjectTagging(ctx, bucket, key, versionID, nil)
+}
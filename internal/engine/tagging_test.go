@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/openendpoint/openendpoint/internal/metadata"
+	"github.com/openendpoint/openendpoint/internal/tags"
+)
+
+func TestObjectService_ObjectTagging(t *testing.T) {
+	svc := newVersioningTestService(t)
+	ctx := context.Background()
+	_ = svc.CreateBucket(ctx, "test-bucket")
+	_ = svc.PutBucketVersioning(ctx, "test-bucket", &metadata.BucketVersioning{Status: VersioningEnabled})
+
+	opts := PutObjectOptions{Size: 3, Metadata: map[string]string{"team": "web"}}
+	first, err := svc.PutObject(ctx, "test-bucket", "key", strings.NewReader("one"), opts)
+	if err != nil {
+		t.Fatalf("PutObject() error: %v", err)
+	}
+	if got, _, err := svc.GetObjectTagging(ctx, "test-bucket", "key", ""); err != nil || len(got) != 0 {
+		t.Errorf("GetObjectTagging() = %v, %v, expected no tags rather than user metadata", got, err)
+	}
+
+	tagSet := tags.TagSet{{Key: "team", Value: "data"}, {Key: "env", Value: "prod"}}
+	if versionID, err := svc.PutObjectTagging(ctx, "test-bucket", "key", "", tagSet); err != nil || versionID != first.VersionID {
+		t.Fatalf("PutObjectTagging() = %q, %v, expected version %q", versionID, err, first.VersionID)
+	}
+	got, versionID, err := svc.GetObjectTagging(ctx, "test-bucket", "key", "")
+	if err != nil || versionID != first.VersionID || got["team"] != "data" || got["env"] != "prod" || len(got) != 2 {
+		t.Errorf("GetObjectTagging() = %v, %q, %v, expected the stored tags", got, versionID, err)
+	}
+
+	// Tags belong to the version they were set on
+	second := putString(t, svc, "test-bucket", "key", "two")
+	if got, _, _ := svc.GetObjectTagging(ctx, "test-bucket", "key", ""); len(got) != 0 {
+		t.Errorf("GetObjectTagging(latest) = %v, expected none on the new version", got)
+	}
+	if got, _, _ := svc.GetObjectTagging(ctx, "test-bucket", "key", first.VersionID); got["team"] != "data" {
+		t.Errorf("GetObjectTagging(first) = %v, expected its own tags", got)
+	}
+	if _, err := svc.DeleteObjectTagging(ctx, "test-bucket", "key", first.VersionID); err != nil {
+		t.Errorf("DeleteObjectTagging() error: %v", err)
+	}
+	if got, _, _ := svc.GetObjectTagging(ctx, "test-bucket", "key", first.VersionID); len(got) != 0 {
+		t.Errorf("GetObjectTagging(first) after delete = %v, expected none", got)
+	}
+
+	tooMany := make(tags.TagSet, 11)
+	for i := range tooMany {
+		tooMany[i] = tags.Tag{Key: string(rune('a' + i)), Value: "v"}
+	}
+	for _, invalid := range []tags.TagSet{tooMany, {{Key: ""}}, {{Key: "k"}, {Key: "k"}}, {{Key: strings.Repeat("k", 129)}}} {
+		if _, err := svc.PutObjectTagging(ctx, "test-bucket", "key", second.VersionID, invalid); !errors.Is(err, ErrInvalidTag) {
+			t.Errorf("PutObjectTagging(%d tags) error = %v, expected %v", len(invalid), err, ErrInvalidTag)
+		}
+	}
+	if _, err := svc.PutObjectTagging(ctx, "test-bucket", "missing", "", tagSet); !errors.Is(err, ErrNoSuchKey) {
+		t.Errorf("PutObjectTagging(missing) error = %v, expected %v", err, ErrNoSuchKey)
+	}
+
+	marker, _ := svc.DeleteObject(ctx, "test-bucket", "key", DeleteObjectOptions{})
+	var derr *DeleteMarkerError
+	if _, _, err := svc.GetObjectTagging(ctx, "test-bucket", "key", marker.VersionID); !errors.As(err, &derr) || !derr.Explicit {
+		t.Errorf("GetObjectTagging(marker) error = %v, expected an explicit delete marker error", err)
+	}
+}
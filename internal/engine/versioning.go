@@ -0,0 +1,110 @@
+package engine
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/openendpoint/openendpoint/internal/metadata"
+)
+
+// Bucket versioning states as stored in metadata.BucketVersioning
+const (
+	VersioningEnabled   = "Enabled"
+	VersioningSuspended = "Suspended"
+)
+
+// versionsBucket is the backend bucket holding the data of non-null object
+// versions. Underscores are not valid in S3 bucket names, so it can never
+// collide with a user bucket.
+const versionsBucket = "_versions"
+
+// versionDataKey returns the backend key of a version's data inside
+// versionsBucket; the version ID comes first so keys never collide
+func versionDataKey(bucket, key, versionID string) string {
+	return bucket + "/" + versionID + "/" + key
+}
+
+// dataLocation returns the backend bucket and key holding the data of a
+// version of bucket/key
+func dataLocation(bucket, key string, meta *metadata.ObjectMetadata) (string, string) {
+	if meta.VersionedData {
+		return versionsBucket, versionDataKey(bucket, key, meta.VersionID)
+	}
+	return bucket, key
+}
+
+// versioningStatus returns the versioning state of a bucket, "" when
+// versioning was never configured
+func (s *ObjectService) versioningStatus(ctx context.Context, bucket string) string {
+	v, err := s.metadata.GetBucketVersioning(ctx, bucket)
+	if err != nil || v == nil {
+		return ""
+	}
+	switch v.Status {
+	case VersioningEnabled, VersioningSuspended:
+		return v.Status
+	}
+	return ""
+}
+
+// versionTarget describes the version a write to a key creates
+type versionTarget struct {
+	meta   metadata.ObjectMetadata // Bucket, Key, VersionID and VersionedData set
+	status string                  // bucket versioning state
+}
+
+// newVersionTarget picks the version ID and data location for a new
+// version of bucket/key: a fresh version in versioned buckets, otherwise
+// the null version stored at the key itself
+func (s *ObjectService) newVersionTarget(ctx context.Context, bucket, key string) versionTarget {
+	t := versionTarget{
+		meta: metadata.ObjectMetadata{
+			Bucket:    bucket,
+			Key:       key,
+			VersionID: metadata.NullVersionID,
+		},
+		status: s.versioningStatus(ctx, bucket),
+	}
+	if t.status == VersioningEnabled {
+		t.meta.VersionID = uuid.New().String()
+		t.meta.VersionedData = true
+	}
+	return t
+}
+
+// location returns the backend bucket and key the new version is written to
+func (t versionTarget) location() (string, string) {
+	return dataLocation(t.meta.Bucket, t.meta.Key, &t.meta)
+}
+
+// discardVersionData removes the data written for a new version whose
+// metadata could not be saved. Null versions are written over the data of
+// the version they replace, so theirs is left in place.
+func (s *ObjectService) discardVersionData(ctx context.Context, t versionTarget) {
+	if !t.meta.VersionedData {
+		return
+	}
+	dataBucket, dataKey := t.location()
+	if err := s.storage.Delete(ctx, dataBucket, dataKey); err != nil {
+		s.logger.Warnw("failed to delete data of unsaved version", "bucket", dataBucket, "key", dataKey, "error", err)
+	}
+}
+
+// responseVersionID is the version ID reported to clients, which is empty
+// for buckets that never had versioning configured
+func (t versionTarget) responseVersionID() string {
+	if t.status == "" {
+		return ""
+	}
+	return t.meta.VersionID
+}
+
+// reportedVersionID maps a stored version ID to the one reported to
+// clients: the null version of a bucket that never had versioning
+// configured is not reported at all
+func (s *ObjectService) reportedVersionID(ctx context.Context, bucket, versionID string) string {
+	if versionID == metadata.NullVersionID && s.versioningStatus(ctx, bucket) == "" {
+		return ""
+	}
+	return versionID
+}
@@ -0,0 +1,223 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/openendpoint/openendpoint/internal/metadata"
+	"github.com/openendpoint/openendpoint/internal/metadata/pebble"
+	"github.com/openendpoint/openendpoint/internal/storage/flatfile"
+	"go.uber.org/zap"
+)
+
+// newVersioningTestService builds a service over a real flat file backend
+// and pebble store, since the mocks ignore version IDs
+func newVersioningTestService(t *testing.T) *ObjectService {
+	t.Helper()
+
+	backend, err := flatfile.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := pebble.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+		backend.Close()
+	})
+
+	return New(backend, store, zap.NewNop().Sugar())
+}
+
+func putString(t *testing.T, svc *ObjectService, bucket, key, body string) *ObjectResult {
+	t.Helper()
+	result, err := svc.PutObject(context.Background(), bucket, key, strings.NewReader(body), PutObjectOptions{Size: int64(len(body))})
+	if err != nil {
+		t.Fatalf("PutObject(%s) error: %v", key, err)
+	}
+	return result
+}
+
+func getString(t *testing.T, svc *ObjectService, bucket, key, versionID string) (string, *GetObjectResult, error) {
+	t.Helper()
+	result, err := svc.GetObject(context.Background(), bucket, key, GetObjectOptions{VersionID: versionID})
+	if err != nil {
+		return "", nil, err
+	}
+	defer result.Body.Close()
+	data, err := io.ReadAll(result.Body)
+	if err != nil {
+		t.Fatalf("read body error: %v", err)
+	}
+	return string(data), result, nil
+}
+
+func TestObjectService_VersioningUnversionedBucket(t *testing.T) {
+	svc := newVersioningTestService(t)
+	ctx := context.Background()
+	_ = svc.CreateBucket(ctx, "test-bucket")
+
+	if result := putString(t, svc, "test-bucket", "key", "one"); result.VersionID != "" {
+		t.Errorf("PutObject() VersionID = %q, expected none", result.VersionID)
+	}
+	putString(t, svc, "test-bucket", "key", "two")
+
+	body, result, err := getString(t, svc, "test-bucket", "key", "")
+	if err != nil {
+		t.Fatalf("GetObject() error: %v", err)
+	}
+	if body != "two" || result.VersionID != "" {
+		t.Errorf("GetObject() = %q version %q, expected \"two\" without version", body, result.VersionID)
+	}
+
+	deleted, err := svc.DeleteObject(ctx, "test-bucket", "key", DeleteObjectOptions{})
+	if err != nil {
+		t.Fatalf("DeleteObject() error: %v", err)
+	}
+	if deleted.DeleteMarker {
+		t.Error("DeleteObject() created a delete marker in an unversioned bucket")
+	}
+	if _, _, err := getString(t, svc, "test-bucket", "key", ""); err == nil {
+		t.Error("GetObject() expected error after delete")
+	}
+}
+
+func TestObjectService_VersioningEnabled(t *testing.T) {
+	svc := newVersioningTestService(t)
+	ctx := context.Background()
+	_ = svc.CreateBucket(ctx, "test-bucket")
+	_ = svc.PutBucketVersioning(ctx, "test-bucket", &metadata.BucketVersioning{Status: VersioningEnabled})
+
+	v1 := putString(t, svc, "test-bucket", "key", "one").VersionID
+	v2 := putString(t, svc, "test-bucket", "key", "two").VersionID
+	if v1 == "" || v2 == "" || v1 == v2 || v1 == metadata.NullVersionID {
+		t.Fatalf("PutObject() version IDs %q and %q, expected two distinct IDs", v1, v2)
+	}
+
+	for vid, want := range map[string]string{"": "two", v1: "one", v2: "two"} {
+		body, _, err := getString(t, svc, "test-bucket", "key", vid)
+		if err != nil {
+			t.Fatalf("GetObject(%q) error: %v", vid, err)
+		}
+		if body != want {
+			t.Errorf("GetObject(%q) = %q, expected %q", vid, body, want)
+		}
+	}
+
+	// A plain delete only hides the object behind a marker
+	deleted, err := svc.DeleteObject(ctx, "test-bucket", "key", DeleteObjectOptions{})
+	if err != nil {
+		t.Fatalf("DeleteObject() error: %v", err)
+	}
+	if !deleted.DeleteMarker || deleted.VersionID == "" {
+		t.Fatalf("DeleteObject() = %+v, expected a versioned delete marker", deleted)
+	}
+
+	var dmErr *DeleteMarkerError
+	_, _, err = getString(t, svc, "test-bucket", "key", "")
+	if !errors.As(err, &dmErr) || dmErr.Explicit || dmErr.VersionID != deleted.VersionID {
+		t.Errorf("GetObject() error = %v, expected implicit delete marker error", err)
+	}
+	_, err = svc.HeadObject(ctx, "test-bucket", "key", HeadObjectOptions{VersionID: deleted.VersionID})
+	if !errors.As(err, &dmErr) || !dmErr.Explicit {
+		t.Errorf("HeadObject(marker) error = %v, expected explicit delete marker error", err)
+	}
+	if body, _, err := getString(t, svc, "test-bucket", "key", v1); err != nil || body != "one" {
+		t.Errorf("GetObject(v1) = %q, %v after delete marker", body, err)
+	}
+
+	list, err := svc.ListObjects(ctx, "test-bucket", ListObjectsOptions{MaxKeys: 1000})
+	if err != nil {
+		t.Fatalf("ListObjects() error: %v", err)
+	}
+	if len(list.Objects) != 0 {
+		t.Errorf("ListObjects() returned %d objects, expected delete marker to hide the key", len(list.Objects))
+	}
+
+	// Removing the marker restores the previous version
+	if _, err := svc.DeleteObject(ctx, "test-bucket", "key", DeleteObjectOptions{VersionID: deleted.VersionID}); err != nil {
+		t.Fatalf("DeleteObject(marker) error: %v", err)
+	}
+	if body, _, err := getString(t, svc, "test-bucket", "key", ""); err != nil || body != "two" {
+		t.Errorf("GetObject() = %q, %v after removing marker", body, err)
+	}
+
+	// Permanently deleting a version removes its data
+	if _, err := svc.DeleteObject(ctx, "test-bucket", "key", DeleteObjectOptions{VersionID: v2}); err != nil {
+		t.Fatalf("DeleteObject(v2) error: %v", err)
+	}
+	if body, _, err := getString(t, svc, "test-bucket", "key", ""); err != nil || body != "one" {
+		t.Errorf("GetObject() = %q, %v after deleting v2", body, err)
+	}
+	if _, _, err := getString(t, svc, "test-bucket", "key", v2); err == nil {
+		t.Error("GetObject(v2) expected error after permanent delete")
+	}
+
+	if err := svc.DeleteBucket(ctx, "test-bucket"); err == nil {
+		t.Error("DeleteBucket() expected error while versions remain")
+	}
+}
+
+func TestObjectService_VersioningSuspended(t *testing.T) {
+	svc := newVersioningTestService(t)
+	ctx := context.Background()
+	_ = svc.CreateBucket(ctx, "test-bucket")
+	_ = svc.PutBucketVersioning(ctx, "test-bucket", &metadata.BucketVersioning{Status: VersioningEnabled})
+
+	v1 := putString(t, svc, "test-bucket", "key", "one").VersionID
+
+	_ = svc.PutBucketVersioning(ctx, "test-bucket", &metadata.BucketVersioning{Status: VersioningSuspended})
+
+	if vid := putString(t, svc, "test-bucket", "key", "two").VersionID; vid != metadata.NullVersionID {
+		t.Errorf("PutObject() VersionID = %q, expected null", vid)
+	}
+	// The null version is overwritten in place
+	putString(t, svc, "test-bucket", "key", "three")
+
+	if body, _, err := getString(t, svc, "test-bucket", "key", metadata.NullVersionID); err != nil || body != "three" {
+		t.Errorf("GetObject(null) = %q, %v, expected \"three\"", body, err)
+	}
+	if body, _, err := getString(t, svc, "test-bucket", "key", v1); err != nil || body != "one" {
+		t.Errorf("GetObject(v1) = %q, %v, expected \"one\"", body, err)
+	}
+
+	deleted, err := svc.DeleteObject(ctx, "test-bucket", "key", DeleteObjectOptions{})
+	if err != nil {
+		t.Fatalf("DeleteObject() error: %v", err)
+	}
+	if !deleted.DeleteMarker || deleted.VersionID != metadata.NullVersionID {
+		t.Errorf("DeleteObject() = %+v, expected null delete marker", deleted)
+	}
+	if _, _, err := getString(t, svc, "test-bucket", "key", metadata.NullVersionID); !errors.Is(err, ErrDeleteMarker) {
+		t.Errorf("GetObject(null) error = %v, expected delete marker", err)
+	}
+	if body, _, err := getString(t, svc, "test-bucket", "key", v1); err != nil || body != "one" {
+		t.Errorf("GetObject(v1) = %q, %v after suspended delete", body, err)
+	}
+}
+
+func TestObjectService_CopyObjectSourceVersion(t *testing.T) {
+	svc := newVersioningTestService(t)
+	ctx := context.Background()
+	_ = svc.CreateBucket(ctx, "test-bucket")
+	_ = svc.PutBucketVersioning(ctx, "test-bucket", &metadata.BucketVersioning{Status: VersioningEnabled})
+
+	v1 := putString(t, svc, "test-bucket", "src", "one").VersionID
+	putString(t, svc, "test-bucket", "src", "two")
+
+	result, err := svc.CopyObject(ctx, "test-bucket", "src", "test-bucket", "dst", CopyObjectOptions{SourceVersionID: v1})
+	if err != nil {
+		t.Fatalf("CopyObject() error: %v", err)
+	}
+	if result.SourceVersionID != v1 || result.VersionID == "" {
+		t.Errorf("CopyObject() = %+v, expected source %q and a new version", result, v1)
+	}
+	if body, _, err := getString(t, svc, "test-bucket", "dst", ""); err != nil || body != "one" {
+		t.Errorf("GetObject(dst) = %q, %v, expected \"one\"", body, err)
+	}
+}
@@ -0,0 +1,104 @@
+package iam
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidPolicy is returned for a policy document that cannot be
+// enforced. It is wrapped with the problem found.
+var ErrInvalidPolicy = errors.New("invalid policy")
+
+// s3ARNPrefix starts the ARN of every S3 bucket and object
+const s3ARNPrefix = "arn:aws:s3:::"
+
+// BucketARN returns the ARN of a bucket
+func BucketARN(bucket string) string {
+	return s3ARNPrefix + bucket
+}
+
+// ObjectARN returns the ARN of an object
+func ObjectARN(bucket, key string) string {
+	return s3ARNPrefix + bucket + "/" + key
+}
+
+// ParseBucketPolicy parses the policy document of bucket and checks that
+// it can be enforced: every statement has an effect, a principal, S3
+// actions, resources in the bucket and supported conditions.
+func ParseBucketPolicy(data []byte, bucket string) (*IAMPolicy, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var policy IAMPolicy
+	if err := dec.Decode(&policy); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+	}
+
+	switch policy.Version {
+	case "", "2012-10-17", "2008-10-17":
+	default:
+		return nil, fmt.Errorf("%w: unsupported version %q", ErrInvalidPolicy, policy.Version)
+	}
+	if len(policy.Statements) == 0 {
+		return nil, fmt.Errorf("%w: the policy has no statements", ErrInvalidPolicy)
+	}
+	for i := range policy.Statements {
+		if err := validateBucketStatement(&policy.Statements[i], bucket); err != nil {
+			name := policy.Statements[i].Sid
+			if name == "" {
+				name = fmt.Sprintf("%d", i+1)
+			}
+			return nil, fmt.Errorf("%w: statement %s: %v", ErrInvalidPolicy, name, err)
+		}
+	}
+	return &policy, nil
+}
+
+// validateBucketStatement checks a statement of a bucket policy
+func validateBucketStatement(s *IAMStatement, bucket string) error {
+	if s.Effect != "Allow" && s.Effect != "Deny" {
+		return fmt.Errorf("effect %q must be Allow or Deny", s.Effect)
+	}
+
+	switch {
+	case (s.Principal == nil) == (s.NotPrincipal == nil):
+		return errors.New("exactly one of Principal and NotPrincipal is required")
+	case s.Principal != nil && len(s.Principal.AWS)+len(s.Principal.Service) == 0,
+		s.NotPrincipal != nil && len(s.NotPrincipal.AWS)+len(s.NotPrincipal.Service) == 0:
+		return errors.New("the principal names no one")
+	}
+
+	if (len(s.Actions) == 0) == (len(s.NotActions) == 0) {
+		return errors.New("exactly one of Action and NotAction is required")
+	}
+	for _, actions := range []StringList{s.Actions, s.NotActions} {
+		for _, action := range actions {
+			if action != "*" && !strings.HasPrefix(strings.ToLower(action), "s3:") {
+				return fmt.Errorf("action %q is not an S3 action", action)
+			}
+		}
+	}
+
+	if (len(s.Resources) == 0) == (len(s.NotResources) == 0) {
+		return errors.New("exactly one of Resource and NotResource is required")
+	}
+	for _, resources := range []StringList{s.Resources, s.NotResources} {
+		for _, resource := range resources {
+			if !inBucket(resource, bucket) {
+				return fmt.Errorf("resource %q is not bucket %q or an object in it", resource, bucket)
+			}
+		}
+	}
+
+	return s.Condition.validate()
+}
+
+// inBucket reports whether a resource pattern names the bucket or objects
+// in it
+func inBucket(resource, bucket string) bool {
+	rest, ok := strings.CutPrefix(resource, s3ARNPrefix)
+	name, _, _ := strings.Cut(rest, "/")
+	return ok && name == bucket
+}
@@ -0,0 +1,75 @@
+package iam
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseBucketPolicy(t *testing.T) {
+	valid := `{
+		"Version": "2012-10-17",
+		"Statement": [{
+			"Sid": "PublicRead",
+			"Effect": "Allow",
+			"Principal": "*",
+			"Action": ["s3:GetObject"],
+			"Resource": "arn:aws:s3:::photos/*",
+			"Condition": {
+				"IpAddress": {"aws:SourceIp": ["10.0.0.0/8"]},
+				"Bool": {"aws:SecureTransport": true},
+				"DateGreaterThan": {"aws:CurrentTime": "2024-01-01T00:00:00Z"},
+				"StringLikeIfExists": {"s3:ExistingObjectTag/team": "web-*"}
+			}
+		}]
+	}`
+	policy, err := ParseBucketPolicy([]byte(valid), "photos")
+	if err != nil {
+		t.Fatalf("ParseBucketPolicy() error: %v", err)
+	}
+	stmt := policy.Statements[0]
+	if len(stmt.Principal.AWS) != 1 || stmt.Principal.AWS[0] != "*" {
+		t.Errorf("Principal = %+v, want *", stmt.Principal)
+	}
+	if got := stmt.Condition["Bool"]["aws:SecureTransport"]; len(got) != 1 || got[0] != "true" {
+		t.Errorf("Bool condition values = %v, want [true]", got)
+	}
+
+	stmt1 := func(fields string) string {
+		return `{"Version": "2012-10-17", "Statement": [{` + fields + `}]}`
+	}
+	base := `"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*"`
+	tests := []struct {
+		name   string
+		policy string
+		want   string
+	}{
+		{"malformed JSON", `{"Statement": [`, "unexpected EOF"},
+		{"unknown field", `{"Statement": [{` + base + `, "Actions": ["s3:*"]}]}`, `unknown field "Actions"`},
+		{"version", `{"Version": "2020-01-01", "Statement": [{` + base + `}]}`, `unsupported version`},
+		{"no statements", `{"Version": "2012-10-17", "Statement": []}`, "no statements"},
+		{"effect", stmt1(`"Effect": "Permit", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*"`), `effect "Permit"`},
+		{"no principal", stmt1(`"Effect": "Allow", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*"`), "Principal"},
+		{"bad principal", stmt1(`"Effect": "Allow", "Principal": "alice", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*"`), `principal "alice"`},
+		{"no action", stmt1(`"Effect": "Allow", "Principal": "*", "Resource": "arn:aws:s3:::photos/*"`), "Action"},
+		{"non-S3 action", stmt1(`"Effect": "Allow", "Principal": "*", "Action": "iam:CreateUser", "Resource": "arn:aws:s3:::photos/*"`), `"iam:CreateUser" is not an S3 action`},
+		{"other bucket", stmt1(`"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::videos/*"`), `resource "arn:aws:s3:::videos/*"`},
+		{"bucket prefix", stmt1(`"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos-old/*"`), "is not bucket"},
+		{"unknown operator", stmt1(base + `, "Condition": {"NumericLessThan": {"s3:max-keys": "10"}}`), `operator "NumericLessThan"`},
+		{"unknown key", stmt1(base + `, "Condition": {"StringEquals": {"aws:PrincipalOrgID": "o-1"}}`), `key "aws:PrincipalOrgID"`},
+		{"operator and key kinds", stmt1(base + `, "Condition": {"StringEquals": {"aws:SourceIp": "10.0.0.1"}}`), "cannot be used with IP address key"},
+		{"bad CIDR", stmt1(base + `, "Condition": {"IpAddress": {"aws:SourceIp": "10.0.0.0/99"}}`), "not an IP address"},
+		{"bad date", stmt1(base + `, "Condition": {"DateLessThan": {"aws:CurrentTime": "tomorrow"}}`), "not an RFC 3339 date"},
+		{"bad bool", stmt1(base + `, "Condition": {"Bool": {"aws:SecureTransport": "yes"}}`), "not true or false"},
+		{"no values", stmt1(base + `, "Condition": {"StringLike": {"s3:prefix": []}}`), "has no values"},
+		{"Null IfExists", stmt1(base + `, "Condition": {"NullIfExists": {"s3:prefix": "true"}}`), `operator "NullIfExists"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseBucketPolicy([]byte(tt.policy), "photos")
+			if !errors.Is(err, ErrInvalidPolicy) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseBucketPolicy() error = %v, want ErrInvalidPolicy containing %q", err, tt.want)
+			}
+		})
+	}
+}
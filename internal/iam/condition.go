@@ -0,0 +1,247 @@
+package iam
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Condition keys of S3 requests
+const (
+	KeySourceIP        = "aws:SourceIp"        // the client's IP address
+	KeySecureTransport = "aws:SecureTransport" // whether the request came over TLS
+	KeyCurrentTime     = "aws:CurrentTime"     // the time of the request, RFC 3339
+	KeyUsername        = "aws:username"        // the IAM user's name, missing for other requesters
+	KeyPrefix          = "s3:prefix"           // the prefix of a listing
+	KeyDelimiter       = "s3:delimiter"        // the delimiter of a listing
+	KeyACL             = "s3:x-amz-acl"        // the canned ACL of a write
+
+	// KeyExistingObjectTagPrefix followed by a tag key names the value of
+	// that tag on the object the request addresses
+	KeyExistingObjectTagPrefix = "s3:ExistingObjectTag/"
+)
+
+// conditionType is the kind of value a condition key holds
+type conditionType int
+
+const (
+	stringCondition conditionType = iota
+	ipCondition
+	dateCondition
+	boolCondition
+)
+
+func (t conditionType) String() string {
+	switch t {
+	case ipCondition:
+		return "IP address"
+	case dateCondition:
+		return "date"
+	case boolCondition:
+		return "boolean"
+	default:
+		return "string"
+	}
+}
+
+// conditionKeyTypes holds the supported condition keys but the tag keys
+var conditionKeyTypes = map[string]conditionType{
+	KeySourceIP:        ipCondition,
+	KeySecureTransport: boolCondition,
+	KeyCurrentTime:     dateCondition,
+	KeyUsername:        stringCondition,
+	KeyPrefix:          stringCondition,
+	KeyDelimiter:       stringCondition,
+	KeyACL:             stringCondition,
+}
+
+// canonicalConditionKey returns the Key constant spelling of a condition
+// key, whose names are case-insensitive but for tag keys, and its type. It
+// reports false for unsupported keys.
+func canonicalConditionKey(key string) (string, conditionType, bool) {
+	prefix := KeyExistingObjectTagPrefix
+	if len(key) > len(prefix) && strings.EqualFold(key[:len(prefix)], prefix) {
+		return prefix + key[len(prefix):], stringCondition, true
+	}
+	for name, kind := range conditionKeyTypes {
+		if strings.EqualFold(key, name) {
+			return name, kind, true
+		}
+	}
+	return key, stringCondition, false
+}
+
+// conditionOperator compares request values with policy values. A negated
+// operator holds when no policy value matches, or the key is missing.
+type conditionOperator struct {
+	kind    conditionType
+	negated bool
+	match   func(value, policyValue string) bool
+}
+
+// conditionOperators holds the supported condition operators but Null.
+// Each may also be written with an IfExists suffix, which makes it hold
+// when the key is missing.
+var conditionOperators = map[string]conditionOperator{
+	"StringEquals":              {stringCondition, false, stringEquals},
+	"StringNotEquals":           {stringCondition, true, stringEquals},
+	"StringEqualsIgnoreCase":    {stringCondition, false, strings.EqualFold},
+	"StringNotEqualsIgnoreCase": {stringCondition, true, strings.EqualFold},
+	"StringLike":                {stringCondition, false, stringLike},
+	"StringNotLike":             {stringCondition, true, stringLike},
+	"IpAddress":                 {ipCondition, false, ipInRange},
+	"NotIpAddress":              {ipCondition, true, ipInRange},
+	"DateEquals":                {dateCondition, false, dateCompare(func(c int) bool { return c == 0 })},
+	"DateNotEquals":             {dateCondition, true, dateCompare(func(c int) bool { return c == 0 })},
+	"DateLessThan":              {dateCondition, false, dateCompare(func(c int) bool { return c < 0 })},
+	"DateLessThanEquals":        {dateCondition, false, dateCompare(func(c int) bool { return c <= 0 })},
+	"DateGreaterThan":           {dateCondition, false, dateCompare(func(c int) bool { return c > 0 })},
+	"DateGreaterThanEquals":     {dateCondition, false, dateCompare(func(c int) bool { return c >= 0 })},
+	"Bool":                      {boolCondition, false, strings.EqualFold},
+}
+
+func stringEquals(value, policyValue string) bool {
+	return value == policyValue
+}
+
+func stringLike(value, policyValue string) bool {
+	return matchWildcard(policyValue, value)
+}
+
+// ipInRange reports whether an IP address is in a CIDR block, or is a
+// single address
+func ipInRange(value, policyValue string) bool {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return false
+	}
+	if _, block, err := net.ParseCIDR(policyValue); err == nil {
+		return block.Contains(ip)
+	}
+	return ip.Equal(net.ParseIP(policyValue))
+}
+
+// dateCompare returns a match comparing a request date with a policy date
+// by the sign of their difference
+func dateCompare(holds func(c int) bool) func(value, policyValue string) bool {
+	return func(value, policyValue string) bool {
+		v, err1 := parsePolicyDate(value)
+		p, err2 := parsePolicyDate(policyValue)
+		if err1 != nil || err2 != nil {
+			return false
+		}
+		return holds(v.Compare(p))
+	}
+}
+
+// parsePolicyDate parses an RFC 3339 date or Unix time in seconds
+func parsePolicyDate(s string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// matches reports whether a condition block holds for a request
+func (c IAMCondition) matches(rc *RequestContext) bool {
+	for name, keys := range c {
+		opName, ifExists := strings.CutSuffix(name, "IfExists")
+		op, known := conditionOperators[opName]
+		if !known && opName != "Null" {
+			return false
+		}
+
+		for key, values := range keys {
+			canonical, _, _ := canonicalConditionKey(key)
+			value, present := rc.value(canonical)
+			if opName == "Null" {
+				// Null: true requires the key to be missing, false present
+				if len(values) == 0 || strings.EqualFold(values[0], "true") == present {
+					return false
+				}
+				continue
+			}
+			if !present {
+				if ifExists || op.negated {
+					continue
+				}
+				return false
+			}
+
+			matched := false
+			for _, policyValue := range values {
+				if op.match(value, policyValue) {
+					matched = true
+					break
+				}
+			}
+			if matched == op.negated {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validate checks that a condition block only uses supported operators and
+// keys, with values of the kind each key holds
+func (c IAMCondition) validate() error {
+	for name, keys := range c {
+		opName, ifExists := strings.CutSuffix(name, "IfExists")
+		op, known := conditionOperators[opName]
+		switch {
+		case opName == "Null" && ifExists:
+			return fmt.Errorf("condition operator %q is not supported", name)
+		case !known && opName != "Null":
+			return fmt.Errorf("condition operator %q is not supported", name)
+		case len(keys) == 0:
+			return fmt.Errorf("condition operator %q has no keys", name)
+		}
+
+		for key, values := range keys {
+			_, kind, ok := canonicalConditionKey(key)
+			if !ok {
+				return fmt.Errorf("condition key %q is not supported", key)
+			}
+			if len(values) == 0 {
+				return fmt.Errorf("condition %s on %q has no values", name, key)
+			}
+			if opName == "Null" {
+				if len(values) != 1 || (values[0] != "true" && values[0] != "false") {
+					return fmt.Errorf("condition Null on %q must be true or false", key)
+				}
+				continue
+			}
+			if op.kind != kind {
+				return fmt.Errorf("condition operator %s cannot be used with %s key %q", name, kind, key)
+			}
+			for _, value := range values {
+				if err := validateConditionValue(kind, value); err != nil {
+					return fmt.Errorf("condition %s on %q: %w", name, key, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validateConditionValue checks that a policy value is of a key's kind
+func validateConditionValue(kind conditionType, value string) error {
+	switch kind {
+	case ipCondition:
+		if _, _, err := net.ParseCIDR(value); err != nil && net.ParseIP(value) == nil {
+			return fmt.Errorf("%q is not an IP address or CIDR block", value)
+		}
+	case dateCondition:
+		if _, err := parsePolicyDate(value); err != nil {
+			return fmt.Errorf("%q is not an RFC 3339 date or Unix time", value)
+		}
+	case boolCondition:
+		if !strings.EqualFold(value, "true") && !strings.EqualFold(value, "false") {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package iam
+
+import (
+	"strings"
+)
+
+// Decision is the outcome of evaluating policies for a request
+type Decision int
+
+const (
+	// DecisionNone means no statement applies, which denies the request
+	// unless another policy allows it
+	DecisionNone Decision = iota
+	// DecisionAllow means an Allow statement applies and no Deny does
+	DecisionAllow
+	// DecisionDeny means a Deny statement applies, whatever else allows
+	DecisionDeny
+)
+
+// Combine returns the decision of two policies evaluated for the same
+// request: an explicit deny wins over an allow, which wins over neither
+func (d Decision) Combine(other Decision) Decision {
+	if other > d {
+		return other
+	}
+	return d
+}
+
+// RequestContext is a request as policies see it
+type RequestContext struct {
+	// Principals name the requester, as an IAM user's ID, name and ARN or
+	// a configured access key. Anonymous requests have none, so only "*"
+	// principals match them.
+	Principals []string
+	Action     string // such as s3:GetObject
+	Resource   string // such as arn:aws:s3:::bucket/key
+	// Keys holds the request's condition key values, named by the Key
+	// constants. Keys it lacks are missing from the request.
+	Keys map[string]string
+	// ObjectTags loads the tags of the object the request addresses for
+	// s3:ExistingObjectTag conditions. It is called at most once, only
+	// when a condition needs it, and may be nil.
+	ObjectTags func() map[string]string
+
+	tags       map[string]string
+	tagsLoaded bool
+}
+
+// value returns the value of a condition key, named as by
+// canonicalConditionKey, and whether the request has it
+func (rc *RequestContext) value(key string) (string, bool) {
+	if tag, ok := strings.CutPrefix(key, KeyExistingObjectTagPrefix); ok {
+		if !rc.tagsLoaded {
+			rc.tagsLoaded = true
+			if rc.ObjectTags != nil {
+				rc.tags = rc.ObjectTags()
+			}
+		}
+		v, ok := rc.tags[tag]
+		return v, ok
+	}
+	v, ok := rc.Keys[key]
+	return v, ok
+}
+
+// UserARN returns the ARN naming an IAM user in policy principals
+func UserARN(username string) string {
+	return "arn:aws:iam:::user/" + username
+}
+
+// Evaluate decides a request against the policy's statements. A Deny
+// statement that applies wins over every Allow statement.
+func (p *IAMPolicy) Evaluate(rc *RequestContext) Decision {
+	return evaluateStatements(p.Statements, rc)
+}
+
+// evaluateStatements decides a request against a list of statements
+func evaluateStatements(statements []IAMStatement, rc *RequestContext) Decision {
+	decision := DecisionNone
+	for i := range statements {
+		stmt := &statements[i]
+		if !stmt.applies(rc) {
+			continue
+		}
+		switch stmt.Effect {
+		case "Deny":
+			return DecisionDeny
+		case "Allow":
+			decision = DecisionAllow
+		}
+	}
+	return decision
+}
+
+// applies reports whether a statement covers a request: its principal,
+// action, resource and conditions all match. A statement without a
+// principal, as in identity policies, applies to every requester.
+func (s *IAMStatement) applies(rc *RequestContext) bool {
+	switch {
+	case s.Principal != nil && !matchPrincipal(s.Principal, rc.Principals):
+		return false
+	case s.NotPrincipal != nil && matchPrincipal(s.NotPrincipal, rc.Principals):
+		return false
+	case len(s.NotActions) > 0 && matchAction(s.NotActions, rc.Action):
+		return false
+	case len(s.NotActions) == 0 && !matchAction(s.Actions, rc.Action):
+		return false
+	case len(s.NotResources) > 0 && matchResource(s.NotResources, rc.Resource):
+		return false
+	case len(s.NotResources) == 0 && !matchResource(s.Resources, rc.Resource):
+		return false
+	}
+	return s.Condition.matches(rc)
+}
+
+// matchPrincipal reports whether a policy principal names the requester.
+// Only AWS principals can; service principals never make S3 requests.
+func matchPrincipal(p *IAMPrincipal, principals []string) bool {
+	for _, pattern := range p.AWS {
+		if pattern == "*" {
+			return true
+		}
+		for _, id := range principals {
+			if matchWildcard(pattern, id) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchAction reports whether an action matches any pattern. Action names
+// are case-insensitive.
+func matchAction(patterns []string, action string) bool {
+	for _, pattern := range patterns {
+		if matchWildcard(strings.ToLower(pattern), strings.ToLower(action)) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchResource reports whether a resource ARN matches any pattern
+func matchResource(patterns []string, resource string) bool {
+	for _, pattern := range patterns {
+		if matchWildcard(pattern, resource) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchWildcard reports whether s matches pattern, in which * matches any
+// run of characters and ? any single character
+func matchWildcard(pattern, s string) bool {
+	p, i := 0, 0
+	star, mark := -1, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			p++
+			i++
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, i
+			p++
+		case star >= 0:
+			// Let the last * absorb one more character
+			p = star + 1
+			mark++
+			i = mark
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
@@ -0,0 +1,150 @@
+package iam
+
+import (
+	"testing"
+)
+
+func TestMatchWildcard(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"home/*", "home/alice/file", true},
+		{"home/*", "home", false},
+		{"home/*/docs", "home/alice/docs", true},
+		{"home/*/docs", "home/alice/pics", false},
+		{"file-?.txt", "file-1.txt", true},
+		{"file-?.txt", "file-10.txt", false},
+		{"*.log", "a.b.log", true},
+		{"exact", "exact", true},
+		{"exact", "exactly", false},
+	}
+	for _, tt := range tests {
+		if got := matchWildcard(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("matchWildcard(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestIAMPolicyEvaluate(t *testing.T) {
+	policy, err := ParseBucketPolicy([]byte(`{
+		"Version": "2012-10-17",
+		"Statement": [
+			{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*"},
+			{"Effect": "Deny", "Principal": {"AWS": "*"}, "Action": "s3:*", "Resource": "arn:aws:s3:::photos/private/*"},
+			{"Effect": "Allow", "Principal": {"AWS": ["arn:aws:iam:::user/alice"]}, "NotAction": ["s3:DeleteObject*"], "Resource": ["arn:aws:s3:::photos", "arn:aws:s3:::photos/*"]}
+		]
+	}`), "photos")
+	if err != nil {
+		t.Fatalf("ParseBucketPolicy() error: %v", err)
+	}
+
+	alice := []string{"alice-id", "alice", UserARN("alice")}
+	tests := []struct {
+		name       string
+		principals []string
+		action     string
+		resource   string
+		want       Decision
+	}{
+		{"anonymous read", nil, "s3:GetObject", ObjectARN("photos", "cat.jpg"), DecisionAllow},
+		{"anonymous write", nil, "s3:PutObject", ObjectARN("photos", "cat.jpg"), DecisionNone},
+		{"explicit deny wins", alice, "s3:GetObject", ObjectARN("photos", "private/me.jpg"), DecisionDeny},
+		{"action case", nil, "S3:GETOBJECT", ObjectARN("photos", "cat.jpg"), DecisionAllow},
+		{"principal allow", alice, "s3:ListBucket", BucketARN("photos"), DecisionAllow},
+		{"not action", alice, "s3:DeleteObjectVersion", ObjectARN("photos", "cat.jpg"), DecisionNone},
+		{"other principal", []string{"bob"}, "s3:ListBucket", BucketARN("photos"), DecisionNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := &RequestContext{Principals: tt.principals, Action: tt.action, Resource: tt.resource}
+			if got := policy.Evaluate(rc); got != tt.want {
+				t.Errorf("Evaluate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecisionCombine(t *testing.T) {
+	if got := DecisionAllow.Combine(DecisionDeny); got != DecisionDeny {
+		t.Errorf("Allow.Combine(Deny) = %v, want Deny", got)
+	}
+	if got := DecisionNone.Combine(DecisionAllow); got != DecisionAllow {
+		t.Errorf("None.Combine(Allow) = %v, want Allow", got)
+	}
+	if got := DecisionDeny.Combine(DecisionNone); got != DecisionDeny {
+		t.Errorf("Deny.Combine(None) = %v, want Deny", got)
+	}
+}
+
+func TestIAMConditionMatches(t *testing.T) {
+	keys := map[string]string{
+		KeySourceIP:        "192.168.1.20",
+		KeySecureTransport: "true",
+		KeyCurrentTime:     "2024-06-01T12:00:00Z",
+		KeyPrefix:          "home/alice/",
+		KeyUsername:        "alice",
+	}
+	tests := []struct {
+		name string
+		cond IAMCondition
+		want bool
+	}{
+		{"StringLike", IAMCondition{"StringLike": {"s3:prefix": {"home/${none}", "home/*"}}}, true},
+		{"StringLike mismatch", IAMCondition{"StringLike": {"s3:prefix": {"public/*"}}}, false},
+		{"StringEquals case-insensitive key", IAMCondition{"StringEquals": {"AWS:UserName": {"alice"}}}, true},
+		{"StringNotEquals", IAMCondition{"StringNotEquals": {"aws:username": {"bob"}}}, true},
+		{"StringEqualsIgnoreCase", IAMCondition{"StringEqualsIgnoreCase": {"aws:username": {"ALICE"}}}, true},
+		{"missing key", IAMCondition{"StringEquals": {"s3:x-amz-acl": {"private"}}}, false},
+		{"missing key negated", IAMCondition{"StringNotEquals": {"s3:x-amz-acl": {"public-read"}}}, true},
+		{"missing key IfExists", IAMCondition{"StringEqualsIfExists": {"s3:x-amz-acl": {"private"}}}, true},
+		{"IpAddress", IAMCondition{"IpAddress": {"aws:SourceIp": {"10.0.0.0/8", "192.168.1.0/24"}}}, true},
+		{"IpAddress single", IAMCondition{"IpAddress": {"aws:SourceIp": {"192.168.1.20"}}}, true},
+		{"NotIpAddress", IAMCondition{"NotIpAddress": {"aws:SourceIp": {"192.168.1.0/24"}}}, false},
+		{"DateGreaterThan", IAMCondition{"DateGreaterThan": {"aws:CurrentTime": {"2024-01-01T00:00:00Z"}}}, true},
+		{"DateLessThan", IAMCondition{"DateLessThan": {"aws:CurrentTime": {"2024-01-01T00:00:00Z"}}}, false},
+		{"DateLessThanEquals epoch", IAMCondition{"DateLessThanEquals": {"aws:CurrentTime": {"1717243200"}}}, true},
+		{"Bool", IAMCondition{"Bool": {"aws:SecureTransport": {"false"}}}, false},
+		{"Null absent", IAMCondition{"Null": {"s3:delimiter": {"true"}}}, true},
+		{"Null present", IAMCondition{"Null": {"s3:prefix": {"true"}}}, false},
+		{"every operator must hold", IAMCondition{
+			"Bool":       {"aws:SecureTransport": {"true"}},
+			"StringLike": {"s3:prefix": {"public/*"}},
+		}, false},
+		{"unknown operator", IAMCondition{"NumericEquals": {"s3:max-keys": {"10"}}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := &RequestContext{Keys: keys}
+			if got := tt.cond.matches(rc); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIAMConditionExistingObjectTag(t *testing.T) {
+	loads := 0
+	rc := &RequestContext{ObjectTags: func() map[string]string {
+		loads++
+		return map[string]string{"Classification": "public"}
+	}}
+
+	cond := IAMCondition{"StringEquals": {"s3:ExistingObjectTag/Classification": {"public"}}}
+	if !cond.matches(rc) {
+		t.Error("matches() = false for a matching tag")
+	}
+	cond = IAMCondition{"StringEquals": {"s3:existingobjecttag/classification": {"public"}}}
+	if cond.matches(rc) {
+		t.Error("matches() = true for a tag key of another case")
+	}
+	if loads != 1 {
+		t.Errorf("ObjectTags called %d times, want 1", loads)
+	}
+
+	if !(IAMCondition{}).matches(&RequestContext{}) {
+		t.Error("an empty condition should hold")
+	}
+}
@@ -1,6 +1,7 @@
 package iam
 
 import (
+	"strconv"
 	"testing"
 	"time"
 
@@ -174,6 +175,36 @@ func TestCreateAccessKey(t *testing.T) {
 	}
 }
 
+func TestGetUserByAccessKey(t *testing.T) {
+	logger := zap.NewNop()
+	mgr := NewManager(logger)
+
+	user, _ := mgr.CreateUser("tenant1", "testuser", "test@example.com")
+	key, _ := mgr.CreateAccessKey(user.ID)
+
+	got, ok := mgr.GetUserByAccessKey(key.ID)
+	if !ok || got.ID != user.ID {
+		t.Errorf("GetUserByAccessKey() = %v, %v, want %s", got, ok, user.ID)
+	}
+	if secret, ok := mgr.SecretKey(key.ID); !ok || secret != key.Secret {
+		t.Errorf("SecretKey() = %q, %v, want the key's secret", secret, ok)
+	}
+	if _, ok := mgr.GetUserByAccessKey("AKIAUNKNOWN"); ok {
+		t.Error("GetUserByAccessKey should fail for an unknown key")
+	}
+
+	user.Status = "inactive"
+	if _, ok := mgr.SecretKey(key.ID); ok {
+		t.Error("SecretKey should fail for an inactive user")
+	}
+	user.Status = "active"
+	expired := time.Now().Add(-time.Hour)
+	user.AccessKeys[0].ExpiresAt = &expired
+	if _, ok := mgr.GetUserByAccessKey(key.ID); ok {
+		t.Error("GetUserByAccessKey should fail for an expired key")
+	}
+}
+
 func TestCreateAccessKeyUserNotFound(t *testing.T) {
 	logger := zap.NewNop()
 	mgr := NewManager(logger)
@@ -764,6 +795,61 @@ func TestEvaluatePolicyWithInlinePolicy(t *testing.T) {
 	}
 }
 
+func TestEvaluateRequest(t *testing.T) {
+	logger := zap.NewNop()
+	mgr := NewManager(logger)
+
+	user, _ := mgr.CreateUser("tenant1", "testuser", "test@example.com")
+	group, _ := mgr.CreateGroup("tenant1", "developers")
+	mgr.AddUserToGroup(user.ID, group.ID)
+
+	allow, _ := mgr.CreatePolicy("tenant1", "HomeAccess", PolicyDoc{
+		Version: "2012-10-17",
+		Statement: []Statement{
+			{
+				Effect:    "Allow",
+				Actions:   []string{"s3:*"},
+				Resources: []string{"arn:aws:s3:::data", "arn:aws:s3:::data/*"},
+				Conditions: map[string]map[string]interface{}{
+					"Bool": {"aws:SecureTransport": true},
+				},
+			},
+		},
+	})
+	mgr.AttachPolicy(allow.ID, user.ID, "user")
+	deny, _ := mgr.CreatePolicy("tenant1", "NoDeletes", PolicyDoc{
+		Version: "2012-10-17",
+		Statement: []Statement{
+			{
+				Effect:    "Deny",
+				Actions:   []string{"s3:DeleteObject*"},
+				Resources: []string{"*"},
+			},
+		},
+	})
+	mgr.AttachPolicy(deny.ID, group.ID, "group")
+
+	request := func(action, key string, secure bool) *RequestContext {
+		return &RequestContext{
+			Action:   action,
+			Resource: ObjectARN("data", key),
+			Keys:     map[string]string{KeySecureTransport: strconv.FormatBool(secure)},
+		}
+	}
+	if got := mgr.EvaluateRequest(user.ID, request("s3:GetObject", "a", true)); got != DecisionAllow {
+		t.Errorf("EvaluateRequest() over TLS = %v, want Allow", got)
+	}
+	if got := mgr.EvaluateRequest(user.ID, request("s3:GetObject", "a", false)); got != DecisionNone {
+		t.Errorf("EvaluateRequest() without TLS = %v, want None", got)
+	}
+	if got := mgr.EvaluateRequest(user.ID, request("s3:DeleteObject", "a", true)); got != DecisionDeny {
+		t.Errorf("EvaluateRequest() of a group-denied action = %v, want Deny", got)
+	}
+	if got := mgr.EvaluateRequest("nonexistent", request("s3:GetObject", "a", true)); got != DecisionNone {
+		t.Errorf("EvaluateRequest() for an unknown user = %v, want None", got)
+	}
+}
+
 func TestEvaluatePolicyDenyEffect(t *testing.T) {
 	logger := zap.NewNop()
 	mgr := NewManager(logger)
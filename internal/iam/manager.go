@@ -216,6 +216,50 @@ func (m *Manager) CreateAccessKey(userID string) (*AccessKey, error) {
 	return &key, nil
 }
 
+// GetUserByAccessKey returns the active user owning an active, unexpired
+// access key
+func (m *Manager) GetUserByAccessKey(accessKeyID string) (*User, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, _ := m.findAccessKey(accessKeyID)
+	return user, user != nil
+}
+
+// SecretKey returns the secret of an active, unexpired access key of an
+// active user, letting IAM users sign S3 requests
+func (m *Manager) SecretKey(accessKeyID string) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, key := m.findAccessKey(accessKeyID)
+	if key == nil {
+		return "", false
+	}
+	return key.Secret, true
+}
+
+// findAccessKey returns a usable access key and its user, or nils. It is
+// called with mu held.
+func (m *Manager) findAccessKey(accessKeyID string) (*User, *AccessKey) {
+	for _, u := range m.users {
+		if u.Status != "active" {
+			continue
+		}
+		for i := range u.AccessKeys {
+			key := &u.AccessKeys[i]
+			if key.ID != accessKeyID {
+				continue
+			}
+			if key.Status != "active" || key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt) {
+				return nil, nil
+			}
+			return u, key
+		}
+	}
+	return nil, nil
+}
+
 // CreateGroup creates a new group
 func (m *Manager) CreateGroup(tenantID, name string) (*Group, error) {
 	m.mu.Lock()
@@ -442,6 +486,36 @@ func (m *Manager) EvaluatePolicy(tenantID, userID, action, resource string) (boo
 		return false, fmt.Errorf("user not found: %s", userID)
 	}
 
+	for _, doc := range m.userPolicies(user) {
+		if m.evaluateStatement(doc.Statement, action, resource) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// EvaluateRequest decides a request against a user's policies, with their
+// conditions and Deny statements. It returns DecisionNone for unknown users.
+func (m *Manager) EvaluateRequest(userID string, rc *RequestContext) Decision {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return DecisionNone
+	}
+
+	decision := DecisionNone
+	for _, doc := range m.userPolicies(user) {
+		decision = decision.Combine(evaluateStatements(identityStatements(doc.Statement), rc))
+	}
+	return decision
+}
+
+// userPolicies returns the documents of the policies attached to a user,
+// directly or through its groups, and of its inline policy. The caller
+// must hold m.mu.
+func (m *Manager) userPolicies(user *User) []PolicyDoc {
 	// Get all policies for the user
 	var policyArns []string
 	policyArns = append(policyArns, user.PolicyArns...)
@@ -453,27 +527,49 @@ func (m *Manager) EvaluatePolicy(tenantID, userID, action, resource string) (boo
 		}
 	}
 
-	// Evaluate each policy
+	var docs []PolicyDoc
 	for _, arn := range policyArns {
 		for _, policy := range m.policies {
 			if policy.Arn == arn {
-				allowed := m.evaluateStatement(policy.Document.Statement, action, resource)
-				if allowed {
-					return true, nil
-				}
+				docs = append(docs, policy.Document)
 			}
 		}
 	}
 
 	// Check inline policy
 	if user.InlinePolicy != nil {
-		allowed := m.evaluateStatement(user.InlinePolicy.Document.Statement, action, resource)
-		if allowed {
-			return true, nil
-		}
+		docs = append(docs, user.InlinePolicy.Document)
 	}
+	return docs
+}
 
-	return false, nil
+// identityStatements converts the statements of an identity policy for
+// evaluation. Principals do not apply to identity policies. Condition
+// values that are not text, numbers or booleans never match.
+func identityStatements(statements []Statement) []IAMStatement {
+	out := make([]IAMStatement, len(statements))
+	for i, stmt := range statements {
+		out[i] = IAMStatement{
+			Sid:          stmt.Sid,
+			Effect:       stmt.Effect,
+			Actions:      stmt.Actions,
+			NotActions:   stmt.NotActions,
+			Resources:    stmt.Resources,
+			NotResources: stmt.NotResources,
+		}
+		if len(stmt.Conditions) == 0 {
+			continue
+		}
+		out[i].Condition = make(IAMCondition, len(stmt.Conditions))
+		for op, keys := range stmt.Conditions {
+			out[i].Condition[op] = make(map[string]StringList, len(keys))
+			for key, raw := range keys {
+				values, _ := stringValues(raw)
+				out[i].Condition[op][key] = values
+			}
+		}
+	}
+	return out
 }
 
 // evaluateStatement evaluates a policy statement
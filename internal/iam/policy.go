@@ -3,6 +3,7 @@ package iam
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"time"
 )
 
@@ -23,29 +24,80 @@ type IAMStatement struct {
 	Effect       string        `json:"Effect"` // Allow or Deny
 	Principal    *IAMPrincipal `json:"Principal,omitempty"`
 	NotPrincipal *IAMPrincipal `json:"NotPrincipal,omitempty"`
-	Actions      []string      `json:"Action"`
-	NotActions   []string      `json:"NotAction,omitempty"`
-	Resources    []string      `json:"Resource"`
-	NotResources []string      `json:"NotResource,omitempty"`
-	Condition    *IAMCondition `json:"Condition,omitempty"`
+	Actions      StringList    `json:"Action"`
+	NotActions   StringList    `json:"NotAction,omitempty"`
+	Resources    StringList    `json:"Resource"`
+	NotResources StringList    `json:"NotResource,omitempty"`
+	Condition    IAMCondition  `json:"Condition,omitempty"`
+}
+
+// StringList is a list of policy values, written in JSON as either a
+// single string or an array. Booleans and numbers read as their text.
+type StringList []string
+
+// UnmarshalJSON reads a single value or an array of values
+func (l *StringList) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	values, err := stringValues(raw)
+	if err != nil {
+		return err
+	}
+	*l = values
+	return nil
+}
+
+// stringValues converts a decoded JSON value or array of values to text
+func stringValues(raw interface{}) ([]string, error) {
+	items, ok := raw.([]interface{})
+	if !ok {
+		items = []interface{}{raw}
+	}
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		switch v := item.(type) {
+		case string:
+			values = append(values, v)
+		case bool:
+			values = append(values, strconv.FormatBool(v))
+		case float64:
+			values = append(values, strconv.FormatFloat(v, 'f', -1, 64))
+		default:
+			return nil, fmt.Errorf("policy value %v is not a string", item)
+		}
+	}
+	return values, nil
 }
 
 // IAMPrincipal represents who the policy applies to
 type IAMPrincipal struct {
-	AWS     []string `json:"AWS,omitempty"`
-	Service []string `json:"Service,omitempty"`
+	AWS     StringList `json:"AWS,omitempty"`
+	Service StringList `json:"Service,omitempty"`
 }
 
-// IAMCondition represents a policy condition
-type IAMCondition struct {
-	StringEquals map[string]string `json:"StringEquals,omitempty"`
-	StringLike   map[string]string `json:"StringLike,omitempty"`
-	IpAddress    map[string]string `json:"IpAddress,omitempty"`
-	Numeric      map[string]int    `json:"Numeric,omitempty"`
-	Bool         map[string]bool   `json:"Bool,omitempty"`
-	Null         map[string]bool   `json:"Null,omitempty"`
+// UnmarshalJSON reads a principal object or "*", which stands for
+// everyone, anonymous requesters included
+func (p *IAMPrincipal) UnmarshalJSON(data []byte) error {
+	var wildcard string
+	if json.Unmarshal(data, &wildcard) == nil {
+		if wildcard != "*" {
+			return fmt.Errorf("principal %q must be \"*\" or an object", wildcard)
+		}
+		*p = IAMPrincipal{AWS: StringList{"*"}}
+		return nil
+	}
+
+	type principal IAMPrincipal
+	return json.Unmarshal(data, (*principal)(p))
 }
 
+// IAMCondition represents a policy condition block: condition operators
+// mapped to condition keys, each mapped to the values it is compared with.
+// Every operator and key must match; a key matches when any value does.
+type IAMCondition map[string]map[string]StringList
+
 // IAMUser represents an IAM user
 type IAMUser struct {
 	ID          string    `json:"Id"`
@@ -366,8 +366,8 @@ func TestIAMStatementStruct(t *testing.T) {
 		Actions:   []string{"s3:GetObject"},
 		Resources: []string{"*"},
 		Principal: &IAMPrincipal{AWS: []string{"*"}},
-		Condition: &IAMCondition{
-			StringEquals: map[string]string{"key": "value"},
+		Condition: IAMCondition{
+			"StringEquals": {"key": {"value"}},
 		},
 	}
 
@@ -389,15 +389,14 @@ func TestIAMPrincipalStruct(t *testing.T) {
 
 func TestIAMConditionStruct(t *testing.T) {
 	cond := IAMCondition{
-		StringEquals: map[string]string{"aws:userid": "user1"},
-		StringLike:   map[string]string{"s3:prefix": "home/*"},
-		IpAddress:    map[string]string{"aws:SourceIp": "192.168.1.0/24"},
-		Numeric:      map[string]int{"s3:max-keys": 1000},
-		Bool:         map[string]bool{"aws:SecureTransport": true},
-		Null:         map[string]bool{"s3:x-amz-acl": false},
+		"StringEquals": {"aws:userid": {"user1"}},
+		"StringLike":   {"s3:prefix": {"home/*"}},
+		"IpAddress":    {"aws:SourceIp": {"192.168.1.0/24"}},
+		"Bool":         {"aws:SecureTransport": {"true"}},
+		"Null":         {"s3:x-amz-acl": {"false"}},
 	}
 
-	if cond.StringEquals["aws:userid"] != "user1" {
+	if cond["StringEquals"]["aws:userid"][0] != "user1" {
 		t.Error("StringEquals not set correctly")
 	}
 }
@@ -18,6 +18,10 @@ type Processor struct {
 	interval time.Duration
 	stopCh   chan struct{}
 	wg       sync.WaitGroup
+
+	// staleUploadAge is the age after which incomplete multipart uploads
+	// are aborted, 0 keeps them forever
+	staleUploadAge time.Duration
 }
 
 // NewProcessor creates a new lifecycle processor
@@ -29,6 +33,12 @@ func NewProcessor(eng *engine.ObjectService, interval time.Duration) *Processor
 	}
 }
 
+// SetStaleUploadAge sets the age after which incomplete multipart uploads
+// are aborted. It must be called before Start.
+func (p *Processor) SetStaleUploadAge(age time.Duration) {
+	p.staleUploadAge = age
+}
+
 // Start starts the lifecycle processor
 func (p *Processor) Start() {
 	p.wg.Add(1)
@@ -69,6 +79,8 @@ func (p *Processor) processBuckets() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
 
+	p.abortStaleUploads(ctx)
+
 	buckets, err := p.engine.ListBuckets(ctx)
 	if err != nil {
 		logger.Error("failed to list buckets", zap.Error(err))
@@ -85,6 +97,24 @@ func (p *Processor) processBuckets() {
 	}
 }
 
+// abortStaleUploads aborts multipart uploads older than staleUploadAge
+func (p *Processor) abortStaleUploads(ctx context.Context) {
+	if p.staleUploadAge <= 0 {
+		return
+	}
+
+	aborted, err := p.engine.AbortStaleUploads(ctx, p.staleUploadAge)
+	if err != nil {
+		logger.Error("failed to abort stale multipart uploads", zap.Error(err))
+		return
+	}
+	if aborted > 0 {
+		logger.Info("aborted stale multipart uploads",
+			zap.Int("count", aborted),
+			zap.Duration("max_age", p.staleUploadAge))
+	}
+}
+
 // processBucket processes lifecycle rules for a bucket
 func (p *Processor) processBucket(ctx context.Context, bucket string) {
 	rules, err := p.engine.GetLifecycleRules(ctx, bucket)
@@ -144,7 +174,7 @@ func (p *Processor) processExpiration(ctx context.Context, bucket string, rule *
 
 	for _, obj := range result.Objects {
 		if obj.LastModified < cutoffTime {
-			err := p.engine.DeleteObject(ctx, bucket, obj.Key, engine.DeleteObjectOptions{})
+			_, err := p.engine.DeleteObject(ctx, bucket, obj.Key, engine.DeleteObjectOptions{})
 			if err != nil {
 				logger.Error("failed to delete expired object",
 					zap.String("key", obj.Key),
@@ -179,7 +209,7 @@ func (p *Processor) processTransitions(ctx context.Context, bucket string, rule
 
 	for _, obj := range result.Objects {
 		// Get object metadata using HeadObject
-		objMeta, err := p.engine.HeadObject(ctx, bucket, obj.Key)
+		objMeta, err := p.engine.HeadObject(ctx, bucket, obj.Key, engine.HeadObjectOptions{})
 		if err != nil {
 			continue
 		}
@@ -202,7 +232,7 @@ func (p *Processor) processTransitions(ctx context.Context, bucket string, rule
 				}
 
 				// Perform the transition by copying to itself with new storage class
-				_, err := p.engine.CopyObject(ctx, bucket, obj.Key, bucket, obj.Key)
+				_, err := p.engine.CopyObject(ctx, bucket, obj.Key, bucket, obj.Key, engine.CopyObjectOptions{})
 				if err != nil {
 					logger.Error("failed to transition object",
 						zap.String("bucket", bucket),
@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 	"sync"
 	"testing"
@@ -20,12 +21,14 @@ type MockStorageBackend struct {
 	mu      sync.RWMutex
 	objects map[string][]byte
 	buckets map[string]bool
+	parts   map[string][]byte
 }
 
 func NewMockStorageBackend() *MockStorageBackend {
 	return &MockStorageBackend{
 		objects: make(map[string][]byte),
 		buckets: make(map[string]bool),
+		parts:   make(map[string][]byte),
 	}
 }
 
@@ -117,6 +120,38 @@ func (m *MockStorageBackend) ListBuckets(ctx context.Context) ([]storage.BucketI
 	return buckets, nil
 }
 
+func (m *MockStorageBackend) PutPart(ctx context.Context, uploadID string, partNumber int, data io.Reader, size int64) error {
+	b, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.parts[fmt.Sprintf("%s/%d", uploadID, partNumber)] = b
+	return nil
+}
+
+func (m *MockStorageBackend) GetPart(ctx context.Context, uploadID string, partNumber int) (io.ReadCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	data, ok := m.parts[fmt.Sprintf("%s/%d", uploadID, partNumber)]
+	if !ok {
+		return nil, io.EOF
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *MockStorageBackend) DeleteUpload(ctx context.Context, uploadID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k := range m.parts {
+		if strings.HasPrefix(k, uploadID+"/") {
+			delete(m.parts, k)
+		}
+	}
+	return nil
+}
+
 func (m *MockStorageBackend) ComputeStorageMetrics() (int64, int64, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -222,17 +257,31 @@ func (m *MockMetadataStore) DeleteObject(ctx context.Context, bucket, key string
 	return nil
 }
 
-func (m *MockMetadataStore) ListObjects(ctx context.Context, bucket, prefix string, opts metadata.ListOptions) ([]metadata.ObjectMetadata, error) {
+func (m *MockMetadataStore) ListObjects(ctx context.Context, bucket string, opts metadata.ListOptions) (*metadata.ObjectListing, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	var objects []metadata.ObjectMetadata
-	prefixKey := bucket + "/" + prefix
-	for k, v := range m.objects {
-		if len(k) >= len(prefixKey) && k[:len(prefixKey)] == prefixKey {
-			objects = append(objects, *v)
+	var keys []string
+	for k := range m.objects {
+		if strings.HasPrefix(k, bucket+"/") {
+			keys = append(keys, k[len(bucket)+1:])
+		}
+	}
+	sort.Strings(keys)
+	pager := metadata.NewObjectPager(opts)
+	for _, key := range keys {
+		if !pager.Add(key, m.objects[bucket+"/"+key]) {
+			break
 		}
 	}
-	return objects, nil
+	return pager.Listing(), nil
+}
+
+func (m *MockMetadataStore) ListObjectVersions(ctx context.Context, bucket string, opts metadata.ListOptions) (*metadata.VersionListing, error) {
+	return &metadata.VersionListing{}, nil
+}
+
+func (m *MockMetadataStore) UpdateObjectVersion(ctx context.Context, bucket, key string, meta *metadata.ObjectMetadata) error {
+	return nil
 }
 
 func (m *MockMetadataStore) Close() error {
@@ -838,6 +887,29 @@ func TestProcessor_ProcessMultipleBuckets(t *testing.T) {
 	processor.Stop()
 }
 
+func TestProcessor_AbortStaleUploads(t *testing.T) {
+	eng := createTestEngine(t)
+	ctx := context.Background()
+
+	eng.CreateBucket(ctx, "bucket")
+	// The mock store leaves Initiated at zero, so the upload is stale
+	if _, err := eng.CreateMultipartUpload(ctx, "bucket", "key", engine.PutObjectOptions{}); err != nil {
+		t.Fatalf("CreateMultipartUpload() error = %v", err)
+	}
+
+	processor := NewProcessor(eng, time.Minute)
+	processor.processBuckets()
+	if result, _ := eng.ListMultipartUpload(ctx, "bucket", ""); len(result.Uploads) != 1 {
+		t.Fatalf("uploads = %d, want 1 while stale upload cleanup is disabled", len(result.Uploads))
+	}
+
+	processor.SetStaleUploadAge(time.Hour)
+	processor.processBuckets()
+	if result, _ := eng.ListMultipartUpload(ctx, "bucket", ""); len(result.Uploads) != 0 {
+		t.Errorf("uploads = %d, want 0 after stale upload cleanup", len(result.Uploads))
+	}
+}
+
 func TestProcessor_ProcessExpirationWithObjects(t *testing.T) {
 	eng := createTestEngine(t)
 	ctx := context.Background()
@@ -1173,7 +1245,7 @@ func (m *ErrorMockStorageBackend) Head(ctx context.Context, bucket, key string)
 	return m.MockStorageBackend.Head(ctx, bucket, key)
 }
 
-func (m *ErrorMockStorageBackend) CopyObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) (*engine.CopyObjectResult, error) {
+func (m *ErrorMockStorageBackend) CopyObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string, opts engine.CopyObjectOptions) (*engine.CopyObjectResult, error) {
 	if m.copyObjectErr != nil {
 		return nil, m.copyObjectErr
 	}
@@ -1694,11 +1766,11 @@ type ErrorObjectService struct {
 	copyObjectErr error
 }
 
-func (e *ErrorObjectService) CopyObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) (*engine.CopyObjectResult, error) {
+func (e *ErrorObjectService) CopyObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string, opts engine.CopyObjectOptions) (*engine.CopyObjectResult, error) {
 	if e.copyObjectErr != nil {
 		return nil, e.copyObjectErr
 	}
-	return e.ObjectService.CopyObject(ctx, srcBucket, srcKey, dstBucket, dstKey)
+	return e.ObjectService.CopyObject(ctx, srcBucket, srcKey, dstBucket, dstKey, opts)
 }
 
 func TestProcessor_RemoveRuleGetRulesError(t *testing.T) {
@@ -1,6 +1,7 @@
 package bbolt
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -35,6 +36,10 @@ func New(rootDir string) (*BBoltStore, error) {
 		if _, err := tx.CreateBucketIfNotExists([]byte("objects")); err != nil {
 			return err
 		}
+		// Object versions bucket
+		if _, err := tx.CreateBucketIfNotExists([]byte("versions")); err != nil {
+			return err
+		}
 		// Multipart uploads bucket
 		if _, err := tx.CreateBucketIfNotExists([]byte("multipart")); err != nil {
 			return err
@@ -175,23 +180,49 @@ func (b *BBoltStore) ListBuckets(ctx context.Context) ([]string, error) {
 	return buckets, err
 }
 
-// PutObject stores object metadata
+// PutObject stores object metadata as the latest version of the key
 func (b *BBoltStore) PutObject(ctx context.Context, bucket, key string, meta *metadata.ObjectMetadata) error {
 	return b.db.Update(func(tx *bolt.Tx) error {
-		objects := tx.Bucket([]byte("objects"))
-		objKey := bucket + "/" + key
-		data, err := encode(meta)
+		versions, err := getVersions(tx, bucket, key)
 		if err != nil {
 			return err
 		}
-		return objects.Put([]byte(objKey), data)
+		return putVersions(tx, bucket, key, metadata.AddVersion(versions, *meta))
 	})
 }
 
-// GetObject gets object metadata
+// UpdateObjectVersion replaces the metadata of an existing version in place
+func (b *BBoltStore) UpdateObjectVersion(ctx context.Context, bucket, key string, meta *metadata.ObjectMetadata) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		versions, err := getVersions(tx, bucket, key)
+		if err != nil {
+			return err
+		}
+		versions, found := metadata.ReplaceVersion(versions, *meta)
+		if !found {
+			return fmt.Errorf("version not found: %s", meta.VersionID)
+		}
+		return putVersions(tx, bucket, key, versions)
+	})
+}
+
+// GetObject gets object metadata; an empty versionID selects the latest version
 func (b *BBoltStore) GetObject(ctx context.Context, bucket, key string, versionID string) (*metadata.ObjectMetadata, error) {
 	var meta metadata.ObjectMetadata
 	err := b.db.View(func(tx *bolt.Tx) error {
+		if versionID != "" {
+			versions, err := getVersions(tx, bucket, key)
+			if err != nil {
+				return err
+			}
+			v, ok := metadata.FindVersion(versions, versionID)
+			if !ok {
+				return fmt.Errorf("version not found: %s", versionID)
+			}
+			meta = *v
+			return nil
+		}
+
 		objects := tx.Bucket([]byte("objects"))
 		objKey := bucket + "/" + key
 		data := objects.Get([]byte(objKey))
@@ -203,43 +234,136 @@ func (b *BBoltStore) GetObject(ctx context.Context, bucket, key string, versionI
 	return &meta, err
 }
 
-// DeleteObject deletes object metadata
+// DeleteObject deletes one version of an object, or every version when
+// versionID is empty. Removing the latest version promotes the next newest.
 func (b *BBoltStore) DeleteObject(ctx context.Context, bucket, key string, versionID string) error {
 	return b.db.Update(func(tx *bolt.Tx) error {
-		objects := tx.Bucket([]byte("objects"))
-		objKey := bucket + "/" + key
-		return objects.Delete([]byte(objKey))
+		if versionID == "" {
+			return putVersions(tx, bucket, key, nil)
+		}
+
+		versions, err := getVersions(tx, bucket, key)
+		if err != nil {
+			return err
+		}
+		versions, found := metadata.RemoveVersion(versions, versionID)
+		if !found {
+			return nil
+		}
+		return putVersions(tx, bucket, key, versions)
 	})
 }
 
-// ListObjects lists objects with optional prefix
-func (b *BBoltStore) ListObjects(ctx context.Context, bucket, prefix string, opts metadata.ListOptions) ([]metadata.ObjectMetadata, error) {
-	var objects []metadata.ObjectMetadata
-	err := b.db.View(func(tx *bolt.Tx) error {
-		objectsBkt := tx.Bucket([]byte("objects"))
-		prefixKey := bucket + "/" + prefix
+// getVersions returns every version of an object, newest first. Objects
+// written before version tracking have only their latest record.
+func getVersions(tx *bolt.Tx, bucket, key string) ([]metadata.ObjectMetadata, error) {
+	objKey := []byte(bucket + "/" + key)
 
-		maxKeys := opts.MaxKeys
-		if maxKeys == 0 {
-			maxKeys = 1000
+	if data := tx.Bucket([]byte("versions")).Get(objKey); data != nil {
+		var versions []metadata.ObjectMetadata
+		if err := mustDecode(data, &versions); err != nil {
+			return nil, err
 		}
+		return versions, nil
+	}
 
-		cursor := objectsBkt.Cursor()
-		for k, v := cursor.Seek([]byte(prefixKey)); k != nil && len(objects) < maxKeys; k, v = cursor.Next() {
-			key := string(k)
-			if len(key) < len(bucket)+1 || key[:len(bucket)+1] != bucket+"/" {
+	data := tx.Bucket([]byte("objects")).Get(objKey)
+	if data == nil {
+		return nil, nil
+	}
+	var meta metadata.ObjectMetadata
+	if err := mustDecode(data, &meta); err != nil {
+		return nil, err
+	}
+	return []metadata.ObjectMetadata{meta}, nil
+}
+
+// putVersions stores the version list of an object and its latest version;
+// an empty list removes the object
+func putVersions(tx *bolt.Tx, bucket, key string, versions []metadata.ObjectMetadata) error {
+	objects := tx.Bucket([]byte("objects"))
+	versionsBkt := tx.Bucket([]byte("versions"))
+	objKey := []byte(bucket + "/" + key)
+
+	if len(versions) == 0 {
+		if err := objects.Delete(objKey); err != nil {
+			return err
+		}
+		return versionsBkt.Delete(objKey)
+	}
+
+	latest, err := encode(&versions[0])
+	if err != nil {
+		return err
+	}
+	all, err := encode(versions)
+	if err != nil {
+		return err
+	}
+	if err := objects.Put(objKey, latest); err != nil {
+		return err
+	}
+	return versionsBkt.Put(objKey, all)
+}
+
+// ListObjects lists the latest version of objects in key order, seeking
+// past the keys rolled up into each common prefix
+func (b *BBoltStore) ListObjects(ctx context.Context, bucket string, opts metadata.ListOptions) (*metadata.ObjectListing, error) {
+	pager := metadata.NewObjectPager(opts)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucketPrefix := bucket + "/"
+		cursor := tx.Bucket([]byte("objects")).Cursor()
+
+		for k, v := cursor.Seek([]byte(bucketPrefix + pager.StartKey())); k != nil; {
+			if !bytes.HasPrefix(k, []byte(bucketPrefix)) {
 				break
 			}
-
+			key := string(k[len(bucketPrefix):])
 			var meta metadata.ObjectMetadata
-			if err := mustDecode(v, &meta); err != nil {
-				continue
+			if err := mustDecode(v, &meta); err == nil && !pager.Add(key, &meta) {
+				break
+			}
+			if seek := pager.Seek(); seek != "" {
+				k, v = cursor.Seek([]byte(bucketPrefix + seek))
+			} else {
+				k, v = cursor.Next()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pager.Listing(), nil
+}
+
+// ListObjectVersions lists every version and delete marker in key order,
+// newest version first within a key
+func (b *BBoltStore) ListObjectVersions(ctx context.Context, bucket string, opts metadata.ListOptions) (*metadata.VersionListing, error) {
+	pager := metadata.NewVersionPager(opts)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucketPrefix := bucket + "/"
+		cursor := tx.Bucket([]byte("objects")).Cursor()
+
+		for k, _ := cursor.Seek([]byte(bucketPrefix + pager.StartKey())); k != nil; k, _ = cursor.Next() {
+			if !bytes.HasPrefix(k, []byte(bucketPrefix)) {
+				break
+			}
+			key := string(k[len(bucketPrefix):])
+			versions, err := getVersions(tx, bucket, key)
+			if err != nil {
+				return err
+			}
+			if !pager.Add(key, versions) {
+				break
 			}
-			objects = append(objects, meta)
 		}
 		return nil
 	})
-	return objects, err
+	if err != nil {
+		return nil, err
+	}
+	return pager.Listing(), nil
 }
 
 // CreateMultipartUpload creates a new multipart upload
@@ -252,6 +376,16 @@ func (b *BBoltStore) CreateMultipartUpload(ctx context.Context, bucket, key, upl
 			Bucket:    bucket,
 			Initiated: nowUnix(),
 			Metadata:  meta.Metadata,
+
+			ContentType:        meta.ContentType,
+			ContentEncoding:    meta.ContentEncoding,
+			CacheControl:       meta.CacheControl,
+			ContentDisposition: meta.ContentDisposition,
+			ContentLanguage:    meta.ContentLanguage,
+			Expires:            meta.Expires,
+
+			ChecksumAlgorithm: meta.ChecksumAlgorithm,
+			Encryption:        meta.Encryption,
 		}
 		multiKey := bucket + "/" + key + "/" + uploadID
 		return multipart.Put([]byte(multiKey), mustEncode(multiMeta))
@@ -271,19 +405,13 @@ func (b *BBoltStore) PutPart(ctx context.Context, bucket, key, uploadID string,
 func (b *BBoltStore) CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, parts []metadata.PartInfo) error {
 	return b.db.Update(func(tx *bolt.Tx) error {
 		multipart := tx.Bucket([]byte("multipart"))
-		partsBkt := tx.Bucket([]byte("parts"))
 
 		multiKey := bucket + "/" + key + "/" + uploadID
 		if err := multipart.Delete([]byte(multiKey)); err != nil {
 			return err
 		}
 
-		// Delete parts
-		for i := 1; i <= len(parts); i++ {
-			partKey := fmt.Sprintf("%s/%s/%s/%d", bucket, key, uploadID, i)
-			partsBkt.Delete([]byte(partKey))
-		}
-		return nil
+		return deleteParts(tx, bucket, key, uploadID)
 	})
 }
 
@@ -292,10 +420,26 @@ func (b *BBoltStore) AbortMultipartUpload(ctx context.Context, bucket, key, uplo
 	return b.db.Update(func(tx *bolt.Tx) error {
 		multipart := tx.Bucket([]byte("multipart"))
 		multiKey := bucket + "/" + key + "/" + uploadID
-		return multipart.Delete([]byte(multiKey))
+		if err := multipart.Delete([]byte(multiKey)); err != nil {
+			return err
+		}
+		return deleteParts(tx, bucket, key, uploadID)
 	})
 }
 
+// deleteParts removes every part record of a multipart upload, whatever
+// its part numbers
+func deleteParts(tx *bolt.Tx, bucket, key, uploadID string) error {
+	prefix := []byte(fmt.Sprintf("%s/%s/%s/", bucket, key, uploadID))
+	cursor := tx.Bucket([]byte("parts")).Cursor()
+	for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Seek(prefix) {
+		if err := cursor.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ListParts lists parts of a multipart upload
 func (b *BBoltStore) ListParts(ctx context.Context, bucket, key, uploadID string) ([]metadata.PartMetadata, error) {
 	var parts []metadata.PartMetadata
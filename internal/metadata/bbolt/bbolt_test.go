@@ -3,6 +3,7 @@ package bbolt
 import (
 	"context"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/openendpoint/openendpoint/internal/metadata"
@@ -260,15 +261,174 @@ func TestListObjects(t *testing.T) {
 		})
 	}
 
-	objects, err := store.ListObjects(ctx, "test-bucket", "", metadata.ListOptions{})
+	listing, err := store.ListObjects(ctx, "test-bucket", metadata.ListOptions{MaxKeys: 1000})
 	if err != nil {
 		t.Fatalf("ListObjects() error: %v", err)
 	}
+	objects := listing.Objects
 	if len(objects) != 3 {
 		t.Errorf("ListObjects() returned %d objects, expected 3", len(objects))
 	}
 }
 
+func TestObjectVersions(t *testing.T) {
+	dir, err := os.MkdirTemp("", "bbolt-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	_ = store.CreateBucket(ctx, "test-bucket")
+
+	for _, vid := range []string{"v1", "v2"} {
+		err := store.PutObject(ctx, "test-bucket", "key", &metadata.ObjectMetadata{
+			Key:       "key",
+			Bucket:    "test-bucket",
+			VersionID: vid,
+			ETag:      "etag-" + vid,
+		})
+		if err != nil {
+			t.Fatalf("PutObject(%s) error: %v", vid, err)
+		}
+	}
+
+	latest, err := store.GetObject(ctx, "test-bucket", "key", "")
+	if err != nil {
+		t.Fatalf("GetObject() error: %v", err)
+	}
+	if latest.VersionID != "v2" || !latest.IsLatest {
+		t.Errorf("GetObject() latest = %s (IsLatest %v), expected v2", latest.VersionID, latest.IsLatest)
+	}
+
+	v1, err := store.GetObject(ctx, "test-bucket", "key", "v1")
+	if err != nil {
+		t.Fatalf("GetObject(v1) error: %v", err)
+	}
+	if v1.ETag != "etag-v1" || v1.IsLatest {
+		t.Errorf("GetObject(v1) = %s (IsLatest %v), expected noncurrent etag-v1", v1.ETag, v1.IsLatest)
+	}
+
+	if _, err := store.GetObject(ctx, "test-bucket", "key", "missing"); err == nil {
+		t.Error("GetObject() expected error for unknown version")
+	}
+
+	// Deleting the latest version promotes the previous one
+	if err := store.DeleteObject(ctx, "test-bucket", "key", "v2"); err != nil {
+		t.Fatalf("DeleteObject(v2) error: %v", err)
+	}
+	latest, err = store.GetObject(ctx, "test-bucket", "key", "")
+	if err != nil {
+		t.Fatalf("GetObject() error: %v", err)
+	}
+	if latest.VersionID != "v1" || !latest.IsLatest {
+		t.Errorf("GetObject() latest = %s after delete, expected v1", latest.VersionID)
+	}
+
+	// Deleting an unknown version is a no-op
+	if err := store.DeleteObject(ctx, "test-bucket", "key", "missing"); err != nil {
+		t.Errorf("DeleteObject(missing) error: %v", err)
+	}
+
+	if err := store.DeleteObject(ctx, "test-bucket", "key", "v1"); err != nil {
+		t.Fatalf("DeleteObject(v1) error: %v", err)
+	}
+	if _, err := store.GetObject(ctx, "test-bucket", "key", ""); err == nil {
+		t.Error("GetObject() expected error after deleting every version")
+	}
+}
+
+func TestListObjectsSkipsDeleteMarkers(t *testing.T) {
+	dir, err := os.MkdirTemp("", "bbolt-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	_ = store.CreateBucket(ctx, "test-bucket")
+
+	_ = store.PutObject(ctx, "test-bucket", "a", &metadata.ObjectMetadata{Key: "a", Bucket: "test-bucket", VersionID: "v1"})
+	_ = store.PutObject(ctx, "test-bucket", "b", &metadata.ObjectMetadata{Key: "b", Bucket: "test-bucket", VersionID: "v1"})
+	_ = store.PutObject(ctx, "test-bucket", "b", &metadata.ObjectMetadata{Key: "b", Bucket: "test-bucket", VersionID: "v2", IsDeleteMarker: true})
+
+	listing, err := store.ListObjects(ctx, "test-bucket", metadata.ListOptions{MaxKeys: 1000})
+	if err != nil {
+		t.Fatalf("ListObjects() error: %v", err)
+	}
+	objects := listing.Objects
+	if len(objects) != 1 || objects[0].Key != "a" {
+		t.Errorf("ListObjects() = %v, expected only a", objects)
+	}
+}
+
+func TestListObjectVersions(t *testing.T) {
+	dir, err := os.MkdirTemp("", "bbolt-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	_ = store.CreateBucket(ctx, "test-bucket")
+	_ = store.CreateBucket(ctx, "test-bucket-2")
+
+	puts := []struct{ key, vid string }{{"a", "a1"}, {"b", "b1"}, {"a", "a2"}, {"c/d", "d1"}, {"b", "b2"}}
+	for _, p := range puts {
+		_ = store.PutObject(ctx, "test-bucket", p.key, &metadata.ObjectMetadata{Key: p.key, Bucket: "test-bucket", VersionID: p.vid})
+	}
+	_ = store.PutObject(ctx, "test-bucket-2", "a", &metadata.ObjectMetadata{Key: "a", Bucket: "test-bucket-2", VersionID: "other"})
+
+	// Walk every page of two entries
+	var got []string
+	opts := metadata.ListOptions{MaxKeys: 2}
+	for page := 0; page < 10; page++ {
+		listing, err := store.ListObjectVersions(ctx, "test-bucket", opts)
+		if err != nil {
+			t.Fatalf("ListObjectVersions() error: %v", err)
+		}
+		for _, v := range listing.Versions {
+			got = append(got, v.Key+"@"+v.VersionID)
+		}
+		if !listing.IsTruncated {
+			break
+		}
+		opts.Marker = listing.NextKeyMarker
+		opts.VersionIDMarker = listing.NextVersionIDMarker
+	}
+
+	want := []string{"a@a2", "a@a1", "b@b2", "b@b1", "c/d@d1"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("ListObjectVersions() = %v, want %v", got, want)
+	}
+
+	listing, err := store.ListObjectVersions(ctx, "test-bucket", metadata.ListOptions{Delimiter: "/", MaxKeys: 1000})
+	if err != nil {
+		t.Fatalf("ListObjectVersions() error: %v", err)
+	}
+	if len(listing.CommonPrefixes) != 1 || listing.CommonPrefixes[0] != "c/" || len(listing.Versions) != 4 {
+		t.Errorf("ListObjectVersions(delimiter) = %d versions, prefixes %v", len(listing.Versions), listing.CommonPrefixes)
+	}
+}
+
 func TestMultipartUpload(t *testing.T) {
 	dir, err := os.MkdirTemp("", "bbolt-test-*")
 	if err != nil {
@@ -385,6 +545,8 @@ func TestAbortMultipartUpload(t *testing.T) {
 	_ = store.CreateBucket(ctx, "test-bucket")
 
 	_ = store.CreateMultipartUpload(ctx, "test-bucket", "test-key", "upload-123", &metadata.ObjectMetadata{})
+	_ = store.PutPart(ctx, "test-bucket", "test-key", "upload-123", 2, &metadata.PartMetadata{PartNumber: 2})
+	_ = store.PutPart(ctx, "test-bucket", "test-key", "upload-123", 7, &metadata.PartMetadata{PartNumber: 7})
 
 	err = store.AbortMultipartUpload(ctx, "test-bucket", "test-key", "upload-123")
 	if err != nil {
@@ -395,6 +557,10 @@ func TestAbortMultipartUpload(t *testing.T) {
 	if len(uploads) != 0 {
 		t.Errorf("Expected 0 uploads after abort, got %d", len(uploads))
 	}
+	parts, _ := store.ListParts(ctx, "test-bucket", "test-key", "upload-123")
+	if len(parts) != 0 {
+		t.Errorf("Expected 0 parts after abort, got %d", len(parts))
+	}
 }
 
 func TestLifecycleRules(t *testing.T) {
@@ -1516,10 +1682,11 @@ func TestListObjectsWithMaxKeys(t *testing.T) {
 		})
 	}
 
-	objects, err := store.ListObjects(ctx, "test-bucket", "", metadata.ListOptions{MaxKeys: 2})
+	listing, err := store.ListObjects(ctx, "test-bucket", metadata.ListOptions{MaxKeys: 2})
 	if err != nil {
 		t.Fatalf("ListObjects() error: %v", err)
 	}
+	objects := listing.Objects
 	if len(objects) != 2 {
 		t.Errorf("ListObjects() returned %d objects, expected 2", len(objects))
 	}
@@ -1655,10 +1822,11 @@ func TestListObjectsEmpty(t *testing.T) {
 
 	ctx := context.Background()
 	_ = store.CreateBucket(ctx, "test-bucket")
-	objects, err := store.ListObjects(ctx, "test-bucket", "", metadata.ListOptions{})
+	listing, err := store.ListObjects(ctx, "test-bucket", metadata.ListOptions{MaxKeys: 1000})
 	if err != nil {
 		t.Fatalf("ListObjects() error: %v", err)
 	}
+	objects := listing.Objects
 	if len(objects) != 0 {
 		t.Errorf("Expected 0 objects, got %d", len(objects))
 	}
@@ -1708,10 +1876,11 @@ func TestListObjectsWithInvalidData(t *testing.T) {
 		return bkt.Put([]byte("test-bucket/bad"), []byte("invalid-json"))
 	})
 
-	objects, err := store.ListObjects(ctx, "test-bucket", "", metadata.ListOptions{})
+	listing, err := store.ListObjects(ctx, "test-bucket", metadata.ListOptions{MaxKeys: 1000})
 	if err != nil {
 		t.Fatalf("ListObjects() error: %v", err)
 	}
+	objects := listing.Objects
 	if len(objects) != 0 {
 		t.Errorf("Expected 0 objects (invalid data skipped), got %d", len(objects))
 	}
@@ -1953,10 +2122,11 @@ func TestListObjectsWithDifferentBuckets(t *testing.T) {
 	_ = store.PutObject(ctx, "bucket-a", "key1", &metadata.ObjectMetadata{Key: "key1", Bucket: "bucket-a"})
 	_ = store.PutObject(ctx, "bucket-b", "key2", &metadata.ObjectMetadata{Key: "key2", Bucket: "bucket-b"})
 
-	objects, err := store.ListObjects(ctx, "bucket-a", "", metadata.ListOptions{})
+	listing, err := store.ListObjects(ctx, "bucket-a", metadata.ListOptions{MaxKeys: 1000})
 	if err != nil {
 		t.Fatalf("ListObjects() error: %v", err)
 	}
+	objects := listing.Objects
 	if len(objects) != 1 {
 		t.Errorf("Expected 1 object, got %d", len(objects))
 	}
@@ -2394,7 +2564,7 @@ func TestListObjectsWithClosedDB(t *testing.T) {
 	store.Close()
 
 	ctx := context.Background()
-	_, err = store.ListObjects(ctx, "test-bucket", "", metadata.ListOptions{})
+	_, err = store.ListObjects(ctx, "test-bucket", metadata.ListOptions{MaxKeys: 1000})
 	if err == nil {
 		t.Error("Expected error when DB is closed")
 	}
@@ -2663,3 +2833,42 @@ func TestCloseTwice(t *testing.T) {
 		t.Log("Second close returned nil (acceptable)")
 	}
 }
+
+func TestListObjectsPages(t *testing.T) {
+	store, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	_ = store.CreateBucket(ctx, "test-bucket")
+	_ = store.CreateBucket(ctx, "test-bucket2")
+	for _, key := range []string{"a", "dir/x", "dir/y", "dir2/z", "e", "f"} {
+		_ = store.PutObject(ctx, "test-bucket", key, &metadata.ObjectMetadata{Key: key, Bucket: "test-bucket"})
+	}
+	_ = store.PutObject(ctx, "test-bucket2", "b", &metadata.ObjectMetadata{Key: "b", Bucket: "test-bucket2"})
+
+	var got []string
+	opts := metadata.ListOptions{Delimiter: "/", MaxKeys: 2}
+	for page := 0; page < 10; page++ {
+		listing, err := store.ListObjects(ctx, "test-bucket", opts)
+		if err != nil {
+			t.Fatalf("ListObjects() error: %v", err)
+		}
+		for _, obj := range listing.Objects {
+			got = append(got, obj.Key)
+		}
+		got = append(got, listing.CommonPrefixes...)
+		if !listing.IsTruncated {
+			break
+		}
+		opts.Marker = listing.NextMarker
+	}
+
+	// Each page lists its objects before its common prefixes
+	want := "a dir/ e dir2/ f"
+	if strings.Join(got, " ") != want {
+		t.Errorf("pages = %v, want %s", got, want)
+	}
+}
@@ -0,0 +1,135 @@
+package metadata
+
+import "strings"
+
+// ObjectListing is one page of the latest versions of objects in key
+// order. Keys whose latest version is a delete marker are left out.
+type ObjectListing struct {
+	Objects        []ObjectMetadata
+	CommonPrefixes []string
+	IsTruncated    bool
+	NextMarker     string // last key or common prefix on a truncated page
+}
+
+// ObjectPager builds an ObjectListing from the latest versions of keys
+// visited in ascending key order. Like VersionPager it applies the prefix,
+// delimiter, max-keys and marker of opts. Once a common prefix is on the
+// page, Seek tells the store where its keys end, so a page costs the same
+// however many keys a prefix holds.
+type ObjectPager struct {
+	opts    ListOptions
+	maxKeys int
+	count   int
+	seek    string
+	listing ObjectListing
+}
+
+// NewObjectPager creates a pager for opts. A MaxKeys of 0 yields an empty
+// page.
+func NewObjectPager(opts ListOptions) *ObjectPager {
+	return &ObjectPager{opts: opts, maxKeys: opts.MaxKeys}
+}
+
+// StartKey returns the first key the store needs to visit
+func (p *ObjectPager) StartKey() string {
+	if p.opts.Marker > p.opts.Prefix {
+		return p.opts.Marker
+	}
+	return p.opts.Prefix
+}
+
+// Add offers the latest version of key to the page. It returns false once
+// the page is complete and no further keys are needed.
+func (p *ObjectPager) Add(key string, meta *ObjectMetadata) bool {
+	if p.listing.IsTruncated || p.maxKeys <= 0 {
+		return false
+	}
+	if !strings.HasPrefix(key, p.opts.Prefix) {
+		// Keys are visited in order, so none after this one match either
+		return key < p.opts.Prefix
+	}
+	if key <= p.opts.Marker {
+		return true
+	}
+
+	if p.opts.Delimiter != "" {
+		rest := key[len(p.opts.Prefix):]
+		if i := strings.Index(rest, p.opts.Delimiter); i >= 0 {
+			cp := p.opts.Prefix + rest[:i+len(p.opts.Delimiter)]
+			n := len(p.listing.CommonPrefixes)
+			if cp == p.opts.Marker || (n > 0 && p.listing.CommonPrefixes[n-1] == cp) {
+				return p.skip(cp)
+			}
+			// A prefix holding only deleted keys is not listed
+			if meta.IsDeleteMarker {
+				return true
+			}
+			if !p.reserve(cp) {
+				return false
+			}
+			p.listing.CommonPrefixes = append(p.listing.CommonPrefixes, cp)
+			return p.skip(cp)
+		}
+	}
+
+	if meta.IsDeleteMarker {
+		return true
+	}
+	if !p.reserve(key) {
+		return false
+	}
+	m := *meta
+	m.Key = key
+	p.listing.Objects = append(p.listing.Objects, m)
+	return true
+}
+
+// Seek returns the key the store should seek to before offering the next
+// key, or "" to carry on with the key after the last one offered
+func (p *ObjectPager) Seek() string {
+	seek := p.seek
+	p.seek = ""
+	return seek
+}
+
+// skip arranges for the keys under a common prefix to be skipped. It
+// returns false when no key can follow them.
+func (p *ObjectPager) skip(prefix string) bool {
+	p.seek = prefixEnd(prefix)
+	return p.seek != ""
+}
+
+// reserve claims a slot on the page for a key or common prefix, marking
+// the page truncated when it is already full
+func (p *ObjectPager) reserve(marker string) bool {
+	if p.count >= p.maxKeys {
+		p.listing.IsTruncated = true
+		return false
+	}
+	p.count++
+	p.listing.NextMarker = marker
+	return true
+}
+
+// Listing returns the page. The next marker is only set when the page is
+// truncated.
+func (p *ObjectPager) Listing() *ObjectListing {
+	listing := p.listing
+	if !listing.IsTruncated {
+		listing.NextMarker = ""
+	}
+	return &listing
+}
+
+// prefixEnd returns the smallest key greater than every key starting with
+// prefix, or "" if there is none
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,103 @@
+package metadata
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// pageObjects runs an ObjectPager over sorted keys the way a store walks
+// its index, honouring Seek, and returns the page and the keys visited.
+// Keys listed in deleted have a delete marker as their latest version.
+func pageObjects(keys []string, deleted map[string]bool, opts ListOptions) (*ObjectListing, int) {
+	pager := NewObjectPager(opts)
+	visited := 0
+	for i := sort.SearchStrings(keys, pager.StartKey()); i < len(keys); {
+		visited++
+		if !pager.Add(keys[i], &ObjectMetadata{Size: int64(i), IsDeleteMarker: deleted[keys[i]]}) {
+			break
+		}
+		if seek := pager.Seek(); seek != "" {
+			i = sort.SearchStrings(keys, seek)
+		} else {
+			i++
+		}
+	}
+	return pager.Listing(), visited
+}
+
+func objectEntries(l *ObjectListing) []string {
+	var entries []string
+	for _, o := range l.Objects {
+		entries = append(entries, o.Key)
+	}
+	return append(entries, l.CommonPrefixes...)
+}
+
+func TestObjectPager(t *testing.T) {
+	keys := []string{"a", "b", "dir/x", "dir/y", "dir/z", "dir2/x", "e", "gone/x", "mixed/x", "mixed/y"}
+	deleted := map[string]bool{"b": true, "gone/x": true, "mixed/x": true}
+
+	tests := []struct {
+		name      string
+		opts      ListOptions
+		want      []string
+		truncated bool
+		next      string
+	}{
+		{"All", ListOptions{MaxKeys: 1000}, []string{"a", "dir/x", "dir/y", "dir/z", "dir2/x", "e", "mixed/y"}, false, ""},
+		{"FirstPage", ListOptions{MaxKeys: 2}, []string{"a", "dir/x"}, true, "dir/x"},
+		{"ExactFit", ListOptions{MaxKeys: 7}, []string{"a", "dir/x", "dir/y", "dir/z", "dir2/x", "e", "mixed/y"}, false, ""},
+		{"ZeroMaxKeys", ListOptions{MaxKeys: 0}, nil, false, ""},
+		{"Marker", ListOptions{Marker: "dir/y", MaxKeys: 1000}, []string{"dir/z", "dir2/x", "e", "mixed/y"}, false, ""},
+		{"Prefix", ListOptions{Prefix: "dir/", MaxKeys: 1000}, []string{"dir/x", "dir/y", "dir/z"}, false, ""},
+		{"Delimiter", ListOptions{Delimiter: "/", MaxKeys: 1000}, []string{"a", "e", "dir/", "dir2/", "mixed/"}, false, ""},
+		{"DelimiterPage", ListOptions{Delimiter: "/", MaxKeys: 2}, []string{"a", "dir/"}, true, "dir/"},
+		{"ResumeAfterPrefix", ListOptions{Delimiter: "/", Marker: "dir/", MaxKeys: 1000}, []string{"e", "dir2/", "mixed/"}, false, ""},
+		{"PrefixDelimiter", ListOptions{Prefix: "dir", Delimiter: "/", MaxKeys: 1000}, []string{"dir/", "dir2/"}, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, _ := pageObjects(keys, deleted, tt.opts)
+			if got := objectEntries(l); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("entries = %v, want %v", got, tt.want)
+			}
+			if l.IsTruncated != tt.truncated || l.NextMarker != tt.next {
+				t.Errorf("truncated %v next %q, want %v %q", l.IsTruncated, l.NextMarker, tt.truncated, tt.next)
+			}
+		})
+	}
+}
+
+func TestObjectPagerSkipsPrefixes(t *testing.T) {
+	var keys []string
+	for _, dir := range []string{"a/", "b/", "c/"} {
+		for i := 0; i < 1000; i++ {
+			keys = append(keys, dir+string(rune('a'+i%26))+string(rune('a'+i/26)))
+		}
+	}
+	sort.Strings(keys)
+
+	l, visited := pageObjects(keys, nil, ListOptions{Delimiter: "/", MaxKeys: 1000})
+	if got := objectEntries(l); !reflect.DeepEqual(got, []string{"a/", "b/", "c/"}) {
+		t.Fatalf("entries = %v", got)
+	}
+	if visited != 3 {
+		t.Errorf("visited %d keys, want 3", visited)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	tests := map[string]string{
+		"dir/":     "dir0",
+		"a":        "b",
+		"a\xff":    "b",
+		"\xff\xff": "",
+	}
+	for prefix, want := range tests {
+		if got := prefixEnd(prefix); got != want {
+			t.Errorf("prefixEnd(%q) = %q, want %q", prefix, got, want)
+		}
+	}
+}
@@ -3,8 +3,10 @@ package pebble
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/gob"
 	"fmt"
+	"math"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -57,11 +59,35 @@ func objectKey(bucket, key string) []byte {
 	return []byte("object:" + bucket + "/" + key)
 }
 
+// firstVersionSeq is the sequence number of the first version of an object
+const firstVersionSeq uint64 = math.MaxUint64
+
+// versionPrefix generates the prefix shared by the version entries of an object
+func versionPrefix(bucket, key string) string {
+	return "version:" + bucket + "/" + key + "\x00"
+}
+
+// versionKey generates the key of one version entry. Sequence numbers count
+// down from firstVersionSeq, so the entries of an object sort newest first.
+func versionKey(bucket, key string, seq uint64) []byte {
+	return binary.BigEndian.AppendUint64([]byte(versionPrefix(bucket, key)), seq)
+}
+
+// versionSeq returns the sequence number of a version entry key
+func versionSeq(key []byte) uint64 {
+	return binary.BigEndian.Uint64(key[len(key)-8:])
+}
+
 // multipartKey generates a multipart upload key
 func multipartKey(bucket, key, uploadID string) []byte {
 	return []byte("multipart:" + bucket + "/" + key + "/" + uploadID)
 }
 
+// partsPrefix generates the prefix shared by the part keys of a multipart upload
+func partsPrefix(bucket, key, uploadID string) string {
+	return "part:" + bucket + "/" + key + "/" + uploadID + "/"
+}
+
 // lifecycleKey generates a lifecycle rule key
 func lifecycleKey(bucket string) []byte {
 	return []byte("lifecycle:" + bucket)
@@ -194,30 +220,102 @@ func (p *PebbleStore) ListBuckets(ctx context.Context) ([]string, error) {
 	return buckets, nil
 }
 
-// PutObject stores object metadata
+// PutObject stores object metadata as the latest version of the key. A
+// version with the same ID (such as the null version) is replaced.
 func (p *PebbleStore) PutObject(ctx context.Context, bucket, key string, meta *metadata.ObjectMetadata) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	data, err := encodeMeta(meta)
+	versions, err := p.getVersions(bucket, key)
 	if err != nil {
 		return err
 	}
 
-	// Include version ID in key if present
-	keyStr := bucket + "/" + key
-	if meta.VersionID != "" {
-		keyStr += "?v=" + meta.VersionID
+	batch := p.db.NewBatch()
+	defer batch.Close()
+
+	seq := firstVersionSeq
+	if len(versions) > 0 {
+		seq = versionSeq(versions[0].key) - 1
+	}
+	for _, v := range versions {
+		if v.meta.VersionID == meta.VersionID {
+			if err := batch.Delete(v.key, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if !v.stored {
+			if err := setMeta(batch, v.key, &v.meta); err != nil {
+				return err
+			}
+		}
 	}
 
-	return p.db.Set(objectKey(bucket, key), data, pebble.Sync)
+	latest := *meta
+	latest.IsLatest = true
+	data, err := encodeMeta(&latest)
+	if err != nil {
+		return err
+	}
+	if err := batch.Set(versionKey(bucket, key, seq), data, nil); err != nil {
+		return err
+	}
+	if err := batch.Set(objectKey(bucket, key), data, nil); err != nil {
+		return err
+	}
+	return batch.Commit(pebble.Sync)
 }
 
-// GetObject gets object metadata
+// UpdateObjectVersion replaces the metadata of an existing version in place
+func (p *PebbleStore) UpdateObjectVersion(ctx context.Context, bucket, key string, meta *metadata.ObjectMetadata) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	versions, err := p.getVersions(bucket, key)
+	if err != nil {
+		return err
+	}
+	for i, v := range versions {
+		if v.meta.VersionID != meta.VersionID {
+			continue
+		}
+
+		updated := *meta
+		updated.IsLatest = i == 0
+		batch := p.db.NewBatch()
+		defer batch.Close()
+		if err := setMeta(batch, v.key, &updated); err != nil {
+			return err
+		}
+		if i == 0 {
+			if err := setMeta(batch, objectKey(bucket, key), &updated); err != nil {
+				return err
+			}
+		}
+		return batch.Commit(pebble.Sync)
+	}
+	return fmt.Errorf("version not found: %s", meta.VersionID)
+}
+
+// GetObject gets object metadata; an empty versionID selects the latest version
 func (p *PebbleStore) GetObject(ctx context.Context, bucket, key string, versionID string) (*metadata.ObjectMetadata, error) {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
+	if versionID != "" {
+		versions, err := p.getVersions(bucket, key)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range versions {
+			if v.meta.VersionID == versionID {
+				return &v.meta, nil
+			}
+		}
+		return nil, fmt.Errorf("version not found: %s", versionID)
+	}
+
 	data, closer, err := p.db.Get(objectKey(bucket, key))
 	if err != nil {
 		if err == pebble.ErrNotFound {
@@ -232,64 +330,218 @@ func (p *PebbleStore) GetObject(ctx context.Context, bucket, key string, version
 		return nil, err
 	}
 
-	// If version ID specified, verify it matches
-	if versionID != "" && meta.VersionID != versionID {
-		return nil, fmt.Errorf("version not found: %s", versionID)
-	}
-
 	return &meta, nil
 }
 
-// DeleteObject deletes object metadata
+// DeleteObject deletes one version of an object, or every version when
+// versionID is empty. Removing the latest version promotes the next newest.
 func (p *PebbleStore) DeleteObject(ctx context.Context, bucket, key string, versionID string) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	return p.db.Delete(objectKey(bucket, key), pebble.Sync)
+	batch := p.db.NewBatch()
+	defer batch.Close()
+
+	if versionID == "" {
+		prefix := versionPrefix(bucket, key)
+		end := prefix[:len(prefix)-1] + "\x01"
+		if err := batch.DeleteRange([]byte(prefix), []byte(end), nil); err != nil {
+			return err
+		}
+		if err := batch.Delete(objectKey(bucket, key), nil); err != nil {
+			return err
+		}
+		return batch.Commit(pebble.Sync)
+	}
+
+	versions, err := p.getVersions(bucket, key)
+	if err != nil {
+		return err
+	}
+	for i, v := range versions {
+		if v.meta.VersionID != versionID {
+			continue
+		}
+
+		if err := batch.Delete(v.key, nil); err != nil {
+			return err
+		}
+		if i == 0 {
+			if len(versions) > 1 {
+				next := versions[1].meta
+				next.IsLatest = true
+				err = setMeta(batch, objectKey(bucket, key), &next)
+			} else {
+				err = batch.Delete(objectKey(bucket, key), nil)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return batch.Commit(pebble.Sync)
+	}
+	return nil
 }
 
-// ListObjects lists objects with optional prefix
-func (p *PebbleStore) ListObjects(ctx context.Context, bucket, prefix string, opts metadata.ListOptions) ([]metadata.ObjectMetadata, error) {
-	p.mu.RLock()
-	defer p.mu.RUnlock()
+// objectVersion is one version of an object together with the key of its
+// version entry
+type objectVersion struct {
+	key  []byte
+	meta metadata.ObjectMetadata
+	// stored is false for the latest record of an object written before
+	// version tracking, which has no version entry yet
+	stored bool
+}
 
-	prefixKey := "object:" + bucket + "/" + prefix
+// versionIter returns an iterator over the version entries of a bucket
+func (p *PebbleStore) versionIter(bucket string) (*pebble.Iterator, error) {
+	return p.db.NewIter(&pebble.IterOptions{
+		LowerBound: []byte("version:" + bucket + "/"),
+		UpperBound: []byte("version:" + bucket + "0"), // '0' sorts right after '/'
+	})
+}
 
-	iter, err := p.db.NewIter(nil)
+// getVersions returns every version of an object, newest first. Objects
+// written before version tracking have only their latest record.
+func (p *PebbleStore) getVersions(bucket, key string) ([]objectVersion, error) {
+	iter, err := p.versionIter(bucket)
 	if err != nil {
 		return nil, err
 	}
 	defer iter.Close()
 
-	var objects []metadata.ObjectMetadata
-	maxKeys := opts.MaxKeys
-	if maxKeys == 0 {
-		maxKeys = 1000
+	versions, err := readVersions(iter, bucket, key)
+	if err != nil || len(versions) > 0 {
+		return versions, err
 	}
 
-	for iter.SeekGE([]byte(prefixKey)); iter.Valid() && len(objects) < maxKeys; iter.Next() {
-		key := string(iter.Key())
-		if len(key) < 8 || key[:8] != "object:" {
-			break
+	data, closer, err := p.db.Get(objectKey(bucket, key))
+	if err != nil {
+		if err == pebble.ErrNotFound {
+			return nil, nil
 		}
+		return nil, err
+	}
+	defer closer.Close()
+	return unversioned(bucket, key, data)
+}
 
-		// Extract bucket/key
-		rest := key[8:]
-		if len(rest) <= len(bucket)+1 || rest[:len(bucket)+1] != bucket+"/" {
-			continue
+// readVersions seeks iter to the version entries of key and reads them,
+// newest first. Only the first entry is marked as the latest version.
+func readVersions(iter *pebble.Iterator, bucket, key string) ([]objectVersion, error) {
+	prefix := []byte(versionPrefix(bucket, key))
+
+	var versions []objectVersion
+	for valid := iter.SeekGE(prefix); valid && bytes.HasPrefix(iter.Key(), prefix); valid = iter.Next() {
+		v := objectVersion{key: append([]byte(nil), iter.Key()...), stored: true}
+		if err := decodeMeta(iter.Value(), &v.meta); err != nil {
+			return nil, err
 		}
-		objKey := rest[len(bucket)+1:]
-		_ = objKey // Reserved for future use
+		v.meta.IsLatest = len(versions) == 0
+		versions = append(versions, v)
+	}
+	return versions, iter.Error()
+}
 
+// unversioned returns the latest record of an object written before
+// version tracking as its only version
+func unversioned(bucket, key string, data []byte) ([]objectVersion, error) {
+	v := objectVersion{key: versionKey(bucket, key, firstVersionSeq)}
+	if err := decodeMeta(data, &v.meta); err != nil {
+		return nil, err
+	}
+	v.meta.IsLatest = true
+	return []objectVersion{v}, nil
+}
+
+// setMeta encodes meta and adds it to batch under key
+func setMeta(batch *pebble.Batch, key []byte, meta *metadata.ObjectMetadata) error {
+	data, err := encodeMeta(meta)
+	if err != nil {
+		return err
+	}
+	return batch.Set(key, data, nil)
+}
+
+// ListObjects lists the latest version of objects in key order, seeking
+// past the keys rolled up into each common prefix
+func (p *PebbleStore) ListObjects(ctx context.Context, bucket string, opts metadata.ListOptions) (*metadata.ObjectListing, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	pager := metadata.NewObjectPager(opts)
+	bucketPrefix := "object:" + bucket + "/"
+
+	iter, err := p.db.NewIter(&pebble.IterOptions{
+		LowerBound: []byte(bucketPrefix),
+		UpperBound: []byte("object:" + bucket + "0"), // '0' sorts right after '/'
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	for valid := iter.SeekGE([]byte(bucketPrefix + pager.StartKey())); valid; {
+		key := string(iter.Key())[len(bucketPrefix):]
 		var meta metadata.ObjectMetadata
-		if err := decodeMeta(iter.Value(), &meta); err != nil {
-			continue
+		if err := decodeMeta(iter.Value(), &meta); err == nil && !pager.Add(key, &meta) {
+			break
+		}
+		if seek := pager.Seek(); seek != "" {
+			valid = iter.SeekGE([]byte(bucketPrefix + seek))
+		} else {
+			valid = iter.Next()
+		}
+	}
+
+	return pager.Listing(), iter.Error()
+}
+
+// ListObjectVersions lists every version and delete marker in key order,
+// newest version first within a key. It walks the latest-version index and
+// reads the version entries of each key as it goes.
+func (p *PebbleStore) ListObjectVersions(ctx context.Context, bucket string, opts metadata.ListOptions) (*metadata.VersionListing, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	pager := metadata.NewVersionPager(opts)
+	bucketPrefix := "object:" + bucket + "/"
+
+	iter, err := p.db.NewIter(&pebble.IterOptions{
+		LowerBound: []byte(bucketPrefix),
+		UpperBound: []byte("object:" + bucket + "0"), // '0' sorts right after '/'
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	versionIter, err := p.versionIter(bucket)
+	if err != nil {
+		return nil, err
+	}
+	defer versionIter.Close()
+
+	for iter.SeekGE([]byte(bucketPrefix + pager.StartKey())); iter.Valid(); iter.Next() {
+		key := string(iter.Key())[len(bucketPrefix):]
+		versions, err := readVersions(versionIter, bucket, key)
+		if err == nil && len(versions) == 0 {
+			versions, err = unversioned(bucket, key, iter.Value())
+		}
+		if err != nil {
+			return nil, err
 		}
 
-		objects = append(objects, meta)
+		metas := make([]metadata.ObjectMetadata, len(versions))
+		for i, v := range versions {
+			metas[i] = v.meta
+		}
+		if !pager.Add(key, metas) {
+			break
+		}
 	}
 
-	return objects, nil
+	return pager.Listing(), iter.Error()
 }
 
 // CreateMultipartUpload creates a new multipart upload
@@ -307,6 +559,16 @@ func (p *PebbleStore) CreateMultipartUpload(ctx context.Context, bucket, key, up
 		Bucket:    bucket,
 		Initiated: nowUnix(),
 		Metadata:  meta.Metadata,
+
+		ContentType:        meta.ContentType,
+		ContentEncoding:    meta.ContentEncoding,
+		CacheControl:       meta.CacheControl,
+		ContentDisposition: meta.ContentDisposition,
+		ContentLanguage:    meta.ContentLanguage,
+		Expires:            meta.Expires,
+
+		ChecksumAlgorithm: meta.ChecksumAlgorithm,
+		Encryption:        meta.Encryption,
 	}
 
 	data, err := encodeMeta(multiMeta)
@@ -342,13 +604,7 @@ func (p *PebbleStore) CompleteMultipartUpload(ctx context.Context, bucket, key,
 		return err
 	}
 
-	// Delete all parts
-	for i := 1; i <= len(parts); i++ {
-		partKey := fmt.Sprintf("part:%s/%s/%s/%d", bucket, key, uploadID, i)
-		p.db.Delete([]byte(partKey), pebble.Sync)
-	}
-
-	return nil
+	return p.deleteParts(bucket, key, uploadID)
 }
 
 // AbortMultipartUpload aborts a multipart upload
@@ -356,7 +612,18 @@ func (p *PebbleStore) AbortMultipartUpload(ctx context.Context, bucket, key, upl
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	return p.db.Delete(multipartKey(bucket, key, uploadID), pebble.Sync)
+	if err := p.db.Delete(multipartKey(bucket, key, uploadID), pebble.Sync); err != nil {
+		return err
+	}
+	return p.deleteParts(bucket, key, uploadID)
+}
+
+// deleteParts removes every part record of a multipart upload, whatever
+// its part numbers. The caller must hold p.mu.
+func (p *PebbleStore) deleteParts(bucket, key, uploadID string) error {
+	prefix := partsPrefix(bucket, key, uploadID)
+	end := prefix[:len(prefix)-1] + "0" // '0' sorts right after '/'
+	return p.db.DeleteRange([]byte(prefix), []byte(end), pebble.Sync)
 }
 
 // ListParts lists parts of a multipart upload
@@ -364,7 +631,7 @@ func (p *PebbleStore) ListParts(ctx context.Context, bucket, key, uploadID strin
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	prefix := fmt.Sprintf("part:%s/%s/%s/", bucket, key, uploadID)
+	prefix := partsPrefix(bucket, key, uploadID)
 
 	iter, err := p.db.NewIter(nil)
 	if err != nil {
@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/cockroachdb/pebble"
@@ -262,15 +263,211 @@ func TestListObjects(t *testing.T) {
 		})
 	}
 
-	objects, err := store.ListObjects(ctx, "test-bucket", "", metadata.ListOptions{})
+	listing, err := store.ListObjects(ctx, "test-bucket", metadata.ListOptions{MaxKeys: 1000})
 	if err != nil {
 		t.Fatalf("ListObjects() error: %v", err)
 	}
+	objects := listing.Objects
 	if len(objects) == 0 {
 		t.Logf("Warning: ListObjects() returned 0 objects, expected 3")
 	}
 }
 
+func TestObjectVersions(t *testing.T) {
+	dir, err := os.MkdirTemp("", "pebble-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	_ = store.CreateBucket(ctx, "test-bucket")
+
+	for _, vid := range []string{"v1", "v2"} {
+		err := store.PutObject(ctx, "test-bucket", "key", &metadata.ObjectMetadata{
+			Key:       "key",
+			Bucket:    "test-bucket",
+			VersionID: vid,
+			ETag:      "etag-" + vid,
+		})
+		if err != nil {
+			t.Fatalf("PutObject(%s) error: %v", vid, err)
+		}
+	}
+
+	// Each version is its own entry, newest first
+	versions, err := store.getVersions("test-bucket", "key")
+	if err != nil {
+		t.Fatalf("getVersions() error: %v", err)
+	}
+	if len(versions) != 2 || versions[0].meta.VersionID != "v2" || versions[1].meta.VersionID != "v1" {
+		t.Fatalf("getVersions() = %+v, expected v2 then v1", versions)
+	}
+
+	latest, err := store.GetObject(ctx, "test-bucket", "key", "")
+	if err != nil {
+		t.Fatalf("GetObject() error: %v", err)
+	}
+	if latest.VersionID != "v2" || !latest.IsLatest {
+		t.Errorf("GetObject() latest = %s (IsLatest %v), expected v2", latest.VersionID, latest.IsLatest)
+	}
+
+	v1, err := store.GetObject(ctx, "test-bucket", "key", "v1")
+	if err != nil {
+		t.Fatalf("GetObject(v1) error: %v", err)
+	}
+	if v1.ETag != "etag-v1" || v1.IsLatest {
+		t.Errorf("GetObject(v1) = %s (IsLatest %v), expected noncurrent etag-v1", v1.ETag, v1.IsLatest)
+	}
+
+	if _, err := store.GetObject(ctx, "test-bucket", "key", "missing"); err == nil {
+		t.Error("GetObject() expected error for unknown version")
+	}
+
+	// Deleting the latest version promotes the previous one
+	if err := store.DeleteObject(ctx, "test-bucket", "key", "v2"); err != nil {
+		t.Fatalf("DeleteObject(v2) error: %v", err)
+	}
+	latest, err = store.GetObject(ctx, "test-bucket", "key", "")
+	if err != nil {
+		t.Fatalf("GetObject() error: %v", err)
+	}
+	if latest.VersionID != "v1" || !latest.IsLatest {
+		t.Errorf("GetObject() latest = %s after delete, expected v1", latest.VersionID)
+	}
+
+	// Deleting an unknown version is a no-op
+	if err := store.DeleteObject(ctx, "test-bucket", "key", "missing"); err != nil {
+		t.Errorf("DeleteObject(missing) error: %v", err)
+	}
+
+	if err := store.DeleteObject(ctx, "test-bucket", "key", "v1"); err != nil {
+		t.Fatalf("DeleteObject(v1) error: %v", err)
+	}
+	if _, err := store.GetObject(ctx, "test-bucket", "key", ""); err == nil {
+		t.Error("GetObject() expected error after deleting every version")
+	}
+}
+
+func TestObjectVersionsUnversioned(t *testing.T) {
+	dir, err := os.MkdirTemp("", "pebble-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	_ = store.CreateBucket(ctx, "test-bucket")
+
+	// A latest record written before version tracking
+	data, err := encodeMeta(&metadata.ObjectMetadata{Key: "key", Bucket: "test-bucket", ETag: "etag-old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.db.Set(objectKey("test-bucket", "key"), data, pebble.Sync); err != nil {
+		t.Fatal(err)
+	}
+
+	listing, err := store.ListObjectVersions(ctx, "test-bucket", metadata.ListOptions{MaxKeys: 1000})
+	if err != nil {
+		t.Fatalf("ListObjectVersions() error: %v", err)
+	}
+	if len(listing.Versions) != 1 || listing.Versions[0].VersionID != metadata.NullVersionID {
+		t.Fatalf("ListObjectVersions() = %+v, expected the null version", listing.Versions)
+	}
+
+	// A new version keeps the old record as a noncurrent version
+	if err := store.PutObject(ctx, "test-bucket", "key", &metadata.ObjectMetadata{Key: "key", Bucket: "test-bucket", VersionID: "v1"}); err != nil {
+		t.Fatalf("PutObject() error: %v", err)
+	}
+	versions, err := store.getVersions("test-bucket", "key")
+	if err != nil {
+		t.Fatalf("getVersions() error: %v", err)
+	}
+	if len(versions) != 2 || versions[0].meta.VersionID != "v1" || versions[1].meta.ETag != "etag-old" || !versions[1].stored {
+		t.Fatalf("getVersions() = %+v, expected v1 then the stored old record", versions)
+	}
+
+	// Writing the same version ID again replaces it
+	if err := store.PutObject(ctx, "test-bucket", "key", &metadata.ObjectMetadata{Key: "key", Bucket: "test-bucket", VersionID: "v1", ETag: "etag-new"}); err != nil {
+		t.Fatalf("PutObject() error: %v", err)
+	}
+	versions, err = store.getVersions("test-bucket", "key")
+	if err != nil {
+		t.Fatalf("getVersions() error: %v", err)
+	}
+	if len(versions) != 2 || versions[0].meta.ETag != "etag-new" || !versions[0].meta.IsLatest || versions[1].meta.IsLatest {
+		t.Errorf("getVersions() = %+v, expected the replaced v1 as latest", versions)
+	}
+}
+
+func TestListObjectVersions(t *testing.T) {
+	dir, err := os.MkdirTemp("", "pebble-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	_ = store.CreateBucket(ctx, "test-bucket")
+	_ = store.CreateBucket(ctx, "test-bucket-2")
+
+	puts := []struct{ key, vid string }{{"a", "a1"}, {"b", "b1"}, {"a", "a2"}, {"c/d", "d1"}, {"b", "b2"}}
+	for _, p := range puts {
+		_ = store.PutObject(ctx, "test-bucket", p.key, &metadata.ObjectMetadata{Key: p.key, Bucket: "test-bucket", VersionID: p.vid})
+	}
+	_ = store.PutObject(ctx, "test-bucket-2", "a", &metadata.ObjectMetadata{Key: "a", Bucket: "test-bucket-2", VersionID: "other"})
+
+	// Walk every page of two entries
+	var got []string
+	opts := metadata.ListOptions{MaxKeys: 2}
+	for page := 0; page < 10; page++ {
+		listing, err := store.ListObjectVersions(ctx, "test-bucket", opts)
+		if err != nil {
+			t.Fatalf("ListObjectVersions() error: %v", err)
+		}
+		for _, v := range listing.Versions {
+			got = append(got, v.Key+"@"+v.VersionID)
+		}
+		if !listing.IsTruncated {
+			break
+		}
+		opts.Marker = listing.NextKeyMarker
+		opts.VersionIDMarker = listing.NextVersionIDMarker
+	}
+
+	want := []string{"a@a2", "a@a1", "b@b2", "b@b1", "c/d@d1"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("ListObjectVersions() = %v, want %v", got, want)
+	}
+
+	listing, err := store.ListObjectVersions(ctx, "test-bucket", metadata.ListOptions{Delimiter: "/", MaxKeys: 1000})
+	if err != nil {
+		t.Fatalf("ListObjectVersions() error: %v", err)
+	}
+	if len(listing.CommonPrefixes) != 1 || listing.CommonPrefixes[0] != "c/" || len(listing.Versions) != 4 {
+		t.Errorf("ListObjectVersions(delimiter) = %d versions, prefixes %v", len(listing.Versions), listing.CommonPrefixes)
+	}
+}
+
 func TestMultipartUpload(t *testing.T) {
 	dir, err := os.MkdirTemp("", "pebble-test-*")
 	if err != nil {
@@ -387,6 +584,8 @@ func TestAbortMultipartUpload(t *testing.T) {
 	_ = store.CreateBucket(ctx, "test-bucket")
 
 	_ = store.CreateMultipartUpload(ctx, "test-bucket", "test-key", "upload-123", &metadata.ObjectMetadata{})
+	_ = store.PutPart(ctx, "test-bucket", "test-key", "upload-123", 2, &metadata.PartMetadata{PartNumber: 2})
+	_ = store.PutPart(ctx, "test-bucket", "test-key", "upload-123", 7, &metadata.PartMetadata{PartNumber: 7})
 
 	err = store.AbortMultipartUpload(ctx, "test-bucket", "test-key", "upload-123")
 	if err != nil {
@@ -397,6 +596,10 @@ func TestAbortMultipartUpload(t *testing.T) {
 	if len(uploads) != 0 {
 		t.Errorf("Expected 0 uploads after abort, got %d", len(uploads))
 	}
+	parts, _ := store.ListParts(ctx, "test-bucket", "test-key", "upload-123")
+	if len(parts) != 0 {
+		t.Errorf("Expected 0 parts after abort, got %d", len(parts))
+	}
 }
 
 func TestLifecycleRules(t *testing.T) {
@@ -1378,6 +1581,20 @@ func TestObjectMetaKey(t *testing.T) {
 	}
 }
 
+func TestVersionKey(t *testing.T) {
+	older := versionKey("my-bucket", "my-object", firstVersionSeq)
+	newer := versionKey("my-bucket", "my-object", firstVersionSeq-1)
+	if !bytes.HasPrefix(older, []byte(versionPrefix("my-bucket", "my-object"))) {
+		t.Errorf("versionKey() = %q, want prefix %q", older, versionPrefix("my-bucket", "my-object"))
+	}
+	if bytes.Compare(newer, older) >= 0 {
+		t.Errorf("versionKey() newer %q should sort before older %q", newer, older)
+	}
+	if versionSeq(newer) != firstVersionSeq-1 {
+		t.Errorf("versionSeq() = %d, want %d", versionSeq(newer), firstVersionSeq-1)
+	}
+}
+
 func TestVersioningKey(t *testing.T) {
 	key := versioningKey("my-bucket")
 	expected := "versioning:my-bucket"
@@ -2329,10 +2546,11 @@ func TestListObjectsWithPrefix(t *testing.T) {
 	}
 
 	// List objects with prefix
-	objects, err := store.ListObjects(ctx, "test-bucket", "prefix1/", metadata.ListOptions{MaxKeys: 1000})
+	listing, err := store.ListObjects(ctx, "test-bucket", metadata.ListOptions{Prefix: "prefix1/", MaxKeys: 1000})
 	if err != nil {
 		t.Fatalf("ListObjects() error: %v", err)
 	}
+	objects := listing.Objects
 	// Just ensure it doesn't error - actual filtering depends on implementation
 	_ = objects
 }
@@ -2688,10 +2906,11 @@ func TestListObjectsBucketMismatch(t *testing.T) {
 	_ = store.PutObject(ctx, "bucket1", "obj1", &metadata.ObjectMetadata{Key: "obj1", Bucket: "bucket1"})
 	_ = store.PutObject(ctx, "bucket2", "obj2", &metadata.ObjectMetadata{Key: "obj2", Bucket: "bucket2"})
 
-	objects, err := store.ListObjects(ctx, "bucket1", "", metadata.ListOptions{MaxKeys: 1000})
+	listing, err := store.ListObjects(ctx, "bucket1", metadata.ListOptions{MaxKeys: 1000})
 	if err != nil {
 		t.Fatalf("ListObjects() error: %v", err)
 	}
+	objects := listing.Objects
 	for _, obj := range objects {
 		if obj.Bucket != "bucket1" {
 			t.Errorf("ListObjects() returned object from wrong bucket: %s", obj.Bucket)
@@ -3092,10 +3311,11 @@ func TestListObjectsWithNonObjectKey(t *testing.T) {
 
 	store.db.Set([]byte("object:test-bucket/zzz-after-objects"), []byte("data"), pebble.Sync)
 
-	objects, err := store.ListObjects(ctx, "test-bucket", "", metadata.ListOptions{MaxKeys: 1000})
+	listing, err := store.ListObjects(ctx, "test-bucket", metadata.ListOptions{MaxKeys: 1000})
 	if err != nil {
 		t.Fatalf("ListObjects() error: %v", err)
 	}
+	objects := listing.Objects
 	_ = objects
 }
 
@@ -3119,10 +3339,11 @@ func TestListObjectsWithMaxKeys(t *testing.T) {
 		_ = store.PutObject(ctx, "test-bucket", fmt.Sprintf("obj%d", i), &metadata.ObjectMetadata{Key: fmt.Sprintf("obj%d", i), Bucket: "test-bucket"})
 	}
 
-	objects, err := store.ListObjects(ctx, "test-bucket", "", metadata.ListOptions{MaxKeys: 3})
+	listing, err := store.ListObjects(ctx, "test-bucket", metadata.ListOptions{MaxKeys: 3})
 	if err != nil {
 		t.Fatalf("ListObjects() error: %v", err)
 	}
+	objects := listing.Objects
 	if len(objects) > 3 {
 		t.Errorf("ListObjects() returned %d objects, expected at most 3", len(objects))
 	}
@@ -3148,10 +3369,11 @@ func TestListObjectsWithInvalidData(t *testing.T) {
 
 	_ = store.PutObject(ctx, "test-bucket", "zzz-valid-obj", &metadata.ObjectMetadata{Key: "zzz-valid-obj", Bucket: "test-bucket"})
 
-	objects, err := store.ListObjects(ctx, "test-bucket", "", metadata.ListOptions{MaxKeys: 1000})
+	listing, err := store.ListObjects(ctx, "test-bucket", metadata.ListOptions{MaxKeys: 1000})
 	if err != nil {
 		t.Fatalf("ListObjects() error: %v", err)
 	}
+	objects := listing.Objects
 	for _, obj := range objects {
 		if obj.Key == "aaa-invalid-obj" {
 			t.Error("ListObjects() should skip invalid data")
@@ -3588,10 +3810,11 @@ func TestListObjectsDifferentBucketPrefix(t *testing.T) {
 	_ = store.PutObject(ctx, "bucket-b", "obj2", &metadata.ObjectMetadata{Key: "obj2", Bucket: "bucket-b"})
 	_ = store.PutObject(ctx, "bucket-b", "obj3", &metadata.ObjectMetadata{Key: "obj3", Bucket: "bucket-b"})
 
-	objects, err := store.ListObjects(ctx, "bucket-b", "", metadata.ListOptions{MaxKeys: 1000})
+	listing, err := store.ListObjects(ctx, "bucket-b", metadata.ListOptions{MaxKeys: 1000})
 	if err != nil {
 		t.Fatalf("ListObjects() error: %v", err)
 	}
+	objects := listing.Objects
 	for _, obj := range objects {
 		if obj.Bucket != "bucket-b" {
 			t.Errorf("ListObjects() returned object from wrong bucket: %s", obj.Bucket)
@@ -3839,10 +4062,11 @@ func TestListObjectsNonObjectKeyBreak(t *testing.T) {
 
 	store.db.Set([]byte("object;aaa-bucket/after-objects"), []byte("data"), pebble.Sync)
 
-	objects, err := store.ListObjects(ctx, "aaa-bucket", "", metadata.ListOptions{MaxKeys: 1000})
+	listing, err := store.ListObjects(ctx, "aaa-bucket", metadata.ListOptions{MaxKeys: 1000})
 	if err != nil {
 		t.Fatalf("ListObjects() error: %v", err)
 	}
+	objects := listing.Objects
 	_ = objects
 }
 
@@ -3872,13 +4096,53 @@ func TestListObjectsBucketMismatchContinue(t *testing.T) {
 
 	store.db.Set([]byte("object;aaa-bucket/after-objects"), []byte("data"), pebble.Sync)
 
-	objects, err := store.ListObjects(ctx, "aaa-bucket", "", metadata.ListOptions{MaxKeys: 1000})
+	listing, err := store.ListObjects(ctx, "aaa-bucket", metadata.ListOptions{MaxKeys: 1000})
 	if err != nil {
 		t.Fatalf("ListObjects() error: %v", err)
 	}
+	objects := listing.Objects
 	for _, obj := range objects {
 		if obj.Bucket != "aaa-bucket" {
 			t.Errorf("ListObjects() returned object from wrong bucket: %s", obj.Bucket)
 		}
 	}
 }
+
+func TestListObjectsPages(t *testing.T) {
+	store, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	_ = store.CreateBucket(ctx, "test-bucket")
+	_ = store.CreateBucket(ctx, "test-bucket2")
+	for _, key := range []string{"a", "dir/x", "dir/y", "dir2/z", "e", "f"} {
+		_ = store.PutObject(ctx, "test-bucket", key, &metadata.ObjectMetadata{Key: key, Bucket: "test-bucket"})
+	}
+	_ = store.PutObject(ctx, "test-bucket2", "b", &metadata.ObjectMetadata{Key: "b", Bucket: "test-bucket2"})
+
+	var got []string
+	opts := metadata.ListOptions{Delimiter: "/", MaxKeys: 2}
+	for page := 0; page < 10; page++ {
+		listing, err := store.ListObjects(ctx, "test-bucket", opts)
+		if err != nil {
+			t.Fatalf("ListObjects() error: %v", err)
+		}
+		for _, obj := range listing.Objects {
+			got = append(got, obj.Key)
+		}
+		got = append(got, listing.CommonPrefixes...)
+		if !listing.IsTruncated {
+			break
+		}
+		opts.Marker = listing.NextMarker
+	}
+
+	// Each page lists its objects before its common prefixes
+	want := "a dir/ e dir2/ f"
+	if strings.Join(got, " ") != want {
+		t.Errorf("pages = %v, want %s", got, want)
+	}
+}
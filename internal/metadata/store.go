@@ -34,7 +34,11 @@ type Store interface {
 	PutObject(ctx context.Context, bucket, key string, meta *ObjectMetadata) error
 	GetObject(ctx context.Context, bucket, key string, versionID string) (*ObjectMetadata, error)
 	DeleteObject(ctx context.Context, bucket, key string, versionID string) error
-	ListObjects(ctx context.Context, bucket, prefix string, opts ListOptions) ([]ObjectMetadata, error)
+	ListObjects(ctx context.Context, bucket string, opts ListOptions) (*ObjectListing, error)
+	ListObjectVersions(ctx context.Context, bucket string, opts ListOptions) (*VersionListing, error)
+	// UpdateObjectVersion replaces the metadata of an existing version in
+	// place, leaving which version is latest unchanged
+	UpdateObjectVersion(ctx context.Context, bucket, key string, meta *ObjectMetadata) error
 
 	// Multipart upload operations
 	CreateMultipartUpload(ctx context.Context, bucket, key, uploadID string, meta *ObjectMetadata) error
@@ -162,21 +166,53 @@ type BucketMetadata struct {
 
 // ObjectMetadata contains object-level metadata
 type ObjectMetadata struct {
-	Key             string            `json:"key"`
-	Bucket          string            `json:"bucket"`
-	Size            int64             `json:"size"`
-	ETag            string            `json:"etag"`
-	ContentType     string            `json:"content_type"`
-	ContentEncoding string            `json:"content_encoding"`
-	CacheControl    string            `json:"cache_control"`
-	Metadata        map[string]string `json:"metadata"`
-	StorageClass    string            `json:"storage_class"`
-	VersionID       string            `json:"version_id"`
-	IsLatest        bool              `json:"is_latest"`
-	IsDeleteMarker  bool              `json:"is_delete_marker"`
-	LastModified    int64             `json:"last_modified"`
-	Expires         int64             `json:"expires"`
-	Parts           []PartInfo        `json:"parts,omitempty"`
+	Key                string            `json:"key"`
+	Bucket             string            `json:"bucket"`
+	Size               int64             `json:"size"`
+	ETag               string            `json:"etag"`
+	ContentType        string            `json:"content_type"`
+	ContentEncoding    string            `json:"content_encoding"`
+	CacheControl       string            `json:"cache_control"`
+	ContentDisposition string            `json:"content_disposition,omitempty"`
+	ContentLanguage    string            `json:"content_language,omitempty"`
+	Metadata           map[string]string `json:"metadata"`
+	StorageClass       string            `json:"storage_class"`
+	VersionID          string            `json:"version_id"`
+	IsLatest           bool              `json:"is_latest"`
+	IsDeleteMarker     bool              `json:"is_delete_marker"`
+	VersionedData      bool              `json:"versioned_data,omitempty"` // data kept per version rather than at the key
+	LastModified       int64             `json:"last_modified"`
+	Expires            int64             `json:"expires"`
+	Parts              []PartInfo        `json:"parts,omitempty"`
+
+	// Additional checksum of the data: the algorithm (CRC32, CRC32C, SHA1
+	// or SHA256) and its base64 value, which for multipart objects is the
+	// composite checksum of the parts followed by -<part count>
+	ChecksumAlgorithm string `json:"checksum_algorithm,omitempty"`
+	Checksum          string `json:"checksum,omitempty"`
+
+	// Encryption describes how the data is sealed at rest, nil when it is
+	// stored as plaintext
+	Encryption *ObjectEncryption `json:"encryption,omitempty"`
+
+	// Object lock state of this version, nil when never set. They belong
+	// to the version, so they never carry over to other versions of the key.
+	Retention *ObjectRetention `json:"retention,omitempty"`
+	LegalHold *ObjectLegalHold `json:"legal_hold,omitempty"`
+
+	// Tags set by PutObjectTagging on this version, nil when it has none
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// ObjectEncryption records the server-side encryption of an object. The
+// data is sealed under a random data key, itself sealed by the master key
+// (SSE-S3) or the customer's key (SSE-C). A multipart object keeps the
+// sealed key of each part in its parts instead.
+type ObjectEncryption struct {
+	Algorithm      string `json:"algorithm"`
+	CustomerKeyMD5 string `json:"customer_key_md5,omitempty"` // base64 MD5 of the SSE-C key, "" otherwise
+	KMSKeyID       string `json:"kms_key_id,omitempty"`       // KMS key sealing the data keys of SSE-KMS
+	SealedKey      string `json:"sealed_key,omitempty"`
 }
 
 // PartInfo represents a part in a multipart upload
@@ -184,6 +220,8 @@ type PartInfo struct {
 	PartNumber int    `json:"part_number"`
 	ETag       string `json:"etag"`
 	Size       int64  `json:"size"`
+	Checksum   string `json:"checksum,omitempty"`
+	SealedKey  string `json:"sealed_key,omitempty"` // data key of an encrypted part
 }
 
 // PartMetadata contains metadata for a part
@@ -195,6 +233,11 @@ type PartMetadata struct {
 	ETag         string `json:"etag"`
 	Size         int64  `json:"size"`
 	LastModified int64  `json:"last_modified"`
+
+	ChecksumAlgorithm string `json:"checksum_algorithm,omitempty"`
+	Checksum          string `json:"checksum,omitempty"`
+
+	SealedKey string `json:"sealed_key,omitempty"` // data key of an encrypted part
 }
 
 // MultipartUploadMetadata contains metadata for a multipart upload
@@ -204,6 +247,22 @@ type MultipartUploadMetadata struct {
 	Bucket   string            `json:"bucket"`
 	Initiated int64            `json:"initiated"`
 	Metadata map[string]string `json:"metadata"`
+
+	// Standard headers given at initiation, applied to the completed object
+	ContentType        string `json:"content_type,omitempty"`
+	ContentEncoding    string `json:"content_encoding,omitempty"`
+	CacheControl       string `json:"cache_control,omitempty"`
+	ContentDisposition string `json:"content_disposition,omitempty"`
+	ContentLanguage    string `json:"content_language,omitempty"`
+	Expires            int64  `json:"expires,omitempty"`
+
+	// ChecksumAlgorithm is computed for every part and combined into the
+	// composite checksum of the completed object
+	ChecksumAlgorithm string `json:"checksum_algorithm,omitempty"`
+
+	// Encryption is the server-side encryption of every part and of the
+	// completed object; each part is sealed under its own data key
+	Encryption *ObjectEncryption `json:"encryption,omitempty"`
 }
 
 // LifecycleRule defines a lifecycle rule
@@ -251,6 +310,16 @@ type ApplyEncryptionConfiguration struct {
 // ObjectLockConfig contains object lock configuration
 type ObjectLockConfig struct {
 	Enabled bool `json:"Enabled"`
+	// DefaultRetention is applied to objects written to the bucket, if set
+	DefaultRetention *DefaultRetention `json:"DefaultRetention,omitempty"`
+}
+
+// DefaultRetention is the retention a bucket gives new objects: Mode for
+// a period of either Days or Years
+type DefaultRetention struct {
+	Mode  string `json:"Mode"` // GOVERNANCE, COMPLIANCE
+	Days  int    `json:"Days,omitempty"`
+	Years int    `json:"Years,omitempty"`
 }
 
 // ObjectRetention contains object retention configuration
@@ -463,7 +532,7 @@ type MetricsConfiguration struct {
 // MetricsFilter contains metrics filter
 type MetricsFilter struct {
 	Prefix string            `json:"Prefix,omitempty"`
-	Tag    map[string]string `json:"Tag,omitempty"`
+	Tag    map[string]string `json:"Tag,omitempty" xml:"-"` // encoding/xml cannot handle maps
 }
 
 // ReplicationConfig contains bucket replication configuration
@@ -488,10 +557,10 @@ type Destination struct {
 
 // ListOptions contains options for listing objects
 type ListOptions struct {
-	Prefix       string
-	Delimiter    string
-	MaxKeys      int
-	Marker       string
+	Prefix          string
+	Delimiter       string
+	MaxKeys         int // entries on a page; callers apply their own default
+	Marker          string
 	VersionIDMarker string
 }
 
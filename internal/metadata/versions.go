@@ -0,0 +1,191 @@
+package metadata
+
+import "strings"
+
+// NullVersionID is the version ID of objects written while versioning is
+// not enabled on their bucket
+const NullVersionID = "null"
+
+// AddVersion returns versions with meta prepended as the latest version.
+// An existing version with the same ID (such as the null version) is
+// replaced, and every other version is marked as no longer latest.
+func AddVersion(versions []ObjectMetadata, meta ObjectMetadata) []ObjectMetadata {
+	meta.IsLatest = true
+	result := make([]ObjectMetadata, 0, len(versions)+1)
+	result = append(result, meta)
+	for _, v := range versions {
+		if v.VersionID == meta.VersionID {
+			continue
+		}
+		v.IsLatest = false
+		result = append(result, v)
+	}
+	return result
+}
+
+// FindVersion returns the version with the given ID
+func FindVersion(versions []ObjectMetadata, versionID string) (*ObjectMetadata, bool) {
+	for i := range versions {
+		if versions[i].VersionID == versionID {
+			return &versions[i], true
+		}
+	}
+	return nil, false
+}
+
+// ReplaceVersion returns versions with the version of meta's ID replaced by
+// meta, keeping its position and latest flag. It reports false when no
+// version has that ID.
+func ReplaceVersion(versions []ObjectMetadata, meta ObjectMetadata) ([]ObjectMetadata, bool) {
+	result := make([]ObjectMetadata, len(versions))
+	copy(result, versions)
+	for i := range result {
+		if result[i].VersionID == meta.VersionID {
+			meta.IsLatest = result[i].IsLatest
+			result[i] = meta
+			return result, true
+		}
+	}
+	return versions, false
+}
+
+// RemoveVersion returns versions without the given version ID. If the
+// removed version was the latest, the next newest version becomes latest.
+func RemoveVersion(versions []ObjectMetadata, versionID string) ([]ObjectMetadata, bool) {
+	result := make([]ObjectMetadata, 0, len(versions))
+	found := false
+	for _, v := range versions {
+		if v.VersionID == versionID && !found {
+			found = true
+			continue
+		}
+		result = append(result, v)
+	}
+	if len(result) > 0 {
+		result[0].IsLatest = true
+	}
+	return result, found
+}
+
+// VersionListing is one page of object versions and delete markers,
+// ordered by key and then newest version first
+type VersionListing struct {
+	Versions            []ObjectMetadata
+	CommonPrefixes      []string
+	IsTruncated         bool
+	NextKeyMarker       string
+	NextVersionIDMarker string
+}
+
+// VersionPager builds a VersionListing from the version lists of keys
+// visited in ascending key order. It applies the prefix, delimiter,
+// max-keys and key/version-id markers of opts, so stores only need to walk
+// their object index from StartKey and feed every key to Add.
+type VersionPager struct {
+	opts    ListOptions
+	maxKeys int
+	count   int
+	listing VersionListing
+}
+
+// NewVersionPager creates a pager for opts. A MaxKeys of 0 yields an empty
+// page.
+func NewVersionPager(opts ListOptions) *VersionPager {
+	return &VersionPager{opts: opts, maxKeys: opts.MaxKeys}
+}
+
+// StartKey returns the first key the store needs to visit
+func (p *VersionPager) StartKey() string {
+	if p.opts.Marker > p.opts.Prefix {
+		return p.opts.Marker
+	}
+	return p.opts.Prefix
+}
+
+// Add offers the versions of key, newest first, to the page. It returns
+// false once the page is complete and no further keys are needed.
+func (p *VersionPager) Add(key string, versions []ObjectMetadata) bool {
+	if p.listing.IsTruncated || p.maxKeys <= 0 {
+		return false
+	}
+	if !strings.HasPrefix(key, p.opts.Prefix) {
+		// Keys are visited in order, so none after this one match either
+		return key < p.opts.Prefix
+	}
+	if key < p.opts.Marker || (key == p.opts.Marker && p.opts.VersionIDMarker == "") {
+		return true
+	}
+
+	if p.opts.Delimiter != "" {
+		rest := key[len(p.opts.Prefix):]
+		if i := strings.Index(rest, p.opts.Delimiter); i >= 0 {
+			cp := p.opts.Prefix + rest[:i+len(p.opts.Delimiter)]
+			n := len(p.listing.CommonPrefixes)
+			if cp == p.opts.Marker || (n > 0 && p.listing.CommonPrefixes[n-1] == cp) {
+				return true
+			}
+			if !p.reserve(cp, "") {
+				return false
+			}
+			p.listing.CommonPrefixes = append(p.listing.CommonPrefixes, cp)
+			return true
+		}
+	}
+
+	// Resuming inside a key skips up to and including the marker version
+	skip := 0
+	if key == p.opts.Marker {
+		skip = len(versions)
+		for i, v := range versions {
+			if versionID(v) == p.opts.VersionIDMarker {
+				skip = i + 1
+				break
+			}
+		}
+	}
+
+	for i := skip; i < len(versions); i++ {
+		v := versions[i]
+		v.Key = key
+		v.VersionID = versionID(v)
+		v.IsLatest = i == 0
+		if !p.reserve(key, v.VersionID) {
+			return false
+		}
+		p.listing.Versions = append(p.listing.Versions, v)
+	}
+	return true
+}
+
+// reserve claims a slot on the page for the entry identified by key and
+// versionID, marking the page truncated when it is already full
+func (p *VersionPager) reserve(key, versionID string) bool {
+	if p.count >= p.maxKeys {
+		p.listing.IsTruncated = true
+		return false
+	}
+	p.count++
+	p.listing.NextKeyMarker = key
+	p.listing.NextVersionIDMarker = versionID
+	return true
+}
+
+// Listing returns the page. The next markers are only set when the page
+// is truncated.
+func (p *VersionPager) Listing() *VersionListing {
+	listing := p.listing
+	if !listing.IsTruncated {
+		listing.NextKeyMarker = ""
+		listing.NextVersionIDMarker = ""
+	}
+	return &listing
+}
+
+// versionID returns the version ID of v; records written before version
+// tracking are the null version
+func versionID(v ObjectMetadata) string {
+	if v.VersionID == "" {
+		return NullVersionID
+	}
+	return v.VersionID
+}
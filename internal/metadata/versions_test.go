@@ -0,0 +1,170 @@
+package metadata
+
+import "testing"
+
+func versionIDs(versions []ObjectMetadata) []string {
+	ids := make([]string, len(versions))
+	for i, v := range versions {
+		ids[i] = v.VersionID
+		if v.IsLatest != (i == 0) {
+			ids[i] += "!"
+		}
+	}
+	return ids
+}
+
+func TestAddVersion(t *testing.T) {
+	var versions []ObjectMetadata
+	versions = AddVersion(versions, ObjectMetadata{VersionID: NullVersionID})
+	versions = AddVersion(versions, ObjectMetadata{VersionID: "v1"})
+	versions = AddVersion(versions, ObjectMetadata{VersionID: "v2"})
+
+	if got := versionIDs(versions); len(got) != 3 || got[0] != "v2" || got[1] != "v1" || got[2] != "null" {
+		t.Fatalf("versions = %v, want [v2 v1 null]", got)
+	}
+
+	// Writing the null version again replaces it and makes it latest
+	versions = AddVersion(versions, ObjectMetadata{VersionID: NullVersionID, Size: 5})
+	if got := versionIDs(versions); len(got) != 3 || got[0] != "null" || got[1] != "v2" || got[2] != "v1" {
+		t.Fatalf("versions = %v, want [null v2 v1]", got)
+	}
+	if v, ok := FindVersion(versions, NullVersionID); !ok || v.Size != 5 {
+		t.Errorf("FindVersion(null) = %v, %v", v, ok)
+	}
+}
+
+func TestRemoveVersion(t *testing.T) {
+	var versions []ObjectMetadata
+	for _, id := range []string{"v1", "v2", "v3"} {
+		versions = AddVersion(versions, ObjectMetadata{VersionID: id})
+	}
+
+	versions, found := RemoveVersion(versions, "v3")
+	if !found {
+		t.Fatal("RemoveVersion(v3) should find the version")
+	}
+	if got := versionIDs(versions); len(got) != 2 || got[0] != "v2" || got[1] != "v1" {
+		t.Fatalf("versions = %v, want [v2 v1] with v2 latest", got)
+	}
+
+	if _, found := RemoveVersion(versions, "missing"); found {
+		t.Error("RemoveVersion(missing) should not find a version")
+	}
+	if _, ok := FindVersion(versions, "v3"); ok {
+		t.Error("FindVersion(v3) should fail after removal")
+	}
+}
+
+func TestReplaceVersion(t *testing.T) {
+	var versions []ObjectMetadata
+	for _, id := range []string{"v1", "v2"} {
+		versions = AddVersion(versions, ObjectMetadata{VersionID: id})
+	}
+
+	replaced, found := ReplaceVersion(versions, ObjectMetadata{VersionID: "v1", ETag: "new"})
+	if !found {
+		t.Fatal("ReplaceVersion(v1) should find the version")
+	}
+	if got := versionIDs(replaced); len(got) != 2 || got[0] != "v2" || got[1] != "v1" {
+		t.Fatalf("versions = %v, want [v2 v1] with v2 latest", got)
+	}
+	if replaced[1].ETag != "new" || replaced[1].IsLatest {
+		t.Errorf("replaced version = %+v, want the new ETag and not latest", replaced[1])
+	}
+	if versions[1].ETag != "" {
+		t.Error("ReplaceVersion should not modify its input")
+	}
+
+	if _, found := ReplaceVersion(versions, ObjectMetadata{VersionID: "missing"}); found {
+		t.Error("ReplaceVersion(missing) should not find a version")
+	}
+}
+
+// pageVersions runs a VersionPager over an in-memory index of keys to
+// version IDs, newest first
+func pageVersions(index map[string][]string, keys []string, opts ListOptions) *VersionListing {
+	pager := NewVersionPager(opts)
+	for _, key := range keys {
+		if key < pager.StartKey() {
+			continue
+		}
+		var versions []ObjectMetadata
+		for _, id := range index[key] {
+			versions = append(versions, ObjectMetadata{VersionID: id})
+		}
+		if !pager.Add(key, versions) {
+			break
+		}
+	}
+	return pager.Listing()
+}
+
+func listingEntries(l *VersionListing) []string {
+	var entries []string
+	for _, v := range l.Versions {
+		entries = append(entries, v.Key+"@"+v.VersionID)
+	}
+	for _, cp := range l.CommonPrefixes {
+		entries = append(entries, cp)
+	}
+	return entries
+}
+
+func TestVersionPager(t *testing.T) {
+	index := map[string][]string{
+		"a":     {"a2", "a1"},
+		"b":     {""},
+		"dir/x": {"x1"},
+		"dir/y": {"y1"},
+		"e":     {"e3", "e2", "e1"},
+	}
+	keys := []string{"a", "b", "dir/x", "dir/y", "e"}
+
+	tests := []struct {
+		name          string
+		opts          ListOptions
+		want          []string
+		truncated     bool
+		nextKey       string
+		nextVersionID string
+	}{
+		{"All", ListOptions{MaxKeys: 1000}, []string{"a@a2", "a@a1", "b@null", "dir/x@x1", "dir/y@y1", "e@e3", "e@e2", "e@e1"}, false, "", ""},
+		{"FirstPage", ListOptions{MaxKeys: 3}, []string{"a@a2", "a@a1", "b@null"}, true, "b", "null"},
+		{"SplitKey", ListOptions{MaxKeys: 1}, []string{"a@a2"}, true, "a", "a2"},
+		{"ZeroMaxKeys", ListOptions{MaxKeys: 0}, nil, false, "", ""},
+		{"ResumeInKey", ListOptions{MaxKeys: 2, Marker: "a", VersionIDMarker: "a2"}, []string{"a@a1", "b@null"}, true, "b", "null"},
+		{"ResumeAfterKey", ListOptions{Marker: "dir/x", MaxKeys: 1000}, []string{"dir/y@y1", "e@e3", "e@e2", "e@e1"}, false, "", ""},
+		{"Prefix", ListOptions{Prefix: "dir/", MaxKeys: 1000}, []string{"dir/x@x1", "dir/y@y1"}, false, "", ""},
+		{"Delimiter", ListOptions{Delimiter: "/", MaxKeys: 1000}, []string{"a@a2", "a@a1", "b@null", "e@e3", "e@e2", "e@e1", "dir/"}, false, "", ""},
+		{"DelimiterPage", ListOptions{Delimiter: "/", MaxKeys: 4}, []string{"a@a2", "a@a1", "b@null", "dir/"}, true, "dir/", ""},
+		{"ResumeAfterPrefix", ListOptions{Delimiter: "/", Marker: "dir/", MaxKeys: 1000}, []string{"e@e3", "e@e2", "e@e1"}, false, "", ""},
+		{"ExactFit", ListOptions{MaxKeys: 8}, []string{"a@a2", "a@a1", "b@null", "dir/x@x1", "dir/y@y1", "e@e3", "e@e2", "e@e1"}, false, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := pageVersions(index, keys, tt.opts)
+			got := listingEntries(l)
+			if len(got) != len(tt.want) {
+				t.Fatalf("entries = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("entries = %v, want %v", got, tt.want)
+				}
+			}
+			if l.IsTruncated != tt.truncated || l.NextKeyMarker != tt.nextKey || l.NextVersionIDMarker != tt.nextVersionID {
+				t.Errorf("truncated %v next %q/%q, want %v %q/%q", l.IsTruncated, l.NextKeyMarker, l.NextVersionIDMarker, tt.truncated, tt.nextKey, tt.nextVersionID)
+			}
+		})
+	}
+}
+
+func TestVersionPagerIsLatest(t *testing.T) {
+	pager := NewVersionPager(ListOptions{MaxKeys: 1000})
+	pager.Add("k", []ObjectMetadata{{VersionID: "v2", IsDeleteMarker: true}, {VersionID: "v1"}})
+	l := pager.Listing()
+	if len(l.Versions) != 2 || !l.Versions[0].IsLatest || l.Versions[1].IsLatest || l.Versions[0].Key != "k" {
+		t.Errorf("versions = %+v, want latest marker then noncurrent v1", l.Versions)
+	}
+}
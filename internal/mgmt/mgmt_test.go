@@ -3,18 +3,28 @@ package mgmt
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
 	"encoding/json"
+	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/openendpoint/openendpoint/internal/auth"
 	"github.com/openendpoint/openendpoint/internal/bucketconfig"
 	"github.com/openendpoint/openendpoint/internal/cluster"
+	"github.com/openendpoint/openendpoint/internal/config"
+	"github.com/openendpoint/openendpoint/internal/encryption"
 	"github.com/openendpoint/openendpoint/internal/engine"
 	"github.com/openendpoint/openendpoint/internal/lifecycle"
 	"github.com/openendpoint/openendpoint/internal/replication"
+	"github.com/openendpoint/openendpoint/internal/storage/packed"
 	"go.uber.org/zap"
 )
 
@@ -2735,3 +2745,150 @@ func TestRouter_HandleSetBucketPolicy_NonExistentBucket(t *testing.T) {
 	}
 }
 
+
+func TestRouter_HandleCompaction(t *testing.T) {
+	router, cleanup := createTestRouter(t)
+	defer cleanup()
+
+	do := func(method, target string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		var resp map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w, resp
+	}
+
+	// Without the packed backend
+	if w, resp := do("GET", "/_mgmt/storage/compaction"); w.Code != http.StatusOK || resp["enabled"] != false {
+		t.Errorf("GET = %d %v, want disabled", w.Code, resp)
+	}
+	if w, _ := do("POST", "/_mgmt/storage/compaction"); w.Code != http.StatusBadRequest {
+		t.Errorf("POST status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	backend, err := packed.New(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	router.SetCompactor(packed.NewCompactor(backend, 0, 0.5))
+
+	if w, resp := do("GET", "/_mgmt/storage/compaction"); w.Code != http.StatusOK || resp["enabled"] != true || resp["status"] == nil {
+		t.Errorf("GET = %d %v, want status", w.Code, resp)
+	}
+	if w, _ := do("POST", "/_mgmt/storage/compaction?threshold=2"); w.Code != http.StatusBadRequest {
+		t.Errorf("POST with bad threshold status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	// The compactor is not started, so the first pass stays queued
+	if w, _ := do("POST", "/_mgmt/storage/compaction?threshold=0.2"); w.Code != http.StatusAccepted {
+		t.Errorf("POST status = %d, want %d", w.Code, http.StatusAccepted)
+	}
+	if w, _ := do("POST", "/_mgmt/storage/compaction"); w.Code != http.StatusConflict {
+		t.Errorf("second POST status = %d, want %d", w.Code, http.StatusConflict)
+	}
+}
+
+func TestRouter_HandleKMSKeys(t *testing.T) {
+	router, cleanup := createTestRouter(t)
+	defer cleanup()
+
+	do := func(method, target, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, target, bytes.NewBufferString(body)))
+		var resp map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w, resp
+	}
+
+	// Without a key provider
+	if w, _ := do("GET", "/_mgmt/kms/keys", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("GET status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w, resp := do("GET", "/_mgmt/kms/rewrap", ""); w.Code != http.StatusOK || resp["enabled"] != false {
+		t.Errorf("GET rewrap = %d %v, want disabled", w.Code, resp)
+	}
+
+	keyring, err := encryption.OpenLocalKeyring(filepath.Join(t.TempDir(), "keyring"), bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.SetKMS(keyring, engine.NewRewrapper(router.engine, 0))
+
+	if w, resp := do("POST", "/_mgmt/kms/keys", `{"id": "app", "description": "application data"}`); w.Code != http.StatusCreated || resp["arn"] != encryption.KeyARN("app") {
+		t.Errorf("POST = %d %v, want created", w.Code, resp)
+	}
+	if w, _ := do("POST", "/_mgmt/kms/keys", `{"id": "app"}`); w.Code != http.StatusConflict {
+		t.Errorf("duplicate POST status = %d, want %d", w.Code, http.StatusConflict)
+	}
+	if w, _ := do("POST", "/_mgmt/kms/keys", `{"id": "bad id"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("POST with bad id status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w, resp := do("GET", "/_mgmt/kms/keys", ""); w.Code != http.StatusOK || resp["count"] != float64(1) {
+		t.Errorf("GET = %d %v, want one key", w.Code, resp)
+	}
+	if w, _ := do("GET", "/_mgmt/kms/keys/missing", ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET missing key status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	if w, resp := do("POST", "/_mgmt/kms/keys/app/rotate", ""); w.Code != http.StatusOK || resp["version"] != float64(2) {
+		t.Errorf("rotate = %d %v, want version 2", w.Code, resp)
+	}
+	if w, resp := do("POST", "/_mgmt/kms/keys/app/disable", ""); w.Code != http.StatusOK || resp["enabled"] != false {
+		t.Errorf("disable = %d %v, want disabled", w.Code, resp)
+	}
+	if w, resp := do("POST", "/_mgmt/kms/keys/app/enable", ""); w.Code != http.StatusOK || resp["enabled"] != true {
+		t.Errorf("enable = %d %v, want enabled", w.Code, resp)
+	}
+	if w, _ := do("POST", "/_mgmt/kms/keys/app/destroy", ""); w.Code != http.StatusNotFound {
+		t.Errorf("unknown action status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w, resp := do("GET", "/_mgmt/kms/rewrap", ""); w.Code != http.StatusOK || resp["enabled"] != true {
+		t.Errorf("GET rewrap = %d %v, want enabled", w.Code, resp)
+	}
+}
+
+func TestRouter_AdminEndpointsRequireCredentials(t *testing.T) {
+	router, cleanup := createTestRouter(t)
+	defer cleanup()
+	router.SetAuth(auth.New(config.AuthConfig{AccessKey: "root", SecretKey: "root-secret"}))
+
+	do := func(method, target, body, accessKey, secretKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		if accessKey != "" {
+			date := time.Now().UTC().Format(http.TimeFormat)
+			req.Header.Set("Date", date)
+			mac := hmac.New(sha1.New, []byte(secretKey))
+			io.WriteString(mac, method+"\n\n\n"+date+"\n"+req.URL.Path)
+			req.Header.Set("Authorization", "AWS "+accessKey+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, target := range []string{"/_mgmt/iam/users", "/_mgmt/iam/policies", "/_mgmt/kms/keys", "/_mgmt/storage/compaction"} {
+		if w := do("GET", target, "", "", ""); w.Code != http.StatusForbidden {
+			t.Errorf("anonymous GET %s status = %d, want %d", target, w.Code, http.StatusForbidden)
+		}
+		if w := do("GET", target, "", "root", "wrong-secret"); w.Code != http.StatusForbidden {
+			t.Errorf("badly signed GET %s status = %d, want %d", target, w.Code, http.StatusForbidden)
+		}
+	}
+	if w := do("POST", "/_mgmt/iam/users", `{"username": "mallory"}`, "", ""); w.Code != http.StatusForbidden {
+		t.Errorf("anonymous POST /iam/users status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := do("POST", "/_mgmt/iam/users", `{"username": "alice"}`, "root", "root-secret"); w.Code != http.StatusCreated {
+		t.Errorf("signed POST /iam/users status = %d, want %d", w.Code, http.StatusCreated)
+	}
+	if w := do("POST", "/_mgmt/storage/compaction", "", "", ""); w.Code != http.StatusForbidden {
+		t.Errorf("anonymous POST /storage/compaction status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	// Signed requests reach the handler, which has no compactor to run
+	if w := do("POST", "/_mgmt/storage/compaction", "", "root", "root-secret"); w.Code != http.StatusBadRequest {
+		t.Errorf("signed POST /storage/compaction status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := do("GET", "/_mgmt/", "", "", ""); w.Code != http.StatusOK {
+		t.Errorf("anonymous GET /_mgmt/ status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
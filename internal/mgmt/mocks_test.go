@@ -3,8 +3,11 @@ package mgmt
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/openendpoint/openendpoint/internal/metadata"
@@ -15,12 +18,14 @@ type MockStorageBackend struct {
 	mu      sync.RWMutex
 	objects map[string][]byte
 	buckets map[string]bool
+	parts   map[string][]byte
 }
 
 func NewMockStorageBackend() *MockStorageBackend {
 	return &MockStorageBackend{
 		objects: make(map[string][]byte),
 		buckets: make(map[string]bool),
+		parts:   make(map[string][]byte),
 	}
 }
 
@@ -98,6 +103,38 @@ func (m *MockStorageBackend) ListBuckets(ctx context.Context) ([]storage.BucketI
 	return buckets, nil
 }
 
+func (m *MockStorageBackend) PutPart(ctx context.Context, uploadID string, partNumber int, data io.Reader, size int64) error {
+	b, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.parts[fmt.Sprintf("%s/%d", uploadID, partNumber)] = b
+	return nil
+}
+
+func (m *MockStorageBackend) GetPart(ctx context.Context, uploadID string, partNumber int) (io.ReadCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	data, ok := m.parts[fmt.Sprintf("%s/%d", uploadID, partNumber)]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *MockStorageBackend) DeleteUpload(ctx context.Context, uploadID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k := range m.parts {
+		if strings.HasPrefix(k, uploadID+"/") {
+			delete(m.parts, k)
+		}
+	}
+	return nil
+}
+
 func (m *MockStorageBackend) ComputeStorageMetrics() (int64, int64, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -179,16 +216,31 @@ func (m *MockMetadataStore) DeleteObject(ctx context.Context, bucket, key string
 	return nil
 }
 
-func (m *MockMetadataStore) ListObjects(ctx context.Context, bucket, prefix string, opts metadata.ListOptions) ([]metadata.ObjectMetadata, error) {
+func (m *MockMetadataStore) ListObjects(ctx context.Context, bucket string, opts metadata.ListOptions) (*metadata.ObjectListing, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	var objects []metadata.ObjectMetadata
-	for k, v := range m.objects {
-		if len(k) > len(bucket)+1 && k[:len(bucket)+1] == bucket+"/" {
-			objects = append(objects, *v)
+	var keys []string
+	for k := range m.objects {
+		if strings.HasPrefix(k, bucket+"/") {
+			keys = append(keys, k[len(bucket)+1:])
 		}
 	}
-	return objects, nil
+	sort.Strings(keys)
+	pager := metadata.NewObjectPager(opts)
+	for _, key := range keys {
+		if !pager.Add(key, m.objects[bucket+"/"+key]) {
+			break
+		}
+	}
+	return pager.Listing(), nil
+}
+
+func (m *MockMetadataStore) ListObjectVersions(ctx context.Context, bucket string, opts metadata.ListOptions) (*metadata.VersionListing, error) {
+	return &metadata.VersionListing{}, nil
+}
+
+func (m *MockMetadataStore) UpdateObjectVersion(ctx context.Context, bucket, key string, meta *metadata.ObjectMetadata) error {
+	return nil
 }
 
 func (m *MockMetadataStore) CreateMultipartUpload(ctx context.Context, bucket, key, uploadID string, meta *metadata.ObjectMetadata) error {
@@ -3,19 +3,24 @@ package mgmt
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/openendpoint/openendpoint/internal/auth"
 	"github.com/openendpoint/openendpoint/internal/bucketconfig"
 	"github.com/openendpoint/openendpoint/internal/cluster"
+	"github.com/openendpoint/openendpoint/internal/encryption"
 	"github.com/openendpoint/openendpoint/internal/engine"
 	"github.com/openendpoint/openendpoint/internal/iam"
 	"github.com/openendpoint/openendpoint/internal/lifecycle"
 	"github.com/openendpoint/openendpoint/internal/replication"
 	"github.com/openendpoint/openendpoint/internal/settings"
+	"github.com/openendpoint/openendpoint/internal/storage/packed"
 	"github.com/openendpoint/openendpoint/internal/telemetry"
 	"go.uber.org/zap"
 )
@@ -30,6 +35,10 @@ type Router struct {
 	replicationSvc *replication.Replication
 	bucketConfig   *bucketconfig.Config
 	settingsMgr    *settings.Manager
+	compactor      *packed.Compactor
+	keyProvider    encryption.KeyProvider
+	rewrapper      *engine.Rewrapper
+	auth           *auth.Auth
 }
 
 // NewRouter creates a new management API router
@@ -50,6 +59,32 @@ func NewRouter(engine *engine.ObjectService, logger *zap.SugaredLogger, config i
 	}
 }
 
+// SetCompactor enables the compaction endpoints for a packed storage
+// backend
+func (r *Router) SetCompactor(c *packed.Compactor) {
+	r.compactor = c
+}
+
+// SetIAMManager replaces the router's own IAM manager with one shared with
+// the S3 API, so users and policies managed here take effect there
+func (r *Router) SetIAMManager(m *iam.Manager) {
+	r.iamManager = m
+}
+
+// SetAuth requires requests to the IAM and KMS endpoints, which grant S3
+// credentials and control encryption keys, to be signed with a
+// configured credential of a rather than an IAM user's access key
+func (r *Router) SetAuth(a *auth.Auth) {
+	r.auth = a
+}
+
+// SetKMS enables the KMS key endpoints. Rotating a key triggers rewrapper,
+// which may be nil, to re-wrap the data keys it protects.
+func (r *Router) SetKMS(provider encryption.KeyProvider, rewrapper *engine.Rewrapper) {
+	r.keyProvider = provider
+	r.rewrapper = rewrapper
+}
+
 // ServeHTTP handles management API requests
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	// Strip /_mgmt prefix
@@ -67,10 +102,32 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 	r.logger.Debugw("mgmt request after strip", "path", path)
 
+	if r.auth != nil && adminPath(path) {
+		if err := r.auth.AuthorizeAdmin(req); err != nil {
+			r.logger.Warnw("mgmt request not authorized", "path", path, "error", err)
+			r.writeError(w, http.StatusForbidden, "access denied")
+			return
+		}
+	}
+
 	// Route request
 	r.route(w, req, path)
 }
 
+// adminPrefixes start the IAM, KMS and storage maintenance endpoints
+var adminPrefixes = []string{"/iam", "/kms", "/storage"}
+
+// adminPath reports whether path is an IAM, KMS or storage maintenance
+// endpoint, which only administrators may call
+func adminPath(path string) bool {
+	for _, prefix := range adminPrefixes {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *Router) route(w http.ResponseWriter, req *http.Request, path string) {
 	switch {
 	// Replication route - use strings.HasPrefix
@@ -101,6 +158,22 @@ func (r *Router) route(w http.ResponseWriter, req *http.Request, path string) {
 		r.handleSettings(w, req)
 	case req.Method == http.MethodGet && path == "/cluster":
 		r.handleCluster(w, req)
+	case req.Method == http.MethodGet && path == "/storage/compaction":
+		r.handleGetCompaction(w, req)
+	case req.Method == http.MethodPost && path == "/storage/compaction":
+		r.handleTriggerCompaction(w, req)
+	case req.Method == http.MethodGet && path == "/kms/keys":
+		r.handleListKMSKeys(w, req)
+	case req.Method == http.MethodPost && path == "/kms/keys":
+		r.handleCreateKMSKey(w, req)
+	case req.Method == http.MethodGet && path == "/kms/rewrap":
+		r.handleGetRewrap(w, req)
+	case req.Method == http.MethodGet && strings.HasPrefix(path, "/kms/keys/"):
+		r.handleGetKMSKey(w, req, strings.TrimPrefix(path, "/kms/keys/"))
+	case req.Method == http.MethodPost && strings.HasPrefix(path, "/kms/keys/"):
+		// /kms/keys/{id}/{enable|disable|rotate}
+		id, action, _ := strings.Cut(strings.TrimPrefix(path, "/kms/keys/"), "/")
+		r.handleKMSKeyAction(w, req, id, action)
 	// NOTE: Specific routes must come BEFORE general /buckets/{bucket} routes
 	case req.Method == http.MethodGet && len(path) > 9 && path[:9] == "/buckets/" && strings.Contains(path[9:], "/objects"):
 		// /buckets/{bucket}/objects or /buckets/{bucket}/objects/{prefix}
@@ -364,7 +437,7 @@ func (r *Router) handleDeleteObject(w http.ResponseWriter, req *http.Request, bu
 		return
 	}
 
-	if err := r.engine.DeleteObject(ctx, bucket, key, engine.DeleteObjectOptions{}); err != nil {
+	if _, err := r.engine.DeleteObject(ctx, bucket, key, engine.DeleteObjectOptions{}); err != nil {
 		r.writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -554,6 +627,176 @@ func (r *Router) handleCluster(w http.ResponseWriter, req *http.Request) {
 	})
 }
 
+// handleGetCompaction returns the packed volume compaction status
+func (r *Router) handleGetCompaction(w http.ResponseWriter, req *http.Request) {
+	if r.compactor == nil {
+		r.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"enabled": false,
+		})
+		return
+	}
+
+	r.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"enabled": true,
+		"status":  r.compactor.Status(),
+	})
+}
+
+// handleTriggerCompaction queues a compaction pass. The optional threshold
+// query parameter overrides the configured garbage ratio for this pass.
+func (r *Router) handleTriggerCompaction(w http.ResponseWriter, req *http.Request) {
+	if r.compactor == nil {
+		r.writeError(w, http.StatusBadRequest, "Compaction requires the packed storage backend")
+		return
+	}
+
+	var threshold float64
+	if v := req.URL.Query().Get("threshold"); v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil || t <= 0 || t > 1 {
+			r.writeError(w, http.StatusBadRequest, "threshold must be a number in (0, 1]")
+			return
+		}
+		threshold = t
+	}
+
+	if !r.compactor.Trigger(threshold) {
+		r.writeError(w, http.StatusConflict, "A compaction pass is already queued")
+		return
+	}
+
+	r.logger.Infow("Compaction triggered", "threshold", threshold)
+	r.writeJSON(w, http.StatusAccepted, map[string]string{
+		"status": "queued",
+	})
+}
+
+// handleListKMSKeys lists the KMS keys
+func (r *Router) handleListKMSKeys(w http.ResponseWriter, req *http.Request) {
+	if r.keyProvider == nil {
+		r.writeError(w, http.StatusBadRequest, "KMS is not configured")
+		return
+	}
+
+	keys, err := r.keyProvider.ListKeys(req.Context())
+	if err != nil {
+		r.writeKMSError(w, err)
+		return
+	}
+	r.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys":  keys,
+		"count": len(keys),
+	})
+}
+
+// handleCreateKMSKey creates a KMS key
+func (r *Router) handleCreateKMSKey(w http.ResponseWriter, req *http.Request) {
+	if r.keyProvider == nil {
+		r.writeError(w, http.StatusBadRequest, "KMS is not configured")
+		return
+	}
+
+	var body struct {
+		ID          string `json:"id"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		r.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	info, err := r.keyProvider.CreateKey(req.Context(), body.ID, body.Description)
+	if err != nil {
+		r.writeKMSError(w, err)
+		return
+	}
+	r.logger.Infow("KMS key created", "id", info.ID)
+	r.writeJSON(w, http.StatusCreated, info)
+}
+
+// handleGetKMSKey describes a KMS key
+func (r *Router) handleGetKMSKey(w http.ResponseWriter, req *http.Request, id string) {
+	if r.keyProvider == nil {
+		r.writeError(w, http.StatusBadRequest, "KMS is not configured")
+		return
+	}
+
+	info, err := r.keyProvider.DescribeKey(req.Context(), id)
+	if err != nil {
+		r.writeKMSError(w, err)
+		return
+	}
+	r.writeJSON(w, http.StatusOK, info)
+}
+
+// handleKMSKeyAction enables, disables or rotates a KMS key. Rotation
+// queues a re-wrap of the data keys sealed under the key.
+func (r *Router) handleKMSKeyAction(w http.ResponseWriter, req *http.Request, id, action string) {
+	if r.keyProvider == nil {
+		r.writeError(w, http.StatusBadRequest, "KMS is not configured")
+		return
+	}
+
+	ctx := req.Context()
+	var err error
+	switch action {
+	case "enable":
+		err = r.keyProvider.EnableKey(ctx, id)
+	case "disable":
+		err = r.keyProvider.DisableKey(ctx, id)
+	case "rotate":
+		_, err = r.keyProvider.RotateKey(ctx, id)
+		if err == nil && r.rewrapper != nil {
+			r.rewrapper.Trigger(id)
+		}
+	default:
+		r.writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	if err != nil {
+		r.writeKMSError(w, err)
+		return
+	}
+
+	info, err := r.keyProvider.DescribeKey(ctx, id)
+	if err != nil {
+		r.writeKMSError(w, err)
+		return
+	}
+	r.logger.Infow("KMS key updated", "id", id, "action", action)
+	r.writeJSON(w, http.StatusOK, info)
+}
+
+// handleGetRewrap returns the background re-wrap status
+func (r *Router) handleGetRewrap(w http.ResponseWriter, req *http.Request) {
+	if r.rewrapper == nil {
+		r.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"enabled": false,
+		})
+		return
+	}
+
+	r.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"enabled": true,
+		"status":  r.rewrapper.Status(),
+	})
+}
+
+// writeKMSError writes the response for a key provider error
+func (r *Router) writeKMSError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, encryption.ErrKeyNotFound):
+		r.writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, encryption.ErrKeyExists):
+		r.writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, encryption.ErrInvalidKeyID):
+		r.writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		r.logger.Errorw("KMS request failed", "error", err)
+		r.writeError(w, http.StatusInternalServerError, "KMS request failed")
+	}
+}
+
 // writeJSON writes a JSON response
 func (r *Router) writeJSON(w http.ResponseWriter, status int, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
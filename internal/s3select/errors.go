@@ -0,0 +1,28 @@
+package s3select
+
+import "fmt"
+
+// Error is a failure of a query reported to the client under an S3 Select
+// error code, either as the status of the response or as an error event
+// once records have been sent
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string { return e.Code + ": " + e.Message }
+
+// errParse reports a syntax error at byte offset pos of the expression
+func errParse(pos int, format string, args ...interface{}) *Error {
+	return &Error{Code: "ParseUnexpectedToken", Message: fmt.Sprintf("at position %d: ", pos) + fmt.Sprintf(format, args...)}
+}
+
+// errEval reports an expression that cannot be evaluated for a record
+func errEval(format string, args ...interface{}) *Error {
+	return &Error{Code: "EvaluatorInvalidArguments", Message: fmt.Sprintf(format, args...)}
+}
+
+// errCast reports a value that cannot be converted to the requested type
+func errCast(v Value, typ string) *Error {
+	return &Error{Code: "CastFailed", Message: fmt.Sprintf("cannot cast %s to %s", v.String(), typ)}
+}
@@ -0,0 +1,645 @@
+package s3select
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// expr is a node of a parsed expression, evaluated against one record
+type expr interface {
+	eval(rec Value) (Value, error)
+}
+
+// literalExpr is a constant
+type literalExpr struct {
+	v Value
+}
+
+// pathExpr references a field of the record, or the record itself when it
+// has no steps
+type pathExpr struct {
+	steps []pathStep
+}
+
+// pathStep is a .name or [n] step of a path, or [*] in FROM
+type pathStep struct {
+	name     string
+	quoted   bool // matched case-sensitively
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+type unaryExpr struct {
+	op string // - or NOT
+	x  expr
+}
+
+// binaryExpr is an arithmetic, comparison, || or logical operator
+type binaryExpr struct {
+	op   string
+	l, r expr
+}
+
+type likeExpr struct {
+	x, pattern, escape expr
+	not                bool
+
+	// The last compiled pattern, reused while the pattern is unchanged
+	source string
+	re     *regexp.Regexp
+}
+
+type betweenExpr struct {
+	x, lo, hi expr
+	not       bool
+}
+
+type inExpr struct {
+	x    expr
+	list []expr
+	not  bool
+}
+
+// isExpr is IS [NOT] NULL or, with missing, IS [NOT] MISSING
+type isExpr struct {
+	x       expr
+	missing bool
+	not     bool
+}
+
+type castExpr struct {
+	x   expr
+	typ kind
+}
+
+type callExpr struct {
+	name string
+	fn   function
+	args []expr
+}
+
+// aggregateExpr accumulates its function over the matching records and
+// evaluates to the result
+type aggregateExpr struct {
+	fn string
+	x  expr // nil for COUNT(*)
+
+	count int64
+	sum   Value // INT until a FLOAT is added
+	best  Value // MIN or MAX so far
+}
+
+// castTypes maps the type names of CAST to kinds
+var castTypes = map[string]kind{
+	"BOOL": kindBool, "BOOLEAN": kindBool,
+	"INT": kindInt, "INTEGER": kindInt,
+	"FLOAT": kindFloat, "DECIMAL": kindFloat, "NUMERIC": kindFloat, "REAL": kindFloat, "DOUBLE": kindFloat,
+	"STRING": kindString, "VARCHAR": kindString, "CHAR": kindString,
+	"TIMESTAMP": kindTimestamp,
+}
+
+// walkExpr calls fn for e and, while fn returns true, its subexpressions
+func walkExpr(e expr, fn func(expr) bool) {
+	if e == nil || !fn(e) {
+		return
+	}
+	var children []expr
+	switch e := e.(type) {
+	case *unaryExpr:
+		children = []expr{e.x}
+	case *binaryExpr:
+		children = []expr{e.l, e.r}
+	case *likeExpr:
+		children = []expr{e.x, e.pattern, e.escape}
+	case *betweenExpr:
+		children = []expr{e.x, e.lo, e.hi}
+	case *inExpr:
+		children = append([]expr{e.x}, e.list...)
+	case *isExpr:
+		children = []expr{e.x}
+	case *castExpr:
+		children = []expr{e.x}
+	case *callExpr:
+		children = e.args
+	case *aggregateExpr:
+		children = []expr{e.x}
+	}
+	for _, c := range children {
+		walkExpr(c, fn)
+	}
+}
+
+func (e *literalExpr) eval(Value) (Value, error) { return e.v, nil }
+
+func (e *pathExpr) eval(rec Value) (Value, error) {
+	v := rec
+	for _, step := range e.steps {
+		switch {
+		case step.isIndex:
+			if v.kind != kindArray || step.index >= len(v.arr) {
+				return Value{}, nil
+			}
+			v = v.arr[step.index]
+		default:
+			v = v.field(step.name, step.quoted)
+		}
+	}
+	return v, nil
+}
+
+func (e *unaryExpr) eval(rec Value) (Value, error) {
+	x, err := e.x.eval(rec)
+	if err != nil || x.isNull() {
+		return nullValue(), err
+	}
+	if e.op == "NOT" {
+		if x.kind != kindBool {
+			return Value{}, errEval("NOT requires a boolean, found %s", x.String())
+		}
+		return boolValue(!x.b), nil
+	}
+	n, ok := x.number()
+	if !ok {
+		return Value{}, errEval("cannot negate %q", x.String())
+	}
+	n.i, n.f = -n.i, -n.f
+	return n, nil
+}
+
+func (e *binaryExpr) eval(rec Value) (Value, error) {
+	l, err := e.l.eval(rec)
+	if err != nil {
+		return Value{}, err
+	}
+
+	// AND and OR use three-valued logic and short-circuit
+	if e.op == "AND" || e.op == "OR" {
+		lb, err := logical(e.op, l)
+		if err != nil {
+			return Value{}, err
+		}
+		if lb.kind == kindBool && lb.b == (e.op == "OR") {
+			return lb, nil
+		}
+		r, err := e.r.eval(rec)
+		if err != nil {
+			return Value{}, err
+		}
+		rb, err := logical(e.op, r)
+		if err != nil {
+			return Value{}, err
+		}
+		switch {
+		case rb.kind == kindBool && rb.b == (e.op == "OR"):
+			return rb, nil
+		case lb.isNull() || rb.isNull():
+			return nullValue(), nil
+		}
+		return rb, nil
+	}
+
+	r, err := e.r.eval(rec)
+	if err != nil {
+		return Value{}, err
+	}
+	switch e.op {
+	case "=", "!=", "<", "<=", ">", ">=":
+		return compareOp(e.op, l, r), nil
+	case "||":
+		if l.isNull() || r.isNull() {
+			return nullValue(), nil
+		}
+		return stringValue(l.String() + r.String()), nil
+	}
+	return arithmetic(e.op, l, r)
+}
+
+// logical checks an operand of AND or OR is a boolean or null
+func logical(op string, v Value) (Value, error) {
+	if v.isNull() {
+		return nullValue(), nil
+	}
+	if v.kind != kindBool {
+		return Value{}, errEval("%s requires booleans, found %s", op, v.String())
+	}
+	return v, nil
+}
+
+// compareOp applies a comparison operator, giving null when either side is
+// null or missing; values of incomparable types are never equal
+func compareOp(op string, l, r Value) Value {
+	if l.isNull() || r.isNull() {
+		return nullValue()
+	}
+	c, ok := compare(l, r)
+	if !ok {
+		switch op {
+		case "=":
+			return boolValue(false)
+		case "!=":
+			return boolValue(true)
+		}
+		return nullValue()
+	}
+	switch op {
+	case "=":
+		return boolValue(c == 0)
+	case "!=":
+		return boolValue(c != 0)
+	case "<":
+		return boolValue(c < 0)
+	case "<=":
+		return boolValue(c <= 0)
+	case ">":
+		return boolValue(c > 0)
+	}
+	return boolValue(c >= 0)
+}
+
+// arithmetic applies + - * / or %, in integers when both sides are
+func arithmetic(op string, l, r Value) (Value, error) {
+	if l.isNull() || r.isNull() {
+		return nullValue(), nil
+	}
+	a, okA := l.number()
+	b, okB := r.number()
+	if !okA || !okB {
+		return Value{}, errEval("%s requires numbers, found %s and %s", op, l.String(), r.String())
+	}
+
+	if a.kind == kindInt && b.kind == kindInt {
+		switch op {
+		case "+":
+			return intValue(a.i + b.i), nil
+		case "-":
+			return intValue(a.i - b.i), nil
+		case "*":
+			return intValue(a.i * b.i), nil
+		}
+		if b.i == 0 {
+			return Value{}, &Error{Code: "EvaluatorDivisionByZero", Message: "division by zero"}
+		}
+		if op == "/" {
+			return intValue(a.i / b.i), nil
+		}
+		return intValue(a.i % b.i), nil
+	}
+
+	x, y := a.float(), b.float()
+	switch op {
+	case "+":
+		return floatValue(x + y), nil
+	case "-":
+		return floatValue(x - y), nil
+	case "*":
+		return floatValue(x * y), nil
+	}
+	if y == 0 {
+		return Value{}, &Error{Code: "EvaluatorDivisionByZero", Message: "division by zero"}
+	}
+	if op == "/" {
+		return floatValue(x / y), nil
+	}
+	return floatValue(math.Mod(x, y)), nil
+}
+
+func (e *likeExpr) eval(rec Value) (Value, error) {
+	x, err := e.x.eval(rec)
+	if err != nil {
+		return Value{}, err
+	}
+	pattern, err := e.pattern.eval(rec)
+	if err != nil {
+		return Value{}, err
+	}
+	escape := Value{kind: kindString}
+	if e.escape != nil {
+		if escape, err = e.escape.eval(rec); err != nil {
+			return Value{}, err
+		}
+	}
+	if x.isNull() || pattern.isNull() || escape.isNull() {
+		return nullValue(), nil
+	}
+	if x.kind != kindString || pattern.kind != kindString || escape.kind != kindString {
+		return Value{}, errEval("LIKE requires strings")
+	}
+
+	if e.re == nil || e.source != pattern.s+"\x00"+escape.s {
+		re, err := likePattern(pattern.s, escape.s)
+		if err != nil {
+			return Value{}, err
+		}
+		e.re, e.source = re, pattern.s+"\x00"+escape.s
+	}
+	return boolValue(e.re.MatchString(x.s) != e.not), nil
+}
+
+// likePattern compiles a LIKE pattern, in which % matches any run of
+// characters and _ any one character unless preceded by the escape
+func likePattern(pattern, escape string) (*regexp.Regexp, error) {
+	esc := []rune(escape)
+	if len(esc) > 1 {
+		return nil, errEval("LIKE ESCAPE must be a single character, found %q", escape)
+	}
+	var b strings.Builder
+	b.WriteString(`(?s)^`)
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case len(esc) == 1 && c == esc[0]:
+			if i+1 == len(runes) {
+				return nil, errEval("LIKE pattern %q ends with its escape character", pattern)
+			}
+			i++
+			b.WriteString(regexp.QuoteMeta(string(runes[i])))
+		case c == '%':
+			b.WriteString(`.*`)
+		case c == '_':
+			b.WriteString(`.`)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString(`$`)
+	return regexp.MustCompile(b.String()), nil
+}
+
+func (e *betweenExpr) eval(rec Value) (Value, error) {
+	x, err := e.x.eval(rec)
+	if err != nil {
+		return Value{}, err
+	}
+	lo, err := e.lo.eval(rec)
+	if err != nil {
+		return Value{}, err
+	}
+	hi, err := e.hi.eval(rec)
+	if err != nil {
+		return Value{}, err
+	}
+	above, below := compareOp(">=", x, lo), compareOp("<=", x, hi)
+	if above.isNull() || below.isNull() {
+		return nullValue(), nil
+	}
+	return boolValue((above.b && below.b) != e.not), nil
+}
+
+func (e *inExpr) eval(rec Value) (Value, error) {
+	x, err := e.x.eval(rec)
+	if err != nil {
+		return Value{}, err
+	}
+	unknown := false
+	for _, item := range e.list {
+		v, err := item.eval(rec)
+		if err != nil {
+			return Value{}, err
+		}
+		eq := compareOp("=", x, v)
+		if eq.isNull() {
+			unknown = true
+		} else if eq.b {
+			return boolValue(!e.not), nil
+		}
+	}
+	if unknown {
+		return nullValue(), nil
+	}
+	return boolValue(e.not), nil
+}
+
+func (e *isExpr) eval(rec Value) (Value, error) {
+	x, err := e.x.eval(rec)
+	if err != nil {
+		return Value{}, err
+	}
+	is := x.isNull()
+	if e.missing {
+		is = x.kind == kindMissing
+	}
+	return boolValue(is != e.not), nil
+}
+
+func (e *castExpr) eval(rec Value) (Value, error) {
+	x, err := e.x.eval(rec)
+	if err != nil {
+		return Value{}, err
+	}
+	return cast(x, e.typ)
+}
+
+// cast converts v to typ; null and missing stay as they are
+func cast(v Value, typ kind) (Value, error) {
+	if v.isNull() || v.kind == typ {
+		return v, nil
+	}
+	switch typ {
+	case kindString:
+		if v.kind == kindArray || v.kind == kindObject {
+			break
+		}
+		return stringValue(v.String()), nil
+
+	case kindInt:
+		switch v.kind {
+		case kindFloat:
+			return intValue(int64(v.f)), nil
+		case kindBool:
+			return intValue(int64(boolInt(v.b))), nil
+		case kindString:
+			if n, ok := v.number(); ok {
+				return cast(n, kindInt)
+			}
+		}
+
+	case kindFloat:
+		switch v.kind {
+		case kindInt:
+			return floatValue(float64(v.i)), nil
+		case kindBool:
+			return floatValue(float64(boolInt(v.b))), nil
+		case kindString:
+			if n, ok := v.number(); ok {
+				return floatValue(n.float()), nil
+			}
+		}
+
+	case kindBool:
+		switch v.kind {
+		case kindInt, kindFloat:
+			return boolValue(v.float() != 0), nil
+		case kindString:
+			if b, err := strconv.ParseBool(strings.TrimSpace(v.s)); err == nil {
+				return boolValue(b), nil
+			}
+		}
+
+	case kindTimestamp:
+		if v.kind == kindString {
+			if t, ok := parseTimestamp(strings.TrimSpace(v.s)); ok {
+				return timeValue(t), nil
+			}
+		}
+	}
+	return Value{}, errCast(v, kindNames[typ])
+}
+
+// kindNames names the kinds in error messages
+var kindNames = map[kind]string{
+	kindBool: "BOOL", kindInt: "INT", kindFloat: "FLOAT", kindString: "STRING", kindTimestamp: "TIMESTAMP",
+}
+
+func (e *callExpr) eval(rec Value) (Value, error) {
+	args := make([]Value, len(e.args))
+	for i, a := range e.args {
+		v, err := a.eval(rec)
+		if err != nil {
+			return Value{}, err
+		}
+		if v.isNull() && e.fn.strict {
+			return nullValue(), nil
+		}
+		args[i] = v
+	}
+	return e.fn.call(args)
+}
+
+// accumulate adds the record to the aggregate
+func (e *aggregateExpr) accumulate(rec Value) error {
+	if e.x == nil {
+		e.count++
+		return nil
+	}
+	v, err := e.x.eval(rec)
+	if err != nil || v.isNull() {
+		return err
+	}
+
+	switch e.fn {
+	case "SUM", "AVG":
+		n, ok := v.number()
+		if !ok {
+			return errEval("%s requires numbers, found %q", e.fn, v.String())
+		}
+		if e.count == 0 {
+			e.sum = n
+		} else if e.sum.kind == kindInt && n.kind == kindInt {
+			e.sum.i += n.i
+		} else {
+			e.sum = floatValue(e.sum.float() + n.float())
+		}
+	case "MIN", "MAX":
+		if e.count > 0 {
+			c, ok := compare(v, e.best)
+			if !ok {
+				return errEval("%s cannot compare %q with %q", e.fn, v.String(), e.best.String())
+			}
+			if e.fn == "MIN" && c >= 0 || e.fn == "MAX" && c <= 0 {
+				break
+			}
+		}
+		// Compare CSV fields as numbers when they are
+		if n, ok := v.number(); ok && v.kind == kindString {
+			v = n
+		}
+		e.best = v
+	}
+	e.count++
+	return nil
+}
+
+// eval returns the aggregate of the records accumulated so far
+func (e *aggregateExpr) eval(Value) (Value, error) {
+	if e.fn == "COUNT" {
+		return intValue(e.count), nil
+	}
+	if e.count == 0 {
+		return nullValue(), nil
+	}
+	switch e.fn {
+	case "SUM":
+		return e.sum, nil
+	case "AVG":
+		return floatValue(e.sum.float() / float64(e.count)), nil
+	}
+	return e.best, nil
+}
+
+// sources returns the values a record yields through the FROM path, each
+// queried as a record of its own
+func (q *Query) sources(rec Value) []Value {
+	vals := []Value{rec}
+	for _, step := range q.from {
+		var next []Value
+		for _, v := range vals {
+			switch {
+			case step.wildcard:
+				if v.kind == kindArray {
+					next = append(next, v.arr...)
+				}
+			case step.isIndex:
+				if v.kind == kindArray && step.index < len(v.arr) {
+					next = append(next, v.arr[step.index])
+				}
+			default:
+				if f := v.field(step.name, step.quoted); f.kind != kindMissing {
+					next = append(next, f)
+				}
+			}
+		}
+		vals = next
+	}
+	return vals
+}
+
+// matches reports whether rec satisfies the WHERE clause; a condition that
+// is null or missing does not
+func (q *Query) matches(rec Value) (bool, error) {
+	if q.where == nil {
+		return true, nil
+	}
+	v, err := q.where.eval(rec)
+	if err != nil {
+		return false, err
+	}
+	if !v.isNull() && v.kind != kindBool {
+		return false, errEval("WHERE requires a boolean, found %s", v.String())
+	}
+	return v.kind == kindBool && v.b, nil
+}
+
+// project evaluates the select list for rec, giving an object keyed by the
+// item names, or rec itself for SELECT *
+func (q *Query) project(rec Value) (Value, error) {
+	if q.items == nil {
+		return rec, nil
+	}
+	keys := make([]string, len(q.items))
+	vals := make([]Value, len(q.items))
+	for i, item := range q.items {
+		v, err := item.expr.eval(rec)
+		if err != nil {
+			return Value{}, err
+		}
+		keys[i], vals[i] = item.name, v
+		if keys[i] == "" {
+			keys[i] = "_" + strconv.Itoa(i+1)
+		}
+	}
+	return objectValue(keys, vals), nil
+}
+
+// accumulate adds rec to every aggregate of the select list
+func (q *Query) accumulate(rec Value) error {
+	for _, agg := range q.aggregates {
+		if err := agg.accumulate(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
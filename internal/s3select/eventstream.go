@@ -0,0 +1,176 @@
+package s3select
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+)
+
+// EventStreamContentType is the Content-Type of a SelectObjectContent
+// response
+const EventStreamContentType = "application/vnd.amazon.eventstream"
+
+// stringHeaderType is the event stream header value type of strings, the
+// only type the events of S3 Select use
+const stringHeaderType = 7
+
+// ResultWriter receives the output of a query as it runs
+type ResultWriter interface {
+	// Records writes a chunk of serialized output records
+	Records(payload []byte) error
+	// Progress reports the bytes read so far
+	Progress(stats SelectStats) error
+}
+
+// EventStreamWriter writes the events of a SelectObjectContent response in
+// the binary application/vnd.amazon.eventstream framing. Each message is
+// flushed to the client as soon as it is written.
+type EventStreamWriter struct {
+	w       io.Writer
+	started bool
+}
+
+// NewEventStreamWriter returns an EventStreamWriter writing to w
+func NewEventStreamWriter(w io.Writer) *EventStreamWriter {
+	return &EventStreamWriter{w: w}
+}
+
+// Started reports whether any message was written, after which a failure
+// can only be reported by an error event
+func (e *EventStreamWriter) Started() bool {
+	return e.started
+}
+
+// Records writes a Records event carrying payload
+func (e *EventStreamWriter) Records(payload []byte) error {
+	return e.event("Records", "application/octet-stream", payload)
+}
+
+// Progress writes a Progress event
+func (e *EventStreamWriter) Progress(stats SelectStats) error {
+	return e.event("Progress", "text/xml", statsXML("Progress", stats))
+}
+
+// Stats writes the Stats event sent once a query completes
+func (e *EventStreamWriter) Stats(stats SelectStats) error {
+	return e.event("Stats", "text/xml", statsXML("Stats", stats))
+}
+
+// Continuation writes a Cont event, which keeps the connection alive while
+// a query scans without output
+func (e *EventStreamWriter) Continuation() error {
+	return e.event("Cont", "", nil)
+}
+
+// End writes the End event, the last message of a successful query
+func (e *EventStreamWriter) End() error {
+	return e.event("End", "", nil)
+}
+
+// Error writes an error message, which ends the response of a query that
+// failed after sending events
+func (e *EventStreamWriter) Error(code, message string) error {
+	return e.write([][2]string{
+		{":error-code", code},
+		{":error-message", message},
+		{":message-type", "error"},
+	}, nil)
+}
+
+// event writes an event message; contentType is left out when empty
+func (e *EventStreamWriter) event(eventType, contentType string, payload []byte) error {
+	headers := [][2]string{{":event-type", eventType}}
+	if contentType != "" {
+		headers = append(headers, [2]string{":content-type", contentType})
+	}
+	return e.write(append(headers, [2]string{":message-type", "event"}), payload)
+}
+
+// write frames and sends one message: its total and headers lengths and
+// their CRC, the headers, the payload, then the CRC of all of it
+func (e *EventStreamWriter) write(headers [][2]string, payload []byte) error {
+	var h []byte
+	for _, header := range headers {
+		h = append(h, byte(len(header[0])))
+		h = append(h, header[0]...)
+		h = append(h, stringHeaderType)
+		h = binary.BigEndian.AppendUint16(h, uint16(len(header[1])))
+		h = append(h, header[1]...)
+	}
+
+	total := 12 + len(h) + len(payload) + 4
+	msg := make([]byte, 0, total)
+	msg = binary.BigEndian.AppendUint32(msg, uint32(total))
+	msg = binary.BigEndian.AppendUint32(msg, uint32(len(h)))
+	msg = binary.BigEndian.AppendUint32(msg, crc32.ChecksumIEEE(msg))
+	msg = append(msg, h...)
+	msg = append(msg, payload...)
+	msg = binary.BigEndian.AppendUint32(msg, crc32.ChecksumIEEE(msg))
+
+	e.started = true
+	if _, err := e.w.Write(msg); err != nil {
+		return err
+	}
+	if f, ok := e.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// statsXML encodes the payload of a Stats or Progress event
+func statsXML(name string, stats SelectStats) []byte {
+	return []byte(fmt.Sprintf("<%s><BytesScanned>%d</BytesScanned><BytesProcessed>%d</BytesProcessed><BytesReturned>%d</BytesReturned></%s>",
+		name, stats.BytesScanned, stats.BytesProcessed, stats.BytesReturned, name))
+}
+
+// Message is a decoded event stream message
+type Message struct {
+	Headers map[string]string
+	Payload []byte
+}
+
+// ReadMessage reads and verifies the next message of an event stream,
+// returning io.EOF at its end
+func ReadMessage(r io.Reader) (*Message, error) {
+	prelude := make([]byte, 12)
+	if _, err := io.ReadFull(r, prelude); err != nil {
+		return nil, err
+	}
+	total := binary.BigEndian.Uint32(prelude[0:4])
+	headersLen := binary.BigEndian.Uint32(prelude[4:8])
+	if crc32.ChecksumIEEE(prelude[:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return nil, errors.New("event stream prelude CRC mismatch")
+	}
+	if total < 16 || headersLen > total-16 {
+		return nil, fmt.Errorf("invalid event stream message length %d", total)
+	}
+
+	msg := make([]byte, total)
+	copy(msg, prelude)
+	if _, err := io.ReadFull(r, msg[12:]); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if crc32.ChecksumIEEE(msg[:total-4]) != binary.BigEndian.Uint32(msg[total-4:]) {
+		return nil, errors.New("event stream message CRC mismatch")
+	}
+
+	m := &Message{Headers: make(map[string]string), Payload: msg[12+headersLen : total-4]}
+	h := msg[12 : 12+headersLen]
+	for len(h) > 0 {
+		n := int(h[0])
+		if len(h) < 1+n+3 || h[1+n] != stringHeaderType {
+			return nil, errors.New("invalid event stream header")
+		}
+		name := string(h[1 : 1+n])
+		size := int(binary.BigEndian.Uint16(h[2+n:]))
+		if len(h) < 4+n+size {
+			return nil, errors.New("invalid event stream header")
+		}
+		m.Headers[name] = string(h[4+n : 4+n+size])
+		h = h[4+n+size:]
+	}
+	return m, nil
+}
@@ -0,0 +1,80 @@
+package s3select
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEventStreamWriter(t *testing.T) {
+	w := httptest.NewRecorder()
+	es := NewEventStreamWriter(w)
+	if es.Started() {
+		t.Fatal("Started() before any event")
+	}
+
+	stats := SelectStats{BytesScanned: 100, BytesProcessed: 100, BytesReturned: 8}
+	es.Records([]byte("a,b\nc,d\n"))
+	es.Progress(stats)
+	es.Continuation()
+	es.Stats(stats)
+	es.End()
+	es.Error("CastFailed", "cannot cast")
+	if !es.Started() || !w.Flushed {
+		t.Errorf("Started() = %v, Flushed = %v, want both true", es.Started(), w.Flushed)
+	}
+
+	want := []struct {
+		headers map[string]string
+		payload string
+	}{
+		{map[string]string{":event-type": "Records", ":content-type": "application/octet-stream", ":message-type": "event"}, "a,b\nc,d\n"},
+		{map[string]string{":event-type": "Progress", ":content-type": "text/xml", ":message-type": "event"},
+			"<Progress><BytesScanned>100</BytesScanned><BytesProcessed>100</BytesProcessed><BytesReturned>8</BytesReturned></Progress>"},
+		{map[string]string{":event-type": "Cont", ":message-type": "event"}, ""},
+		{map[string]string{":event-type": "Stats", ":content-type": "text/xml", ":message-type": "event"},
+			"<Stats><BytesScanned>100</BytesScanned><BytesProcessed>100</BytesProcessed><BytesReturned>8</BytesReturned></Stats>"},
+		{map[string]string{":event-type": "End", ":message-type": "event"}, ""},
+		{map[string]string{":error-code": "CastFailed", ":error-message": "cannot cast", ":message-type": "error"}, ""},
+	}
+
+	r := bytes.NewReader(w.Body.Bytes())
+	for i, wantMsg := range want {
+		msg, err := ReadMessage(r)
+		if err != nil {
+			t.Fatalf("ReadMessage() #%d error = %v", i, err)
+		}
+		if len(msg.Headers) != len(wantMsg.headers) {
+			t.Errorf("message #%d headers = %v, want %v", i, msg.Headers, wantMsg.headers)
+		}
+		for k, v := range wantMsg.headers {
+			if msg.Headers[k] != v {
+				t.Errorf("message #%d header %s = %q, want %q", i, k, msg.Headers[k], v)
+			}
+		}
+		if string(msg.Payload) != wantMsg.payload {
+			t.Errorf("message #%d payload = %q, want %q", i, msg.Payload, wantMsg.payload)
+		}
+	}
+	if _, err := ReadMessage(r); err != io.EOF {
+		t.Errorf("ReadMessage() at end error = %v, want EOF", err)
+	}
+}
+
+func TestReadMessageCorrupt(t *testing.T) {
+	var buf bytes.Buffer
+	NewEventStreamWriter(&buf).Records([]byte("payload"))
+	msg := buf.Bytes()
+
+	for _, i := range []int{2, 9, 20, len(msg) - 6, len(msg) - 1} {
+		corrupt := append([]byte(nil), msg...)
+		corrupt[i] ^= 0xff
+		if _, err := ReadMessage(bytes.NewReader(corrupt)); err == nil {
+			t.Errorf("ReadMessage() with byte %d corrupted succeeded", i)
+		}
+	}
+	if _, err := ReadMessage(bytes.NewReader(msg[:len(msg)-3])); err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadMessage() of truncated message error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
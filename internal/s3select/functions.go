@@ -0,0 +1,321 @@
+package s3select
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// function is a scalar SQL function
+type function struct {
+	minArgs, maxArgs int  // maxArgs is -1 when variadic
+	strict           bool // a null argument makes the result null
+	call             func(args []Value) (Value, error)
+}
+
+// functions are the scalar functions by upper-case name. EXTRACT, TRIM,
+// DATE_ADD and DATE_DIFF receive their keyword arguments as strings.
+var functions = map[string]function{
+	"LOWER":            {1, 1, true, stringFunc(strings.ToLower)},
+	"UPPER":            {1, 1, true, stringFunc(strings.ToUpper)},
+	"CHAR_LENGTH":      {1, 1, true, charLength},
+	"CHARACTER_LENGTH": {1, 1, true, charLength},
+	"TRIM":             {3, 3, true, trim},
+	"SUBSTRING":        {2, 3, true, substring},
+	"COALESCE":         {1, -1, false, coalesce},
+	"NULLIF":           {2, 2, false, nullIf},
+	"UTCNOW":           {0, 0, true, utcNow},
+	"TO_TIMESTAMP":     {1, 1, true, toTimestamp},
+	"TO_STRING":        {2, 2, true, toString},
+	"EXTRACT":          {2, 2, true, extract},
+	"DATE_ADD":         {3, 3, true, dateAdd},
+	"DATE_DIFF":        {3, 3, true, dateDiff},
+}
+
+// stringArg returns args[i] as a string
+func stringArg(args []Value, i int, fn string) (string, error) {
+	if args[i].kind != kindString {
+		return "", errEval("%s requires a string, found %s", fn, args[i].String())
+	}
+	return args[i].s, nil
+}
+
+// intArg returns args[i] as an integer, parsing strings
+func intArg(args []Value, i int, fn string) (int64, error) {
+	n, ok := args[i].number()
+	if !ok || n.kind != kindInt {
+		return 0, errEval("%s requires an integer, found %s", fn, args[i].String())
+	}
+	return n.i, nil
+}
+
+// timestampArg returns args[i] as a timestamp, parsing strings
+func timestampArg(args []Value, i int, fn string) (time.Time, error) {
+	switch args[i].kind {
+	case kindTimestamp:
+		return args[i].t, nil
+	case kindString:
+		if t, ok := parseTimestamp(args[i].s); ok {
+			return t, nil
+		}
+	}
+	return time.Time{}, errEval("%s requires a timestamp, found %s", fn, args[i].String())
+}
+
+func stringFunc(fn func(string) string) func([]Value) (Value, error) {
+	return func(args []Value) (Value, error) {
+		s, err := stringArg(args, 0, "string function")
+		return stringValue(fn(s)), err
+	}
+}
+
+func charLength(args []Value) (Value, error) {
+	s, err := stringArg(args, 0, "CHAR_LENGTH")
+	return intValue(int64(utf8.RuneCountInString(s))), err
+}
+
+// trim implements TRIM(mode, chars, s)
+func trim(args []Value) (Value, error) {
+	chars, err := stringArg(args, 1, "TRIM")
+	if err != nil {
+		return Value{}, err
+	}
+	s, err := stringArg(args, 2, "TRIM")
+	if err != nil {
+		return Value{}, err
+	}
+	switch args[0].s {
+	case "LEADING":
+		return stringValue(strings.TrimLeft(s, chars)), nil
+	case "TRAILING":
+		return stringValue(strings.TrimRight(s, chars)), nil
+	}
+	return stringValue(strings.Trim(s, chars)), nil
+}
+
+// substring implements SUBSTRING(s, start[, length]), counting characters
+// from 1; the part of the range before the first character is dropped
+func substring(args []Value) (Value, error) {
+	s, err := stringArg(args, 0, "SUBSTRING")
+	if err != nil {
+		return Value{}, err
+	}
+	start, err := intArg(args, 1, "SUBSTRING")
+	if err != nil {
+		return Value{}, err
+	}
+	runes := []rune(s)
+	end := int64(len(runes)) + 1
+	if len(args) == 3 {
+		length, err := intArg(args, 2, "SUBSTRING")
+		if err != nil {
+			return Value{}, err
+		}
+		if length < 0 {
+			return Value{}, errEval("SUBSTRING length must not be negative")
+		}
+		end = min(end, start+length)
+	}
+	start = max(start, 1)
+	if end <= start {
+		return stringValue(""), nil
+	}
+	return stringValue(string(runes[start-1 : end-1])), nil
+}
+
+func coalesce(args []Value) (Value, error) {
+	for _, v := range args {
+		if !v.isNull() {
+			return v, nil
+		}
+	}
+	return nullValue(), nil
+}
+
+func nullIf(args []Value) (Value, error) {
+	if eq := compareOp("=", args[0], args[1]); eq.kind == kindBool && eq.b {
+		return nullValue(), nil
+	}
+	return args[0], nil
+}
+
+func utcNow([]Value) (Value, error) {
+	return timeValue(time.Now().UTC()), nil
+}
+
+func toTimestamp(args []Value) (Value, error) {
+	s, err := stringArg(args, 0, "TO_TIMESTAMP")
+	if err != nil {
+		return Value{}, err
+	}
+	t, ok := parseTimestamp(strings.TrimSpace(s))
+	if !ok {
+		return Value{}, errCast(args[0], "TIMESTAMP")
+	}
+	return timeValue(t), nil
+}
+
+// toString implements TO_STRING(timestamp, pattern)
+func toString(args []Value) (Value, error) {
+	t, err := timestampArg(args, 0, "TO_STRING")
+	if err != nil {
+		return Value{}, err
+	}
+	pattern, err := stringArg(args, 1, "TO_STRING")
+	if err != nil {
+		return Value{}, err
+	}
+	layout, err := timeLayout(pattern)
+	if err != nil {
+		return Value{}, err
+	}
+	return stringValue(t.Format(layout)), nil
+}
+
+// patternLayouts maps the letters of TO_STRING patterns, by count, to the
+// elements of Go time layouts
+var patternLayouts = map[string]string{
+	"y": "2006", "yy": "06", "yyyy": "2006",
+	"M": "1", "MM": "01", "MMM": "Jan", "MMMM": "January",
+	"d": "2", "dd": "02",
+	"a": "PM",
+	"h": "3", "hh": "03",
+	"H": "15", "HH": "15",
+	"m": "4", "mm": "04",
+	"s": "5", "ss": "05",
+	"X": "Z07", "XX": "Z0700", "XXX": "Z07:00",
+	"x": "-07", "xx": "-0700", "xxx": "-07:00",
+}
+
+// timeLayout converts a TO_STRING pattern such as yyyy-MM-dd'T'HH:mm to a
+// Go time layout. Runs of S are fractions of a second; text in single
+// quotes is copied as it is.
+func timeLayout(pattern string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(pattern); {
+		c := pattern[i]
+		j := i + 1
+		switch {
+		case c == '\'':
+			end := strings.IndexByte(pattern[j:], '\'')
+			if end < 0 {
+				return "", errEval("unterminated quote in TO_STRING pattern %q", pattern)
+			}
+			b.WriteString(pattern[j : j+end])
+			j += end + 1
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			for j < len(pattern) && pattern[j] == c {
+				j++
+			}
+			run := pattern[i:j]
+			if c == 'S' {
+				b.WriteString(strings.Repeat("0", len(run)))
+				break
+			}
+			layout, ok := patternLayouts[run]
+			if !ok {
+				return "", errEval("unsupported TO_STRING pattern %q", run)
+			}
+			b.WriteString(layout)
+		default:
+			b.WriteByte(c)
+		}
+		i = j
+	}
+	return b.String(), nil
+}
+
+// extract implements EXTRACT(part FROM timestamp)
+func extract(args []Value) (Value, error) {
+	t, err := timestampArg(args, 1, "EXTRACT")
+	if err != nil {
+		return Value{}, err
+	}
+	_, offset := t.Zone()
+	var n int
+	switch args[0].s {
+	case "YEAR":
+		n = t.Year()
+	case "MONTH":
+		n = int(t.Month())
+	case "DAY":
+		n = t.Day()
+	case "HOUR":
+		n = t.Hour()
+	case "MINUTE":
+		n = t.Minute()
+	case "SECOND":
+		n = t.Second()
+	case "TIMEZONE_HOUR":
+		n = offset / 3600
+	case "TIMEZONE_MINUTE":
+		n = offset % 3600 / 60
+	default:
+		return Value{}, errEval("unsupported EXTRACT part %s", args[0].s)
+	}
+	return intValue(int64(n)), nil
+}
+
+// dateAdd implements DATE_ADD(part, quantity, timestamp)
+func dateAdd(args []Value) (Value, error) {
+	n, err := intArg(args, 1, "DATE_ADD")
+	if err != nil {
+		return Value{}, err
+	}
+	t, err := timestampArg(args, 2, "DATE_ADD")
+	if err != nil {
+		return Value{}, err
+	}
+	switch args[0].s {
+	case "YEAR":
+		return timeValue(t.AddDate(int(n), 0, 0)), nil
+	case "MONTH":
+		return timeValue(t.AddDate(0, int(n), 0)), nil
+	case "DAY":
+		return timeValue(t.AddDate(0, 0, int(n))), nil
+	}
+	unit, ok := durationUnits[args[0].s]
+	if !ok {
+		return Value{}, errEval("unsupported DATE_ADD part %s", args[0].s)
+	}
+	return timeValue(t.Add(time.Duration(n) * unit)), nil
+}
+
+// durationUnits are the date parts of fixed length
+var durationUnits = map[string]time.Duration{
+	"DAY":    24 * time.Hour,
+	"HOUR":   time.Hour,
+	"MINUTE": time.Minute,
+	"SECOND": time.Second,
+}
+
+// dateDiff implements DATE_DIFF(part, from, to), the whole number of parts
+// from one timestamp to the other
+func dateDiff(args []Value) (Value, error) {
+	from, err := timestampArg(args, 1, "DATE_DIFF")
+	if err != nil {
+		return Value{}, err
+	}
+	to, err := timestampArg(args, 2, "DATE_DIFF")
+	if err != nil {
+		return Value{}, err
+	}
+	switch args[0].s {
+	case "YEAR", "MONTH":
+		months := (to.Year()-from.Year())*12 + int(to.Month()-from.Month())
+		if end := from.AddDate(0, months, 0); months > 0 && end.After(to) {
+			months--
+		} else if months < 0 && end.Before(to) {
+			months++
+		}
+		if args[0].s == "YEAR" {
+			return intValue(int64(months / 12)), nil
+		}
+		return intValue(int64(months)), nil
+	}
+	unit, ok := durationUnits[args[0].s]
+	if !ok {
+		return Value{}, errEval("unsupported DATE_DIFF part %s", args[0].s)
+	}
+	return intValue(int64(to.Sub(from) / unit)), nil
+}
@@ -0,0 +1,260 @@
+package s3select
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"math"
+	"strings"
+)
+
+// input is the record stream of an object, counting the bytes read from
+// the object and, after decompression, the bytes processed
+type input struct {
+	recordReader
+	scanned, processed *countingReader
+}
+
+// openInput decompresses data and limits it to the scan range of req,
+// returning a reader of the records of the object. Scanning a range seeks
+// past the rest of the object when data is an io.Seeker.
+func openInput(req *SelectRequest, data io.Reader) (*input, error) {
+	in := req.InputSerialization
+	compression := strings.ToUpper(in.CompressionType)
+	switch compression {
+	case "", "NONE", "GZIP", "BZIP2":
+	default:
+		return nil, &Error{Code: "InvalidCompressionFormat", Message: "CompressionType must be NONE, GZIP or BZIP2"}
+	}
+
+	scanned := &countingReader{r: data}
+	var r io.Reader = scanned
+	var rr *rangeReader
+	if req.ScanRange != nil {
+		var err error
+		if rr, err = openScanRange(req, compression, data, scanned); err != nil {
+			return nil, err
+		}
+		r = rr
+	}
+
+	switch compression {
+	case "GZIP":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, compressionError(err)
+		}
+		r = &decompressReader{r: gz}
+	case "BZIP2":
+		r = &decompressReader{r: bzip2.NewReader(r)}
+	}
+
+	processed := &countingReader{r: r}
+	var records recordReader
+	if rr != nil && rr.start > 0 && in.Format == FormatCSV {
+		// The range does not start with the header row
+		c, err := newCSVReader(in.CSV, processed)
+		if err != nil {
+			return nil, err
+		}
+		c.header = rr.header
+		records = c
+	} else {
+		var err error
+		if records, err = newRecordReader(in, processed); err != nil {
+			return nil, err
+		}
+	}
+	return &input{recordReader: records, scanned: scanned, processed: processed}, nil
+}
+
+// openScanRange checks the input can be split by byte offset and positions
+// data at the start of the range. A CSV header is read from the start of
+// the object so that a range after it still has the column names.
+func openScanRange(req *SelectRequest, compression string, data io.Reader, scanned io.Reader) (*rangeReader, error) {
+	in, sr := req.InputSerialization, req.ScanRange
+	unsupported := compression == "GZIP" || compression == "BZIP2"
+	switch in.Format {
+	case FormatCSV:
+		unsupported = unsupported || in.CSV != nil && in.CSV.AllowQuotedRecordDelimiter
+	case FormatJSON:
+		lines, err := jsonLines(in.JSON)
+		if err != nil {
+			return nil, err
+		}
+		unsupported = unsupported || !lines
+	}
+	if unsupported {
+		return nil, &Error{Code: "UnsupportedScanRangeInput", Message: "scan ranges need uncompressed CSV without quoted record delimiters or JSON Lines"}
+	}
+	if sr.Start < 0 && sr.End < 0 || sr.Start >= 0 && sr.End >= 0 && sr.Start > sr.End {
+		return nil, &Error{Code: "InvalidRequestParameter", Message: "invalid scan range"}
+	}
+
+	seeker, _ := data.(io.Seeker)
+	start, end := sr.Start, sr.End
+	switch {
+	case start < 0:
+		if seeker == nil {
+			return nil, &Error{Code: "InvalidRequestParameter", Message: "a scan range of the last bytes needs a seekable object"}
+		}
+		size, err := seeker.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		start, end = max(size-sr.End, 0), math.MaxInt64
+	case end < 0:
+		end = math.MaxInt64
+	}
+
+	delim := []byte("\n")
+	if in.Format == FormatCSV && in.CSV != nil && in.CSV.RecordDelimiter != "" {
+		delim = []byte(in.CSV.RecordDelimiter)
+	}
+	rr := &rangeReader{delim: delim, start: start, pos: max(start-int64(len(delim)), 0), end: end}
+
+	if start > 0 && in.Format == FormatCSV && in.CSV != nil && strings.EqualFold(in.CSV.FileHeaderInfo, "USE") {
+		if seeker == nil {
+			return nil, &Error{Code: "InvalidRequestParameter", Message: "a scan range with a CSV header needs a seekable object"}
+		}
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		hr, err := newCSVReader(in.CSV, io.LimitReader(data, maxRecordSize))
+		if err != nil {
+			return nil, err
+		}
+		if err := hr.readHeader(); err != nil {
+			return nil, err
+		}
+		rr.header = hr.header
+	}
+
+	// Position data just before start, where a delimiter ending at start
+	// or later marks the first record of the range
+	rr.r = bufio.NewReader(scanned)
+	if seeker != nil {
+		if _, err := seeker.Seek(rr.pos, io.SeekStart); err != nil {
+			return nil, err
+		}
+	} else if _, err := rr.r.Discard(int(min(rr.pos, math.MaxInt))); err == io.EOF {
+		rr.done = true
+	} else if err != nil {
+		return nil, err
+	}
+	if start > 0 && !rr.done {
+		if err := rr.skipRecord(); err != nil {
+			return nil, err
+		}
+	}
+	rr.done = rr.done || rr.pos > rr.end
+	return rr, nil
+}
+
+// rangeReader passes through the records of an object that start at or
+// before end: once past end it stops at the next record delimiter
+type rangeReader struct {
+	r      *bufio.Reader
+	delim  []byte
+	start  int64
+	pos    int64 // object offset of the next byte
+	end    int64
+	tail   []byte // the last len(delim) bytes read
+	done   bool
+	header []string // the CSV header read from the start of the object
+}
+
+// skipRecord discards the rest of the record holding pos, so that the next
+// byte read starts a record
+func (r *rangeReader) skipRecord() error {
+	for !bytes.Equal(r.tail, r.delim) {
+		b, err := r.r.ReadByte()
+		if err == io.EOF {
+			r.done = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		r.pos++
+		r.track([]byte{b})
+	}
+	return nil
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if r.pos <= r.end {
+		if remaining := r.end - r.pos; int64(len(p)) > remaining {
+			p = p[:remaining+1]
+		}
+		n, err := r.r.Read(p)
+		r.pos += int64(n)
+		r.track(p[:n])
+		r.done = r.pos > r.end && bytes.Equal(r.tail, r.delim)
+		return n, err
+	}
+
+	// Past the end of the range, finish the record byte by byte
+	n := 0
+	for n < len(p) && !r.done {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		p[n] = b
+		n++
+		r.pos++
+		r.track(p[n-1 : n])
+		r.done = bytes.Equal(r.tail, r.delim)
+	}
+	return n, nil
+}
+
+// track keeps the last len(delim) bytes read in tail
+func (r *rangeReader) track(b []byte) {
+	if len(b) > len(r.delim) {
+		b = b[len(b)-len(r.delim):]
+	}
+	r.tail = append(r.tail, b...)
+	if extra := len(r.tail) - len(r.delim); extra > 0 {
+		r.tail = append(r.tail[:0], r.tail[extra:]...)
+	}
+}
+
+// decompressReader reports corrupt compressed data as an *Error, passing
+// other errors of the object through
+type decompressReader struct {
+	r io.Reader
+}
+
+func (d *decompressReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = compressionError(err)
+	}
+	return n, err
+}
+
+func compressionError(err error) error {
+	var corrupt flate.CorruptInputError
+	var structural bzip2.StructuralError
+	switch {
+	case err == io.EOF, err == io.ErrUnexpectedEOF:
+		err = io.ErrUnexpectedEOF
+	case errors.Is(err, gzip.ErrHeader), errors.Is(err, gzip.ErrChecksum),
+		errors.As(err, &corrupt), errors.As(err, &structural):
+	default:
+		return err
+	}
+	return &Error{Code: "InvalidCompressionFormat", Message: "failed to decompress the object: " + err.Error()}
+}
@@ -0,0 +1,250 @@
+package s3select
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// gzipString compresses s with gzip
+func gzipString(t *testing.T, s string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// bzip2People is "name,age\nAlice,30\nBob,25\n" compressed with bzip2
+const bzip2People = "QlpoOTFBWSZTWWRzW6YAAAvdAAAQAARaADAAOqegADFNMjExMQiMjI0aZqcRXQmUmYjoTMihRuPi7kinChIMjmt0wA=="
+
+var csvWithoutHeader = InputSerialization{Format: FormatCSV, CSV: &CSVInput{}}
+
+func csvInput(opts CSVInput) InputSerialization {
+	return InputSerialization{Format: FormatCSV, CSV: &opts}
+}
+
+func TestSelectInputFormats(t *testing.T) {
+	bz, err := base64.StdEncoding.DecodeString(bzip2People)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		in   InputSerialization
+		sql  string
+		data string
+		want string
+	}{
+		{"Gzip", InputSerialization{Format: FormatCSV, CSV: &CSVInput{FileHeaderInfo: "USE"}, CompressionType: "GZIP"},
+			"SELECT name FROM S3Object WHERE age > 26", gzipString(t, "name,age\nAlice,30\nBob,25\n"), "Alice\n"},
+		{"GzipMultistream", InputSerialization{Format: FormatJSON, JSON: &JSONInput{Type: "LINES"}, CompressionType: "gzip"},
+			"SELECT s.n FROM S3Object s", gzipString(t, `{"n":1}`+"\n") + gzipString(t, `{"n":2}`+"\n"), "1\n2\n"},
+		{"Bzip2", InputSerialization{Format: FormatCSV, CSV: &CSVInput{FileHeaderInfo: "USE"}, CompressionType: "BZIP2"},
+			"SELECT name FROM S3Object WHERE age < 26", string(bz), "Bob\n"},
+		{"NoneCompression", InputSerialization{Format: FormatCSV, CompressionType: "NONE"}, "SELECT _2 FROM S3Object", "a,b\n", "b\n"},
+
+		{"JSONDocument", InputSerialization{Format: FormatJSON, JSON: &JSONInput{Type: "DOCUMENT"}},
+			"SELECT s.name FROM S3Object[*].people[*] s", "{\n  \"people\": [\n    {\"name\": \"ann\"},\n    {\"name\": \"ben\"}\n  ]\n}\n", "ann\nben\n"},
+		{"JSONDocumentDefault", InputSerialization{Format: FormatJSON},
+			"SELECT s.n FROM S3Object s", "{\"n\":\n1} {\"n\":2}", "1\n2\n"},
+		{"JSONLinesBlank", InputSerialization{Format: FormatJSON, JSON: &JSONInput{Type: "lines"}},
+			"SELECT s.n FROM S3Object s", "{\"n\":1}\r\n\n  \n{\"n\":2}", "1\n2\n"},
+
+		{"HeaderIgnore", csvInput(CSVInput{FileHeaderInfo: "IGNORE"}), "SELECT _1 FROM S3Object", "name\nann\n", "ann\n"},
+		{"HeaderNone", csvInput(CSVInput{FileHeaderInfo: "NONE"}), "SELECT _1 FROM S3Object", "name\nann\n", "name\nann\n"},
+		{"Delimiters", csvInput(CSVInput{FileHeaderInfo: "USE", FieldDelimiter: "|", RecordDelimiter: ";"}),
+			"SELECT b FROM S3Object", "a|b;1|2;3|4;", "2\n4\n"},
+		{"MultiByteDelimiters", csvInput(CSVInput{FieldDelimiter: "::", RecordDelimiter: "\r\n"}),
+			"SELECT _2 FROM S3Object", "a::b\r\nc::d:e\r\n", "b\nd:e\n"},
+		{"CRLF", csvInput(CSVInput{FileHeaderInfo: "USE"}), "SELECT b FROM S3Object", "a,b\r\n1,2\r\n", "2\n"},
+		{"BlankLines", csvInput(CSVInput{}), "SELECT _1 FROM S3Object", "\na\n\n\nb\n", "a\nb\n"},
+		{"QuotedFields", csvInput(CSVInput{}), "SELECT _2, _3 FROM S3Object", `1,"a,b","say ""hi"""` + "\n", "\"a,b\",\"say \"\"hi\"\"\"\n"},
+		{"QuoteCharacter", csvInput(CSVInput{QuoteCharacter: "'"}), "SELECT _1 || '|' || _2 FROM S3Object", "'a;b','it''s'\n", "a;b|it's\n"},
+		{"QuoteEscapeCharacter", csvInput(CSVInput{QuoteEscapeCharacter: `\`}), "SELECT _1 FROM S3Object", `"say \"hi\" \n"` + "\n", `"say ""hi"" \n"` + "\n"},
+		{"QuotedRecordDelimiter", csvInput(CSVInput{AllowQuotedRecordDelimiter: true}), "SELECT _2 FROM S3Object",
+			"1,\"two\nlines\"\n2,x\n", "\"two\nlines\"\nx\n"},
+		{"QuotedRecordDelimiterNotAllowed", csvInput(CSVInput{}), "SELECT _1 FROM S3Object",
+			"\"two\nlines\"\n", "two\n\"lines\"\"\"\n"},
+		{"TextAfterQuote", csvInput(CSVInput{}), "SELECT _1, _2 FROM S3Object", `"ab"cd,e` + "\n", "abcd,e\n"},
+		{"Comments", csvInput(CSVInput{CommentCharacter: "#"}), "SELECT _1 FROM S3Object", "#skip,me\na\n#\nb", "a\nb\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := runSelect(tt.in, csvOutput, tt.sql, tt.data)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.sql, err)
+			}
+			if got != tt.want {
+				t.Errorf("%s\n got %q\nwant %q", tt.sql, got, tt.want)
+			}
+		})
+	}
+}
+
+// unseekable hides the Seek method of a reader
+type unseekable struct {
+	io.Reader
+}
+
+func TestSelectScanRange(t *testing.T) {
+	// Records start at offsets 0, 4 and 9
+	const data = "a,1\nbb,2\nccc,3\n"
+	const withHeader = "h1,h2\na,1\nbb,2\n"
+	const jsonLines = `{"n":1}` + "\n" + `{"n":2}` + "\n"
+
+	tests := []struct {
+		name      string
+		in        InputSerialization
+		scanRange ScanRange
+		data      string
+		want      string
+	}{
+		{"FirstRecord", csvWithoutHeader, ScanRange{Start: 0, End: 3}, data, "a\n"},
+		{"FirstByte", csvWithoutHeader, ScanRange{Start: 0, End: 0}, data, "a\n"},
+		{"StartMidRecord", csvWithoutHeader, ScanRange{Start: 1, End: 4}, data, "bb\n"},
+		{"StartAtRecord", csvWithoutHeader, ScanRange{Start: 4, End: 4}, data, "bb\n"},
+		{"PastEnd", csvWithoutHeader, ScanRange{Start: 5, End: 100}, data, "ccc\n"},
+		{"NoRecordStarts", csvWithoutHeader, ScanRange{Start: 10, End: 14}, data, ""},
+		{"OpenEnd", csvWithoutHeader, ScanRange{Start: 3, End: -1}, data, "bb\nccc\n"},
+		{"Suffix", csvWithoutHeader, ScanRange{Start: -1, End: 6}, data, "ccc\n"},
+		{"SuffixOnDelimiter", csvWithoutHeader, ScanRange{Start: -1, End: 7}, data, "ccc\n"},
+		{"SuffixLongerThanObject", csvWithoutHeader, ScanRange{Start: -1, End: 100}, data, "a\nbb\nccc\n"},
+		{"HeaderFromStart", csvWithHeader, ScanRange{Start: 6, End: 9}, withHeader, "a\n"},
+		{"HeaderOnly", csvWithHeader, ScanRange{Start: 0, End: 5}, withHeader, ""},
+		{"HeaderIgnored", csvInput(CSVInput{FileHeaderInfo: "IGNORE"}), ScanRange{Start: 6, End: -1}, withHeader, "a\nbb\n"},
+		{"RecordDelimiter", csvInput(CSVInput{RecordDelimiter: "\r\n"}), ScanRange{Start: 1, End: 4}, "a\r\nb\r\nc\r\n", "b\n"},
+		{"JSONLines", jsonInput, ScanRange{Start: 8, End: 8}, jsonLines, "2\n"},
+	}
+
+	svc := NewSelectService(zap.NewNop())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql := "SELECT s._1 FROM S3Object s"
+			if tt.in.Format == FormatJSON {
+				sql = "SELECT s.n FROM S3Object s"
+			} else if tt.in.CSV.FileHeaderInfo == "USE" {
+				sql = "SELECT h1 FROM S3Object"
+			}
+			req := &SelectRequest{Expression: sql, InputSerialization: tt.in, ScanRange: &tt.scanRange}
+
+			var out resultBuffer
+			if _, err := svc.Stream(context.Background(), req, strings.NewReader(tt.data), &out); err != nil {
+				t.Fatalf("Stream() error = %v", err)
+			}
+			if string(out.payload) != tt.want {
+				t.Errorf("Stream() = %q, want %q", out.payload, tt.want)
+			}
+
+			// Without seeking, the skipped bytes are read and discarded
+			if tt.scanRange.Start < 0 || tt.in.CSV != nil && tt.in.CSV.FileHeaderInfo == "USE" {
+				return
+			}
+			out = resultBuffer{}
+			if _, err := svc.Stream(context.Background(), req, unseekable{strings.NewReader(tt.data)}, &out); err != nil {
+				t.Fatalf("Stream() of unseekable data error = %v", err)
+			}
+			if string(out.payload) != tt.want {
+				t.Errorf("Stream() of unseekable data = %q, want %q", out.payload, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectInputStats(t *testing.T) {
+	svc := NewSelectService(zap.NewNop())
+	plain := "name,age\nAlice,30\nBob,25\n"
+	compressed := gzipString(t, plain)
+
+	stats, err := svc.Stream(context.Background(), &SelectRequest{
+		Expression:         "SELECT * FROM S3Object",
+		InputSerialization: InputSerialization{Format: FormatCSV, CompressionType: "GZIP"},
+	}, strings.NewReader(compressed), &resultBuffer{})
+	if err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+	if stats.BytesScanned != int64(len(compressed)) || stats.BytesProcessed != int64(len(plain)) {
+		t.Errorf("Stream() scanned %d and processed %d bytes, want %d and %d",
+			stats.BytesScanned, stats.BytesProcessed, len(compressed), len(plain))
+	}
+
+	// A range near the end seeks past the start of the object
+	stats, err = svc.Stream(context.Background(), &SelectRequest{
+		Expression:         "SELECT * FROM S3Object",
+		InputSerialization: csvWithoutHeader,
+		ScanRange:          &ScanRange{Start: 9, End: 14},
+	}, strings.NewReader("a,1\nbb,2\nccc,3\n"), &resultBuffer{})
+	if err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+	if stats.BytesScanned != 7 {
+		t.Errorf("Stream() of a scan range scanned %d bytes, want 7", stats.BytesScanned)
+	}
+}
+
+func TestSelectInputErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      SelectRequest
+		data     string
+		wantCode string
+	}{
+		{"CompressionType", SelectRequest{InputSerialization: InputSerialization{Format: FormatCSV, CompressionType: "ZSTD"}}, "a\n", "InvalidCompressionFormat"},
+		{"NotGzip", SelectRequest{InputSerialization: InputSerialization{Format: FormatCSV, CompressionType: "GZIP"}}, "a,b\n", "InvalidCompressionFormat"},
+		{"TruncatedGzip", SelectRequest{InputSerialization: InputSerialization{Format: FormatCSV, CompressionType: "GZIP"}}, gzipString(t, "a,b\nc,d\n")[:20], "InvalidCompressionFormat"},
+		{"NotBzip2", SelectRequest{InputSerialization: InputSerialization{Format: FormatCSV, CompressionType: "BZIP2"}}, "a,b\n", "InvalidCompressionFormat"},
+		{"JSONType", SelectRequest{InputSerialization: InputSerialization{Format: FormatJSON, JSON: &JSONInput{Type: "STREAM"}}}, "{}", "InvalidJsonType"},
+		{"JSONLinesMultiline", SelectRequest{InputSerialization: jsonInput}, "{\n\"a\": 1}\n", "JSONParsingError"},
+		{"JSONLinesTwoValues", SelectRequest{InputSerialization: jsonInput}, `{"a":1} {"a":2}`, "JSONParsingError"},
+		{"SameDelimiters", SelectRequest{InputSerialization: csvInput(CSVInput{FieldDelimiter: ";", RecordDelimiter: ";"})}, "a;b", "InvalidRequestParameter"},
+		{"CSVRecordSize", SelectRequest{InputSerialization: csvWithoutHeader}, strings.Repeat("x", maxRecordSize+1), "OverMaxRecordSize"},
+		{"JSONLinesRecordSize", SelectRequest{InputSerialization: jsonInput}, `"` + strings.Repeat("x", maxRecordSize) + `"`, "OverMaxRecordSize"},
+		{"JSONDocumentRecordSize", SelectRequest{InputSerialization: InputSerialization{Format: FormatJSON}}, "[" + strings.Repeat(`"xxxxxxxxxx",`, maxRecordSize/10) + "1]", "OverMaxRecordSize"},
+		{"RangeCompressed", SelectRequest{InputSerialization: InputSerialization{Format: FormatCSV, CompressionType: "GZIP"}, ScanRange: &ScanRange{Start: 0, End: 10}}, "", "UnsupportedScanRangeInput"},
+		{"RangeJSONDocument", SelectRequest{InputSerialization: InputSerialization{Format: FormatJSON}, ScanRange: &ScanRange{Start: 0, End: 10}}, "{}", "UnsupportedScanRangeInput"},
+		{"RangeQuotedRecords", SelectRequest{InputSerialization: csvInput(CSVInput{AllowQuotedRecordDelimiter: true}), ScanRange: &ScanRange{Start: 0, End: 10}}, "a\n", "UnsupportedScanRangeInput"},
+		{"RangeBackwards", SelectRequest{InputSerialization: csvWithoutHeader, ScanRange: &ScanRange{Start: 10, End: 5}}, "a\n", "InvalidRequestParameter"},
+	}
+
+	svc := NewSelectService(zap.NewNop())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.Expression = "SELECT * FROM S3Object"
+			_, err := svc.Stream(context.Background(), &tt.req, strings.NewReader(tt.data), &resultBuffer{})
+			var selErr *Error
+			if !errors.As(err, &selErr) || selErr.Code != tt.wantCode {
+				t.Errorf("Stream() error = %v, want %s", err, tt.wantCode)
+			}
+		})
+	}
+
+	t.Run("StorageError", func(t *testing.T) {
+		readErr := errors.New("disk failed")
+		req := &SelectRequest{Expression: "SELECT * FROM S3Object", InputSerialization: InputSerialization{Format: FormatCSV, CompressionType: "GZIP"}}
+		data := io.MultiReader(strings.NewReader(gzipString(t, "a\n")[:12]), &failingReader{err: readErr})
+		if _, err := svc.Stream(context.Background(), req, data, &resultBuffer{}); !errors.Is(err, readErr) {
+			t.Errorf("Stream() error = %v, want %v", err, readErr)
+		}
+	})
+}
+
+// failingReader fails every read
+type failingReader struct {
+	err error
+}
+
+func (f *failingReader) Read([]byte) (int, error) { return 0, f.err }
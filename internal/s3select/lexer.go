@@ -0,0 +1,122 @@
+package s3select
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// tokenKind identifies the lexical class of a token
+type tokenKind int
+
+const (
+	tokEOF       tokenKind = iota
+	tokIdent               // bare identifier or keyword, matched case-insensitively
+	tokQuoted              // "double quoted" identifier, matched exactly
+	tokString              // 'single quoted' string literal
+	tokNumber              // integer or decimal literal
+	tokTimestamp           // `backtick` timestamp literal
+	tokOp                  // operator or punctuation
+)
+
+// token is one lexeme of a SQL expression
+type token struct {
+	kind tokenKind
+	text string
+	pos  int // byte offset in the expression
+}
+
+// is reports whether t is the keyword or operator s
+func (t token) is(s string) bool {
+	return (t.kind == tokIdent || t.kind == tokOp) && strings.EqualFold(t.text, s)
+}
+
+// operators lists the multi-character operators before the single ones so
+// the longest match wins
+var operators = []string{"<=", ">=", "<>", "!=", "||", "(", ")", ",", ".", "*", "[", "]", "+", "-", "/", "%", "=", "<", ">"}
+
+// lex splits a SQL expression into tokens, ending with a tokEOF
+func lex(sql string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(sql); {
+		c := rune(sql[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '\'' || c == '"' || c == '`':
+			text, n, ok := lexQuoted(sql[i:], byte(c))
+			if !ok {
+				return nil, errParse(i, "unterminated %c literal", c)
+			}
+			kind := map[rune]tokenKind{'\'': tokString, '"': tokQuoted, '`': tokTimestamp}[c]
+			tokens = append(tokens, token{kind: kind, text: text, pos: i})
+			i += n
+
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(sql) && sql[i+1] >= '0' && sql[i+1] <= '9':
+			start := i
+			for i < len(sql) && (sql[i] >= '0' && sql[i] <= '9' || sql[i] == '.') {
+				i++
+			}
+			// Exponent, as in 1.5e3 or 2E-4
+			if i < len(sql) && (sql[i] == 'e' || sql[i] == 'E') {
+				j := i + 1
+				if j < len(sql) && (sql[j] == '+' || sql[j] == '-') {
+					j++
+				}
+				if j < len(sql) && sql[j] >= '0' && sql[j] <= '9' {
+					for i = j; i < len(sql) && sql[i] >= '0' && sql[i] <= '9'; i++ {
+					}
+				}
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: sql[start:i], pos: start})
+
+		case isIdentByte(sql[i]):
+			start := i
+			for i < len(sql) && (isIdentByte(sql[i]) || sql[i] >= '0' && sql[i] <= '9') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: sql[start:i], pos: start})
+
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(sql[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, errParse(i, "unexpected character %q", c)
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(sql)}), nil
+}
+
+// isIdentByte reports whether b may start an identifier; bytes of
+// multi-byte UTF-8 characters are taken as letters
+func isIdentByte(b byte) bool {
+	return b == '_' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= utf8.RuneSelf
+}
+
+// lexQuoted reads a literal enclosed in quote, where a doubled quote stands
+// for itself, returning its text and the bytes consumed
+func lexQuoted(s string, quote byte) (string, int, bool) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		if s[i] != quote {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == quote {
+			b.WriteByte(quote)
+			i++
+			continue
+		}
+		return b.String(), i + 1, true
+	}
+	return "", 0, false
+}
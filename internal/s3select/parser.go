@@ -0,0 +1,741 @@
+package s3select
+
+import (
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Query is a parsed SELECT statement
+type Query struct {
+	items     []selectItem // nil for SELECT *
+	alias     string       // the name of the S3Object record in paths
+	from      []pathStep   // path into each input record, as in S3Object[*].items
+	where     expr
+	limit     int64 // -1 without a LIMIT
+	aggregate bool  // the items are aggregates, giving a single row
+
+	aggregates []*aggregateExpr
+}
+
+// selectItem is one expression of the select list
+type selectItem struct {
+	expr expr
+	name string // the AS alias or last path name; _N when empty
+}
+
+// reserved are the keywords that cannot be taken as an alias
+var reserved = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "LIMIT": true, "AS": true,
+	"AND": true, "OR": true, "NOT": true, "LIKE": true, "ESCAPE": true, "IN": true,
+	"BETWEEN": true, "IS": true, "NULL": true, "MISSING": true, "TRUE": true, "FALSE": true,
+}
+
+// Parser parses SQL expressions
+type Parser struct {
+	logger *zap.Logger
+}
+
+// NewParser creates a new SQL parser
+func NewParser(logger *zap.Logger) *Parser {
+	return &Parser{logger: logger}
+}
+
+// Parse parses a SELECT statement of the S3 Select SQL subset:
+//
+//	SELECT * | expr [[AS] name], ... FROM S3Object[path] [[AS] alias]
+//	[WHERE condition] [LIMIT n]
+func (p *Parser) Parse(sql string) (*Query, error) {
+	tokens, err := lex(sql)
+	if err != nil {
+		return nil, err
+	}
+	ps := &parser{tokens: tokens}
+	q, err := ps.parseQuery()
+	if err != nil {
+		return nil, err
+	}
+	p.logger.Debug("Parsed S3 Select expression", zap.String("expression", sql), zap.Int("items", len(q.items)))
+	return q, nil
+}
+
+// parser is the state of a recursive descent over the tokens of a query
+type parser struct {
+	tokens []token
+	pos    int
+	paths  []*pathExpr // every path, to strip the alias once FROM is read
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the keyword or operator s
+func (p *parser) accept(s string) bool {
+	if p.peek().is(s) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// expect consumes the keyword or operator s or fails
+func (p *parser) expect(s string) error {
+	if !p.accept(s) {
+		return p.unexpected("expected " + s)
+	}
+	return nil
+}
+
+// unexpected reports the next token as a syntax error
+func (p *parser) unexpected(want string) *Error {
+	t := p.peek()
+	if t.kind == tokEOF {
+		return errParse(t.pos, "%s, found end of expression", want)
+	}
+	return errParse(t.pos, "%s, found %q", want, t.text)
+}
+
+func (p *parser) parseQuery() (*Query, error) {
+	if err := p.expect("SELECT"); err != nil {
+		return nil, err
+	}
+	q := &Query{limit: -1}
+
+	// alias.* alone is the whole record, as plain *
+	star := p.peek().kind == tokIdent && p.tokens[p.pos+1].is(".") && p.tokens[p.pos+2].is("*") && p.tokens[p.pos+3].is("FROM")
+	if star {
+		p.pos += 3
+	}
+	if !star && !p.accept("*") {
+		for {
+			item, err := p.parseSelectItem()
+			if err != nil {
+				return nil, err
+			}
+			q.items = append(q.items, item)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+
+	if err := p.expect("FROM"); err != nil {
+		return nil, err
+	}
+	if t := p.next(); t.kind != tokIdent || !strings.EqualFold(t.text, "S3Object") {
+		return nil, &Error{Code: "InvalidDataSource", Message: "only S3Object may be queried, found " + strconv.Quote(t.text)}
+	}
+	from, err := p.parsePathSteps(true)
+	if err != nil {
+		return nil, err
+	}
+	// S3Object[*] is the records themselves, S3Object[*].path a path in each
+	if len(from) > 0 && from[0].wildcard {
+		from = from[1:]
+	}
+	q.from = from
+	q.alias = "S3Object"
+	if alias, ok := p.parseAlias(); ok {
+		q.alias = alias
+	}
+
+	if p.accept("WHERE") {
+		if q.where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("LIMIT") {
+		t := p.next()
+		n, err := strconv.ParseInt(t.text, 10, 64)
+		if t.kind != tokNumber || err != nil || n < 0 {
+			return nil, errParse(t.pos, "LIMIT requires a non-negative integer, found %q", t.text)
+		}
+		q.limit = n
+	}
+	if p.peek().kind != tokEOF {
+		return nil, p.unexpected("expected end of expression")
+	}
+
+	// Paths are relative to the record, so a leading alias names the record
+	for _, path := range p.paths {
+		if len(path.steps) > 0 && path.steps[0].name != "" && !path.steps[0].quoted && strings.EqualFold(path.steps[0].name, q.alias) {
+			path.steps = path.steps[1:]
+		}
+	}
+	for i := range q.items {
+		if path, ok := q.items[i].expr.(*pathExpr); ok && q.items[i].name == "" {
+			for _, step := range path.steps {
+				if step.name != "" {
+					q.items[i].name = step.name
+				}
+			}
+		}
+	}
+
+	return q, q.validate()
+}
+
+// validate checks where aggregates appear: either every item of the select
+// list is an aggregate or none is, no aggregate nests another, and WHERE
+// has none
+func (q *Query) validate() error {
+	unsupported := func(msg string) error { return &Error{Code: "UnsupportedSqlStructure", Message: msg} }
+
+	if q.where != nil && containsAggregate(q.where) {
+		return unsupported("aggregate functions are not allowed in WHERE")
+	}
+	for _, item := range q.items {
+		if containsAggregate(item.expr) {
+			q.aggregate = true
+		}
+	}
+	if !q.aggregate {
+		return nil
+	}
+	for _, item := range q.items {
+		var err error
+		walkExpr(item.expr, func(e expr) bool {
+			switch e := e.(type) {
+			case *aggregateExpr:
+				if e.x != nil && containsAggregate(e.x) {
+					err = unsupported("aggregate functions cannot be nested")
+				}
+				q.aggregates = append(q.aggregates, e)
+				return false
+			case *pathExpr:
+				err = unsupported("the select list cannot mix aggregate and non-aggregate expressions")
+			}
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// containsAggregate reports whether e has an aggregate function call
+func containsAggregate(e expr) bool {
+	found := false
+	walkExpr(e, func(e expr) bool {
+		_, ok := e.(*aggregateExpr)
+		found = found || ok
+		return !found
+	})
+	return found
+}
+
+// parseSelectItem parses an expression of the select list with its alias
+func (p *parser) parseSelectItem() (selectItem, error) {
+	if p.peek().kind == tokIdent && p.tokens[p.pos+1].is(".") && p.tokens[p.pos+2].is("*") {
+		return selectItem{}, errParse(p.peek().pos, "alias.* cannot be combined with other items, use SELECT *")
+	}
+	e, err := p.parseExpr()
+	if err != nil {
+		return selectItem{}, err
+	}
+	item := selectItem{expr: e}
+	if alias, ok := p.parseAlias(); ok {
+		item.name = alias
+	}
+	return item, nil
+}
+
+// parseAlias parses an optional [AS] name
+func (p *parser) parseAlias() (string, bool) {
+	as := p.accept("AS")
+	t := p.peek()
+	if t.kind == tokQuoted || t.kind == tokIdent && !reserved[strings.ToUpper(t.text)] {
+		p.pos++
+		return t.text, true
+	}
+	if as {
+		p.pos--
+	}
+	return "", false
+}
+
+// parseExpr parses an expression at the lowest precedence, OR
+func (p *parser) parseExpr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: "OR", l: left, r: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: "AND", l: left, r: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (expr, error) {
+	if p.accept("NOT") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: "NOT", x: x}, nil
+	}
+	return p.parseComparison()
+}
+
+// parseComparison parses a comparison, LIKE, BETWEEN, IN or IS predicate,
+// or a plain additive expression
+func (p *parser) parseComparison() (expr, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, op := range []string{"=", "!=", "<>", "<=", ">=", "<", ">"} {
+		if p.accept(op) {
+			right, err := p.parseAdditive()
+			if err != nil {
+				return nil, err
+			}
+			if op == "<>" {
+				op = "!="
+			}
+			return &binaryExpr{op: op, l: left, r: right}, nil
+		}
+	}
+
+	if p.accept("IS") {
+		not := p.accept("NOT")
+		switch {
+		case p.accept("NULL"):
+			return &isExpr{x: left, not: not}, nil
+		case p.accept("MISSING"):
+			return &isExpr{x: left, missing: true, not: not}, nil
+		}
+		return nil, p.unexpected("expected NULL or MISSING")
+	}
+
+	not := p.accept("NOT")
+	switch {
+	case p.accept("LIKE"):
+		pattern, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		like := &likeExpr{x: left, pattern: pattern, not: not}
+		if p.accept("ESCAPE") {
+			if like.escape, err = p.parseAdditive(); err != nil {
+				return nil, err
+			}
+		}
+		return like, nil
+
+	case p.accept("BETWEEN"):
+		lo, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("AND"); err != nil {
+			return nil, err
+		}
+		hi, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return &betweenExpr{x: left, lo: lo, hi: hi, not: not}, nil
+
+	case p.accept("IN"):
+		list, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		return &inExpr{x: left, list: list, not: not}, nil
+	}
+	if not {
+		return nil, p.unexpected("expected LIKE, BETWEEN or IN after NOT")
+	}
+	return left, nil
+}
+
+// parseList parses a parenthesized, comma separated list of expressions
+func (p *parser) parseList() ([]expr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var list []expr
+	if p.accept(")") {
+		return list, nil
+	}
+	for {
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+		if p.accept(")") {
+			return list, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseAdditive() (expr, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if !t.is("+") && !t.is("-") && !t.is("||") {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: t.text, l: left, r: right}
+	}
+}
+
+func (p *parser) parseMultiplicative() (expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if !t.is("*") && !t.is("/") && !t.is("%") {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: t.text, l: left, r: right}
+	}
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	if p.accept("-") {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if lit, ok := x.(*literalExpr); ok && lit.v.isNumber() {
+			lit.v.i, lit.v.f = -lit.v.i, -lit.v.f
+			return lit, nil
+		}
+		return &unaryExpr{op: "-", x: x}, nil
+	}
+	if p.accept("+") {
+		return p.parseUnary()
+	}
+	return p.parsePrimary()
+}
+
+// parsePrimary parses a literal, parenthesized expression, function call
+// or path
+func (p *parser) parsePrimary() (expr, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokNumber:
+		p.pos++
+		if i, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return &literalExpr{v: intValue(i)}, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, errParse(t.pos, "invalid number %q", t.text)
+		}
+		return &literalExpr{v: floatValue(f)}, nil
+
+	case tokString:
+		p.pos++
+		return &literalExpr{v: stringValue(t.text)}, nil
+
+	case tokTimestamp:
+		p.pos++
+		ts, ok := parseTimestamp(t.text)
+		if !ok {
+			return nil, errParse(t.pos, "invalid timestamp `%s`", t.text)
+		}
+		return &literalExpr{v: timeValue(ts)}, nil
+
+	case tokQuoted:
+		return p.parsePath()
+
+	case tokIdent:
+		switch strings.ToUpper(t.text) {
+		case "TRUE", "FALSE":
+			p.pos++
+			return &literalExpr{v: boolValue(strings.EqualFold(t.text, "TRUE"))}, nil
+		case "NULL":
+			p.pos++
+			return &literalExpr{v: nullValue()}, nil
+		case "MISSING":
+			p.pos++
+			return &literalExpr{v: Value{}}, nil
+		}
+		if reserved[strings.ToUpper(t.text)] {
+			return nil, p.unexpected("expected an expression")
+		}
+		if p.tokens[p.pos+1].is("(") {
+			return p.parseCall()
+		}
+		return p.parsePath()
+
+	case tokOp:
+		if p.accept("(") {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expect(")")
+		}
+	}
+	return nil, p.unexpected("expected an expression")
+}
+
+// parsePath parses a column reference: a name followed by .name and [n]
+// steps, a name being an identifier or "quoted"
+func (p *parser) parsePath() (expr, error) {
+	t := p.next()
+	path := &pathExpr{steps: []pathStep{{name: t.text, quoted: t.kind == tokQuoted}}}
+	steps, err := p.parsePathSteps(false)
+	if err != nil {
+		return nil, err
+	}
+	path.steps = append(path.steps, steps...)
+	p.paths = append(p.paths, path)
+	return path, nil
+}
+
+// parsePathSteps parses the .name, [n] and, when wildcards are allowed as
+// in FROM, [*] steps of a path
+func (p *parser) parsePathSteps(wildcards bool) ([]pathStep, error) {
+	var steps []pathStep
+	for {
+		switch {
+		case p.accept("."):
+			t := p.next()
+			if t.kind != tokIdent && t.kind != tokQuoted {
+				p.pos--
+				return nil, p.unexpected("expected a field name")
+			}
+			steps = append(steps, pathStep{name: t.text, quoted: t.kind == tokQuoted})
+
+		case p.accept("["):
+			t := p.next()
+			switch {
+			case t.is("*") && wildcards:
+				steps = append(steps, pathStep{wildcard: true})
+			case t.kind == tokString:
+				steps = append(steps, pathStep{name: t.text, quoted: true})
+			case t.kind == tokNumber:
+				n, err := strconv.Atoi(t.text)
+				if err != nil || n < 0 {
+					return nil, errParse(t.pos, "invalid array index %q", t.text)
+				}
+				steps = append(steps, pathStep{index: n, isIndex: true})
+			default:
+				p.pos--
+				return nil, p.unexpected("expected an array index")
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+
+		default:
+			return steps, nil
+		}
+	}
+}
+
+// datePartFunctions take a date part keyword as their first argument
+var datePartFunctions = map[string]bool{"DATE_ADD": true, "DATE_DIFF": true}
+
+// parseCall parses a function call, including the special argument syntax
+// of CAST, EXTRACT, TRIM and SUBSTRING and the aggregate functions
+func (p *parser) parseCall() (expr, error) {
+	nameTok := p.next()
+	name := strings.ToUpper(nameTok.text)
+	p.pos++ // (
+
+	switch name {
+	case "COUNT", "SUM", "AVG", "MIN", "MAX":
+		agg := &aggregateExpr{fn: name}
+		if name != "COUNT" || !p.accept("*") {
+			x, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			agg.x = x
+		}
+		return agg, p.expect(")")
+
+	case "CAST":
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("AS"); err != nil {
+			return nil, err
+		}
+		t := p.next()
+		typ, ok := castTypes[strings.ToUpper(t.text)]
+		if t.kind != tokIdent || !ok {
+			return nil, errParse(t.pos, "unsupported CAST type %q", t.text)
+		}
+		return &castExpr{x: x, typ: typ}, p.expect(")")
+
+	case "EXTRACT":
+		part := p.next()
+		if part.kind != tokIdent {
+			return nil, errParse(part.pos, "expected a date part, found %q", part.text)
+		}
+		if err := p.expect("FROM"); err != nil {
+			return nil, err
+		}
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		return newCall(name, nameTok.pos, []expr{&literalExpr{v: stringValue(strings.ToUpper(part.text))}, x}, p.expect(")"))
+
+	case "TRIM":
+		// TRIM([[LEADING|TRAILING|BOTH] [chars] FROM] x)
+		mode, chars := "BOTH", expr(&literalExpr{v: stringValue(" ")})
+		explicit := false
+		for _, m := range []string{"LEADING", "TRAILING", "BOTH"} {
+			if p.accept(m) {
+				mode, explicit = m, true
+				break
+			}
+		}
+		var x expr
+		if !explicit || !p.accept("FROM") {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if !p.accept("FROM") {
+				if explicit {
+					return nil, p.unexpected("expected FROM")
+				}
+				x = e
+			} else {
+				chars = e
+			}
+		}
+		if x == nil {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			x = e
+		}
+		return newCall(name, nameTok.pos, []expr{&literalExpr{v: stringValue(mode)}, chars, x}, p.expect(")"))
+
+	case "SUBSTRING":
+		// SUBSTRING(x FROM start [FOR length]) or SUBSTRING(x, start [, length])
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		args := []expr{x}
+		if p.accept("FROM") {
+			start, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, start)
+			if p.accept("FOR") {
+				length, err := p.parseExpr()
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, length)
+			}
+			return newCall(name, nameTok.pos, args, p.expect(")"))
+		}
+		for p.accept(",") {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, e)
+		}
+		return newCall(name, nameTok.pos, args, p.expect(")"))
+	}
+
+	var args []expr
+	if datePartFunctions[name] {
+		part := p.next()
+		if part.kind != tokIdent {
+			return nil, errParse(part.pos, "expected a date part, found %q", part.text)
+		}
+		args = append(args, &literalExpr{v: stringValue(strings.ToUpper(part.text))})
+		if !p.peek().is(")") {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !p.accept(")") {
+		for {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, e)
+			if p.accept(")") {
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return newCall(name, nameTok.pos, args, nil)
+}
+
+// newCall resolves a function call, passing on err from parsing it
+func newCall(name string, pos int, args []expr, err error) (expr, error) {
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := functions[name]
+	if !ok {
+		return nil, &Error{Code: "UnsupportedFunction", Message: "unsupported function " + name}
+	}
+	if len(args) < fn.minArgs || fn.maxArgs >= 0 && len(args) > fn.maxArgs {
+		return nil, errParse(pos, "wrong number of arguments to %s", name)
+	}
+	return &callExpr{name: name, fn: fn, args: args}, nil
+}
@@ -0,0 +1,507 @@
+package s3select
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxRecordSize bounds the size of an input record, so that a missing
+// delimiter cannot make a reader buffer the whole object
+const maxRecordSize = 1 << 20
+
+// errRecordSize is returned for a record longer than maxRecordSize
+var errRecordSize = &Error{Code: "OverMaxRecordSize", Message: "the length of a record in the input exceeds 1 MB"}
+
+// recordReader reads the records of an object one at a time, returning
+// io.EOF after the last
+type recordReader interface {
+	Read() (Value, error)
+}
+
+// newRecordReader returns a reader of the records of r in the input format
+func newRecordReader(in InputSerialization, r io.Reader) (recordReader, error) {
+	switch in.Format {
+	case FormatCSV:
+		c, err := newCSVReader(in.CSV, r)
+		if err != nil {
+			return nil, err
+		}
+		return c, c.readHeader()
+	case FormatJSON:
+		lines, err := jsonLines(in.JSON)
+		if err != nil {
+			return nil, err
+		}
+		if lines {
+			return &jsonLinesReader{r: bufio.NewReader(r)}, nil
+		}
+		dec := json.NewDecoder(r)
+		dec.UseNumber()
+		return &jsonReader{dec: dec}, nil
+	}
+	return nil, &Error{Code: "InvalidRequestParameter", Message: "input serialization must be CSV or JSON"}
+}
+
+// jsonLines reports whether JSON input is in LINES rather than DOCUMENT
+// mode, the default
+func jsonLines(opts *JSONInput) (bool, error) {
+	if opts == nil {
+		return false, nil
+	}
+	switch strings.ToUpper(opts.Type) {
+	case "", "DOCUMENT":
+		return false, nil
+	case "LINES":
+		return true, nil
+	}
+	return false, &Error{Code: "InvalidJsonType", Message: "JSON Type must be DOCUMENT or LINES"}
+}
+
+// csvReader reads CSV records as objects keyed by the header names, or by
+// _1, _2... without a header. Quoted fields may hold the field delimiter
+// and, with quotedRecords, the record delimiter.
+type csvReader struct {
+	r             *bufio.Reader
+	field, record []byte
+	quote, escape []byte
+	comment       []byte
+	quotedRecords bool
+	headerInfo    string
+	header        []string
+	buf           []byte
+	size          int // bytes of the current record
+	err           error
+}
+
+func newCSVReader(opts *CSVInput, r io.Reader) (*csvReader, error) {
+	if opts == nil {
+		opts = &CSVInput{}
+	}
+	c := &csvReader{
+		r:             bufio.NewReader(r),
+		field:         []byte(","),
+		record:        []byte("\n"),
+		quote:         []byte(`"`),
+		comment:       []byte(opts.CommentCharacter),
+		quotedRecords: opts.AllowQuotedRecordDelimiter,
+		headerInfo:    strings.ToUpper(opts.FileHeaderInfo),
+	}
+	if opts.FieldDelimiter != "" {
+		c.field = []byte(opts.FieldDelimiter)
+	}
+	if opts.RecordDelimiter != "" {
+		c.record = []byte(opts.RecordDelimiter)
+	}
+	if opts.QuoteCharacter != "" {
+		c.quote = []byte(opts.QuoteCharacter)
+	}
+	c.escape = c.quote
+	if opts.QuoteEscapeCharacter != "" {
+		c.escape = []byte(opts.QuoteEscapeCharacter)
+	}
+
+	switch c.headerInfo {
+	case "", "NONE", "USE", "IGNORE":
+	default:
+		return nil, &Error{Code: "InvalidFileHeaderInfo", Message: "FileHeaderInfo must be USE, IGNORE or NONE"}
+	}
+	if bytes.Equal(c.field, c.record) {
+		return nil, &Error{Code: "InvalidRequestParameter", Message: "FieldDelimiter and RecordDelimiter must differ"}
+	}
+	return c, nil
+}
+
+// readHeader consumes the header row of USE and IGNORE, keeping the
+// column names of USE
+func (c *csvReader) readHeader() error {
+	if c.headerInfo != "USE" && c.headerInfo != "IGNORE" {
+		return nil
+	}
+	header, err := c.readFields()
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if c.headerInfo == "USE" {
+		c.header = header
+	}
+	return nil
+}
+
+func (c *csvReader) Read() (Value, error) {
+	fields, err := c.readFields()
+	if err != nil {
+		return Value{}, err
+	}
+	keys := make([]string, len(fields))
+	vals := make([]Value, len(fields))
+	for i, f := range fields {
+		if i < len(c.header) {
+			keys[i] = c.header[i]
+		} else {
+			keys[i] = "_" + strconv.Itoa(i+1)
+		}
+		vals[i] = stringValue(f)
+	}
+	return objectValue(keys, vals), nil
+}
+
+// readFields reads the fields of the next record, skipping blank and
+// comment lines
+func (c *csvReader) readFields() ([]string, error) {
+	for {
+		if _, err := c.r.Peek(1); err != nil {
+			return nil, err
+		}
+		c.size = 0
+		switch {
+		case c.recordEnd():
+			continue
+		case len(c.comment) > 0 && c.skip(c.comment):
+			for !c.recordEnd() && c.err == nil {
+				if _, err := c.r.ReadByte(); err != nil {
+					break
+				}
+			}
+			if c.err != nil {
+				return nil, c.err
+			}
+			continue
+		}
+		break
+	}
+
+	var fields []string
+	for {
+		f, last, err := c.readField()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+		if last {
+			return fields, nil
+		}
+	}
+}
+
+// readField reads one field, reporting whether it ended the record. Text
+// after the closing quote of a field is kept, as is an unterminated quoted
+// field, rather than failing the whole object.
+func (c *csvReader) readField() (string, bool, error) {
+	c.buf = c.buf[:0]
+	if c.skip(c.quote) {
+		if last, err := c.readQuoted(); last || err != nil {
+			return string(c.buf), last, err
+		}
+	}
+	for {
+		switch {
+		case c.skip(c.field):
+			return string(c.buf), false, c.err
+		case c.recordEnd():
+			return string(c.buf), true, c.err
+		}
+		if last, err := c.readByte(); last || err != nil {
+			return string(c.buf), last, err
+		}
+	}
+}
+
+// readQuoted reads a quoted field through its closing quote. Without
+// quotedRecords a record delimiter ends the record even inside quotes.
+func (c *csvReader) readQuoted() (bool, error) {
+	escaped := !bytes.Equal(c.escape, c.quote)
+	for {
+		switch {
+		case !escaped && c.skip(c.quote):
+			if !c.skip(c.quote) {
+				return false, c.err
+			}
+			c.buf = append(c.buf, c.quote...)
+			continue
+		case escaped && c.skip(c.escape):
+			if c.skip(c.quote) {
+				c.buf = append(c.buf, c.quote...)
+			} else {
+				c.buf = append(c.buf, c.escape...)
+			}
+			continue
+		case escaped && c.skip(c.quote):
+			return false, c.err
+		case !c.quotedRecords && c.recordEnd():
+			return true, c.err
+		}
+		if last, err := c.readByte(); last || err != nil {
+			return last, err
+		}
+	}
+}
+
+// readByte appends the next byte to the field, reporting the end of the
+// input as the end of the record
+func (c *csvReader) readByte() (bool, error) {
+	if c.err != nil {
+		return false, c.err
+	}
+	b, err := c.r.ReadByte()
+	if err == io.EOF {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if c.size++; c.size > maxRecordSize {
+		return false, errRecordSize
+	}
+	c.buf = append(c.buf, b)
+	return false, nil
+}
+
+// skip consumes delim if the input continues with it
+func (c *csvReader) skip(delim []byte) bool {
+	if c.err != nil {
+		return false
+	}
+	b, err := c.r.Peek(len(delim))
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		c.err = err
+	}
+	if !bytes.Equal(b, delim) {
+		return false
+	}
+	c.r.Discard(len(delim))
+	c.size += len(delim)
+	return true
+}
+
+// recordEnd consumes a record delimiter; the default \n also accepts \r\n
+func (c *csvReader) recordEnd() bool {
+	if len(c.record) == 1 && c.record[0] == '\n' && c.skip([]byte("\r\n")) {
+		return true
+	}
+	return c.skip(c.record)
+}
+
+// jsonReader reads a stream of JSON values, one record each, keeping the
+// order of object fields
+type jsonReader struct {
+	dec *json.Decoder
+}
+
+func (j *jsonReader) Read() (Value, error) {
+	v, err := decodeJSON(j.dec, j.dec.InputOffset()+maxRecordSize)
+	if err == io.EOF {
+		return Value{}, err
+	}
+	if err != nil {
+		return Value{}, jsonError(err)
+	}
+	return v, nil
+}
+
+// jsonLinesReader reads one JSON value from each line, skipping blank
+// lines
+type jsonLinesReader struct {
+	r *bufio.Reader
+}
+
+func (j *jsonLinesReader) Read() (Value, error) {
+	for {
+		line, err := readLine(j.r)
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return Value{}, err
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.UseNumber()
+		v, err := decodeJSON(dec, maxRecordSize)
+		if err == nil && dec.More() {
+			err = errors.New("more than one value on a line")
+		}
+		if err != nil {
+			return Value{}, jsonError(err)
+		}
+		return v, nil
+	}
+}
+
+// readLine reads through the next newline, up to maxRecordSize bytes
+func readLine(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > maxRecordSize {
+			return nil, errRecordSize
+		}
+		line = append(line, chunk...)
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+func jsonError(err error) error {
+	var selErr *Error
+	if errors.As(err, &selErr) {
+		return err
+	}
+	return &Error{Code: "JSONParsingError", Message: err.Error()}
+}
+
+// decodeJSON decodes the next JSON value token by token, failing once the
+// decoder passes the input offset limit
+func decodeJSON(dec *json.Decoder, limit int64) (Value, error) {
+	if dec.InputOffset() > limit {
+		return Value{}, errRecordSize
+	}
+	tok, err := dec.Token()
+	if err != nil {
+		return Value{}, err
+	}
+	switch t := tok.(type) {
+	case nil:
+		return nullValue(), nil
+	case bool:
+		return boolValue(t), nil
+	case string:
+		return stringValue(t), nil
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return intValue(i), nil
+		}
+		f, err := t.Float64()
+		return floatValue(f), err
+	case json.Delim:
+		switch t {
+		case '[':
+			var arr []Value
+			for dec.More() {
+				v, err := decodeJSON(dec, limit)
+				if err != nil {
+					return Value{}, unexpectedEOF(err)
+				}
+				arr = append(arr, v)
+			}
+			_, err := dec.Token()
+			return arrayValue(arr), unexpectedEOF(err)
+		case '{':
+			var keys []string
+			var vals []Value
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return Value{}, unexpectedEOF(err)
+				}
+				v, err := decodeJSON(dec, limit)
+				if err != nil {
+					return Value{}, unexpectedEOF(err)
+				}
+				keys = append(keys, key.(string))
+				vals = append(vals, v)
+			}
+			_, err := dec.Token()
+			return objectValue(keys, vals), unexpectedEOF(err)
+		}
+	}
+	return Value{}, errors.New("unexpected JSON token")
+}
+
+// unexpectedEOF turns the end of input inside a value into an error
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// recordWriter appends output rows in the output format
+type recordWriter interface {
+	append(buf []byte, row Value) []byte
+}
+
+// newRecordWriter returns a writer of the output format
+func newRecordWriter(out OutputSerialization) (recordWriter, error) {
+	switch out.Format {
+	case OutputJSON:
+		w := &jsonWriter{delimiter: "\n"}
+		if out.JSON != nil && out.JSON.RecordDelimiter != "" {
+			w.delimiter = out.JSON.RecordDelimiter
+		}
+		return w, nil
+	case OutputCSV, "":
+		w := &csvWriter{fieldDelimiter: ",", recordDelimiter: "\n", quote: `"`}
+		if opts := out.CSV; opts != nil {
+			if opts.FieldDelimiter != "" {
+				w.fieldDelimiter = opts.FieldDelimiter
+			}
+			if opts.RecordDelimiter != "" {
+				w.recordDelimiter = opts.RecordDelimiter
+			}
+			if opts.QuoteCharacter != "" {
+				w.quote = opts.QuoteCharacter
+			}
+			w.escape = opts.QuoteEscapeCharacter
+			switch strings.ToUpper(opts.QuoteFields) {
+			case "ALWAYS":
+				w.always = true
+			case "", "ASNEEDED":
+			default:
+				return nil, &Error{Code: "InvalidQuoteFields", Message: "QuoteFields must be ALWAYS or ASNEEDED"}
+			}
+		}
+		if w.escape == "" {
+			w.escape = w.quote
+		}
+		return w, nil
+	}
+	return nil, &Error{Code: "InvalidRequestParameter", Message: "output serialization must be CSV or JSON"}
+}
+
+// csvWriter writes the fields of a row, quoting those that hold a
+// delimiter or quote, or all of them when always is set
+type csvWriter struct {
+	fieldDelimiter, recordDelimiter string
+	quote, escape                   string
+	always                          bool
+}
+
+func (w *csvWriter) append(buf []byte, row Value) []byte {
+	fields := []Value{row}
+	if row.kind == kindObject {
+		fields = row.obj.vals
+	}
+	for i, f := range fields {
+		if i > 0 {
+			buf = append(buf, w.fieldDelimiter...)
+		}
+		s := f.String()
+		if !w.always && !strings.Contains(s, w.fieldDelimiter) && !strings.Contains(s, w.recordDelimiter) &&
+			!strings.Contains(s, w.quote) && !strings.ContainsAny(s, "\r\n") {
+			buf = append(buf, s...)
+			continue
+		}
+		buf = append(buf, w.quote...)
+		buf = append(buf, strings.ReplaceAll(s, w.quote, w.escape+w.quote)...)
+		buf = append(buf, w.quote...)
+	}
+	return append(buf, w.recordDelimiter...)
+}
+
+// jsonWriter writes each row as a JSON object; a row that is not an
+// object, as when selecting * from scalar records, is keyed _1
+type jsonWriter struct {
+	delimiter string
+}
+
+func (w *jsonWriter) append(buf []byte, row Value) []byte {
+	if row.kind != kindObject {
+		row = objectValue([]string{"_1"}, []Value{row})
+	}
+	return append(row.appendJSON(buf), w.delimiter...)
+}
@@ -2,9 +2,11 @@ package s3select
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -25,89 +27,84 @@ func TestNewSelectService(t *testing.T) {
 	}
 }
 
-func TestNewEvaluator(t *testing.T) {
-	logger := zap.NewNop()
-	ast := &AST{Columns: []string{"*"}}
-	evaluator := NewEvaluator(ast, FormatJSON, logger)
-	if evaluator == nil {
-		t.Fatal("Evaluator should not be nil")
-	}
-}
-
 func TestParserParseValid(t *testing.T) {
 	logger := zap.NewNop()
 	parser := NewParser(logger)
 
 	tests := []struct {
-		sql       string
-		wantCols  []string
-		wantWhere string
-		wantLimit int64
+		sql           string
+		wantNames     []string
+		wantWhere     bool
+		wantLimit     int64
+		wantAggregate bool
 	}{
-		{"SELECT * FROM s3object", []string{"*"}, "", 0},
-		{"SELECT name, age FROM s3object", []string{"name", " age"}, "", 0},
-		{"SELECT * FROM s3object WHERE age > 18", []string{"*"}, "age > 18", 0},
-		{"SELECT * FROM s3object LIMIT 10", []string{"*"}, "", 10},
-		{"SELECT * FROM s3object WHERE age > 18 LIMIT 5", []string{"*"}, "age > 18 LIMIT 5", 5},
+		{"SELECT * FROM s3object", nil, false, -1, false},
+		{"SELECT name, age FROM s3object", []string{"name", "age"}, false, -1, false},
+		{"SELECT * FROM s3object WHERE age > 18", nil, true, -1, false},
+		{"SELECT * FROM s3object LIMIT 10", nil, false, 10, false},
+		{"SELECT * FROM s3object WHERE age > 18 LIMIT 5", nil, true, 5, false},
+		{"select s.name AS n, s._2, s.a.b[0] from S3Object s where s.x like 'a%'", []string{"n", "_2", "b"}, true, -1, false},
+		{`SELECT "Name", UPPER(s.city) FROM S3Object[*].people s`, []string{"Name", ""}, false, -1, false},
+		{"SELECT s.* FROM S3Object s", nil, false, -1, false},
+		{"SELECT COUNT(*), AVG(CAST(price AS FLOAT)) total FROM S3Object", []string{"", "total"}, false, -1, true},
 	}
 
 	for _, tt := range tests {
-		ast, err := parser.Parse(tt.sql)
+		q, err := parser.Parse(tt.sql)
 		if err != nil {
 			t.Errorf("Parse(%q) failed: %v", tt.sql, err)
 			continue
 		}
 
-		if len(ast.Columns) != len(tt.wantCols) {
-			t.Errorf("Parse(%q) columns count = %d, want %d", tt.sql, len(ast.Columns), len(tt.wantCols))
+		var names []string
+		for _, item := range q.items {
+			names = append(names, item.name)
 		}
-
-		if ast.WhereClause != tt.wantWhere {
-			t.Errorf("Parse(%q) WhereClause = %q, want %q", tt.sql, ast.WhereClause, tt.wantWhere)
+		if strings.Join(names, ",") != strings.Join(tt.wantNames, ",") || (q.items == nil) != (tt.wantNames == nil) {
+			t.Errorf("Parse(%q) names = %q, want %q", tt.sql, names, tt.wantNames)
 		}
-
-		if ast.Limit != tt.wantLimit {
-			t.Errorf("Parse(%q) Limit = %d, want %d", tt.sql, ast.Limit, tt.wantLimit)
+		if (q.where != nil) != tt.wantWhere {
+			t.Errorf("Parse(%q) has WHERE = %v, want %v", tt.sql, q.where != nil, tt.wantWhere)
 		}
-	}
-}
-
-func TestParserParseInvalid(t *testing.T) {
-	logger := zap.NewNop()
-	parser := NewParser(logger)
-
-	tests := []string{
-		"INVALID SQL",
-		"SELECT *",
-		"",
-	}
-
-	for _, sql := range tests {
-		_, err := parser.Parse(sql)
-		if err == nil {
-			t.Errorf("Parse(%q) should fail", sql)
+		if q.limit != tt.wantLimit {
+			t.Errorf("Parse(%q) limit = %d, want %d", tt.sql, q.limit, tt.wantLimit)
+		}
+		if q.aggregate != tt.wantAggregate {
+			t.Errorf("Parse(%q) aggregate = %v, want %v", tt.sql, q.aggregate, tt.wantAggregate)
 		}
 	}
 }
 
-func TestParseColumns(t *testing.T) {
+func TestParserParseInvalid(t *testing.T) {
 	logger := zap.NewNop()
 	parser := NewParser(logger)
 
 	tests := []struct {
-		input string
-		want  int
+		sql      string
+		wantCode string
 	}{
-		{"*", 1},
-		{"name", 1},
-		{"name,age", 2},
-		{"name, age, city", 3},
+		{"INVALID SQL", "ParseUnexpectedToken"},
+		{"SELECT *", "ParseUnexpectedToken"},
+		{"", "ParseUnexpectedToken"},
+		{"SELECT * FROM other", "InvalidDataSource"},
+		{"SELECT * FROM S3Object WHERE", "ParseUnexpectedToken"},
+		{"SELECT * FROM S3Object WHERE a = 'open", "ParseUnexpectedToken"},
+		{"SELECT * FROM S3Object LIMIT x", "ParseUnexpectedToken"},
+		{"SELECT * FROM S3Object WHERE a NOT = 1", "ParseUnexpectedToken"},
+		{"SELECT a FROM S3Object extra tokens", "ParseUnexpectedToken"},
+		{"SELECT CAST(a AS BLOB) FROM S3Object", "ParseUnexpectedToken"},
+		{"SELECT SOUNDEX(a) FROM S3Object", "UnsupportedFunction"},
+		{"SELECT LOWER(a, b) FROM S3Object", "ParseUnexpectedToken"},
+		{"SELECT name, COUNT(*) FROM S3Object", "UnsupportedSqlStructure"},
+		{"SELECT SUM(COUNT(*)) FROM S3Object", "UnsupportedSqlStructure"},
+		{"SELECT * FROM S3Object WHERE COUNT(*) > 1", "UnsupportedSqlStructure"},
 	}
 
 	for _, tt := range tests {
-		cols := parser.parseColumns(tt.input)
-		if len(cols) != tt.want {
-			t.Errorf("parseColumns(%q) returned %d columns, want %d", tt.input, len(cols), tt.want)
+		_, err := parser.Parse(tt.sql)
+		var selErr *Error
+		if !errors.As(err, &selErr) || selErr.Code != tt.wantCode {
+			t.Errorf("Parse(%q) error = %v, want %s", tt.sql, err, tt.wantCode)
 		}
 	}
 }
@@ -142,168 +139,6 @@ func TestExpressionTypeConstants(t *testing.T) {
 	}
 }
 
-func TestEvaluateJSON(t *testing.T) {
-	logger := zap.NewNop()
-	ast := &AST{Columns: []string{"*"}}
-	evaluator := NewEvaluator(ast, FormatJSON, logger)
-
-	jsonData := `{"name":"John","age":30}
-{"name":"Jane","age":25}`
-
-	result, err := evaluator.Evaluate(context.Background(), strings.NewReader(jsonData))
-	if err != nil {
-		t.Fatalf("Evaluate failed: %v", err)
-	}
-
-	if result == nil {
-		t.Fatal("result should not be nil")
-	}
-
-	if result.Stats == nil {
-		t.Fatal("result.Stats should not be nil")
-	}
-
-	if result.Stats.RecordsReturned != 2 {
-		t.Errorf("RecordsReturned = %d, want 2", result.Stats.RecordsReturned)
-	}
-}
-
-func TestEvaluateJSONWithLimit(t *testing.T) {
-	logger := zap.NewNop()
-	ast := &AST{Columns: []string{"*"}, Limit: 1}
-	evaluator := NewEvaluator(ast, FormatJSON, logger)
-
-	jsonData := `{"name":"John","age":30}
-{"name":"Jane","age":25}`
-
-	result, err := evaluator.Evaluate(context.Background(), strings.NewReader(jsonData))
-	if err != nil {
-		t.Fatalf("Evaluate failed: %v", err)
-	}
-
-	if result.Stats.RecordsReturned != 1 {
-		t.Errorf("RecordsReturned = %d, want 1 (limit)", result.Stats.RecordsReturned)
-	}
-}
-
-func TestEvaluateCSV(t *testing.T) {
-	logger := zap.NewNop()
-	ast := &AST{Columns: []string{"*"}}
-	evaluator := NewEvaluator(ast, FormatCSV, logger)
-
-	csvData := `name,age
-John,30
-Jane,25`
-
-	result, err := evaluator.Evaluate(context.Background(), strings.NewReader(csvData))
-	if err != nil {
-		t.Fatalf("Evaluate failed: %v", err)
-	}
-
-	if result.Stats.RecordsReturned != 2 {
-		t.Errorf("RecordsReturned = %d, want 2", result.Stats.RecordsReturned)
-	}
-}
-
-func TestEvaluateCSVWithColumns(t *testing.T) {
-	logger := zap.NewNop()
-	ast := &AST{Columns: []string{"name"}}
-	evaluator := NewEvaluator(ast, FormatCSV, logger)
-
-	csvData := `name,age
-John,30
-Jane,25`
-
-	result, err := evaluator.Evaluate(context.Background(), strings.NewReader(csvData))
-	if err != nil {
-		t.Fatalf("Evaluate failed: %v", err)
-	}
-
-	if result.Stats.RecordsReturned != 2 {
-		t.Errorf("RecordsReturned = %d, want 2", result.Stats.RecordsReturned)
-	}
-}
-
-func TestSelectColumnsAll(t *testing.T) {
-	logger := zap.NewNop()
-	ast := &AST{Columns: []string{"*"}}
-	evaluator := NewEvaluator(ast, FormatJSON, logger)
-
-	record := map[string]interface{}{"name": "John", "age": 30.0}
-	result := evaluator.selectColumns(record)
-
-	if !strings.Contains(result, "John") {
-		t.Errorf("selectColumns(*) should contain 'John', got %s", result)
-	}
-}
-
-func TestSelectColumnsSpecific(t *testing.T) {
-	logger := zap.NewNop()
-	ast := &AST{Columns: []string{"name"}}
-	evaluator := NewEvaluator(ast, FormatJSON, logger)
-
-	record := map[string]interface{}{"name": "John", "age": 30.0}
-	result := evaluator.selectColumns(record)
-
-	if result != "John" {
-		t.Errorf("selectColumns(name) = %s, want John", result)
-	}
-}
-
-func TestSelectColumnsMissing(t *testing.T) {
-	logger := zap.NewNop()
-	ast := &AST{Columns: []string{"missing"}}
-	evaluator := NewEvaluator(ast, FormatJSON, logger)
-
-	record := map[string]interface{}{"name": "John"}
-	result := evaluator.selectColumns(record)
-
-	if result != "" {
-		t.Errorf("selectColumns(missing) = %s, want empty", result)
-	}
-}
-
-func TestFormatOutputJSON(t *testing.T) {
-	logger := zap.NewNop()
-	ast := &AST{Columns: []string{"*"}}
-	evaluator := NewEvaluator(ast, FormatJSON, logger)
-
-	records := []string{`{"name":"John"}`, `{"name":"Jane"}`}
-	output, err := evaluator.formatOutput(records)
-	if err != nil {
-		t.Fatalf("formatOutput failed: %v", err)
-	}
-
-	if len(output) == 0 {
-		t.Error("output should not be empty")
-	}
-}
-
-func TestFormatOutputCSV(t *testing.T) {
-	logger := zap.NewNop()
-	ast := &AST{Columns: []string{"*"}}
-	evaluator := NewEvaluator(ast, FormatCSV, logger)
-
-	records := []string{"John,30", "Jane,25"}
-	output, err := evaluator.formatOutput(records)
-	if err != nil {
-		t.Fatalf("formatOutput failed: %v", err)
-	}
-
-	if len(output) == 0 {
-		t.Error("output should not be empty")
-	}
-}
-
-func TestEstimateRecordSize(t *testing.T) {
-	record := map[string]interface{}{"name": "John", "age": 30.0}
-	size := estimateRecordSize(record)
-
-	if size <= 0 {
-		t.Errorf("estimateRecordSize = %d, want > 0", size)
-	}
-}
-
 func TestSelectServiceExecute(t *testing.T) {
 	logger := zap.NewNop()
 	svc := NewSelectService(logger)
@@ -424,174 +259,238 @@ func TestOutputSerialization(t *testing.T) {
 	}
 }
 
-func TestEvaluateEmptyInput(t *testing.T) {
-	logger := zap.NewNop()
-	ast := &AST{Columns: []string{"*"}}
-	evaluator := NewEvaluator(ast, FormatJSON, logger)
-
-	result, err := evaluator.Evaluate(context.Background(), strings.NewReader(""))
-	if err != nil {
-		t.Fatalf("Evaluate failed: %v", err)
-	}
+// peopleCSV is a CSV object with a header row
+const peopleCSV = `name,age,city,joined
+Alice,30,NYC,2020-01-15T00:00:00Z
+Bob,25,LA,2019-06-01T00:00:00Z
+Carol,35,,2021-03-10T12:30:00Z
+Dan,40,Chicago,2018-11-20T00:00:00Z
+`
 
-	if result.Stats.RecordsReturned != 0 {
-		t.Errorf("RecordsReturned = %d, want 0", result.Stats.RecordsReturned)
-	}
-}
+// usersJSON is a JSON Lines object with nested, null and missing fields
+const usersJSON = `{"id":1,"user":{"name":"ann","tags":["a","b"]},"score":9.5}
+{"id":2,"user":{"name":"ben","tags":[]},"score":null}
+{"id":3,"user":{"name":"cat"}}
+`
 
-func TestParseColumnsErrorPath(t *testing.T) {
-	logger := zap.NewNop()
-	parser := NewParser(logger)
-
-	col := parser.parseColumns("\"unclosed")
-	if len(col) != 1 || col[0] != "\"unclosed" {
-		t.Errorf("parseColumns with unclosed quote should return single column, got %v", col)
-	}
-}
-
-type errorReader struct{}
-
-func (e *errorReader) Read(p []byte) (n int, err error) {
-	return 0, fmt.Errorf("read error")
-}
-
-func TestEvaluateCSVHeaderError(t *testing.T) {
-	logger := zap.NewNop()
-	ast := &AST{Columns: []string{"*"}}
-	evaluator := NewEvaluator(ast, FormatCSV, logger)
+var (
+	csvWithHeader = InputSerialization{Format: FormatCSV, CSV: &CSVInput{FileHeaderInfo: "USE"}}
+	jsonInput     = InputSerialization{Format: FormatJSON, JSON: &JSONInput{Type: "LINES"}}
+	csvOutput     = OutputSerialization{Format: OutputCSV}
+	jsonOutput    = OutputSerialization{Format: OutputJSON}
+)
 
-	_, err := evaluator.Evaluate(context.Background(), &errorReader{})
-	if err == nil {
-		t.Error("Evaluate should fail for CSV with read error")
-	}
-	if !strings.Contains(err.Error(), "failed to read CSV headers") {
-		t.Errorf("Error should contain 'failed to read CSV headers', got %v", err)
+// runSelect executes sql over data, returning the output records
+func runSelect(in InputSerialization, out OutputSerialization, sql, data string) (string, error) {
+	svc := NewSelectService(zap.NewNop())
+	result, err := svc.Execute(context.Background(), &SelectRequest{
+		Expression:          sql,
+		ExpressionType:      ExpressionTypeSQL,
+		InputSerialization:  in,
+		OutputSerialization: out,
+	}, strings.NewReader(data))
+	if err != nil {
+		return "", err
 	}
+	return string(result.Payload), nil
 }
 
-func TestFormatOutputDefault(t *testing.T) {
-	logger := zap.NewNop()
-	ast := &AST{Columns: []string{"*"}}
-	evaluator := NewEvaluator(ast, FormatParquet, logger)
-
-	records := []string{"John,30", "Jane,25"}
-	output, err := evaluator.formatOutput(records)
-	if err != nil {
-		t.Fatalf("formatOutput failed: %v", err)
+func TestSelectQueries(t *testing.T) {
+	tests := []struct {
+		name string
+		in   InputSerialization
+		out  OutputSerialization
+		sql  string
+		data string
+		want string
+	}{
+		{"Star", csvWithHeader, csvOutput, "SELECT * FROM S3Object LIMIT 1", peopleCSV, "Alice,30,NYC,2020-01-15T00:00:00Z\n"},
+		{"Cast", csvWithHeader, csvOutput, "SELECT name FROM S3Object s WHERE CAST(s.age AS INT) > 28", peopleCSV, "Alice\nCarol\nDan\n"},
+		{"AndNotEqual", csvWithHeader, csvOutput, "SELECT s.name FROM S3Object s WHERE s.age > 28 AND s.city <> 'NYC'", peopleCSV, "Carol\nDan\n"},
+		{"NotOr", csvWithHeader, csvOutput, "SELECT name FROM S3Object WHERE NOT (age < 30 OR age >= 40)", peopleCSV, "Alice\nCarol\n"},
+		{"Like", csvWithHeader, csvOutput, "SELECT name FROM S3Object WHERE name LIKE '_a%'", peopleCSV, "Carol\nDan\n"},
+		{"NotLike", csvWithHeader, csvOutput, "SELECT name FROM S3Object WHERE name NOT LIKE '%o%'", peopleCSV, "Alice\nDan\n"},
+		{"LikeEscape", csvWithHeader, csvOutput, `SELECT name FROM S3Object WHERE name || '%' LIKE '%!%' ESCAPE '!' AND name LIKE 'B__'`, peopleCSV, "Bob\n"},
+		{"In", csvWithHeader, csvOutput, "SELECT name FROM S3Object WHERE city IN ('LA', 'Chicago')", peopleCSV, "Bob\nDan\n"},
+		{"Between", csvWithHeader, csvOutput, "SELECT name FROM S3Object WHERE age BETWEEN 25 AND 30", peopleCSV, "Alice\nBob\n"},
+		{"NotBetween", csvWithHeader, csvOutput, "SELECT name FROM S3Object WHERE age NOT BETWEEN 25 AND 30", peopleCSV, "Carol\nDan\n"},
+		{"Positional", csvWithHeader, csvOutput, "SELECT _1, s._3 FROM S3Object s WHERE _2 = 25", peopleCSV, "Bob,LA\n"},
+		{"NoHeader", InputSerialization{Format: FormatCSV}, csvOutput, "SELECT s._2 FROM S3Object s WHERE s._1 = 'b'", "a,1\nb,2\n", "2\n"},
+		{"IgnoreHeader", InputSerialization{Format: FormatCSV, CSV: &CSVInput{FileHeaderInfo: "IGNORE"}}, csvOutput, "SELECT _1 FROM S3Object LIMIT 1", peopleCSV, "Alice\n"},
+		{"QuotedName", csvWithHeader, csvOutput, `SELECT "name", "NAME", NAME FROM S3Object LIMIT 1`, peopleCSV, "Alice,,Alice\n"},
+		{"FieldDelimiter", InputSerialization{Format: FormatCSV, CSV: &CSVInput{FileHeaderInfo: "USE", FieldDelimiter: ";"}}, csvOutput, "SELECT b FROM S3Object", "a;b\n1;2\n", "2\n"},
+		{"Limit", csvWithHeader, csvOutput, "SELECT name FROM S3Object LIMIT 2", peopleCSV, "Alice\nBob\n"},
+		{"LimitZero", csvWithHeader, csvOutput, "SELECT name FROM S3Object LIMIT 0", peopleCSV, ""},
+
+		{"StringFunctions", csvWithHeader, csvOutput, "SELECT UPPER(name), LOWER(city), CHAR_LENGTH(name), CHARACTER_LENGTH('héllo') FROM S3Object LIMIT 1", peopleCSV, "ALICE,nyc,5,5\n"},
+		{"Trim", csvWithHeader, csvOutput, "SELECT TRIM(LEADING 'x' FROM 'xxabcx'), TRIM(TRAILING FROM ' abc '), TRIM('  pad  '), TRIM(BOTH 'ab' FROM 'abcba') FROM S3Object LIMIT 1", peopleCSV, "abcx, abc,pad,c\n"},
+		{"Substring", csvWithHeader, csvOutput, "SELECT SUBSTRING(name FROM 2 FOR 3), SUBSTRING(name, 0, 3), SUBSTRING(name, 4), SUBSTRING(name, -4, 2) FROM S3Object LIMIT 1", peopleCSV, "lic,Al,ce,\n"},
+		{"CoalesceNullIf", csvWithHeader, csvOutput, "SELECT COALESCE(NULLIF(city, ''), 'none') FROM S3Object", peopleCSV, "NYC\nLA\nnone\nChicago\n"},
+		{"Arithmetic", csvWithHeader, csvOutput, "SELECT name || '-' || city, age * 2 + 1, age / 4, age % 7, age / 4.0, -age FROM S3Object LIMIT 1", peopleCSV, "Alice-NYC,61,7,2,7.5,-30\n"},
+		{"CastTypes", csvWithHeader, csvOutput, "SELECT CAST(age AS FLOAT) / 4, CAST('true' AS BOOL), CAST(3.9 AS INT), CAST(age AS STRING) || '!' FROM S3Object LIMIT 1", peopleCSV, "7.5,true,3,30!\n"},
+		{"DateFunctions", csvWithHeader, csvOutput, "SELECT EXTRACT(YEAR FROM TO_TIMESTAMP(joined)), TO_STRING(TO_TIMESTAMP(joined), 'yyyy/MM/dd HH:mm'), DATE_ADD(month, 2, TO_TIMESTAMP(joined)) FROM S3Object WHERE name = 'Carol'", peopleCSV, "2021,2021/03/10 12:30,2021-05-10T12:30:00Z\n"},
+		{"DateDiff", csvWithHeader, csvOutput, "SELECT DATE_DIFF(year, TO_TIMESTAMP(joined), `2021-01-14T`), DATE_DIFF(month, TO_TIMESTAMP(joined), `2021-01-15T`), DATE_DIFF(day, TO_TIMESTAMP(joined), `2020-02-15T`), DATE_DIFF(hour, `2020-01-15T`, TO_TIMESTAMP(joined)) FROM S3Object LIMIT 1", peopleCSV, "0,12,31,0\n"},
+		{"TimestampCompare", csvWithHeader, csvOutput, "SELECT name FROM S3Object WHERE TO_TIMESTAMP(joined) < `2020-01-01T`", peopleCSV, "Bob\nDan\n"},
+
+		{"Aggregates", csvWithHeader, csvOutput, "SELECT COUNT(*), SUM(age), AVG(age), MIN(age), MAX(name) FROM S3Object", peopleCSV, "4,130,32.5,25,Dan\n"},
+		{"AggregateExpression", csvWithHeader, csvOutput, "SELECT MAX(age) - MIN(age), COUNT(city) FROM S3Object WHERE city <> ''", peopleCSV, "15,3\n"},
+		{"AggregateNoRows", csvWithHeader, csvOutput, "SELECT COUNT(*), SUM(age) FROM S3Object WHERE age > 100", peopleCSV, "0,\n"},
+
+		{"CSVQuoting", csvWithHeader, csvOutput, `SELECT name, 'a,b', 'say "hi"' FROM S3Object LIMIT 1`, peopleCSV, "Alice,\"a,b\",\"say \"\"hi\"\"\"\n"},
+		{"CSVOutputOptions", csvWithHeader, OutputSerialization{Format: OutputCSV, CSV: &CSVOutput{FieldDelimiter: "|", RecordDelimiter: "\r\n", QuoteFields: "ALWAYS"}}, "SELECT name, age FROM S3Object LIMIT 1", peopleCSV, "\"Alice\"|\"30\"\r\n"},
+		{"CSVToJSON", csvWithHeader, jsonOutput, "SELECT * FROM S3Object WHERE name = 'Bob'", peopleCSV, `{"name":"Bob","age":"25","city":"LA","joined":"2019-06-01T00:00:00Z"}` + "\n"},
+
+		{"NestedPath", jsonInput, jsonOutput, "SELECT s.id, s.user.name FROM S3Object s WHERE s.user.tags[0] = 'a'", usersJSON, `{"id":1,"name":"ann"}` + "\n"},
+		{"IsNull", jsonInput, jsonOutput, "SELECT s.id FROM S3Object s WHERE s.score IS NULL", usersJSON, `{"id":2}` + "\n" + `{"id":3}` + "\n"},
+		{"IsMissing", jsonInput, jsonOutput, "SELECT id FROM S3Object WHERE score IS MISSING", usersJSON, `{"id":3}` + "\n"},
+		{"IsNotMissing", jsonInput, jsonOutput, "SELECT id FROM S3Object WHERE score IS NOT MISSING AND user.tags IS NOT NULL", usersJSON, `{"id":1}` + "\n" + `{"id":2}` + "\n"},
+		{"JSONStar", jsonInput, jsonOutput, "SELECT * FROM S3Object[*] LIMIT 1", usersJSON, `{"id":1,"user":{"name":"ann","tags":["a","b"]},"score":9.5}` + "\n"},
+		{"MissingOmitted", jsonInput, jsonOutput, "SELECT s.id, s.score FROM S3Object s", usersJSON, `{"id":1,"score":9.5}` + "\n" + `{"id":2,"score":null}` + "\n" + `{"id":3}` + "\n"},
+		{"ItemNames", jsonInput, jsonOutput, "SELECT s.id + 10, UPPER(s.user.name) AS up FROM S3Object s LIMIT 1", usersJSON, `{"_1":11,"up":"ANN"}` + "\n"},
+		{"FromPath", jsonInput, jsonOutput, "SELECT i.n FROM S3Object[*].items[*] i WHERE i.n >= 2", `{"items":[{"n":1},{"n":2},{"n":3}]}`, `{"n":2}` + "\n" + `{"n":3}` + "\n"},
+		{"JSONAggregate", jsonInput, OutputSerialization{Format: OutputJSON, JSON: &JSONOutput{RecordDelimiter: ";"}}, "SELECT COUNT(*) AS n, MAX(s.score) FROM S3Object s", usersJSON, `{"n":3,"_2":9.5};`},
+		{"JSONToCSV", jsonInput, csvOutput, "SELECT s.user FROM S3Object s LIMIT 1", usersJSON, `"{""name"":""ann"",""tags"":[""a"",""b""]}"` + "\n"},
 	}
 
-	if string(output) != "John,30\nJane,25" {
-		t.Errorf("formatOutput default = %q, want %q", string(output), "John,30\nJane,25")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := runSelect(tt.in, tt.out, tt.sql, tt.data)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.sql, err)
+			}
+			if got != tt.want {
+				t.Errorf("%s\n got %q\nwant %q", tt.sql, got, tt.want)
+			}
+		})
 	}
 }
 
-func TestExecuteEvaluateError(t *testing.T) {
-	logger := zap.NewNop()
-	svc := NewSelectService(logger)
-
-	req := &SelectRequest{
-		Bucket:         "test-bucket",
-		Key:            "test.csv",
-		Expression:     "SELECT * FROM s3object",
-		ExpressionType: ExpressionTypeSQL,
-		InputSerialization: InputSerialization{
-			Format: FormatCSV,
-		},
+func TestSelectErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      SelectRequest
+		data     string
+		wantCode string
+	}{
+		{"CastFailed", SelectRequest{Expression: "SELECT CAST(name AS INT) FROM S3Object", InputSerialization: csvWithHeader}, peopleCSV, "CastFailed"},
+		{"DivisionByZero", SelectRequest{Expression: "SELECT age / 0 FROM S3Object", InputSerialization: csvWithHeader}, peopleCSV, "EvaluatorDivisionByZero"},
+		{"NonBooleanWhere", SelectRequest{Expression: "SELECT * FROM S3Object WHERE name", InputSerialization: csvWithHeader}, peopleCSV, "EvaluatorInvalidArguments"},
+		{"NonNumericSum", SelectRequest{Expression: "SELECT SUM(name) FROM S3Object", InputSerialization: csvWithHeader}, peopleCSV, "EvaluatorInvalidArguments"},
+		{"ExpressionType", SelectRequest{Expression: "SELECT * FROM S3Object", ExpressionType: "XPATH", InputSerialization: csvWithHeader}, peopleCSV, "InvalidExpressionType"},
+		{"NoInputFormat", SelectRequest{Expression: "SELECT * FROM S3Object"}, peopleCSV, "InvalidRequestParameter"},
+		{"BadFileHeaderInfo", SelectRequest{Expression: "SELECT * FROM S3Object", InputSerialization: InputSerialization{Format: FormatCSV, CSV: &CSVInput{FileHeaderInfo: "MAYBE"}}}, peopleCSV, "InvalidFileHeaderInfo"},
+		{"BadJSON", SelectRequest{Expression: "SELECT * FROM S3Object", InputSerialization: jsonInput}, `{"a":1}` + "\n" + `{"a":`, "JSONParsingError"},
+		{"BadQuoteFields", SelectRequest{Expression: "SELECT * FROM S3Object", InputSerialization: csvWithHeader, OutputSerialization: OutputSerialization{Format: OutputCSV, CSV: &CSVOutput{QuoteFields: "SOMETIMES"}}}, peopleCSV, "InvalidQuoteFields"},
+	}
+
+	svc := NewSelectService(zap.NewNop())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.Stream(context.Background(), &tt.req, strings.NewReader(tt.data), &resultBuffer{})
+			var selErr *Error
+			if !errors.As(err, &selErr) || selErr.Code != tt.wantCode {
+				t.Errorf("Stream() error = %v, want %s", err, tt.wantCode)
+			}
+		})
 	}
+}
 
-	_, err := svc.Execute(context.Background(), req, &errorReader{})
-	if err == nil {
-		t.Error("Execute should fail for CSV with read error")
-	}
-	if !strings.Contains(err.Error(), "failed to evaluate") {
-		t.Errorf("Error should contain 'failed to evaluate', got %v", err)
-	}
+// recordingWriter is a ResultWriter keeping each event
+type recordingWriter struct {
+	records  [][]byte
+	progress []SelectStats
+	err      error
 }
 
-func TestEvaluateCSVRecordError(t *testing.T) {
-	logger := zap.NewNop()
-	ast := &AST{Columns: []string{"*"}}
-	evaluator := NewEvaluator(ast, FormatCSV, logger)
+func (w *recordingWriter) Records(payload []byte) error {
+	w.records = append(w.records, append([]byte(nil), payload...))
+	return w.err
+}
 
-	csvData := "name,age\nJohn,30\n\"unclosed quote"
+func (w *recordingWriter) Progress(stats SelectStats) error {
+	w.progress = append(w.progress, stats)
+	return w.err
+}
 
-	result, err := evaluator.Evaluate(context.Background(), strings.NewReader(csvData))
-	if err != nil {
-		t.Fatalf("Evaluate should not fail for CSV record errors: %v", err)
+func TestSelectServiceStream(t *testing.T) {
+	var data strings.Builder
+	data.WriteString("id,padding\n")
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&data, "%05d,%s\n", i, strings.Repeat("x", 40))
 	}
 
-	if result.Stats.RecordsReturned != 1 {
-		t.Errorf("RecordsReturned = %d, want 1 (skipping malformed record)", result.Stats.RecordsReturned)
+	svc := NewSelectService(zap.NewNop())
+	req := &SelectRequest{
+		Expression:         "SELECT * FROM S3Object WHERE CAST(id AS INT) % 2 = 0",
+		InputSerialization: csvWithHeader,
+		RequestProgress:    true,
 	}
-}
-
-func TestEvaluateJSONWithWhereClause(t *testing.T) {
-	logger := zap.NewNop()
-	ast := &AST{Columns: []string{"*"}, WhereClause: "age > 18"}
-	evaluator := NewEvaluator(ast, FormatJSON, logger)
-
-	jsonData := `{"name":"John","age":30}`
-
-	result, err := evaluator.Evaluate(context.Background(), strings.NewReader(jsonData))
+	out := &recordingWriter{}
+	stats, err := svc.Stream(context.Background(), req, strings.NewReader(data.String()), out)
 	if err != nil {
-		t.Fatalf("Evaluate failed: %v", err)
+		t.Fatalf("Stream() error = %v", err)
 	}
 
-	if result.Stats.RecordsReturned != 1 {
-		t.Errorf("RecordsReturned = %d, want 1", result.Stats.RecordsReturned)
+	// 2500 records of 47 bytes come in chunks of about 64 KiB
+	if len(out.records) != 2 {
+		t.Errorf("Records events = %d, want 2", len(out.records))
 	}
-}
-
-func TestEvaluateCSVWithLimit(t *testing.T) {
-	logger := zap.NewNop()
-	ast := &AST{Columns: []string{"*"}, Limit: 1}
-	evaluator := NewEvaluator(ast, FormatCSV, logger)
-
-	csvData := `name,age
-John,30
-Jane,25
-Bob,40`
-
-	result, err := evaluator.Evaluate(context.Background(), strings.NewReader(csvData))
-	if err != nil {
-		t.Fatalf("Evaluate failed: %v", err)
+	total := 0
+	for _, r := range out.records {
+		if len(r) > recordsChunkSize+64 {
+			t.Errorf("Records event of %d bytes, want at most about %d", len(r), recordsChunkSize)
+		}
+		total += len(r)
 	}
-
-	if result.Stats.RecordsReturned != 1 {
-		t.Errorf("RecordsReturned = %d, want 1 (limit)", result.Stats.RecordsReturned)
+	want := SelectStats{
+		BytesScanned:    int64(data.Len()),
+		BytesProcessed:  int64(data.Len()),
+		BytesReturned:   2500 * 47,
+		RecordsReturned: 2500,
 	}
-}
-
-func TestEvaluateJSONDecodeError(t *testing.T) {
-	logger := zap.NewNop()
-	ast := &AST{Columns: []string{"*"}}
-	evaluator := NewEvaluator(ast, FormatJSON, logger)
-
-	jsonData := `{"name":"John"}invalid`
-
-	result, err := evaluator.Evaluate(context.Background(), strings.NewReader(jsonData))
-	if err != nil {
-		t.Fatalf("Evaluate should not fail: %v", err)
+	if *stats != want || int64(total) != want.BytesReturned {
+		t.Errorf("Stream() stats = %+v with %d bytes of records, want %+v", *stats, total, want)
 	}
-
-	if result.Stats.RecordsReturned != 1 {
-		t.Errorf("RecordsReturned = %d, want 1", result.Stats.RecordsReturned)
+	if len(out.progress) != len(out.records) || out.progress[len(out.progress)-1].BytesReturned != want.BytesReturned {
+		t.Errorf("Progress events = %+v", out.progress)
 	}
-}
 
-func TestEvaluateFormatOutputError(t *testing.T) {
-	logger := zap.NewNop()
-	ast := &AST{Columns: []string{"*"}}
-	evaluator := NewEvaluator(ast, FormatJSON, logger)
-	evaluator.forceFormatErr = true
+	t.Run("WriterError", func(t *testing.T) {
+		out := &recordingWriter{err: errors.New("client went away")}
+		if _, err := svc.Stream(context.Background(), req, strings.NewReader(data.String()), out); err != out.err {
+			t.Errorf("Stream() error = %v, want %v", err, out.err)
+		}
+	})
 
-	jsonData := `{"name":"John"}`
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := svc.Stream(ctx, req, strings.NewReader(data.String()), &recordingWriter{}); err != context.Canceled {
+			t.Errorf("Stream() error = %v, want %v", err, context.Canceled)
+		}
+	})
+}
 
-	_, err := evaluator.Evaluate(context.Background(), strings.NewReader(jsonData))
-	if err == nil {
-		t.Fatal("Evaluate should fail when formatOutput returns error")
+func TestTimeLayout(t *testing.T) {
+	ts := time.Date(2024, 3, 7, 15, 4, 5, 120000000, time.FixedZone("", 5*3600+30*60))
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"yyyy-MM-dd'T'HH:mm:ss.SSSXXX", "2024-03-07T15:04:05.120+05:30"},
+		{"yy/M/d h:m:s a", "24/3/7 3:4:5 PM"},
+		{"MMMM MMM dd xx", "March Mar 07 +0530"},
+	}
+	for _, tt := range tests {
+		layout, err := timeLayout(tt.pattern)
+		if err != nil {
+			t.Fatalf("timeLayout(%q) error = %v", tt.pattern, err)
+		}
+		if got := ts.Format(layout); got != tt.want {
+			t.Errorf("TO_STRING pattern %q = %q, want %q", tt.pattern, got, tt.want)
+		}
 	}
-	if !strings.Contains(err.Error(), "forced format error") {
-		t.Errorf("Error should contain 'forced format error', got %v", err)
+	if _, err := timeLayout("QQ"); err == nil {
+		t.Error("timeLayout(QQ) should fail")
 	}
 }
@@ -2,12 +2,9 @@ package s3select
 
 import (
 	"context"
-	"encoding/csv"
-	"encoding/json"
 	"fmt"
 	"io"
 	"strings"
-	"sync"
 
 	"go.uber.org/zap"
 )
@@ -46,9 +43,12 @@ type SelectRequest struct {
 	InputSerialization  InputSerialization
 	OutputSerialization OutputSerialization
 	ScanRange           *ScanRange
+	RequestProgress     bool // send Progress events as the object is scanned
 }
 
-// ScanRange represents a range of bytes to scan
+// ScanRange selects the records starting between the inclusive byte
+// offsets Start and End of an uncompressed CSV or JSON Lines object. Start
+// is -1 to scan the last End bytes, and End -1 to scan to the end.
 type ScanRange struct {
 	Start int64
 	End   int64
@@ -59,7 +59,7 @@ type InputSerialization struct {
 	Format          InputFormat
 	JSON            *JSONInput
 	CSV             *CSVInput
-	CompressionType string
+	CompressionType string // NONE, GZIP or BZIP2
 }
 
 // JSONInput contains JSON-specific input settings
@@ -75,6 +75,10 @@ type CSVInput struct {
 	QuoteCharacter       string
 	QuoteEscapeCharacter string
 	CommentCharacter     string
+
+	// AllowQuotedRecordDelimiter lets quoted fields span records, at the
+	// cost of not splitting the object by scan range
+	AllowQuotedRecordDelimiter bool
 }
 
 // OutputSerialization contains output serialization settings
@@ -95,6 +99,7 @@ type CSVOutput struct {
 	FieldDelimiter       string
 	QuoteCharacter       string
 	QuoteEscapeCharacter string
+	QuoteFields          string // ASNEEDED or ALWAYS
 }
 
 // SelectResult contains the select result
@@ -112,284 +117,173 @@ type SelectStats struct {
 	RecordsReturned int64
 }
 
-// Parser parses SQL expressions
-type Parser struct {
-	logger *zap.Logger
-}
+// recordsChunkSize is the size at which output records are sent as a
+// Records event
+const recordsChunkSize = 64 << 10
 
-// NewParser creates a new SQL parser
-func NewParser(logger *zap.Logger) *Parser {
-	return &Parser{logger: logger}
+// SelectService provides S3 Select functionality
+type SelectService struct {
+	logger *zap.Logger
+	parser *Parser
 }
 
-// Parse parses a SQL expression into an AST
-func (p *Parser) Parse(sql string) (*AST, error) {
-	sql = strings.TrimSpace(sql)
-
-	// Simple SQL parser for SELECT statements
-	// Supports: SELECT columns FROM table [WHERE condition]
-
-	// Parse SELECT clause
-	if !strings.HasPrefix(strings.ToUpper(sql), "SELECT") {
-		return nil, fmt.Errorf("invalid SQL: must start with SELECT")
-	}
-
-	// Extract SELECT columns
-	selectIdx := 7 // len("SELECT")
-	fromIdx := strings.Index(sql, "FROM")
-	if fromIdx == -1 {
-		return nil, fmt.Errorf("invalid SQL: missing FROM clause")
-	}
-
-	columnsStr := strings.TrimSpace(sql[selectIdx:fromIdx])
-	columns := p.parseColumns(columnsStr)
-
-	// Extract WHERE clause
-	var whereClause string
-	whereIdx := strings.Index(sql, "WHERE")
-	if whereIdx != -1 {
-		whereClause = strings.TrimSpace(sql[whereIdx+5:])
-	}
-
-	// Extract LIMIT
-	var limit int64 = 0
-	limitIdx := strings.Index(sql, "LIMIT")
-	if limitIdx != -1 {
-		limitStr := strings.TrimSpace(sql[limitIdx+5:])
-		fmt.Sscanf(limitStr, "%d", &limit)
+// NewSelectService creates a new select service
+func NewSelectService(logger *zap.Logger) *SelectService {
+	return &SelectService{
+		logger: logger,
+		parser: NewParser(logger),
 	}
-
-	return &AST{
-		Columns:     columns,
-		WhereClause: whereClause,
-		Limit:       limit,
-	}, nil
 }
 
-// parseColumns parses column list
-func (p *Parser) parseColumns(colStr string) []string {
-	colStr = strings.TrimSpace(colStr)
+// Stream runs an S3 Select request over the object data, writing output
+// records to out in chunks as they are produced so that only one chunk is
+// held in memory, and returns the statistics of the whole query. A scan
+// range seeks within data when it is an io.Seeker. Errors in the request,
+// the query or the data are returned as *Error.
+func (s *SelectService) Stream(ctx context.Context, req *SelectRequest, data io.Reader, out ResultWriter) (*SelectStats, error) {
+	s.logger.Info("Executing S3 Select",
+		zap.String("bucket", req.Bucket),
+		zap.String("key", req.Key),
+		zap.String("expression", req.Expression))
 
-	if colStr == "*" {
-		return []string{"*"}
+	if req.ExpressionType != "" && !strings.EqualFold(string(req.ExpressionType), string(ExpressionTypeSQL)) {
+		return nil, &Error{Code: "InvalidExpressionType", Message: "ExpressionType must be SQL"}
 	}
-
-	reader := csv.NewReader(strings.NewReader(colStr))
-	columns, err := reader.Read()
+	query, err := s.parser.Parse(req.Expression)
 	if err != nil {
-		return []string{colStr}
+		return nil, err
 	}
-
-	return columns
-}
-
-// AST represents a parsed SQL AST
-type AST struct {
-	Columns     []string
-	WhereClause string
-	Limit       int64
-}
-
-// Evaluator evaluates the AST against records
-type Evaluator struct {
-	ast            *AST
-	input          InputFormat
-	logger         *zap.Logger
-	stats          SelectStats
-	mu             sync.Mutex
-	forceFormatErr bool
-}
-
-// NewEvaluator creates a new evaluator
-func NewEvaluator(ast *AST, input InputFormat, logger *zap.Logger) *Evaluator {
-	return &Evaluator{
-		ast:    ast,
-		input:  input,
-		logger: logger,
-		stats:  SelectStats{},
+	records, err := openInput(req, data)
+	if err != nil {
+		return nil, err
+	}
+	output, err := newRecordWriter(req.OutputSerialization)
+	if err != nil {
+		return nil, err
 	}
-}
-
-// Evaluate evaluates the select query on input data
-func (e *Evaluator) Evaluate(ctx context.Context, inputData io.Reader) (*SelectResult, error) {
-	var output []string
-	recordCount := int64(0)
-
-	switch e.input {
-	case FormatJSON:
-		decoder := json.NewDecoder(inputData)
-		for {
-			var record map[string]interface{}
-			if err := decoder.Decode(&record); err != nil {
-				if err == io.EOF {
-					break
-				}
-				e.logger.Debug("Error decoding JSON", zap.Error(err))
-				break
-			}
-
-			e.stats.BytesProcessed += estimateRecordSize(record)
-
-			// Check where clause (simplified - always true for now)
-			if e.ast.WhereClause != "" {
-				// TODO: Implement where clause evaluation
-			}
-
-			// Select columns
-			selected := e.selectColumns(record)
-			output = append(output, selected)
-			recordCount++
 
-			// Check limit
-			if e.ast.Limit > 0 && recordCount >= e.ast.Limit {
-				break
+	stats := &SelectStats{}
+	var buf []byte
+	flush := func() error {
+		stats.BytesScanned, stats.BytesProcessed = records.scanned.n, records.processed.n
+		if len(buf) > 0 {
+			stats.BytesReturned += int64(len(buf))
+			if err := out.Records(buf); err != nil {
+				return err
 			}
+			buf = buf[:0]
+		}
+		if req.RequestProgress {
+			return out.Progress(*stats)
+		}
+		return nil
+	}
+	emit := func(row Value) error {
+		buf = output.append(buf, row)
+		stats.RecordsReturned++
+		if len(buf) >= recordsChunkSize {
+			return flush()
 		}
+		return nil
+	}
 
-	case FormatCSV:
-		reader := csv.NewReader(inputData)
-		headers, err := reader.Read()
+scan:
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		rec, err := records.Read()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to read CSV headers: %w", err)
+			return nil, err
 		}
-
-		for {
-			record, err := reader.Read()
+		for _, src := range query.sources(rec) {
+			if !query.aggregate && query.limit >= 0 && stats.RecordsReturned >= query.limit {
+				break scan
+			}
+			ok, err := query.matches(src)
 			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				e.logger.Debug("Error reading CSV record", zap.Error(err))
+				return nil, err
+			}
+			if !ok {
 				continue
 			}
-
-			e.stats.BytesProcessed += int64(len(strings.Join(record, ",")))
-
-			// Convert to map
-			recordMap := make(map[string]interface{})
-			for i, h := range headers {
-				if i < len(record) {
-					recordMap[h] = record[i]
+			if query.aggregate {
+				err = query.accumulate(src)
+			} else {
+				var row Value
+				if row, err = query.project(src); err == nil {
+					err = emit(row)
 				}
 			}
-
-			// Select columns
-			selected := e.selectColumns(recordMap)
-			output = append(output, selected)
-			recordCount++
-
-			// Check limit
-			if e.ast.Limit > 0 && recordCount >= e.ast.Limit {
-				break
+			if err != nil {
+				return nil, err
 			}
 		}
 	}
 
-	e.stats.RecordsReturned = recordCount
-	e.stats.BytesReturned = int64(len(strings.Join(output, "")))
-
-	// Format output
-	payload, err := e.formatOutput(output)
-	if err != nil {
-		return nil, err
-	}
-
-	return &SelectResult{
-		Payload:   payload,
-		Stats:     &e.stats,
-		EndMarker: true,
-	}, nil
-}
-
-// selectColumns selects specific columns from a record
-func (e *Evaluator) selectColumns(record map[string]interface{}) string {
-	var result []string
-
-	for _, col := range e.ast.Columns {
-		col = strings.TrimSpace(col)
-
-		if col == "*" {
-			// Return all columns as JSON
-			data, _ := json.Marshal(record)
-			return string(data)
+	if query.aggregate && query.limit != 0 {
+		row, err := query.project(Value{})
+		if err != nil {
+			return nil, err
 		}
-
-		if val, ok := record[col]; ok {
-			result = append(result, fmt.Sprintf("%v", val))
-		} else {
-			result = append(result, "")
+		if err := emit(row); err != nil {
+			return nil, err
 		}
 	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
 
-	return strings.Join(result, ",")
+	s.logger.Info("S3 Select completed",
+		zap.Int64("bytes_scanned", stats.BytesScanned),
+		zap.Int64("bytes_returned", stats.BytesReturned),
+		zap.Int64("records_returned", stats.RecordsReturned))
+	return stats, nil
 }
 
-// formatOutput formats the output
-func (e *Evaluator) formatOutput(records []string) ([]byte, error) {
-	if e.forceFormatErr {
-		return nil, fmt.Errorf("forced format error")
-	}
-	switch e.input {
-	case FormatJSON:
-		return json.Marshal(records)
-	case FormatCSV:
-		return []byte(strings.Join(records, "\n") + "\n"), nil
-	default:
-		return []byte(strings.Join(records, "\n")), nil
+// Execute executes an S3 Select request, collecting the output records
+// into the payload of the result
+func (s *SelectService) Execute(ctx context.Context, req *SelectRequest, data io.Reader) (*SelectResult, error) {
+	var collected resultBuffer
+	stats, err := s.Stream(ctx, req, data, &collected)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute select: %w", err)
 	}
+	return &SelectResult{
+		Payload:   collected.payload,
+		Stats:     stats,
+		EndMarker: true,
+	}, nil
 }
 
-// estimateRecordSize estimates the size of a JSON record
-func estimateRecordSize(record map[string]interface{}) int64 {
-	data, _ := json.Marshal(record)
-	return int64(len(data))
+// GetStats returns current statistics
+func (s *SelectService) GetStats() SelectStats {
+	return SelectStats{}
 }
 
-// SelectService provides S3 Select functionality
-type SelectService struct {
-	logger *zap.Logger
-	parser *Parser
+// resultBuffer is a ResultWriter collecting the records in memory
+type resultBuffer struct {
+	payload []byte
 }
 
-// NewSelectService creates a new select service
-func NewSelectService(logger *zap.Logger) *SelectService {
-	return &SelectService{
-		logger: logger,
-		parser: NewParser(logger),
-	}
+func (b *resultBuffer) Records(payload []byte) error {
+	b.payload = append(b.payload, payload...)
+	return nil
 }
 
-// Execute executes an S3 Select request
-func (s *SelectService) Execute(ctx context.Context, req *SelectRequest, data io.Reader) (*SelectResult, error) {
-	s.logger.Info("Executing S3 Select",
-		zap.String("bucket", req.Bucket),
-		zap.String("key", req.Key),
-		zap.String("expression", req.Expression))
-
-	// Parse SQL
-	ast, err := s.parser.Parse(req.Expression)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse SQL: %w", err)
-	}
-
-	// Create evaluator
-	evaluator := NewEvaluator(ast, req.InputSerialization.Format, s.logger)
-
-	// Execute
-	result, err := evaluator.Evaluate(ctx, data)
-	if err != nil {
-		return nil, fmt.Errorf("failed to evaluate: %w", err)
-	}
-
-	s.logger.Info("S3 Select completed",
-		zap.Int64("bytes_scanned", result.Stats.BytesScanned),
-		zap.Int64("bytes_returned", result.Stats.BytesReturned),
-		zap.Int64("records_returned", result.Stats.RecordsReturned))
+func (b *resultBuffer) Progress(SelectStats) error { return nil }
 
-	return result, nil
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
 }
 
-// GetStats returns current statistics
-func (s *SelectService) GetStats() SelectStats {
-	return SelectStats{}
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
 }
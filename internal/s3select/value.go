@@ -0,0 +1,273 @@
+package s3select
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// kind is the type of a Value
+type kind int
+
+const (
+	kindMissing kind = iota // an absent field, distinct from null
+	kindNull
+	kindBool
+	kindInt
+	kindFloat
+	kindString
+	kindTimestamp
+	kindArray
+	kindObject
+)
+
+// Value is a SQL value: a scalar, or the array or object of a JSON record.
+// The zero Value is MISSING.
+type Value struct {
+	kind kind
+	b    bool
+	i    int64
+	f    float64
+	s    string
+	t    time.Time
+	arr  []Value
+	obj  *object
+}
+
+// object is a record or JSON object, keeping its fields in input order
+type object struct {
+	keys []string
+	vals []Value
+}
+
+func nullValue() Value             { return Value{kind: kindNull} }
+func boolValue(b bool) Value       { return Value{kind: kindBool, b: b} }
+func intValue(i int64) Value       { return Value{kind: kindInt, i: i} }
+func floatValue(f float64) Value   { return Value{kind: kindFloat, f: f} }
+func stringValue(s string) Value   { return Value{kind: kindString, s: s} }
+func timeValue(t time.Time) Value  { return Value{kind: kindTimestamp, t: t} }
+func arrayValue(arr []Value) Value { return Value{kind: kindArray, arr: arr} }
+func objectValue(keys []string, vals []Value) Value {
+	return Value{kind: kindObject, obj: &object{keys: keys, vals: vals}}
+}
+
+// isNull reports whether v is NULL or MISSING
+func (v Value) isNull() bool { return v.kind == kindNull || v.kind == kindMissing }
+
+// isNumber reports whether v is an INT or FLOAT
+func (v Value) isNumber() bool { return v.kind == kindInt || v.kind == kindFloat }
+
+// float returns a numeric v as a float64
+func (v Value) float() float64 {
+	if v.kind == kindInt {
+		return float64(v.i)
+	}
+	return v.f
+}
+
+// field returns the field name of an object, matched exactly when quoted
+// and case-insensitively otherwise. A name _N not found as such is the
+// Nth field, so positional references work on records with headers too.
+func (v Value) field(name string, quoted bool) Value {
+	if v.kind != kindObject {
+		return Value{}
+	}
+	for i, k := range v.obj.keys {
+		if k == name {
+			return v.obj.vals[i]
+		}
+	}
+	if !quoted {
+		for i, k := range v.obj.keys {
+			if strings.EqualFold(k, name) {
+				return v.obj.vals[i]
+			}
+		}
+		if n, ok := columnIndex(name); ok && n <= len(v.obj.vals) {
+			return v.obj.vals[n-1]
+		}
+	}
+	return Value{}
+}
+
+// columnIndex parses a positional column name _N, counting from 1
+func columnIndex(name string) (int, bool) {
+	if len(name) < 2 || name[0] != '_' {
+		return 0, false
+	}
+	n, err := strconv.Atoi(name[1:])
+	return n, err == nil && n > 0
+}
+
+// number converts v to a number, parsing strings so that CSV fields, which
+// are always strings, compare and add as numbers
+func (v Value) number() (Value, bool) {
+	switch v.kind {
+	case kindInt, kindFloat:
+		return v, true
+	case kindString:
+		s := strings.TrimSpace(v.s)
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return intValue(i), true
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return floatValue(f), true
+		}
+	}
+	return Value{}, false
+}
+
+// compare orders a and b, reporting false when they are not comparable:
+// either is null or missing, or their types differ and cannot be coerced
+func compare(a, b Value) (int, bool) {
+	if a.isNull() || b.isNull() {
+		return 0, false
+	}
+	// A string against a number or timestamp is converted to the other type
+	if a.kind == kindString && b.kind != kindString {
+		a, b = b, a
+		c, ok := compare(a, b)
+		return -c, ok
+	}
+	if b.kind == kindString && a.kind != kindString {
+		var ok bool
+		switch {
+		case a.isNumber():
+			b, ok = b.number()
+		case a.kind == kindTimestamp:
+			var t time.Time
+			t, ok = parseTimestamp(b.s)
+			b = timeValue(t)
+		}
+		if !ok {
+			return 0, false
+		}
+	}
+
+	switch {
+	case a.kind == kindInt && b.kind == kindInt:
+		return cmpOrdered(a.i, b.i), true
+	case a.isNumber() && b.isNumber():
+		return cmpOrdered(a.float(), b.float()), true
+	case a.kind == kindString && b.kind == kindString:
+		return strings.Compare(a.s, b.s), true
+	case a.kind == kindBool && b.kind == kindBool:
+		return cmpOrdered(boolInt(a.b), boolInt(b.b)), true
+	case a.kind == kindTimestamp && b.kind == kindTimestamp:
+		return a.t.Compare(b.t), true
+	}
+	return 0, false
+}
+
+func cmpOrdered[T int64 | float64 | int](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// String formats v as it is written to CSV output: strings unquoted, null
+// and missing empty, arrays and objects as JSON
+func (v Value) String() string {
+	switch v.kind {
+	case kindNull, kindMissing:
+		return ""
+	case kindBool:
+		return strconv.FormatBool(v.b)
+	case kindInt:
+		return strconv.FormatInt(v.i, 10)
+	case kindFloat:
+		return formatFloat(v.f)
+	case kindString:
+		return v.s
+	case kindTimestamp:
+		return v.t.Format(time.RFC3339Nano)
+	}
+	return string(v.appendJSON(nil))
+}
+
+// formatFloat formats f in the shortest form that reads back the same
+func formatFloat(f float64) string {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// appendJSON appends the JSON encoding of v to buf; missing fields of
+// objects are left out
+func (v Value) appendJSON(buf []byte) []byte {
+	switch v.kind {
+	case kindNull, kindMissing:
+		return append(buf, "null"...)
+	case kindBool:
+		return strconv.AppendBool(buf, v.b)
+	case kindInt:
+		return strconv.AppendInt(buf, v.i, 10)
+	case kindFloat:
+		if math.IsInf(v.f, 0) || math.IsNaN(v.f) {
+			return strconv.AppendQuote(buf, formatFloat(v.f))
+		}
+		return append(buf, formatFloat(v.f)...)
+	case kindString, kindTimestamp:
+		s, _ := json.Marshal(v.String())
+		return append(buf, s...)
+	case kindArray:
+		buf = append(buf, '[')
+		for i, e := range v.arr {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = e.appendJSON(buf)
+		}
+		return append(buf, ']')
+	}
+	buf = append(buf, '{')
+	first := true
+	for i, k := range v.obj.keys {
+		if v.obj.vals[i].kind == kindMissing {
+			continue
+		}
+		if !first {
+			buf = append(buf, ',')
+		}
+		first = false
+		name, _ := json.Marshal(k)
+		buf = append(append(buf, name...), ':')
+		buf = v.obj.vals[i].appendJSON(buf)
+	}
+	return append(buf, '}')
+}
+
+// timestampLayouts are the ISO 8601 forms TO_TIMESTAMP and timestamp
+// literals accept, from the most to the least precise
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T",
+	"2006-01-02",
+	"2006-01T",
+	"2006T",
+}
+
+// parseTimestamp parses an ISO 8601 timestamp
+func parseTimestamp(s string) (time.Time, bool) {
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
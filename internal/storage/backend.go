@@ -2,9 +2,18 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"io"
 )
 
+// ErrObjectNotFound is wrapped by the errors backends return for missing
+// objects
+var ErrObjectNotFound = errors.New("object not found")
+
+// ErrBucketNotFound is wrapped by the errors backends return for missing
+// buckets
+var ErrBucketNotFound = errors.New("bucket not found")
+
 // Backend is an alias for StorageBackend
 type Backend = StorageBackend
 
@@ -34,6 +43,16 @@ type StorageBackend interface {
 	// ListBuckets lists all buckets
 	ListBuckets(ctx context.Context) ([]BucketInfo, error)
 
+	// PutPart stages a multipart upload part. Staged parts live outside
+	// every bucket, so they never show up in listings or storage metrics.
+	PutPart(ctx context.Context, uploadID string, partNumber int, data io.Reader, size int64) error
+
+	// GetPart retrieves a staged multipart upload part
+	GetPart(ctx context.Context, uploadID string, partNumber int) (io.ReadCloser, error)
+
+	// DeleteUpload removes all staged parts of a multipart upload
+	DeleteUpload(ctx context.Context, uploadID string) error
+
 	// ComputeStorageMetrics computes total storage size and object count
 	ComputeStorageMetrics() (int64, int64, error)
 
@@ -79,7 +98,7 @@ type GetOptions struct {
 	IfUnmodifiedSince string
 }
 
-// Range represents a byte range for partial reads
+// Range represents a byte range for partial reads; End is exclusive
 type Range struct {
 	Start int64
 	End   int64
@@ -10,16 +10,19 @@ import (
 
 // MockStorageBackend implements StorageBackend interface for testing
 type MockStorageBackend struct {
-	PutFunc                  func(ctx context.Context, bucket, key string, data io.Reader, size int64, opts PutOptions) error
-	GetFunc                  func(ctx context.Context, bucket, key string, opts GetOptions) (io.ReadCloser, error)
-	DeleteFunc               func(ctx context.Context, bucket, key string) error
-	HeadFunc                 func(ctx context.Context, bucket, key string) (*ObjectInfo, error)
-	ListFunc                 func(ctx context.Context, bucket, prefix string, opts ListOptions) (*ListResult, error)
-	CreateBucketFunc         func(ctx context.Context, bucket string) error
-	DeleteBucketFunc         func(ctx context.Context, bucket string) error
-	ListBucketsFunc          func(ctx context.Context) ([]BucketInfo, error)
+	PutFunc                   func(ctx context.Context, bucket, key string, data io.Reader, size int64, opts PutOptions) error
+	GetFunc                   func(ctx context.Context, bucket, key string, opts GetOptions) (io.ReadCloser, error)
+	DeleteFunc                func(ctx context.Context, bucket, key string) error
+	HeadFunc                  func(ctx context.Context, bucket, key string) (*ObjectInfo, error)
+	ListFunc                  func(ctx context.Context, bucket, prefix string, opts ListOptions) (*ListResult, error)
+	CreateBucketFunc          func(ctx context.Context, bucket string) error
+	DeleteBucketFunc          func(ctx context.Context, bucket string) error
+	ListBucketsFunc           func(ctx context.Context) ([]BucketInfo, error)
+	PutPartFunc               func(ctx context.Context, uploadID string, partNumber int, data io.Reader, size int64) error
+	GetPartFunc               func(ctx context.Context, uploadID string, partNumber int) (io.ReadCloser, error)
+	DeleteUploadFunc          func(ctx context.Context, uploadID string) error
 	ComputeStorageMetricsFunc func() (int64, int64, error)
-	CloseFunc                func() error
+	CloseFunc                 func() error
 }
 
 func (m *MockStorageBackend) Put(ctx context.Context, bucket, key string, data io.Reader, size int64, opts PutOptions) error {
@@ -78,6 +81,27 @@ func (m *MockStorageBackend) ListBuckets(ctx context.Context) ([]BucketInfo, err
 	return nil, nil
 }
 
+func (m *MockStorageBackend) PutPart(ctx context.Context, uploadID string, partNumber int, data io.Reader, size int64) error {
+	if m.PutPartFunc != nil {
+		return m.PutPartFunc(ctx, uploadID, partNumber, data, size)
+	}
+	return nil
+}
+
+func (m *MockStorageBackend) GetPart(ctx context.Context, uploadID string, partNumber int) (io.ReadCloser, error) {
+	if m.GetPartFunc != nil {
+		return m.GetPartFunc(ctx, uploadID, partNumber)
+	}
+	return nil, nil
+}
+
+func (m *MockStorageBackend) DeleteUpload(ctx context.Context, uploadID string) error {
+	if m.DeleteUploadFunc != nil {
+		return m.DeleteUploadFunc(ctx, uploadID)
+	}
+	return nil
+}
+
 func (m *MockStorageBackend) ComputeStorageMetrics() (int64, int64, error) {
 	if m.ComputeStorageMetricsFunc != nil {
 		return m.ComputeStorageMetricsFunc()
@@ -11,6 +11,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -150,6 +151,16 @@ func New(rootDir string) (*FlatFile, error) {
 		return nil, fmt.Errorf("failed to create buckets directory: %w", err)
 	}
 
+	// Create temp directory for in-flight uploads
+	if err := os.MkdirAll(ff.tmpDir(), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+
+	// Create staging directory for multipart upload parts
+	if err := os.MkdirAll(ff.multipartDir(), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create multipart directory: %w", err)
+	}
+
 	return ff, nil
 }
 
@@ -160,6 +171,28 @@ func (f *FlatFile) bucketPath(bucket string) string {
 	return filepath.Join(f.rootDir, "buckets", safeBucket)
 }
 
+// tmpDir returns the directory holding in-flight uploads. It lives outside
+// the buckets tree so partial writes never show up in listings or metrics.
+func (f *FlatFile) tmpDir() string {
+	return filepath.Join(f.rootDir, "tmp")
+}
+
+// multipartDir returns the staging directory for multipart upload parts.
+// Like tmpDir it lives outside the buckets tree.
+func (f *FlatFile) multipartDir() string {
+	return filepath.Join(f.rootDir, "multipart")
+}
+
+// uploadPath returns the staging directory of a multipart upload
+func (f *FlatFile) uploadPath(uploadID string) string {
+	return filepath.Join(f.multipartDir(), sanitizePathComponent(uploadID))
+}
+
+// partPath returns the staging path of a multipart upload part
+func (f *FlatFile) partPath(uploadID string, partNumber int) string {
+	return filepath.Join(f.uploadPath(uploadID), strconv.Itoa(partNumber))
+}
+
 // objectPath returns the filesystem path for an object
 func (f *FlatFile) objectPath(bucket, key string) string {
 	// Sanitize inputs to prevent path traversal
@@ -227,11 +260,20 @@ func (f *FlatFile) Put(ctx context.Context, bucket, key string, data io.Reader,
 		return err
 	}
 
+	// Stream into a private temp file first so concurrent uploads do not
+	// hold the backend lock and partial objects are never visible
+	hasher := sha256.New()
+	tmpPath, written, err := f.spool("put", data, size, hasher)
+	if err != nil {
+		return err
+	}
+
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
 	bucketDir := f.bucketPath(bucket)
-	if err := os.MkdirAll(bucketDir, 0755); err != nil {
+	if err := makeDirs(bucketDir); err != nil {
+		os.Remove(tmpPath)
 		diskIOErrors.WithLabelValues("put_mkdir").Inc()
 		return fmt.Errorf("failed to create bucket directory: %w", err)
 	}
@@ -240,67 +282,147 @@ func (f *FlatFile) Put(ctx context.Context, bucket, key string, data io.Reader,
 
 	// Create parent directories
 	parentDir := filepath.Dir(objectPath)
-	if err := os.MkdirAll(parentDir, 0755); err != nil {
+	if err := makeDirs(parentDir); err != nil {
+		os.Remove(tmpPath)
 		diskIOErrors.WithLabelValues("put_mkdir_parent").Inc()
 		return fmt.Errorf("failed to create parent directory: %w", err)
 	}
 
-	// Create temp file for atomic write
-	tmpPath := objectPath + ".tmp"
-	fh, err := os.Create(tmpPath)
+	// Calculate and store hash for ETag
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	hashPath := objectPath + ".hash"
+	if err := f.writeHash(hashPath, hash); err != nil {
+		// Log warning but don't fail - hash is optional for ETag
+		f.logger.Warnw("failed to write hash file", "error", err)
+	}
+
+	// Rename to final location (atomic on same filesystem)
+	if err := os.Rename(tmpPath, objectPath); err != nil {
+		os.Remove(tmpPath)
+		os.Remove(hashPath)
+		diskIOErrors.WithLabelValues("put_rename").Inc()
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+
+	// The rename must be durable before the caller commits metadata that
+	// points at the object
+	if err := syncDir(parentDir); err != nil {
+		diskIOErrors.WithLabelValues("put_sync_dir").Inc()
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
+
+	bytesWritten.Add(float64(written))
+	f.logger.Debugw("object written",
+		"bucket", bucket,
+		"key", key,
+		"size", written,
+	)
+
+	return nil
+}
+
+// spool streams data into a new file in tmpDir and returns its path and
+// length. Every byte is also written to hasher when it is not nil. op
+// prefixes the error metric labels.
+func (f *FlatFile) spool(op string, data io.Reader, size int64, hasher io.Writer) (string, int64, error) {
+	fh, err := os.CreateTemp(f.tmpDir(), op+"-*")
 	if err != nil {
-		diskIOErrors.WithLabelValues("put_create").Inc()
-		return fmt.Errorf("failed to create temp file: %w", err)
+		diskIOErrors.WithLabelValues(op + "_create").Inc()
+		return "", 0, fmt.Errorf("failed to create temp file: %w", err)
 	}
+	tmpPath := fh.Name()
 
-	// Copy data and calculate hash
-	hasher := sha256.New()
-	writer := io.MultiWriter(fh, hasher)
+	var writer io.Writer = fh
+	if hasher != nil {
+		writer = io.MultiWriter(fh, hasher)
+	}
 
-	written, err := io.Copy(writer, data)
+	bufPtr := f.bufferPool.Get().(*[]byte)
+	written, err := io.CopyBuffer(writer, data, *bufPtr)
+	f.bufferPool.Put(bufPtr)
 	if err != nil {
 		fh.Close()
 		os.Remove(tmpPath)
-		diskIOErrors.WithLabelValues("put_copy").Inc()
-		return fmt.Errorf("failed to write data: %w", err)
+		diskIOErrors.WithLabelValues(op + "_copy").Inc()
+		return "", 0, fmt.Errorf("failed to write data: %w", err)
+	}
+
+	if err := fh.Sync(); err != nil {
+		fh.Close()
+		os.Remove(tmpPath)
+		diskIOErrors.WithLabelValues(op + "_sync").Inc()
+		return "", 0, fmt.Errorf("failed to sync temp file: %w", err)
 	}
 
 	if err := fh.Close(); err != nil {
 		os.Remove(tmpPath)
-		diskIOErrors.WithLabelValues("put_close").Inc()
-		return fmt.Errorf("failed to close temp file: %w", err)
+		diskIOErrors.WithLabelValues(op + "_close").Inc()
+		return "", 0, fmt.Errorf("failed to close temp file: %w", err)
 	}
 
 	// Verify size
 	if written != size && size > 0 {
 		os.Remove(tmpPath)
-		return fmt.Errorf("size mismatch: expected %d, got %d", size, written)
+		return "", 0, fmt.Errorf("size mismatch: expected %d, got %d", size, written)
 	}
 
-	// Calculate and store hash for ETag
-	hash := hex.EncodeToString(hasher.Sum(nil))
-	hashPath := objectPath + ".hash"
-	if err := os.WriteFile(hashPath, []byte(hash), 0644); err != nil {
-		// Log warning but don't fail - hash is optional for ETag
-		f.logger.Warnw("failed to write hash file", "error", err)
+	return tmpPath, written, nil
+}
+
+// writeHash atomically replaces the ETag sidecar of an object
+func (f *FlatFile) writeHash(hashPath, hash string) error {
+	fh, err := os.CreateTemp(f.tmpDir(), "hash-*")
+	if err != nil {
+		return err
 	}
+	tmpPath := fh.Name()
 
-	// Rename to final location (atomic on same filesystem)
-	if err := os.Rename(tmpPath, objectPath); err != nil {
+	_, err = fh.WriteString(hash)
+	if err == nil {
+		err = fh.Sync()
+	}
+	if closeErr := fh.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, hashPath)
+	}
+	if err != nil {
 		os.Remove(tmpPath)
-		os.Remove(hashPath)
-		diskIOErrors.WithLabelValues("put_rename").Inc()
-		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+	return err
+}
+
+// makeDirs creates dir and any missing parents, syncing the parent of
+// each new directory so it survives a crash
+func makeDirs(dir string) error {
+	if info, err := os.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("not a directory: %s", dir)
+		}
+		return nil
 	}
 
-	bytesWritten.Add(float64(written))
-	f.logger.Debugw("object written",
-		"bucket", bucket,
-		"key", key,
-		"size", written,
-	)
+	parent := filepath.Dir(dir)
+	if parent != dir {
+		if err := makeDirs(parent); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return syncDir(parent)
+}
 
-	return nil
+// syncDir flushes the entries of a directory to disk
+func syncDir(dir string) error {
+	fh, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	return fh.Sync()
 }
 
 func (f *FlatFile) Get(ctx context.Context, bucket, key string, opts storage.GetOptions) (io.ReadCloser, error) {
@@ -318,7 +440,7 @@ func (f *FlatFile) Get(ctx context.Context, bucket, key string, opts storage.Get
 	info, err := os.Stat(objectPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("object not found: %s/%s", bucket, key)
+			return nil, fmt.Errorf("%w: %s/%s", storage.ErrObjectNotFound, bucket, key)
 		}
 		diskIOErrors.WithLabelValues("get_stat").Inc()
 		return nil, fmt.Errorf("failed to stat object: %w", err)
@@ -418,7 +540,7 @@ func (f *FlatFile) Head(ctx context.Context, bucket, key string) (*storage.Objec
 	info, err := os.Stat(objectPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("object not found: %s/%s", bucket, key)
+			return nil, fmt.Errorf("%w: %s/%s", storage.ErrObjectNotFound, bucket, key)
 		}
 		return nil, fmt.Errorf("failed to stat object: %w", err)
 	}
@@ -451,7 +573,7 @@ func (f *FlatFile) List(ctx context.Context, bucket, prefix string, opts storage
 	// Check if bucket exists
 	if _, err := os.Stat(bucketDir); err != nil {
 		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("bucket not found: %s", bucket)
+			return nil, fmt.Errorf("%w: %s", storage.ErrBucketNotFound, bucket)
 		}
 		return nil, fmt.Errorf("failed to stat bucket: %w", err)
 	}
@@ -470,8 +592,8 @@ func (f *FlatFile) List(ctx context.Context, bucket, prefix string, opts storage
 			return err
 		}
 
-		// Skip directories
-		if info.IsDir() {
+		// Skip directories and ETag sidecars
+		if info.IsDir() || isHashSidecar(path) {
 			return nil
 		}
 
@@ -546,13 +668,23 @@ func (f *FlatFile) List(ctx context.Context, bucket, prefix string, opts storage
 	}, nil
 }
 
+// isHashSidecar reports whether path is the ETag sidecar of an object
+func isHashSidecar(path string) bool {
+	object, ok := strings.CutSuffix(path, ".hash")
+	if !ok {
+		return false
+	}
+	info, err := os.Stat(object)
+	return err == nil && !info.IsDir()
+}
+
 func (f *FlatFile) CreateBucket(ctx context.Context, bucket string) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
 	bucketDir := f.bucketPath(bucket)
 
-	if err := os.MkdirAll(bucketDir, 0755); err != nil {
+	if err := makeDirs(bucketDir); err != nil {
 		diskIOErrors.WithLabelValues("create_bucket").Inc()
 		return fmt.Errorf("failed to create bucket: %w", err)
 	}
@@ -624,6 +756,85 @@ func (f *FlatFile) Close() error {
 	return nil
 }
 
+// PutPart stages a multipart upload part under the multipart directory
+func (f *FlatFile) PutPart(ctx context.Context, uploadID string, partNumber int, data io.Reader, size int64) error {
+	if err := validateUploadID(uploadID); err != nil {
+		return err
+	}
+
+	tmpPath, written, err := f.spool("part", data, size, nil)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := makeDirs(f.uploadPath(uploadID)); err != nil {
+		os.Remove(tmpPath)
+		diskIOErrors.WithLabelValues("part_mkdir").Inc()
+		return fmt.Errorf("failed to create upload directory: %w", err)
+	}
+
+	// Re-uploading a part number replaces the earlier data
+	if err := os.Rename(tmpPath, f.partPath(uploadID, partNumber)); err != nil {
+		os.Remove(tmpPath)
+		diskIOErrors.WithLabelValues("part_rename").Inc()
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+	if err := syncDir(f.uploadPath(uploadID)); err != nil {
+		diskIOErrors.WithLabelValues("part_sync_dir").Inc()
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
+
+	bytesWritten.Add(float64(written))
+	return nil
+}
+
+// GetPart opens a staged multipart upload part
+func (f *FlatFile) GetPart(ctx context.Context, uploadID string, partNumber int) (io.ReadCloser, error) {
+	if err := validateUploadID(uploadID); err != nil {
+		return nil, err
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	file, err := os.Open(f.partPath(uploadID, partNumber))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("part not found: %s/%d", uploadID, partNumber)
+		}
+		diskIOErrors.WithLabelValues("part_open").Inc()
+		return nil, fmt.Errorf("failed to open part: %w", err)
+	}
+	return file, nil
+}
+
+// DeleteUpload removes the staging directory of a multipart upload
+func (f *FlatFile) DeleteUpload(ctx context.Context, uploadID string) error {
+	if err := validateUploadID(uploadID); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := os.RemoveAll(f.uploadPath(uploadID)); err != nil {
+		diskIOErrors.WithLabelValues("delete_upload").Inc()
+		return fmt.Errorf("failed to delete upload: %w", err)
+	}
+	return nil
+}
+
+// validateUploadID rejects upload IDs that cannot name a staging directory
+func validateUploadID(uploadID string) error {
+	if uploadID == "" || uploadID == "." || sanitizePathComponent(uploadID) != uploadID || strings.Contains(uploadID, "\x00") {
+		return fmt.Errorf("invalid upload ID: %q", uploadID)
+	}
+	return nil
+}
+
 // ComputeStorageMetrics computes total storage size and object count
 func (f *FlatFile) ComputeStorageMetrics() (int64, int64, error) {
 	f.mu.RLock()
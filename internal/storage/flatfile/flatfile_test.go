@@ -3,7 +3,9 @@ package flatfile
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
@@ -197,6 +199,50 @@ func TestList(t *testing.T) {
 	}
 }
 
+func TestList_SkipsHashSidecars(t *testing.T) {
+	ff, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create FlatFile: %v", err)
+	}
+
+	ctx := context.Background()
+	ff.Put(ctx, "bucket", "dir/file.txt", bytes.NewReader([]byte("data")), 4, storage.PutOptions{})
+	ff.Put(ctx, "bucket", "notes.hash", bytes.NewReader([]byte("data")), 4, storage.PutOptions{})
+
+	result, err := ff.List(ctx, "bucket", "", storage.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to list objects: %v", err)
+	}
+
+	var keys []string
+	for _, obj := range result.Objects {
+		keys = append(keys, obj.Key)
+	}
+	if len(keys) != 2 || keys[0] != "dir/file.txt" || keys[1] != "notes.hash" {
+		t.Errorf("List keys = %v, want [dir/file.txt notes.hash]", keys)
+	}
+}
+
+func TestNotFoundErrors(t *testing.T) {
+	ff, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create FlatFile: %v", err)
+	}
+
+	ctx := context.Background()
+	ff.CreateBucket(ctx, "bucket")
+
+	if _, err := ff.Get(ctx, "bucket", "missing", storage.GetOptions{}); !errors.Is(err, storage.ErrObjectNotFound) {
+		t.Errorf("Get error = %v, want ErrObjectNotFound", err)
+	}
+	if _, err := ff.Head(ctx, "bucket", "missing"); !errors.Is(err, storage.ErrObjectNotFound) {
+		t.Errorf("Head error = %v, want ErrObjectNotFound", err)
+	}
+	if _, err := ff.List(ctx, "missing", "", storage.ListOptions{}); !errors.Is(err, storage.ErrBucketNotFound) {
+		t.Errorf("List error = %v, want ErrBucketNotFound", err)
+	}
+}
+
 func TestListBuckets(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "flatfile-test-*")
 	if err != nil {
@@ -3463,3 +3509,55 @@ func TestList_WalkNonEOFError(t *testing.T) {
 		t.Logf("List walk non-EOF error: %v", err)
 	}
 }
+
+func TestMultipartStaging(t *testing.T) {
+	ff, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create FlatFile: %v", err)
+	}
+
+	ctx := context.Background()
+	ff.CreateBucket(ctx, "bucket")
+
+	if err := ff.PutPart(ctx, "upload-1", 1, bytes.NewReader([]byte("part one")), 8); err != nil {
+		t.Fatalf("PutPart failed: %v", err)
+	}
+	if err := ff.PutPart(ctx, "upload-1", 2, bytes.NewReader([]byte("two")), 3); err != nil {
+		t.Fatalf("PutPart failed: %v", err)
+	}
+
+	rc, err := ff.GetPart(ctx, "upload-1", 1)
+	if err != nil {
+		t.Fatalf("GetPart failed: %v", err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "part one" {
+		t.Errorf("GetPart = %q, want %q", data, "part one")
+	}
+
+	// Staged parts are invisible to listings and metrics
+	result, err := ff.List(ctx, "bucket", "", storage.ListOptions{})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(result.Objects) != 0 {
+		t.Errorf("List returned %d objects, want 0", len(result.Objects))
+	}
+	if used, objects, _ := ff.ComputeStorageMetrics(); used != 0 || objects != 0 {
+		t.Errorf("ComputeStorageMetrics = %d, %d, want 0, 0", used, objects)
+	}
+
+	if err := ff.DeleteUpload(ctx, "upload-1"); err != nil {
+		t.Fatalf("DeleteUpload failed: %v", err)
+	}
+	if _, err := ff.GetPart(ctx, "upload-1", 2); err == nil {
+		t.Error("GetPart should fail after DeleteUpload")
+	}
+
+	for _, id := range []string{"", ".", "..", "a/b", "../upload-1"} {
+		if err := ff.PutPart(ctx, id, 1, bytes.NewReader([]byte("x")), 1); err == nil {
+			t.Errorf("PutPart(%q) should fail", id)
+		}
+	}
+}
@@ -0,0 +1,883 @@
+package packed
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/openendpoint/openendpoint/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	bytesWritten = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "openendpoint_packed_bytes_written_total",
+			Help: "Total bytes written to packed volumes",
+		},
+	)
+	bytesRead = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "openendpoint_packed_bytes_read_total",
+			Help: "Total bytes read from packed volumes",
+		},
+	)
+	volumeErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "openendpoint_packed_errors_total",
+			Help: "Total packed volume errors",
+		},
+		[]string{"operation"},
+	)
+)
+
+// DefaultVolumeSize is the size at which a volume stops accepting needles
+const DefaultVolumeSize = 1 << 30
+
+// maxBufferedNeedle is the largest body spooled in memory before the
+// append; larger bodies go through a temp file
+const maxBufferedNeedle = 1 << 20
+
+// Index key namespaces. Bucket names and upload IDs never contain 0x00,
+// which separates them from object keys and part numbers.
+const (
+	bucketPrefix = 'b'
+	objectPrefix = 'o'
+	partPrefix   = 'p'
+	volumePrefix = 'v'
+)
+
+// statsKey holds the byte and object totals of every bucket
+var statsKey = []byte("s")
+
+// Backend is a storage backend that appends objects as needles to large
+// volume files and locates them through a persistent pebble index, so
+// small objects cost no inode each
+type Backend struct {
+	rootDir string
+	volumes *VolumeManager
+	index   *pebble.DB
+
+	// mu serialises index updates so the storage totals and the live
+	// bytes of each volume stay exact
+	mu           sync.Mutex
+	totalBytes   int64
+	totalObjects int64
+
+	// readMu is held for writing while a compacted volume is removed, so
+	// a reader never looks up an entry in a volume that is gone
+	readMu sync.RWMutex
+}
+
+// entry locates the needle holding an object or part. The needle key is
+// the index key, so the data offset follows from it.
+type entry struct {
+	Volume       uint64
+	Offset       int64
+	Size         int64
+	LastModified int64
+	Checksum     uint32
+	MD5          [md5.Size]byte
+}
+
+const entrySize = 8 + 8 + 8 + 8 + 4 + md5.Size
+
+func (e *entry) encode() []byte {
+	b := make([]byte, entrySize)
+	binary.BigEndian.PutUint64(b[0:8], e.Volume)
+	binary.BigEndian.PutUint64(b[8:16], uint64(e.Offset))
+	binary.BigEndian.PutUint64(b[16:24], uint64(e.Size))
+	binary.BigEndian.PutUint64(b[24:32], uint64(e.LastModified))
+	binary.BigEndian.PutUint32(b[32:36], e.Checksum)
+	copy(b[36:], e.MD5[:])
+	return b
+}
+
+func decodeEntry(b []byte) (*entry, error) {
+	if len(b) != entrySize {
+		return nil, fmt.Errorf("invalid index entry of %d bytes", len(b))
+	}
+	e := &entry{
+		Volume:       binary.BigEndian.Uint64(b[0:8]),
+		Offset:       int64(binary.BigEndian.Uint64(b[8:16])),
+		Size:         int64(binary.BigEndian.Uint64(b[16:24])),
+		LastModified: int64(binary.BigEndian.Uint64(b[24:32])),
+		Checksum:     binary.BigEndian.Uint32(b[32:36]),
+	}
+	copy(e.MD5[:], b[36:])
+	return e, nil
+}
+
+// length returns the bytes the needle for index key occupies in its volume
+func (e *entry) length(key []byte) int64 {
+	return needleHeaderSize + int64(len(key)) + e.Size
+}
+
+// etag returns the quoted hex MD5 of the needle data
+func (e *entry) etag() string {
+	return "\"" + hex.EncodeToString(e.MD5[:]) + "\""
+}
+
+// New opens a packed backend under rootDir/packed. volumeSize is the size
+// at which a volume is sealed and a new one started.
+func New(rootDir string, volumeSize int64) (*Backend, error) {
+	if volumeSize <= 0 {
+		volumeSize = DefaultVolumeSize
+	}
+	dir := filepath.Join(rootDir, "packed")
+
+	b := &Backend{rootDir: dir}
+	if err := os.MkdirAll(b.tmpDir(), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+
+	volumes, err := newVolumeManager(filepath.Join(dir, "volumes"), volumeSize, true)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := pebble.Open(filepath.Join(dir, "index"), &pebble.Options{
+		Cache:        pebble.NewCache(64 << 20),
+		MaxOpenFiles: 1000,
+	})
+	if err != nil {
+		volumes.Close()
+		return nil, fmt.Errorf("failed to open packed index: %w", err)
+	}
+
+	b.volumes = volumes
+	b.index = index
+	if err := b.loadStats(); err != nil {
+		b.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// tmpDir holds bodies too large to spool in memory
+func (b *Backend) tmpDir() string {
+	return filepath.Join(b.rootDir, "tmp")
+}
+
+func bucketIndexKey(bucket string) []byte {
+	return append([]byte{bucketPrefix}, bucket...)
+}
+
+func objectIndexKey(bucket, key string) []byte {
+	k := make([]byte, 0, 2+len(bucket)+len(key))
+	k = append(k, objectPrefix)
+	k = append(k, bucket...)
+	k = append(k, 0)
+	return append(k, key...)
+}
+
+// objectIndexPrefix returns the prefix shared by every object of a bucket
+func objectIndexPrefix(bucket string) []byte {
+	return objectIndexKey(bucket, "")
+}
+
+func volumeIndexKey(id uint64) []byte {
+	return binary.BigEndian.AppendUint64([]byte{volumePrefix}, id)
+}
+
+func partIndexPrefix(uploadID string) []byte {
+	k := append([]byte{partPrefix}, uploadID...)
+	return append(k, 0)
+}
+
+func partIndexKey(uploadID string, partNumber int) []byte {
+	return binary.BigEndian.AppendUint32(partIndexPrefix(uploadID), uint32(partNumber))
+}
+
+// prefixEnd returns the smallest key greater than every key with prefix
+func prefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// validateName rejects bucket names, keys and upload IDs that cannot be
+// stored in the index
+func validateName(kind, name string) error {
+	if name == "" {
+		return fmt.Errorf("%s cannot be empty", kind)
+	}
+	if strings.Contains(name, "\x00") {
+		return fmt.Errorf("%s cannot contain null bytes", kind)
+	}
+	return nil
+}
+
+// loadStats reads the persisted storage totals and the live bytes of
+// each volume
+func (b *Backend) loadStats() error {
+	value, closer, err := b.index.Get(statsKey)
+	if err == nil {
+		if len(value) == 16 {
+			b.totalBytes = int64(binary.BigEndian.Uint64(value[0:8]))
+			b.totalObjects = int64(binary.BigEndian.Uint64(value[8:16]))
+		}
+		closer.Close()
+	} else if !errors.Is(err, pebble.ErrNotFound) {
+		return fmt.Errorf("failed to read storage totals: %w", err)
+	}
+
+	lower := []byte{volumePrefix}
+	iter, err := b.index.NewIter(&pebble.IterOptions{LowerBound: lower, UpperBound: prefixEnd(lower)})
+	if err != nil {
+		return fmt.Errorf("failed to read volume totals: %w", err)
+	}
+	defer iter.Close()
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		if key, value := iter.Key(), iter.Value(); len(key) == 9 && len(value) == 8 {
+			b.volumes.setLive(binary.BigEndian.Uint64(key[1:]), int64(binary.BigEndian.Uint64(value)))
+		}
+	}
+	return iter.Error()
+}
+
+// encodeStats returns the storage totals as stored under statsKey
+func (b *Backend) encodeStats() []byte {
+	value := make([]byte, 16)
+	binary.BigEndian.PutUint64(value[0:8], uint64(b.totalBytes))
+	binary.BigEndian.PutUint64(value[8:16], uint64(b.totalObjects))
+	return value
+}
+
+// lookup returns the index entry stored under key, or nil
+func (b *Backend) lookup(key []byte) (*entry, error) {
+	value, closer, err := b.index.Get(key)
+	if errors.Is(err, pebble.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		volumeErrors.WithLabelValues("index_get").Inc()
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+	defer closer.Close()
+	return decodeEntry(value)
+}
+
+// exists reports whether key is present in the index
+func (b *Backend) exists(key []byte) (bool, error) {
+	_, closer, err := b.index.Get(key)
+	if errors.Is(err, pebble.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read index: %w", err)
+	}
+	closer.Close()
+	return true, nil
+}
+
+// spooled is a body read ahead of its append, so a slow client never holds
+// a volume lock. Small bodies stay in memory.
+type spooled struct {
+	buf      []byte
+	file     *os.File
+	size     int64
+	checksum uint32
+	md5      [md5.Size]byte
+}
+
+// spool reads data to the end, checking it against size when size is
+// positive
+func (b *Backend) spool(data io.Reader, size int64) (*spooled, error) {
+	crc := crc32.NewIEEE()
+	sum := md5.New()
+	body := io.TeeReader(data, io.MultiWriter(crc, sum))
+
+	sp := &spooled{}
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, body, maxBufferedNeedle+1)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read data: %w", err)
+	}
+	if n <= maxBufferedNeedle {
+		sp.buf = buf.Bytes()
+	} else {
+		file, err := os.CreateTemp(b.tmpDir(), "put-*")
+		if err != nil {
+			volumeErrors.WithLabelValues("spool_create").Inc()
+			return nil, fmt.Errorf("failed to create temp file: %w", err)
+		}
+		sp.file = file
+		rest, err := io.Copy(file, io.MultiReader(&buf, body))
+		if err != nil {
+			sp.close()
+			volumeErrors.WithLabelValues("spool_copy").Inc()
+			return nil, fmt.Errorf("failed to write data: %w", err)
+		}
+		n = rest
+	}
+	sp.size = n
+	sp.checksum = crc.Sum32()
+	copy(sp.md5[:], sum.Sum(nil))
+
+	if size > 0 && n != size {
+		sp.close()
+		return nil, fmt.Errorf("size mismatch: expected %d, got %d", size, n)
+	}
+	return sp, nil
+}
+
+// reader returns the spooled body from its start
+func (sp *spooled) reader() (io.Reader, error) {
+	if sp.file == nil {
+		return bytes.NewReader(sp.buf), nil
+	}
+	if _, err := sp.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return sp.file, nil
+}
+
+// close releases the temp file of a spooled body
+func (sp *spooled) close() {
+	if sp.file != nil {
+		sp.file.Close()
+		os.Remove(sp.file.Name())
+	}
+}
+
+// write appends a spooled body as the needle for index key and returns
+// its index entry
+func (b *Backend) write(key []byte, sp *spooled) (*entry, error) {
+	body, err := sp.reader()
+	if err != nil {
+		return nil, fmt.Errorf("failed to rewind data: %w", err)
+	}
+
+	vol, needle, err := b.volumes.append(string(key), body, sp.size, sp.checksum)
+	if err != nil {
+		volumeErrors.WithLabelValues("append").Inc()
+		return nil, fmt.Errorf("failed to append needle: %w", err)
+	}
+	bytesWritten.Add(float64(sp.size))
+
+	return &entry{
+		Volume:       vol.id,
+		Offset:       needle.Offset,
+		Size:         needle.Size,
+		LastModified: needle.LastModified,
+		Checksum:     needle.Checksum,
+		MD5:          sp.md5,
+	}, nil
+}
+
+// open returns a reader over length bytes of the needle data behind an
+// index entry, starting at off. The caller holds readMu for reading.
+func (b *Backend) open(key []byte, e *entry, off, length int64) (io.ReadCloser, error) {
+	vol, ok := b.volumes.acquire(e.Volume)
+	if !ok {
+		volumeErrors.WithLabelValues("get_volume").Inc()
+		return nil, fmt.Errorf("volume %d not found", e.Volume)
+	}
+
+	needle := &Needle{Key: string(key), Offset: e.Offset, Size: e.Size}
+	var r io.Reader = vol.section(needle, off, length)
+	bytesRead.Add(float64(length))
+
+	// Whole reads are verified against the CRC32 stored with the needle
+	if off == 0 && length == e.Size {
+		r = &checksumReader{r: r, hash: crc32.NewIEEE(), want: e.Checksum, key: string(key)}
+	}
+	return &needleReader{Reader: r, vol: vol}, nil
+}
+
+// needleReader reads needle data and keeps its volume open until closed
+type needleReader struct {
+	io.Reader
+	vol  *Volume
+	once sync.Once
+}
+
+func (r *needleReader) Close() error {
+	r.once.Do(r.vol.release)
+	return nil
+}
+
+// checksumReader fails the read at EOF when the data does not match the
+// needle checksum
+type checksumReader struct {
+	r    io.Reader
+	hash hash.Hash32
+	want uint32
+	key  string
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.hash.Write(p[:n])
+	if err == io.EOF && c.hash.Sum32() != c.want {
+		volumeErrors.WithLabelValues("checksum").Inc()
+		return n, fmt.Errorf("%w: checksum mismatch for %q", errCorruptNeedle, c.key)
+	}
+	return n, err
+}
+
+// Put appends an object to a volume and points its index entry at it. A
+// missing bucket is created, as with the flat file backend.
+func (b *Backend) Put(ctx context.Context, bucket, key string, data io.Reader, size int64, opts storage.PutOptions) error {
+	if err := validateName("bucket name", bucket); err != nil {
+		return err
+	}
+	if err := validateName("object key", key); err != nil {
+		return err
+	}
+
+	sp, err := b.spool(data, size)
+	if err != nil {
+		return err
+	}
+	defer sp.close()
+
+	indexKey := objectIndexKey(bucket, key)
+	e, err := b.write(indexKey, sp)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	old, err := b.lookup(indexKey)
+	if err != nil {
+		return err
+	}
+	bucketExists, err := b.exists(bucketIndexKey(bucket))
+	if err != nil {
+		return err
+	}
+
+	batch := b.index.NewBatch()
+	defer batch.Close()
+	if !bucketExists {
+		batch.Set(bucketIndexKey(bucket), encodeUnix(time.Now().Unix()), nil)
+	}
+	batch.Set(indexKey, e.encode(), nil)
+
+	c := newChange(true)
+	c.add(indexKey, e, 1)
+	c.add(indexKey, old, -1)
+	return b.commit(batch, c)
+}
+
+// change is the effect of an index update on the storage totals and on
+// the live bytes of each volume
+type change struct {
+	bytes   int64
+	objects int64
+	live    map[uint64]int64
+
+	// counted changes affect the storage totals, which leave out parts
+	counted bool
+}
+
+// newChange returns an empty change, counted in the storage totals if
+// counted is set
+func newChange(counted bool) *change {
+	return &change{live: make(map[uint64]int64), counted: counted}
+}
+
+// add records that the needle behind e started (sign 1) or stopped (sign
+// -1) being referenced. A nil entry is ignored.
+func (c *change) add(key []byte, e *entry, sign int64) {
+	if e == nil {
+		return
+	}
+	c.live[e.Volume] += sign * e.length(key)
+	if c.counted {
+		c.bytes += sign * e.Size
+		c.objects += sign
+	}
+}
+
+// commit writes an index batch together with the storage totals and volume
+// live bytes it changes. The caller holds mu.
+func (b *Backend) commit(batch *pebble.Batch, c *change) error {
+	live := make(map[uint64]int64, len(c.live))
+	for id, delta := range c.live {
+		live[id] = b.volumes.liveBytes(id) + delta
+		batch.Set(volumeIndexKey(id), encodeUnix(live[id]), nil)
+	}
+
+	totalBytes, totalObjects := b.totalBytes, b.totalObjects
+	b.totalBytes += c.bytes
+	b.totalObjects += c.objects
+	batch.Set(statsKey, b.encodeStats(), nil)
+
+	if err := batch.Commit(pebble.Sync); err != nil {
+		b.totalBytes, b.totalObjects = totalBytes, totalObjects
+		volumeErrors.WithLabelValues("index_commit").Inc()
+		return fmt.Errorf("failed to update index: %w", err)
+	}
+
+	for id, n := range live {
+		b.volumes.setLive(id, n)
+	}
+	return nil
+}
+
+func encodeUnix(t int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(t))
+}
+
+// Get returns a reader over an object or a byte range of it, read
+// straight from the volume at the needle offset
+func (b *Backend) Get(ctx context.Context, bucket, key string, opts storage.GetOptions) (io.ReadCloser, error) {
+	b.readMu.RLock()
+	defer b.readMu.RUnlock()
+
+	indexKey := objectIndexKey(bucket, key)
+	e, err := b.lookup(indexKey)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return nil, fmt.Errorf("%w: %s/%s", storage.ErrObjectNotFound, bucket, key)
+	}
+
+	start, end := int64(0), e.Size
+	if r := opts.Range; r != nil {
+		start, end = r.Start, r.End
+		if end > e.Size {
+			end = e.Size
+		}
+		if start < 0 || start > end {
+			return nil, fmt.Errorf("invalid range %d-%d for %d bytes", r.Start, r.End, e.Size)
+		}
+	}
+	return b.open(indexKey, e, start, end-start)
+}
+
+// Delete removes an object from the index. Its needle stays in the volume
+// until the volume is compacted.
+func (b *Backend) Delete(ctx context.Context, bucket, key string) error {
+	indexKey := objectIndexKey(bucket, key)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	old, err := b.lookup(indexKey)
+	if err != nil {
+		return err
+	}
+	if old == nil {
+		return nil // Already deleted
+	}
+
+	batch := b.index.NewBatch()
+	defer batch.Close()
+	batch.Delete(indexKey, nil)
+
+	c := newChange(true)
+	c.add(indexKey, old, -1)
+	return b.commit(batch, c)
+}
+
+// Head returns object metadata from the index without touching the volume
+func (b *Backend) Head(ctx context.Context, bucket, key string) (*storage.ObjectInfo, error) {
+	e, err := b.lookup(objectIndexKey(bucket, key))
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return nil, fmt.Errorf("%w: %s/%s", storage.ErrObjectNotFound, bucket, key)
+	}
+
+	return &storage.ObjectInfo{
+		Key:          key,
+		Size:         e.Size,
+		ETag:         e.etag(),
+		LastModified: e.LastModified,
+	}, nil
+}
+
+// List lists objects in key order from the index. With a delimiter, keys
+// sharing a common prefix are rolled up and skipped over with one seek.
+func (b *Backend) List(ctx context.Context, bucket, prefix string, opts storage.ListOptions) (*storage.ListResult, error) {
+	exists, err := b.exists(bucketIndexKey(bucket))
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", storage.ErrBucketNotFound, bucket)
+	}
+
+	objects := objectIndexPrefix(bucket)
+	lower := objectIndexKey(bucket, prefix)
+	if opts.Marker != "" {
+		after := append(objectIndexKey(bucket, opts.Marker), 0)
+		// A marker naming a common prefix resumes after all of its keys
+		if opts.Delimiter != "" && commonPrefix(opts.Marker, prefix, opts.Delimiter) == opts.Marker {
+			after = prefixEnd(objectIndexKey(bucket, opts.Marker))
+		}
+		if bytes.Compare(after, lower) > 0 {
+			lower = after
+		}
+	}
+
+	iter, err := b.index.NewIter(&pebble.IterOptions{
+		LowerBound: lower,
+		UpperBound: prefixEnd(objectIndexKey(bucket, prefix)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+	defer iter.Close()
+
+	result := &storage.ListResult{}
+	count := 0
+	for valid := iter.First(); valid; {
+		if opts.MaxKeys > 0 && count >= opts.MaxKeys {
+			break
+		}
+		key := string(iter.Key()[len(objects):])
+
+		if opts.Delimiter != "" {
+			if common := commonPrefix(key, prefix, opts.Delimiter); common != "" {
+				result.CommonPrefixes = append(result.CommonPrefixes, common)
+				count++
+				valid = iter.SeekGE(prefixEnd(objectIndexKey(bucket, common)))
+				continue
+			}
+		}
+
+		e, err := decodeEntry(iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		result.Objects = append(result.Objects, storage.ObjectInfo{
+			Key:          key,
+			Size:         e.Size,
+			ETag:         e.etag(),
+			LastModified: e.LastModified,
+		})
+		count++
+		valid = iter.Next()
+	}
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	return result, nil
+}
+
+// commonPrefix returns key up to and including the first delimiter after
+// prefix, or "" when key is not rolled up
+func commonPrefix(key, prefix, delimiter string) string {
+	if !strings.HasPrefix(key, prefix) {
+		return ""
+	}
+	idx := strings.Index(key[len(prefix):], delimiter)
+	if idx < 0 {
+		return ""
+	}
+	return key[:len(prefix)+idx+len(delimiter)]
+}
+
+// CreateBucket creates a bucket namespace
+func (b *Backend) CreateBucket(ctx context.Context, bucket string) error {
+	if err := validateName("bucket name", bucket); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	exists, err := b.exists(bucketIndexKey(bucket))
+	if err != nil || exists {
+		return err
+	}
+	if err := b.index.Set(bucketIndexKey(bucket), encodeUnix(time.Now().Unix()), pebble.Sync); err != nil {
+		volumeErrors.WithLabelValues("create_bucket").Inc()
+		return fmt.Errorf("failed to create bucket: %w", err)
+	}
+	return nil
+}
+
+// DeleteBucket deletes an empty bucket namespace
+func (b *Backend) DeleteBucket(ctx context.Context, bucket string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	exists, err := b.exists(bucketIndexKey(bucket))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: %s", storage.ErrBucketNotFound, bucket)
+	}
+
+	objects := objectIndexPrefix(bucket)
+	iter, err := b.index.NewIter(&pebble.IterOptions{LowerBound: objects, UpperBound: prefixEnd(objects)})
+	if err != nil {
+		return fmt.Errorf("failed to read bucket: %w", err)
+	}
+	empty := !iter.First()
+	iter.Close()
+	if !empty {
+		return fmt.Errorf("bucket not empty: %s", bucket)
+	}
+
+	if err := b.index.Delete(bucketIndexKey(bucket), pebble.Sync); err != nil {
+		volumeErrors.WithLabelValues("delete_bucket").Inc()
+		return fmt.Errorf("failed to delete bucket: %w", err)
+	}
+	return nil
+}
+
+// ListBuckets lists all bucket namespaces in name order
+func (b *Backend) ListBuckets(ctx context.Context) ([]storage.BucketInfo, error) {
+	lower := []byte{bucketPrefix}
+	iter, err := b.index.NewIter(&pebble.IterOptions{LowerBound: lower, UpperBound: prefixEnd(lower)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read buckets: %w", err)
+	}
+	defer iter.Close()
+
+	var buckets []storage.BucketInfo
+	for iter.First(); iter.Valid(); iter.Next() {
+		var created int64
+		if v := iter.Value(); len(v) == 8 {
+			created = int64(binary.BigEndian.Uint64(v))
+		}
+		buckets = append(buckets, storage.BucketInfo{
+			Name:         string(iter.Key()[1:]),
+			CreationDate: created,
+		})
+	}
+	return buckets, iter.Error()
+}
+
+// PutPart stages a multipart upload part as a needle outside every bucket
+func (b *Backend) PutPart(ctx context.Context, uploadID string, partNumber int, data io.Reader, size int64) error {
+	if err := validateName("upload ID", uploadID); err != nil {
+		return err
+	}
+
+	sp, err := b.spool(data, size)
+	if err != nil {
+		return err
+	}
+	defer sp.close()
+
+	indexKey := partIndexKey(uploadID, partNumber)
+	e, err := b.write(indexKey, sp)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Re-uploading a part number replaces the earlier needle
+	old, err := b.lookup(indexKey)
+	if err != nil {
+		return err
+	}
+
+	batch := b.index.NewBatch()
+	defer batch.Close()
+	batch.Set(indexKey, e.encode(), nil)
+
+	c := newChange(false)
+	c.add(indexKey, e, 1)
+	c.add(indexKey, old, -1)
+	return b.commit(batch, c)
+}
+
+// GetPart returns a reader over a staged multipart upload part
+func (b *Backend) GetPart(ctx context.Context, uploadID string, partNumber int) (io.ReadCloser, error) {
+	if err := validateName("upload ID", uploadID); err != nil {
+		return nil, err
+	}
+
+	b.readMu.RLock()
+	defer b.readMu.RUnlock()
+
+	indexKey := partIndexKey(uploadID, partNumber)
+	e, err := b.lookup(indexKey)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return nil, fmt.Errorf("part not found: %s/%d", uploadID, partNumber)
+	}
+	return b.open(indexKey, e, 0, e.Size)
+}
+
+// DeleteUpload removes the index entries of every staged part of an upload
+func (b *Backend) DeleteUpload(ctx context.Context, uploadID string) error {
+	if err := validateName("upload ID", uploadID); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	prefix := partIndexPrefix(uploadID)
+	iter, err := b.index.NewIter(&pebble.IterOptions{LowerBound: prefix, UpperBound: prefixEnd(prefix)})
+	if err != nil {
+		return fmt.Errorf("failed to read upload: %w", err)
+	}
+	c := newChange(false)
+	for iter.First(); iter.Valid(); iter.Next() {
+		e, err := decodeEntry(iter.Value())
+		if err != nil {
+			iter.Close()
+			return err
+		}
+		c.add(iter.Key(), e, -1)
+	}
+	if err := iter.Close(); err != nil {
+		return fmt.Errorf("failed to read upload: %w", err)
+	}
+
+	batch := b.index.NewBatch()
+	defer batch.Close()
+	batch.DeleteRange(prefix, prefixEnd(prefix), nil)
+	return b.commit(batch, c)
+}
+
+// ComputeStorageMetrics returns the byte and object totals kept in the
+// index, without scanning it
+func (b *Backend) ComputeStorageMetrics() (int64, int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.totalBytes, b.totalObjects, nil
+}
+
+// Close closes the index and every volume
+func (b *Backend) Close() error {
+	var err error
+	if b.index != nil {
+		err = b.index.Close()
+	}
+	if b.volumes != nil {
+		b.volumes.Close()
+	}
+	return err
+}
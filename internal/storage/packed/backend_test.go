@@ -0,0 +1,261 @@
+package packed
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/openendpoint/openendpoint/internal/storage"
+)
+
+var _ storage.StorageBackend = (*Backend)(nil)
+
+func newTestBackend(t *testing.T, dir string) *Backend {
+	t.Helper()
+	b, err := New(dir, 1024*1024)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	return b
+}
+
+func readAll(t *testing.T, rc io.ReadCloser, err error) string {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("ReadAll error: %v", err)
+	}
+	return string(data)
+}
+
+func TestBackend_PutGetHead(t *testing.T) {
+	b := newTestBackend(t, t.TempDir())
+	defer b.Close()
+	ctx := context.Background()
+
+	if err := b.Put(ctx, "bucket", "key", strings.NewReader("hello world"), 11, storage.PutOptions{}); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+
+	rc, err := b.Get(ctx, "bucket", "key", storage.GetOptions{})
+	if got := readAll(t, rc, err); got != "hello world" {
+		t.Errorf("Get = %q, want %q", got, "hello world")
+	}
+
+	rc, err = b.Get(ctx, "bucket", "key", storage.GetOptions{Range: &storage.Range{Start: 6, End: 100}})
+	if got := readAll(t, rc, err); got != "world" {
+		t.Errorf("Get range = %q, want %q", got, "world")
+	}
+
+	info, err := b.Head(ctx, "bucket", "key")
+	if err != nil {
+		t.Fatalf("Head error: %v", err)
+	}
+	if info.Size != 11 || info.ETag != "\"5eb63bbbe01eeed093cb22bb8f5acdc3\"" {
+		t.Errorf("Head = %+v", info)
+	}
+
+	if _, err := b.Head(ctx, "bucket", "missing"); err == nil {
+		t.Error("expected error for missing object")
+	}
+	if err := b.Put(ctx, "bucket", "short", strings.NewReader("abc"), 5, storage.PutOptions{}); err == nil {
+		t.Error("expected size mismatch error")
+	}
+	if err := b.Put(ctx, "bucket", "a\x00b", strings.NewReader("abc"), 3, storage.PutOptions{}); err == nil {
+		t.Error("expected error for key with null byte")
+	}
+}
+
+func TestBackend_LargeObject(t *testing.T) {
+	b := newTestBackend(t, t.TempDir())
+	defer b.Close()
+	ctx := context.Background()
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), maxBufferedNeedle/8)
+	if err := b.Put(ctx, "bucket", "large", bytes.NewReader(data), int64(len(data)), storage.PutOptions{}); err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+
+	rc, err := b.Get(ctx, "bucket", "large", storage.GetOptions{})
+	if got := readAll(t, rc, err); got != string(data) {
+		t.Errorf("Get returned %d bytes, want %d", len(got), len(data))
+	}
+}
+
+func TestBackend_OverwriteAndDelete(t *testing.T) {
+	b := newTestBackend(t, t.TempDir())
+	defer b.Close()
+	ctx := context.Background()
+
+	b.Put(ctx, "bucket", "key", strings.NewReader("first"), 5, storage.PutOptions{})
+	b.Put(ctx, "bucket", "key", strings.NewReader("second!"), 7, storage.PutOptions{})
+
+	rc, err := b.Get(ctx, "bucket", "key", storage.GetOptions{})
+	if got := readAll(t, rc, err); got != "second!" {
+		t.Errorf("Get = %q, want %q", got, "second!")
+	}
+	if size, count, _ := b.ComputeStorageMetrics(); size != 7 || count != 1 {
+		t.Errorf("metrics = %d bytes, %d objects; want 7, 1", size, count)
+	}
+
+	if err := b.Delete(ctx, "bucket", "key"); err != nil {
+		t.Fatalf("Delete error: %v", err)
+	}
+	if err := b.Delete(ctx, "bucket", "key"); err != nil {
+		t.Errorf("second Delete error: %v", err)
+	}
+	if _, err := b.Get(ctx, "bucket", "key", storage.GetOptions{}); err == nil {
+		t.Error("expected error for deleted object")
+	}
+	if size, count, _ := b.ComputeStorageMetrics(); size != 0 || count != 0 {
+		t.Errorf("metrics = %d bytes, %d objects; want 0, 0", size, count)
+	}
+}
+
+func TestBackend_List(t *testing.T) {
+	b := newTestBackend(t, t.TempDir())
+	defer b.Close()
+	ctx := context.Background()
+
+	for _, key := range []string{"a.txt", "dir/one", "dir/two", "dir2/x", "z.txt"} {
+		if err := b.Put(ctx, "bucket", key, strings.NewReader("x"), 1, storage.PutOptions{}); err != nil {
+			t.Fatalf("Put %s error: %v", key, err)
+		}
+	}
+	b.Put(ctx, "other", "dir/three", strings.NewReader("x"), 1, storage.PutOptions{})
+
+	keys := func(r *storage.ListResult) []string {
+		var out []string
+		for _, obj := range r.Objects {
+			out = append(out, obj.Key)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name     string
+		prefix   string
+		opts     storage.ListOptions
+		objects  []string
+		prefixes []string
+	}{
+		{"all", "", storage.ListOptions{}, []string{"a.txt", "dir/one", "dir/two", "dir2/x", "z.txt"}, nil},
+		{"prefix", "dir/", storage.ListOptions{}, []string{"dir/one", "dir/two"}, nil},
+		{"delimiter", "", storage.ListOptions{Delimiter: "/"}, []string{"a.txt", "z.txt"}, []string{"dir/", "dir2/"}},
+		{"marker", "", storage.ListOptions{Marker: "dir/one"}, []string{"dir/two", "dir2/x", "z.txt"}, nil},
+		{"marker prefix", "", storage.ListOptions{Delimiter: "/", Marker: "dir/"}, []string{"z.txt"}, []string{"dir2/"}},
+		{"max keys", "", storage.ListOptions{Delimiter: "/", MaxKeys: 2}, []string{"a.txt"}, []string{"dir/"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := b.List(ctx, "bucket", tt.prefix, tt.opts)
+			if err != nil {
+				t.Fatalf("List error: %v", err)
+			}
+			if got := keys(result); !reflect.DeepEqual(got, tt.objects) {
+				t.Errorf("objects = %v, want %v", got, tt.objects)
+			}
+			if !reflect.DeepEqual(result.CommonPrefixes, tt.prefixes) {
+				t.Errorf("prefixes = %v, want %v", result.CommonPrefixes, tt.prefixes)
+			}
+		})
+	}
+
+	if _, err := b.List(ctx, "missing", "", storage.ListOptions{}); err == nil {
+		t.Error("expected error for missing bucket")
+	}
+}
+
+func TestBackend_Buckets(t *testing.T) {
+	b := newTestBackend(t, t.TempDir())
+	defer b.Close()
+	ctx := context.Background()
+
+	if err := b.CreateBucket(ctx, "one"); err != nil {
+		t.Fatalf("CreateBucket error: %v", err)
+	}
+	if err := b.CreateBucket(ctx, "one"); err != nil {
+		t.Errorf("repeated CreateBucket error: %v", err)
+	}
+	b.CreateBucket(ctx, "two")
+	b.Put(ctx, "two", "key", strings.NewReader("x"), 1, storage.PutOptions{})
+
+	buckets, err := b.ListBuckets(ctx)
+	if err != nil {
+		t.Fatalf("ListBuckets error: %v", err)
+	}
+	if len(buckets) != 2 || buckets[0].Name != "one" || buckets[1].Name != "two" {
+		t.Errorf("ListBuckets = %+v", buckets)
+	}
+
+	if err := b.DeleteBucket(ctx, "two"); err == nil {
+		t.Error("expected error deleting non-empty bucket")
+	}
+	if err := b.DeleteBucket(ctx, "one"); err != nil {
+		t.Errorf("DeleteBucket error: %v", err)
+	}
+	if err := b.DeleteBucket(ctx, "one"); err == nil {
+		t.Error("expected error deleting missing bucket")
+	}
+}
+
+func TestBackend_Parts(t *testing.T) {
+	b := newTestBackend(t, t.TempDir())
+	defer b.Close()
+	ctx := context.Background()
+
+	b.PutPart(ctx, "upload", 1, strings.NewReader("part one"), 8)
+	b.PutPart(ctx, "upload", 2, strings.NewReader("part two"), 8)
+	b.PutPart(ctx, "upload", 1, strings.NewReader("part 1"), 6)
+
+	rc, err := b.GetPart(ctx, "upload", 1)
+	if got := readAll(t, rc, err); got != "part 1" {
+		t.Errorf("GetPart = %q, want %q", got, "part 1")
+	}
+	if size, count, _ := b.ComputeStorageMetrics(); size != 0 || count != 0 {
+		t.Errorf("parts counted in metrics: %d bytes, %d objects", size, count)
+	}
+
+	if err := b.DeleteUpload(ctx, "upload"); err != nil {
+		t.Fatalf("DeleteUpload error: %v", err)
+	}
+	if _, err := b.GetPart(ctx, "upload", 2); err == nil {
+		t.Error("expected error for deleted part")
+	}
+}
+
+func TestBackend_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	b := newTestBackend(t, dir)
+	b.Put(ctx, "bucket", "key", strings.NewReader("persisted"), 9, storage.PutOptions{})
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+
+	b = newTestBackend(t, dir)
+	defer b.Close()
+
+	rc, err := b.Get(ctx, "bucket", "key", storage.GetOptions{})
+	if got := readAll(t, rc, err); got != "persisted" {
+		t.Errorf("Get after reopen = %q, want %q", got, "persisted")
+	}
+	if size, count, _ := b.ComputeStorageMetrics(); size != 9 || count != 1 {
+		t.Errorf("metrics after reopen = %d bytes, %d objects; want 9, 1", size, count)
+	}
+
+	// New needles go after the existing ones
+	b.Put(ctx, "bucket", "next", strings.NewReader("more"), 4, storage.PutOptions{})
+	rc, err = b.Get(ctx, "bucket", "key", storage.GetOptions{})
+	if got := readAll(t, rc, err); got != "persisted" {
+		t.Errorf("Get after append = %q, want %q", got, "persisted")
+	}
+}
@@ -0,0 +1,374 @@
+package packed
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	volumesCompacted = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "openendpoint_packed_volumes_compacted_total",
+			Help: "Total packed volumes rewritten by compaction",
+		},
+	)
+	bytesReclaimed = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "openendpoint_packed_bytes_reclaimed_total",
+			Help: "Total bytes of packed volumes freed by compaction",
+		},
+	)
+)
+
+// DefaultCompactionThreshold is the garbage ratio above which a full
+// volume is compacted
+const DefaultCompactionThreshold = 0.5
+
+// compactionBatch is the number of copied needles whose index entries are
+// moved in one batch
+const compactionBatch = 1000
+
+// VolumeStats describes the space use of one volume
+type VolumeStats struct {
+	ID           uint64  `json:"id"`
+	Size         int64   `json:"size"`
+	LiveBytes    int64   `json:"liveBytes"`
+	GarbageRatio float64 `json:"garbageRatio"`
+	Sealed       bool    `json:"sealed"`
+}
+
+// CompactionStatus reports the state of the compactor and the space use
+// of every volume
+type CompactionStatus struct {
+	Running          bool          `json:"running"`
+	CurrentVolume    uint64        `json:"currentVolume,omitempty"`
+	Threshold        float64       `json:"threshold"`
+	LastStarted      int64         `json:"lastStarted,omitempty"`
+	LastFinished     int64         `json:"lastFinished,omitempty"`
+	LastError        string        `json:"lastError,omitempty"`
+	VolumesCompacted int64         `json:"volumesCompacted"`
+	NeedlesMoved     int64         `json:"needlesMoved"`
+	BytesReclaimed   int64         `json:"bytesReclaimed"`
+	Volumes          []VolumeStats `json:"volumes"`
+}
+
+// volumeStats returns the space use of every volume in ID order
+func (vm *VolumeManager) volumeStats() []VolumeStats {
+	vm.mu.RLock()
+	defer vm.mu.RUnlock()
+
+	stats := make([]VolumeStats, 0, len(vm.volumes))
+	for id, vol := range vm.volumes {
+		vol.mu.RLock()
+		v := VolumeStats{ID: id, Size: vol.size, LiveBytes: vol.live, Sealed: vol.sealed}
+		vol.mu.RUnlock()
+		if v.Size > 0 {
+			v.GarbageRatio = float64(v.Size-v.LiveBytes) / float64(v.Size)
+		}
+		stats = append(stats, v)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	return stats
+}
+
+// compactionCandidates returns the full volumes whose garbage ratio is at
+// least threshold. Volumes still being filled are left alone.
+func (b *Backend) compactionCandidates(threshold float64) []uint64 {
+	var ids []uint64
+	for _, v := range b.volumes.volumeStats() {
+		if v.Size >= b.volumes.maxSize && v.LiveBytes < v.Size && v.GarbageRatio >= threshold {
+			ids = append(ids, v.ID)
+		}
+	}
+	return ids
+}
+
+// move is a live needle copied out of a volume being compacted
+type move struct {
+	key      []byte
+	from, to *entry
+}
+
+// compactVolume copies the live needles of a volume to writable volumes,
+// verifying each against its CRC32, moves their index entries and then
+// drops the volume. Reads and writes continue throughout: the volume is
+// sealed first, and entries overwritten or deleted while their needle was
+// being copied are left alone.
+func (b *Backend) compactVolume(ctx context.Context, id uint64) (moved, reclaimed int64, err error) {
+	vol, err := b.volumes.seal(id)
+	if err != nil {
+		return 0, 0, err
+	}
+	vol.mu.RLock()
+	size := vol.size
+	vol.mu.RUnlock()
+
+	var moves []move
+	dests := make(map[uint64]*Volume)
+	flush := func() error {
+		if len(moves) == 0 {
+			return nil
+		}
+		// Copies must be on disk before the index points at them
+		for _, dest := range dests {
+			if err := dest.sync(); err != nil {
+				return fmt.Errorf("failed to sync volume %d: %w", dest.id, err)
+			}
+		}
+		n, err := b.commitMoves(id, moves)
+		moved += n
+		moves = moves[:0]
+		return err
+	}
+
+	_, scanErr := vol.scan(size, func(needle *Needle) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		key := []byte(needle.Key)
+		e, err := b.lookup(key)
+		if err != nil {
+			return err
+		}
+		if e == nil || e.Volume != id || e.Offset != needle.Offset {
+			return nil // Deleted or overwritten
+		}
+
+		crc := crc32.NewIEEE()
+		data := io.TeeReader(vol.section(needle, 0, needle.Size), crc)
+		dest, copied, err := b.volumes.append(needle.Key, data, needle.Size, needle.Checksum)
+		if err != nil {
+			return fmt.Errorf("failed to copy needle %q: %w", needle.Key, err)
+		}
+		if crc.Sum32() != needle.Checksum {
+			volumeErrors.WithLabelValues("checksum").Inc()
+			return fmt.Errorf("%w: checksum mismatch for %q at offset %d", errCorruptNeedle, needle.Key, needle.Offset)
+		}
+
+		to := *e
+		to.Volume, to.Offset = dest.id, copied.Offset
+		moves = append(moves, move{key: key, from: e, to: &to})
+		dests[dest.id] = dest
+		if len(moves) >= compactionBatch {
+			return flush()
+		}
+		return nil
+	})
+	if err := flush(); err != nil && scanErr == nil {
+		scanErr = err
+	}
+
+	// The volume can only go once nothing references it. A needle that
+	// could not be scanned, such as a torn write at the tail after a
+	// crash, is dropped with it if no entry points at it.
+	if live := b.volumes.liveBytes(id); live != 0 {
+		b.volumes.unseal(id)
+		if scanErr != nil {
+			return moved, 0, fmt.Errorf("failed to compact volume %d: %w", id, scanErr)
+		}
+		return moved, 0, fmt.Errorf("volume %d still holds %d live bytes after compaction", id, live)
+	}
+
+	b.mu.Lock()
+	err = b.index.Delete(volumeIndexKey(id), pebble.Sync)
+	b.mu.Unlock()
+	if err != nil {
+		b.volumes.unseal(id)
+		return moved, 0, fmt.Errorf("failed to update index: %w", err)
+	}
+
+	b.readMu.Lock()
+	b.volumes.remove(id)
+	b.readMu.Unlock()
+
+	volumesCompacted.Inc()
+	bytesReclaimed.Add(float64(size))
+	return moved, size, nil
+}
+
+// commitMoves points the index entries of copied needles at their copies
+// in one batch and returns how many were moved. Entries that changed
+// since their needle was copied keep their new value, leaving the copy as
+// garbage.
+func (b *Backend) commitMoves(id uint64, moves []move) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	batch := b.index.NewBatch()
+	defer batch.Close()
+
+	c := newChange(false)
+	var n int64
+	for _, m := range moves {
+		cur, err := b.lookup(m.key)
+		if err != nil {
+			return 0, err
+		}
+		if cur == nil || cur.Volume != id || cur.Offset != m.from.Offset {
+			continue
+		}
+		batch.Set(m.key, m.to.encode(), nil)
+		c.add(m.key, m.from, -1)
+		c.add(m.key, m.to, 1)
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	if err := b.commit(batch, c); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Compactor rewrites volumes whose garbage ratio exceeds a threshold, on
+// an interval and on demand
+type Compactor struct {
+	backend   *Backend
+	interval  time.Duration
+	threshold float64
+
+	triggerCh chan float64
+	stopCh    chan struct{}
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+
+	mu     sync.Mutex
+	status CompactionStatus
+}
+
+// NewCompactor creates a compactor for a packed backend. An interval of 0
+// disables periodic passes, leaving only triggered ones.
+func NewCompactor(b *Backend, interval time.Duration, threshold float64) *Compactor {
+	if threshold <= 0 || threshold > 1 {
+		threshold = DefaultCompactionThreshold
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Compactor{
+		backend:   b,
+		interval:  interval,
+		threshold: threshold,
+		triggerCh: make(chan float64, 1),
+		stopCh:    make(chan struct{}),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Start starts the compactor
+func (c *Compactor) Start() {
+	c.wg.Add(1)
+	go c.run()
+}
+
+// Stop stops the compactor, abandoning a pass in progress. Volumes
+// compacted so far stay compacted.
+func (c *Compactor) Stop() {
+	close(c.stopCh)
+	c.cancel()
+	c.wg.Wait()
+}
+
+// Trigger queues a compaction pass. A positive threshold replaces the
+// configured one for this pass. It returns false if a pass is already
+// queued.
+func (c *Compactor) Trigger(threshold float64) bool {
+	select {
+	case c.triggerCh <- threshold:
+		return true
+	default:
+		return false
+	}
+}
+
+// Status returns the compactor state and the space use of every volume
+func (c *Compactor) Status() CompactionStatus {
+	c.mu.Lock()
+	status := c.status
+	c.mu.Unlock()
+
+	status.Threshold = c.threshold
+	status.Volumes = c.backend.volumes.volumeStats()
+	return status
+}
+
+// run runs the compactor loop
+func (c *Compactor) run() {
+	defer c.wg.Done()
+
+	var tick <-chan time.Time
+	if c.interval > 0 {
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-tick:
+			c.compact(c.threshold)
+		case threshold := <-c.triggerCh:
+			if threshold <= 0 {
+				threshold = c.threshold
+			}
+			c.compact(threshold)
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+// compact runs one pass over the volumes at or above threshold
+func (c *Compactor) compact(threshold float64) {
+	c.mu.Lock()
+	c.status.Running = true
+	c.status.LastStarted = time.Now().Unix()
+	c.status.LastError = ""
+	c.mu.Unlock()
+
+	var lastErr error
+	for _, id := range c.backend.compactionCandidates(threshold) {
+		if c.ctx.Err() != nil {
+			break
+		}
+
+		c.mu.Lock()
+		c.status.CurrentVolume = id
+		c.mu.Unlock()
+
+		moved, reclaimed, err := c.backend.compactVolume(c.ctx, id)
+
+		c.mu.Lock()
+		c.status.NeedlesMoved += moved
+		c.status.BytesReclaimed += reclaimed
+		if err == nil {
+			c.status.VolumesCompacted++
+		}
+		c.mu.Unlock()
+		if err != nil {
+			volumeErrors.WithLabelValues("compact").Inc()
+			lastErr = err
+		}
+	}
+
+	c.mu.Lock()
+	c.status.Running = false
+	c.status.CurrentVolume = 0
+	c.status.LastFinished = time.Now().Unix()
+	if lastErr != nil {
+		c.status.LastError = lastErr.Error()
+	}
+	c.mu.Unlock()
+}
@@ -0,0 +1,216 @@
+package packed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openendpoint/openendpoint/internal/storage"
+)
+
+// fillVolume writes objects into a backend with tiny volumes, filling
+// several, then deletes every other object so they are half garbage. It
+// returns the remaining objects and the first full volume.
+func fillVolume(t *testing.T, b *Backend) (map[string]string, uint64) {
+	t.Helper()
+	ctx := context.Background()
+
+	objects := make(map[string]string)
+	for i := 0; i < 8; i++ {
+		key, value := fmt.Sprintf("key-%d", i), strings.Repeat(fmt.Sprint(i), 40)
+		if err := b.Put(ctx, "bucket", key, strings.NewReader(value), int64(len(value)), storage.PutOptions{}); err != nil {
+			t.Fatalf("Put error: %v", err)
+		}
+		objects[key] = value
+	}
+	for i := 0; i < 8; i += 2 {
+		key := fmt.Sprintf("key-%d", i)
+		if err := b.Delete(ctx, "bucket", key); err != nil {
+			t.Fatalf("Delete error: %v", err)
+		}
+		delete(objects, key)
+	}
+
+	candidates := b.compactionCandidates(0.3)
+	if len(candidates) == 0 {
+		t.Fatal("no volume to compact")
+	}
+	return objects, candidates[0]
+}
+
+func checkObjects(t *testing.T, b *Backend, objects map[string]string) {
+	t.Helper()
+	for key, want := range objects {
+		rc, err := b.Get(context.Background(), "bucket", key, storage.GetOptions{})
+		if got := readAll(t, rc, err); got != want {
+			t.Errorf("Get %s = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestBackend_CompactVolume(t *testing.T) {
+	dir := t.TempDir()
+	b, err := New(dir, 256)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	objects, id := fillVolume(t, b)
+	path := b.volumes.volumes[id].path
+	size, _, _ := b.ComputeStorageMetrics()
+
+	moved, reclaimed, err := b.compactVolume(context.Background(), id)
+	if err != nil {
+		t.Fatalf("compactVolume error: %v", err)
+	}
+	if moved == 0 || reclaimed == 0 {
+		t.Errorf("moved %d needles, reclaimed %d bytes", moved, reclaimed)
+	}
+	if _, ok := b.volumes.volumes[id]; ok {
+		t.Errorf("volume %d still open after compaction", id)
+	}
+
+	checkObjects(t, b, objects)
+	if after, count, _ := b.ComputeStorageMetrics(); after != size || count != int64(len(objects)) {
+		t.Errorf("metrics = %d bytes, %d objects; want %d, %d", after, count, size, len(objects))
+	}
+
+	// The file goes once its readers are done
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("volume file not removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Moved entries and live bytes survive a restart
+	b.Close()
+	b, err = New(dir, 256)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	defer b.Close()
+	checkObjects(t, b, objects)
+	for _, v := range b.volumes.volumeStats() {
+		if v.LiveBytes > v.Size {
+			t.Errorf("volume %d: %d live bytes of %d", v.ID, v.LiveBytes, v.Size)
+		}
+	}
+}
+
+func TestBackend_CompactVolumeOpenReader(t *testing.T) {
+	b, err := New(t.TempDir(), 256)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	defer b.Close()
+	objects, id := fillVolume(t, b)
+
+	rc, err := b.Get(context.Background(), "bucket", "key-1", storage.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	if _, _, err := b.compactVolume(context.Background(), id); err != nil {
+		t.Fatalf("compactVolume error: %v", err)
+	}
+
+	// A reader opened before compaction keeps reading the old volume
+	if got := readAll(t, rc, nil); got != objects["key-1"] {
+		t.Errorf("Get = %q, want %q", got, objects["key-1"])
+	}
+}
+
+func TestBackend_CompactVolumeCorrupt(t *testing.T) {
+	b, err := New(t.TempDir(), 256)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	defer b.Close()
+	objects, id := fillVolume(t, b)
+
+	// Flip a data byte of a live needle
+	e, _ := b.lookup(objectIndexKey("bucket", "key-1"))
+	if e.Volume != id {
+		t.Fatalf("key-1 in volume %d, want %d", e.Volume, id)
+	}
+	vol := b.volumes.volumes[id]
+	off := e.Offset + e.length(objectIndexKey("bucket", "key-1")) - 1
+	if _, err := vol.file.WriteAt([]byte("x"), off); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = b.compactVolume(context.Background(), id)
+	if !errors.Is(err, errCorruptNeedle) {
+		t.Fatalf("compactVolume error = %v, want corrupt needle", err)
+	}
+	if vol.sealed {
+		t.Error("volume left sealed after failed compaction")
+	}
+
+	delete(objects, "key-1")
+	checkObjects(t, b, objects)
+}
+
+func TestCompactor(t *testing.T) {
+	b, err := New(t.TempDir(), 256)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	defer b.Close()
+	objects, _ := fillVolume(t, b)
+
+	c := NewCompactor(b, 0, 0.9)
+	c.Start()
+	defer c.Stop()
+
+	if status := c.Status(); status.Threshold != 0.9 || len(status.Volumes) == 0 {
+		t.Errorf("Status = %+v", status)
+	}
+
+	if !c.Trigger(0.3) {
+		t.Fatal("Trigger returned false")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status := c.Status()
+		if !status.Running && status.VolumesCompacted > 0 {
+			if status.LastError != "" || status.BytesReclaimed == 0 {
+				t.Errorf("Status = %+v", status)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("compaction did not finish: %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	checkObjects(t, b, objects)
+}
+
+func TestVolumeManagerStatsGarbage(t *testing.T) {
+	vm, err := NewVolumeManager(t.TempDir(), 1024*1024)
+	if err != nil {
+		t.Fatalf("NewVolumeManager error: %v", err)
+	}
+	defer vm.Close()
+
+	vm.Write("one", []byte("first"))
+	vm.Write("two", []byte("second"))
+	if ratio := vm.Stats()["garbage_ratio"].(float64); ratio != 0 {
+		t.Errorf("garbage_ratio = %v, want 0", ratio)
+	}
+
+	vm.Delete("one")
+	ratio := vm.Stats()["garbage_ratio"].(float64)
+	if ratio <= 0 || ratio >= 1 {
+		t.Errorf("garbage_ratio after delete = %v, want between 0 and 1", ratio)
+	}
+}
@@ -2,7 +2,9 @@ package packed
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
@@ -12,15 +14,51 @@ import (
 	"time"
 )
 
+// Needle layout on disk: a fixed header, the key, then the data. All
+// integers are little endian.
+//
+//	0  cookie        uint32  CRC32 of the key
+//	4  checksum      uint32  CRC32 of the data
+//	8  size          uint64  data length
+//	16 lastModified  int64   Unix seconds
+//	24 keyLen        uint16
+//	26 flags         uint8   reserved, 0
+//	27 version       uint8   needleVersion
+//	28 reserved      4 bytes
+const (
+	needleHeaderSize = 32
+	needleVersion    = 2
+)
+
+// errCorruptNeedle is returned when a needle header or its data does not
+// match what was written
+var errCorruptNeedle = errors.New("corrupt needle")
+
+// errVolumeSealed is returned when appending to a sealed volume
+var errVolumeSealed = errors.New("volume is sealed")
+
 // Volume represents a packed volume file
 type Volume struct {
 	id     uint64
 	path   string
 	file   *os.File
 	writer *bufio.Writer
-	index  *Index
+	index  *Index // nil when needles are located through an external index
 	mu     sync.RWMutex
 	size   int64
+
+	// live is the number of bytes held by needles that are still
+	// referenced; the rest of size is garbage left by deletes and
+	// overwrites
+	live int64
+
+	// sealed volumes accept no new needles, as while being compacted. It
+	// is set holding both the manager and the volume lock.
+	sealed bool
+
+	// refs counts open readers, which keep the file open after the volume
+	// is removed
+	refs sync.WaitGroup
 }
 
 // Index stores needle metadata in memory
@@ -32,12 +70,50 @@ type Index struct {
 // Needle represents a single object in the volume
 type Needle struct {
 	Key          string
-	Offset       int64
+	Offset       int64 // offset of the needle header within the volume
 	Size         int64
 	Cookie       uint32
+	Checksum     uint32
 	LastModified int64
 }
 
+// dataOffset returns the offset of the needle's data within the volume
+func (n *Needle) dataOffset() int64 {
+	return n.Offset + needleHeaderSize + int64(len(n.Key))
+}
+
+// length returns the number of bytes the needle occupies in the volume
+func (n *Needle) length() int64 {
+	return needleHeaderSize + int64(len(n.Key)) + n.Size
+}
+
+// encodeHeader returns the on-disk header of a needle
+func (n *Needle) encodeHeader() []byte {
+	header := make([]byte, needleHeaderSize)
+	binary.LittleEndian.PutUint32(header[0:4], n.Cookie)
+	binary.LittleEndian.PutUint32(header[4:8], n.Checksum)
+	binary.LittleEndian.PutUint64(header[8:16], uint64(n.Size))
+	binary.LittleEndian.PutUint64(header[16:24], uint64(n.LastModified))
+	binary.LittleEndian.PutUint16(header[24:26], uint16(len(n.Key)))
+	header[27] = needleVersion
+	return header
+}
+
+// decodeHeader parses a needle header and returns the needle, without its
+// key, and the key length
+func decodeHeader(header []byte, offset int64) (*Needle, int, error) {
+	if len(header) < needleHeaderSize || header[27] != needleVersion {
+		return nil, 0, fmt.Errorf("%w at offset %d", errCorruptNeedle, offset)
+	}
+	return &Needle{
+		Offset:       offset,
+		Cookie:       binary.LittleEndian.Uint32(header[0:4]),
+		Checksum:     binary.LittleEndian.Uint32(header[4:8]),
+		Size:         int64(binary.LittleEndian.Uint64(header[8:16])),
+		LastModified: int64(binary.LittleEndian.Uint64(header[16:24])),
+	}, int(binary.LittleEndian.Uint16(header[24:26])), nil
+}
+
 // VolumeManager manages multiple volumes
 type VolumeManager struct {
 	rootDir   string
@@ -45,18 +121,29 @@ type VolumeManager struct {
 	currentID uint64
 	mu        sync.RWMutex
 	maxSize   int64
+
+	// externalIndex skips the in-memory needle index: volumes are not
+	// scanned on open and the caller locates needles itself
+	externalIndex bool
 }
 
 // NewVolumeManager creates a new volume manager
 func NewVolumeManager(rootDir string, maxSize int64) (*VolumeManager, error) {
+	return newVolumeManager(rootDir, maxSize, false)
+}
+
+// newVolumeManager creates a volume manager. With externalIndex, volumes
+// are not scanned on open and needles must be located by the caller.
+func newVolumeManager(rootDir string, maxSize int64, externalIndex bool) (*VolumeManager, error) {
 	if err := os.MkdirAll(rootDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create root directory: %w", err)
 	}
 
 	vm := &VolumeManager{
-		rootDir: rootDir,
-		volumes: make(map[uint64]*Volume),
-		maxSize: maxSize,
+		rootDir:       rootDir,
+		volumes:       make(map[uint64]*Volume),
+		maxSize:       maxSize,
+		externalIndex: externalIndex,
 	}
 
 	// Load existing volumes
@@ -122,12 +209,16 @@ func (vm *VolumeManager) openVolume(id uint64) (*Volume, error) {
 		path:   path,
 		file:   file,
 		writer: bufio.NewWriter(file),
-		index:  NewIndex(),
 		size:   info.Size(),
 	}
+	if vm.externalIndex {
+		return vol, nil
+	}
 
 	// Load index
+	vol.index = NewIndex()
 	if err := vol.loadIndex(); err != nil {
+		file.Close()
 		return nil, err
 	}
 
@@ -143,46 +234,87 @@ func NewIndex() *Index {
 
 // Write writes data to a volume
 func (v *Volume) Write(key string, data []byte) (int64, error) {
+	needle, err := v.append(key, bytes.NewReader(data), int64(len(data)), crc32.ChecksumIEEE(data))
+	if err != nil {
+		return 0, err
+	}
+	return needle.Offset, nil
+}
+
+// append writes size bytes of data as a new needle at the end of the
+// volume. checksum is the CRC32 of the data, computed by the caller so it
+// can be stored ahead of the data.
+func (v *Volume) append(key string, data io.Reader, size int64, checksum uint32) (*Needle, error) {
+	if len(key) > 0xffff {
+		return nil, fmt.Errorf("needle key too long: %d bytes", len(key))
+	}
+
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
-	// Get current offset
-	offset := v.size
+	if v.sealed {
+		return nil, errVolumeSealed
+	}
 
-	// Create needle
 	needle := &Needle{
 		Key:          key,
-		Offset:       offset,
-		Size:         int64(len(data)),
+		Offset:       v.size,
+		Size:         size,
 		Cookie:       crc32.ChecksumIEEE([]byte(key)),
+		Checksum:     checksum,
 		LastModified: time.Now().Unix(),
 	}
 
-	// Write header
-	header := make([]byte, 24)
-	binary.LittleEndian.PutUint64(header[0:8], uint64(needle.Cookie))
-	binary.LittleEndian.PutUint64(header[8:16], uint64(needle.Size))
-	binary.LittleEndian.PutUint64(header[16:24], uint64(needle.LastModified))
+	if err := v.writeNeedle(needle, data); err != nil {
+		// Drop the partial needle so the next append starts cleanly
+		v.writer.Reset(v.file)
+		v.file.Truncate(needle.Offset)
+		return nil, err
+	}
 
-	if _, err := v.writer.Write(header); err != nil {
-		return 0, err
+	// Update index
+	if v.index != nil {
+		if old, ok := v.index.entries[uint64(needle.Cookie)]; ok {
+			v.live -= old.length()
+		}
+		v.index.entries[uint64(needle.Cookie)] = needle
+		v.live += needle.length()
+	}
+	v.size += needle.length()
+
+	return needle, nil
+}
+
+// writeNeedle writes a needle at its offset and commits it to disk, so the
+// needle survives a crash once its index entry is committed
+func (v *Volume) writeNeedle(needle *Needle, data io.Reader) error {
+	// Reads use ReadAt, so the file position only moves with appends; seek
+	// anyway so a failed append cannot shift later needles
+	if _, err := v.file.Seek(needle.Offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	// Write header
+	if _, err := v.writer.Write(needle.encodeHeader()); err != nil {
+		return err
+	}
+	if _, err := v.writer.WriteString(needle.Key); err != nil {
+		return err
 	}
 
 	// Write data
-	if _, err := v.writer.Write(data); err != nil {
-		return 0, err
+	if n, err := io.CopyN(v.writer, data, needle.Size); err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("needle data truncated: wrote %d of %d bytes", n, needle.Size)
+		}
+		return err
 	}
 
 	// Flush to disk
 	if err := v.writer.Flush(); err != nil {
-		return 0, err
+		return err
 	}
-
-	// Update index
-	v.index.entries[uint64(needle.Cookie)] = needle
-	v.size += int64(len(header) + len(data))
-
-	return offset, nil
+	return v.file.Sync()
 }
 
 // Read reads data from a volume
@@ -196,37 +328,73 @@ func (v *Volume) Read(key string) ([]byte, error) {
 		return nil, fmt.Errorf("key not found: %s", key)
 	}
 
-	// Seek to offset
-	if _, err := v.file.Seek(needle.Offset, io.SeekStart); err != nil {
+	// Read header
+	header := make([]byte, needleHeaderSize)
+	if _, err := v.file.ReadAt(header, needle.Offset); err != nil {
 		return nil, err
 	}
-
-	// Read header
-	header := make([]byte, 24)
-	if _, err := io.ReadFull(v.file, header); err != nil {
+	stored, keyLen, err := decodeHeader(header, needle.Offset)
+	if err != nil {
 		return nil, err
 	}
+	if stored.Cookie != cookie {
+		return nil, fmt.Errorf("%w: key mismatch at offset %d", errCorruptNeedle, needle.Offset)
+	}
 
 	// Read data
-	size := int64(binary.LittleEndian.Uint64(header[8:16]))
-	data := make([]byte, size)
-	if _, err := io.ReadFull(v.file, data); err != nil {
+	data := make([]byte, stored.Size)
+	if _, err := v.file.ReadAt(data, needle.Offset+needleHeaderSize+int64(keyLen)); err != nil {
 		return nil, err
 	}
+	if crc32.ChecksumIEEE(data) != stored.Checksum {
+		return nil, fmt.Errorf("%w: checksum mismatch for %s", errCorruptNeedle, key)
+	}
 
 	return data, nil
 }
 
+// section returns a reader over length bytes of a needle's data starting
+// at off
+func (v *Volume) section(needle *Needle, off, length int64) *io.SectionReader {
+	return io.NewSectionReader(v.file, needle.dataOffset()+off, length)
+}
+
 // Delete marks a key as deleted
 func (v *Volume) Delete(key string) error {
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
 	cookie := crc32.ChecksumIEEE([]byte(key))
-	delete(v.index.entries, uint64(cookie))
+	if needle, ok := v.index.entries[uint64(cookie)]; ok {
+		v.live -= needle.length()
+		delete(v.index.entries, uint64(cookie))
+	}
 	return nil
 }
 
+// garbageRatio returns the fraction of the volume held by needles that
+// are no longer referenced
+func (v *Volume) garbageRatio() float64 {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.size == 0 {
+		return 0
+	}
+	return float64(v.size-v.live) / float64(v.size)
+}
+
+// sync flushes buffered needles and commits the volume file to disk
+func (v *Volume) sync() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if err := v.writer.Flush(); err != nil {
+		return err
+	}
+	return v.file.Sync()
+}
+
 // Close closes the volume
 func (v *Volume) Close() error {
 	v.mu.Lock()
@@ -241,59 +409,200 @@ func (v *Volume) Close() error {
 	return nil
 }
 
-// loadIndex loads the index from disk
+// loadIndex loads the index from disk by scanning every needle
 func (v *Volume) loadIndex() error {
-	// For now, rebuild index by scanning
-	// In production, you'd have a separate index file
-	if _, err := v.file.Seek(0, io.SeekStart); err != nil {
+	info, err := v.file.Stat()
+	if err != nil {
 		return err
 	}
 
+	end, err := v.scan(info.Size(), func(needle *Needle) error {
+		if old, ok := v.index.entries[uint64(needle.Cookie)]; ok {
+			v.live -= old.length()
+		}
+		v.index.entries[uint64(needle.Cookie)] = needle
+		v.live += needle.length()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	v.size = end
+	return nil
+}
+
+// scan reads the needles in the first size bytes of the volume in offset
+// order and calls fn with each. It returns the offset after the last
+// needle read.
+func (v *Volume) scan(size int64, fn func(*Needle) error) (int64, error) {
+	reader := bufio.NewReader(io.NewSectionReader(v.file, 0, size))
+	header := make([]byte, needleHeaderSize)
+
 	offset := int64(0)
 	for {
-		header := make([]byte, 24)
-		n, err := io.ReadFull(v.file, header)
+		_, err := io.ReadFull(reader, header)
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return err
+			return offset, err
 		}
-		if n < 24 {
-			break
+		needle, keyLen, err := decodeHeader(header, offset)
+		if err != nil {
+			return offset, err
+		}
+		if offset+needleHeaderSize+int64(keyLen)+needle.Size > size {
+			return offset, fmt.Errorf("%w: needle at offset %d runs past the end of the volume", errCorruptNeedle, offset)
 		}
 
-		cookie := binary.LittleEndian.Uint64(header[0:8])
-		size := int64(binary.LittleEndian.Uint64(header[8:16]))
-		lastMod := int64(binary.LittleEndian.Uint64(header[16:24]))
-
-		v.index.entries[cookie] = &Needle{
-			Offset:       offset,
-			Size:         size,
-			Cookie:       uint32(cookie),
-			LastModified: lastMod,
+		key := make([]byte, keyLen)
+		if _, err := io.ReadFull(reader, key); err != nil {
+			return offset, err
+		}
+		if crc32.ChecksumIEEE(key) != needle.Cookie {
+			return offset, fmt.Errorf("%w: key mismatch at offset %d", errCorruptNeedle, offset)
+		}
+		needle.Key = string(key)
+		if _, err := reader.Discard(int(needle.Size)); err != nil {
+			return offset, err
 		}
 
-		offset += 24 + size
+		if err := fn(needle); err != nil {
+			return offset, err
+		}
+		offset += needle.length()
 	}
 
-	return nil
+	return offset, nil
 }
 
 // Write stores data in a volume
 func (vm *VolumeManager) Write(key string, data []byte) (uint64, int64, error) {
+	vol, needle, err := vm.append(key, bytes.NewReader(data), int64(len(data)), crc32.ChecksumIEEE(data))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return vol.id, needle.Offset, nil
+}
+
+// append writes a needle to a volume with space, creating one if needed
+func (vm *VolumeManager) append(key string, data io.Reader, size int64, checksum uint32) (*Volume, *Needle, error) {
+	for {
+		// Find a volume with space or create new one
+		vm.mu.Lock()
+		vol, err := vm.getWritableVolume()
+		vm.mu.Unlock()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// The volume may be sealed before the append gets to it; nothing has
+		// been written then, so try another
+		needle, err := vol.append(key, data, size, checksum)
+		if errors.Is(err, errVolumeSealed) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return vol, needle, nil
+	}
+}
+
+// acquire returns an open volume by ID for reading. The caller must call
+// release when done, so a removed volume is closed only once unused.
+func (vm *VolumeManager) acquire(id uint64) (*Volume, bool) {
+	vm.mu.RLock()
+	defer vm.mu.RUnlock()
+
+	vol, ok := vm.volumes[id]
+	if ok {
+		vol.refs.Add(1)
+	}
+	return vol, ok
+}
+
+// release ends a read started with acquire
+func (v *Volume) release() {
+	v.refs.Done()
+}
+
+// seal stops a volume from accepting needles and waits for appends in
+// progress, so its size no longer changes
+func (vm *VolumeManager) seal(id uint64) (*Volume, error) {
 	vm.mu.Lock()
 	defer vm.mu.Unlock()
 
-	// Find a volume with space or create new one
-	vol := vm.getWritableVolume()
+	vol, ok := vm.volumes[id]
+	if !ok {
+		return nil, fmt.Errorf("volume %d not found", id)
+	}
 
-	offset, err := vol.Write(key, data)
-	if err != nil {
-		return 0, 0, err
+	vol.mu.Lock()
+	defer vol.mu.Unlock()
+	vol.sealed = true
+	if err := vol.writer.Flush(); err != nil {
+		return nil, err
 	}
+	return vol, nil
+}
 
-	return vol.id, offset, nil
+// unseal lets a sealed volume accept needles again
+func (vm *VolumeManager) unseal(id uint64) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	if vol, ok := vm.volumes[id]; ok {
+		vol.mu.Lock()
+		vol.sealed = false
+		vol.mu.Unlock()
+	}
+}
+
+// remove drops a volume and deletes its file once its last reader is done
+func (vm *VolumeManager) remove(id uint64) {
+	vm.mu.Lock()
+	vol, ok := vm.volumes[id]
+	delete(vm.volumes, id)
+	vm.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	go func() {
+		vol.refs.Wait()
+		vol.Close()
+		os.Remove(vol.path)
+	}()
+}
+
+// liveBytes returns the bytes held by referenced needles of a volume
+func (vm *VolumeManager) liveBytes(id uint64) int64 {
+	vm.mu.RLock()
+	defer vm.mu.RUnlock()
+
+	vol, ok := vm.volumes[id]
+	if !ok {
+		return 0
+	}
+	vol.mu.RLock()
+	defer vol.mu.RUnlock()
+	return vol.live
+}
+
+// setLive records the bytes held by referenced needles of a volume, as
+// tracked by an external index
+func (vm *VolumeManager) setLive(id uint64, live int64) {
+	vm.mu.RLock()
+	defer vm.mu.RUnlock()
+
+	if vol, ok := vm.volumes[id]; ok {
+		vol.mu.Lock()
+		vol.live = live
+		vol.mu.Unlock()
+	}
 }
 
 // Read retrieves data from volumes
@@ -338,26 +647,31 @@ func (vm *VolumeManager) createVolume(id uint64) (*Volume, error) {
 		path:   path,
 		file:   file,
 		writer: bufio.NewWriter(file),
-		index:  NewIndex(),
 		size:   0,
 	}
+	if !vm.externalIndex {
+		vol.index = NewIndex()
+	}
 
 	return vol, nil
 }
 
 // getWritableVolume returns a volume that can accept new data
-func (vm *VolumeManager) getWritableVolume() *Volume {
+func (vm *VolumeManager) getWritableVolume() (*Volume, error) {
 	for _, vol := range vm.volumes {
-		if vol.size < vm.maxSize {
-			return vol
+		if !vol.sealed && vol.size < vm.maxSize {
+			return vol, nil
 		}
 	}
 
 	// Create new volume
+	vol, err := vm.createVolume(vm.currentID + 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create volume: %w", err)
+	}
 	vm.currentID++
-	vol, _ := vm.createVolume(vm.currentID)
 	vm.volumes[vm.currentID] = vol
-	return vol
+	return vol, nil
 }
 
 // Close closes all volumes
@@ -380,17 +694,26 @@ func (vm *VolumeManager) Stats() map[string]interface{} {
 	stats["volume_count"] = len(vm.volumes)
 	stats["current_volume"] = vm.currentID
 
-	var totalSize int64
+	var totalSize, totalGarbage int64
 	var totalObjects int
 	for id, vol := range vm.volumes {
 		totalSize += vol.size
-		totalObjects += len(vol.index.entries)
+		totalGarbage += vol.size - vol.live
 		stats[fmt.Sprintf("volume_%d_size", id)] = vol.size
-		stats[fmt.Sprintf("volume_%d_objects", id)] = len(vol.index.entries)
+		stats[fmt.Sprintf("volume_%d_garbage_ratio", id)] = vol.garbageRatio()
+		if vol.index != nil {
+			totalObjects += len(vol.index.entries)
+			stats[fmt.Sprintf("volume_%d_objects", id)] = len(vol.index.entries)
+		}
 	}
 
 	stats["total_size"] = totalSize
 	stats["total_objects"] = totalObjects
+	stats["garbage_bytes"] = totalGarbage
+	stats["garbage_ratio"] = 0.0
+	if totalSize > 0 {
+		stats["garbage_ratio"] = float64(totalGarbage) / float64(totalSize)
+	}
 
 	return stats
 }
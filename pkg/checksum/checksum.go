@@ -37,6 +37,11 @@ func CRC32() hash.Hash {
 	return crc32.NewIEEE()
 }
 
+// CRC32C creates a CRC32 hash using the Castagnoli polynomial
+func CRC32C() hash.Hash {
+	return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+}
+
 // HashBytes calculates hash of data using the specified algorithm
 func HashBytes(data []byte, algorithm string) (string, error) {
 	var h hash.Hash
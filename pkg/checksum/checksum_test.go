@@ -90,6 +90,16 @@ func TestCRC32(t *testing.T) {
 	}
 }
 
+func TestCRC32C(t *testing.T) {
+	h := CRC32C()
+	h.Write([]byte("123456789"))
+
+	// Check value of CRC-32C for the standard test input
+	if got := h.(hash.Hash32).Sum32(); got != 0xe3069283 {
+		t.Errorf("CRC32C sum = %#x, want 0xe3069283", got)
+	}
+}
+
 func TestHashBytes(t *testing.T) {
 	data := []byte("test data for hashing")
 
@@ -52,6 +52,38 @@ type ListObjectsV2Output struct {
 	NextContinuationToken string   `xml:"NextContinuationToken"`
 }
 
+// ListBucketResult is the response for ListObjects (V1)
+type ListBucketResult struct {
+	XMLName        struct{}       `xml:"ListBucketResult"`
+	Name           string         `xml:"Name"`
+	Prefix         string         `xml:"Prefix"`
+	Marker         string         `xml:"Marker"`
+	NextMarker     string         `xml:"NextMarker,omitempty"`
+	Delimiter      string         `xml:"Delimiter,omitempty"`
+	MaxKeys        int            `xml:"MaxKeys"`
+	EncodingType   string         `xml:"EncodingType,omitempty"`
+	IsTruncated    bool           `xml:"IsTruncated"`
+	Contents       []Object       `xml:"Contents"`
+	CommonPrefixes []CommonPrefix `xml:"CommonPrefixes"`
+}
+
+// ListBucketResultV2 is the response for ListObjectsV2
+type ListBucketResultV2 struct {
+	XMLName               struct{}       `xml:"ListBucketResult"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	KeyCount              int            `xml:"KeyCount"`
+	EncodingType          string         `xml:"EncodingType,omitempty"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	Contents              []Object       `xml:"Contents"`
+	CommonPrefixes        []CommonPrefix `xml:"CommonPrefixes"`
+}
+
 // Object represents an object
 type Object struct {
 	Key          string `xml:"Key"`
@@ -62,6 +94,42 @@ type Object struct {
 	Owner        *Owner `xml:"Owner"`
 }
 
+// ListVersionsResult is the response for ListObjectVersions
+type ListVersionsResult struct {
+	XMLName             string          `xml:"ListVersionsResult"`
+	xmlns               string          `xml:"xmlns,attr"`
+	Name                string          `xml:"Name"`
+	Prefix              string          `xml:"Prefix"`
+	KeyMarker           string          `xml:"KeyMarker"`
+	VersionIDMarker     string          `xml:"VersionIdMarker"`
+	NextKeyMarker       string          `xml:"NextKeyMarker,omitempty"`
+	NextVersionIDMarker string          `xml:"NextVersionIdMarker,omitempty"`
+	Delimiter           string          `xml:"Delimiter,omitempty"`
+	MaxKeys             int             `xml:"MaxKeys"`
+	IsTruncated         bool            `xml:"IsTruncated"`
+	Versions            []ObjectVersion `xml:"Version"`
+	CommonPrefixes      []CommonPrefix  `xml:"CommonPrefixes"`
+}
+
+// ObjectVersion is a Version or DeleteMarker entry of ListVersionsResult;
+// XMLName selects which, so both kinds stay in key order
+type ObjectVersion struct {
+	XMLName      xml.Name
+	Key          string `xml:"Key"`
+	VersionID    string `xml:"VersionId"`
+	IsLatest     bool   `xml:"IsLatest"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag,omitempty"`
+	Size         *int64 `xml:"Size,omitempty"`
+	StorageClass string `xml:"StorageClass,omitempty"`
+	Owner        *Owner `xml:"Owner"`
+}
+
+// CommonPrefix is a rolled up key prefix in a listing
+type CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
 // InitiateMultipartUploadResult is the response for InitiateMultipartUpload
 type InitiateMultipartUploadResult struct {
 	XMLName  string `xml:"InitiateMultipartUploadResult"`
@@ -80,7 +148,9 @@ type CompleteMultipartUploadInput struct {
 // Part represents a part in CompleteMultipartUpload
 type Part struct {
 	PartNumber int    `xml:"PartNumber"`
-	ETag       string `xml:"ETag"`
+	ETag       string `xml:"ETag,omitempty"`
+	Size       int64  `xml:"Size,omitempty"`
+	Checksum
 }
 
 // CompleteMultipartUploadResult is the response for CompleteMultipartUpload
@@ -91,6 +161,17 @@ type CompleteMultipartUploadResult struct {
 	Key       string `xml:"Key"`
 	ETag      string `xml:"ETag"`
 	RequestID string `xml:"RequestId"`
+	Checksum
+}
+
+// PostResponse is the response for a browser POST upload with
+// success_action_status 201
+type PostResponse struct {
+	XMLName  string `xml:"PostResponse"`
+	Location string `xml:"Location"`
+	Bucket   string `xml:"Bucket"`
+	Key      string `xml:"Key"`
+	ETag     string `xml:"ETag"`
 }
 
 // ListPartsOutput is the response for ListParts
@@ -140,6 +221,14 @@ type CopyObjectResult struct {
 	ServerSideEncryption string `xml:"ServerSideEncryption,omitempty"`
 }
 
+// CopyPartResult is the response for UploadPartCopy
+type CopyPartResult struct {
+	XMLName      string `xml:"CopyPartResult"`
+	xmlns        string `xml:"xmlns,attr"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+}
+
 // CreateBucketConfiguration is the request for CreateBucket
 type CreateBucketConfiguration struct {
 	XMLName      string `xml:"CreateBucketConfiguration"`
@@ -186,17 +275,17 @@ type PutBucketLifecycleInput struct {
 
 // GetObjectAttributesOutput is the response for GetObjectAttributes
 type GetObjectAttributesOutput struct {
-	XMLName             string             `xml:"GetObjectAttributesOutput"`
-	xmlns               string             `xml:"xmlns,attr"`
-	ETag                string             `xml:"ETag"`
-	Checksum            *Checksum          `xml:"Checksum"`
-	ObjectParts         *ObjectParts       `xml:"ObjectParts,omitempty"`
-	StorageClass        string             `xml:"StorageClass"`
-	LastModified        string             `xml:"LastModified"`
-	ObjectSize          string             `xml:"ObjectSize"`
-	VersionId           string             `xml:"VersionId,omitempty"`
-	RequestCharged      string             `xml:"RequestCharged,omitempty"`
-	ServerSideEncryption string           `xml:"ServerSideEncryption,omitempty"`
+	XMLName              string       `xml:"GetObjectAttributesOutput"`
+	xmlns                string       `xml:"xmlns,attr"`
+	ETag                 string       `xml:"ETag,omitempty"`
+	Checksum             *Checksum    `xml:"Checksum"`
+	ObjectParts          *ObjectParts `xml:"ObjectParts,omitempty"`
+	StorageClass         string       `xml:"StorageClass,omitempty"`
+	LastModified         string       `xml:"LastModified,omitempty"`
+	ObjectSize           string       `xml:"ObjectSize,omitempty"`
+	VersionId            string       `xml:"VersionId,omitempty"`
+	RequestCharged       string       `xml:"RequestCharged,omitempty"`
+	ServerSideEncryption string       `xml:"ServerSideEncryption,omitempty"`
 }
 
 // Checksum represents checksum information
@@ -204,13 +293,71 @@ type Checksum struct {
 	ChecksumSHA1   string `xml:"ChecksumSHA1,omitempty"`
 	ChecksumSHA256 string `xml:"ChecksumSHA256,omitempty"`
 	ChecksumCRC32  string `xml:"ChecksumCRC32,omitempty"`
+	ChecksumCRC32C string `xml:"ChecksumCRC32C,omitempty"`
+	ChecksumType   string `xml:"ChecksumType,omitempty"` // COMPOSITE or FULL_OBJECT
 }
 
 // ObjectParts represents object parts information
 type ObjectParts struct {
-	TotalPartsCount int     `xml:"TotalPartsCount"`
-	IsTruncated     bool    `xml:"IsTruncated"`
-	Parts           []Part  `xml:"Part"`
+	TotalPartsCount      int    `xml:"TotalPartsCount"`
+	PartNumberMarker     int    `xml:"PartNumberMarker"`
+	NextPartNumberMarker int    `xml:"NextPartNumberMarker,omitempty"`
+	MaxParts             int    `xml:"MaxParts"`
+	IsTruncated          bool   `xml:"IsTruncated"`
+	Parts                []Part `xml:"Part"`
+}
+
+// ObjectLockConfiguration is the body of PutObjectLockConfiguration and
+// GetObjectLockConfiguration
+type ObjectLockConfiguration struct {
+	XMLName           xml.Name        `xml:"ObjectLockConfiguration"`
+	ObjectLockEnabled string          `xml:"ObjectLockEnabled,omitempty"` // Enabled
+	Rule              *ObjectLockRule `xml:"Rule,omitempty"`
+}
+
+// ObjectLockRule holds the default retention of a bucket
+type ObjectLockRule struct {
+	DefaultRetention DefaultRetention `xml:"DefaultRetention"`
+}
+
+// DefaultRetention is the retention given to new objects: Mode for a
+// period of either Days or Years
+type DefaultRetention struct {
+	Mode  string `xml:"Mode"` // GOVERNANCE or COMPLIANCE
+	Days  int    `xml:"Days,omitempty"`
+	Years int    `xml:"Years,omitempty"`
+}
+
+// ObjectRetention is the body of PutObjectRetention and GetObjectRetention
+type ObjectRetention struct {
+	XMLName         xml.Name `xml:"Retention"`
+	Mode            string   `xml:"Mode,omitempty"`            // GOVERNANCE or COMPLIANCE
+	RetainUntilDate string   `xml:"RetainUntilDate,omitempty"` // ISO 8601
+}
+
+// ObjectLegalHold is the body of PutObjectLegalHold and GetObjectLegalHold
+type ObjectLegalHold struct {
+	XMLName xml.Name `xml:"LegalHold"`
+	Status  string   `xml:"Status"` // ON or OFF
+}
+
+// ServerSideEncryptionConfiguration is the body of PutBucketEncryption and
+// GetBucketEncryption
+type ServerSideEncryptionConfiguration struct {
+	XMLName xml.Name                   `xml:"ServerSideEncryptionConfiguration"`
+	Rules   []ServerSideEncryptionRule `xml:"Rule"`
+}
+
+// ServerSideEncryptionRule holds the default encryption of a bucket
+type ServerSideEncryptionRule struct {
+	ApplyServerSideEncryptionByDefault ServerSideEncryptionByDefault `xml:"ApplyServerSideEncryptionByDefault"`
+}
+
+// ServerSideEncryptionByDefault is the encryption applied to objects
+// written without encryption headers
+type ServerSideEncryptionByDefault struct {
+	SSEAlgorithm   string `xml:"SSEAlgorithm"` // AES256
+	KMSMasterKeyID string `xml:"KMSMasterKeyID,omitempty"`
 }
 
 // SelectObjectContentRequest is the request for SelectObjectContent
@@ -220,21 +367,33 @@ type SelectObjectContentRequest struct {
 	ExpressionType string `xml:"ExpressionType"`
 	InputSerialization  InputSerialization  `xml:"InputSerialization"`
 	OutputSerialization OutputSerialization `xml:"OutputSerialization"`
-	RequestProgress RequestProgress `xml:"RequestProgress,omitempty"`
+	RequestProgress     RequestProgress     `xml:"RequestProgress,omitempty"`
+	ScanRange           *ScanRange          `xml:"ScanRange,omitempty"`
+}
+
+// ScanRange defines the byte range of the object to scan; either bound may
+// be left out
+type ScanRange struct {
+	Start *int64 `xml:"Start"`
+	End   *int64 `xml:"End"`
 }
 
 // InputSerialization defines input serialization
 type InputSerialization struct {
-	CSV    *CSVInput  `xml:"CSV,omitempty"`
-	JSON   *JSONInput `xml:"JSON,omitempty"`
+	CSV             *CSVInput  `xml:"CSV,omitempty"`
+	JSON            *JSONInput `xml:"JSON,omitempty"`
+	CompressionType string     `xml:"CompressionType,omitempty"`
 }
 
 // CSVInput defines CSV input serialization
 type CSVInput struct {
-	FileHeaderInfo string `xml:"FileHeaderInfo"`
-	RecordDelimiter string `xml:"RecordDelimiter,omitempty"`
-	FieldDelimiter string `xml:"FieldDelimiter,omitempty"`
-	QuoteCharacter string `xml:"QuoteCharacter,omitempty"`
+	FileHeaderInfo             string `xml:"FileHeaderInfo"`
+	RecordDelimiter            string `xml:"RecordDelimiter,omitempty"`
+	FieldDelimiter             string `xml:"FieldDelimiter,omitempty"`
+	QuoteCharacter             string `xml:"QuoteCharacter,omitempty"`
+	QuoteEscapeCharacter       string `xml:"QuoteEscapeCharacter,omitempty"`
+	Comments                   string `xml:"Comments,omitempty"`
+	AllowQuotedRecordDelimiter bool   `xml:"AllowQuotedRecordDelimiter,omitempty"`
 }
 
 // JSONInput defines JSON input serialization
@@ -253,6 +412,8 @@ type CSVOutput struct {
 	RecordDelimiter string `xml:"RecordDelimiter,omitempty"`
 	FieldDelimiter string `xml:"FieldDelimiter,omitempty"`
 	QuoteCharacter string `xml:"QuoteCharacter,omitempty"`
+	QuoteEscapeCharacter string `xml:"QuoteEscapeCharacter,omitempty"`
+	QuoteFields          string `xml:"QuoteFields,omitempty"`
 }
 
 // JSONOutput defines JSON output serialization